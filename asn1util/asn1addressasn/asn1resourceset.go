@@ -0,0 +1,521 @@
+package asn1addressasn
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// https://tools.ietf.org/html/rfc3779#section-2.2.3.6
+// resource sets are always canonical: ranges are sorted, not overlapped and not adjacent.
+// inherit is kept as flag, use ResolveInherit to replace it by resources of issuer
+
+// IPResourceRange is [Min, Max], Min and Max are in same family
+type IPResourceRange struct {
+	Min netip.Addr `json:"min"`
+	Max netip.Addr `json:"max"`
+}
+
+// IPResourceSet: ipv4 ranges are before ipv6 ranges
+type IPResourceSet struct {
+	Ranges      []IPResourceRange `json:"ranges"`
+	InheritIpv4 bool              `json:"inheritIpv4"`
+	InheritIpv6 bool              `json:"inheritIpv6"`
+}
+
+// ASResourceRange is [Min, Max]
+type ASResourceRange struct {
+	Min uint32 `json:"min"`
+	Max uint32 `json:"max"`
+}
+
+// ASResourceSet is asnum of ASIdentifiers, rdi is not used in rpki
+type ASResourceSet struct {
+	Ranges  []ASResourceRange `json:"ranges"`
+	Inherit bool              `json:"inherit"`
+}
+
+// NewIPResourceSet gets set from IPAddresses of RPKICertificate, IPAddressNull is inherit
+func NewIPResourceSet(ips []IPCertificateInformation) (*IPResourceSet, error) {
+	s := &IPResourceSet{}
+	ranges := make([]resourceRange[netip.Addr], 0, len(ips))
+	for _, ip := range ips {
+		afi := ip.GetAfi()
+		if afi != 1 && afi != 2 {
+			return nil, errors.New("afi of ip is invalid: " + ip.String())
+		}
+		if _, ok := ip.(*IPAddressNull); ok {
+			if afi == 1 {
+				s.InheritIpv4 = true
+			} else {
+				s.InheritIpv6 = true
+			}
+			continue
+		}
+		min, max, _ := ip.GetRange()
+		minAddr, ok1 := ipToAddr(min, afi)
+		maxAddr, ok2 := ipToAddr(max, afi)
+		if !ok1 || !ok2 || maxAddr.Less(minAddr) {
+			return nil, errors.New("range of ip is invalid: " + ip.String())
+		}
+		ranges = append(ranges, resourceRange[netip.Addr]{min: minAddr, max: maxAddr})
+	}
+	s.setRanges(normalizeRanges(ranges, ipRangeOps))
+	return s, nil
+}
+
+// ParseIPResourceSet parses prefix such as 10.0.0.0/8, or range such as 10.0.0.1-10.0.0.9
+func ParseIPResourceSet(ips []string) (*IPResourceSet, error) {
+	ranges := make([]resourceRange[netip.Addr], 0, len(ips))
+	for _, ip := range ips {
+		ip = strings.TrimSpace(ip)
+		if min, max, ok := strings.Cut(ip, "-"); ok {
+			minAddr, err1 := netip.ParseAddr(strings.TrimSpace(min))
+			maxAddr, err2 := netip.ParseAddr(strings.TrimSpace(max))
+			if err1 != nil || err2 != nil || minAddr.Is4() != maxAddr.Is4() || maxAddr.Less(minAddr) {
+				return nil, errors.New("ip range is invalid: " + ip)
+			}
+			ranges = append(ranges, resourceRange[netip.Addr]{min: minAddr.Unmap(), max: maxAddr.Unmap()})
+			continue
+		}
+		prefix, err := netip.ParsePrefix(ip)
+		if err != nil {
+			return nil, errors.New("ip prefix is invalid: " + ip)
+		}
+		prefix = prefix.Masked()
+		ranges = append(ranges, resourceRange[netip.Addr]{min: prefix.Addr(), max: getPrefixLastAddr(prefix)})
+	}
+	s := &IPResourceSet{}
+	s.setRanges(normalizeRanges(ranges, ipRangeOps))
+	return s, nil
+}
+
+// IsEmpty: no range and no inherit
+func (s *IPResourceSet) IsEmpty() bool {
+	return len(s.Ranges) == 0 && !s.InheritIpv4 && !s.InheritIpv6
+}
+
+// Union: inherit is kept when it is in s or o
+func (s *IPResourceSet) Union(o *IPResourceSet) *IPResourceSet {
+	r := &IPResourceSet{InheritIpv4: s.InheritIpv4 || o.InheritIpv4, InheritIpv6: s.InheritIpv6 || o.InheritIpv6}
+	r.setRanges(normalizeRanges(append(s.getRanges(), o.getRanges()...), ipRangeOps))
+	return r
+}
+
+// Intersection: inherit is kept when it is in both s and o
+func (s *IPResourceSet) Intersection(o *IPResourceSet) *IPResourceSet {
+	r := &IPResourceSet{InheritIpv4: s.InheritIpv4 && o.InheritIpv4, InheritIpv6: s.InheritIpv6 && o.InheritIpv6}
+	r.setRanges(intersectRanges(s.getRanges(), o.getRanges(), ipRangeOps))
+	return r
+}
+
+// Subtract gets resources in s but not in o, inherit is kept when it is in s but not in o.
+// child.Subtract(issuer) is over-claimed resources of child
+func (s *IPResourceSet) Subtract(o *IPResourceSet) *IPResourceSet {
+	r := &IPResourceSet{InheritIpv4: s.InheritIpv4 && !o.InheritIpv4, InheritIpv6: s.InheritIpv6 && !o.InheritIpv6}
+	r.setRanges(subtractRanges(s.getRanges(), o.getRanges(), ipRangeOps))
+	return r
+}
+
+// Contains: all ranges of o are in s, inherit of o is in s by definition
+func (s *IPResourceSet) Contains(o *IPResourceSet) bool {
+	return len(subtractRanges(o.getRanges(), s.getRanges(), ipRangeOps)) == 0
+}
+
+// ContainsPrefix: prefix is in ranges of s
+func (s *IPResourceSet) ContainsPrefix(prefix netip.Prefix) bool {
+	prefix = prefix.Masked()
+	return s.Contains(&IPResourceSet{Ranges: []IPResourceRange{{Min: prefix.Addr(), Max: getPrefixLastAddr(prefix)}}})
+}
+
+// ResolveInherit replaces inherit of each family by ranges of same family in parent
+func (s *IPResourceSet) ResolveInherit(parent *IPResourceSet) *IPResourceSet {
+	ranges := s.getRanges()
+	for _, r := range parent.getRanges() {
+		if (r.min.Is4() && s.InheritIpv4) || (r.min.Is6() && s.InheritIpv6) {
+			ranges = append(ranges, r)
+		}
+	}
+	r := &IPResourceSet{
+		InheritIpv4: s.InheritIpv4 && parent.InheritIpv4,
+		InheritIpv6: s.InheritIpv6 && parent.InheritIpv6,
+	}
+	r.setRanges(normalizeRanges(ranges, ipRangeOps))
+	return r
+}
+
+// Prefixes splits ranges to minimal prefixes
+func (s *IPResourceSet) Prefixes() []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(s.Ranges))
+	for _, r := range s.Ranges {
+		prefixes = append(prefixes, r.Prefixes()...)
+	}
+	return prefixes
+}
+
+// ToIPCertificateInformations gets IPNet when range is exact prefix, or IPAddressRange, and IPAddressNull for inherit
+func (s *IPResourceSet) ToIPCertificateInformations() []IPCertificateInformation {
+	ips := make([]IPCertificateInformation, 0, len(s.Ranges)+2)
+	for _, r := range s.Ranges {
+		if prefix, ok := r.getPrefix(); ok {
+			ips = append(ips, &IPNet{IPNet: &net.IPNet{
+				IP:   net.IP(prefix.Addr().AsSlice()),
+				Mask: net.CIDRMask(prefix.Bits(), prefix.Addr().BitLen()),
+			}})
+			continue
+		}
+		// IPToBitString needs 16 bytes
+		min, max := r.Min.As16(), r.Max.As16()
+		ips = append(ips, &IPAddressRange{Min: net.IP(min[:]), Max: net.IP(max[:])})
+	}
+	if s.InheritIpv4 {
+		ips = append(ips, &IPAddressNull{Family: 1})
+	}
+	if s.InheritIpv6 {
+		ips = append(ips, &IPAddressNull{Family: 2})
+	}
+	return ips
+}
+
+// String such as "10.0.0.0/8, 11.0.0.1-11.0.0.9, inherit(ipv6)"
+func (s *IPResourceSet) String() string {
+	strs := make([]string, 0, len(s.Ranges)+2)
+	for _, r := range s.Ranges {
+		strs = append(strs, r.String())
+	}
+	if s.InheritIpv4 {
+		strs = append(strs, "inherit(ipv4)")
+	}
+	if s.InheritIpv6 {
+		strs = append(strs, "inherit(ipv6)")
+	}
+	return strings.Join(strs, ", ")
+}
+
+// String is prefix when range is exact prefix, or min-max
+func (r IPResourceRange) String() string {
+	if prefix, ok := r.getPrefix(); ok {
+		return prefix.String()
+	}
+	return r.Min.String() + "-" + r.Max.String()
+}
+
+// Prefixes splits range to minimal prefixes
+func (r IPResourceRange) Prefixes() []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0)
+	for min := r.Min; min.IsValid() && !r.Max.Less(min); {
+		// shortest prefix which starts at min and ends before max
+		bits := min.BitLen()
+		for bits > 0 {
+			prefix := netip.PrefixFrom(min, bits-1).Masked()
+			if prefix.Addr() != min || r.Max.Less(getPrefixLastAddr(prefix)) {
+				break
+			}
+			bits--
+		}
+		prefix := netip.PrefixFrom(min, bits)
+		prefixes = append(prefixes, prefix)
+		min = getPrefixLastAddr(prefix).Next()
+	}
+	return prefixes
+}
+
+func (r IPResourceRange) getPrefix() (netip.Prefix, bool) {
+	prefixes := r.Prefixes()
+	if len(prefixes) != 1 {
+		return netip.Prefix{}, false
+	}
+	return prefixes[0], true
+}
+
+func (s *IPResourceSet) getRanges() []resourceRange[netip.Addr] {
+	ranges := make([]resourceRange[netip.Addr], 0, len(s.Ranges))
+	for _, r := range s.Ranges {
+		ranges = append(ranges, resourceRange[netip.Addr]{min: r.Min, max: r.Max})
+	}
+	return ranges
+}
+
+func (s *IPResourceSet) setRanges(ranges []resourceRange[netip.Addr]) {
+	s.Ranges = make([]IPResourceRange, 0, len(ranges))
+	for _, r := range ranges {
+		s.Ranges = append(s.Ranges, IPResourceRange{Min: r.min, Max: r.max})
+	}
+}
+
+// NewASResourceSet gets set from ASNums of RPKICertificate, ASNull is inherit
+func NewASResourceSet(asns []ASNCertificateInformation) (*ASResourceSet, error) {
+	s := &ASResourceSet{}
+	ranges := make([]resourceRange[uint32], 0, len(asns))
+	for _, asn := range asns {
+		if _, ok := asn.(*ASNull); ok {
+			s.Inherit = true
+			continue
+		}
+		min, max, _ := asn.GetRange()
+		if min < 0 || max > math.MaxUint32 || min > max {
+			return nil, errors.New("range of asn is invalid: " + asn.String())
+		}
+		ranges = append(ranges, resourceRange[uint32]{min: uint32(min), max: uint32(max)})
+	}
+	s.setRanges(normalizeRanges(ranges, asRangeOps))
+	return s, nil
+}
+
+// ParseASResourceSet parses asn such as 65000, or range such as 65000-65010
+func ParseASResourceSet(asns []string) (*ASResourceSet, error) {
+	ranges := make([]resourceRange[uint32], 0, len(asns))
+	for _, asn := range asns {
+		min, max, ok := strings.Cut(strings.TrimSpace(asn), "-")
+		if !ok {
+			max = min
+		}
+		minAsn, err1 := strconv.ParseUint(strings.TrimSpace(min), 10, 32)
+		maxAsn, err2 := strconv.ParseUint(strings.TrimSpace(max), 10, 32)
+		if err1 != nil || err2 != nil || minAsn > maxAsn {
+			return nil, errors.New("asn is invalid: " + asn)
+		}
+		ranges = append(ranges, resourceRange[uint32]{min: uint32(minAsn), max: uint32(maxAsn)})
+	}
+	s := &ASResourceSet{}
+	s.setRanges(normalizeRanges(ranges, asRangeOps))
+	return s, nil
+}
+
+// IsEmpty: no range and no inherit
+func (s *ASResourceSet) IsEmpty() bool {
+	return len(s.Ranges) == 0 && !s.Inherit
+}
+
+// Union: inherit is kept when it is in s or o
+func (s *ASResourceSet) Union(o *ASResourceSet) *ASResourceSet {
+	r := &ASResourceSet{Inherit: s.Inherit || o.Inherit}
+	r.setRanges(normalizeRanges(append(s.getRanges(), o.getRanges()...), asRangeOps))
+	return r
+}
+
+// Intersection: inherit is kept when it is in both s and o
+func (s *ASResourceSet) Intersection(o *ASResourceSet) *ASResourceSet {
+	r := &ASResourceSet{Inherit: s.Inherit && o.Inherit}
+	r.setRanges(intersectRanges(s.getRanges(), o.getRanges(), asRangeOps))
+	return r
+}
+
+// Subtract gets resources in s but not in o, inherit is kept when it is in s but not in o.
+// child.Subtract(issuer) is over-claimed resources of child
+func (s *ASResourceSet) Subtract(o *ASResourceSet) *ASResourceSet {
+	r := &ASResourceSet{Inherit: s.Inherit && !o.Inherit}
+	r.setRanges(subtractRanges(s.getRanges(), o.getRanges(), asRangeOps))
+	return r
+}
+
+// Contains: all ranges of o are in s, inherit of o is in s by definition
+func (s *ASResourceSet) Contains(o *ASResourceSet) bool {
+	return len(subtractRanges(o.getRanges(), s.getRanges(), asRangeOps)) == 0
+}
+
+// ContainsAsn: asn is in ranges of s
+func (s *ASResourceSet) ContainsAsn(asn uint32) bool {
+	return s.Contains(&ASResourceSet{Ranges: []ASResourceRange{{Min: asn, Max: asn}}})
+}
+
+// ResolveInherit replaces inherit by ranges of parent
+func (s *ASResourceSet) ResolveInherit(parent *ASResourceSet) *ASResourceSet {
+	if !s.Inherit {
+		return s.Union(&ASResourceSet{})
+	}
+	r := &ASResourceSet{Inherit: parent.Inherit}
+	r.setRanges(normalizeRanges(append(s.getRanges(), parent.getRanges()...), asRangeOps))
+	return r
+}
+
+// ToASNCertificateInformations gets ASN or ASNRange, and ASNull for inherit
+func (s *ASResourceSet) ToASNCertificateInformations() []ASNCertificateInformation {
+	asns := make([]ASNCertificateInformation, 0, len(s.Ranges)+1)
+	if s.Inherit {
+		// ASIdentifierChoice is inherit or asIdsOrRanges
+		return append(asns, &ASNull{})
+	}
+	for _, r := range s.Ranges {
+		if r.Min == r.Max {
+			asns = append(asns, &ASN{ASN: int(r.Min)})
+		} else {
+			asns = append(asns, &ASNRange{Min: int(r.Min), Max: int(r.Max)})
+		}
+	}
+	return asns
+}
+
+// String such as "65000, 65100-65200, inherit"
+func (s *ASResourceSet) String() string {
+	strs := make([]string, 0, len(s.Ranges)+1)
+	for _, r := range s.Ranges {
+		strs = append(strs, r.String())
+	}
+	if s.Inherit {
+		strs = append(strs, "inherit")
+	}
+	return strings.Join(strs, ", ")
+}
+
+func (r ASResourceRange) String() string {
+	if r.Min == r.Max {
+		return strconv.FormatUint(uint64(r.Min), 10)
+	}
+	return strconv.FormatUint(uint64(r.Min), 10) + "-" + strconv.FormatUint(uint64(r.Max), 10)
+}
+
+func (s *ASResourceSet) getRanges() []resourceRange[uint32] {
+	ranges := make([]resourceRange[uint32], 0, len(s.Ranges))
+	for _, r := range s.Ranges {
+		ranges = append(ranges, resourceRange[uint32]{min: r.Min, max: r.Max})
+	}
+	return ranges
+}
+
+func (s *ASResourceSet) setRanges(ranges []resourceRange[uint32]) {
+	s.Ranges = make([]ASResourceRange, 0, len(ranges))
+	for _, r := range ranges {
+		s.Ranges = append(s.Ranges, ASResourceRange{Min: r.min, Max: r.max})
+	}
+}
+
+type resourceRange[T any] struct {
+	min T
+	max T
+}
+
+type resourceRangeOps[T any] struct {
+	compare func(a, b T) int
+	// next and prev are false at the end of address space (of family)
+	next func(a T) (T, bool)
+	prev func(a T) (T, bool)
+}
+
+var ipRangeOps = resourceRangeOps[netip.Addr]{
+	// ipv4 is less than ipv6
+	compare: func(a, b netip.Addr) int { return a.Compare(b) },
+	next: func(a netip.Addr) (netip.Addr, bool) {
+		n := a.Next()
+		return n, n.IsValid()
+	},
+	prev: func(a netip.Addr) (netip.Addr, bool) {
+		p := a.Prev()
+		return p, p.IsValid()
+	},
+}
+
+var asRangeOps = resourceRangeOps[uint32]{
+	compare: func(a, b uint32) int {
+		if a < b {
+			return -1
+		} else if a > b {
+			return 1
+		}
+		return 0
+	},
+	next: func(a uint32) (uint32, bool) { return a + 1, a < math.MaxUint32 },
+	prev: func(a uint32) (uint32, bool) { return a - 1, a > 0 },
+}
+
+// normalizeRanges sorts ranges, and merges overlapped and adjacent ranges
+func normalizeRanges[T any](ranges []resourceRange[T], ops resourceRangeOps[T]) []resourceRange[T] {
+	sorted := make([]resourceRange[T], len(ranges))
+	copy(sorted, ranges)
+	sort.Slice(sorted, func(i, j int) bool {
+		return ops.compare(sorted[i].min, sorted[j].min) < 0
+	})
+	merged := make([]resourceRange[T], 0, len(sorted))
+	for _, r := range sorted {
+		if len(merged) > 0 {
+			last := &merged[len(merged)-1]
+			next, ok := ops.next(last.max)
+			if ops.compare(r.min, last.max) <= 0 || (ok && ops.compare(r.min, next) == 0) {
+				if ops.compare(r.max, last.max) > 0 {
+					last.max = r.max
+				}
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// intersectRanges: a and b are normalized
+func intersectRanges[T any](a, b []resourceRange[T], ops resourceRangeOps[T]) []resourceRange[T] {
+	result := make([]resourceRange[T], 0)
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		min, max := a[i].min, a[i].max
+		if ops.compare(b[j].min, min) > 0 {
+			min = b[j].min
+		}
+		if ops.compare(b[j].max, max) < 0 {
+			max = b[j].max
+		}
+		if ops.compare(min, max) <= 0 {
+			result = append(result, resourceRange[T]{min: min, max: max})
+		}
+		if ops.compare(a[i].max, b[j].max) < 0 {
+			i++
+		} else {
+			j++
+		}
+	}
+	return result
+}
+
+// subtractRanges: a and b are normalized
+func subtractRanges[T any](a, b []resourceRange[T], ops resourceRangeOps[T]) []resourceRange[T] {
+	result := make([]resourceRange[T], 0)
+	j := 0
+	for _, r := range a {
+		min, empty := r.min, false
+		for ; j < len(b) && ops.compare(b[j].max, min) < 0; j++ {
+		}
+		for k := j; k < len(b) && ops.compare(b[k].min, r.max) <= 0; k++ {
+			if ops.compare(b[k].min, min) > 0 {
+				prev, _ := ops.prev(b[k].min)
+				result = append(result, resourceRange[T]{min: min, max: prev})
+			}
+			next, ok := ops.next(b[k].max)
+			if !ok || ops.compare(b[k].max, r.max) >= 0 {
+				empty = true
+				break
+			}
+			min = next
+		}
+		if !empty {
+			result = append(result, resourceRange[T]{min: min, max: r.max})
+		}
+	}
+	return result
+}
+
+// ipToAddr: ipv4 may be in 4 or 16 bytes
+func ipToAddr(ip net.IP, afi uint8) (netip.Addr, bool) {
+	if afi == 1 {
+		ip4 := ip.To4()
+		if ip4 == nil {
+			return netip.Addr{}, false
+		}
+		return netip.AddrFrom4([4]byte(ip4)), true
+	}
+	if len(ip) != net.IPv6len {
+		return netip.Addr{}, false
+	}
+	return netip.AddrFrom16([16]byte(ip)), true
+}
+
+func getPrefixLastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Masked().Addr().AsSlice()
+	for i := prefix.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
@@ -0,0 +1,131 @@
+package asn1addressasn
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/cpusoft/goutil/jsonutil"
+)
+
+func TestIPResourceSet(t *testing.T) {
+	s, err := ParseIPResourceSet([]string{"10.0.0.0/9", "10.128.0.0/9", "10.0.5.0/24", "12.0.0.0-12.0.0.254",
+		"2001:db8::/33", "2001:db8:8000::/33", "192.168.0.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(s.String())
+	if s.String() != "10.0.0.0/8, 12.0.0.0-12.0.0.254, 192.168.0.0/24, 2001:db8::/32" {
+		t.Fatal("normalized set is wrong:", s.String())
+	}
+	prefixes := s.Ranges[1].Prefixes()
+	if len(prefixes) != 8 || prefixes[0].String() != "12.0.0.0/25" || prefixes[7].String() != "12.0.0.254/32" {
+		t.Fatal("prefixes are wrong:", prefixes)
+	}
+
+	o, _ := ParseIPResourceSet([]string{"10.1.0.0/16", "12.0.0.0/24", "172.16.0.0/12", "2001:db8:1::/48"})
+	if u := s.Union(o).String(); u != "10.0.0.0/8, 12.0.0.0/24, 172.16.0.0/12, 192.168.0.0/24, 2001:db8::/32" {
+		t.Fatal("union is wrong:", u)
+	}
+	if i := s.Intersection(o).String(); i != "10.1.0.0/16, 12.0.0.0-12.0.0.254, 2001:db8:1::/48" {
+		t.Fatal("intersection is wrong:", i)
+	}
+	if d := o.Subtract(s).String(); d != "12.0.0.255/32, 172.16.0.0/12" {
+		t.Fatal("subtract is wrong:", d)
+	}
+	if d := s.Subtract(o).Ranges; len(d) != 5 || d[0].String() != "10.0.0.0/16" ||
+		d[1].String() != "10.2.0.0-10.255.255.255" || d[4].String() != "2001:db8:2::-2001:db8:ffff:ffff:ffff:ffff:ffff:ffff" {
+		t.Fatal("subtract is wrong:", jsonutil.MarshalJson(d))
+	}
+	if s.Contains(o) || !s.Union(o).Contains(o) || !s.ContainsPrefix(netip.MustParsePrefix("10.9.9.0/24")) ||
+		s.ContainsPrefix(netip.MustParsePrefix("12.0.0.0/24")) {
+		t.Fatal("contains is wrong")
+	}
+
+	// whole address space
+	all, _ := ParseIPResourceSet([]string{"0.0.0.0/0", "::/0"})
+	if d := all.Subtract(s).Union(s).String(); d != "0.0.0.0/0, ::/0" {
+		t.Fatal("whole space is wrong:", d)
+	}
+	if d := all.Subtract(all); !d.IsEmpty() {
+		t.Fatal("empty is wrong:", d.String())
+	}
+}
+
+func TestIPResourceSetInherit(t *testing.T) {
+	_, ipNet, _ := net.ParseCIDR("10.1.0.0/16")
+	child, err := NewIPResourceSet([]IPCertificateInformation{
+		&IPNet{IPNet: ipNet},
+		&IPAddressRange{Min: net.ParseIP("10.2.0.1"), Max: net.ParseIP("10.2.0.9")},
+		&IPAddressNull{Family: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if child.String() != "10.1.0.0/16, 10.2.0.1-10.2.0.9, inherit(ipv6)" {
+		t.Fatal("child is wrong:", child.String())
+	}
+	parent, _ := ParseIPResourceSet([]string{"10.0.0.0/8", "2001:db8::/32"})
+	if !parent.Contains(child) {
+		t.Fatal("parent should contain child")
+	}
+	if resolved := child.ResolveInherit(parent).String(); resolved != "10.1.0.0/16, 10.2.0.1-10.2.0.9, 2001:db8::/32" {
+		t.Fatal("resolved is wrong:", resolved)
+	}
+
+	// encode and decode again
+	ext, err := EncodeIPAddressBlock(child.ToIPCertificateInformations())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ips, err := DecodeIPAddressBlock(ext.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := NewIPResourceSet(ips)
+	if err != nil || decoded.String() != child.String() {
+		t.Fatal("decoded is wrong:", decoded, err)
+	}
+}
+
+func TestASResourceSet(t *testing.T) {
+	s, err := ParseASResourceSet([]string{"65000-65010", "65011", "65020-65030", "4294967295"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.String() != "65000-65011, 65020-65030, 4294967295" {
+		t.Fatal("normalized set is wrong:", s.String())
+	}
+	o, _ := ParseASResourceSet([]string{"65005-65025", "0"})
+	if u := s.Union(o).String(); u != "0, 65000-65030, 4294967295" {
+		t.Fatal("union is wrong:", u)
+	}
+	if i := s.Intersection(o).String(); i != "65005-65011, 65020-65025" {
+		t.Fatal("intersection is wrong:", i)
+	}
+	if d := s.Subtract(o).String(); d != "65000-65004, 65026-65030, 4294967295" {
+		t.Fatal("subtract is wrong:", d)
+	}
+	if d := o.Subtract(s).String(); d != "0, 65012-65019" {
+		t.Fatal("over-claim is wrong:", d)
+	}
+	if !s.ContainsAsn(65011) || s.ContainsAsn(65015) || s.Contains(o) {
+		t.Fatal("contains is wrong")
+	}
+	if _, err = ParseASResourceSet([]string{"65010-65000"}); err == nil {
+		t.Fatal("invalid range should fail")
+	}
+
+	child, err := NewASResourceSet([]ASNCertificateInformation{&ASNull{}})
+	if err != nil || !child.Inherit || !s.Contains(child) {
+		t.Fatal("inherit is wrong:", err)
+	}
+	if resolved := child.ResolveInherit(s); resolved.String() != s.String() {
+		t.Fatal("resolved is wrong:", resolved.String())
+	}
+	asns := s.ToASNCertificateInformations()
+	if len(asns) != 3 || asns[2].String() != "4294967295" {
+		t.Fatal("asns are wrong:", asns)
+	}
+}
@@ -0,0 +1,378 @@
+package asn1base
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Rules of DerViolation
+const (
+	DerRuleTruncated         = "truncated"
+	DerRuleTrailingData      = "trailing data"
+	DerRuleNonMinimalTag     = "non-minimal tag"
+	DerRuleIndefiniteLength  = "indefinite length"
+	DerRuleNonMinimalLength  = "non-minimal length"
+	DerRuleConstructedForm   = "wrong constructed form"
+	DerRuleBoolean           = "invalid boolean"
+	DerRuleNonMinimalInteger = "non-minimal integer"
+	DerRuleNull              = "invalid null"
+	DerRuleBitString         = "invalid bit string"
+	DerRuleObjectIdentifier  = "invalid object identifier"
+	DerRuleUTCTime           = "non-canonical utc time"
+	DerRuleGeneralizedTime   = "non-canonical generalized time"
+	DerRuleString            = "invalid string"
+	DerRuleSetOrder          = "unsorted set"
+	DerRuleTooDeep           = "too deep"
+)
+
+// max depth of constructed elements in ValidateDer
+const derValidateMaxDepth = 64
+
+// DerViolation is one DER violation, Offset is from beginning of data
+type DerViolation struct {
+	Offset int    `json:"offset"`
+	Rule   string `json:"rule"`
+	Msg    string `json:"msg"`
+}
+
+func (v DerViolation) Error() string {
+	return "asn1: der violation at offset " + strconv.Itoa(v.Offset) + ": " + v.Rule + ": " + v.Msg
+}
+
+// DerViolations are all violations of one object, empty means it is DER
+type DerViolations []DerViolation
+
+func (vs DerViolations) Error() string {
+	msgs := make([]string, 0, len(vs))
+	for i := range vs {
+		msgs = append(msgs, vs[i].Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+type derValidator struct {
+	data       []byte
+	violations DerViolations
+}
+
+// ValidateDer checks that data is one strict DER element (X.690 section 10 and 11).
+// Unlike Unmarshal, it does not stop at the first problem: every violation is
+// reported with the byte offset where it is found, so caller can classify the
+// object as malformed and show why.
+// It returns nil when data is strict DER.
+func ValidateDer(data []byte) DerViolations {
+	v := &derValidator{data: data}
+	if len(data) == 0 {
+		v.add(0, DerRuleTruncated, "empty data")
+		return v.violations
+	}
+	end, ok := v.validateElement(0, len(data), 0)
+	if ok && end < len(data) {
+		v.add(end, DerRuleTrailingData, strconv.Itoa(len(data)-end)+" bytes after element")
+	}
+	return v.violations
+}
+
+func (v *derValidator) add(offset int, rule, msg string) {
+	v.violations = append(v.violations, DerViolation{Offset: offset, Rule: rule, Msg: msg})
+}
+
+// validateElement checks element at offset which must end before limit.
+// It returns end of the element, ok is false when the rest cannot be parsed.
+func (v *derValidator) validateElement(offset, limit, depth int) (end int, ok bool) {
+	if depth > derValidateMaxDepth {
+		v.add(offset, DerRuleTooDeep, "more than "+strconv.Itoa(derValidateMaxDepth)+" levels")
+		return limit, false
+	}
+	data := v.data[:limit]
+
+	// tag
+	start := offset
+	b := data[offset]
+	offset++
+	class := int(b >> 6)
+	constructed := b&0x20 == 0x20
+	tag := int(b & 0x1f)
+	if tag == 0x1f {
+		if offset < len(data) && data[offset] == 0x80 {
+			v.add(offset, DerRuleNonMinimalTag, "leading 0x80 in tag number")
+		}
+		tag = 0
+		for {
+			if offset >= len(data) {
+				v.add(start, DerRuleTruncated, "truncated tag")
+				return limit, false
+			}
+			if tag >= 1<<24 {
+				v.add(start, DerRuleTruncated, "tag number too large")
+				return limit, false
+			}
+			b = data[offset]
+			offset++
+			tag = tag<<7 | int(b&0x7f)
+			if b&0x80 == 0 {
+				break
+			}
+		}
+		if tag < 0x1f {
+			v.add(start, DerRuleNonMinimalTag, "tag "+strconv.Itoa(tag)+" should be in one byte")
+		}
+	}
+
+	// length
+	if offset >= len(data) {
+		v.add(offset, DerRuleTruncated, "truncated length")
+		return limit, false
+	}
+	lengthOffset := offset
+	b = data[offset]
+	offset++
+	if b == 0x80 {
+		v.add(lengthOffset, DerRuleIndefiniteLength, "indefinite length is not allowed")
+		if !constructed {
+			v.add(start, DerRuleConstructedForm, "primitive element has indefinite length")
+			return limit, false
+		}
+		return v.validateIndefinite(start, offset, limit, class, tag, depth)
+	}
+	length := int(b)
+	if b&0x80 != 0 {
+		numBytes := int(b & 0x7f)
+		if numBytes == 0x7f {
+			v.add(lengthOffset, DerRuleTruncated, "reserved length 0xff")
+			return limit, false
+		}
+		if offset < len(data) && data[offset] == 0 {
+			v.add(lengthOffset, DerRuleNonMinimalLength, "leading zero in length")
+		}
+		length = 0
+		for i := 0; i < numBytes; i++ {
+			if offset >= len(data) {
+				v.add(lengthOffset, DerRuleTruncated, "truncated length")
+				return limit, false
+			}
+			if length >= 1<<23 {
+				v.add(lengthOffset, DerRuleTruncated, "length too large")
+				return limit, false
+			}
+			length = length<<8 | int(data[offset])
+			offset++
+		}
+		if length < 0x80 {
+			v.add(lengthOffset, DerRuleNonMinimalLength, "length "+strconv.Itoa(length)+" should be in short form")
+		}
+	}
+	if length > len(data)-offset {
+		v.add(lengthOffset, DerRuleTruncated, "length "+strconv.Itoa(length)+" is more than the rest "+
+			strconv.Itoa(len(data)-offset))
+		return limit, false
+	}
+	end = offset + length
+
+	if constructed {
+		v.checkConstructed(start, class, tag)
+		children := make([][2]int, 0)
+		for offset < end {
+			childEnd, ok := v.validateElement(offset, end, depth+1)
+			if !ok {
+				// contents cannot be parsed, but length of this element is known
+				return end, true
+			}
+			children = append(children, [2]int{offset, childEnd})
+			offset = childEnd
+		}
+		if class == ClassUniversal && tag == TagSet {
+			v.checkSetOrder(children)
+		}
+		return end, true
+	}
+	v.checkPrimitive(start, offset, end, class, tag)
+	return end, true
+}
+
+// validateIndefinite checks children of indefinite length element until end-of-contents
+func (v *derValidator) validateIndefinite(start, offset, limit, class, tag, depth int) (end int, ok bool) {
+	v.checkConstructed(start, class, tag)
+	children := make([][2]int, 0)
+	for {
+		if offset+2 > limit {
+			v.add(start, DerRuleTruncated, "no end-of-contents")
+			return limit, false
+		}
+		if v.data[offset] == 0 && v.data[offset+1] == 0 {
+			break
+		}
+		childEnd, ok := v.validateElement(offset, limit, depth+1)
+		if !ok {
+			return limit, false
+		}
+		children = append(children, [2]int{offset, childEnd})
+		offset = childEnd
+	}
+	if class == ClassUniversal && tag == TagSet {
+		v.checkSetOrder(children)
+	}
+	return offset + 2, true
+}
+
+// checkConstructed: SEQUENCE and SET are the only universal types which are constructed in DER
+func (v *derValidator) checkConstructed(start, class, tag int) {
+	if class != ClassUniversal || tag == TagSequence || tag == TagSet {
+		return
+	}
+	// EXTERNAL, EMBEDDED PDV are constructed too
+	if tag == 8 || tag == 11 {
+		return
+	}
+	v.add(start, DerRuleConstructedForm, "universal tag "+strconv.Itoa(tag)+" must be primitive")
+}
+
+// checkSetOrder: elements of SET OF are in ascending order of their encodings,
+// and elements of SET are in ascending order of their tags, which is the same order
+func (v *derValidator) checkSetOrder(children [][2]int) {
+	for i := 1; i < len(children); i++ {
+		prev := v.data[children[i-1][0]:children[i-1][1]]
+		cur := v.data[children[i][0]:children[i][1]]
+		if bytes.Compare(prev, cur) > 0 {
+			v.add(children[i][0], DerRuleSetOrder, "element should be before the element at offset "+
+				strconv.Itoa(children[i-1][0]))
+		}
+	}
+}
+
+// checkPrimitive checks contents in [offset, end) of universal types
+func (v *derValidator) checkPrimitive(start, offset, end, class, tag int) {
+	if class != ClassUniversal {
+		return
+	}
+	contents := v.data[offset:end]
+	switch tag {
+	case TagSequence, TagSet:
+		v.add(start, DerRuleConstructedForm, "universal tag "+strconv.Itoa(tag)+" must be constructed")
+	case TagBoolean:
+		if len(contents) != 1 {
+			v.add(offset, DerRuleBoolean, "length is "+strconv.Itoa(len(contents))+", not 1")
+		} else if contents[0] != 0x00 && contents[0] != 0xff {
+			v.add(offset, DerRuleBoolean, "value is 0x"+strconv.FormatUint(uint64(contents[0]), 16)+", not 0x00 or 0xff")
+		}
+	case TagInteger, TagEnum:
+		if len(contents) == 0 {
+			v.add(offset, DerRuleNonMinimalInteger, "empty integer")
+		} else if err := CheckInteger(contents); err != nil {
+			v.add(offset, DerRuleNonMinimalInteger, "superfluous leading 0x"+
+				strconv.FormatUint(uint64(contents[0]), 16))
+		}
+	case TagNull:
+		if len(contents) != 0 {
+			v.add(offset, DerRuleNull, "length is "+strconv.Itoa(len(contents))+", not 0")
+		}
+	case TagBitString:
+		v.checkBitString(offset, contents)
+	case TagOID:
+		v.checkObjectIdentifier(offset, contents)
+	case TagUTCTime:
+		if msg := checkUTCTime(contents); len(msg) > 0 {
+			v.add(offset, DerRuleUTCTime, msg)
+		}
+	case TagGeneralizedTime:
+		if msg := checkGeneralizedTime(contents); len(msg) > 0 {
+			v.add(offset, DerRuleGeneralizedTime, msg)
+		}
+	case TagPrintableString:
+		for i, b := range contents {
+			if !IsPrintable(b, rejectAsterisk, rejectAmpersand) {
+				v.add(offset+i, DerRuleString, "invalid character in PrintableString")
+				return
+			}
+		}
+	case TagIA5String:
+		for i, b := range contents {
+			if b >= utf8.RuneSelf {
+				v.add(offset+i, DerRuleString, "invalid character in IA5String")
+				return
+			}
+		}
+	case TagUTF8String:
+		if !utf8.Valid(contents) {
+			v.add(offset, DerRuleString, "invalid UTF-8 in UTF8String")
+		}
+	}
+}
+
+// checkBitString: unused bits is 0 to 7, and unused bits are zero
+func (v *derValidator) checkBitString(offset int, contents []byte) {
+	if len(contents) == 0 {
+		v.add(offset, DerRuleBitString, "no unused bits byte")
+		return
+	}
+	unused := contents[0]
+	if unused > 7 || (len(contents) == 1 && unused != 0) {
+		v.add(offset, DerRuleBitString, "unused bits is "+strconv.Itoa(int(unused)))
+		return
+	}
+	if len(contents) > 1 && contents[len(contents)-1]&(1<<unused-1) != 0 {
+		v.add(offset+len(contents)-1, DerRuleBitString, "unused bits are not zero")
+	}
+}
+
+// checkObjectIdentifier: every subidentifier is in minimal base 128
+func (v *derValidator) checkObjectIdentifier(offset int, contents []byte) {
+	if len(contents) == 0 {
+		v.add(offset, DerRuleObjectIdentifier, "empty object identifier")
+		return
+	}
+	subStart := true
+	for i, b := range contents {
+		if subStart && b == 0x80 {
+			v.add(offset+i, DerRuleObjectIdentifier, "leading 0x80 in subidentifier")
+		}
+		subStart = b&0x80 == 0
+	}
+	if !subStart {
+		v.add(offset+len(contents)-1, DerRuleObjectIdentifier, "last subidentifier is truncated")
+	}
+}
+
+// checkUTCTime: DER is YYMMDDHHMMSSZ
+func checkUTCTime(contents []byte) string {
+	s := string(contents)
+	if len(s) != 13 || s[12] != 'Z' || !isDigits(s[:12]) {
+		return "\"" + s + "\" is not YYMMDDHHMMSSZ"
+	}
+	if _, err := time.Parse("060102150405Z", s); err != nil {
+		return "\"" + s + "\" is invalid time"
+	}
+	return ""
+}
+
+// checkGeneralizedTime: DER is YYYYMMDDHHMMSS[.f]Z, and fraction has no trailing zero
+func checkGeneralizedTime(contents []byte) string {
+	s := string(contents)
+	if len(s) < 15 || s[len(s)-1] != 'Z' || !isDigits(s[:14]) {
+		return "\"" + s + "\" is not YYYYMMDDHHMMSS[.f]Z"
+	}
+	if fraction := s[14 : len(s)-1]; len(fraction) > 0 {
+		if fraction[0] != '.' || len(fraction) == 1 || !isDigits(fraction[1:]) {
+			return "\"" + s + "\" has invalid fraction of seconds"
+		}
+		if fraction[len(fraction)-1] == '0' {
+			return "\"" + s + "\" has trailing zero in fraction of seconds"
+		}
+	}
+	if _, err := time.Parse("20060102150405Z", s[:14]+"Z"); err != nil {
+		return "\"" + s + "\" is invalid time"
+	}
+	return ""
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
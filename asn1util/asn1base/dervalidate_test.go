@@ -0,0 +1,136 @@
+package asn1base
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+)
+
+type derValidateTest struct {
+	in     string
+	rule   string
+	offset int
+}
+
+var derValidateTestData = []derValidateTest{
+	{"3000", "", 0},
+	{"3003020100", "", 0},
+	{"0101ff", "", 0},
+	{"010101", DerRuleBoolean, 2},
+	{"01020000", DerRuleBoolean, 2},
+	{"02020001", DerRuleNonMinimalInteger, 2},
+	{"0202ff80", DerRuleNonMinimalInteger, 2},
+	{"0200", DerRuleNonMinimalInteger, 2},
+	{"0500", "", 0},
+	{"050100", DerRuleNull, 2},
+	{"3081020500", DerRuleNonMinimalLength, 1},
+	{"308200820500", DerRuleNonMinimalLength, 1},
+	{"308005000000", DerRuleIndefiniteLength, 1},
+	{"3080050000", DerRuleIndefiniteLength, 1},
+	{"3005020100", DerRuleTruncated, 1},
+	{"30000500", DerRuleTrailingData, 2},
+	{"1f0100", DerRuleNonMinimalTag, 0},
+	{"2401ff", DerRuleConstructedForm, 0},
+	{"1000", DerRuleConstructedForm, 0},
+	{"03020780", "", 0},
+	{"03020701", DerRuleBitString, 3},
+	{"030108", DerRuleBitString, 2},
+	{"030101", DerRuleBitString, 2},
+	{"06032a8101", "", 0},
+	{"06032a8001", DerRuleObjectIdentifier, 3},
+	{"06022a81", DerRuleObjectIdentifier, 3},
+	{"0602802a", DerRuleObjectIdentifier, 2},
+	{"060100", "", 0},
+	{"170d3931303530363233343534305a", "", 0},
+	{"170b393130353036323334355a", DerRuleUTCTime, 2},
+	{"17113931303530363136343534302d30373030", DerRuleUTCTime, 2},
+	{"170d3931313330363233343534305a", DerRuleUTCTime, 2},
+	{"180f32303230303130313030303030305a", "", 0},
+	{"181132303230303130313030303030302e355a", "", 0},
+	{"181232303230303130313030303030302e35305a", DerRuleGeneralizedTime, 2},
+	{"181032303230303130313030303030302e5a", DerRuleGeneralizedTime, 2},
+	{"181332303230303130313030303030302b30303030", DerRuleGeneralizedTime, 2},
+	{"3106020101020102", "", 0},
+	{"3106020102020101", DerRuleSetOrder, 5},
+	{"31050101ff0500", "", 0},
+	{"310505000101ff", DerRuleSetOrder, 4},
+	{"130161", "", 0},
+	{"13012a", DerRuleString, 2},
+	{"160180", DerRuleString, 2},
+	{"0c01ff", DerRuleString, 2},
+	{"", DerRuleTruncated, 0},
+}
+
+func TestValidateDer(t *testing.T) {
+	for i, test := range derValidateTestData {
+		in, _ := hex.DecodeString(test.in)
+		violations := ValidateDer(in)
+		if len(test.rule) == 0 {
+			if len(violations) != 0 {
+				t.Fatal("#", i, test.in, "should be DER:", violations.Error())
+			}
+			continue
+		}
+		if len(violations) == 0 {
+			t.Fatal("#", i, test.in, "should fail with", test.rule)
+		}
+		if violations[0].Rule != test.rule || violations[0].Offset != test.offset {
+			t.Fatal("#", i, test.in, "wrong violation:", violations.Error())
+		}
+	}
+}
+
+func TestValidateDerAllViolations(t *testing.T) {
+	// SEQUENCE with long form length { INTEGER 0001, BOOLEAN 01, SET OF { INTEGER 2, INTEGER 1 } }
+	in, _ := hex.DecodeString("30810f020200010101013106020102020101")
+	violations := ValidateDer(in)
+	fmt.Println(violations.Error())
+	if len(violations) != 4 {
+		t.Fatal("should have 4 violations:", violations.Error())
+	}
+	expects := []DerViolation{{Offset: 1, Rule: DerRuleNonMinimalLength}, {Offset: 5, Rule: DerRuleNonMinimalInteger},
+		{Offset: 9, Rule: DerRuleBoolean}, {Offset: 15, Rule: DerRuleSetOrder}}
+	for i, expect := range expects {
+		if violations[i].Offset != expect.Offset || violations[i].Rule != expect.Rule {
+			t.Fatal("violation", i, "is wrong:", violations[i].Error())
+		}
+	}
+}
+
+func TestValidateDerCertificate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test", Organization: []string{"b", "a"}},
+		NotBefore:             time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:              time.Date(2054, 1, 1, 0, 0, 0, 0, time.UTC),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if violations := ValidateDer(cert); len(violations) != 0 {
+		t.Fatal("certificate should be DER:", violations.Error())
+	}
+
+	// BER: change outer length to indefinite
+	ber := append([]byte{0x30, 0x80}, cert[4:]...)
+	ber = append(ber, 0x00, 0x00)
+	violations := ValidateDer(ber)
+	if len(violations) != 1 || violations[0].Rule != DerRuleIndefiniteLength {
+		t.Fatal("ber should have one violation:", violations.Error())
+	}
+}
@@ -0,0 +1,87 @@
+package asn1cms
+
+import (
+	"encoding/asn1"
+	"errors"
+	"strconv"
+
+	"github.com/cpusoft/goutil/belogs"
+)
+
+// https://datatracker.ietf.org/doc/draft-ietf-sidrops-aspa-profile/
+type aspa struct {
+	Version      int `asn1:"optional,explicit,default:0,tag:0"`
+	CustomerAsId int64
+	Providers    []int64
+}
+
+const (
+	ASPA_VERSION = 1
+)
+
+// AspaModel is ASPA with its signed object
+type AspaModel struct {
+	SignedObject
+	Version       int     `json:"version"`
+	CustomerAsId  int64   `json:"customerAsId"`
+	ProviderAsIds []int64 `json:"providerAsIds"`
+}
+
+// ParseAspa parses .asa, signature and message-digest are verified
+func ParseAspa(data []byte) (*AspaModel, error) {
+	signedObject, err := parseSignedObjectWithType(data, AspaOid)
+	if err != nil {
+		belogs.Error("ParseAspa(): parseSignedObjectWithType fail:", err)
+		return nil, err
+	}
+	aspaModel, err := ParseAspaContent(signedObject.EContent)
+	if err != nil {
+		belogs.Error("ParseAspa(): ParseAspaContent fail:", err)
+		return nil, err
+	}
+	aspaModel.SignedObject = *signedObject
+	return aspaModel, nil
+}
+
+// ParseAspaContent parses eContent of ASPA, providers must be in ascending order without duplicates
+// and not contain customer
+func ParseAspaContent(eContent []byte) (*AspaModel, error) {
+	var a aspa
+	rest, err := asn1.Unmarshal(eContent, &a)
+	if err != nil {
+		return nil, errors.New("ASProviderAttestation is invalid: " + err.Error())
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("ASProviderAttestation has trailing data")
+	}
+	if a.Version != ASPA_VERSION {
+		return nil, errors.New("version of ASPA should be 1, but is " + strconv.Itoa(a.Version))
+	}
+	if a.CustomerAsId < 0 || a.CustomerAsId > 0xFFFFFFFF {
+		return nil, errors.New("customerASID of ASPA is invalid: " + strconv.FormatInt(a.CustomerAsId, 10))
+	}
+	if len(a.Providers) == 0 {
+		return nil, errors.New("providers of ASPA is empty")
+	}
+	for i, provider := range a.Providers {
+		if provider < 0 || provider > 0xFFFFFFFF {
+			return nil, errors.New("provider of ASPA is invalid: " + strconv.FormatInt(provider, 10))
+		}
+		if provider == a.CustomerAsId {
+			return nil, errors.New("providers of ASPA should not contain customerASID")
+		}
+		if i > 0 && provider <= a.Providers[i-1] {
+			return nil, errors.New("providers of ASPA should be in ascending order without duplicates")
+		}
+	}
+	return &AspaModel{
+		Version:       a.Version,
+		CustomerAsId:  a.CustomerAsId,
+		ProviderAsIds: a.Providers,
+	}, nil
+}
+
+// EncodeAspaContent encodes eContent of ASPA, providerAsIds should be in ascending order
+func EncodeAspaContent(customerAsId int64, providerAsIds []int64) ([]byte, error) {
+	return asn1.Marshal(aspa{Version: ASPA_VERSION, CustomerAsId: customerAsId, Providers: providerAsIds})
+}
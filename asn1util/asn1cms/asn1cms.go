@@ -0,0 +1,382 @@
+package asn1cms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/cpusoft/goutil/asn1util/asn1addressasn"
+	"github.com/cpusoft/goutil/belogs"
+)
+
+// https://datatracker.ietf.org/doc/html/rfc6488
+// https://datatracker.ietf.org/doc/html/rfc5652
+// https://datatracker.ietf.org/doc/html/rfc7935
+
+var (
+	SignedDataOid = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+	// eContentType
+	RoaOid      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 24}
+	ManifestOid = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 26}
+	AspaOid     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 49}
+
+	// signed attributes
+	ContentTypeAttrOid       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	MessageDigestAttrOid     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	SigningTimeAttrOid       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	BinarySigningTimeAttrOid = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 46}
+
+	// algorithms
+	Sha256Oid        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	RsaEncryptionOid = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	Sha256WithRsaOid = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+)
+
+const (
+	SIGNED_DATA_VERSION = 3
+	SIGNER_INFO_VERSION = 3
+)
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapContentInfo
+	Certificates     rawSet       `asn1:"optional,tag:0"`
+	Crls             rawSet       `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo `asn1:"set"`
+}
+
+type encapContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     []byte `asn1:"explicit,optional,tag:0"`
+}
+
+// rawSet keeps [0]/[1] IMPLICIT SET OF as it is
+type rawSet struct {
+	Raw asn1.RawContent
+}
+
+type signerInfo struct {
+	Version            int
+	Sid                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        rawSet `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      rawSet `asn1:"optional,tag:1"`
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// SignedAttributes are signed attributes of RFC 6488 2.1.6.4
+type SignedAttributes struct {
+	ContentType   asn1.ObjectIdentifier `json:"contentType"`
+	MessageDigest []byte                `json:"messageDigest"`
+	// zero when not exists
+	SigningTime       time.Time `json:"signingTime"`
+	BinarySigningTime time.Time `json:"binarySigningTime"`
+}
+
+// SignedObject is RFC 6488 signed object, signature and message-digest are verified.
+// EE certificate is not verified by its issuer here
+type SignedObject struct {
+	EContentType asn1.ObjectIdentifier `json:"eContentType"`
+	EContent     []byte                `json:"-"`
+
+	EeCertBytes []byte                          `json:"-"`
+	EeCert      *asn1addressasn.RPKICertificate `json:"-"`
+	// hex of sid, same as SubjectKeyId of EE certificate
+	SubjectKeyIdentifier string `json:"subjectKeyIdentifier"`
+
+	DigestAlgorithm    asn1.ObjectIdentifier `json:"digestAlgorithm"`
+	SignatureAlgorithm asn1.ObjectIdentifier `json:"signatureAlgorithm"`
+	SignedAttributes   SignedAttributes      `json:"signedAttributes"`
+	Signature          []byte                `json:"-"`
+}
+
+// ParseSignedObject parses CMS of .roa/.mft/.asa and verifies signature and message-digest
+func ParseSignedObject(data []byte) (*SignedObject, error) {
+	belogs.Debug("ParseSignedObject(): len(data):", len(data))
+	var ci contentInfo
+	rest, err := asn1.Unmarshal(data, &ci)
+	if err != nil {
+		belogs.Error("ParseSignedObject(): Unmarshal contentInfo fail:", err)
+		return nil, errors.New("signed object is not CMS: " + err.Error())
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("signed object has trailing data")
+	}
+	if !ci.ContentType.Equal(SignedDataOid) {
+		return nil, errors.New("contentType is not signedData: " + ci.ContentType.String())
+	}
+
+	var sd signedData
+	rest, err = asn1.Unmarshal(ci.Content.Bytes, &sd)
+	if err != nil {
+		belogs.Error("ParseSignedObject(): Unmarshal signedData fail:", err)
+		return nil, errors.New("signedData is invalid: " + err.Error())
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("signedData has trailing data")
+	}
+	if err = checkSignedData(&sd); err != nil {
+		belogs.Error("ParseSignedObject(): checkSignedData fail:", err)
+		return nil, err
+	}
+	si := &sd.SignerInfos[0]
+	signedObject := &SignedObject{
+		EContentType:       sd.EncapContentInfo.EContentType,
+		EContent:           sd.EncapContentInfo.EContent,
+		DigestAlgorithm:    si.DigestAlgorithm.Algorithm,
+		SignatureAlgorithm: si.SignatureAlgorithm.Algorithm,
+		Signature:          si.Signature,
+	}
+
+	// only one EE certificate
+	var certs []asn1.RawValue
+	if _, err = asn1.UnmarshalWithParams(retagSet(sd.Certificates.Raw), &certs, "set"); err != nil || len(certs) != 1 {
+		belogs.Error("ParseSignedObject(): certificates fail:", len(certs), err)
+		return nil, errors.New("signedData should have only one EE certificate")
+	}
+	signedObject.EeCertBytes = certs[0].FullBytes
+	signedObject.EeCert, err = asn1addressasn.DecodeCertificate(signedObject.EeCertBytes)
+	if err != nil {
+		belogs.Error("ParseSignedObject(): DecodeCertificate fail:", err)
+		return nil, errors.New("EE certificate is invalid: " + err.Error())
+	}
+	if si.Sid.Class != asn1.ClassContextSpecific || si.Sid.Tag != 0 || si.Sid.IsCompound {
+		return nil, errors.New("sid should be subjectKeyIdentifier")
+	}
+	if !bytes.Equal(si.Sid.Bytes, signedObject.EeCert.Certificate.SubjectKeyId) {
+		return nil, errors.New("sid is different from SubjectKeyIdentifier of EE certificate")
+	}
+	signedObject.SubjectKeyIdentifier = hex.EncodeToString(si.Sid.Bytes)
+
+	// signed attributes, the signature is over DER of SET OF
+	signedAttrsBytes := retagSet(si.SignedAttrs.Raw)
+	signedObject.SignedAttributes, err = parseSignedAttributes(signedAttrsBytes)
+	if err != nil {
+		belogs.Error("ParseSignedObject(): parseSignedAttributes fail:", err)
+		return nil, err
+	}
+	if !signedObject.SignedAttributes.ContentType.Equal(signedObject.EContentType) {
+		return nil, errors.New("contentType of signed attributes is different from eContentType")
+	}
+	digest := sha256.Sum256(signedObject.EContent)
+	if !bytes.Equal(digest[:], signedObject.SignedAttributes.MessageDigest) {
+		return nil, errors.New("message-digest is different from digest of eContent")
+	}
+	err = signedObject.EeCert.Certificate.CheckSignature(x509.SHA256WithRSA, signedAttrsBytes, si.Signature)
+	if err != nil {
+		belogs.Error("ParseSignedObject(): CheckSignature fail:", err)
+		return nil, errors.New("signature is invalid: " + err.Error())
+	}
+	belogs.Debug("ParseSignedObject(): ok, eContentType:", signedObject.EContentType, " ski:", signedObject.SubjectKeyIdentifier)
+	return signedObject, nil
+}
+
+// EncodeSignedObject signs eContent by EE key, with contentType, messageDigest and signingTime in signedAttrs.
+// eeCertBytes is DER of EE certificate, its SubjectKeyIdentifier is used as sid
+func EncodeSignedObject(eContentType asn1.ObjectIdentifier, eContent []byte, eeCertBytes []byte,
+	eeKey *rsa.PrivateKey, signingTime time.Time) ([]byte, error) {
+	sd, err := newSignedData(eContentType, eContent, eeCertBytes, eeKey, signingTime)
+	if err != nil {
+		belogs.Error("EncodeSignedObject(): newSignedData fail:", err)
+		return nil, err
+	}
+	return marshalSignedData(sd)
+}
+
+// newSignedData creates signedData of RFC 6488 2.1 with only one signerInfo
+func newSignedData(eContentType asn1.ObjectIdentifier, eContent []byte, eeCertBytes []byte,
+	eeKey *rsa.PrivateKey, signingTime time.Time) (*signedData, error) {
+	eeCert, err := x509.ParseCertificate(eeCertBytes)
+	if err != nil {
+		return nil, errors.New("EE certificate is invalid: " + err.Error())
+	}
+	if len(eeCert.SubjectKeyId) == 0 {
+		return nil, errors.New("EE certificate has no SubjectKeyIdentifier")
+	}
+	certs, err := asn1.MarshalWithParams([]asn1.RawValue{{FullBytes: eeCertBytes}}, "set")
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256(eContent)
+	contentTypeValue, err := asn1.Marshal(eContentType)
+	if err != nil {
+		return nil, err
+	}
+	digestValue, err := asn1.Marshal(digest[:])
+	if err != nil {
+		return nil, err
+	}
+	signingTimeValue, err := asn1.Marshal(signingTime.UTC())
+	if err != nil {
+		return nil, err
+	}
+	attrsBytes, err := asn1.MarshalWithParams([]attribute{
+		{Type: ContentTypeAttrOid, Values: []asn1.RawValue{{FullBytes: contentTypeValue}}},
+		{Type: MessageDigestAttrOid, Values: []asn1.RawValue{{FullBytes: digestValue}}},
+		{Type: SigningTimeAttrOid, Values: []asn1.RawValue{{FullBytes: signingTimeValue}}},
+	}, "set")
+	if err != nil {
+		return nil, err
+	}
+	// the signature is over DER of SET OF, and signedAttrs is saved as [0] IMPLICIT
+	attrsDigest := sha256.Sum256(attrsBytes)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, eeKey, crypto.SHA256, attrsDigest[:])
+	if err != nil {
+		return nil, errors.New("sign signedAttrs fail: " + err.Error())
+	}
+	return &signedData{
+		Version:          SIGNED_DATA_VERSION,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: Sha256Oid}},
+		EncapContentInfo: encapContentInfo{EContentType: eContentType, EContent: eContent},
+		Certificates:     rawSet{Raw: certs},
+		SignerInfos: []signerInfo{{
+			Version:            SIGNER_INFO_VERSION,
+			Sid:                asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: eeCert.SubjectKeyId},
+			DigestAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: Sha256Oid},
+			SignedAttrs:        rawSet{Raw: attrsBytes},
+			SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: RsaEncryptionOid},
+			Signature:          signature,
+		}},
+	}, nil
+}
+
+// marshalSignedData marshals signedData in contentInfo
+func marshalSignedData(sd *signedData) ([]byte, error) {
+	sdBytes, err := asn1.Marshal(*sd)
+	if err != nil {
+		return nil, errors.New("marshal signedData fail: " + err.Error())
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: SignedDataOid,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sdBytes},
+	})
+}
+
+// checkSignedData checks profile of RFC 6488 2.1
+func checkSignedData(sd *signedData) error {
+	if sd.Version != SIGNED_DATA_VERSION {
+		return errors.New("version of signedData should be 3, but is " + strconv.Itoa(sd.Version))
+	}
+	if len(sd.DigestAlgorithms) != 1 || !sd.DigestAlgorithms[0].Algorithm.Equal(Sha256Oid) {
+		return errors.New("digestAlgorithms should be only sha256")
+	}
+	if len(sd.EncapContentInfo.EContent) == 0 {
+		return errors.New("eContent is empty")
+	}
+	if len(sd.Certificates.Raw) == 0 {
+		return errors.New("certificates is empty")
+	}
+	if len(sd.Crls.Raw) > 0 {
+		return errors.New("crls should be omitted")
+	}
+	if len(sd.SignerInfos) != 1 {
+		return errors.New("signerInfos should have only one signerInfo, but has " + strconv.Itoa(len(sd.SignerInfos)))
+	}
+	si := &sd.SignerInfos[0]
+	if si.Version != SIGNER_INFO_VERSION {
+		return errors.New("version of signerInfo should be 3, but is " + strconv.Itoa(si.Version))
+	}
+	if !si.DigestAlgorithm.Algorithm.Equal(Sha256Oid) {
+		return errors.New("digestAlgorithm of signerInfo should be sha256: " + si.DigestAlgorithm.Algorithm.String())
+	}
+	if !si.SignatureAlgorithm.Algorithm.Equal(RsaEncryptionOid) && !si.SignatureAlgorithm.Algorithm.Equal(Sha256WithRsaOid) {
+		return errors.New("signatureAlgorithm should be rsaEncryption or sha256WithRSAEncryption: " + si.SignatureAlgorithm.Algorithm.String())
+	}
+	if len(si.SignedAttrs.Raw) == 0 {
+		return errors.New("signedAttrs is empty")
+	}
+	if len(si.UnsignedAttrs.Raw) > 0 {
+		return errors.New("unsignedAttrs should be omitted")
+	}
+	return nil
+}
+
+// parseSignedAttributes: contentType and messageDigest are required, signingTime and binarySigningTime are optional,
+// each only once and with only one value
+func parseSignedAttributes(signedAttrsBytes []byte) (attrs SignedAttributes, err error) {
+	var attributes []attribute
+	if _, err = asn1.UnmarshalWithParams(signedAttrsBytes, &attributes, "set"); err != nil {
+		return attrs, errors.New("signedAttrs is invalid: " + err.Error())
+	}
+	found := make(map[string]bool)
+	for _, attr := range attributes {
+		oid := attr.Type.String()
+		if found[oid] {
+			return attrs, errors.New("signed attribute occurs more than once: " + oid)
+		}
+		found[oid] = true
+		if len(attr.Values) != 1 {
+			return attrs, errors.New("signed attribute should have only one value: " + oid)
+		}
+		value := attr.Values[0].FullBytes
+		switch {
+		case attr.Type.Equal(ContentTypeAttrOid):
+			_, err = asn1.Unmarshal(value, &attrs.ContentType)
+		case attr.Type.Equal(MessageDigestAttrOid):
+			_, err = asn1.Unmarshal(value, &attrs.MessageDigest)
+		case attr.Type.Equal(SigningTimeAttrOid):
+			_, err = asn1.Unmarshal(value, &attrs.SigningTime)
+		case attr.Type.Equal(BinarySigningTimeAttrOid):
+			var t *big.Int
+			if _, err = asn1.Unmarshal(value, &t); err == nil {
+				attrs.BinarySigningTime = time.Unix(t.Int64(), 0).UTC()
+			}
+		default:
+			return attrs, errors.New("signed attribute is not allowed: " + oid)
+		}
+		if err != nil {
+			return attrs, errors.New("signed attribute is invalid: " + oid + ", " + err.Error())
+		}
+	}
+	if !found[ContentTypeAttrOid.String()] || !found[MessageDigestAttrOid.String()] {
+		return attrs, errors.New("signed attributes should have contentType and messageDigest")
+	}
+	return attrs, nil
+}
+
+// retagSet changes IMPLICIT [n] to universal SET
+func retagSet(raw []byte) []byte {
+	if len(raw) == 0 {
+		return raw
+	}
+	b := make([]byte, len(raw))
+	copy(b, raw)
+	b[0] = 0x31
+	return b
+}
+
+// parseSignedObjectWithType parses signed object and checks eContentType
+func parseSignedObjectWithType(data []byte, eContentType asn1.ObjectIdentifier) (*SignedObject, error) {
+	signedObject, err := ParseSignedObject(data)
+	if err != nil {
+		return nil, err
+	}
+	if !signedObject.EContentType.Equal(eContentType) {
+		return nil, errors.New("eContentType should be " + eContentType.String() + ", but is " + signedObject.EContentType.String())
+	}
+	return signedObject, nil
+}
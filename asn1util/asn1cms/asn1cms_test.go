@@ -0,0 +1,157 @@
+package asn1cms
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/cpusoft/goutil/asn1util/asn1cert"
+	"github.com/cpusoft/goutil/jsonutil"
+)
+
+var testEeKey *rsa.PrivateKey
+
+// newTestSignedObject signs eContent by an EE certificate, modify can change signedData before marshal
+func newTestSignedObject(t *testing.T, eContentType asn1.ObjectIdentifier, eContent []byte,
+	modify func(sd *signedData, si *signerInfo)) []byte {
+	if testEeKey == nil {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testEeKey = key
+	}
+	ski := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "test-ee"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		SubjectKeyId: ski,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, &testEeKey.PublicKey, testEeKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd, err := newSignedData(eContentType, eContent, certBytes, testEeKey, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if modify != nil {
+		modify(sd, &sd.SignerInfos[0])
+	}
+	b, err := marshalSignedData(sd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestParseRoa(t *testing.T) {
+	eContent, err := EncodeRoaContent(65001, []RoaIpAddress{
+		{IpAddrBlock: asn1cert.IpAddrBlock{AddressPrefix: "2001:db8::/32"}, MaxLength: -1},
+		{IpAddrBlock: asn1cert.IpAddrBlock{AddressPrefix: "10.0.32.0/20"}, MaxLength: 24},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roaModel, err := ParseRoa(newTestSignedObject(t, RoaOid, eContent, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(jsonutil.MarshalJson(roaModel))
+	if roaModel.AsId != 65001 || len(roaModel.RoaIpAddresses) != 2 ||
+		roaModel.RoaIpAddresses[0].AddressPrefix != "10.0.32.0/20" || roaModel.RoaIpAddresses[0].MaxLength != 24 ||
+		roaModel.RoaIpAddresses[1].AddressPrefix != "2001:db8::/32" || roaModel.RoaIpAddresses[1].MaxLength != -1 {
+		t.Fatal("roa is wrong:", jsonutil.MarshalJson(roaModel))
+	}
+	if roaModel.SignedAttributes.SigningTime.IsZero() || roaModel.SubjectKeyIdentifier != "0102030405060708090a0b0c0d0e0f1011121314" {
+		t.Fatal("signed object is wrong:", jsonutil.MarshalJson(roaModel.SignedObject))
+	}
+
+	// not roa
+	if _, err = ParseManifest(newTestSignedObject(t, RoaOid, eContent, nil)); err == nil {
+		t.Fatal("ParseManifest should fail for roa")
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	hash := sha256.Sum256([]byte("a.roa"))
+	now := time.Now().UTC().Truncate(time.Second)
+	eContent, err := EncodeManifestContent(big.NewInt(12), now, now.Add(24*time.Hour), []asn1cert.FileAndHash{
+		{File: "a.roa", Hash: hash[:]},
+		{File: "b.crl", Hash: hash[:]},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mftModel, err := ParseManifest(newTestSignedObject(t, ManifestOid, eContent, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(jsonutil.MarshalJson(mftModel))
+	if mftModel.ManifestNumber.Int64() != 12 || !mftModel.ThisUpdate.Equal(now) || len(mftModel.FileAndHashs) != 2 ||
+		mftModel.FileAndHashs[1].File != "b.crl" || string(mftModel.FileAndHashs[0].Hash) != string(hash[:]) {
+		t.Fatal("manifest is wrong:", jsonutil.MarshalJson(mftModel))
+	}
+}
+
+func TestParseAspa(t *testing.T) {
+	eContent, _ := EncodeAspaContent(65000, []int64{65001, 65002})
+	aspaModel, err := ParseAspa(newTestSignedObject(t, AspaOid, eContent, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(jsonutil.MarshalJson(aspaModel))
+	if aspaModel.CustomerAsId != 65000 || len(aspaModel.ProviderAsIds) != 2 {
+		t.Fatal("aspa is wrong:", jsonutil.MarshalJson(aspaModel))
+	}
+
+	eContent, _ = EncodeAspaContent(65000, []int64{65002, 65001})
+	if _, err = ParseAspa(newTestSignedObject(t, AspaOid, eContent, nil)); err == nil {
+		t.Fatal("ParseAspa should fail for unsorted providers")
+	}
+}
+
+func TestParseSignedObjectFail(t *testing.T) {
+	eContent, _ := EncodeAspaContent(65000, []int64{65001})
+	modifies := map[string]func(sd *signedData, si *signerInfo){
+		"eContent changed": func(sd *signedData, si *signerInfo) {
+			sd.EncapContentInfo.EContent = append([]byte{}, eContent...)
+			sd.EncapContentInfo.EContent[len(eContent)-1]++
+		},
+		"signature changed": func(sd *signedData, si *signerInfo) {
+			si.Signature[0]++
+		},
+		"sid changed": func(sd *signedData, si *signerInfo) {
+			si.Sid.Bytes = []byte{1, 2, 3}
+		},
+		"wrong version": func(sd *signedData, si *signerInfo) {
+			sd.Version = 1
+		},
+		"has crls": func(sd *signedData, si *signerInfo) {
+			sd.Crls = sd.Certificates
+		},
+		"wrong digest algorithm": func(sd *signedData, si *signerInfo) {
+			si.DigestAlgorithm.Algorithm = RsaEncryptionOid
+		},
+	}
+	for name, modify := range modifies {
+		if _, err := ParseSignedObject(newTestSignedObject(t, AspaOid, eContent, modify)); err == nil {
+			t.Fatal(name, ": should fail")
+		} else {
+			fmt.Println(name, ":", err)
+		}
+	}
+	if _, err := ParseSignedObject([]byte{0x30, 0x03, 0x02, 0x01, 0x01}); err == nil {
+		t.Fatal("should fail for not CMS")
+	}
+}
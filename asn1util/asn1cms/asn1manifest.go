@@ -0,0 +1,125 @@
+package asn1cms
+
+import (
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cpusoft/goutil/asn1util/asn1cert"
+	"github.com/cpusoft/goutil/belogs"
+)
+
+// https://datatracker.ietf.org/doc/html/rfc9286
+type manifest struct {
+	Version        int `asn1:"optional,explicit,default:0,tag:0"`
+	ManifestNumber *big.Int
+	ThisUpdate     time.Time `asn1:"generalized"`
+	NextUpdate     time.Time `asn1:"generalized"`
+	FileHashAlg    asn1.ObjectIdentifier
+	FileList       []fileAndHash
+}
+
+type fileAndHash struct {
+	File string `asn1:"ia5"`
+	Hash asn1.BitString
+}
+
+// ManifestModel is Manifest with its signed object
+type ManifestModel struct {
+	SignedObject
+	Version        int                    `json:"version"`
+	ManifestNumber *big.Int               `json:"manifestNumber"`
+	ThisUpdate     time.Time              `json:"thisUpdate"`
+	NextUpdate     time.Time              `json:"nextUpdate"`
+	FileHashAlg    asn1.ObjectIdentifier  `json:"fileHashAlg"`
+	FileAndHashs   []asn1cert.FileAndHash `json:"fileAndHashs"`
+}
+
+// ParseManifest parses .mft, signature and message-digest are verified
+func ParseManifest(data []byte) (*ManifestModel, error) {
+	signedObject, err := parseSignedObjectWithType(data, ManifestOid)
+	if err != nil {
+		belogs.Error("ParseManifest(): parseSignedObjectWithType fail:", err)
+		return nil, err
+	}
+	manifestModel, err := ParseManifestContent(signedObject.EContent)
+	if err != nil {
+		belogs.Error("ParseManifest(): ParseManifestContent fail:", err)
+		return nil, err
+	}
+	manifestModel.SignedObject = *signedObject
+	return manifestModel, nil
+}
+
+// ParseManifestContent parses eContent of Manifest
+func ParseManifestContent(eContent []byte) (*ManifestModel, error) {
+	var mft manifest
+	rest, err := asn1.Unmarshal(eContent, &mft)
+	if err != nil {
+		return nil, errors.New("Manifest is invalid: " + err.Error())
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("Manifest has trailing data")
+	}
+	if mft.Version != 0 {
+		return nil, errors.New("version of Manifest should be 0, but is " + strconv.Itoa(mft.Version))
+	}
+	if mft.ManifestNumber.Sign() < 0 || mft.ManifestNumber.BitLen() > 160 {
+		return nil, errors.New("manifestNumber is invalid: " + mft.ManifestNumber.String())
+	}
+	if !mft.NextUpdate.After(mft.ThisUpdate) {
+		return nil, errors.New("nextUpdate of Manifest should be after thisUpdate")
+	}
+	if !mft.FileHashAlg.Equal(Sha256Oid) {
+		return nil, errors.New("fileHashAlg of Manifest should be sha256: " + mft.FileHashAlg.String())
+	}
+
+	manifestModel := &ManifestModel{
+		Version:        mft.Version,
+		ManifestNumber: mft.ManifestNumber,
+		ThisUpdate:     mft.ThisUpdate,
+		NextUpdate:     mft.NextUpdate,
+		FileHashAlg:    mft.FileHashAlg,
+		FileAndHashs:   make([]asn1cert.FileAndHash, 0, len(mft.FileList)),
+	}
+	files := make(map[string]bool, len(mft.FileList))
+	for _, f := range mft.FileList {
+		if len(f.File) == 0 || strings.ContainsAny(f.File, "/\\") || f.File == "." || f.File == ".." {
+			return nil, errors.New("file name of Manifest is invalid: " + f.File)
+		}
+		if files[f.File] {
+			return nil, errors.New("file of Manifest is duplicated: " + f.File)
+		}
+		files[f.File] = true
+		if f.Hash.BitLength != 256 {
+			return nil, errors.New("hash of Manifest should be sha256: " + f.File)
+		}
+		manifestModel.FileAndHashs = append(manifestModel.FileAndHashs, asn1cert.FileAndHash{
+			File: f.File,
+			Hash: f.Hash.Bytes,
+		})
+	}
+	return manifestModel, nil
+}
+
+// EncodeManifestContent encodes eContent of Manifest, Hash of fileAndHashs is sha256
+func EncodeManifestContent(manifestNumber *big.Int, thisUpdate, nextUpdate time.Time,
+	fileAndHashs []asn1cert.FileAndHash) ([]byte, error) {
+	mft := manifest{
+		ManifestNumber: manifestNumber,
+		ThisUpdate:     thisUpdate.UTC(),
+		NextUpdate:     nextUpdate.UTC(),
+		FileHashAlg:    Sha256Oid,
+		FileList:       make([]fileAndHash, 0, len(fileAndHashs)),
+	}
+	for _, f := range fileAndHashs {
+		mft.FileList = append(mft.FileList, fileAndHash{
+			File: f.File,
+			Hash: asn1.BitString{Bytes: f.Hash, BitLength: 8 * len(f.Hash)},
+		})
+	}
+	return asn1.Marshal(mft)
+}
@@ -0,0 +1,146 @@
+package asn1cms
+
+import (
+	"encoding/asn1"
+	"errors"
+	"net"
+	"strconv"
+
+	"github.com/cpusoft/goutil/asn1util/asn1addressasn"
+	"github.com/cpusoft/goutil/asn1util/asn1cert"
+	"github.com/cpusoft/goutil/belogs"
+)
+
+// https://datatracker.ietf.org/doc/html/rfc9582
+type routeOriginAttestation struct {
+	Version      int `asn1:"optional,explicit,default:0,tag:0"`
+	AsId         int64
+	IpAddrBlocks []roaIpAddressFamily
+}
+
+type roaIpAddressFamily struct {
+	AddressFamily []byte
+	Addresses     []roaIpAddress
+}
+
+type roaIpAddress struct {
+	Address   asn1.BitString
+	MaxLength int `asn1:"optional,default:-1"`
+}
+
+// RoaIpAddress is prefix of ROA, MaxLength is -1 when not exists
+type RoaIpAddress struct {
+	asn1cert.IpAddrBlock
+	MaxLength int `json:"maxLength"`
+}
+
+// RoaModel is ROA with its signed object
+type RoaModel struct {
+	SignedObject
+	Version        int            `json:"version"`
+	AsId           int64          `json:"asId"`
+	RoaIpAddresses []RoaIpAddress `json:"roaIpAddresses"`
+}
+
+// ParseRoa parses .roa, signature and message-digest are verified
+func ParseRoa(data []byte) (*RoaModel, error) {
+	signedObject, err := parseSignedObjectWithType(data, RoaOid)
+	if err != nil {
+		belogs.Error("ParseRoa(): parseSignedObjectWithType fail:", err)
+		return nil, err
+	}
+	roaModel, err := ParseRoaContent(signedObject.EContent)
+	if err != nil {
+		belogs.Error("ParseRoa(): ParseRoaContent fail:", err)
+		return nil, err
+	}
+	roaModel.SignedObject = *signedObject
+	return roaModel, nil
+}
+
+// ParseRoaContent parses eContent of ROA
+func ParseRoaContent(eContent []byte) (*RoaModel, error) {
+	var roa routeOriginAttestation
+	rest, err := asn1.Unmarshal(eContent, &roa)
+	if err != nil {
+		return nil, errors.New("RouteOriginAttestation is invalid: " + err.Error())
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("RouteOriginAttestation has trailing data")
+	}
+	if roa.Version != 0 {
+		return nil, errors.New("version of ROA should be 0, but is " + strconv.Itoa(roa.Version))
+	}
+	if roa.AsId < 0 || roa.AsId > 0xFFFFFFFF {
+		return nil, errors.New("asID of ROA is invalid: " + strconv.FormatInt(roa.AsId, 10))
+	}
+	if len(roa.IpAddrBlocks) == 0 || len(roa.IpAddrBlocks) > 2 {
+		return nil, errors.New("ipAddrBlocks of ROA should have 1 or 2 families")
+	}
+
+	roaModel := &RoaModel{
+		Version:        roa.Version,
+		AsId:           roa.AsId,
+		RoaIpAddresses: make([]RoaIpAddress, 0),
+	}
+	for _, family := range roa.IpAddrBlocks {
+		if len(family.AddressFamily) != 2 || family.AddressFamily[0] != 0 ||
+			(family.AddressFamily[1] != 1 && family.AddressFamily[1] != 2) {
+			return nil, errors.New("addressFamily of ROA is invalid")
+		}
+		bits := 32
+		if family.AddressFamily[1] == 2 {
+			bits = 128
+		}
+		for _, address := range family.Addresses {
+			if address.Address.BitLength > bits {
+				return nil, errors.New("address of ROA is too long: " + strconv.Itoa(address.Address.BitLength))
+			}
+			if address.MaxLength != -1 && (address.MaxLength < address.Address.BitLength || address.MaxLength > bits) {
+				return nil, errors.New("maxLength of ROA is invalid: " + strconv.Itoa(address.MaxLength))
+			}
+			ipNet, err := asn1addressasn.DecodeIP(family.AddressFamily, address.Address)
+			if err != nil {
+				return nil, err
+			}
+			roaModel.RoaIpAddresses = append(roaModel.RoaIpAddresses, RoaIpAddress{
+				IpAddrBlock: asn1cert.IpAddrBlock{
+					AddressFamily: uint64(family.AddressFamily[1]),
+					AddressPrefix: ipNet.String(),
+				},
+				MaxLength: address.MaxLength,
+			})
+		}
+	}
+	return roaModel, nil
+}
+
+// EncodeRoaContent encodes eContent of ROA by AddressPrefix and MaxLength of roaIpAddresses,
+// MaxLength is -1 means omitted. ipv4 is before ipv6
+func EncodeRoaContent(asId int64, roaIpAddresses []RoaIpAddress) ([]byte, error) {
+	families := []roaIpAddressFamily{{AddressFamily: []byte{0, 1}}, {AddressFamily: []byte{0, 2}}}
+	for _, address := range roaIpAddresses {
+		_, ipNet, err := net.ParseCIDR(address.AddressPrefix)
+		if err != nil {
+			return nil, errors.New("address prefix of ROA is invalid: " + address.AddressPrefix)
+		}
+		family := 0
+		if ipNet.IP.To4() == nil {
+			family = 1
+		}
+		families[family].Addresses = append(families[family].Addresses, roaIpAddress{
+			Address:   asn1addressasn.IPNetToBitString(*ipNet),
+			MaxLength: address.MaxLength,
+		})
+	}
+	roa := routeOriginAttestation{AsId: asId}
+	for _, family := range families {
+		if len(family.Addresses) > 0 {
+			roa.IpAddrBlocks = append(roa.IpAddrBlocks, family)
+		}
+	}
+	if len(roa.IpAddrBlocks) == 0 {
+		return nil, errors.New("ROA has no address prefix")
+	}
+	return asn1.Marshal(roa)
+}
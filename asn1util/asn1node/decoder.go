@@ -0,0 +1,384 @@
+package asn1node
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/cpusoft/goutil/belogs"
+)
+
+const (
+	DECODER_DEFAULT_MAX_DEPTH        = 64
+	DECODER_DEFAULT_MAX_LENGTH       = 16 << 20
+	DECODER_DEFAULT_MAX_TOTAL_LENGTH = 64 << 20
+
+	// Length of Token is LENGTH_INDEFINITE when it is BER indefinite length (0x80)
+	LENGTH_INDEFINITE = -1
+)
+
+type TokenType int
+
+const (
+	TOKEN_TYPE_START_CONSTRUCTED TokenType = 1
+	TOKEN_TYPE_PRIMITIVE         TokenType = 2
+	// end of definite length, or end-of-contents (0x00 0x00) of indefinite length
+	TOKEN_TYPE_END_CONSTRUCTED TokenType = 3
+)
+
+func (t TokenType) String() string {
+	switch t {
+	case TOKEN_TYPE_START_CONSTRUCTED:
+		return "startConstructed"
+	case TOKEN_TYPE_PRIMITIVE:
+		return "primitive"
+	case TOKEN_TYPE_END_CONSTRUCTED:
+		return "endConstructed"
+	}
+	return "unknown(" + strconv.Itoa(int(t)) + ")"
+}
+
+// Token is one event of Decoder
+type Token struct {
+	Type   TokenType `json:"type"`
+	Header Header    `json:"header"`
+	// offset of header in input
+	Offset       int64 `json:"offset"`
+	HeaderLength int   `json:"headerLength"`
+	// length of contents, or LENGTH_INDEFINITE.
+	// for END_CONSTRUCTED, it is real length of contents without end-of-contents
+	Length int64 `json:"length"`
+	// offset after element (include end-of-contents), just for END_CONSTRUCTED
+	EndOffset int64 `json:"endOffset,omitempty"`
+	// depth of top element is 0
+	Depth int `json:"depth"`
+	// contents of primitive
+	Data []byte `json:"data,omitempty"`
+}
+
+// DecoderConfig: zero values use defaults
+type DecoderConfig struct {
+	// max depth of nested constructed elements
+	MaxDepth int `json:"maxDepth"`
+	// max length of contents of one element
+	MaxLength int64 `json:"maxLength"`
+	// max bytes read from reader
+	MaxTotalLength int64 `json:"maxTotalLength"`
+}
+
+type decoderFrame struct {
+	token *Token
+	// end offset of contents, or LENGTH_INDEFINITE
+	end int64
+}
+
+// Decoder reads DER/BER elements from reader as tokens, definite and indefinite lengths are supported
+type Decoder struct {
+	r      io.ByteReader
+	config DecoderConfig
+	offset int64
+	stack  []decoderFrame
+
+	// all bytes read are saved in capture when it is not nil, to rebuild Node
+	capture *bytes.Buffer
+}
+
+func NewDecoder(r io.Reader, config *DecoderConfig) *Decoder {
+	d := &Decoder{}
+	if config != nil {
+		d.config = *config
+	}
+	if d.config.MaxDepth <= 0 {
+		d.config.MaxDepth = DECODER_DEFAULT_MAX_DEPTH
+	}
+	if d.config.MaxLength <= 0 {
+		d.config.MaxLength = DECODER_DEFAULT_MAX_LENGTH
+	}
+	if d.config.MaxTotalLength <= 0 {
+		d.config.MaxTotalLength = DECODER_DEFAULT_MAX_TOTAL_LENGTH
+	}
+	if br, ok := r.(io.ByteReader); ok {
+		d.r = br
+	} else {
+		d.r = bufio.NewReader(r)
+	}
+	return d
+}
+
+// Offset is count of bytes which have been decoded
+func (d *Decoder) Offset() int64 {
+	return d.offset
+}
+
+// Depth is count of constructed elements which are not ended
+func (d *Decoder) Depth() int {
+	return len(d.stack)
+}
+
+// Next gets next token, io.EOF means input ends after complete elements
+func (d *Decoder) Next() (*Token, error) {
+	if len(d.stack) > 0 {
+		top := d.stack[len(d.stack)-1]
+		if top.end != LENGTH_INDEFINITE && d.offset == top.end {
+			return d.pop(d.offset), nil
+		}
+	}
+
+	offset := d.offset
+	header, length, err := d.readHeaderAndLength()
+	if err != nil {
+		if err == io.EOF && d.offset == offset {
+			if len(d.stack) == 0 {
+				return nil, io.EOF
+			}
+			err = io.ErrUnexpectedEOF
+		} else if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		belogs.Debug("Decoder.Next(): readHeaderAndLength fail, offset:", offset, err)
+		return nil, err
+	}
+	headerLength := int(d.offset - offset)
+	if err = d.checkLimit(); err != nil {
+		return nil, err
+	}
+
+	// end-of-contents
+	if header.Class == CLASS_UNIVERSAL && header.Tag == TAG_END_OF_CONTENT && !header.IsCompound {
+		if length != 0 {
+			return nil, errors.New("length of end-of-contents is not 0, offset: " + strconv.FormatInt(offset, 10))
+		}
+		if len(d.stack) == 0 || d.stack[len(d.stack)-1].end != LENGTH_INDEFINITE {
+			return nil, errors.New("end-of-contents is not in indefinite length, offset: " + strconv.FormatInt(offset, 10))
+		}
+		return d.pop(offset), nil
+	}
+
+	token := &Token{
+		Header:       header,
+		Offset:       offset,
+		HeaderLength: headerLength,
+		Length:       length,
+		Depth:        len(d.stack),
+	}
+	if length == LENGTH_INDEFINITE {
+		if !header.IsCompound {
+			return nil, errors.New("indefinite length of primitive, offset: " + strconv.FormatInt(offset, 10))
+		}
+	} else if d.offset+length > d.limit() {
+		return nil, errors.New("length exceeds parent or max total length, offset: " + strconv.FormatInt(offset, 10))
+	}
+
+	if header.IsCompound {
+		if len(d.stack) >= d.config.MaxDepth {
+			return nil, errors.New("depth exceeds max depth " + strconv.Itoa(d.config.MaxDepth) + ", offset: " + strconv.FormatInt(offset, 10))
+		}
+		token.Type = TOKEN_TYPE_START_CONSTRUCTED
+		end := int64(LENGTH_INDEFINITE)
+		if length != LENGTH_INDEFINITE {
+			end = d.offset + length
+		}
+		d.stack = append(d.stack, decoderFrame{token: token, end: end})
+		return token, nil
+	}
+
+	token.Type = TOKEN_TYPE_PRIMITIVE
+	data := bytes.NewBuffer(make([]byte, 0, min(length, 4096)))
+	for i := int64(0); i < length; i++ {
+		b, err := d.readByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			belogs.Debug("Decoder.Next(): read data fail, offset:", offset, "  length:", length, err)
+			return nil, err
+		}
+		data.WriteByte(b)
+	}
+	token.Data = data.Bytes()
+	return token, nil
+}
+
+// Skip skips contents of the constructed element of last START_CONSTRUCTED token, and its END_CONSTRUCTED token
+func (d *Decoder) Skip() error {
+	depth := len(d.stack)
+	for len(d.stack) >= depth && depth > 0 {
+		if _, err := d.Next(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DecodeNode reads next complete element and rebuilds Node. FullData of Node is its encoding,
+// and Data of constructed Node is its contents (without end-of-contents)
+func (d *Decoder) DecodeNode() (*Node, error) {
+	if len(d.stack) > 0 {
+		return nil, errors.New("decoder is in constructed element")
+	}
+	base := d.offset
+	d.capture = new(bytes.Buffer)
+	defer func() { d.capture = nil }()
+
+	nodes := make([]*Node, 0)
+	var root *Node
+	for {
+		token, err := d.Next()
+		if err != nil {
+			return nil, err
+		}
+		switch token.Type {
+		case TOKEN_TYPE_START_CONSTRUCTED:
+			n := NewNode(token.Header.Class, token.Header.Tag)
+			n.constructed = true
+			if len(nodes) > 0 {
+				parent := nodes[len(nodes)-1]
+				parent.Nodes = append(parent.Nodes, n)
+			}
+			nodes = append(nodes, n)
+		case TOKEN_TYPE_PRIMITIVE:
+			n := NewNode(token.Header.Class, token.Header.Tag)
+			if err = decodeValue(token.Data, n); err != nil {
+				belogs.Debug("Decoder.DecodeNode(): decodeValue fail, offset:", token.Offset, err)
+				return nil, err
+			}
+			n.FullData = d.getCaptured(base, token.Offset, d.offset)
+			if len(nodes) == 0 {
+				return n, nil
+			}
+			parent := nodes[len(nodes)-1]
+			parent.Nodes = append(parent.Nodes, n)
+		case TOKEN_TYPE_END_CONSTRUCTED:
+			n := nodes[len(nodes)-1]
+			nodes = nodes[:len(nodes)-1]
+			contentOffset := token.Offset + int64(token.HeaderLength)
+			n.FullData = d.getCaptured(base, token.Offset, token.EndOffset)
+			n.Data = d.getCaptured(base, contentOffset, contentOffset+token.Length)
+			root = n
+		}
+		if len(nodes) == 0 && root != nil {
+			return root, nil
+		}
+	}
+}
+
+// ParseReader reads one element from reader and rebuilds Node, config is nil to use defaults
+func ParseReader(r io.Reader, config *DecoderConfig) (*Node, error) {
+	n, err := NewDecoder(r, config).DecodeNode()
+	if err != nil {
+		belogs.Error("ParseReader(): DecodeNode fail:", err)
+		return nil, err
+	}
+	return n, nil
+}
+
+// pop ends top constructed element, contentEnd is where contents end (before end-of-contents)
+func (d *Decoder) pop(contentEnd int64) *Token {
+	top := d.stack[len(d.stack)-1]
+	d.stack = d.stack[:len(d.stack)-1]
+	return &Token{
+		Type:         TOKEN_TYPE_END_CONSTRUCTED,
+		Header:       top.token.Header,
+		Offset:       top.token.Offset,
+		HeaderLength: top.token.HeaderLength,
+		Length:       contentEnd - top.token.Offset - int64(top.token.HeaderLength),
+		Depth:        top.token.Depth,
+		EndOffset:    d.offset,
+	}
+}
+
+// limit is end of innermost definite length element, or max total length
+func (d *Decoder) limit() int64 {
+	for i := len(d.stack) - 1; i >= 0; i-- {
+		if d.stack[i].end != LENGTH_INDEFINITE {
+			return d.stack[i].end
+		}
+	}
+	return d.config.MaxTotalLength
+}
+
+func (d *Decoder) checkLimit() error {
+	if d.offset > d.limit() {
+		return errors.New("element exceeds parent or max total length, offset: " + strconv.FormatInt(d.offset, 10))
+	}
+	return nil
+}
+
+func (d *Decoder) getCaptured(base, start, end int64) []byte {
+	b := make([]byte, end-start)
+	copy(b, d.capture.Bytes()[start-base:end-base])
+	return b
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	d.offset++
+	if d.capture != nil {
+		d.capture.WriteByte(b)
+	}
+	return b, nil
+}
+
+// readHeaderAndLength is same as DecodeHeader and DecodeLength, but reads from reader.
+// length is LENGTH_INDEFINITE for 0x80
+func (d *Decoder) readHeaderAndLength() (header Header, length int64, err error) {
+	b, err := d.readByte()
+	if err != nil {
+		return header, 0, err
+	}
+	header.Class = int(b >> 6)
+	header.IsCompound = (b & 0x20) == 0x20
+	header.Tag = int(b & 0x1F)
+	if header.Tag == 0x1F {
+		// high-tag-number form
+		header.Tag = 0
+		for i := 0; ; i++ {
+			if i > 4 {
+				return header, 0, errors.New("tag is too big")
+			}
+			if b, err = d.readByte(); err != nil {
+				return header, 0, err
+			}
+			header.Tag = (header.Tag << 7) | int(b&0x7F)
+			if (b & 0x80) == 0 {
+				break
+			}
+		}
+	}
+
+	if b, err = d.readByte(); err != nil {
+		return header, 0, err
+	}
+	if (b & 0x80) == 0 {
+		length = int64(b)
+		if length > d.config.MaxLength {
+			return header, 0, errors.New("length exceeds max length " + strconv.FormatInt(d.config.MaxLength, 10))
+		}
+		return header, length, nil
+	}
+	count := int(b & 0x7F)
+	if count == 0 {
+		return header, LENGTH_INDEFINITE, nil
+	}
+	if count > 8 {
+		return header, 0, errors.New("length is too long, count of length bytes: " + strconv.Itoa(count))
+	}
+	for i := 0; i < count; i++ {
+		if b, err = d.readByte(); err != nil {
+			return header, 0, err
+		}
+		if length > (d.config.MaxLength >> 8) {
+			return header, 0, errors.New("length exceeds max length " + strconv.FormatInt(d.config.MaxLength, 10))
+		}
+		length = (length << 8) | int64(b)
+	}
+	if length > d.config.MaxLength {
+		return header, 0, errors.New("length exceeds max length " + strconv.FormatInt(d.config.MaxLength, 10))
+	}
+	return header, length, nil
+}
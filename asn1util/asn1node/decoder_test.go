@@ -0,0 +1,124 @@
+package asn1node
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/cpusoft/goutil/jsonutil"
+)
+
+func TestDecoderNext(t *testing.T) {
+	// SEQUENCE(indefinite) { OCTET STRING aabb, OCTET STRING(constructed, indefinite) { cc, dd }, [0] { INTEGER 1 } }, NULL
+	data, _ := hex.DecodeString("30800402aabb24800401cc0401dd0000a003020101000005000500")
+	d := NewDecoder(bytes.NewReader(data), nil)
+	tokens := make([]string, 0)
+	for {
+		token, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Println(jsonutil.MarshalJson(token))
+		tokens = append(tokens, fmt.Sprintf("%s:%d:%d:%d", token.Type, token.Depth, token.Offset, token.Length))
+	}
+	expect := "[startConstructed:0:0:-1 primitive:1:2:2 startConstructed:1:6:-1 primitive:2:8:1 primitive:2:11:1 " +
+		"endConstructed:1:6:6 startConstructed:1:16:3 primitive:2:18:1 endConstructed:1:16:3 endConstructed:0:0:19 " +
+		"primitive:0:23:0 primitive:0:25:0]"
+	if fmt.Sprint(tokens) != expect {
+		t.Fatal("tokens are wrong:", tokens)
+	}
+}
+
+func TestParseReader(t *testing.T) {
+	// same as ParseBytes for der
+	sigStr := `30819C3014A1123010300E04010230090307002001067C208C300B06096086480165030402013077303416106234325F697076365F6C6F612E706E6704209516DD64BE7C1725B9FCA117120E58E8D842A5206873399B3DDFFC91C4B6ACF0303F161B6234325F736572766963655F646566696E6974696F6E2E6A736F6E04200AE1394722005CD92F4C6AA024D5D6B3E2E67D629F11720D9478A633A117A1C7`
+	data, _ := hex.DecodeString(sigStr)
+	n1, err := ParseBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n2, err := ParseReader(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(n2.FullData, data) || !equalNodes(n1, n2) {
+		t.Fatal("nodes are different:", jsonutil.MarshalJson(n2))
+	}
+	if n2.Nodes[2].Nodes[0].Nodes[0].Value != "b42_ipv6_loa.png" {
+		t.Fatal("value is wrong:", jsonutil.MarshalJson(n2.Nodes[2].Nodes[0]))
+	}
+
+	// ber indefinite length
+	data, _ = hex.DecodeString("30800402aabb24800401cc0401dd00000000")
+	n, err := ParseReader(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(jsonutil.MarshalJson(n))
+	if !bytes.Equal(n.FullData, data) || hex.EncodeToString(n.Data) != "0402aabb24800401cc0401dd0000" ||
+		len(n.Nodes) != 2 || hex.EncodeToString(n.Nodes[1].Data) != "0401cc0401dd" || len(n.Nodes[1].Nodes) != 2 {
+		t.Fatal("node is wrong:", jsonutil.MarshalJson(n))
+	}
+}
+
+func TestDecoderLimits(t *testing.T) {
+	deep := append(bytes.Repeat([]byte{0x30, 0x80}, 70), bytes.Repeat([]byte{0x00, 0x00}, 70)...)
+	for name, hexStr := range map[string]string{
+		"too deep":               hex.EncodeToString(deep),
+		"too long":               "04847fffffff00",
+		"truncated":              "300604020102",
+		"truncated header":       "3082",
+		"eoc at top":             "0000",
+		"eoc in definite":        "30020000",
+		"indefinite primitive":   "0480aa0000",
+		"child exceeds parent":   "3003040501020304",
+		"indefinite in definite": "30043080050005000000",
+		"too many length bytes":  "04890000000000000000000001",
+	} {
+		data, _ := hex.DecodeString(hexStr)
+		_, err := ParseReader(bytes.NewReader(data), nil)
+		fmt.Println(name, ":", err)
+		if err == nil {
+			t.Fatal("should fail:", name)
+		}
+	}
+
+	data, _ := hex.DecodeString("3006040401020304")
+	if _, err := ParseReader(bytes.NewReader(data), &DecoderConfig{MaxLength: 3}); err == nil {
+		t.Fatal("should fail by max length")
+	}
+	if _, err := ParseReader(bytes.NewReader(data), &DecoderConfig{MaxTotalLength: 7}); err == nil {
+		t.Fatal("should fail by max total length")
+	}
+	if _, err := ParseReader(bytes.NewReader(data), &DecoderConfig{MaxDepth: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// skip
+	data, _ = hex.DecodeString("30803003020101000005000500")
+	d := NewDecoder(bytes.NewReader(data), nil)
+	if _, err := d.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Skip(); err != nil || d.Offset() != 9 || d.Depth() != 0 {
+		t.Fatal("skip is wrong:", d.Offset(), err)
+	}
+}
+
+func equalNodes(a, b *Node) bool {
+	if a.class != b.class || a.tag != b.tag || a.constructed != b.constructed ||
+		!bytes.Equal(a.Data, b.Data) || fmt.Sprint(a.Value) != fmt.Sprint(b.Value) || len(a.Nodes) != len(b.Nodes) {
+		return false
+	}
+	for i := range a.Nodes {
+		if !equalNodes(a.Nodes[i], b.Nodes[i]) {
+			return false
+		}
+	}
+	return true
+}
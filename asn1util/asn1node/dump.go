@@ -0,0 +1,346 @@
+package asn1node
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/cpusoft/goutil/jsonutil"
+)
+
+/*
+Dump is like dumpasn1, offset and length of contents are before every line:
+
+	    0   412: SEQUENCE {
+	    4   261:   SEQUENCE {
+	    8     3:     [0] {
+	   10     1:       INTEGER 2
+	           :       }
+	   13     1:     INTEGER 1
+	   16    13:     SEQUENCE {
+	   18     9:       OBJECT IDENTIFIER sha256WithRSAEncryption (1.2.840.113549.1.1.11)
+	...
+*/
+
+// bytes in one line of hex in Dump
+const dumpHexLineBytes = 16
+
+// NodeDump is decoded Node for Dump and DumpJson.
+// Offset and HeaderLength are not in json, so two objects can be diffed by json
+type NodeDump struct {
+	Offset       int `json:"-"`
+	HeaderLength int `json:"-"`
+	// length of contents
+	Length      int    `json:"length"`
+	Class       string `json:"class"`
+	Tag         int    `json:"tag"`
+	Name        string `json:"name"`
+	Constructed bool   `json:"constructed,omitempty"`
+	// decoded value, such as integer, oid, string and time
+	Value   string `json:"value,omitempty"`
+	OidName string `json:"oidName,omitempty"`
+	// contents which cannot be decoded as value
+	Hex   string      `json:"hex,omitempty"`
+	Nodes []*NodeDump `json:"nodes,omitempty"`
+	// der in OCTET STRING or BIT STRING
+	Encapsulates *NodeDump `json:"encapsulates,omitempty"`
+}
+
+var classNames = []string{"universal", "application", "context", "private"}
+
+var universalTagNames = map[int]string{
+	TAG_END_OF_CONTENT:   "EOC",
+	TAG_BOOLEAN:          "BOOLEAN",
+	TAG_INTEGER:          "INTEGER",
+	TAG_BIT_STRING:       "BIT STRING",
+	TAG_OCTET_STRING:     "OCTET STRING",
+	TAG_NULL:             "NULL",
+	TAG_OID:              "OBJECT IDENTIFIER",
+	7:                    "ObjectDescriptor",
+	8:                    "EXTERNAL",
+	TAG_REAL:             "REAL",
+	TAG_ENUMERATED:       "ENUMERATED",
+	TAG_UTF8_STRING:      "UTF8String",
+	TAG_TIME:             "TIME",
+	TAG_SEQUENCE:         "SEQUENCE",
+	TAG_SET:              "SET",
+	TAG_NUMBERIC_STRING:  "NumericString",
+	TAG_PRINTABLE_STRING: "PrintableString",
+	TAG_T61_STRING:       "TeletexString",
+	TAG_VIDEOTEX_STRING:  "VideotexString",
+	TAG_IA5_STRING:       "IA5String",
+	TAG_UTC_TIME:         "UTCTime",
+	TAG_GENERALIZED_TIME: "GeneralizedTime",
+	26:                   "VisibleString",
+	27:                   "GeneralString",
+	28:                   "UniversalString",
+	TAG_BMP_STRING:       "BMPString",
+}
+
+// GetNodeDump decodes n and its children, offset of n is 0
+func GetNodeDump(n *Node) (*NodeDump, error) {
+	if n == nil {
+		return nil, errors.New("node is nil")
+	}
+	nodeDump, _, err := getNodeDump(n, 0)
+	return nodeDump, err
+}
+
+// Dump gets indented tree of n as dumpasn1
+func (n *Node) Dump() (string, error) {
+	nodeDump, err := GetNodeDump(n)
+	if err != nil {
+		return "", err
+	}
+	var buffer strings.Builder
+	nodeDump.writeTo(&buffer, 0)
+	return buffer.String(), nil
+}
+
+// DumpJson gets indented json of n, which is without offsets to diff two objects
+func (n *Node) DumpJson() (string, error) {
+	nodeDump, err := GetNodeDump(n)
+	if err != nil {
+		return "", err
+	}
+	return jsonutil.MarshalJsonIndent(nodeDump), nil
+}
+
+// getNodeDump returns dump and total length of encoding of n
+func getNodeDump(n *Node, offset int) (*NodeDump, int, error) {
+	headerLength, length, totalLength, err := getNodeLength(n)
+	if err != nil {
+		return nil, 0, err
+	}
+	nodeDump := &NodeDump{
+		Offset:       offset,
+		HeaderLength: headerLength,
+		Length:       length,
+		Class:        classNames[n.class&0x03],
+		Tag:          n.tag,
+		Name:         getTagName(n.class, n.tag),
+		Constructed:  n.constructed,
+	}
+	if n.constructed {
+		childOffset := offset + headerLength
+		for _, child := range n.Nodes {
+			childDump, childLength, err := getNodeDump(child, childOffset)
+			if err != nil {
+				return nil, 0, err
+			}
+			nodeDump.Nodes = append(nodeDump.Nodes, childDump)
+			childOffset += childLength
+		}
+		return nodeDump, totalLength, nil
+	}
+	nodeDump.setValue(n, offset+headerLength)
+	return nodeDump, totalLength, nil
+}
+
+// getNodeLength gets from FullData, or from encoding when n is created by NewNode
+func getNodeLength(n *Node) (headerLength, length, totalLength int, err error) {
+	if len(n.FullData) == 0 {
+		value, err := encodeValue(n)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		header := n.getHeader()
+		data, err := EncodeHeader(nil, &header)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if data, err = EncodeLength(data, len(value)); err != nil {
+			return 0, 0, 0, err
+		}
+		return len(data), len(value), len(data) + len(value), nil
+	}
+
+	var header Header
+	rest, err := DecodeHeader(n.FullData, &header)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if len(rest) > 0 && rest[0] == 0x80 {
+		// indefinite length, Data is contents without end-of-contents
+		headerLength = len(n.FullData) - len(rest) + 1
+		return headerLength, len(n.Data), headerLength + len(n.Data) + 2, nil
+	}
+	if rest, err = DecodeLength(rest, &length); err != nil {
+		return 0, 0, 0, err
+	}
+	headerLength = len(n.FullData) - len(rest)
+	return headerLength, length, headerLength + length, nil
+}
+
+func getTagName(class, tag int) string {
+	switch class {
+	case CLASS_UNIVERSAL:
+		if name, ok := universalTagNames[tag]; ok {
+			return name
+		}
+		return "[UNIVERSAL " + strconv.Itoa(tag) + "]"
+	case CLASS_APPLICATION:
+		return "[APPLICATION " + strconv.Itoa(tag) + "]"
+	case CLASS_CONTEXT_SPECIFIC:
+		return "[" + strconv.Itoa(tag) + "]"
+	}
+	return "[PRIVATE " + strconv.Itoa(tag) + "]"
+}
+
+// setValue decodes primitive, and it is Hex when it cannot be decoded
+func (d *NodeDump) setValue(n *Node, contentOffset int) {
+	data := n.Data
+	if n.class != CLASS_UNIVERSAL {
+		d.Hex = hex.EncodeToString(data)
+		return
+	}
+	switch n.tag {
+	case TAG_NULL, TAG_END_OF_CONTENT:
+		return
+	case TAG_BOOLEAN:
+		if b, err := n.GetBool(); err == nil {
+			d.Value = strings.ToUpper(strconv.FormatBool(b))
+			return
+		}
+	case TAG_INTEGER, TAG_ENUMERATED:
+		// long integer, such as modulus, is hex
+		if i, err := n.GetBigInt(); err == nil && len(data) <= 8 {
+			d.Value = i.String()
+			return
+		}
+	case TAG_OID:
+		if oid, err := n.GetOid(); err == nil {
+			d.Value = oid
+			d.OidName, _ = GetOidName(oid)
+			return
+		}
+	case TAG_UTC_TIME:
+		if t, err := n.GetUTCTime(); err == nil {
+			d.Value = t.UTC().Format("2006-01-02 15:04:05 UTC")
+			return
+		}
+	case TAG_GENERALIZED_TIME:
+		if t, err := n.GetGeneralizedTime(); err == nil {
+			d.Value = t.UTC().Format("2006-01-02 15:04:05 UTC")
+			return
+		}
+	case TAG_UTF8_STRING, TAG_NUMBERIC_STRING, TAG_PRINTABLE_STRING, TAG_T61_STRING, TAG_VIDEOTEX_STRING,
+		TAG_IA5_STRING, 26, 27:
+		if utf8.Valid(data) {
+			d.Value = string(data)
+			return
+		}
+	case TAG_BMP_STRING:
+		if len(data)%2 == 0 {
+			u := make([]uint16, 0, len(data)/2)
+			for i := 0; i < len(data); i += 2 {
+				u = append(u, uint16(data[i])<<8|uint16(data[i+1]))
+			}
+			d.Value = string(utf16.Decode(u))
+			return
+		}
+	case TAG_BIT_STRING:
+		if len(data) > 0 {
+			if data[0] != 0 {
+				d.Value = strconv.Itoa(int(data[0])) + " unused bits"
+			} else if d.Encapsulates = getEncapsulated(data[1:], contentOffset+1); d.Encapsulates != nil {
+				return
+			}
+			d.Hex = hex.EncodeToString(data[1:])
+			return
+		}
+	case TAG_OCTET_STRING:
+		if d.Encapsulates = getEncapsulated(data, contentOffset); d.Encapsulates != nil {
+			return
+		}
+	}
+	d.Hex = hex.EncodeToString(data)
+}
+
+// getEncapsulated gets dump when data is just one der element, such as eContent and value of extension
+func getEncapsulated(data []byte, offset int) *NodeDump {
+	if len(data) < 2 {
+		return nil
+	}
+	// constructed, or primitive which is often encapsulated
+	switch data[0] {
+	case 0x01, 0x02, 0x03, 0x04, 0x06:
+	default:
+		if data[0]&0x20 == 0 {
+			return nil
+		}
+	}
+	d := NewDecoder(bytes.NewReader(data), &DecoderConfig{MaxTotalLength: int64(len(data))})
+	n, err := d.DecodeNode()
+	if err != nil || d.Offset() != int64(len(data)) {
+		return nil
+	}
+	nodeDump, _, err := getNodeDump(n, offset)
+	if err != nil {
+		return nil
+	}
+	return nodeDump
+}
+
+func (d *NodeDump) writeTo(buffer *strings.Builder, depth int) {
+	indent := strings.Repeat("  ", depth)
+	line := d.Name
+	if len(d.OidName) > 0 {
+		line += " " + d.OidName + " (" + d.Value + ")"
+	} else if len(d.Value) > 0 {
+		switch d.Tag {
+		case TAG_UTF8_STRING, TAG_NUMBERIC_STRING, TAG_PRINTABLE_STRING, TAG_T61_STRING, TAG_VIDEOTEX_STRING,
+			TAG_IA5_STRING, 26, 27, TAG_BMP_STRING:
+			line += " '" + d.Value + "'"
+		default:
+			line += " " + d.Value
+		}
+	}
+	if d.Encapsulates != nil {
+		line += ", encapsulates"
+	}
+	if len(d.Hex) > 0 && len(d.Hex) <= 2*dumpHexLineBytes {
+		line += " " + formatHex(d.Hex)
+	}
+	if d.Constructed || d.Encapsulates != nil {
+		line += " {"
+	}
+	buffer.WriteString(padLeft(strconv.Itoa(d.Offset), 5) + " " + padLeft(strconv.Itoa(d.Length), 5) + ": " + indent + line + "\n")
+
+	if len(d.Hex) > 2*dumpHexLineBytes {
+		for i := 0; i < len(d.Hex); i += 2 * dumpHexLineBytes {
+			end := min(i+2*dumpHexLineBytes, len(d.Hex))
+			buffer.WriteString("           : " + indent + "  " + formatHex(d.Hex[i:end]) + "\n")
+		}
+	}
+	for _, child := range d.Nodes {
+		child.writeTo(buffer, depth+1)
+	}
+	if d.Encapsulates != nil {
+		d.Encapsulates.writeTo(buffer, depth+1)
+	}
+	if d.Constructed || d.Encapsulates != nil {
+		buffer.WriteString("           : " + indent + "  }\n")
+	}
+}
+
+// formatHex: "0a0b" -> "0A 0B"
+func formatHex(h string) string {
+	h = strings.ToUpper(h)
+	parts := make([]string, 0, len(h)/2)
+	for i := 0; i+1 < len(h); i += 2 {
+		parts = append(parts, h[i:i+2])
+	}
+	return strings.Join(parts, " ")
+}
+
+func padLeft(s string, width int) string {
+	if len(s) >= width {
+		return s
+	}
+	return strings.Repeat(" ", width-len(s)) + s
+}
@@ -0,0 +1,143 @@
+package asn1node
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cpusoft/goutil/asn1util/asn1addressasn"
+)
+
+func newDumpTestCert(t *testing.T, cn string, key *ecdsa.PrivateKey) []byte {
+	_, ipNet, _ := net.ParseCIDR("10.0.0.0/8")
+	ipExtension, err := asn1addressasn.EncodeIPAddressBlock([]asn1addressasn.IPCertificateInformation{&asn1addressasn.IPNet{IPNet: ipNet}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(0x0102),
+		Subject:         pkix.Name{CommonName: cn},
+		NotBefore:       time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:        time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		SubjectKeyId:    []byte{1, 2, 3},
+		ExtraExtensions: []pkix.Extension{*ipExtension},
+	}
+	b, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDump(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	n, err := ParseBytes(newDumpTestCert(t, "dump test", key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := n.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(s)
+	for _, expect := range []string{
+		"    0 ", ": SEQUENCE {",
+		"INTEGER 258",
+		"OBJECT IDENTIFIER ecdsaWithSHA256 (1.2.840.10045.4.3.2)",
+		"OBJECT IDENTIFIER commonName (2.5.4.3)",
+		"PrintableString 'dump test'",
+		"UTCTime 2024-01-01 00:00:00 UTC",
+		"OBJECT IDENTIFIER ipAddrBlocks (1.3.6.1.5.5.7.1.7)",
+		"OCTET STRING, encapsulates {",
+		"BIT STRING 0A",
+		"OBJECT IDENTIFIER subjectKeyIdentifier (2.5.29.14)",
+		"OCTET STRING 01 02 03",
+	} {
+		if !strings.Contains(s, expect) {
+			t.Fatal("dump should contain:", expect)
+		}
+	}
+	// offsets are same as der
+	nodeDump, err := GetNodeDump(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbs := nodeDump.Nodes[0]
+	if tbs.Offset != 4 || tbs.Nodes[1].Offset != tbs.Offset+tbs.HeaderLength+5 || tbs.Nodes[1].Value != "258" {
+		t.Fatal("offsets are wrong:", tbs.Offset, tbs.Nodes[1].Offset)
+	}
+
+	// json of two certificates are different just in cn (issuer and subject) and signature
+	j1, _ := n.DumpJson()
+	n2, _ := ParseBytes(newDumpTestCert(t, "dump tesu", key))
+	j2, _ := n2.DumpJson()
+	lines1, lines2 := strings.Split(j1, "\n"), strings.Split(j2, "\n")
+	if len(lines1) != len(lines2) {
+		t.Fatal("lines of json should be same")
+	}
+	diffs := make([]string, 0)
+	for i := range lines1 {
+		if lines1[i] != lines2[i] && !strings.Contains(lines2[i], `"length"`) && !strings.Contains(lines2[i], `"hex"`) {
+			diffs = append(diffs, strings.TrimSpace(lines2[i]))
+		}
+	}
+	fmt.Println(diffs)
+	if len(diffs) != 2 || diffs[0] != `"value": "dump tesu"` {
+		t.Fatal("diffs should be cn:", diffs)
+	}
+}
+
+func TestDumpNode(t *testing.T) {
+	oid := NewNode(CLASS_UNIVERSAL, TAG_OID)
+	oid.SetBytes([]byte{0x55, 0x1d, 0x20, 0x00})
+	str := NewNode(CLASS_UNIVERSAL, TAG_BMP_STRING)
+	str.SetBytes([]byte{0x00, 0x61, 0x00, 0x62})
+	ctx := NewNode(CLASS_CONTEXT_SPECIFIC, 1)
+	ctx.SetBytes([]byte{0xff})
+	seq := NewNode(CLASS_UNIVERSAL, TAG_SEQUENCE)
+	seq.SetNodes([]*Node{oid, str, ctx})
+
+	s, err := seq.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(s)
+	expect := "    0    15: SEQUENCE {\n" +
+		"    2     4:   OBJECT IDENTIFIER anyPolicy (2.5.29.32.0)\n" +
+		"    8     4:   BMPString 'ab'\n" +
+		"   14     1:   [1] FF\n" +
+		"           :   }\n"
+	if s != expect {
+		t.Fatal("dump is wrong:\n" + s)
+	}
+
+	RegisterOidName("1.2.3.4", "testOid")
+	oid.SetBytes([]byte{0x2a, 0x03, 0x04})
+	if name, ok := GetOidName("1.2.3.4"); !ok || name != "testOid" {
+		t.Fatal("registry is wrong")
+	}
+	nodeDump, _ := GetNodeDump(oid)
+	if nodeDump.OidName != "testOid" {
+		t.Fatal("oid name is wrong:", nodeDump.OidName)
+	}
+
+	// long oid arc
+	data, _ := hex.DecodeString("2b0601040182373c0201")
+	oid.SetBytes(data)
+	if v, _ := oid.GetOid(); v != "1.3.6.1.4.1.311.60.2.1" {
+		t.Fatal("oid is wrong:", v)
+	}
+	oid.SetBytes([]byte{0x2b, 0x86})
+	if _, err = oid.GetOid(); err == nil {
+		t.Fatal("truncated oid should fail")
+	}
+}
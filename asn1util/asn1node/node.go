@@ -384,36 +384,36 @@ func (n *Node) GetOid() (string, error) {
 	if n.constructed {
 		return "", ErrNodeIsConstructed
 	}
-	oids := make([]uint32, len(n.Data)+2)
-	//the first byte using: first_arc* 40+second_arc
+	if len(n.Data) == 0 || n.Data[len(n.Data)-1] >= 0x80 {
+		return "", errors.New("oid is invalid")
+	}
+	//the first arcs using: first_arc* 40+second_arc
 	//the later , when highest bit is 1, will add to next to calc
 	// https://msdn.microsoft.com/en-us/library/windows/desktop/bb540809(v=vs.85).aspx
-	f := uint32(n.Data[0])
-	if f < 80 {
-		oids[0] = f / 40
-		oids[1] = f % 40
-	} else {
-		oids[0] = 2
-		oids[1] = f - 80
-	}
-	var tmp uint32
-	for i := 2; i <= len(n.Data); i++ {
-		f = uint32(n.Data[i-1])
-		if f >= 0x80 {
-			tmp = tmp<<7 + (f & 0x7f)
-		} else {
-			oids[i] = tmp<<7 + (f & 0x7f)
-			tmp = 0
-		}
-	}
 	var buffer bytes.Buffer
-	for i := 0; i < len(oids); i++ {
-		if oids[i] == 0 {
+	var arc uint64
+	first := true
+	for _, b := range n.Data {
+		if arc > (1<<57)-1 {
+			return "", errors.New("arc of oid is too big")
+		}
+		arc = arc<<7 | uint64(b&0x7f)
+		if b >= 0x80 {
 			continue
 		}
-		buffer.WriteString(fmt.Sprint(oids[i]) + ".")
+		if first {
+			if arc < 80 {
+				buffer.WriteString(fmt.Sprint(arc/40) + "." + fmt.Sprint(arc%40))
+			} else {
+				buffer.WriteString("2." + fmt.Sprint(arc-80))
+			}
+			first = false
+		} else {
+			buffer.WriteString("." + fmt.Sprint(arc))
+		}
+		arc = 0
 	}
-	return buffer.String()[0 : len(buffer.String())-1], nil
+	return buffer.String(), nil
 }
 
 func (n *Node) GetReal() (float64, error) {
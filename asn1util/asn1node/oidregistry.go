@@ -0,0 +1,98 @@
+package asn1node
+
+import (
+	"sync"
+
+	"github.com/cpusoft/goutil/asn1util/asn1addressasn"
+)
+
+// oidNames: oid -> name, used by Dump
+var (
+	oidNamesMutex sync.RWMutex
+	oidNames      = map[string]string{
+		// x509
+		"2.5.4.3":     "commonName",
+		"2.5.4.5":     "serialNumber",
+		"2.5.4.6":     "countryName",
+		"2.5.4.7":     "localityName",
+		"2.5.4.8":     "stateOrProvinceName",
+		"2.5.4.10":    "organizationName",
+		"2.5.4.11":    "organizationalUnitName",
+		"2.5.29.15":   "keyUsage",
+		"2.5.29.17":   "subjectAltName",
+		"2.5.29.19":   "basicConstraints",
+		"2.5.29.20":   "cRLNumber",
+		"2.5.29.31":   "cRLDistributionPoints",
+		"2.5.29.37":   "extKeyUsage",
+		"2.5.29.32.0": "anyPolicy",
+		// algorithms
+		"1.2.840.113549.1.1.1":   "rsaEncryption",
+		"1.2.840.113549.1.1.11":  "sha256WithRSAEncryption",
+		"1.2.840.113549.1.1.12":  "sha384WithRSAEncryption",
+		"1.2.840.113549.1.1.13":  "sha512WithRSAEncryption",
+		"1.2.840.10045.2.1":      "ecPublicKey",
+		"1.2.840.10045.3.1.7":    "prime256v1",
+		"1.2.840.10045.4.3.2":    "ecdsaWithSHA256",
+		"1.3.101.112":            "Ed25519",
+		"2.16.840.1.101.3.4.2.1": "sha256",
+		"2.16.840.1.101.3.4.2.2": "sha384",
+		"2.16.840.1.101.3.4.2.3": "sha512",
+		// cms
+		"1.2.840.113549.1.7.1":       "data",
+		"1.2.840.113549.1.7.2":       "signedData",
+		"1.2.840.113549.1.9.3":       "contentType",
+		"1.2.840.113549.1.9.4":       "messageDigest",
+		"1.2.840.113549.1.9.5":       "signingTime",
+		"1.2.840.113549.1.9.16.2.46": "binarySigningTime",
+		"1.2.840.113549.1.9.16.1.24": "routeOriginAuthz",
+		"1.2.840.113549.1.9.16.1.35": "rpkiGhostbusters",
+		"1.2.840.113549.1.9.16.1.47": "geofeedCSVwithCRLF",
+		"1.2.840.113549.1.9.16.1.48": "signedChecklist",
+		"1.2.840.113549.1.9.16.1.49": "rpkiASPA",
+		"1.2.840.113549.1.9.16.1.50": "signedTAL",
+		"1.3.6.1.5.5.7.1.30":         "ipAddrAndASIdentV2",
+		"1.3.6.1.5.5.7.14.3":         "ipAddrAsNumberV2",
+		"1.3.6.1.5.5.7.48.1":         "ocsp",
+		"1.3.6.1.5.5.7.3.30":         "bgpsecRouter",
+	}
+)
+
+func init() {
+	// rpki oids declared in asn1addressasn
+	for name, oid := range map[string]string{
+		"ipAddrBlocks":           asn1addressasn.IpAddrBlock.String(),
+		"autonomousSysIds":       asn1addressasn.AutonomousSysIds.String(),
+		"ipAddrBlocksV2":         asn1addressasn.IpAddrBlockV2.String(),
+		"autonomousSysIdsV2":     asn1addressasn.AutonomousSysIdsV2.String(),
+		"certificatePolicies":    asn1addressasn.CertPolicy.String(),
+		"ipAddrAsNumber":         asn1addressasn.ResourceCertPolicy.String(),
+		"cps":                    asn1addressasn.CPS.String(),
+		"subjectInfoAccess":      asn1addressasn.SubjectInfoAccess.String(),
+		"authorityInfoAccess":    asn1addressasn.AuthorityInfoAccess.String(),
+		"caIssuers":              asn1addressasn.CAIssuer.String(),
+		"signedObject":           asn1addressasn.SignedObject.String(),
+		"subjectKeyIdentifier":   asn1addressasn.SubjectKeyIdentifier.String(),
+		"authorityKeyIdentifier": asn1addressasn.AuthorityKeyIdentifier.String(),
+		"caRepository":           asn1addressasn.CertRepository.String(),
+		"rpkiNotify":             asn1addressasn.CertRRDP.String(),
+		"rpkiManifest":           asn1addressasn.SIAManifest.String(),
+		"rpkiManifestContent":    asn1addressasn.ManifestOID.String(),
+	} {
+		oidNames[oid] = name
+	}
+}
+
+// RegisterOidName adds or replaces name of oid, such as "1.2.840.113549.1.9.16.1.24" -> "routeOriginAuthz"
+func RegisterOidName(oid, name string) {
+	oidNamesMutex.Lock()
+	defer oidNamesMutex.Unlock()
+	oidNames[oid] = name
+}
+
+// GetOidName gets name of oid, ok is false when it is not registered
+func GetOidName(oid string) (name string, ok bool) {
+	oidNamesMutex.RLock()
+	defer oidNamesMutex.RUnlock()
+	name, ok = oidNames[oid]
+	return name, ok
+}
@@ -342,6 +342,43 @@ func VerifyRootCerByOpenssl(rootFile string) (result string, err error) {
 	return "ok", nil
 }
 
+// same as VerifyRootCerByOpenssl, but no need of openssl. rootFile may be DER or PEM
+// result: ok/fail
+func VerifyRootCerByX509(rootFile string) (result string, err error) {
+	belogs.Debug("VerifyRootCerByX509():rootFile", rootFile)
+	rootCert, err := ReadFileToCer(rootFile)
+	if err != nil {
+		belogs.Error("VerifyRootCerByX509(): ReadFileToCer fail: ", err, rootFile)
+		return "fail", fmt.Errorf("failed to read root certificate: %w", err)
+	}
+	// -check_ss_sig
+	if !bytes.Equal(rootCert.RawIssuer, rootCert.RawSubject) {
+		belogs.Error("VerifyRootCerByX509(): issuer is not equal to subject, issuer:", rootCert.Issuer.String(),
+			"   subject:", rootCert.Subject.String(), rootFile)
+		return "fail", errors.New("root certificate is not self-issued")
+	}
+	err = rootCert.CheckSignature(rootCert.SignatureAlgorithm, rootCert.RawTBSCertificate, rootCert.Signature)
+	if err != nil {
+		belogs.Error("VerifyRootCerByX509(): CheckSignature fail: ", err, rootFile)
+		return "fail", fmt.Errorf("self signature verification failed: %w", err)
+	}
+
+	// -CAfile rootFile rootFile
+	rootCert.UnhandledCriticalExtensions = make([]asn1.ObjectIdentifier, 0)
+	rootPool := x509.NewCertPool()
+	rootPool.AddCert(rootCert)
+	opts := x509.VerifyOptions{
+		Roots:     rootPool,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	if _, err = rootCert.Verify(opts); err != nil {
+		belogs.Error("VerifyRootCerByX509(): Verify fail: ", err, rootFile)
+		return "fail", fmt.Errorf("certificate verification failed: %w", err)
+	}
+	belogs.Debug("VerifyRootCerByX509(): certificate verified successfully")
+	return "ok", nil
+}
+
 // 核心修改2：VerifyCrlByX509 适配新的 RevocationList 类型，替换废弃的 CheckCRLSignature
 func VerifyCrlByX509(cerFile, crlFile string) (result string, err error) {
 	/*
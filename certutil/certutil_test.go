@@ -591,6 +591,32 @@ func TestVerifyRootCerByOpenssl(t *testing.T) {
 	}
 }
 
+// TestVerifyRootCerByX509 测试不依赖OpenSSL的根证书验证
+func TestVerifyRootCerByX509(t *testing.T) {
+	tests := []struct {
+		name       string
+		rootFile   string
+		wantResult string
+		wantErr    bool
+	}{
+		{"有效根证书验证", validRootCertFile, "ok", false},
+		{"PEM格式根证书验证", createPEMCertFile(t, validRootCertFile), "ok", false},
+		{"非自签名证书", validChildCertFile, "fail", true},
+		{"无效证书验证", invalidCertFile, "fail", true},
+		{"不存在的文件", filepath.Join(testTempDir, "noroot.cer"), "fail", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := VerifyRootCerByX509(tt.rootFile)
+			fmt.Println(tt.name, result, err)
+			if result != tt.wantResult || (err != nil) != tt.wantErr {
+				t.Fatal("VerifyRootCerByX509() fail:", tt.name, result, err)
+			}
+		})
+	}
+}
+
 // TestVerifyCrlByX509 测试CRL验证功能
 func TestVerifyCrlByX509(t *testing.T) {
 	tests := []struct {
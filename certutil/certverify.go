@@ -0,0 +1,392 @@
+package certutil
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cpusoft/goutil/belogs"
+	"github.com/cpusoft/goutil/convert"
+)
+
+const (
+	CERT_VERIFY_STATE_PASS = "pass"
+	CERT_VERIFY_STATE_WARN = "warn"
+	CERT_VERIFY_STATE_FAIL = "fail"
+)
+
+const (
+	CERT_VERIFY_CHECK_SIGNATURE         = "signature"
+	CERT_VERIFY_CHECK_ISSUER_NAME       = "issuerName"
+	CERT_VERIFY_CHECK_VALIDITY          = "validity"
+	CERT_VERIFY_CHECK_KEY_IDENTIFIER    = "keyIdentifier"
+	CERT_VERIFY_CHECK_KEY_USAGE         = "keyUsage"
+	CERT_VERIFY_CHECK_BASIC_CONSTRAINTS = "basicConstraints"
+	CERT_VERIFY_CHECK_CRL_REVOCATION    = "crlRevocation"
+	CERT_VERIFY_CHECK_CRL_FRESHNESS     = "crlFreshness"
+)
+
+// one check of verification, State is pass/warn/fail
+type CertVerifyCheck struct {
+	Check  string `json:"check"`
+	State  string `json:"state"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// State is fail when any check fails, is warn when any check warns, otherwise is pass
+type CertVerifyResult struct {
+	Subject string            `json:"subject"`
+	Issuer  string            `json:"issuer"`
+	State   string            `json:"state"`
+	Checks  []CertVerifyCheck `json:"checks"`
+}
+
+// Results are from leaf to root, the last one is root verified by itself
+type CertChainVerifyResult struct {
+	State   string              `json:"state"`
+	Results []*CertVerifyResult `json:"results"`
+}
+
+func newCertVerifyResult(subject, issuer string) *CertVerifyResult {
+	return &CertVerifyResult{
+		Subject: subject,
+		Issuer:  issuer,
+		State:   CERT_VERIFY_STATE_PASS,
+		Checks:  make([]CertVerifyCheck, 0),
+	}
+}
+
+func (c *CertVerifyResult) addCheck(check, state, reason string) {
+	c.Checks = append(c.Checks, CertVerifyCheck{Check: check, State: state, Reason: reason})
+	c.State = worseCertVerifyState(c.State, state)
+}
+
+// IsPass is true when no check fails, warns are allowed
+func (c *CertVerifyResult) IsPass() bool {
+	return c.State != CERT_VERIFY_STATE_FAIL
+}
+
+// GetCheck gets check by name, nil when it is not performed
+func (c *CertVerifyResult) GetCheck(check string) *CertVerifyCheck {
+	for i := range c.Checks {
+		if c.Checks[i].Check == check {
+			return &c.Checks[i]
+		}
+	}
+	return nil
+}
+
+// IsPass is true when no check of all results fails
+func (c *CertChainVerifyResult) IsPass() bool {
+	return c.State != CERT_VERIFY_STATE_FAIL
+}
+
+func worseCertVerifyState(a, b string) string {
+	if a == CERT_VERIFY_STATE_FAIL || b == CERT_VERIFY_STATE_FAIL {
+		return CERT_VERIFY_STATE_FAIL
+	}
+	if a == CERT_VERIFY_STATE_WARN || b == CERT_VERIFY_STATE_WARN {
+		return CERT_VERIFY_STATE_WARN
+	}
+	return CERT_VERIFY_STATE_PASS
+}
+
+// VerifyCerFileWithResult is typed result of VerifyCerByX509, crlFiles may be empty
+func VerifyCerFileWithResult(fatherCertFile string, childCertFile string, crlFiles []string) (*CertVerifyResult, error) {
+	fatherCert, err := ReadFileToCer(fatherCertFile)
+	if err != nil {
+		belogs.Error("VerifyCerFileWithResult(): ReadFileToCer fatherCertFile fail:", fatherCertFile, err)
+		return nil, fmt.Errorf("failed to read father certificate: %w", err)
+	}
+	childCert, err := ReadFileToCer(childCertFile)
+	if err != nil {
+		belogs.Error("VerifyCerFileWithResult(): ReadFileToCer childCertFile fail:", childCertFile, err)
+		return nil, fmt.Errorf("failed to read child certificate: %w", err)
+	}
+	crls, err := readFilesToCrls(crlFiles)
+	if err != nil {
+		return nil, err
+	}
+	return VerifyCerWithResult(fatherCert, childCert, crls), nil
+}
+
+// VerifyCerByteWithResult is typed result of VerifyCerByteByX509, crls may be empty
+func VerifyCerByteWithResult(fatherCertByte []byte, childCertByte []byte, crls []*x509.RevocationList) (*CertVerifyResult, error) {
+	fatherCert, err := x509.ParseCertificate(fatherCertByte)
+	if err != nil {
+		belogs.Error("VerifyCerByteWithResult(): parse father certificate fail:", err)
+		return nil, fmt.Errorf("failed to parse father certificate: %w", err)
+	}
+	childCert, err := x509.ParseCertificate(childCertByte)
+	if err != nil {
+		belogs.Error("VerifyCerByteWithResult(): parse child certificate fail:", err)
+		return nil, fmt.Errorf("failed to parse child certificate: %w", err)
+	}
+	return VerifyCerWithResult(fatherCert, childCert, crls), nil
+}
+
+// VerifyCerWithResult verifies childCert is issued by fatherCert, and is not revoked by crl of fatherCert in crls
+func VerifyCerWithResult(fatherCert, childCert *x509.Certificate, crls []*x509.RevocationList) *CertVerifyResult {
+	return verifyCerWithResult(fatherCert, childCert, crls, 0, time.Now())
+}
+
+// VerifyCrlWithResult is typed result of VerifyCrlByX509, cer is issuer of crl
+func VerifyCrlWithResult(cer *x509.Certificate, crl *x509.RevocationList) *CertVerifyResult {
+	result := newCertVerifyResult("crl:"+crl.Issuer.String(), cer.Subject.String())
+	checkCrl(result, cer, crl, time.Now())
+	belogs.Debug("VerifyCrlWithResult(): issuer:", result.Issuer, "  state:", result.State)
+	return result
+}
+
+// VerifyCertChain finds path from leafCert to one of rootCerts by intermediateCerts, and verifies every certificate
+// at validationTime, zero is time.Now().
+// crls are crls of all issuers, if crl of one issuer is missing, revocation is warn
+func VerifyCertChain(leafCert *x509.Certificate, intermediateCerts []*x509.Certificate,
+	rootCerts []*x509.Certificate, crls []*x509.RevocationList, validationTime time.Time) (*CertChainVerifyResult, error) {
+	if leafCert == nil || len(rootCerts) == 0 {
+		return nil, errors.New("leaf certificate or root certificates is empty")
+	}
+	now := validationTime
+	if now.IsZero() {
+		now = time.Now()
+	}
+	chainResult := &CertChainVerifyResult{
+		State:   CERT_VERIFY_STATE_PASS,
+		Results: make([]*CertVerifyResult, 0),
+	}
+	cert := leafCert
+	caCount := 0
+	used := make(map[*x509.Certificate]bool)
+	for {
+		if root := findIssuerCert(cert, rootCerts); root != nil {
+			chainResult.add(verifyCerWithResult(root, cert, crls, caCount, now))
+			if cert != root {
+				chainResult.add(verifyRootWithResult(root, now))
+			}
+			belogs.Debug("VerifyCertChain(): leaf:", leafCert.Subject.String(), "  len(Results):", len(chainResult.Results),
+				"  state:", chainResult.State)
+			return chainResult, nil
+		}
+		father := findIssuerCert(cert, intermediateCerts)
+		if father == nil || used[father] {
+			belogs.Error("VerifyCertChain(): cannot find issuer, subject:", cert.Subject.String(), "   issuer:", cert.Issuer.String())
+			return nil, errors.New("cannot find issuer of " + cert.Subject.String() + " in intermediate and root certificates")
+		}
+		used[father] = true
+		chainResult.add(verifyCerWithResult(father, cert, crls, caCount, now))
+		// father is intermediate ca below next issuer
+		caCount++
+		cert = father
+	}
+}
+
+func (c *CertChainVerifyResult) add(result *CertVerifyResult) {
+	c.Results = append(c.Results, result)
+	c.State = worseCertVerifyState(c.State, result.State)
+}
+
+// findIssuerCert prefers aki/ski, then issuer name
+func findIssuerCert(cert *x509.Certificate, candidates []*x509.Certificate) *x509.Certificate {
+	for _, candidate := range candidates {
+		if len(cert.AuthorityKeyId) > 0 && bytes.Equal(cert.AuthorityKeyId, candidate.SubjectKeyId) {
+			return candidate
+		}
+	}
+	for _, candidate := range candidates {
+		if bytes.Equal(cert.RawIssuer, candidate.RawSubject) {
+			return candidate
+		}
+	}
+	return nil
+}
+
+// caCount is count of ca certificates between fatherCert and leaf, used for maxPathLen
+func verifyCerWithResult(fatherCert, childCert *x509.Certificate, crls []*x509.RevocationList,
+	caCount int, now time.Time) *CertVerifyResult {
+	result := newCertVerifyResult(childCert.Subject.String(), fatherCert.Subject.String())
+
+	err := fatherCert.CheckSignature(childCert.SignatureAlgorithm, childCert.RawTBSCertificate, childCert.Signature)
+	if err != nil {
+		result.addCheck(CERT_VERIFY_CHECK_SIGNATURE, CERT_VERIFY_STATE_FAIL, err.Error())
+	} else {
+		result.addCheck(CERT_VERIFY_CHECK_SIGNATURE, CERT_VERIFY_STATE_PASS, "")
+	}
+
+	checkIssuerName(result, fatherCert.RawSubject, childCert.RawIssuer, fatherCert.Subject.String(), childCert.Issuer.String())
+	checkValidity(result, childCert, now)
+	checkKeyIdentifier(result, fatherCert.SubjectKeyId, childCert.AuthorityKeyId)
+	checkIssuerCa(result, fatherCert, caCount)
+	checkRevocation(result, fatherCert, childCert, crls, now)
+
+	belogs.Debug("verifyCerWithResult(): subject:", result.Subject, "  issuer:", result.Issuer, "  state:", result.State)
+	return result
+}
+
+// root is verified by itself, no crl
+func verifyRootWithResult(rootCert *x509.Certificate, now time.Time) *CertVerifyResult {
+	result := newCertVerifyResult(rootCert.Subject.String(), rootCert.Issuer.String())
+	err := rootCert.CheckSignature(rootCert.SignatureAlgorithm, rootCert.RawTBSCertificate, rootCert.Signature)
+	if err != nil {
+		result.addCheck(CERT_VERIFY_CHECK_SIGNATURE, CERT_VERIFY_STATE_FAIL, "self signature: "+err.Error())
+	} else {
+		result.addCheck(CERT_VERIFY_CHECK_SIGNATURE, CERT_VERIFY_STATE_PASS, "")
+	}
+	checkIssuerName(result, rootCert.RawSubject, rootCert.RawIssuer, rootCert.Subject.String(), rootCert.Issuer.String())
+	checkValidity(result, rootCert, now)
+	checkIssuerCa(result, rootCert, 0)
+	return result
+}
+
+func checkIssuerName(result *CertVerifyResult, rawSubject, rawIssuer []byte, subject, issuer string) {
+	if bytes.Equal(rawSubject, rawIssuer) {
+		result.addCheck(CERT_VERIFY_CHECK_ISSUER_NAME, CERT_VERIFY_STATE_PASS, "")
+	} else if subject == issuer {
+		// same as VerifyCerByteByX509, equal by string but not by raw bytes
+		result.addCheck(CERT_VERIFY_CHECK_ISSUER_NAME, CERT_VERIFY_STATE_WARN,
+			"issuer is equal to subject of father by string, but not by raw bytes")
+	} else {
+		result.addCheck(CERT_VERIFY_CHECK_ISSUER_NAME, CERT_VERIFY_STATE_FAIL,
+			"issuer `"+issuer+"` is not equal to subject of father `"+subject+"`")
+	}
+}
+
+func checkValidity(result *CertVerifyResult, cert *x509.Certificate, now time.Time) {
+	if now.Before(cert.NotBefore) {
+		result.addCheck(CERT_VERIFY_CHECK_VALIDITY, CERT_VERIFY_STATE_FAIL,
+			"certificate is not yet valid, notBefore is "+convert.Time2StringZone(cert.NotBefore))
+	} else if now.After(cert.NotAfter) {
+		result.addCheck(CERT_VERIFY_CHECK_VALIDITY, CERT_VERIFY_STATE_FAIL,
+			"certificate has expired, notAfter is "+convert.Time2StringZone(cert.NotAfter))
+	} else {
+		result.addCheck(CERT_VERIFY_CHECK_VALIDITY, CERT_VERIFY_STATE_PASS, "")
+	}
+}
+
+func checkKeyIdentifier(result *CertVerifyResult, fatherSki, childAki []byte) {
+	if len(fatherSki) == 0 || len(childAki) == 0 {
+		result.addCheck(CERT_VERIFY_CHECK_KEY_IDENTIFIER, CERT_VERIFY_STATE_WARN,
+			"subjectKeyIdentifier of father or authorityKeyIdentifier is missing")
+	} else if !bytes.Equal(fatherSki, childAki) {
+		result.addCheck(CERT_VERIFY_CHECK_KEY_IDENTIFIER, CERT_VERIFY_STATE_FAIL,
+			"authorityKeyIdentifier "+convert.Bytes2String(childAki)+" is not equal to subjectKeyIdentifier of father "+
+				convert.Bytes2String(fatherSki))
+	} else {
+		result.addCheck(CERT_VERIFY_CHECK_KEY_IDENTIFIER, CERT_VERIFY_STATE_PASS, "")
+	}
+}
+
+// fatherCert should be ca, and can sign certificate
+func checkIssuerCa(result *CertVerifyResult, fatherCert *x509.Certificate, caCount int) {
+	if fatherCert.KeyUsage == 0 {
+		result.addCheck(CERT_VERIFY_CHECK_KEY_USAGE, CERT_VERIFY_STATE_WARN, "keyUsage of father is missing")
+	} else if fatherCert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		result.addCheck(CERT_VERIFY_CHECK_KEY_USAGE, CERT_VERIFY_STATE_FAIL, "keyUsage of father has no keyCertSign")
+	} else {
+		result.addCheck(CERT_VERIFY_CHECK_KEY_USAGE, CERT_VERIFY_STATE_PASS, "")
+	}
+
+	if !fatherCert.BasicConstraintsValid || !fatherCert.IsCA {
+		result.addCheck(CERT_VERIFY_CHECK_BASIC_CONSTRAINTS, CERT_VERIFY_STATE_FAIL, "father is not ca")
+	} else if (fatherCert.MaxPathLen > 0 || fatherCert.MaxPathLenZero) && caCount > fatherCert.MaxPathLen {
+		result.addCheck(CERT_VERIFY_CHECK_BASIC_CONSTRAINTS, CERT_VERIFY_STATE_FAIL,
+			fmt.Sprintf("pathLenConstraint of father is %d, but there are %d ca certificates below", fatherCert.MaxPathLen, caCount))
+	} else {
+		result.addCheck(CERT_VERIFY_CHECK_BASIC_CONSTRAINTS, CERT_VERIFY_STATE_PASS, "")
+	}
+}
+
+// crl of fatherCert is found in crls by findIssuerCrl, then revocation and freshness are checked
+func checkRevocation(result *CertVerifyResult, fatherCert, childCert *x509.Certificate,
+	crls []*x509.RevocationList, now time.Time) {
+	crl := findIssuerCrl(fatherCert, crls, now)
+	if crl == nil {
+		result.addCheck(CERT_VERIFY_CHECK_CRL_REVOCATION, CERT_VERIFY_STATE_WARN, "crl of father is not found")
+		return
+	}
+	if err := crl.CheckSignatureFrom(fatherCert); err != nil {
+		result.addCheck(CERT_VERIFY_CHECK_CRL_REVOCATION, CERT_VERIFY_STATE_FAIL, "signature of crl is invalid: "+err.Error())
+		return
+	}
+	revoked := false
+	for _, entry := range crl.RevokedCertificateEntries {
+		if entry.SerialNumber != nil && entry.SerialNumber.Cmp(childCert.SerialNumber) == 0 {
+			result.addCheck(CERT_VERIFY_CHECK_CRL_REVOCATION, CERT_VERIFY_STATE_FAIL,
+				"certificate is revoked at "+convert.Time2StringZone(entry.RevocationTime))
+			revoked = true
+			break
+		}
+	}
+	if !revoked {
+		result.addCheck(CERT_VERIFY_CHECK_CRL_REVOCATION, CERT_VERIFY_STATE_PASS, "")
+	}
+	checkCrlFreshness(result, crl, now)
+}
+
+// findIssuerCrl matches aki of crl to ski of fatherCert, issuer name is used only when aki or ski is missing.
+// When there are more crls, the newest thisUpdate not after now is preferred
+func findIssuerCrl(fatherCert *x509.Certificate, crls []*x509.RevocationList, now time.Time) *x509.RevocationList {
+	var found *x509.RevocationList
+	for _, c := range crls {
+		if len(c.AuthorityKeyId) > 0 && len(fatherCert.SubjectKeyId) > 0 {
+			if !bytes.Equal(c.AuthorityKeyId, fatherCert.SubjectKeyId) {
+				continue
+			}
+		} else if !bytes.Equal(c.RawIssuer, fatherCert.RawSubject) {
+			continue
+		}
+		if found == nil || isNewerCrl(c, found, now) {
+			found = c
+		}
+	}
+	return found
+}
+
+// crl whose thisUpdate is after now is older than any valid one
+func isNewerCrl(a, b *x509.RevocationList, now time.Time) bool {
+	aValid, bValid := !a.ThisUpdate.After(now), !b.ThisUpdate.After(now)
+	if aValid != bValid {
+		return aValid
+	}
+	return a.ThisUpdate.After(b.ThisUpdate)
+}
+
+// checkCrl checks crl itself
+func checkCrl(result *CertVerifyResult, cer *x509.Certificate, crl *x509.RevocationList, now time.Time) {
+	if err := crl.CheckSignatureFrom(cer); err != nil {
+		result.addCheck(CERT_VERIFY_CHECK_SIGNATURE, CERT_VERIFY_STATE_FAIL, err.Error())
+	} else {
+		result.addCheck(CERT_VERIFY_CHECK_SIGNATURE, CERT_VERIFY_STATE_PASS, "")
+	}
+	checkIssuerName(result, cer.RawSubject, crl.RawIssuer, cer.Subject.String(), crl.Issuer.String())
+	checkKeyIdentifier(result, cer.SubjectKeyId, crl.AuthorityKeyId)
+	checkCrlFreshness(result, crl, now)
+}
+
+func checkCrlFreshness(result *CertVerifyResult, crl *x509.RevocationList, now time.Time) {
+	if now.Before(crl.ThisUpdate) {
+		result.addCheck(CERT_VERIFY_CHECK_CRL_FRESHNESS, CERT_VERIFY_STATE_FAIL,
+			"crl is not yet valid, thisUpdate is "+convert.Time2StringZone(crl.ThisUpdate))
+	} else if crl.NextUpdate.IsZero() {
+		result.addCheck(CERT_VERIFY_CHECK_CRL_FRESHNESS, CERT_VERIFY_STATE_WARN, "nextUpdate of crl is missing")
+	} else if now.After(crl.NextUpdate) {
+		result.addCheck(CERT_VERIFY_CHECK_CRL_FRESHNESS, CERT_VERIFY_STATE_FAIL,
+			"crl is stale, nextUpdate is "+convert.Time2StringZone(crl.NextUpdate))
+	} else {
+		result.addCheck(CERT_VERIFY_CHECK_CRL_FRESHNESS, CERT_VERIFY_STATE_PASS, "")
+	}
+}
+
+func readFilesToCrls(crlFiles []string) ([]*x509.RevocationList, error) {
+	crls := make([]*x509.RevocationList, 0, len(crlFiles))
+	for _, crlFile := range crlFiles {
+		crl, err := ReadFileToCrl(crlFile)
+		if err != nil {
+			belogs.Error("readFilesToCrls(): ReadFileToCrl fail:", crlFile, err)
+			return nil, fmt.Errorf("failed to read CRL: %w", err)
+		}
+		crls = append(crls, crl)
+	}
+	return crls, nil
+}
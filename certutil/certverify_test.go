@@ -0,0 +1,185 @@
+package certutil
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/cpusoft/goutil/jsonutil"
+)
+
+func newVerifyTestCert(t *testing.T, serial int64, cn string, isCa bool, maxPathLen int,
+	parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  isCa,
+		MaxPathLen:            maxPathLen,
+		MaxPathLenZero:        maxPathLen == 0,
+		SubjectKeyId:          []byte(cn),
+	}
+	if isCa {
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	b, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func newVerifyTestCrl(t *testing.T, issuer *x509.Certificate, issuerKey crypto.Signer, nextUpdate time.Time,
+	revokedSerials ...int64) *x509.RevocationList {
+	return newVerifyTestCrlAt(t, issuer, issuerKey, time.Now().Add(-2*time.Hour), nextUpdate, revokedSerials...)
+}
+
+func newVerifyTestCrlAt(t *testing.T, issuer *x509.Certificate, issuerKey crypto.Signer, thisUpdate, nextUpdate time.Time,
+	revokedSerials ...int64) *x509.RevocationList {
+	template := &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: thisUpdate,
+		NextUpdate: nextUpdate,
+	}
+	for _, serial := range revokedSerials {
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries,
+			x509.RevocationListEntry{SerialNumber: big.NewInt(serial), RevocationTime: time.Now().Add(-time.Hour)})
+	}
+	b, err := x509.CreateRevocationList(rand.Reader, template, issuer, issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.ParseRevocationList(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crl
+}
+
+func TestVerifyCerWithResult(t *testing.T) {
+	root, rootKey := newVerifyTestCert(t, 1, "root", true, -1, nil, nil)
+	inter, interKey := newVerifyTestCert(t, 2, "inter", true, 0, root, rootKey)
+	leaf, _ := newVerifyTestCert(t, 3, "leaf", false, -1, inter, interKey)
+	revokedLeaf, _ := newVerifyTestCert(t, 4, "revoked", false, -1, inter, interKey)
+	interCrl := newVerifyTestCrl(t, inter, interKey, time.Now().Add(time.Hour), 4)
+
+	result := VerifyCerWithResult(inter, leaf, []*x509.RevocationList{interCrl})
+	fmt.Println(jsonutil.MarshalJson(result))
+	if result.State != CERT_VERIFY_STATE_PASS || len(result.Checks) != 8 {
+		t.Fatal("leaf should pass:", jsonutil.MarshalJson(result))
+	}
+
+	// no crl is warn
+	result = VerifyCerWithResult(inter, leaf, nil)
+	if result.State != CERT_VERIFY_STATE_WARN || !result.IsPass() ||
+		result.GetCheck(CERT_VERIFY_CHECK_CRL_REVOCATION).State != CERT_VERIFY_STATE_WARN {
+		t.Fatal("leaf without crl should warn:", jsonutil.MarshalJson(result))
+	}
+
+	result = VerifyCerWithResult(inter, revokedLeaf, []*x509.RevocationList{interCrl})
+	if result.IsPass() || result.GetCheck(CERT_VERIFY_CHECK_CRL_REVOCATION).State != CERT_VERIFY_STATE_FAIL {
+		t.Fatal("revoked leaf should fail:", jsonutil.MarshalJson(result))
+	}
+
+	staleCrl := newVerifyTestCrl(t, inter, interKey, time.Now().Add(-time.Hour))
+	result = VerifyCerWithResult(inter, leaf, []*x509.RevocationList{staleCrl})
+	if result.IsPass() || result.GetCheck(CERT_VERIFY_CHECK_CRL_FRESHNESS).State != CERT_VERIFY_STATE_FAIL {
+		t.Fatal("stale crl should fail:", jsonutil.MarshalJson(result))
+	}
+
+	// crl of other key with same issuer name is skipped by aki, and the newest crl is used
+	otherInter := *inter
+	otherInter.SubjectKeyId = []byte("other")
+	otherCrl := newVerifyTestCrlAt(t, &otherInter, rootKey, time.Now().Add(-time.Minute), time.Now().Add(time.Hour))
+	newCrl := newVerifyTestCrlAt(t, inter, interKey, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	futureCrl := newVerifyTestCrlAt(t, inter, interKey, time.Now().Add(time.Hour), time.Now().Add(2*time.Hour))
+	result = VerifyCerWithResult(inter, leaf, []*x509.RevocationList{otherCrl, staleCrl, futureCrl, newCrl})
+	if result.State != CERT_VERIFY_STATE_PASS {
+		t.Fatal("newest crl of father should be used:", jsonutil.MarshalJson(result))
+	}
+
+	// wrong father: signature, issuer name and aki/ski fail; leaf cannot sign
+	result = VerifyCerWithResult(root, leaf, nil)
+	fmt.Println(jsonutil.MarshalJson(result))
+	for _, check := range []string{CERT_VERIFY_CHECK_SIGNATURE, CERT_VERIFY_CHECK_ISSUER_NAME, CERT_VERIFY_CHECK_KEY_IDENTIFIER} {
+		if result.GetCheck(check).State != CERT_VERIFY_STATE_FAIL {
+			t.Fatal(check, "should fail:", jsonutil.MarshalJson(result))
+		}
+	}
+	result = VerifyCerWithResult(leaf, revokedLeaf, nil)
+	if result.GetCheck(CERT_VERIFY_CHECK_KEY_USAGE).State != CERT_VERIFY_STATE_FAIL ||
+		result.GetCheck(CERT_VERIFY_CHECK_BASIC_CONSTRAINTS).State != CERT_VERIFY_STATE_FAIL {
+		t.Fatal("leaf as father should fail:", jsonutil.MarshalJson(result))
+	}
+
+	crlResult := VerifyCrlWithResult(inter, interCrl)
+	if crlResult.State != CERT_VERIFY_STATE_PASS {
+		t.Fatal("crl should pass:", jsonutil.MarshalJson(crlResult))
+	}
+	if crlResult = VerifyCrlWithResult(root, interCrl); crlResult.IsPass() {
+		t.Fatal("crl of other issuer should fail:", jsonutil.MarshalJson(crlResult))
+	}
+}
+
+func TestVerifyCertChain(t *testing.T) {
+	root, rootKey := newVerifyTestCert(t, 1, "root", true, 1, nil, nil)
+	inter1, inter1Key := newVerifyTestCert(t, 2, "inter1", true, -1, root, rootKey)
+	inter2, inter2Key := newVerifyTestCert(t, 3, "inter2", true, -1, inter1, inter1Key)
+	leaf, _ := newVerifyTestCert(t, 4, "leaf", false, -1, inter2, inter2Key)
+	crls := []*x509.RevocationList{
+		newVerifyTestCrl(t, root, rootKey, time.Now().Add(time.Hour)),
+		newVerifyTestCrl(t, inter1, inter1Key, time.Now().Add(time.Hour)),
+		newVerifyTestCrl(t, inter2, inter2Key, time.Now().Add(time.Hour)),
+	}
+
+	chainResult, err := VerifyCertChain(inter2, []*x509.Certificate{inter1}, []*x509.Certificate{root}, crls, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(jsonutil.MarshalJson(chainResult))
+	if chainResult.State != CERT_VERIFY_STATE_PASS || len(chainResult.Results) != 3 || chainResult.Results[2].Subject != "CN=root" {
+		t.Fatal("chain should pass:", jsonutil.MarshalJson(chainResult))
+	}
+
+	// pathLen of root is 1, but inter1 and inter2 are below root
+	chainResult, err = VerifyCertChain(leaf, []*x509.Certificate{inter2, inter1}, []*x509.Certificate{root}, crls, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(jsonutil.MarshalJson(chainResult))
+	if chainResult.IsPass() || len(chainResult.Results) != 4 ||
+		chainResult.Results[2].GetCheck(CERT_VERIFY_CHECK_BASIC_CONSTRAINTS).State != CERT_VERIFY_STATE_FAIL {
+		t.Fatal("chain should fail by pathLen:", jsonutil.MarshalJson(chainResult))
+	}
+
+	// validation time is after notAfter
+	chainResult, err = VerifyCertChain(inter2, []*x509.Certificate{inter1}, []*x509.Certificate{root}, crls, time.Now().Add(2*time.Hour))
+	if err != nil || chainResult.IsPass() || chainResult.Results[0].GetCheck(CERT_VERIFY_CHECK_VALIDITY).State != CERT_VERIFY_STATE_FAIL {
+		t.Fatal("chain should fail by validity:", jsonutil.MarshalJson(chainResult), err)
+	}
+
+	if _, err = VerifyCertChain(leaf, []*x509.Certificate{inter2}, []*x509.Certificate{root}, crls, time.Time{}); err == nil {
+		t.Fatal("should fail when inter1 is missing")
+	}
+}
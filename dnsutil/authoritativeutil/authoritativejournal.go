@@ -0,0 +1,218 @@
+package authoritativeutil
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/cpusoft/goutil/belogs"
+	"github.com/cpusoft/goutil/dnsutil"
+)
+
+const (
+	// max count of deltas kept for IXFR, older deltas are removed and AXFR is used, rfc1995 5
+	AUTHORITATIVE_JOURNAL_MAX_DELTAS = 100
+)
+
+// zoneDelta is change of zone from serial of oldSoa to serial of newSoa, rfc1995 4
+type zoneDelta struct {
+	oldSoa *dnsutil.DnsRr
+	// without SOA
+	deletes []*dnsutil.DnsRr
+	newSoa  *dnsutil.DnsRr
+	// without SOA
+	adds []*dnsutil.DnsRr
+}
+
+// getZoneDeltas gets journal of newZone: journal of oldZone with the change from oldZone to newZone.
+// Journal is cleared when serial is not increased but zone is changed
+func getZoneDeltas(oldZone, newZone *authoritativeZone) []*zoneDelta {
+	if oldZone == nil {
+		return nil
+	}
+	oldSerial, newSerial := getSoaSerial(oldZone.soa), getSoaSerial(newZone.soa)
+	deletes := getRrsDiff(oldZone.dnsRrs, newZone.dnsRrs)
+	adds := getRrsDiff(newZone.dnsRrs, oldZone.dnsRrs)
+	if oldSerial == newSerial {
+		if len(deletes) > 0 || len(adds) > 0 {
+			belogs.Info("getZoneDeltas(): zone is changed without increasing serial, journal is cleared:", newZone.origin, newSerial)
+			return nil
+		}
+		return oldZone.deltas
+	}
+	if !isSerialGreater(newSerial, oldSerial) {
+		belogs.Info("getZoneDeltas(): serial is decreased, journal is cleared:", newZone.origin, oldSerial, newSerial)
+		return nil
+	}
+
+	deltas := make([]*zoneDelta, 0, len(oldZone.deltas)+1)
+	deltas = append(deltas, oldZone.deltas...)
+	deltas = append(deltas, &zoneDelta{oldSoa: oldZone.soa, deletes: deletes, newSoa: newZone.soa, adds: adds})
+	if len(deltas) > AUTHORITATIVE_JOURNAL_MAX_DELTAS {
+		deltas = deltas[len(deltas)-AUTHORITATIVE_JOURNAL_MAX_DELTAS:]
+	}
+	belogs.Debug("getZoneDeltas(): zone is changed:", newZone.origin, oldSerial, newSerial,
+		"  len(deletes):", len(deletes), "  len(adds):", len(adds), "  len(deltas):", len(deltas))
+	return deltas
+}
+
+// getRrsDiff gets rrs in from but not in to, SOA is ignored
+func getRrsDiff(from, to []*dnsutil.DnsRr) []*dnsutil.DnsRr {
+	in := make(map[string]bool, len(to))
+	for _, rr := range to {
+		in[getRrKey(rr)] = true
+	}
+	diff := make([]*dnsutil.DnsRr, 0)
+	for _, rr := range from {
+		if rr.Type != dnsutil.DNS_TYPE_INT_SOA && !in[getRrKey(rr)] {
+			diff = append(diff, rr)
+		}
+	}
+	return diff
+}
+
+// getRrKey: rr with different ttl is different rr in IXFR
+func getRrKey(rr *dnsutil.DnsRr) string {
+	key := dnsutil.FormatDomainFqdn(rr.Name) + " " + strconv.FormatUint(uint64(rr.Ttl), 10) + " " +
+		strconv.Itoa(int(rr.Class)) + " " + strconv.Itoa(int(rr.Type))
+	if rr.Data != nil {
+		key += " " + rr.Data.String()
+	}
+	return key
+}
+
+func getSoaSerial(soa *dnsutil.DnsRr) uint32 {
+	if soa == nil {
+		return 0
+	}
+	if data, ok := soa.Data.(*dnsutil.DnsRrDataSoa); ok {
+		return data.Serial
+	}
+	return 0
+}
+
+// isSerialGreater: s1 > s2 in serial number arithmetic, rfc1982 3.2
+func isSerialGreater(s1, s2 uint32) bool {
+	return int32(s1-s2) > 0
+}
+
+// getAxfrRrs: SOA, other rrs, SOA, rfc5936 2.2
+func (c *authoritativeZone) getAxfrRrs() []*dnsutil.DnsRr {
+	rrs := make([]*dnsutil.DnsRr, 0, len(c.dnsRrs)+1)
+	rrs = append(rrs, c.soa)
+	for _, rr := range c.dnsRrs {
+		if rr.Type != dnsutil.DNS_TYPE_INT_SOA {
+			rrs = append(rrs, rr)
+		}
+	}
+	return append(rrs, c.soa)
+}
+
+// getIxfrRrs gets rrs from serial, rfc1995 4: only SOA when serial is not older,
+// all rrs as AXFR when journal has no serial, otherwise SOA, deltas, SOA
+func (c *authoritativeZone) getIxfrRrs(serial uint32) []*dnsutil.DnsRr {
+	if !isSerialGreater(getSoaSerial(c.soa), serial) {
+		return []*dnsutil.DnsRr{c.soa}
+	}
+	for i, delta := range c.deltas {
+		if getSoaSerial(delta.oldSoa) != serial {
+			continue
+		}
+		rrs := []*dnsutil.DnsRr{c.soa}
+		for _, delta := range c.deltas[i:] {
+			rrs = append(rrs, delta.oldSoa)
+			rrs = append(rrs, delta.deletes...)
+			rrs = append(rrs, delta.newSoa)
+			rrs = append(rrs, delta.adds...)
+		}
+		return append(rrs, c.soa)
+	}
+	belogs.Debug("authoritativeZone.getIxfrRrs(): serial is not in journal, use AXFR:", c.origin, serial)
+	return c.getAxfrRrs()
+}
+
+// parseTransferRrs parses rrs of AXFR or IXFR response. complete is false when more rrs are needed.
+// When it is AXFR format, axfrRrs are all rrs of zone with SOA in the first; otherwise they are deltas
+func parseTransferRrs(rrs []*dnsutil.DnsRr) (axfrRrs []*dnsutil.DnsRr, deltas []*zoneDelta, complete bool, err error) {
+	if len(rrs) == 0 {
+		return nil, nil, false, nil
+	}
+	if rrs[0].Type != dnsutil.DNS_TYPE_INT_SOA {
+		return nil, nil, false, errors.New("the first rr of zone transfer is not SOA")
+	}
+	if len(rrs) == 1 {
+		return nil, nil, false, nil
+	}
+	serial := getSoaSerial(rrs[0])
+
+	// AXFR format, rfc1995 4 ("second record is not SOA") and rfc5936 2.2
+	if rrs[1].Type != dnsutil.DNS_TYPE_INT_SOA || getSoaSerial(rrs[1]) == serial {
+		last := rrs[len(rrs)-1]
+		if last.Type != dnsutil.DNS_TYPE_INT_SOA {
+			return nil, nil, false, nil
+		}
+		if getSoaSerial(last) != serial {
+			return nil, nil, false, errors.New("serial of the last SOA is not the serial of the first SOA")
+		}
+		return rrs[:len(rrs)-1], nil, true, nil
+	}
+
+	// incremental format
+	deltas = make([]*zoneDelta, 0)
+	i := 1
+	for i < len(rrs) {
+		if getSoaSerial(rrs[i]) == serial {
+			if i != len(rrs)-1 {
+				return nil, nil, false, errors.New("there are rrs after the last SOA")
+			}
+			return nil, deltas, true, nil
+		}
+		delta := &zoneDelta{oldSoa: rrs[i], deletes: make([]*dnsutil.DnsRr, 0), adds: make([]*dnsutil.DnsRr, 0)}
+		for i++; i < len(rrs) && rrs[i].Type != dnsutil.DNS_TYPE_INT_SOA; i++ {
+			delta.deletes = append(delta.deletes, rrs[i])
+		}
+		if i >= len(rrs) {
+			break
+		}
+		delta.newSoa = rrs[i]
+		for i++; i < len(rrs) && rrs[i].Type != dnsutil.DNS_TYPE_INT_SOA; i++ {
+			delta.adds = append(delta.adds, rrs[i])
+		}
+		deltas = append(deltas, delta)
+	}
+	return nil, nil, false, nil
+}
+
+// applyZoneDeltas applies deltas to rrs of zone, SOA of rrs should be the oldSoa of the first delta
+func applyZoneDeltas(dnsRrs []*dnsutil.DnsRr, deltas []*zoneDelta) ([]*dnsutil.DnsRr, error) {
+	var soa *dnsutil.DnsRr
+	rrs := make([]*dnsutil.DnsRr, 0, len(dnsRrs))
+	for _, rr := range dnsRrs {
+		if rr.Type == dnsutil.DNS_TYPE_INT_SOA {
+			soa = rr
+			continue
+		}
+		rrs = append(rrs, rr)
+	}
+	if soa == nil {
+		return nil, errors.New("zone has no SOA")
+	}
+	for _, delta := range deltas {
+		if getSoaSerial(delta.oldSoa) != getSoaSerial(soa) {
+			return nil, errors.New("serial " + strconv.FormatUint(uint64(getSoaSerial(delta.oldSoa)), 10) +
+				" of delta is not serial " + strconv.FormatUint(uint64(getSoaSerial(soa)), 10) + " of zone")
+		}
+		deletes := make(map[string]bool, len(delta.deletes))
+		for _, rr := range delta.deletes {
+			deletes[getRrKey(rr)] = true
+		}
+		newRrs := make([]*dnsutil.DnsRr, 0, len(rrs)+len(delta.adds))
+		for _, rr := range rrs {
+			if !deletes[getRrKey(rr)] {
+				newRrs = append(newRrs, rr)
+			}
+		}
+		rrs = append(newRrs, delta.adds...)
+		soa = delta.newSoa
+	}
+	return append([]*dnsutil.DnsRr{soa}, rrs...), nil
+}
@@ -0,0 +1,253 @@
+package authoritativeutil
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cpusoft/goutil/belogs"
+	"github.com/cpusoft/goutil/dnsutil"
+	"github.com/cpusoft/goutil/zonefileutil"
+)
+
+const (
+	AUTHORITATIVE_TRANSFER_TIMEOUT_SECONDS = 60
+	// retry interval when there is no SOA of zone
+	AUTHORITATIVE_SECONDARY_RETRY_SECONDS = 60
+)
+
+// secondaryZone is zone pulled from primary by AXFR/IXFR
+type secondaryZone struct {
+	origin       string
+	zoneFileName string
+	primary      netip.AddrPort
+	// NOTIFY from primary
+	notifyCh chan struct{}
+
+	refreshMutex sync.Mutex
+	// time of the last successful refresh, for expire of SOA
+	refreshTime time.Time
+}
+
+// AddSecondaryZone pulls zone from primary, such as "192.0.2.1:53", by AXFR or IXFR, and saves it to zoneFileName
+// by zonefileutil.SaveZoneFile. When zoneFileName exists, it is served before the first refresh.
+// Zone is refreshed by refresh and retry of SOA, or by NOTIFY from primary, and is removed after expire, rfc1034 4.3.5
+func (c *AuthoritativeServer) AddSecondaryZone(origin, zoneFileName, primary string) error {
+	primaryAddrPort, err := parseAddrPort(primary)
+	if err != nil {
+		belogs.Error("AuthoritativeServer.AddSecondaryZone(): parseAddrPort fail:", origin, primary, err)
+		return err
+	}
+	s := &secondaryZone{
+		origin:       dnsutil.FormatDomainFqdn(origin),
+		zoneFileName: zoneFileName,
+		primary:      primaryAddrPort,
+		notifyCh:     make(chan struct{}, 1),
+	}
+	c.transferMutex.Lock()
+	if _, ok := c.secondaryZones[s.origin]; ok {
+		c.transferMutex.Unlock()
+		return errors.New("secondary zone " + s.origin + " already exists")
+	}
+	c.secondaryZones[s.origin] = s
+	c.transferMutex.Unlock()
+
+	if fileInfo, err := os.Stat(zoneFileName); err == nil {
+		if err = c.LoadZoneFile(zoneFileName); err != nil {
+			belogs.Error("AuthoritativeServer.AddSecondaryZone(): LoadZoneFile fail, will transfer zone:", zoneFileName, err)
+		} else {
+			s.refreshTime = fileInfo.ModTime()
+		}
+	}
+	go c.runSecondaryZone(s)
+	belogs.Info("AuthoritativeServer.AddSecondaryZone(): secondary zone is added:", s.origin, "  primary:", s.primary)
+	return nil
+}
+
+func (c *AuthoritativeServer) getSecondaryZone(origin string) *secondaryZone {
+	c.transferMutex.RLock()
+	defer c.transferMutex.RUnlock()
+	return c.secondaryZones[dnsutil.FormatDomainFqdn(origin)]
+}
+
+func getZoneSoaData(zone *authoritativeZone) (*dnsutil.DnsRrDataSoa, bool) {
+	if zone == nil {
+		return nil, false
+	}
+	soa, ok := zone.soa.Data.(*dnsutil.DnsRrDataSoa)
+	return soa, ok
+}
+
+func (c *AuthoritativeServer) runSecondaryZone(s *secondaryZone) {
+	for {
+		err := c.RefreshSecondaryZone(s.origin)
+		wait := AUTHORITATIVE_SECONDARY_RETRY_SECONDS * time.Second
+		c.zonesMutex.RLock()
+		zone := c.zones[s.origin]
+		c.zonesMutex.RUnlock()
+		if soa, ok := getZoneSoaData(zone); ok {
+			if err == nil {
+				wait = time.Duration(soa.Refresh) * time.Second
+			} else {
+				wait = time.Duration(soa.Retry) * time.Second
+				s.refreshMutex.Lock()
+				expired := time.Since(s.refreshTime) > time.Duration(soa.Expire)*time.Second
+				s.refreshMutex.Unlock()
+				if expired {
+					belogs.Info("AuthoritativeServer.runSecondaryZone(): zone is expired, will be removed:", s.origin)
+					c.RemoveZone(s.origin)
+				}
+			}
+		}
+		select {
+		case <-time.After(max(wait, time.Second)):
+		case <-s.notifyCh:
+		case <-c.closeCh:
+			return
+		}
+	}
+}
+
+// RefreshSecondaryZone pulls secondary zone from primary now, by IXFR when there is zone, otherwise by AXFR
+func (c *AuthoritativeServer) RefreshSecondaryZone(origin string) error {
+	s := c.getSecondaryZone(origin)
+	if s == nil {
+		return errors.New("secondary zone " + origin + " is not found")
+	}
+	s.refreshMutex.Lock()
+	defer s.refreshMutex.Unlock()
+
+	c.zonesMutex.RLock()
+	zone := c.zones[s.origin]
+	c.zonesMutex.RUnlock()
+	request := dnsutil.NewDnsQuery(uint16(rand.Uint32()), s.origin, dnsutil.DNS_TYPE_INT_AXFR)
+	request.Header.Rd = false
+	if zone != nil {
+		// SOA of client is in authority section, rfc1995 3
+		request.Questions[0].Type = dnsutil.DNS_TYPE_INT_IXFR
+		request.Authorities = append(request.Authorities, zone.soa)
+	}
+	rrs, err := queryTransfer(request, s.primary)
+	if err != nil {
+		belogs.Error("AuthoritativeServer.RefreshSecondaryZone(): queryTransfer fail:", s.origin, s.primary, err)
+		return err
+	}
+	if zone != nil && !isSerialGreater(getSoaSerial(rrs[0]), getSoaSerial(zone.soa)) {
+		belogs.Debug("AuthoritativeServer.RefreshSecondaryZone(): zone is up to date:", s.origin, getSoaSerial(zone.soa))
+		s.refreshTime = time.Now()
+		return nil
+	}
+
+	axfrRrs, deltas, _, err := parseTransferRrs(rrs)
+	if err != nil {
+		belogs.Error("AuthoritativeServer.RefreshSecondaryZone(): parseTransferRrs fail:", s.origin, err)
+		return err
+	}
+	if axfrRrs == nil {
+		if zone == nil {
+			return errors.New("incremental transfer is got without zone")
+		}
+		if axfrRrs, err = applyZoneDeltas(zone.dnsRrs, deltas); err != nil {
+			belogs.Error("AuthoritativeServer.RefreshSecondaryZone(): applyZoneDeltas fail:", s.origin, err)
+			return err
+		}
+	}
+	zoneFileModel, err := zonefileutil.NewZoneFileModelByDnsRrs(s.origin, s.zoneFileName, axfrRrs)
+	if err != nil {
+		belogs.Error("AuthoritativeServer.RefreshSecondaryZone(): NewZoneFileModelByDnsRrs fail:", s.origin, err)
+		return err
+	}
+	if err = zonefileutil.SaveZoneFile(zoneFileModel, ""); err != nil {
+		belogs.Error("AuthoritativeServer.RefreshSecondaryZone(): SaveZoneFile fail:", s.origin, s.zoneFileName, err)
+		return err
+	}
+	if err = c.SetZone(zoneFileModel); err != nil {
+		belogs.Error("AuthoritativeServer.RefreshSecondaryZone(): SetZone fail:", s.origin, err)
+		return err
+	}
+	s.refreshTime = time.Now()
+	belogs.Info("AuthoritativeServer.RefreshSecondaryZone(): zone is transferred:", s.origin, getSoaSerial(rrs[0]),
+		"  incremental:", deltas != nil, "  len(rrs):", len(rrs))
+	return nil
+}
+
+// queryTransfer sends AXFR/IXFR request to primary over tcp, and gets all rrs in responses
+func queryTransfer(request *dnsutil.DnsMessage, primary netip.AddrPort) ([]*dnsutil.DnsRr, error) {
+	b, err := request.Pack()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("tcp", primary.String(), AUTHORITATIVE_TRANSFER_TIMEOUT_SECONDS*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(AUTHORITATIVE_TRANSFER_TIMEOUT_SECONDS * time.Second))
+	if _, err = conn.Write(dnsutil.GetTcpDnsMessage(b)); err != nil {
+		return nil, err
+	}
+
+	rrs := make([]*dnsutil.DnsRr, 0)
+	data := make([]byte, 0)
+	buffer := make([]byte, dnsutil.DNS_EDNS_DEFAULT_UDP_SIZE)
+	for {
+		n, err := conn.Read(buffer)
+		if err != nil {
+			return nil, err
+		}
+		var msgs [][]byte
+		msgs, data = dnsutil.SplitTcpDnsMessages(append(data, buffer[:n]...))
+		for _, msg := range msgs {
+			response, err := dnsutil.UnpackDnsMessage(msg)
+			if err != nil {
+				return nil, err
+			}
+			if response.Header.Id != request.Header.Id || response.Header.Qr != dnsutil.DNS_QR_RESPONSE {
+				return nil, errors.New("message is not response of request")
+			}
+			if rCode := response.GetRCode(); rCode != uint16(dnsutil.DNS_RCODE_NOERROR) {
+				return nil, errors.New("rCode of response is " + strconv.Itoa(int(rCode)))
+			}
+			rrs = append(rrs, response.Answers...)
+			// only SOA when zone is up to date, rfc1995 4
+			if request.Questions[0].Type == dnsutil.DNS_TYPE_INT_IXFR && len(rrs) == 1 && len(response.Answers) == 1 &&
+				rrs[0].Type == dnsutil.DNS_TYPE_INT_SOA {
+				return rrs, nil
+			}
+			_, _, complete, err := parseTransferRrs(rrs)
+			if err != nil {
+				return nil, err
+			}
+			if complete {
+				return rrs, nil
+			}
+		}
+	}
+}
+
+// onNotify refreshes secondary zone when NOTIFY is from its primary, rfc1996 3.7 and 3.11
+func (c *AuthoritativeServer) onNotify(request *dnsutil.DnsMessage, clientAddr netip.Addr) *dnsutil.DnsMessage {
+	response := dnsutil.NewDnsResponse(request, uint16(dnsutil.DNS_RCODE_NOERROR))
+	if len(request.Questions) != 1 {
+		response.SetRCode(uint16(dnsutil.DNS_RCODE_FORMERR))
+		return response
+	}
+	s := c.getSecondaryZone(request.Questions[0].Name)
+	if s == nil || s.primary.Addr() != clientAddr.Unmap() {
+		belogs.Info("AuthoritativeServer.onNotify(): NOTIFY is refused:", request.Questions[0].Name, clientAddr)
+		response.SetRCode(uint16(dnsutil.DNS_RCODE_REFUSED))
+		return response
+	}
+	response.Header.Aa = true
+	select {
+	case s.notifyCh <- struct{}{}:
+	default:
+	}
+	belogs.Info("AuthoritativeServer.onNotify(): NOTIFY is got, zone will be refreshed:", s.origin, clientAddr)
+	return response
+}
@@ -0,0 +1,359 @@
+package authoritativeutil
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cpusoft/goutil/belogs"
+	"github.com/cpusoft/goutil/dnsutil"
+	"github.com/cpusoft/goutil/transportutil"
+	"github.com/cpusoft/goutil/zonefileutil"
+)
+
+const (
+	// tcp server does not declare length, and dns splits stream by length, rfc1035 4.2.2
+	AUTHORITATIVE_TCP_LENGTH_DECLARATION = "false"
+	AUTHORITATIVE_TCP_RECEIVE_LENGTH     = 2048
+	AUTHORITATIVE_UDP_RECEIVE_LENGTH     = dnsutil.DNS_MESSAGE_MAX_LENGTH
+)
+
+// AuthoritativeServer answers queries over udp and tcp from zones loaded by zonefileutil
+type AuthoritativeServer struct {
+	// lower origin --> zone
+	zonesMutex sync.RWMutex
+	zones      map[string]*authoritativeZone
+
+	// lower origin --> secondaries to notify and to transfer zone, and zones pulled from primary
+	transferMutex  sync.RWMutex
+	secondaries    map[string][]netip.AddrPort
+	secondaryZones map[string]*secondaryZone
+
+	udpServer *transportutil.UdpServer
+	tcpServer *transportutil.TcpServer
+	closeOnce sync.Once
+	closeCh   chan struct{}
+}
+
+func NewAuthoritativeServer() *AuthoritativeServer {
+	return &AuthoritativeServer{
+		zones:          make(map[string]*authoritativeZone),
+		secondaries:    make(map[string][]netip.AddrPort),
+		secondaryZones: make(map[string]*secondaryZone),
+		closeCh:        make(chan struct{}),
+	}
+}
+
+// LoadZoneFile loads or reloads zone from zoneFileName, the old zone of same origin is replaced
+func (c *AuthoritativeServer) LoadZoneFile(zoneFileName string) error {
+	fileInfo, err := os.Stat(zoneFileName)
+	if err != nil {
+		belogs.Error("AuthoritativeServer.LoadZoneFile(): Stat fail:", zoneFileName, err)
+		return err
+	}
+	zoneFileModel, err := zonefileutil.LoadZoneFile(zoneFileName)
+	if err != nil {
+		belogs.Error("AuthoritativeServer.LoadZoneFile(): LoadZoneFile fail:", zoneFileName, err)
+		return err
+	}
+	return c.setZone(zoneFileModel, fileInfo.ModTime())
+}
+
+// SetZone adds zone, or refreshes it after zoneFileModel is changed (such as by zonefileutil.ApplyDnsUpdate).
+// When serial of SOA is increased, the change is kept in journal for IXFR, and NOTIFY is sent to secondaries
+func (c *AuthoritativeServer) SetZone(zoneFileModel *zonefileutil.ZoneFileModel) error {
+	var modTime time.Time
+	if zoneFileModel != nil && len(zoneFileModel.ZoneFileName) > 0 {
+		if fileInfo, err := os.Stat(zoneFileModel.ZoneFileName); err == nil {
+			modTime = fileInfo.ModTime()
+		}
+	}
+	return c.setZone(zoneFileModel, modTime)
+}
+
+func (c *AuthoritativeServer) setZone(zoneFileModel *zonefileutil.ZoneFileModel, modTime time.Time) error {
+	if zoneFileModel == nil {
+		return errors.New("zoneFileModel is nil")
+	}
+	zone, err := newAuthoritativeZone(zoneFileModel, modTime)
+	if err != nil {
+		belogs.Error("AuthoritativeServer.setZone(): newAuthoritativeZone fail:", zoneFileModel.Origin, err)
+		return err
+	}
+	c.zonesMutex.Lock()
+	oldZone := c.zones[zone.origin]
+	zone.deltas = getZoneDeltas(oldZone, zone)
+	c.zones[zone.origin] = zone
+	c.zonesMutex.Unlock()
+	belogs.Info("AuthoritativeServer.setZone(): zone is set:", zone.origin, "  serial:", getSoaSerial(zone.soa),
+		"  zoneFileName:", zoneFileModel.ZoneFileName)
+	if oldZone != nil && isSerialGreater(getSoaSerial(zone.soa), getSoaSerial(oldZone.soa)) {
+		c.NotifySecondaries(zone.origin)
+	}
+	return nil
+}
+
+// RemoveZone removes zone of origin
+func (c *AuthoritativeServer) RemoveZone(origin string) {
+	c.zonesMutex.Lock()
+	defer c.zonesMutex.Unlock()
+	delete(c.zones, dnsutil.FormatDomainFqdn(origin))
+}
+
+// GetZoneFileModel gets zone of origin, or nil
+func (c *AuthoritativeServer) GetZoneFileModel(origin string) *zonefileutil.ZoneFileModel {
+	c.zonesMutex.RLock()
+	defer c.zonesMutex.RUnlock()
+	if zone, ok := c.zones[dnsutil.FormatDomainFqdn(origin)]; ok {
+		return zone.zoneFileModel
+	}
+	return nil
+}
+
+// getZone gets the zone which is the closest to name
+func (c *AuthoritativeServer) getZone(name string) *authoritativeZone {
+	c.zonesMutex.RLock()
+	defer c.zonesMutex.RUnlock()
+	var found *authoritativeZone
+	for origin, zone := range c.zones {
+		if dnsutil.IsSubDomain(name, origin) && (found == nil || len(origin) > len(found.origin)) {
+			found = zone
+		}
+	}
+	return found
+}
+
+// ReloadZones reloads zones whose zone files are changed, the old zone is kept when reload fails
+func (c *AuthoritativeServer) ReloadZones() {
+	c.zonesMutex.RLock()
+	zones := make([]*authoritativeZone, 0, len(c.zones))
+	for _, zone := range c.zones {
+		zones = append(zones, zone)
+	}
+	c.zonesMutex.RUnlock()
+
+	for _, zone := range zones {
+		zoneFileName := zone.zoneFileModel.ZoneFileName
+		if len(zoneFileName) == 0 {
+			continue
+		}
+		fileInfo, err := os.Stat(zoneFileName)
+		if err != nil {
+			belogs.Error("AuthoritativeServer.ReloadZones(): Stat fail:", zoneFileName, err)
+			continue
+		}
+		if fileInfo.ModTime().Equal(zone.modTime) {
+			continue
+		}
+		belogs.Info("AuthoritativeServer.ReloadZones(): zone file is changed, will reload:", zoneFileName)
+		if err = c.LoadZoneFile(zoneFileName); err != nil {
+			belogs.Error("AuthoritativeServer.ReloadZones(): LoadZoneFile fail, keep old zone:", zoneFileName, err)
+		}
+	}
+}
+
+// Query gets response of request, nil means no response. AXFR/IXFR is refused, it is only answered over tcp/udp after Start
+func (c *AuthoritativeServer) Query(request *dnsutil.DnsMessage) *dnsutil.DnsMessage {
+	if request == nil || request.Header.Qr != dnsutil.DNS_QR_REQUEST {
+		return nil
+	}
+	response := dnsutil.NewDnsResponse(request, uint16(dnsutil.DNS_RCODE_NOERROR))
+	if request.Header.OpCode != dnsutil.DNS_OPCODE_QUERY {
+		response.SetRCode(uint16(dnsutil.DNS_RCODE_NOTIMP))
+		return response
+	}
+	if len(request.Questions) != 1 {
+		response.SetRCode(uint16(dnsutil.DNS_RCODE_FORMERR))
+		return response
+	}
+	question := request.Questions[0]
+	if (question.Class != dnsutil.DNS_CLASS_INT_IN && question.Class != dnsutil.DNS_CLASS_INT_ANY) ||
+		question.Type == dnsutil.DNS_TYPE_INT_AXFR || question.Type == dnsutil.DNS_TYPE_INT_IXFR {
+		response.SetRCode(uint16(dnsutil.DNS_RCODE_REFUSED))
+		return response
+	}
+	zone := c.getZone(question.Name)
+	if zone == nil {
+		response.SetRCode(uint16(dnsutil.DNS_RCODE_REFUSED))
+		return response
+	}
+	response.Header.Aa = true
+	zone.answer(question, response)
+	return response
+}
+
+// processRequest unpacks request and packs responses, there are more responses for AXFR/IXFR over tcp
+func (c *AuthoritativeServer) processRequest(receiveData []byte, clientAddr netip.Addr, isUdp bool) [][]byte {
+	request, err := dnsutil.UnpackDnsMessage(receiveData)
+	if err != nil {
+		belogs.Debug("AuthoritativeServer.processRequest(): UnpackDnsMessage fail:", len(receiveData), err)
+		// FORMERR with id and opCode when there is header, rfc1035 4.1.1
+		if len(receiveData) < dnsutil.DNS_HEADER_LENGTH || receiveData[2]&0x80 != 0 {
+			return nil
+		}
+		response := dnsutil.NewDnsMessage()
+		response.Header = dnsutil.DnsHeader{Id: binary.BigEndian.Uint16(receiveData), Qr: dnsutil.DNS_QR_RESPONSE,
+			OpCode: (receiveData[2] >> 3) & 0x0f, RCode: dnsutil.DNS_RCODE_FORMERR}
+		b, _ := response.Pack()
+		return [][]byte{b}
+	}
+	if request.Header.Qr != dnsutil.DNS_QR_REQUEST {
+		return nil
+	}
+	var responses []*dnsutil.DnsMessage
+	if request.Header.OpCode == dnsutil.DNS_OPCODE_NOTIFY {
+		responses = []*dnsutil.DnsMessage{c.onNotify(request, clientAddr)}
+	} else if isTransferRequest(request) {
+		responses = c.transfer(request, clientAddr, isUdp)
+	} else {
+		responses = []*dnsutil.DnsMessage{c.Query(request)}
+	}
+
+	maxLength := dnsutil.DNS_MESSAGE_MAX_LENGTH
+	if isUdp {
+		maxLength = getUdpMaxLength(request)
+	}
+	bs := make([][]byte, 0, len(responses))
+	for _, response := range responses {
+		b, err := packResponse(response, maxLength)
+		if err != nil {
+			belogs.Error("AuthoritativeServer.processRequest(): packResponse fail:", request.Header.Id, err)
+			response = dnsutil.NewDnsResponse(request, uint16(dnsutil.DNS_RCODE_SERVFAIL))
+			b, _ = response.Pack()
+			return [][]byte{b}
+		}
+		bs = append(bs, b)
+	}
+	return bs
+}
+
+// getUdpMaxLength: 512 without edns, or udp size of edns but not more than DNS_EDNS_DEFAULT_UDP_SIZE, rfc6891 6.2.5
+func getUdpMaxLength(request *dnsutil.DnsMessage) int {
+	if request.Edns == nil || request.Edns.UdpSize <= dnsutil.DNS_UDP_MAX_LENGTH {
+		return dnsutil.DNS_UDP_MAX_LENGTH
+	}
+	if request.Edns.UdpSize > dnsutil.DNS_EDNS_DEFAULT_UDP_SIZE {
+		return dnsutil.DNS_EDNS_DEFAULT_UDP_SIZE
+	}
+	return int(request.Edns.UdpSize)
+}
+
+// packResponse: when response is longer than maxLength, additionals are removed first except glue in referral,
+// then TC is set and all records are removed, rfc2181 9
+func packResponse(response *dnsutil.DnsMessage, maxLength int) ([]byte, error) {
+	b, err := response.Pack()
+	if err != nil || len(b) <= maxLength {
+		return b, err
+	}
+	isReferral := len(response.Answers) == 0 && len(response.Authorities) > 0 &&
+		response.Authorities[0].Type == dnsutil.DNS_TYPE_INT_NS
+	if len(response.Additionals) > 0 && !isReferral {
+		response.Additionals = make([]*dnsutil.DnsRr, 0)
+		if b, err = response.Pack(); err != nil || len(b) <= maxLength {
+			return b, err
+		}
+	}
+	response.Header.Tc = true
+	response.Answers = make([]*dnsutil.DnsRr, 0)
+	response.Authorities = make([]*dnsutil.DnsRr, 0)
+	response.Additionals = make([]*dnsutil.DnsRr, 0)
+	return response.Pack()
+}
+
+// Start starts udp and tcp server on port, such as "53", and reloads changed zone files
+// every reloadIntervalSeconds (0 is no reload)
+func (c *AuthoritativeServer) Start(port string, reloadIntervalSeconds int) error {
+	c.udpServer = transportutil.NewUdpServer(&authoritativeUdpServerProcess{server: c},
+		make(chan transportutil.BusinessToConnMsg, 16), AUTHORITATIVE_UDP_RECEIVE_LENGTH)
+	if err := c.udpServer.StartUdpServer(port); err != nil {
+		belogs.Error("AuthoritativeServer.Start(): StartUdpServer fail:", port, err)
+		return err
+	}
+	c.tcpServer = transportutil.NewTcpServer(&authoritativeTcpServerProcess{server: c},
+		make(chan transportutil.BusinessToConnMsg, 16), AUTHORITATIVE_TCP_LENGTH_DECLARATION, AUTHORITATIVE_TCP_RECEIVE_LENGTH)
+	go func() {
+		if err := c.tcpServer.StartTcpServer(port); err != nil {
+			belogs.Error("AuthoritativeServer.Start(): StartTcpServer fail:", port, err)
+		}
+	}()
+	if reloadIntervalSeconds > 0 {
+		go func() {
+			ticker := time.NewTicker(time.Duration(reloadIntervalSeconds) * time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					c.ReloadZones()
+				case <-c.closeCh:
+					return
+				}
+			}
+		}()
+	}
+	belogs.Info("AuthoritativeServer.Start(): udp and tcp server start:", port)
+	return nil
+}
+
+// Close closes udp and tcp server
+func (c *AuthoritativeServer) Close() {
+	c.closeOnce.Do(func() {
+		close(c.closeCh)
+		if c.udpServer != nil {
+			c.udpServer.SendBusinessToConnMsg(&transportutil.BusinessToConnMsg{
+				BusinessToConnMsgType: transportutil.BUSINESS_TO_CONN_MSG_TYPE_SERVER_CLOSE_FORCIBLE})
+		}
+		if c.tcpServer != nil {
+			c.tcpServer.SendMsgForCloseConnect(transportutil.BUSINESS_TO_CONN_MSG_TYPE_SERVER_CLOSE_FORCIBLE, "")
+		}
+	})
+}
+
+type authoritativeUdpServerProcess struct {
+	server *AuthoritativeServer
+}
+
+func (c *authoritativeUdpServerProcess) OnReceiveAndSendProcess(udpConn *transportutil.UdpConn, clientUdpAddr *net.UDPAddr,
+	receiveData []byte) (err error) {
+	for _, b := range c.server.processRequest(receiveData, clientUdpAddr.AddrPort().Addr(), true) {
+		if _, err = udpConn.WriteToClient(b, transportutil.GetUdpAddrKey(clientUdpAddr)); err != nil {
+			belogs.Error("authoritativeUdpServerProcess.OnReceiveAndSendProcess(): WriteToClient fail:", clientUdpAddr, err)
+			return err
+		}
+	}
+	return nil
+}
+
+type authoritativeTcpServerProcess struct {
+	server *AuthoritativeServer
+}
+
+func (c *authoritativeTcpServerProcess) OnConnectProcess(tcpConn *transportutil.TcpConn) {
+	belogs.Debug("authoritativeTcpServerProcess.OnConnectProcess(): connKey:", transportutil.GetTcpConnKey(tcpConn))
+}
+
+func (c *authoritativeTcpServerProcess) OnReceiveAndSendProcess(tcpConn *transportutil.TcpConn,
+	receiveData []byte) (nextConnectPolicy int, leftData []byte, err error) {
+	var clientAddr netip.Addr
+	if tcpAddr, ok := tcpConn.RemoteAddr().(*net.TCPAddr); ok {
+		clientAddr = tcpAddr.AddrPort().Addr()
+	}
+	msgs, leftData := dnsutil.SplitTcpDnsMessages(receiveData)
+	for _, msg := range msgs {
+		for _, b := range c.server.processRequest(msg, clientAddr, false) {
+			if _, err = tcpConn.Write(dnsutil.GetTcpDnsMessage(b)); err != nil {
+				belogs.Error("authoritativeTcpServerProcess.OnReceiveAndSendProcess(): Write fail:",
+					transportutil.GetTcpConnKey(tcpConn), err)
+				return transportutil.NEXT_CONNECT_POLICY_CLOSE_FORCIBLE, nil, err
+			}
+		}
+	}
+	return transportutil.NEXT_CONNECT_POLICY_KEEP, leftData, nil
+}
+
+func (c *authoritativeTcpServerProcess) OnCloseProcess(tcpConn *transportutil.TcpConn) {
+	belogs.Debug("authoritativeTcpServerProcess.OnCloseProcess(): connKey:", transportutil.GetTcpConnKey(tcpConn))
+}
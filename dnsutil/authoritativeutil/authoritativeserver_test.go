@@ -0,0 +1,231 @@
+package authoritativeutil
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cpusoft/goutil/dnsutil"
+)
+
+const testZone = `$ORIGIN example.com.
+$TTL 3600
+@ IN SOA ns1 hostmaster 1 7200 3600 1209600 300
+  IN NS ns1
+  IN MX 10 mail
+ns1 IN A 192.0.2.1
+mail IN A 192.0.2.2
+www IN CNAME web
+web IN A 192.0.2.3
+loop1 IN CNAME loop2
+loop2 IN CNAME loop1
+out IN CNAME www.example.net.
+a.b.c IN A 192.0.2.4
+sub IN NS ns.sub
+ns.sub IN A 192.0.2.5
+`
+
+func newTestAuthoritativeServer(t *testing.T) (*AuthoritativeServer, string) {
+	zoneFileName := filepath.Join(t.TempDir(), "example.com.zone")
+	if err := os.WriteFile(zoneFileName, []byte(testZone), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := NewAuthoritativeServer()
+	if err := server.LoadZoneFile(zoneFileName); err != nil {
+		t.Fatal(err)
+	}
+	return server, zoneFileName
+}
+
+func TestAuthoritativeServerQuery(t *testing.T) {
+	server, _ := newTestAuthoritativeServer(t)
+
+	// answer with additionals
+	response := server.Query(dnsutil.NewDnsQuery(1, "example.com", dnsutil.DNS_TYPE_INT_MX))
+	fmt.Println(response)
+	if !response.Header.Aa || len(response.Answers) != 1 || len(response.Additionals) != 1 ||
+		response.Additionals[0].Name != "mail.example.com." {
+		t.Fatal("MX is wrong:", response)
+	}
+
+	// cname in zone is chased
+	response = server.Query(dnsutil.NewDnsQuery(2, "WWW.example.com", dnsutil.DNS_TYPE_INT_A))
+	if len(response.Answers) != 2 || response.Answers[0].Type != dnsutil.DNS_TYPE_INT_CNAME ||
+		response.Answers[1].Name != "web.example.com." {
+		t.Fatal("CNAME is wrong:", response)
+	}
+	response = server.Query(dnsutil.NewDnsQuery(3, "out.example.com", dnsutil.DNS_TYPE_INT_A))
+	if len(response.Answers) != 1 || response.Header.RCode != dnsutil.DNS_RCODE_NOERROR {
+		t.Fatal("CNAME out of zone is wrong:", response)
+	}
+	response = server.Query(dnsutil.NewDnsQuery(4, "loop1.example.com", dnsutil.DNS_TYPE_INT_A))
+	if len(response.Answers) != 2 {
+		t.Fatal("CNAME loop is wrong:", response)
+	}
+
+	// NXDOMAIN, NODATA and empty non-terminal
+	response = server.Query(dnsutil.NewDnsQuery(5, "none.example.com", dnsutil.DNS_TYPE_INT_A))
+	if response.Header.RCode != dnsutil.DNS_RCODE_NXDOMAIN || len(response.Authorities) != 1 ||
+		response.Authorities[0].Ttl != 300 {
+		t.Fatal("NXDOMAIN is wrong:", response)
+	}
+	response = server.Query(dnsutil.NewDnsQuery(6, "web.example.com", dnsutil.DNS_TYPE_INT_AAAA))
+	if response.Header.RCode != dnsutil.DNS_RCODE_NOERROR || len(response.Answers) != 0 ||
+		len(response.Authorities) != 1 || response.Authorities[0].Type != dnsutil.DNS_TYPE_INT_SOA {
+		t.Fatal("NODATA is wrong:", response)
+	}
+	response = server.Query(dnsutil.NewDnsQuery(7, "b.c.example.com", dnsutil.DNS_TYPE_INT_A))
+	if response.Header.RCode != dnsutil.DNS_RCODE_NOERROR || len(response.Answers) != 0 {
+		t.Fatal("empty non-terminal is wrong:", response)
+	}
+
+	// referral with glue
+	response = server.Query(dnsutil.NewDnsQuery(8, "www.sub.example.com", dnsutil.DNS_TYPE_INT_A))
+	if response.Header.Aa || len(response.Answers) != 0 || len(response.Authorities) != 1 ||
+		len(response.Additionals) != 1 || response.Additionals[0].Name != "ns.sub.example.com." {
+		t.Fatal("referral is wrong:", response)
+	}
+
+	// refused
+	response = server.Query(dnsutil.NewDnsQuery(9, "www.example.net", dnsutil.DNS_TYPE_INT_A))
+	if response.Header.RCode != dnsutil.DNS_RCODE_REFUSED || response.Header.Aa {
+		t.Fatal("out of zone is wrong:", response)
+	}
+}
+
+func TestAuthoritativeServerDs(t *testing.T) {
+	zoneFileName := filepath.Join(t.TempDir(), "example.com.zone")
+	zone := testZone + "sub IN TYPE43 \\# 6 0001020300ab\n"
+	if err := os.WriteFile(zoneFileName, []byte(zone), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := NewAuthoritativeServer()
+	if err := server.LoadZoneFile(zoneFileName); err != nil {
+		t.Fatal(err)
+	}
+	// DS is answered by parent
+	response := server.Query(dnsutil.NewDnsQuery(1, "sub.example.com", AUTHORITATIVE_TYPE_INT_DS))
+	if !response.Header.Aa || len(response.Answers) != 1 {
+		t.Fatal("DS is wrong:", response)
+	}
+}
+
+func TestPackResponse(t *testing.T) {
+	server, _ := newTestAuthoritativeServer(t)
+	request := dnsutil.NewDnsQuery(1, "example.com", dnsutil.DNS_TYPE_INT_MX)
+	response := server.Query(request)
+	b, err := packResponse(response, dnsutil.DNS_HEADER_LENGTH+30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated, err := dnsutil.UnpackDnsMessage(b)
+	if err != nil || !truncated.Header.Tc || len(truncated.Answers) != 0 {
+		t.Fatal("truncated is wrong:", truncated, err)
+	}
+
+	// edns size
+	request.SetEdns(4096, false)
+	if getUdpMaxLength(request) != dnsutil.DNS_EDNS_DEFAULT_UDP_SIZE {
+		t.Fatal("udp max length is wrong")
+	}
+	request.SetEdns(100, false)
+	if getUdpMaxLength(request) != dnsutil.DNS_UDP_MAX_LENGTH {
+		t.Fatal("udp max length is wrong")
+	}
+
+	// FORMERR for header only
+	bs := server.processRequest([]byte{0x12, 0x34, 0x00, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}, netip.MustParseAddr("127.0.0.1"), true)
+	if len(bs) != 1 {
+		t.Fatal("FORMERR is not responded")
+	}
+	formErr, err := dnsutil.UnpackDnsMessage(bs[0])
+	if err != nil || formErr.Header.Id != 0x1234 || formErr.Header.RCode != dnsutil.DNS_RCODE_FORMERR {
+		t.Fatal("FORMERR is wrong:", formErr, err)
+	}
+}
+
+func TestAuthoritativeServerReload(t *testing.T) {
+	server, zoneFileName := newTestAuthoritativeServer(t)
+	zone := strings.Replace(testZone, "192.0.2.3", "192.0.2.33", 1)
+	if err := os.WriteFile(zoneFileName, []byte(zone), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(zoneFileName, future, future)
+	server.ReloadZones()
+	response := server.Query(dnsutil.NewDnsQuery(1, "web.example.com", dnsutil.DNS_TYPE_INT_A))
+	if len(response.Answers) != 1 || response.Answers[0].Data.String() != "192.0.2.33" {
+		t.Fatal("reload is wrong:", response)
+	}
+
+	// invalid zone file keeps old zone
+	os.WriteFile(zoneFileName, []byte("$ORIGIN example.com.\nwww IN A 1.2.3\n"), 0644)
+	future = future.Add(time.Minute)
+	os.Chtimes(zoneFileName, future, future)
+	server.ReloadZones()
+	response = server.Query(dnsutil.NewDnsQuery(2, "web.example.com", dnsutil.DNS_TYPE_INT_A))
+	if len(response.Answers) != 1 {
+		t.Fatal("old zone is not kept:", response)
+	}
+}
+
+func TestAuthoritativeServerStart(t *testing.T) {
+	server, _ := newTestAuthoritativeServer(t)
+	if err := server.Start("9954", 0); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	time.Sleep(200 * time.Millisecond)
+
+	request, _ := dnsutil.NewDnsQuery(1, "web.example.com", dnsutil.DNS_TYPE_INT_A).Pack()
+	udpConn, err := net.Dial("udp", "127.0.0.1:9954")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpConn.Close()
+	udpConn.SetDeadline(time.Now().Add(3 * time.Second))
+	if _, err = udpConn.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, 512)
+	n, err := udpConn.Read(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := dnsutil.UnpackDnsMessage(buffer[:n])
+	fmt.Println("udp:", response, err)
+	if err != nil || len(response.Answers) != 1 {
+		t.Fatal("udp response is wrong:", response, err)
+	}
+
+	tcpConn, err := net.Dial("tcp", "127.0.0.1:9954")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpConn.Close()
+	tcpConn.SetDeadline(time.Now().Add(3 * time.Second))
+	if _, err = tcpConn.Write(dnsutil.GetTcpDnsMessage(request)); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 0)
+	for {
+		n, err = tcpConn.Read(buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, buffer[:n]...)
+		if msgs, _ := dnsutil.SplitTcpDnsMessages(data); len(msgs) > 0 {
+			response, err = dnsutil.UnpackDnsMessage(msgs[0])
+			break
+		}
+	}
+	fmt.Println("tcp:", response, err)
+	if err != nil || len(response.Answers) != 1 {
+		t.Fatal("tcp response is wrong:", response, err)
+	}
+}
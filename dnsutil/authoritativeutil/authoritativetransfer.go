@@ -0,0 +1,234 @@
+package authoritativeutil
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/cpusoft/goutil/belogs"
+	"github.com/cpusoft/goutil/dnsutil"
+)
+
+const (
+	AUTHORITATIVE_DEFAULT_PORT = 53
+	// NOTIFY is sent again when there is no response in timeout, rfc1996 3.6
+	AUTHORITATIVE_NOTIFY_TIMEOUT_SECONDS = 2
+	AUTHORITATIVE_NOTIFY_RETRY_COUNT     = 5
+)
+
+// SetSecondaries sets secondaries of zone, such as "192.0.2.1:53", "[2001:db8::1]:53" or "192.0.2.1" (port is 53).
+// NOTIFY is sent to secondaries after zone is changed, and only secondaries can transfer zone by AXFR/IXFR
+func (c *AuthoritativeServer) SetSecondaries(origin string, secondaries []string) error {
+	addrPorts := make([]netip.AddrPort, 0, len(secondaries))
+	for _, secondary := range secondaries {
+		addrPort, err := parseAddrPort(secondary)
+		if err != nil {
+			belogs.Error("AuthoritativeServer.SetSecondaries(): parseAddrPort fail:", origin, secondary, err)
+			return err
+		}
+		addrPorts = append(addrPorts, addrPort)
+	}
+	c.transferMutex.Lock()
+	defer c.transferMutex.Unlock()
+	c.secondaries[dnsutil.FormatDomainFqdn(origin)] = addrPorts
+	return nil
+}
+
+// parseAddrPort: ip and port, or only ip with port 53
+func parseAddrPort(s string) (netip.AddrPort, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return netip.AddrPortFrom(addr.Unmap(), AUTHORITATIVE_DEFAULT_PORT), nil
+	}
+	addrPort, err := netip.ParseAddrPort(s)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	return netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port()), nil
+}
+
+func (c *AuthoritativeServer) getSecondaries(origin string) []netip.AddrPort {
+	c.transferMutex.RLock()
+	defer c.transferMutex.RUnlock()
+	return c.secondaries[origin]
+}
+
+func (c *AuthoritativeServer) isTransferAllowed(origin string, clientAddr netip.Addr) bool {
+	for _, secondary := range c.getSecondaries(origin) {
+		if secondary.Addr() == clientAddr.Unmap() {
+			return true
+		}
+	}
+	return false
+}
+
+func isTransferRequest(request *dnsutil.DnsMessage) bool {
+	return request.Header.OpCode == dnsutil.DNS_OPCODE_QUERY && len(request.Questions) == 1 &&
+		(request.Questions[0].Type == dnsutil.DNS_TYPE_INT_AXFR || request.Questions[0].Type == dnsutil.DNS_TYPE_INT_IXFR)
+}
+
+// transfer answers AXFR (rfc5936) and IXFR (rfc1995), response may be split to more messages
+func (c *AuthoritativeServer) transfer(request *dnsutil.DnsMessage, clientAddr netip.Addr, isUdp bool) []*dnsutil.DnsMessage {
+	question := request.Questions[0]
+	response := dnsutil.NewDnsResponse(request, uint16(dnsutil.DNS_RCODE_NOERROR))
+	zone := c.getZone(question.Name)
+	if zone == nil || zone.origin != dnsutil.FormatDomainFqdn(question.Name) || question.Class != dnsutil.DNS_CLASS_INT_IN {
+		// rfc5936 2.2.1
+		response.SetRCode(uint16(dnsutil.DNS_RCODE_NOTAUTH))
+		return []*dnsutil.DnsMessage{response}
+	}
+	if !c.isTransferAllowed(zone.origin, clientAddr) {
+		belogs.Info("AuthoritativeServer.transfer(): transfer is refused:", zone.origin, clientAddr)
+		response.SetRCode(uint16(dnsutil.DNS_RCODE_REFUSED))
+		return []*dnsutil.DnsMessage{response}
+	}
+
+	var rrs []*dnsutil.DnsRr
+	if question.Type == dnsutil.DNS_TYPE_INT_AXFR {
+		// AXFR over udp is not defined, rfc5936 4.2
+		if isUdp {
+			response.SetRCode(uint16(dnsutil.DNS_RCODE_REFUSED))
+			return []*dnsutil.DnsMessage{response}
+		}
+		rrs = zone.getAxfrRrs()
+	} else {
+		// SOA of client is in authority section, rfc1995 3
+		if len(request.Authorities) != 1 || request.Authorities[0].Type != dnsutil.DNS_TYPE_INT_SOA {
+			response.SetRCode(uint16(dnsutil.DNS_RCODE_FORMERR))
+			return []*dnsutil.DnsMessage{response}
+		}
+		rrs = zone.getIxfrRrs(getSoaSerial(request.Authorities[0]))
+		// only SOA when it does not fit in udp, rfc1995 2
+		if isUdp {
+			response.Header.Aa = true
+			response.Answers = rrs
+			if b, err := response.Pack(); err != nil || len(b) > getUdpMaxLength(request) {
+				response.Answers = []*dnsutil.DnsRr{zone.soa}
+			}
+			return []*dnsutil.DnsMessage{response}
+		}
+	}
+	responses, err := getTransferResponses(request, rrs, dnsutil.DNS_MESSAGE_MAX_LENGTH)
+	if err != nil {
+		belogs.Error("AuthoritativeServer.transfer(): getTransferResponses fail:", zone.origin, err)
+		response.SetRCode(uint16(dnsutil.DNS_RCODE_SERVFAIL))
+		return []*dnsutil.DnsMessage{response}
+	}
+	belogs.Info("AuthoritativeServer.transfer(): transfer zone:", zone.origin, dnsutil.GetDnsTypeStr(question.Type), clientAddr,
+		"  len(rrs):", len(rrs), "  len(responses):", len(responses))
+	return responses
+}
+
+// getTransferResponses splits rrs into responses which are not longer than maxLength,
+// question is only in the first response, rfc5936 2.2.1
+func getTransferResponses(request *dnsutil.DnsMessage, rrs []*dnsutil.DnsRr, maxLength int) ([]*dnsutil.DnsMessage, error) {
+	newResponse := func(isFirst bool) (*dnsutil.DnsMessage, int, error) {
+		response := dnsutil.NewDnsResponse(request, uint16(dnsutil.DNS_RCODE_NOERROR))
+		response.Header.Aa = true
+		if !isFirst {
+			response.Questions = make([]*dnsutil.DnsQuestion, 0)
+		}
+		b, err := response.Pack()
+		return response, len(b), err
+	}
+	response, length, err := newResponse(true)
+	if err != nil {
+		return nil, err
+	}
+	responses := []*dnsutil.DnsMessage{response}
+	for _, rr := range rrs {
+		rrLength, err := getRrLength(rr)
+		if err != nil {
+			return nil, err
+		}
+		if length+rrLength > maxLength && len(response.Answers) > 0 {
+			if response, length, err = newResponse(false); err != nil {
+				return nil, err
+			}
+			responses = append(responses, response)
+		}
+		if length+rrLength > maxLength {
+			return nil, errors.New(rr.Name + " is too long to be in one message")
+		}
+		response.Answers = append(response.Answers, rr)
+		length += rrLength
+	}
+	return responses, nil
+}
+
+// getRrLength: length of rr when it is packed alone, it is not shorter than in message which has more names to compress
+func getRrLength(rr *dnsutil.DnsRr) (int, error) {
+	m := dnsutil.NewDnsMessage()
+	m.Answers = append(m.Answers, rr)
+	b, err := m.Pack()
+	if err != nil {
+		return 0, err
+	}
+	return len(b) - dnsutil.DNS_HEADER_LENGTH, nil
+}
+
+// NotifySecondaries sends NOTIFY of zone to all secondaries in background, rfc1996 3.5
+func (c *AuthoritativeServer) NotifySecondaries(origin string) {
+	origin = dnsutil.FormatDomainFqdn(origin)
+	c.zonesMutex.RLock()
+	zone, ok := c.zones[origin]
+	c.zonesMutex.RUnlock()
+	if !ok {
+		return
+	}
+	for _, secondary := range c.getSecondaries(origin) {
+		go func(secondary netip.AddrPort) {
+			if err := sendNotify(origin, zone.soa, secondary); err != nil {
+				belogs.Error("AuthoritativeServer.NotifySecondaries(): sendNotify fail:", origin, secondary, err)
+				return
+			}
+			belogs.Info("AuthoritativeServer.NotifySecondaries(): sendNotify ok:", origin, secondary)
+		}(secondary)
+	}
+}
+
+// sendNotify sends NOTIFY with SOA over udp until it is responded, rfc1996 3.6 and 3.7
+func sendNotify(origin string, soa *dnsutil.DnsRr, secondary netip.AddrPort) error {
+	request := dnsutil.NewDnsMessage()
+	request.Header = dnsutil.DnsHeader{Id: uint16(rand.Uint32()), Qr: dnsutil.DNS_QR_REQUEST,
+		OpCode: dnsutil.DNS_OPCODE_NOTIFY, Aa: true}
+	request.Questions = append(request.Questions, &dnsutil.DnsQuestion{Name: origin, Type: dnsutil.DNS_TYPE_INT_SOA,
+		Class: dnsutil.DNS_CLASS_INT_IN})
+	request.Answers = append(request.Answers, soa)
+	b, err := request.Pack()
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(secondary))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	buffer := make([]byte, dnsutil.DNS_EDNS_DEFAULT_UDP_SIZE)
+	for i := 0; i < AUTHORITATIVE_NOTIFY_RETRY_COUNT; i++ {
+		if _, err = conn.Write(b); err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(AUTHORITATIVE_NOTIFY_TIMEOUT_SECONDS * time.Second))
+		for {
+			n, err := conn.Read(buffer)
+			if err != nil {
+				belogs.Debug("sendNotify(): Read fail, will retry:", origin, secondary, i, err)
+				break
+			}
+			response, err := dnsutil.UnpackDnsMessage(buffer[:n])
+			if err != nil || response.Header.Id != request.Header.Id || response.Header.Qr != dnsutil.DNS_QR_RESPONSE ||
+				response.Header.OpCode != dnsutil.DNS_OPCODE_NOTIFY {
+				continue
+			}
+			if rCode := response.GetRCode(); rCode != uint16(dnsutil.DNS_RCODE_NOERROR) {
+				return errors.New("rCode of NOTIFY response is " + strconv.Itoa(int(rCode)))
+			}
+			return nil
+		}
+	}
+	return errors.New("NOTIFY is not responded by " + secondary.String())
+}
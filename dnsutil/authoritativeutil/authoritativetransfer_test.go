@@ -0,0 +1,206 @@
+package authoritativeutil
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cpusoft/goutil/dnsutil"
+	"github.com/cpusoft/goutil/zonefileutil"
+)
+
+// updateTestZone adds new.example.com and deletes web.example.com, serial is increased
+func updateTestZone(t *testing.T, server *AuthoritativeServer) {
+	zoneFileModel := server.GetZoneFileModel("example.com")
+	u := dnsutil.NewDnsUpdate(1, "example.com")
+	u.AddUpdateAddRr(&dnsutil.DnsRr{Name: "new.example.com.", Type: dnsutil.DNS_TYPE_INT_A, Class: dnsutil.DNS_CLASS_INT_IN,
+		Ttl: 600, Data: &dnsutil.DnsRrDataA{Ip: netip.MustParseAddr("192.0.2.6")}})
+	u.AddUpdateDelRrset("web.example.com.", dnsutil.DNS_TYPE_INT_A)
+	if _, _, err := zonefileutil.ApplyDnsUpdate(zoneFileModel, u.GetDnsMessage()); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.SetZone(zoneFileModel); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestTransferRequest(qType uint16, serial uint32) *dnsutil.DnsMessage {
+	request := dnsutil.NewDnsQuery(1, "example.com", qType)
+	if qType == dnsutil.DNS_TYPE_INT_IXFR {
+		request.Authorities = append(request.Authorities, &dnsutil.DnsRr{Name: "example.com.", Type: dnsutil.DNS_TYPE_INT_SOA,
+			Class: dnsutil.DNS_CLASS_INT_IN, Data: &dnsutil.DnsRrDataSoa{MName: "ns1.example.com.",
+				RName: "hostmaster.example.com.", Serial: serial}})
+	}
+	return request
+}
+
+func getTestTransferRrs(t *testing.T, responses []*dnsutil.DnsMessage) []*dnsutil.DnsRr {
+	rrs := make([]*dnsutil.DnsRr, 0)
+	for _, response := range responses {
+		if response.Header.RCode != dnsutil.DNS_RCODE_NOERROR {
+			t.Fatal("transfer fail:", response)
+		}
+		rrs = append(rrs, response.Answers...)
+	}
+	return rrs
+}
+
+func TestAuthoritativeTransfer(t *testing.T) {
+	server, _ := newTestAuthoritativeServer(t)
+	if err := server.SetSecondaries("example.com", []string{"127.0.0.1:9957"}); err != nil {
+		t.Fatal(err)
+	}
+	secondary := netip.MustParseAddr("127.0.0.1")
+
+	// refused or not auth
+	for _, c := range []struct {
+		request    *dnsutil.DnsMessage
+		clientAddr netip.Addr
+		isUdp      bool
+		rCode      uint8
+	}{
+		{newTestTransferRequest(dnsutil.DNS_TYPE_INT_AXFR, 0), netip.MustParseAddr("192.0.2.9"), false, dnsutil.DNS_RCODE_REFUSED},
+		{newTestTransferRequest(dnsutil.DNS_TYPE_INT_AXFR, 0), secondary, true, dnsutil.DNS_RCODE_REFUSED},
+		{dnsutil.NewDnsQuery(1, "www.example.com", dnsutil.DNS_TYPE_INT_AXFR), secondary, false, dnsutil.DNS_RCODE_NOTAUTH},
+		{newTestTransferRequest(dnsutil.DNS_TYPE_INT_AXFR, 0), secondary, false, dnsutil.DNS_RCODE_NOERROR},
+	} {
+		responses := server.transfer(c.request, c.clientAddr, c.isUdp)
+		if len(responses) != 1 || responses[0].Header.RCode != c.rCode {
+			t.Fatal("rCode is wrong:", c.request, c.clientAddr, responses)
+		}
+	}
+
+	// AXFR
+	axfrRrs := getTestTransferRrs(t, server.transfer(newTestTransferRequest(dnsutil.DNS_TYPE_INT_AXFR, 0), secondary, false))
+	oldRrs, _, complete, err := parseTransferRrs(axfrRrs)
+	if err != nil || !complete || len(axfrRrs) != 14 || axfrRrs[13].Type != dnsutil.DNS_TYPE_INT_SOA {
+		t.Fatal("AXFR is wrong:", len(axfrRrs), complete, err)
+	}
+
+	// IXFR from journal
+	updateTestZone(t, server)
+	ixfrRrs := getTestTransferRrs(t, server.transfer(newTestTransferRequest(dnsutil.DNS_TYPE_INT_IXFR, 1), secondary, false))
+	for _, rr := range ixfrRrs {
+		fmt.Println(rr)
+	}
+	if len(ixfrRrs) != 6 || getSoaSerial(ixfrRrs[0]) != 2 || getSoaSerial(ixfrRrs[1]) != 1 || ixfrRrs[2].Name != "web.example.com." ||
+		getSoaSerial(ixfrRrs[3]) != 2 || ixfrRrs[4].Name != "new.example.com." || getSoaSerial(ixfrRrs[5]) != 2 {
+		t.Fatal("IXFR is wrong")
+	}
+	_, deltas, complete, err := parseTransferRrs(ixfrRrs)
+	if err != nil || !complete || len(deltas) != 1 {
+		t.Fatal("parseTransferRrs fail:", complete, err)
+	}
+	newRrs, err := applyZoneDeltas(oldRrs, deltas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zone := server.getZone("example.com.")
+	if len(getRrsDiff(newRrs, zone.dnsRrs)) != 0 || len(getRrsDiff(zone.dnsRrs, newRrs)) != 0 ||
+		getSoaSerial(newRrs[0]) != 2 {
+		t.Fatal("applyZoneDeltas is wrong")
+	}
+	if _, _, complete, _ = parseTransferRrs(ixfrRrs[:4]); complete {
+		t.Fatal("IXFR should not be complete")
+	}
+
+	// up to date, and serial is not in journal
+	rrs := getTestTransferRrs(t, server.transfer(newTestTransferRequest(dnsutil.DNS_TYPE_INT_IXFR, 2), secondary, true))
+	if len(rrs) != 1 || getSoaSerial(rrs[0]) != 2 {
+		t.Fatal("IXFR up to date is wrong:", rrs)
+	}
+	rrs = getTestTransferRrs(t, server.transfer(newTestTransferRequest(dnsutil.DNS_TYPE_INT_IXFR, 0), secondary, false))
+	if len(rrs) != 14 || rrs[1].Type == dnsutil.DNS_TYPE_INT_SOA {
+		t.Fatal("IXFR without journal should be AXFR:", len(rrs))
+	}
+	// only SOA when IXFR does not fit in udp
+	for i := 0; i < 10; i++ {
+		updateTestZone(t, server)
+		zoneFileModel := server.GetZoneFileModel("example.com")
+		u := dnsutil.NewDnsUpdate(1, "example.com")
+		u.AddUpdateDelRrset("new.example.com.", dnsutil.DNS_TYPE_INT_A)
+		zonefileutil.ApplyDnsUpdate(zoneFileModel, u.GetDnsMessage())
+		server.SetZone(zoneFileModel)
+	}
+	rrs = getTestTransferRrs(t, server.transfer(newTestTransferRequest(dnsutil.DNS_TYPE_INT_IXFR, 1), secondary, true))
+	if len(rrs) != 1 {
+		t.Fatal("IXFR over udp should be only SOA:", len(rrs))
+	}
+}
+
+func TestGetTransferResponses(t *testing.T) {
+	server, _ := newTestAuthoritativeServer(t)
+	rrs := server.getZone("example.com.").getAxfrRrs()
+	request := newTestTransferRequest(dnsutil.DNS_TYPE_INT_AXFR, 0)
+	responses, err := getTransferResponses(request, rrs, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for i, response := range responses {
+		b, err := response.Pack()
+		if err != nil || len(b) > 200 {
+			t.Fatal("response is too long:", len(b), err)
+		}
+		if (i == 0) != (len(response.Questions) == 1) {
+			t.Fatal("question should be only in the first response")
+		}
+		count += len(response.Answers)
+	}
+	if len(responses) < 2 || count != len(rrs) {
+		t.Fatal("responses are wrong:", len(responses), count)
+	}
+	if _, err = getTransferResponses(request, rrs, 40); err == nil {
+		t.Fatal("rr longer than message should fail")
+	}
+}
+
+func TestAuthoritativeSecondary(t *testing.T) {
+	primary, _ := newTestAuthoritativeServer(t)
+	if err := primary.SetSecondaries("example.com", []string{"127.0.0.1:9956"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := primary.Start("9955", 0); err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+	secondary := NewAuthoritativeServer()
+	if err := secondary.Start("9956", 0); err != nil {
+		t.Fatal(err)
+	}
+	defer secondary.Close()
+	time.Sleep(200 * time.Millisecond)
+
+	zoneFileName := filepath.Join(t.TempDir(), "secondary.zone")
+	if err := secondary.AddSecondaryZone("example.com", zoneFileName, "127.0.0.1:9955"); err != nil {
+		t.Fatal(err)
+	}
+	waitAnswers := func(name string, count int) {
+		for i := 0; i < 50; i++ {
+			response := secondary.Query(dnsutil.NewDnsQuery(1, name, dnsutil.DNS_TYPE_INT_A))
+			if response.Header.RCode != dnsutil.DNS_RCODE_REFUSED && len(response.Answers) == count {
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		t.Fatal("secondary is not refreshed:", name)
+	}
+	// AXFR
+	waitAnswers("web.example.com", 1)
+
+	// NOTIFY and IXFR
+	updateTestZone(t, primary)
+	waitAnswers("new.example.com", 1)
+	waitAnswers("web.example.com", 0)
+	b, err := os.ReadFile(zoneFileName)
+	if err != nil || !strings.Contains(string(b), "192.0.2.6") || strings.Contains(string(b), "192.0.2.3") {
+		t.Fatal("zone file is not saved:", string(b), err)
+	}
+	if err = secondary.RefreshSecondaryZone("example.com"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,211 @@
+package authoritativeutil
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cpusoft/goutil/belogs"
+	"github.com/cpusoft/goutil/dnsutil"
+	"github.com/cpusoft/goutil/zonefileutil"
+)
+
+const (
+	// max count of CNAME in one answer, to avoid loop
+	AUTHORITATIVE_CNAME_MAX_CHAIN = 8
+	// DS is in parent side of zone cut, rfc4035 3.1.4.1
+	AUTHORITATIVE_TYPE_INT_DS = 43
+)
+
+// authoritativeZone is snapshot of one zone for query, it is rebuilt when zone is loaded or changed
+type authoritativeZone struct {
+	zoneFileModel *zonefileutil.ZoneFileModel
+	// lower and ends with "."
+	origin string
+	soa    *dnsutil.DnsRr
+	// all rrs in zone file order, for zone transfer
+	dnsRrs []*dnsutil.DnsRr
+	// lower name --> rrs in zone file order
+	rrs map[string][]*dnsutil.DnsRr
+	// names which have rrs, and empty non-terminals, rfc8020
+	names map[string]bool
+	// modTime of zone file when it is loaded
+	modTime time.Time
+	// journal for IXFR, the oldest is the first
+	deltas []*zoneDelta
+}
+
+func newAuthoritativeZone(zoneFileModel *zonefileutil.ZoneFileModel, modTime time.Time) (*authoritativeZone, error) {
+	dnsRrs, err := zonefileutil.GetDnsRrs(zoneFileModel)
+	if err != nil {
+		belogs.Error("newAuthoritativeZone(): GetDnsRrs fail:", err)
+		return nil, err
+	}
+	c := &authoritativeZone{
+		zoneFileModel: zoneFileModel,
+		origin:        dnsutil.FormatDomainFqdn(zoneFileModel.Origin),
+		dnsRrs:        dnsRrs,
+		rrs:           make(map[string][]*dnsutil.DnsRr),
+		names:         make(map[string]bool),
+		modTime:       modTime,
+	}
+	for _, rr := range dnsRrs {
+		name := dnsutil.FormatDomainFqdn(rr.Name)
+		if rr.Type == dnsutil.DNS_TYPE_INT_SOA && name == c.origin && c.soa == nil {
+			c.soa = rr
+		}
+		c.rrs[name] = append(c.rrs[name], rr)
+		for _, ancestor := range getAncestors(name, c.origin) {
+			c.names[ancestor] = true
+		}
+	}
+	if c.soa == nil {
+		belogs.Error("newAuthoritativeZone(): zone has no SOA:", c.origin)
+		return nil, errors.New("zone " + c.origin + " has no SOA")
+	}
+	return c, nil
+}
+
+// answer fills answers/authorities/additionals and rCode of response, as rfc1034 4.3.2 in this zone
+func (c *authoritativeZone) answer(question *dnsutil.DnsQuestion, response *dnsutil.DnsMessage) {
+	qName := dnsutil.FormatDomainFqdn(question.Name)
+	visited := make(map[string]bool)
+	for i := 0; i < AUTHORITATIVE_CNAME_MAX_CHAIN; i++ {
+		visited[qName] = true
+		// referral, AA is only set when there is CNAME before
+		if cut := c.getZoneCut(qName, question.Type); len(cut) > 0 {
+			response.Header.Aa = len(response.Answers) > 0
+			response.Authorities = append(response.Authorities, c.rrs[cut]...)
+			c.addAdditionals(response, c.rrs[cut])
+			return
+		}
+
+		rrs, ok := c.rrs[qName]
+		if !ok {
+			if !c.names[qName] {
+				// rCode is NXDOMAIN even after CNAME, rfc6604 2.1
+				response.SetRCode(uint16(dnsutil.DNS_RCODE_NXDOMAIN))
+			}
+			response.Authorities = append(response.Authorities, c.getNegativeSoa())
+			return
+		}
+
+		if question.Type != dnsutil.DNS_TYPE_INT_CNAME && question.Type != dnsutil.DNS_TYPE_INT_ANY {
+			if cname := getRrsByType(rrs, dnsutil.DNS_TYPE_INT_CNAME); len(cname) > 0 {
+				response.Answers = append(response.Answers, cname[0])
+				host, ok := cname[0].Data.(*dnsutil.DnsRrDataHost)
+				if !ok {
+					return
+				}
+				target := dnsutil.FormatDomainFqdn(host.Host)
+				// target out of zone is resolved by client
+				if visited[target] || !dnsutil.IsSubDomain(target, c.origin) {
+					return
+				}
+				qName = target
+				continue
+			}
+		}
+
+		matches := rrs
+		if question.Type != dnsutil.DNS_TYPE_INT_ANY {
+			matches = getRrsByType(rrs, question.Type)
+		}
+		if len(matches) == 0 {
+			// NODATA
+			response.Authorities = append(response.Authorities, c.getNegativeSoa())
+			return
+		}
+		response.Answers = append(response.Answers, matches...)
+		c.addAdditionals(response, matches)
+		return
+	}
+	belogs.Info("authoritativeZone.answer(): CNAME chain is too long:", question.Name)
+}
+
+// getZoneCut gets the highest name between origin and qName which has NS, apex is not zone cut.
+// qName with DS is answered in parent side
+func (c *authoritativeZone) getZoneCut(qName string, qType uint16) string {
+	ancestors := getAncestors(qName, c.origin)
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		name := ancestors[i]
+		if name == c.origin || (name == qName && qType == AUTHORITATIVE_TYPE_INT_DS) {
+			continue
+		}
+		if len(getRrsByType(c.rrs[name], dnsutil.DNS_TYPE_INT_NS)) > 0 {
+			return name
+		}
+	}
+	return ""
+}
+
+// getNegativeSoa: ttl is the smaller of ttl and minimum of SOA, rfc2308 3
+func (c *authoritativeZone) getNegativeSoa() *dnsutil.DnsRr {
+	soa := *c.soa
+	if data, ok := soa.Data.(*dnsutil.DnsRrDataSoa); ok && data.Minimum < soa.Ttl {
+		soa.Ttl = data.Minimum
+	}
+	return &soa
+}
+
+// addAdditionals adds A/AAAA of hosts in NS/MX/SRV when they are in zone, including glue, rfc1034 4.3.2
+func (c *authoritativeZone) addAdditionals(response *dnsutil.DnsMessage, rrs []*dnsutil.DnsRr) {
+	added := make(map[string]bool)
+	for _, rr := range response.Additionals {
+		added[dnsutil.FormatDomainFqdn(rr.Name)] = true
+	}
+	for _, rr := range rrs {
+		var host string
+		switch data := rr.Data.(type) {
+		case *dnsutil.DnsRrDataHost:
+			if rr.Type == dnsutil.DNS_TYPE_INT_NS {
+				host = data.Host
+			}
+		case *dnsutil.DnsRrDataMx:
+			host = data.Exchange
+		case *dnsutil.DnsRrDataSrv:
+			host = data.Target
+		}
+		host = dnsutil.FormatDomainFqdn(host)
+		if host == "." || added[host] {
+			continue
+		}
+		added[host] = true
+		response.Additionals = append(response.Additionals, getRrsByType(c.rrs[host], dnsutil.DNS_TYPE_INT_A)...)
+		response.Additionals = append(response.Additionals, getRrsByType(c.rrs[host], dnsutil.DNS_TYPE_INT_AAAA)...)
+	}
+}
+
+func getRrsByType(rrs []*dnsutil.DnsRr, rrType uint16) []*dnsutil.DnsRr {
+	matches := make([]*dnsutil.DnsRr, 0)
+	for _, rr := range rrs {
+		if rr.Type == rrType {
+			matches = append(matches, rr)
+		}
+	}
+	return matches
+}
+
+// getAncestors gets names from origin to name (included), lower; name should be in origin
+func getAncestors(name, origin string) []string {
+	wire, labelStarts, err := dnsutil.DomainStrToWire(name)
+	if err != nil || !dnsutil.IsSubDomain(name, origin) {
+		return nil
+	}
+	ancestors := make([]string, 0, len(labelStarts))
+	for i := len(labelStarts); i >= 0; i-- {
+		start := len(wire) - 1
+		if i < len(labelStarts) {
+			start = labelStarts[i]
+		}
+		ancestor, _, err := dnsutil.UnpackDomain(wire, start)
+		if err != nil {
+			return nil
+		}
+		ancestor = dnsutil.FormatDomainFqdn(ancestor)
+		if !dnsutil.IsSubDomain(ancestor, origin) {
+			continue
+		}
+		ancestors = append(ancestors, ancestor)
+	}
+	return ancestors
+}
@@ -23,6 +23,8 @@ const (
 	DNS_TYPE_INT_TXT   = 16
 	DNS_TYPE_INT_AAAA  = 28
 	DNS_TYPE_INT_SRV   = 33
+	DNS_TYPE_INT_OPT   = 41
+	DNS_TYPE_INT_IXFR  = 251
 	DNS_TYPE_INT_AXFR  = 252
 	DNS_TYPE_INT_MAILB = 253
 	DNS_TYPE_INT_MAILA = 254
@@ -38,6 +40,8 @@ const (
 	DNS_TYPE_STR_TXT   = "TXT"
 	DNS_TYPE_STR_AAAA  = "AAAA"
 	DNS_TYPE_STR_SRV   = "SRV"
+	DNS_TYPE_STR_OPT   = "OPT"
+	DNS_TYPE_STR_IXFR  = "IXFR"
 	DNS_TYPE_STR_AXFR  = "AXFR"
 	DNS_TYPE_STR_MAILB = "MAILB"
 	DNS_TYPE_STR_MAILA = "MAILA"
@@ -117,6 +121,8 @@ var DnsIntTypes map[uint16]string = map[uint16]string{
 	DNS_TYPE_INT_TXT:   DNS_TYPE_STR_TXT,
 	DNS_TYPE_INT_AAAA:  DNS_TYPE_STR_AAAA,
 	DNS_TYPE_INT_SRV:   DNS_TYPE_STR_SRV,
+	DNS_TYPE_INT_OPT:   DNS_TYPE_STR_OPT,
+	DNS_TYPE_INT_IXFR:  DNS_TYPE_STR_IXFR,
 	DNS_TYPE_INT_AXFR:  DNS_TYPE_STR_AXFR,
 	DNS_TYPE_INT_MAILB: DNS_TYPE_STR_MAILB,
 	DNS_TYPE_INT_MAILA: DNS_TYPE_STR_MAILA,
@@ -132,6 +138,8 @@ var DnsStrTypes map[string]uint16 = map[string]uint16{
 	DNS_TYPE_STR_TXT:   DNS_TYPE_INT_TXT,
 	DNS_TYPE_STR_AAAA:  DNS_TYPE_INT_AAAA,
 	DNS_TYPE_STR_SRV:   DNS_TYPE_INT_SRV,
+	DNS_TYPE_STR_OPT:   DNS_TYPE_INT_OPT,
+	DNS_TYPE_STR_IXFR:  DNS_TYPE_INT_IXFR,
 	DNS_TYPE_STR_AXFR:  DNS_TYPE_INT_AXFR,
 	DNS_TYPE_STR_MAILB: DNS_TYPE_INT_MAILB,
 	DNS_TYPE_STR_MAILA: DNS_TYPE_INT_MAILA,
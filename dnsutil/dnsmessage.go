@@ -0,0 +1,366 @@
+package dnsutil
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/cpusoft/goutil/belogs"
+)
+
+const (
+	DNS_HEADER_LENGTH      = 12
+	DNS_MESSAGE_MAX_LENGTH = 65535
+	// rfc1035 4.2.1 UDP usage
+	DNS_UDP_MAX_LENGTH = 512
+	// EDNS udp payload size, recommended by dns flag day 2020
+	DNS_EDNS_DEFAULT_UDP_SIZE = 1232
+)
+
+// DnsHeader is 12 bytes header, rfc1035 4.1.1
+type DnsHeader struct {
+	Id     uint16 `json:"id"`
+	Qr     uint8  `json:"qr"`
+	OpCode uint8  `json:"opCode"`
+	Aa     bool   `json:"aa"`
+	Tc     bool   `json:"tc"`
+	Rd     bool   `json:"rd"`
+	Ra     bool   `json:"ra"`
+	Z      bool   `json:"z"`
+	Ad     bool   `json:"ad"`
+	Cd     bool   `json:"cd"`
+	// low 4 bits, high 8 bits are in DnsEdns.ExtendedRCode
+	RCode uint8 `json:"rCode"`
+}
+
+// DnsEdns is OPT pseudo-RR in additional section, rfc6891 6.1
+type DnsEdns struct {
+	UdpSize       uint16          `json:"udpSize"`
+	ExtendedRCode uint8           `json:"extendedRCode"`
+	Version       uint8           `json:"version"`
+	Do            bool            `json:"do"`
+	Options       []DnsEdnsOption `json:"options"`
+}
+
+// DnsMessage is one dns message. In UPDATE (rfc2136), Questions/Answers/Authorities
+// are Zone/Prerequisite/Update sections. OPT is in Edns, not in Additionals.
+// In DSO (rfc8490), all sections are empty and TLVs are in DsoTlvs, the first is primary TLV
+type DnsMessage struct {
+	Header      DnsHeader      `json:"header"`
+	Questions   []*DnsQuestion `json:"questions"`
+	Answers     []*DnsRr       `json:"answers"`
+	Authorities []*DnsRr       `json:"authorities"`
+	Additionals []*DnsRr       `json:"additionals"`
+	Edns        *DnsEdns       `json:"edns,omitempty"`
+	DsoTlvs     []DsoTlv       `json:"dsoTlvs,omitempty"`
+}
+
+func NewDnsMessage() *DnsMessage {
+	return &DnsMessage{
+		Questions:   make([]*DnsQuestion, 0),
+		Answers:     make([]*DnsRr, 0),
+		Authorities: make([]*DnsRr, 0),
+		Additionals: make([]*DnsRr, 0),
+	}
+}
+
+// NewDnsQuery gets query of one question, class is IN, and recursion desired
+func NewDnsQuery(id uint16, name string, qType uint16) *DnsMessage {
+	m := NewDnsMessage()
+	m.Header = DnsHeader{Id: id, Qr: DNS_QR_REQUEST, OpCode: DNS_OPCODE_QUERY, Rd: true}
+	m.Questions = append(m.Questions, &DnsQuestion{Name: FormatDomainFqdn(name), Type: qType, Class: DNS_CLASS_INT_IN})
+	return m
+}
+
+// NewDnsResponse gets response of request, with same id, opCode, rd, cd and questions
+func NewDnsResponse(request *DnsMessage, rCode uint16) *DnsMessage {
+	m := NewDnsMessage()
+	m.Header = DnsHeader{
+		Id:     request.Header.Id,
+		Qr:     DNS_QR_RESPONSE,
+		OpCode: request.Header.OpCode,
+		Rd:     request.Header.Rd,
+		Cd:     request.Header.Cd,
+	}
+	m.Questions = append(m.Questions, request.Questions...)
+	if request.Edns != nil {
+		m.Edns = &DnsEdns{UdpSize: DNS_EDNS_DEFAULT_UDP_SIZE, Do: request.Edns.Do, Options: make([]DnsEdnsOption, 0)}
+	}
+	m.SetRCode(rCode)
+	return m
+}
+
+// SetEdns adds or replaces OPT
+func (c *DnsMessage) SetEdns(udpSize uint16, do bool) {
+	c.Edns = &DnsEdns{UdpSize: udpSize, Do: do, Options: make([]DnsEdnsOption, 0)}
+}
+
+// SetRCode sets 12 bits rcode, high 8 bits need Edns
+func (c *DnsMessage) SetRCode(rCode uint16) {
+	c.Header.RCode = uint8(rCode & 0x0f)
+	if c.Edns != nil {
+		c.Edns.ExtendedRCode = uint8(rCode >> 4)
+	}
+}
+
+// GetRCode gets 12 bits rcode when there is Edns
+func (c *DnsMessage) GetRCode() uint16 {
+	rCode := uint16(c.Header.RCode)
+	if c.Edns != nil {
+		rCode |= uint16(c.Edns.ExtendedRCode) << 4
+	}
+	return rCode
+}
+
+// Pack gets wire format with name compression
+func (c *DnsMessage) Pack() ([]byte, error) {
+	if c.Header.RCode > 0x0f {
+		return nil, errors.New("rCode in header should be 4 bits, use SetRCode")
+	}
+	if c.Header.OpCode > 0x0f {
+		return nil, errors.New("opCode should be 4 bits")
+	}
+	additionalCount := len(c.Additionals)
+	if c.Edns != nil {
+		additionalCount++
+	}
+	for _, count := range []int{len(c.Questions), len(c.Answers), len(c.Authorities), additionalCount} {
+		if count > 0xffff {
+			return nil, errors.New("too many records in one section")
+		}
+	}
+	if c.Header.OpCode == DNS_OPCODE_DSO {
+		if len(c.Questions)+len(c.Answers)+len(c.Authorities)+additionalCount > 0 {
+			return nil, errors.New("sections of dso message should be empty")
+		}
+	} else if len(c.DsoTlvs) > 0 {
+		return nil, errors.New("tlvs are only in dso message")
+	}
+
+	p := &dnsPacker{buf: make([]byte, DNS_HEADER_LENGTH, DNS_UDP_MAX_LENGTH), compression: make(map[string]int)}
+	binary.BigEndian.PutUint16(p.buf[0:], c.Header.Id)
+	binary.BigEndian.PutUint16(p.buf[2:], c.Header.getFlags())
+	binary.BigEndian.PutUint16(p.buf[4:], uint16(len(c.Questions)))
+	binary.BigEndian.PutUint16(p.buf[6:], uint16(len(c.Answers)))
+	binary.BigEndian.PutUint16(p.buf[8:], uint16(len(c.Authorities)))
+	binary.BigEndian.PutUint16(p.buf[10:], uint16(additionalCount))
+	for _, q := range c.Questions {
+		if err := p.packQuestion(q); err != nil {
+			return nil, err
+		}
+	}
+	for _, rrs := range [][]*DnsRr{c.Answers, c.Authorities, c.Additionals} {
+		for _, rr := range rrs {
+			if err := p.packRr(rr); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if c.Edns != nil {
+		if err := p.packRr(c.Edns.getRr()); err != nil {
+			return nil, err
+		}
+	}
+	for i := range c.DsoTlvs {
+		if err := p.packDsoTlv(&c.DsoTlvs[i]); err != nil {
+			return nil, err
+		}
+	}
+	if len(p.buf) > DNS_MESSAGE_MAX_LENGTH {
+		return nil, errors.New("message is longer than 65535")
+	}
+	return p.buf, nil
+}
+
+// UnpackDnsMessage parses wire format, and all bounds are checked
+func UnpackDnsMessage(msg []byte) (*DnsMessage, error) {
+	if len(msg) < DNS_HEADER_LENGTH {
+		belogs.Debug("UnpackDnsMessage(): message is too short, len(msg):", len(msg))
+		return nil, errors.New("message is shorter than header")
+	}
+	c := NewDnsMessage()
+	c.Header.Id = binary.BigEndian.Uint16(msg[0:])
+	c.Header.setFlags(binary.BigEndian.Uint16(msg[2:]))
+	qdCount := int(binary.BigEndian.Uint16(msg[4:]))
+	anCount := int(binary.BigEndian.Uint16(msg[6:]))
+	nsCount := int(binary.BigEndian.Uint16(msg[8:]))
+	arCount := int(binary.BigEndian.Uint16(msg[10:]))
+	// question is at least 5 bytes, and rr is at least 11 bytes, so counts cannot be larger
+	if qdCount*5+(anCount+nsCount+arCount)*11 > len(msg)-DNS_HEADER_LENGTH {
+		belogs.Debug("UnpackDnsMessage(): counts are too large, len(msg):", len(msg),
+			"  qdCount:", qdCount, "  anCount:", anCount, "  nsCount:", nsCount, "  arCount:", arCount)
+		return nil, errors.New("counts of sections are larger than message")
+	}
+
+	if c.Header.OpCode == DNS_OPCODE_DSO {
+		// rfc8490 5.4: counts are zero, and tlvs are after header
+		if qdCount+anCount+nsCount+arCount > 0 {
+			return nil, errors.New("counts of dso message should be zero")
+		}
+		tlvs, err := unpackDsoTlvs(msg[DNS_HEADER_LENGTH:])
+		if err != nil {
+			belogs.Debug("UnpackDnsMessage(): unpackDsoTlvs fail, len(msg):", len(msg), err)
+			return nil, err
+		}
+		c.DsoTlvs = tlvs
+		return c, nil
+	}
+
+	offset := DNS_HEADER_LENGTH
+	for i := 0; i < qdCount; i++ {
+		q, newOffset, err := unpackQuestion(msg, offset)
+		if err != nil {
+			belogs.Debug("UnpackDnsMessage(): unpackQuestion fail, offset:", offset, err)
+			return nil, errors.New("question " + strconv.Itoa(i) + " is invalid: " + err.Error())
+		}
+		c.Questions = append(c.Questions, q)
+		offset = newOffset
+	}
+	sections := []*[]*DnsRr{&c.Answers, &c.Authorities, &c.Additionals}
+	for s, count := range []int{anCount, nsCount, arCount} {
+		for i := 0; i < count; i++ {
+			rr, newOffset, err := unpackRr(msg, offset)
+			if err != nil {
+				belogs.Debug("UnpackDnsMessage(): unpackRr fail, offset:", offset, err)
+				return nil, errors.New("resource record " + strconv.Itoa(i) + " of section " + strconv.Itoa(s+1) +
+					" is invalid: " + err.Error())
+			}
+			offset = newOffset
+			if rr.Type == DNS_TYPE_INT_OPT {
+				// rfc6891 6.1.1: only one OPT in additional section, its name is root
+				if s != 2 || c.Edns != nil || rr.Name != "." {
+					return nil, errors.New("OPT is invalid or duplicated")
+				}
+				c.Edns = newDnsEdns(rr)
+				continue
+			}
+			*sections[s] = append(*sections[s], rr)
+		}
+	}
+	if offset != len(msg) {
+		belogs.Debug("UnpackDnsMessage(): there are bytes after message, offset:", offset, "  len(msg):", len(msg))
+		return nil, errors.New("there are " + strconv.Itoa(len(msg)-offset) + " bytes after message")
+	}
+	return c, nil
+}
+
+func (c *DnsHeader) getFlags() uint16 {
+	flags := uint16(c.Qr&0x01)<<15 | uint16(c.OpCode&0x0f)<<11 | uint16(c.RCode&0x0f)
+	for i, b := range []bool{c.Aa, c.Tc, c.Rd, c.Ra, c.Z, c.Ad, c.Cd} {
+		if b {
+			flags |= 1 << (10 - i)
+		}
+	}
+	return flags
+}
+
+func (c *DnsHeader) setFlags(flags uint16) {
+	c.Qr = uint8(flags >> 15)
+	c.OpCode = uint8(flags>>11) & 0x0f
+	c.Aa = flags&(1<<10) != 0
+	c.Tc = flags&(1<<9) != 0
+	c.Rd = flags&(1<<8) != 0
+	c.Ra = flags&(1<<7) != 0
+	c.Z = flags&(1<<6) != 0
+	c.Ad = flags&(1<<5) != 0
+	c.Cd = flags&(1<<4) != 0
+	c.RCode = uint8(flags & 0x0f)
+}
+
+// OPT: class is udp size, ttl is extended rcode(8) + version(8) + DO(1) + Z(15)
+func (c *DnsEdns) getRr() *DnsRr {
+	ttl := uint32(c.ExtendedRCode)<<24 | uint32(c.Version)<<16
+	if c.Do {
+		ttl |= 1 << 15
+	}
+	return &DnsRr{Name: ".", Type: DNS_TYPE_INT_OPT, Class: c.UdpSize, Ttl: ttl, Data: &DnsRrDataOpt{Options: c.Options}}
+}
+
+func newDnsEdns(rr *DnsRr) *DnsEdns {
+	edns := &DnsEdns{
+		UdpSize:       rr.Class,
+		ExtendedRCode: uint8(rr.Ttl >> 24),
+		Version:       uint8(rr.Ttl >> 16),
+		Do:            rr.Ttl&(1<<15) != 0,
+		Options:       make([]DnsEdnsOption, 0),
+	}
+	if opt, ok := rr.Data.(*DnsRrDataOpt); ok {
+		edns.Options = opt.Options
+	}
+	return edns
+}
+
+// String is like output of dig
+func (c *DnsMessage) String() string {
+	var b strings.Builder
+	opCode, ok := DnsIntOpCodes[c.Header.OpCode]
+	if !ok {
+		opCode = strconv.Itoa(int(c.Header.OpCode))
+	}
+	rCode, ok := DnsRCodes[uint8(c.GetRCode())]
+	if !ok || c.GetRCode() > 0xff {
+		rCode = strconv.Itoa(int(c.GetRCode()))
+	}
+	b.WriteString(";; opcode: " + opCode + ", status: " + rCode + ", id: " + strconv.Itoa(int(c.Header.Id)) + "\n")
+	b.WriteString(";; flags:")
+	for i, name := range []string{"qr", "aa", "tc", "rd", "ra", "ad", "cd"} {
+		if []bool{c.Header.Qr == DNS_QR_RESPONSE, c.Header.Aa, c.Header.Tc, c.Header.Rd, c.Header.Ra, c.Header.Ad, c.Header.Cd}[i] {
+			b.WriteString(" " + name)
+		}
+	}
+	b.WriteString("\n")
+	if c.Edns != nil {
+		b.WriteString(";; EDNS: version: " + strconv.Itoa(int(c.Edns.Version)) + ", udp: " + strconv.Itoa(int(c.Edns.UdpSize)))
+		if c.Edns.Do {
+			b.WriteString(", flags: do")
+		}
+		b.WriteString("\n")
+	}
+	if len(c.Questions) > 0 {
+		b.WriteString(";; QUESTION SECTION:\n")
+		for _, q := range c.Questions {
+			b.WriteString(";" + q.String() + "\n")
+		}
+	}
+	for i, rrs := range [][]*DnsRr{c.Answers, c.Authorities, c.Additionals} {
+		if len(rrs) == 0 {
+			continue
+		}
+		b.WriteString(";; " + []string{"ANSWER", "AUTHORITY", "ADDITIONAL"}[i] + " SECTION:\n")
+		for _, rr := range rrs {
+			b.WriteString(rr.String() + "\n")
+		}
+	}
+	if len(c.DsoTlvs) > 0 {
+		b.WriteString(";; DSO SECTION:\n")
+		for _, tlv := range c.DsoTlvs {
+			b.WriteString(GetDsoTypeStr(tlv.Type) + "\t" + hex.EncodeToString(tlv.Data) + "\n")
+		}
+	}
+	return b.String()
+}
+
+// GetTcpDnsMessage adds 2 bytes length before msg, rfc1035 4.2.2
+func GetTcpDnsMessage(msg []byte) []byte {
+	tcpMsg := make([]byte, 2, 2+len(msg))
+	binary.BigEndian.PutUint16(tcpMsg, uint16(len(msg)))
+	return append(tcpMsg, msg...)
+}
+
+// SplitTcpDnsMessages splits tcp stream by 2 bytes length, leftData is incomplete message
+func SplitTcpDnsMessages(data []byte) (msgs [][]byte, leftData []byte) {
+	msgs = make([][]byte, 0)
+	for len(data) >= 2 {
+		length := int(binary.BigEndian.Uint16(data))
+		if len(data) < 2+length {
+			break
+		}
+		msgs = append(msgs, data[2:2+length])
+		data = data[2+length:]
+	}
+	leftData = make([]byte, len(data))
+	copy(leftData, data)
+	return msgs, leftData
+}
@@ -0,0 +1,195 @@
+package dnsutil
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"testing"
+
+	"github.com/cpusoft/goutil/jsonutil"
+)
+
+func TestDnsQuery(t *testing.T) {
+	// dig www.example.com A, with id 0x1234 and rd
+	query := NewDnsQuery(0x1234, "www.example.com", DNS_TYPE_INT_A)
+	b, err := query.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(hex.EncodeToString(b))
+	if hex.EncodeToString(b) != "12340100000100000000000003777777076578616d706c6503636f6d0000010001" {
+		t.Fatal("query is wrong:", hex.EncodeToString(b))
+	}
+	m, err := UnpackDnsMessage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Header.Id != 0x1234 || !m.Header.Rd || len(m.Questions) != 1 || m.Questions[0].Name != "www.example.com." {
+		t.Fatal("unpacked query is wrong:", jsonutil.MarshalJson(m))
+	}
+}
+
+func TestDnsMessage(t *testing.T) {
+	query := NewDnsQuery(1, "example.com.", DNS_TYPE_INT_ANY)
+	query.SetEdns(4096, true)
+	m := NewDnsResponse(query, uint16(DNS_RCODE_NOERROR))
+	m.Header.Aa = true
+	m.Answers = append(m.Answers,
+		&DnsRr{Name: "example.com.", Type: DNS_TYPE_INT_SOA, Class: DNS_CLASS_INT_IN, Ttl: 3600,
+			Data: &DnsRrDataSoa{MName: "ns1.example.com.", RName: "hostmaster.example.com.", Serial: 2024010101,
+				Refresh: 3600, Retry: 600, Expire: 604800, Minimum: 86400}},
+		&DnsRr{Name: "example.com.", Type: DNS_TYPE_INT_NS, Class: DNS_CLASS_INT_IN, Ttl: 3600,
+			Data: &DnsRrDataHost{Host: "ns1.example.com."}},
+		&DnsRr{Name: "Example.COM.", Type: DNS_TYPE_INT_MX, Class: DNS_CLASS_INT_IN, Ttl: 3600,
+			Data: &DnsRrDataMx{Preference: 10, Exchange: "mail.example.com."}},
+		&DnsRr{Name: "example.com.", Type: DNS_TYPE_INT_TXT, Class: DNS_CLASS_INT_IN, Ttl: 300,
+			Data: &DnsRrDataTxt{Txts: []string{"v=spf1 -all", ""}}},
+		&DnsRr{Name: "_sip._tcp.example.com.", Type: DNS_TYPE_INT_SRV, Class: DNS_CLASS_INT_IN, Ttl: 300,
+			Data: &DnsRrDataSrv{Priority: 1, Weight: 2, Port: 5060, Target: "sip.example.com."}},
+		&DnsRr{Name: "a\\.b.example.com.", Type: DNS_TYPE_INT_CNAME, Class: DNS_CLASS_INT_IN, Ttl: 300,
+			Data: &DnsRrDataHost{Host: "\\001x.example.com."}},
+		&DnsRr{Name: "4.3.2.1.in-addr.arpa.", Type: DNS_TYPE_INT_PTR, Class: DNS_CLASS_INT_IN, Ttl: 300,
+			Data: &DnsRrDataHost{Host: "example.com."}},
+		&DnsRr{Name: "example.com.", Type: 99, Class: DNS_CLASS_INT_IN, Ttl: 300,
+			Data: &DnsRrDataUnknown{Data: []byte{1, 2, 3}}},
+	)
+	m.Additionals = append(m.Additionals,
+		&DnsRr{Name: "ns1.example.com.", Type: DNS_TYPE_INT_A, Class: DNS_CLASS_INT_IN, Ttl: 3600,
+			Data: &DnsRrDataA{Ip: netip.MustParseAddr("192.0.2.1")}},
+		&DnsRr{Name: "ns1.example.com.", Type: DNS_TYPE_INT_AAAA, Class: DNS_CLASS_INT_IN, Ttl: 3600,
+			Data: &DnsRrDataAaaa{Ip: netip.MustParseAddr("2001:db8::1")}},
+	)
+	m.Edns.Options = append(m.Edns.Options, DnsEdnsOption{Code: 10, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}})
+	m.SetRCode(16)
+
+	b, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unpacked, err := UnpackDnsMessage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(unpacked.String())
+	if jsonutil.MarshalJson(unpacked) != jsonutil.MarshalJson(m) {
+		t.Fatal("unpacked is wrong:\n", jsonutil.MarshalJson(unpacked), "\n", jsonutil.MarshalJson(m))
+	}
+	if unpacked.GetRCode() != 16 || unpacked.Header.RCode != 0 || !unpacked.Edns.Do || unpacked.Edns.UdpSize != DNS_EDNS_DEFAULT_UDP_SIZE {
+		t.Fatal("edns is wrong:", jsonutil.MarshalJson(unpacked.Edns))
+	}
+
+	// "example.com." is compressed, so it is only in question and target of SRV
+	if bytes.Count(b, []byte("\x07example\x03com\x00")) != 2 {
+		t.Fatal("name is not compressed")
+	}
+	// target of SRV is not compressed
+	if !bytes.Contains(b, []byte("\x03sip\x07example\x03com\x00")) {
+		t.Fatal("target of SRV should not be compressed")
+	}
+}
+
+func TestDnsMessageUpdate(t *testing.T) {
+	// delete rrset in UPDATE has no rdata
+	m := NewDnsMessage()
+	m.Header.OpCode = DNS_OPCODE_UPDATE
+	m.Questions = append(m.Questions, &DnsQuestion{Name: "example.com.", Type: DNS_TYPE_INT_SOA, Class: DNS_CLASS_INT_IN})
+	m.Authorities = append(m.Authorities, &DnsRr{Name: "www.example.com.", Type: DNS_TYPE_INT_A, Class: DNS_CLASS_INT_ANY})
+	b, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unpacked, err := UnpackDnsMessage(b)
+	if err != nil || unpacked.Header.OpCode != DNS_OPCODE_UPDATE || unpacked.Authorities[0].Data != nil {
+		t.Fatal("update is wrong:", err, jsonutil.MarshalJson(unpacked))
+	}
+}
+
+func TestDnsMessageInvalid(t *testing.T) {
+	for _, h := range []string{
+		"",
+		"1234010000010000000000",
+		// qdCount is too large
+		"12340100ffff000000000000",
+		// label is truncated
+		"1234010000010000000000000377770000010001",
+		// pointer to itself
+		"123401000001000000000000c00c00010001",
+		// forward pointer
+		"123401000001000000000000c01000010001" + "00",
+		// reserved label type
+		"12340100000100000000000040000100010001",
+		// trailing byte
+		"12340100000100000000000000000100010000",
+		// A with 3 bytes
+		"1234818000000001000000000000010001000000000003010203",
+		// two OPT
+		"123401000000000000000002" + "0000290200000000000000" + "0000290200000000000000",
+		// OPT in answer
+		"123401000000000100000000" + "0000290200000000000000",
+	} {
+		b, _ := hex.DecodeString(h)
+		if _, err := UnpackDnsMessage(b); err == nil {
+			t.Fatal("should fail:", h)
+		} else {
+			fmt.Println(h, err)
+		}
+	}
+
+	for _, name := range []string{"a..b", ".a", "a\\", "\\999.com", string(bytes.Repeat([]byte("a"), 64)) + ".com",
+		string(bytes.Repeat([]byte("abcdefghi."), 26))} {
+		if _, _, err := DomainStrToWire(name); err == nil {
+			t.Fatal("name should fail:", name)
+		}
+	}
+	m := NewDnsQuery(1, "a.com", DNS_TYPE_INT_A)
+	m.Header.RCode = 16
+	if _, err := m.Pack(); err == nil {
+		t.Fatal("rCode should fail")
+	}
+}
+
+func TestDnsDomain(t *testing.T) {
+	if !IsSubDomain("www.Example.com", "example.com.") || !IsSubDomain("example.com", "example.com") ||
+		IsSubDomain("wwwexample.com", "example.com") || !IsSubDomain("a.b", ".") || IsSubDomain("a\\.example.com", "example.com.") {
+		t.Fatal("IsSubDomain is wrong")
+	}
+	if FormatDomainFqdn("WWW.a.com") != "www.a.com." || FormatDomainFqdn("") != "." || FormatDomainFqdn("a\\.") != "a\\.." {
+		t.Fatal("FormatDomainFqdn is wrong")
+	}
+	if !EqualDomain("A.com", "a.COM.") {
+		t.Fatal("EqualDomain is wrong")
+	}
+}
+
+// FuzzUnpackDnsMessage: unpack never panics, and unpack of pack gets same message
+func FuzzUnpackDnsMessage(f *testing.F) {
+	query := NewDnsQuery(1, "www.example.com", DNS_TYPE_INT_A)
+	query.SetEdns(1232, false)
+	b, _ := query.Pack()
+	f.Add(b)
+	f.Add([]byte{0x12, 0x34, 0x81, 0x80, 0, 1, 0, 1, 0, 0, 0, 0,
+		1, 'a', 0, 0, 1, 0, 1,
+		0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 1, 2, 3, 4})
+	f.Add([]byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0,
+		0, 0, 6, 0, 1, 0, 0, 0, 60, 0, 24, 1, 'a', 0, 0xc0, 25, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3, 0, 0, 0, 4, 0, 0, 0, 5})
+	b, _ = NewDsoMessage(1, NewDsoKeepaliveTlv(15000, 15000), NewDsoEncryptionPaddingTlv(4)).Pack()
+	f.Add(b)
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		m, err := UnpackDnsMessage(msg)
+		if err != nil {
+			return
+		}
+		b, err := m.Pack()
+		if err != nil {
+			t.Fatal("unpacked message cannot be packed:", err)
+		}
+		m2, err := UnpackDnsMessage(b)
+		if err != nil {
+			t.Fatal("packed message cannot be unpacked:", err)
+		}
+		if jsonutil.MarshalJson(m) != jsonutil.MarshalJson(m2) {
+			t.Fatal("message is changed:\n", jsonutil.MarshalJson(m), "\n", jsonutil.MarshalJson(m2))
+		}
+	})
+}
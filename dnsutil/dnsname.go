@@ -0,0 +1,233 @@
+package dnsutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const (
+	// rfc1035 2.3.4. Size limits: 255 octets or less, include the end 0x00
+	DNS_DOMAIN_MAXLENGTH = 255
+	// max count of compression pointers in one name
+	DNS_DOMAIN_MAX_POINTERS = 126
+)
+
+// dnsPacker packs message, compression is wire name of suffix --> offset.
+// Suffix is case-sensitive, so case of names is kept (such as 0x20 in question).
+// When canonical, names are lower, rfc4034 6.2
+type dnsPacker struct {
+	buf         []byte
+	compression map[string]int
+	canonical   bool
+}
+
+// packName appends name, such as "www.example.com." or "www.example.com".
+// Pointer is only used when compress, but all suffixes are recorded for later names
+func (p *dnsPacker) packName(name string, compress bool) error {
+	wire, labelStarts, err := DomainStrToWire(name)
+	if err != nil {
+		return err
+	}
+	if p.canonical {
+		// only ascii letters, length octets are less than 'A'
+		for i := range wire {
+			if wire[i] >= 'A' && wire[i] <= 'Z' {
+				wire[i] += 'a' - 'A'
+			}
+		}
+	}
+	for _, start := range labelStarts {
+		key := string(wire[start:])
+		if offset, ok := p.compression[key]; ok && compress {
+			p.buf = binary.BigEndian.AppendUint16(p.buf, DNS_DOMAIN_COMPRESSION_POINTER|uint16(offset))
+			return nil
+		}
+		if p.compression != nil && len(p.buf) < 0x3fff {
+			if _, ok := p.compression[key]; !ok {
+				p.compression[key] = len(p.buf)
+			}
+		}
+		p.buf = append(p.buf, wire[start:start+1+int(wire[start])]...)
+	}
+	p.buf = append(p.buf, 0x00)
+	return nil
+}
+
+// DomainStrToWire converts name to uncompressed wire format, labelStarts are offsets of every label.
+// "\." and "\DDD" are escaped in label as rfc1035 5.1, "" and "." are root
+func DomainStrToWire(name string) (wire []byte, labelStarts []int, err error) {
+	wire = make([]byte, 0, len(name)+2)
+	labelStarts = make([]int, 0, 4)
+	if name == "." {
+		name = ""
+	}
+	label := make([]byte, 0, DNS_DOMAIN_ONE_LABEL_MAXLENGTH)
+	endLabel := func() error {
+		if len(label) == 0 {
+			return errors.New("empty label in domain: " + name)
+		}
+		if len(label) > int(DNS_DOMAIN_ONE_LABEL_MAXLENGTH) {
+			return errors.New("one label in domain should be 63 octets or less: " + name)
+		}
+		labelStarts = append(labelStarts, len(wire))
+		wire = append(wire, byte(len(label)))
+		wire = append(wire, label...)
+		label = label[:0]
+		return nil
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c == '.':
+			if err = endLabel(); err != nil {
+				return nil, nil, err
+			}
+			continue
+		case c == '\\':
+			if i+1 >= len(name) {
+				return nil, nil, errors.New("escape is truncated in domain: " + name)
+			}
+			if i+3 < len(name) && isDigit(name[i+1]) && isDigit(name[i+2]) && isDigit(name[i+3]) {
+				d, _ := strconv.Atoi(name[i+1 : i+4])
+				if d > 255 {
+					return nil, nil, errors.New("escape is invalid in domain: " + name)
+				}
+				c = byte(d)
+				i += 3
+			} else {
+				c = name[i+1]
+				i++
+			}
+		}
+		label = append(label, c)
+	}
+	if len(label) > 0 {
+		if err = endLabel(); err != nil {
+			return nil, nil, err
+		}
+	}
+	wire = append(wire, 0x00)
+	if len(wire) > DNS_DOMAIN_MAXLENGTH {
+		return nil, nil, errors.New("domain should be 255 octets or less: " + name)
+	}
+	return wire, labelStarts, nil
+}
+
+// UnpackDomain gets name at offset of msg, which maybe compressed. Name ends with ".",
+// newOffset is after the name (or after the first pointer)
+func UnpackDomain(msg []byte, offset int) (name string, newOffset int, err error) {
+	var b strings.Builder
+	wireLen := 1
+	pointers := 0
+	newOffset = -1
+	cur := offset
+	for {
+		if cur >= len(msg) {
+			return "", 0, errors.New("domain is truncated")
+		}
+		c := int(msg[cur])
+		switch c & 0xc0 {
+		case 0x00:
+			if c == 0 {
+				if newOffset < 0 {
+					newOffset = cur + 1
+				}
+				if b.Len() == 0 {
+					return ".", newOffset, nil
+				}
+				return b.String(), newOffset, nil
+			}
+			if cur+1+c > len(msg) {
+				return "", 0, errors.New("label of domain is truncated")
+			}
+			if wireLen += 1 + c; wireLen > DNS_DOMAIN_MAXLENGTH {
+				return "", 0, errors.New("domain is longer than 255 octets")
+			}
+			writeEscapedLabel(&b, msg[cur+1:cur+1+c])
+			b.WriteByte('.')
+			cur += 1 + c
+		case 0xc0:
+			if cur+2 > len(msg) {
+				return "", 0, errors.New("compression pointer is truncated")
+			}
+			pointer := int(binary.BigEndian.Uint16(msg[cur:]) &^ DNS_DOMAIN_COMPRESSION_POINTER)
+			// only backward, so there is no loop
+			if pointer >= cur {
+				return "", 0, errors.New("compression pointer is not backward: " + strconv.Itoa(pointer))
+			}
+			if pointers++; pointers > DNS_DOMAIN_MAX_POINTERS {
+				return "", 0, errors.New("too many compression pointers")
+			}
+			if newOffset < 0 {
+				newOffset = cur + 2
+			}
+			cur = pointer
+		default:
+			return "", 0, errors.New("label type is not supported: 0x" + strconv.FormatInt(int64(c&0xc0), 16))
+		}
+	}
+}
+
+func writeEscapedLabel(b *strings.Builder, label []byte) {
+	for _, c := range label {
+		switch {
+		case c == '.' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x21 || c > 0x7e:
+			b.WriteByte('\\')
+			s := strconv.Itoa(int(c))
+			b.WriteString(strings.Repeat("0", 3-len(s)) + s)
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// FormatDomainFqdn: lower and ends with ".", root is "."
+func FormatDomainFqdn(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	// "\." in the end is escaped dot in label, not the root
+	backslashes := 0
+	for i := len(name) - 2; i >= 0 && name[i] == '\\'; i-- {
+		backslashes++
+	}
+	if !strings.HasSuffix(name, ".") || backslashes%2 == 1 {
+		name += "."
+	}
+	return name
+}
+
+// EqualDomain compares two names case-insensitively, "." in the end is ignored
+func EqualDomain(a, b string) bool {
+	return FormatDomainFqdn(a) == FormatDomainFqdn(b)
+}
+
+// IsSubDomain: child is parent or under parent, case-insensitively
+func IsSubDomain(child, parent string) bool {
+	childWire, labelStarts, err := DomainStrToWire(child)
+	if err != nil {
+		return false
+	}
+	parentWire, _, err := DomainStrToWire(parent)
+	if err != nil {
+		return false
+	}
+	if len(parentWire) == 1 {
+		return true
+	}
+	parentWire = bytes.ToLower(parentWire)
+	for _, start := range labelStarts {
+		if bytes.Equal(bytes.ToLower(childWire[start:]), parentWire) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,455 @@
+package dnsutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/cpusoft/goutil/jsonutil"
+)
+
+// DnsQuestion is one question, Name ends with "."
+type DnsQuestion struct {
+	Name  string `json:"name"`
+	Type  uint16 `json:"type"`
+	Class uint16 `json:"class"`
+}
+
+// DnsRr is resource record in message, Name ends with ".".
+// Data is nil when rdlength is 0, such as delete in UPDATE (rfc2136 2.5)
+type DnsRr struct {
+	Name  string    `json:"name"`
+	Type  uint16    `json:"type"`
+	Class uint16    `json:"class"`
+	Ttl   uint32    `json:"ttl"`
+	Data  DnsRrData `json:"data,omitempty"`
+}
+
+// DnsRrData is rdata of one type
+type DnsRrData interface {
+	// pack appends rdata to p
+	pack(p *dnsPacker) error
+	// String is rdata in zone file format
+	String() string
+}
+
+// A
+type DnsRrDataA struct {
+	Ip netip.Addr `json:"ip"`
+}
+
+// AAAA
+type DnsRrDataAaaa struct {
+	Ip netip.Addr `json:"ip"`
+}
+
+// NS, CNAME, PTR, which are just one domain
+type DnsRrDataHost struct {
+	Host string `json:"host"`
+}
+
+// SOA
+type DnsRrDataSoa struct {
+	MName   string `json:"mName"`
+	RName   string `json:"rName"`
+	Serial  uint32 `json:"serial"`
+	Refresh uint32 `json:"refresh"`
+	Retry   uint32 `json:"retry"`
+	Expire  uint32 `json:"expire"`
+	Minimum uint32 `json:"minimum"`
+}
+
+// MX
+type DnsRrDataMx struct {
+	Preference uint16 `json:"preference"`
+	Exchange   string `json:"exchange"`
+}
+
+// TXT, every string is 255 octets or less
+type DnsRrDataTxt struct {
+	Txts []string `json:"txts"`
+}
+
+// SRV, rfc2782
+type DnsRrDataSrv struct {
+	Priority uint16 `json:"priority"`
+	Weight   uint16 `json:"weight"`
+	Port     uint16 `json:"port"`
+	Target   string `json:"target"`
+}
+
+// OPT, rfc6891, it is in DnsEdns of DnsMessage
+type DnsRrDataOpt struct {
+	Options []DnsEdnsOption `json:"options"`
+}
+
+// DnsEdnsOption is one option in OPT
+type DnsEdnsOption struct {
+	Code uint16            `json:"code"`
+	Data jsonutil.HexBytes `json:"data"`
+}
+
+// DnsRrDataUnknown is rdata of other types, rfc3597
+type DnsRrDataUnknown struct {
+	Data jsonutil.HexBytes `json:"data"`
+}
+
+func (c *DnsRrDataA) pack(p *dnsPacker) error {
+	if !c.Ip.Is4() {
+		return errors.New("ip of A is not ipv4: " + c.Ip.String())
+	}
+	a := c.Ip.As4()
+	p.buf = append(p.buf, a[:]...)
+	return nil
+}
+func (c *DnsRrDataA) String() string {
+	return c.Ip.String()
+}
+
+func (c *DnsRrDataAaaa) pack(p *dnsPacker) error {
+	if !c.Ip.Is6() {
+		return errors.New("ip of AAAA is not ipv6: " + c.Ip.String())
+	}
+	a := c.Ip.As16()
+	p.buf = append(p.buf, a[:]...)
+	return nil
+}
+func (c *DnsRrDataAaaa) String() string {
+	return c.Ip.String()
+}
+
+// name in NS, CNAME and PTR can be compressed, rfc3597 4
+func (c *DnsRrDataHost) pack(p *dnsPacker) error {
+	return p.packName(c.Host, true)
+}
+func (c *DnsRrDataHost) String() string {
+	return c.Host
+}
+
+func (c *DnsRrDataSoa) pack(p *dnsPacker) error {
+	if err := p.packName(c.MName, true); err != nil {
+		return err
+	}
+	if err := p.packName(c.RName, true); err != nil {
+		return err
+	}
+	for _, v := range []uint32{c.Serial, c.Refresh, c.Retry, c.Expire, c.Minimum} {
+		p.buf = binary.BigEndian.AppendUint32(p.buf, v)
+	}
+	return nil
+}
+func (c *DnsRrDataSoa) String() string {
+	return c.MName + " " + c.RName + " " + strconv.FormatUint(uint64(c.Serial), 10) + " " +
+		strconv.FormatUint(uint64(c.Refresh), 10) + " " + strconv.FormatUint(uint64(c.Retry), 10) + " " +
+		strconv.FormatUint(uint64(c.Expire), 10) + " " + strconv.FormatUint(uint64(c.Minimum), 10)
+}
+
+func (c *DnsRrDataMx) pack(p *dnsPacker) error {
+	p.buf = binary.BigEndian.AppendUint16(p.buf, c.Preference)
+	return p.packName(c.Exchange, true)
+}
+func (c *DnsRrDataMx) String() string {
+	return strconv.Itoa(int(c.Preference)) + " " + c.Exchange
+}
+
+// empty Txts is packed as one empty string, because TXT has one or more strings
+func (c *DnsRrDataTxt) pack(p *dnsPacker) error {
+	if len(c.Txts) == 0 {
+		p.buf = append(p.buf, 0)
+		return nil
+	}
+	for _, txt := range c.Txts {
+		if len(txt) > 255 {
+			return errors.New("one string of TXT should be 255 octets or less")
+		}
+		p.buf = append(p.buf, byte(len(txt)))
+		p.buf = append(p.buf, txt...)
+	}
+	return nil
+}
+func (c *DnsRrDataTxt) String() string {
+	txts := make([]string, 0, len(c.Txts))
+	for _, txt := range c.Txts {
+		txts = append(txts, strconv.Quote(txt))
+	}
+	return strings.Join(txts, " ")
+}
+
+// target of SRV must not be compressed, rfc2782
+func (c *DnsRrDataSrv) pack(p *dnsPacker) error {
+	p.buf = binary.BigEndian.AppendUint16(p.buf, c.Priority)
+	p.buf = binary.BigEndian.AppendUint16(p.buf, c.Weight)
+	p.buf = binary.BigEndian.AppendUint16(p.buf, c.Port)
+	return p.packName(c.Target, false)
+}
+func (c *DnsRrDataSrv) String() string {
+	return strconv.Itoa(int(c.Priority)) + " " + strconv.Itoa(int(c.Weight)) + " " +
+		strconv.Itoa(int(c.Port)) + " " + c.Target
+}
+
+func (c *DnsRrDataOpt) pack(p *dnsPacker) error {
+	for _, option := range c.Options {
+		if len(option.Data) > 0xffff {
+			return errors.New("data of edns option is too long")
+		}
+		p.buf = binary.BigEndian.AppendUint16(p.buf, option.Code)
+		p.buf = binary.BigEndian.AppendUint16(p.buf, uint16(len(option.Data)))
+		p.buf = append(p.buf, option.Data...)
+	}
+	return nil
+}
+func (c *DnsRrDataOpt) String() string {
+	options := make([]string, 0, len(c.Options))
+	for _, option := range c.Options {
+		options = append(options, strconv.Itoa(int(option.Code))+":"+hex.EncodeToString(option.Data))
+	}
+	return strings.Join(options, " ")
+}
+
+func (c *DnsRrDataUnknown) pack(p *dnsPacker) error {
+	p.buf = append(p.buf, c.Data...)
+	return nil
+}
+
+// String is as rfc3597 5: \# length hex
+func (c *DnsRrDataUnknown) String() string {
+	s := "\\# " + strconv.Itoa(len(c.Data))
+	if len(c.Data) > 0 {
+		s += " " + hex.EncodeToString(c.Data)
+	}
+	return s
+}
+
+// String is in zone file format: name ttl class type rdata
+func (c *DnsRr) String() string {
+	s := c.Name + "\t" + strconv.FormatUint(uint64(c.Ttl), 10) + "\t" + GetDnsClassStr(c.Class) + "\t" + GetDnsTypeStr(c.Type)
+	if c.Data != nil {
+		s += "\t" + c.Data.String()
+	}
+	return s
+}
+
+func (c *DnsQuestion) String() string {
+	return c.Name + "\t" + GetDnsClassStr(c.Class) + "\t" + GetDnsTypeStr(c.Type)
+}
+
+// GetDnsTypeStr gets "A", or "TYPE123" when it is unknown
+func GetDnsTypeStr(t uint16) string {
+	if s, ok := DnsIntTypes[t]; ok {
+		return s
+	}
+	return "TYPE" + strconv.Itoa(int(t))
+}
+
+// GetDnsClassStr gets "IN", or "CLASS123" when it is unknown
+func GetDnsClassStr(class uint16) string {
+	if s, ok := DnsIntClasses[class]; ok {
+		return s
+	}
+	return "CLASS" + strconv.Itoa(int(class))
+}
+
+func (p *dnsPacker) packQuestion(q *DnsQuestion) error {
+	if err := p.packName(q.Name, true); err != nil {
+		return err
+	}
+	p.buf = binary.BigEndian.AppendUint16(p.buf, q.Type)
+	p.buf = binary.BigEndian.AppendUint16(p.buf, q.Class)
+	return nil
+}
+
+func (p *dnsPacker) packRr(rr *DnsRr) error {
+	if err := p.packName(rr.Name, true); err != nil {
+		return err
+	}
+	p.buf = binary.BigEndian.AppendUint16(p.buf, rr.Type)
+	p.buf = binary.BigEndian.AppendUint16(p.buf, rr.Class)
+	p.buf = binary.BigEndian.AppendUint32(p.buf, rr.Ttl)
+	lengthOffset := len(p.buf)
+	p.buf = append(p.buf, 0, 0)
+	if rr.Data != nil {
+		if err := rr.Data.pack(p); err != nil {
+			return errors.New(GetDnsTypeStr(rr.Type) + " of " + rr.Name + " cannot be packed: " + err.Error())
+		}
+	}
+	rdLength := len(p.buf) - lengthOffset - 2
+	if rdLength > 0xffff {
+		return errors.New("rdata of " + rr.Name + " is too long")
+	}
+	binary.BigEndian.PutUint16(p.buf[lengthOffset:], uint16(rdLength))
+	return nil
+}
+
+func unpackQuestion(msg []byte, offset int) (q *DnsQuestion, newOffset int, err error) {
+	name, offset, err := UnpackDomain(msg, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if offset+4 > len(msg) {
+		return nil, 0, errors.New("question is truncated")
+	}
+	q = &DnsQuestion{
+		Name:  name,
+		Type:  binary.BigEndian.Uint16(msg[offset:]),
+		Class: binary.BigEndian.Uint16(msg[offset+2:]),
+	}
+	return q, offset + 4, nil
+}
+
+func unpackRr(msg []byte, offset int) (rr *DnsRr, newOffset int, err error) {
+	name, offset, err := UnpackDomain(msg, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if offset+10 > len(msg) {
+		return nil, 0, errors.New("resource record is truncated")
+	}
+	rr = &DnsRr{
+		Name:  name,
+		Type:  binary.BigEndian.Uint16(msg[offset:]),
+		Class: binary.BigEndian.Uint16(msg[offset+2:]),
+		Ttl:   binary.BigEndian.Uint32(msg[offset+4:]),
+	}
+	rdLength := int(binary.BigEndian.Uint16(msg[offset+8:]))
+	offset += 10
+	end := offset + rdLength
+	if end > len(msg) {
+		return nil, 0, errors.New("rdata of " + name + " is truncated")
+	}
+	if rdLength == 0 {
+		return rr, end, nil
+	}
+	if rr.Data, err = unpackRrData(msg, offset, end, rr.Type); err != nil {
+		return nil, 0, errors.New(GetDnsTypeStr(rr.Type) + " of " + name + " is invalid: " + err.Error())
+	}
+	return rr, end, nil
+}
+
+// unpackRrData gets rdata in msg[offset:end], names in rdata maybe point to anywhere before
+func unpackRrData(msg []byte, offset, end int, rrType uint16) (DnsRrData, error) {
+	rdata := msg[offset:end]
+	// name should be in rdata
+	unpackName := func() (string, error) {
+		name, newOffset, err := UnpackDomain(msg[:end], offset)
+		if err != nil {
+			return "", err
+		}
+		offset = newOffset
+		return name, nil
+	}
+	var data DnsRrData
+	switch rrType {
+	case DNS_TYPE_INT_A:
+		if len(rdata) != 4 {
+			return nil, errors.New("length should be 4")
+		}
+		return &DnsRrDataA{Ip: netip.AddrFrom4([4]byte(rdata))}, nil
+	case DNS_TYPE_INT_AAAA:
+		if len(rdata) != 16 {
+			return nil, errors.New("length should be 16")
+		}
+		return &DnsRrDataAaaa{Ip: netip.AddrFrom16([16]byte(rdata))}, nil
+	case DNS_TYPE_INT_NS, DNS_TYPE_INT_CNAME, DNS_TYPE_INT_PTR:
+		host, err := unpackName()
+		if err != nil {
+			return nil, err
+		}
+		data = &DnsRrDataHost{Host: host}
+	case DNS_TYPE_INT_SOA:
+		soa := &DnsRrDataSoa{}
+		var err error
+		if soa.MName, err = unpackName(); err != nil {
+			return nil, err
+		}
+		if soa.RName, err = unpackName(); err != nil {
+			return nil, err
+		}
+		if end-offset != 20 {
+			return nil, errors.New("length of numbers should be 20")
+		}
+		soa.Serial = binary.BigEndian.Uint32(msg[offset:])
+		soa.Refresh = binary.BigEndian.Uint32(msg[offset+4:])
+		soa.Retry = binary.BigEndian.Uint32(msg[offset+8:])
+		soa.Expire = binary.BigEndian.Uint32(msg[offset+12:])
+		soa.Minimum = binary.BigEndian.Uint32(msg[offset+16:])
+		return soa, nil
+	case DNS_TYPE_INT_MX:
+		if len(rdata) < 3 {
+			return nil, errors.New("length is too short")
+		}
+		mx := &DnsRrDataMx{Preference: binary.BigEndian.Uint16(rdata)}
+		offset += 2
+		exchange, err := unpackName()
+		if err != nil {
+			return nil, err
+		}
+		mx.Exchange = exchange
+		data = mx
+	case DNS_TYPE_INT_TXT:
+		txt := &DnsRrDataTxt{Txts: make([]string, 0)}
+		for i := 0; i < len(rdata); {
+			l := int(rdata[i])
+			if i+1+l > len(rdata) {
+				return nil, errors.New("string is truncated")
+			}
+			txt.Txts = append(txt.Txts, string(rdata[i+1:i+1+l]))
+			i += 1 + l
+		}
+		return txt, nil
+	case DNS_TYPE_INT_SRV:
+		if len(rdata) < 7 {
+			return nil, errors.New("length is too short")
+		}
+		srv := &DnsRrDataSrv{
+			Priority: binary.BigEndian.Uint16(rdata),
+			Weight:   binary.BigEndian.Uint16(rdata[2:]),
+			Port:     binary.BigEndian.Uint16(rdata[4:]),
+		}
+		offset += 6
+		target, err := unpackName()
+		if err != nil {
+			return nil, err
+		}
+		srv.Target = target
+		data = srv
+	case DNS_TYPE_INT_OPT:
+		opt := &DnsRrDataOpt{Options: make([]DnsEdnsOption, 0)}
+		for i := 0; i < len(rdata); {
+			if i+4 > len(rdata) {
+				return nil, errors.New("option is truncated")
+			}
+			code := binary.BigEndian.Uint16(rdata[i:])
+			l := int(binary.BigEndian.Uint16(rdata[i+2:]))
+			if i+4+l > len(rdata) {
+				return nil, errors.New("data of option is truncated")
+			}
+			opt.Options = append(opt.Options, DnsEdnsOption{Code: code, Data: append([]byte{}, rdata[i+4:i+4+l]...)})
+			i += 4 + l
+		}
+		return opt, nil
+	default:
+		return &DnsRrDataUnknown{Data: append([]byte{}, rdata...)}, nil
+	}
+	// after the last name
+	if offset != end {
+		return nil, errors.New("there are " + strconv.Itoa(end-offset) + " bytes after rdata")
+	}
+	return data, nil
+}
+
+// EqualDnsRrData compares rdata in canonical wire format, so names in rdata are case-insensitive, rfc4034 6.2
+func EqualDnsRrData(a, b DnsRrData) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	pa := &dnsPacker{buf: make([]byte, 0, 64), canonical: true}
+	pb := &dnsPacker{buf: make([]byte, 0, 64), canonical: true}
+	if a.pack(pa) != nil || b.pack(pb) != nil {
+		return false
+	}
+	return bytes.Equal(pa.buf, pb.buf)
+}
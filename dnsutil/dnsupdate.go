@@ -0,0 +1,108 @@
+package dnsutil
+
+import (
+	"errors"
+	"strconv"
+)
+
+// DnsUpdate is UPDATE message, rfc2136 2.
+// Zone is the only record in zone section, its type is SOA
+type DnsUpdate struct {
+	Id            uint16      `json:"id"`
+	Zone          DnsQuestion `json:"zone"`
+	Prerequisites []*DnsRr    `json:"prerequisites"`
+	Updates       []*DnsRr    `json:"updates"`
+	Additionals   []*DnsRr    `json:"additionals"`
+}
+
+// NewDnsUpdate gets UPDATE of zone in class IN
+func NewDnsUpdate(id uint16, zone string) *DnsUpdate {
+	return &DnsUpdate{
+		Id:            id,
+		Zone:          DnsQuestion{Name: FormatDomainFqdn(zone), Type: DNS_TYPE_INT_SOA, Class: DNS_CLASS_INT_IN},
+		Prerequisites: make([]*DnsRr, 0),
+		Updates:       make([]*DnsRr, 0),
+		Additionals:   make([]*DnsRr, 0),
+	}
+}
+
+// AddPrereqRrsetExists: rrset exists (value independent), rfc2136 2.4.1
+func (c *DnsUpdate) AddPrereqRrsetExists(name string, rrType uint16) {
+	c.Prerequisites = append(c.Prerequisites, &DnsRr{Name: FormatDomainFqdn(name), Type: rrType, Class: DNS_CLASS_INT_ANY})
+}
+
+// AddPrereqRrsetExistsValue: rrset exists (value dependent), all rrs of the rrset should be added, rfc2136 2.4.2
+func (c *DnsUpdate) AddPrereqRrsetExistsValue(rr *DnsRr) {
+	c.Prerequisites = append(c.Prerequisites, &DnsRr{Name: FormatDomainFqdn(rr.Name), Type: rr.Type,
+		Class: c.Zone.Class, Data: rr.Data})
+}
+
+// AddPrereqRrsetNotExists: rrset does not exist, rfc2136 2.4.3
+func (c *DnsUpdate) AddPrereqRrsetNotExists(name string, rrType uint16) {
+	c.Prerequisites = append(c.Prerequisites, &DnsRr{Name: FormatDomainFqdn(name), Type: rrType, Class: DNS_CLASS_INT_NONE})
+}
+
+// AddPrereqNameInUse: name is in use, rfc2136 2.4.4
+func (c *DnsUpdate) AddPrereqNameInUse(name string) {
+	c.Prerequisites = append(c.Prerequisites, &DnsRr{Name: FormatDomainFqdn(name), Type: DNS_TYPE_INT_ANY, Class: DNS_CLASS_INT_ANY})
+}
+
+// AddPrereqNameNotInUse: name is not in use, rfc2136 2.4.5
+func (c *DnsUpdate) AddPrereqNameNotInUse(name string) {
+	c.Prerequisites = append(c.Prerequisites, &DnsRr{Name: FormatDomainFqdn(name), Type: DNS_TYPE_INT_ANY, Class: DNS_CLASS_INT_NONE})
+}
+
+// AddUpdateAddRr: add rr to an rrset, rfc2136 2.5.1
+func (c *DnsUpdate) AddUpdateAddRr(rr *DnsRr) {
+	c.Updates = append(c.Updates, &DnsRr{Name: FormatDomainFqdn(rr.Name), Type: rr.Type,
+		Class: c.Zone.Class, Ttl: rr.Ttl, Data: rr.Data})
+}
+
+// AddUpdateDelRrset: delete an rrset, rfc2136 2.5.2
+func (c *DnsUpdate) AddUpdateDelRrset(name string, rrType uint16) {
+	c.Updates = append(c.Updates, &DnsRr{Name: FormatDomainFqdn(name), Type: rrType, Class: DNS_CLASS_INT_ANY})
+}
+
+// AddUpdateDelName: delete all rrsets from a name, rfc2136 2.5.3
+func (c *DnsUpdate) AddUpdateDelName(name string) {
+	c.Updates = append(c.Updates, &DnsRr{Name: FormatDomainFqdn(name), Type: DNS_TYPE_INT_ANY, Class: DNS_CLASS_INT_ANY})
+}
+
+// AddUpdateDelRr: delete an rr from an rrset, rfc2136 2.5.4
+func (c *DnsUpdate) AddUpdateDelRr(rr *DnsRr) {
+	c.Updates = append(c.Updates, &DnsRr{Name: FormatDomainFqdn(rr.Name), Type: rr.Type,
+		Class: DNS_CLASS_INT_NONE, Data: rr.Data})
+}
+
+// GetDnsMessage: Zone/Prerequisites/Updates are in Questions/Answers/Authorities
+func (c *DnsUpdate) GetDnsMessage() *DnsMessage {
+	m := NewDnsMessage()
+	m.Header = DnsHeader{Id: c.Id, Qr: DNS_QR_REQUEST, OpCode: DNS_OPCODE_UPDATE}
+	zone := c.Zone
+	m.Questions = append(m.Questions, &zone)
+	m.Answers = append(m.Answers, c.Prerequisites...)
+	m.Authorities = append(m.Authorities, c.Updates...)
+	m.Additionals = append(m.Additionals, c.Additionals...)
+	return m
+}
+
+// ParseDnsUpdate gets UPDATE from request, ZOCOUNT must be 1 and type must be SOA, rfc2136 3.1.1.
+// Error means FORMERR
+func ParseDnsUpdate(m *DnsMessage) (*DnsUpdate, error) {
+	if m == nil || m.Header.OpCode != DNS_OPCODE_UPDATE || m.Header.Qr != DNS_QR_REQUEST {
+		return nil, errors.New("message is not update request")
+	}
+	if len(m.Questions) != 1 {
+		return nil, errors.New("count of zone section should be 1, but it is " + strconv.Itoa(len(m.Questions)))
+	}
+	if m.Questions[0].Type != DNS_TYPE_INT_SOA {
+		return nil, errors.New("type of zone should be SOA, but it is " + GetDnsTypeStr(m.Questions[0].Type))
+	}
+	return &DnsUpdate{
+		Id:            m.Header.Id,
+		Zone:          *m.Questions[0],
+		Prerequisites: append(make([]*DnsRr, 0, len(m.Answers)), m.Answers...),
+		Updates:       append(make([]*DnsRr, 0, len(m.Authorities)), m.Authorities...),
+		Additionals:   append(make([]*DnsRr, 0, len(m.Additionals)), m.Additionals...),
+	}, nil
+}
@@ -0,0 +1,79 @@
+package dnsutil
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+
+	"github.com/cpusoft/goutil/jsonutil"
+)
+
+func TestDnsUpdate(t *testing.T) {
+	a := &DnsRr{Name: "www.example.com.", Type: DNS_TYPE_INT_A, Ttl: 300,
+		Data: &DnsRrDataA{Ip: netip.MustParseAddr("192.0.2.1")}}
+	u := NewDnsUpdate(0x1234, "Example.COM")
+	u.AddPrereqNameInUse("example.com.")
+	u.AddPrereqRrsetExists("example.com.", DNS_TYPE_INT_NS)
+	u.AddPrereqRrsetNotExists("www.example.com.", DNS_TYPE_INT_CNAME)
+	u.AddPrereqNameNotInUse("new.example.com.")
+	u.AddPrereqRrsetExistsValue(&DnsRr{Name: "example.com.", Type: DNS_TYPE_INT_MX,
+		Data: &DnsRrDataMx{Preference: 10, Exchange: "mail.example.com."}})
+	u.AddUpdateDelRrset("www.example.com.", DNS_TYPE_INT_A)
+	u.AddUpdateAddRr(a)
+	u.AddUpdateDelRr(&DnsRr{Name: "old.example.com.", Type: DNS_TYPE_INT_A, Data: a.Data})
+	u.AddUpdateDelName("gone.example.com.")
+
+	b, err := u.GetDnsMessage().Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := UnpackDnsMessage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(m.String())
+	parsed, err := ParseDnsUpdate(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jsonutil.MarshalJson(parsed) != jsonutil.MarshalJson(u) {
+		t.Fatal("update is wrong:", jsonutil.MarshalJson(parsed))
+	}
+	if parsed.Zone.Name != "example.com." || len(parsed.Prerequisites) != 5 || len(parsed.Updates) != 4 {
+		t.Fatal("sections are wrong:", jsonutil.MarshalJson(parsed))
+	}
+	// delete has no rdata and ttl 0, rfc2136 2.5.2
+	if del := parsed.Updates[0]; del.Class != DNS_CLASS_INT_ANY || del.Ttl != 0 || del.Data != nil {
+		t.Fatal("delete rrset is wrong:", jsonutil.MarshalJson(del))
+	}
+	if del := parsed.Updates[2]; del.Class != DNS_CLASS_INT_NONE || del.Ttl != 0 || del.Data == nil {
+		t.Fatal("delete rr is wrong:", jsonutil.MarshalJson(del))
+	}
+
+	query := NewDnsQuery(1, "example.com.", DNS_TYPE_INT_SOA)
+	if _, err = ParseDnsUpdate(query); err == nil {
+		t.Fatal("query should fail")
+	}
+	m = u.GetDnsMessage()
+	m.Questions[0].Type = DNS_TYPE_INT_A
+	if _, err = ParseDnsUpdate(m); err == nil {
+		t.Fatal("zone type A should fail")
+	}
+	m.Questions = append(m.Questions, m.Questions[0])
+	if _, err = ParseDnsUpdate(m); err == nil {
+		t.Fatal("two zones should fail")
+	}
+}
+
+func TestEqualDnsRrData(t *testing.T) {
+	if !EqualDnsRrData(&DnsRrDataMx{Preference: 10, Exchange: "Mail.Example.com."},
+		&DnsRrDataMx{Preference: 10, Exchange: "mail.example.COM"}) {
+		t.Fatal("names in rdata should be case-insensitive")
+	}
+	if EqualDnsRrData(&DnsRrDataTxt{Txts: []string{"A"}}, &DnsRrDataTxt{Txts: []string{"a"}}) {
+		t.Fatal("txt should be case-sensitive")
+	}
+	if EqualDnsRrData(&DnsRrDataA{Ip: netip.MustParseAddr("192.0.2.1")}, nil) || !EqualDnsRrData(nil, nil) {
+		t.Fatal("nil is wrong")
+	}
+}
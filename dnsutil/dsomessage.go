@@ -0,0 +1,222 @@
+package dnsutil
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+
+	"github.com/cpusoft/goutil/jsonutil"
+)
+
+// DsoTlv is one TLV in dso message, rfc8490 5.4.4
+type DsoTlv struct {
+	Type uint16            `json:"type"`
+	Data jsonutil.HexBytes `json:"data"`
+}
+
+// NewDsoMessage gets dso request when id is not 0, or unidirectional message when id is 0.
+// The first tlv is primary TLV
+func NewDsoMessage(id uint16, tlvs ...DsoTlv) *DnsMessage {
+	m := NewDnsMessage()
+	m.Header = DnsHeader{Id: id, Qr: DNS_QR_REQUEST, OpCode: DNS_OPCODE_DSO}
+	m.DsoTlvs = append(make([]DsoTlv, 0, len(tlvs)), tlvs...)
+	return m
+}
+
+// GetDsoPrimaryTlv gets the first tlv, response maybe has no primary TLV
+func (c *DnsMessage) GetDsoPrimaryTlv() *DsoTlv {
+	if c.Header.OpCode != DNS_OPCODE_DSO || len(c.DsoTlvs) == 0 {
+		return nil
+	}
+	return &c.DsoTlvs[0]
+}
+
+// GetDsoTypeStr gets "keepalive", or "TYPE123" when it is unknown
+func GetDsoTypeStr(t uint16) string {
+	if s, ok := DsoIntTypes[uint8(t)]; ok && t <= 0xff {
+		return s
+	}
+	return "TYPE" + strconv.Itoa(int(t))
+}
+
+func (p *dnsPacker) packDsoTlv(tlv *DsoTlv) error {
+	if len(tlv.Data) > 0xffff {
+		return errors.New("data of dso tlv " + GetDsoTypeStr(tlv.Type) + " is too long")
+	}
+	p.buf = binary.BigEndian.AppendUint16(p.buf, tlv.Type)
+	p.buf = binary.BigEndian.AppendUint16(p.buf, uint16(len(tlv.Data)))
+	p.buf = append(p.buf, tlv.Data...)
+	return nil
+}
+
+func unpackDsoTlvs(b []byte) ([]DsoTlv, error) {
+	tlvs := make([]DsoTlv, 0)
+	for offset := 0; offset < len(b); {
+		if offset+DSO_LENGTH_MIN > len(b) {
+			return nil, errors.New("dso tlv is truncated")
+		}
+		t := binary.BigEndian.Uint16(b[offset:])
+		length := int(binary.BigEndian.Uint16(b[offset+2:]))
+		offset += DSO_LENGTH_MIN
+		if offset+length > len(b) {
+			return nil, errors.New("data of dso tlv " + GetDsoTypeStr(t) + " is truncated")
+		}
+		data := make([]byte, length)
+		copy(data, b[offset:offset+length])
+		tlvs = append(tlvs, DsoTlv{Type: t, Data: data})
+		offset += length
+	}
+	return tlvs, nil
+}
+
+// NewDsoKeepaliveTlv: timeouts are in milliseconds, rfc8490 7.1
+func NewDsoKeepaliveTlv(inactivityTimeout, keepaliveInterval uint32) DsoTlv {
+	data := make([]byte, DSO_TYPE_KEEPALIVE_LENGTH)
+	binary.BigEndian.PutUint32(data, inactivityTimeout)
+	binary.BigEndian.PutUint32(data[4:], keepaliveInterval)
+	return DsoTlv{Type: DSO_TYPE_KEEPALIVE, Data: data}
+}
+
+func (c *DsoTlv) GetKeepalive() (inactivityTimeout, keepaliveInterval uint32, err error) {
+	if c.Type != DSO_TYPE_KEEPALIVE || len(c.Data) != DSO_TYPE_KEEPALIVE_LENGTH {
+		return 0, 0, errors.New("keepalive tlv is invalid")
+	}
+	return binary.BigEndian.Uint32(c.Data), binary.BigEndian.Uint32(c.Data[4:]), nil
+}
+
+// NewDsoRetryDelayTlv: retryDelay is in milliseconds, rfc8490 7.2
+func NewDsoRetryDelayTlv(retryDelay uint32) DsoTlv {
+	return DsoTlv{Type: DSO_TYPE_RETRY_DELAY, Data: binary.BigEndian.AppendUint32(nil, retryDelay)}
+}
+
+func (c *DsoTlv) GetRetryDelay() (retryDelay uint32, err error) {
+	if c.Type != DSO_TYPE_RETRY_DELAY || len(c.Data) != DSO_TYPE_RETRY_DELAY_LENGTH {
+		return 0, errors.New("retry delay tlv is invalid")
+	}
+	return binary.BigEndian.Uint32(c.Data), nil
+}
+
+// NewDsoEncryptionPaddingTlv gets padding of zeros, rfc8490 7.3
+func NewDsoEncryptionPaddingTlv(length int) DsoTlv {
+	return DsoTlv{Type: DSO_TYPE_ENCRYPTION_PADDING, Data: make([]byte, length)}
+}
+
+// NewDsoSubscribeTlv: name(uncompressed)+type+class, rfc8765 6.2
+func NewDsoSubscribeTlv(question *DnsQuestion) (DsoTlv, error) {
+	p := &dnsPacker{buf: make([]byte, 0, len(question.Name)+6)}
+	if err := p.packQuestion(question); err != nil {
+		return DsoTlv{}, err
+	}
+	return DsoTlv{Type: DSO_TYPE_SUBSCRIBE, Data: p.buf}, nil
+}
+
+func (c *DsoTlv) GetSubscribe() (*DnsQuestion, error) {
+	if c.Type != DSO_TYPE_SUBSCRIBE {
+		return nil, errors.New("subscribe tlv is invalid")
+	}
+	if err := checkDsoName(c.Data, 0); err != nil {
+		return nil, err
+	}
+	question, offset, err := unpackQuestion(c.Data, 0)
+	if err != nil {
+		return nil, errors.New("subscribe tlv is invalid: " + err.Error())
+	}
+	if offset != len(c.Data) {
+		return nil, errors.New("there are bytes after question in subscribe tlv")
+	}
+	return question, nil
+}
+
+// NewDsoPushTlv: one or more rrs without compression, rfc8765 6.3.1.
+// Ttl is DSO_DEL_SPECIFIED_RESOURCE_RECORD_TTL or DSO_DEL_COLLECTIVE_RESOURCE_RECORD_TTL when delete
+func NewDsoPushTlv(rrs []*DnsRr) (DsoTlv, error) {
+	if len(rrs) == 0 {
+		return DsoTlv{}, errors.New("push tlv should have resource records")
+	}
+	p := &dnsPacker{buf: make([]byte, 0, 64*len(rrs))}
+	for _, rr := range rrs {
+		if err := p.packRr(rr); err != nil {
+			return DsoTlv{}, err
+		}
+	}
+	return DsoTlv{Type: DSO_TYPE_PUSH, Data: p.buf}, nil
+}
+
+func (c *DsoTlv) GetPush() ([]*DnsRr, error) {
+	// at least root name and fixed fields
+	if c.Type != DSO_TYPE_PUSH || len(c.Data) < 1+DSO_TYPE_PUSH_MIN_LENGTH {
+		return nil, errors.New("push tlv is invalid")
+	}
+	rrs := make([]*DnsRr, 0)
+	for offset := 0; offset < len(c.Data); {
+		if err := checkDsoName(c.Data, offset); err != nil {
+			return nil, err
+		}
+		rr, newOffset, err := unpackRr(c.Data, offset)
+		if err != nil {
+			return nil, errors.New("push tlv is invalid: " + err.Error())
+		}
+		rrs = append(rrs, rr)
+		offset = newOffset
+	}
+	return rrs, nil
+}
+
+// NewDsoUnsubscribeTlv: subscribeId is message id of SUBSCRIBE, rfc8765 6.4
+func NewDsoUnsubscribeTlv(subscribeId uint16) DsoTlv {
+	return DsoTlv{Type: DSO_TYPE_UNSUBSCRIBE, Data: binary.BigEndian.AppendUint16(nil, subscribeId)}
+}
+
+func (c *DsoTlv) GetUnsubscribe() (subscribeId uint16, err error) {
+	if c.Type != DSO_TYPE_UNSUBSCRIBE || len(c.Data) != DSO_TYPE_UNSUBSCRIBE_LENGTH {
+		return 0, errors.New("unsubscribe tlv is invalid")
+	}
+	return binary.BigEndian.Uint16(c.Data), nil
+}
+
+// NewDsoReconfirmTlv: name(uncompressed)+type+class+rdata, no ttl, rfc8765 6.5
+func NewDsoReconfirmTlv(rr *DnsRr) (DsoTlv, error) {
+	p := &dnsPacker{buf: make([]byte, 0, len(rr.Name)+32)}
+	if err := p.packQuestion(&DnsQuestion{Name: rr.Name, Type: rr.Type, Class: rr.Class}); err != nil {
+		return DsoTlv{}, err
+	}
+	if rr.Data == nil {
+		return DsoTlv{}, errors.New("reconfirm tlv should have rdata")
+	}
+	if err := rr.Data.pack(p); err != nil {
+		return DsoTlv{}, err
+	}
+	return DsoTlv{Type: DSO_TYPE_RECONFIRM, Data: p.buf}, nil
+}
+
+func (c *DsoTlv) GetReconfirm() (*DnsRr, error) {
+	if c.Type != DSO_TYPE_RECONFIRM {
+		return nil, errors.New("reconfirm tlv is invalid")
+	}
+	if err := checkDsoName(c.Data, 0); err != nil {
+		return nil, err
+	}
+	question, offset, err := unpackQuestion(c.Data, 0)
+	if err != nil {
+		return nil, errors.New("reconfirm tlv is invalid: " + err.Error())
+	}
+	if offset == len(c.Data) {
+		return nil, errors.New("reconfirm tlv should have rdata")
+	}
+	rr := &DnsRr{Name: question.Name, Type: question.Type, Class: question.Class}
+	if rr.Data, err = unpackRrData(c.Data, offset, len(c.Data), rr.Type); err != nil {
+		return nil, errors.New("reconfirm tlv is invalid: " + err.Error())
+	}
+	return rr, nil
+}
+
+// checkDsoName: names in dso tlvs are not compressed
+func checkDsoName(data []byte, offset int) error {
+	for offset < len(data) && data[offset] != 0 {
+		if data[offset]&0xc0 != 0 {
+			return errors.New("name in dso tlv should not be compressed")
+		}
+		offset += 1 + int(data[offset])
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package dnsutil
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"testing"
+
+	"github.com/cpusoft/goutil/jsonutil"
+)
+
+func TestDsoMessage(t *testing.T) {
+	// keepalive request with padding, rfc8490 7.1
+	m := NewDsoMessage(0x1234, NewDsoKeepaliveTlv(15000, 10000), NewDsoEncryptionPaddingTlv(2))
+	b, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(hex.EncodeToString(b))
+	if hex.EncodeToString(b) != "123430000000000000000000"+"0001000800003a9800002710"+"000300020000" {
+		t.Fatal("dso message is wrong:", hex.EncodeToString(b))
+	}
+	unpacked, err := UnpackDnsMessage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(unpacked.String())
+	inactivityTimeout, keepaliveInterval, err := unpacked.GetDsoPrimaryTlv().GetKeepalive()
+	if err != nil || inactivityTimeout != 15000 || keepaliveInterval != 10000 || len(unpacked.DsoTlvs) != 2 {
+		t.Fatal("keepalive is wrong:", inactivityTimeout, keepaliveInterval, err)
+	}
+
+	// response without primary tlv
+	response := NewDnsResponse(unpacked, uint16(DNS_RCODE_DSOTYPENI))
+	if b, err = response.Pack(); err != nil {
+		t.Fatal(err)
+	}
+	if unpacked, err = UnpackDnsMessage(b); err != nil || unpacked.GetDsoPrimaryTlv() != nil ||
+		unpacked.Header.RCode != DNS_RCODE_DSOTYPENI {
+		t.Fatal("response is wrong:", err)
+	}
+
+	// sections should be empty
+	m.Questions = append(m.Questions, &DnsQuestion{Name: ".", Type: DNS_TYPE_INT_A, Class: DNS_CLASS_INT_IN})
+	if _, err = m.Pack(); err == nil {
+		t.Fatal("dso message with question should fail")
+	}
+	for _, h := range []string{
+		// qdCount is not 0
+		"123430000001000000000000" + "0000010001",
+		// tlv is truncated
+		"123430000000000000000000" + "000100080000",
+		"123430000000000000000000" + "0001",
+	} {
+		b, _ := hex.DecodeString(h)
+		if _, err := UnpackDnsMessage(b); err == nil {
+			t.Fatal("should fail:", h)
+		}
+	}
+}
+
+func TestDsoPushTlvs(t *testing.T) {
+	subscribe, err := NewDsoSubscribeTlv(&DnsQuestion{Name: "_ipp._tcp.example.com.", Type: DNS_TYPE_INT_PTR, Class: DNS_CLASS_INT_IN})
+	if err != nil {
+		t.Fatal(err)
+	}
+	question, err := subscribe.GetSubscribe()
+	if err != nil || question.Name != "_ipp._tcp.example.com." || question.Type != DNS_TYPE_INT_PTR {
+		t.Fatal("subscribe is wrong:", question, err)
+	}
+
+	rrs := []*DnsRr{
+		{Name: "_ipp._tcp.example.com.", Type: DNS_TYPE_INT_PTR, Class: DNS_CLASS_INT_IN, Ttl: 3600,
+			Data: &DnsRrDataHost{Host: "printer._ipp._tcp.example.com."}},
+		{Name: "_ipp._tcp.example.com.", Type: DNS_TYPE_INT_PTR, Class: DNS_CLASS_INT_IN, Ttl: DSO_DEL_SPECIFIED_RESOURCE_RECORD_TTL,
+			Data: &DnsRrDataHost{Host: "old._ipp._tcp.example.com."}},
+		{Name: "_ipp._tcp.example.com.", Type: DNS_TYPE_INT_PTR, Class: DNS_CLASS_INT_ANY, Ttl: DSO_DEL_COLLECTIVE_RESOURCE_RECORD_TTL},
+	}
+	push, err := NewDsoPushTlv(rrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushRrs, err := push.GetPush()
+	if err != nil || jsonutil.MarshalJson(pushRrs) != jsonutil.MarshalJson(rrs) {
+		t.Fatal("push is wrong:", jsonutil.MarshalJson(pushRrs), err)
+	}
+	// names are not compressed in push
+	wire, _, _ := DomainStrToWire("_ipp._tcp.example.com.")
+	if len(push.Data) < 3*len(wire) {
+		t.Fatal("names in push should not be compressed")
+	}
+	compressed := DsoTlv{Type: DSO_TYPE_PUSH, Data: append([]byte{0xc0, 0x00}, make([]byte, 10)...)}
+	if _, err = compressed.GetPush(); err == nil {
+		t.Fatal("compressed push should fail")
+	}
+
+	unsubscribe := NewDsoUnsubscribeTlv(7)
+	if id, err := unsubscribe.GetUnsubscribe(); err != nil || id != 7 {
+		t.Fatal("unsubscribe is wrong:", id, err)
+	}
+
+	rr := &DnsRr{Name: "host.example.com.", Type: DNS_TYPE_INT_A, Class: DNS_CLASS_INT_IN,
+		Data: &DnsRrDataA{Ip: netip.MustParseAddr("192.0.2.1")}}
+	reconfirm, err := NewDsoReconfirmTlv(rr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reconfirmRr, err := reconfirm.GetReconfirm()
+	if err != nil || jsonutil.MarshalJson(reconfirmRr) != jsonutil.MarshalJson(rr) {
+		t.Fatal("reconfirm is wrong:", jsonutil.MarshalJson(reconfirmRr), err)
+	}
+
+	retryDelay := NewDsoRetryDelayTlv(60000)
+	if delay, err := retryDelay.GetRetryDelay(); err != nil || delay != 60000 {
+		t.Fatal("retry delay is wrong:", delay, err)
+	}
+	if _, err = retryDelay.GetSubscribe(); err == nil {
+		t.Fatal("type should be checked")
+	}
+}
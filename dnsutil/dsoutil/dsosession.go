@@ -0,0 +1,745 @@
+package dsoutil
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cpusoft/goutil/belogs"
+	"github.com/cpusoft/goutil/dnsutil"
+	"github.com/cpusoft/goutil/transportutil"
+)
+
+const (
+	// client waits for response of dso request
+	DSO_RESPONSE_TIMEOUT_SECONDS = 5
+	// server waits for client to close after retry delay
+	DSO_CLOSE_WAIT_SECONDS = 5
+	// rfc8490 6.4.1 and 6.5.2: server is patient at least 5 seconds
+	DSO_DELINQUENT_MIN_SECONDS = 5
+	// timeout or interval is infinite
+	DSO_INFINITE_MILLISECONDS = 0xffffffff
+
+	// rfc8467 4.1: recommended block size of padding
+	DSO_PADDING_BLOCK_SIZE_CLIENT = 128
+	DSO_PADDING_BLOCK_SIZE_SERVER = 468
+)
+
+// DsoSessionConfig: timeouts are in milliseconds. Client sends them in keepalive request,
+// and server sends them in keepalive response which client must use
+type DsoSessionConfig struct {
+	InactivityTimeout uint32 `json:"inactivityTimeout"`
+	KeepaliveInterval uint32 `json:"keepaliveInterval"`
+	// encryption padding is only for tls, 0 means no padding
+	PaddingBlockSize int `json:"paddingBlockSize"`
+}
+
+func NewDefaultDsoSessionConfig() *DsoSessionConfig {
+	return &DsoSessionConfig{
+		InactivityTimeout: dnsutil.DSO_DEFAULT_INACTIVITY_TIMEOUT_SECONDS * 1000,
+		KeepaliveInterval: dnsutil.DSO_DEFAULT_KEEPALIVE_INTERVAL_SECONDS * 1000,
+	}
+}
+
+// DsoPushHandler is hook of dns push notifications (rfc8765).
+// Server gets OnSubscribe/OnUnsubscribe/OnReconfirm, and client gets OnPush
+type DsoPushHandler interface {
+	// OnSubscribe returns rcode of response, subscription is kept when rcode is DNS_RCODE_NOERROR
+	OnSubscribe(session *DsoSession, subscribeId uint16, question *dnsutil.DnsQuestion) (rCode uint8)
+	OnUnsubscribe(session *DsoSession, subscribeId uint16, question *dnsutil.DnsQuestion)
+	OnReconfirm(session *DsoSession, rr *dnsutil.DnsRr)
+	OnPush(session *DsoSession, rrs []*dnsutil.DnsRr)
+}
+
+type dsoPendingRequest struct {
+	primaryType uint16
+	question    *dnsutil.DnsQuestion
+	responseCh  chan *dnsutil.DnsMessage
+}
+
+// DsoSession is one dso session on one connection, rfc8490 5
+type DsoSession struct {
+	mutex    sync.Mutex
+	isServer bool
+	state    string
+	config   DsoSessionConfig
+	// negotiated, in milliseconds
+	inactivityTimeout uint32
+	keepaliveInterval uint32
+
+	pushHandler DsoPushHandler
+	writeMutex  sync.Mutex
+	write       func(msg []byte) error
+	close       func()
+
+	nextId        uint16
+	pendings      map[uint16]*dsoPendingRequest
+	subscriptions map[uint16]*dnsutil.DnsQuestion
+
+	lastSendTime    time.Time
+	lastReceiveTime time.Time
+	// since when there is no subscription or request except keepalive
+	inactiveTime time.Time
+	// client: should not reconnect before retryTime
+	retryTime time.Time
+	// server: has sent retry delay, and waits for client to close
+	closingTime time.Time
+
+	closeCh chan struct{}
+}
+
+// NewDsoSession: write sends one dns message (without tcp length), close closes connection.
+// They maybe set later by setConn
+func NewDsoSession(isServer bool, config *DsoSessionConfig, pushHandler DsoPushHandler,
+	write func(msg []byte) error, close func()) *DsoSession {
+	if config == nil {
+		config = NewDefaultDsoSessionConfig()
+	}
+	now := time.Now()
+	c := &DsoSession{
+		isServer:          isServer,
+		state:             dnsutil.DSO_SESSION_STATE_CONNECTED_SESSIONLESS,
+		config:            *config,
+		inactivityTimeout: config.InactivityTimeout,
+		keepaliveInterval: config.KeepaliveInterval,
+		pushHandler:       pushHandler,
+		write:             write,
+		close:             close,
+		pendings:          make(map[uint16]*dsoPendingRequest),
+		subscriptions:     make(map[uint16]*dnsutil.DnsQuestion),
+		lastSendTime:      now,
+		lastReceiveTime:   now,
+		inactiveTime:      now,
+		closeCh:           make(chan struct{}),
+	}
+	if write == nil {
+		c.state = dnsutil.DSO_SESSION_STATE_DISCONNECTED
+	}
+	return c
+}
+
+func (c *DsoSession) setConn(write func(msg []byte) error, close func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.write = write
+	c.close = close
+	c.state = dnsutil.DSO_SESSION_STATE_CONNECTED_SESSIONLESS
+	c.lastSendTime = time.Now()
+	c.lastReceiveTime = c.lastSendTime
+	c.inactiveTime = c.lastSendTime
+}
+
+func (c *DsoSession) setState(state string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.state = state
+}
+
+func (c *DsoSession) GetState() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.state
+}
+
+// GetTimeouts gets negotiated timeouts in milliseconds
+func (c *DsoSession) GetTimeouts() (inactivityTimeout, keepaliveInterval uint32) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.inactivityTimeout, c.keepaliveInterval
+}
+
+// GetRetryTime: client should not reconnect before it, rfc8490 6.6.1
+func (c *DsoSession) GetRetryTime() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.retryTime
+}
+
+// GetSubscriptions gets copy of subscribeId --> question
+func (c *DsoSession) GetSubscriptions() map[uint16]*dnsutil.DnsQuestion {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	subscriptions := make(map[uint16]*dnsutil.DnsQuestion, len(c.subscriptions))
+	for id, question := range c.subscriptions {
+		subscriptions[id] = question
+	}
+	return subscriptions
+}
+
+// Start checks timers every second, until session is closed
+func (c *DsoSession) Start() {
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.closeCh:
+				return
+			case now := <-ticker.C:
+				c.checkTimers(now)
+			}
+		}
+	}()
+}
+
+// Close closes connection gracefully
+func (c *DsoSession) Close() {
+	c.mutex.Lock()
+	closeFunc := c.close
+	c.mutex.Unlock()
+	belogs.Debug("DsoSession.Close(): isServer:", c.isServer)
+	if closeFunc != nil {
+		closeFunc()
+	}
+	c.closed()
+}
+
+// closed is called when connection is closed
+func (c *DsoSession) closed() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.state == dnsutil.DSO_SESSION_STATE_DISCONNECTED && c.write == nil {
+		return
+	}
+	c.state = dnsutil.DSO_SESSION_STATE_DISCONNECTED
+	c.write = nil
+	c.close = nil
+	c.pendings = make(map[uint16]*dsoPendingRequest)
+	c.subscriptions = make(map[uint16]*dnsutil.DnsQuestion)
+	select {
+	case <-c.closeCh:
+	default:
+		close(c.closeCh)
+	}
+}
+
+// OnReceive processes one dns message from peer, returns transportutil.NEXT_CONNECT_POLICY_*
+func (c *DsoSession) OnReceive(msg []byte) (nextConnectPolicy int, err error) {
+	c.mutex.Lock()
+	c.lastReceiveTime = time.Now()
+	c.mutex.Unlock()
+
+	m, err := dnsutil.UnpackDnsMessage(msg)
+	if err != nil {
+		belogs.Error("DsoSession.OnReceive(): UnpackDnsMessage fail, isServer:", c.isServer, "  len(msg):", len(msg), err)
+		return transportutil.NEXT_CONNECT_POLICY_CLOSE_FORCIBLE, err
+	}
+	belogs.Debug("DsoSession.OnReceive(): isServer:", c.isServer, "  message:", m.String())
+	if m.Header.OpCode != dnsutil.DNS_OPCODE_DSO {
+		// only dso is supported in this session
+		if m.Header.Qr == dnsutil.DNS_QR_REQUEST && c.isServer {
+			return transportutil.NEXT_CONNECT_POLICY_KEEP, c.sendMessage(dnsutil.NewDnsResponse(m, uint16(dnsutil.DNS_RCODE_NOTIMP)))
+		}
+		belogs.Debug("DsoSession.OnReceive(): not dso message, ignore, opCode:", m.Header.OpCode)
+		return transportutil.NEXT_CONNECT_POLICY_KEEP, nil
+	}
+	if m.Header.Qr == dnsutil.DNS_QR_RESPONSE {
+		return c.onResponse(m)
+	}
+	if m.Header.Id == 0 {
+		return c.onUnidirectional(m)
+	}
+	return c.onRequest(m)
+}
+
+// onRequest: rfc8490 5.3, only server accepts dso requests
+func (c *DsoSession) onRequest(m *dnsutil.DnsMessage) (nextConnectPolicy int, err error) {
+	primary := m.GetDsoPrimaryTlv()
+	if primary == nil {
+		belogs.Error("DsoSession.onRequest(): dso request has no primary tlv, id:", m.Header.Id)
+		return transportutil.NEXT_CONNECT_POLICY_KEEP, c.sendMessage(dnsutil.NewDnsResponse(m, uint16(dnsutil.DNS_RCODE_FORMERR)))
+	}
+	if !c.isServer {
+		if primary.Type == dnsutil.DSO_TYPE_KEEPALIVE {
+			belogs.Error("DsoSession.onRequest(): client receives keepalive request, id:", m.Header.Id)
+			return transportutil.NEXT_CONNECT_POLICY_CLOSE_FORCIBLE, errors.New("client receives keepalive request")
+		}
+		return transportutil.NEXT_CONNECT_POLICY_KEEP, c.sendMessage(dnsutil.NewDnsResponse(m, uint16(dnsutil.DNS_RCODE_DSOTYPENI)))
+	}
+
+	response := dnsutil.NewDnsResponse(m, uint16(dnsutil.DNS_RCODE_NOERROR))
+	switch primary.Type {
+	case dnsutil.DSO_TYPE_KEEPALIVE:
+		inactivityTimeout, keepaliveInterval, err := primary.GetKeepalive()
+		if err != nil {
+			belogs.Error("DsoSession.onRequest(): GetKeepalive fail, id:", m.Header.Id, err)
+			return transportutil.NEXT_CONNECT_POLICY_CLOSE_FORCIBLE, err
+		}
+		// rfc8490 7.1.1: values in response are what client should use
+		belogs.Debug("DsoSession.onRequest(): keepalive from client, inactivityTimeout:", inactivityTimeout,
+			"  keepaliveInterval:", keepaliveInterval)
+		response.DsoTlvs = append(response.DsoTlvs,
+			dnsutil.NewDsoKeepaliveTlv(c.config.InactivityTimeout, c.config.KeepaliveInterval))
+	case dnsutil.DSO_TYPE_SUBSCRIBE:
+		question, err := primary.GetSubscribe()
+		if err != nil {
+			belogs.Error("DsoSession.onRequest(): GetSubscribe fail, id:", m.Header.Id, err)
+			response.SetRCode(uint16(dnsutil.DNS_RCODE_FORMERR))
+			break
+		}
+		c.mutex.Lock()
+		_, exist := c.subscriptions[m.Header.Id]
+		c.mutex.Unlock()
+		if exist || c.pushHandler == nil {
+			belogs.Error("DsoSession.onRequest(): subscribe id is duplicated or push is not supported, id:", m.Header.Id)
+			return transportutil.NEXT_CONNECT_POLICY_CLOSE_FORCIBLE, errors.New("subscribe is not supported or id is duplicated")
+		}
+		rCode := c.pushHandler.OnSubscribe(c, m.Header.Id, question)
+		if rCode == dnsutil.DNS_RCODE_NOERROR {
+			c.mutex.Lock()
+			c.subscriptions[m.Header.Id] = question
+			c.mutex.Unlock()
+		}
+		response.SetRCode(uint16(rCode))
+	case dnsutil.DSO_TYPE_RETRY_DELAY, dnsutil.DSO_TYPE_ENCRYPTION_PADDING,
+		dnsutil.DSO_TYPE_PUSH, dnsutil.DSO_TYPE_UNSUBSCRIBE, dnsutil.DSO_TYPE_RECONFIRM:
+		// rfc8490 7.2 7.3, rfc8765 6.3-6.5: they are not requests
+		belogs.Error("DsoSession.onRequest(): tlv cannot be primary tlv of request:", dnsutil.GetDsoTypeStr(primary.Type))
+		response.SetRCode(uint16(dnsutil.DNS_RCODE_FORMERR))
+	default:
+		belogs.Debug("DsoSession.onRequest(): tlv is not implemented:", dnsutil.GetDsoTypeStr(primary.Type))
+		response.SetRCode(uint16(dnsutil.DNS_RCODE_DSOTYPENI))
+	}
+
+	// rfc8490 5.1: successful response establishes session
+	if response.GetRCode() == uint16(dnsutil.DNS_RCODE_NOERROR) {
+		c.mutex.Lock()
+		if c.state != dnsutil.DSO_SESSION_STATE_ESTABLISHED_SESSION {
+			c.state = dnsutil.DSO_SESSION_STATE_ESTABLISHED_SESSION
+			c.inactiveTime = time.Now()
+		}
+		c.mutex.Unlock()
+	}
+	return transportutil.NEXT_CONNECT_POLICY_KEEP, c.sendMessage(response)
+}
+
+// onUnidirectional: rfc8490 5.4.3, only in established session
+func (c *DsoSession) onUnidirectional(m *dnsutil.DnsMessage) (nextConnectPolicy int, err error) {
+	primary := m.GetDsoPrimaryTlv()
+	c.mutex.Lock()
+	state := c.state
+	c.mutex.Unlock()
+	if primary == nil || state != dnsutil.DSO_SESSION_STATE_ESTABLISHED_SESSION {
+		belogs.Error("DsoSession.onUnidirectional(): no primary tlv or session is not established, state:", state)
+		return transportutil.NEXT_CONNECT_POLICY_CLOSE_FORCIBLE, errors.New("unidirectional message is invalid in " + state)
+	}
+
+	switch {
+	case primary.Type == dnsutil.DSO_TYPE_KEEPALIVE && !c.isServer:
+		// rfc8490 7.1.2: server updates timeouts
+		inactivityTimeout, keepaliveInterval, err := primary.GetKeepalive()
+		if err != nil {
+			return transportutil.NEXT_CONNECT_POLICY_CLOSE_FORCIBLE, err
+		}
+		c.setTimeouts(inactivityTimeout, keepaliveInterval)
+		return transportutil.NEXT_CONNECT_POLICY_KEEP, nil
+	case primary.Type == dnsutil.DSO_TYPE_RETRY_DELAY && !c.isServer:
+		// rfc8490 7.2.1: client closes, and does not reconnect before retry delay
+		retryDelay, err := primary.GetRetryDelay()
+		if err != nil {
+			return transportutil.NEXT_CONNECT_POLICY_CLOSE_FORCIBLE, err
+		}
+		c.setRetryDelay(retryDelay)
+		belogs.Info("DsoSession.onUnidirectional(): server sends retry delay, will close, retryDelay(ms):", retryDelay,
+			"  rCode:", m.GetRCode())
+		return transportutil.NEXT_CONNECT_POLICY_CLOSE_GRACEFUL, nil
+	case primary.Type == dnsutil.DSO_TYPE_PUSH && !c.isServer:
+		rrs, err := primary.GetPush()
+		if err != nil {
+			belogs.Error("DsoSession.onUnidirectional(): GetPush fail:", err)
+			return transportutil.NEXT_CONNECT_POLICY_CLOSE_FORCIBLE, err
+		}
+		if c.pushHandler != nil {
+			c.pushHandler.OnPush(c, rrs)
+		}
+		return transportutil.NEXT_CONNECT_POLICY_KEEP, nil
+	case primary.Type == dnsutil.DSO_TYPE_UNSUBSCRIBE && c.isServer:
+		subscribeId, err := primary.GetUnsubscribe()
+		if err != nil {
+			return transportutil.NEXT_CONNECT_POLICY_CLOSE_FORCIBLE, err
+		}
+		c.mutex.Lock()
+		question, ok := c.subscriptions[subscribeId]
+		delete(c.subscriptions, subscribeId)
+		c.refreshInactiveTime(ok)
+		c.mutex.Unlock()
+		if !ok {
+			belogs.Debug("DsoSession.onUnidirectional(): subscribeId is not found, ignore:", subscribeId)
+			return transportutil.NEXT_CONNECT_POLICY_KEEP, nil
+		}
+		if c.pushHandler != nil {
+			c.pushHandler.OnUnsubscribe(c, subscribeId, question)
+		}
+		return transportutil.NEXT_CONNECT_POLICY_KEEP, nil
+	case primary.Type == dnsutil.DSO_TYPE_RECONFIRM && c.isServer:
+		rr, err := primary.GetReconfirm()
+		if err != nil {
+			return transportutil.NEXT_CONNECT_POLICY_CLOSE_FORCIBLE, err
+		}
+		if c.pushHandler != nil {
+			c.pushHandler.OnReconfirm(c, rr)
+		}
+		return transportutil.NEXT_CONNECT_POLICY_KEEP, nil
+	}
+	// rfc8490 5.4.5: unrecognized primary tlv of unidirectional message is fatal
+	belogs.Error("DsoSession.onUnidirectional(): tlv is not supported, isServer:", c.isServer, "  type:", dnsutil.GetDsoTypeStr(primary.Type))
+	return transportutil.NEXT_CONNECT_POLICY_CLOSE_FORCIBLE, errors.New("unidirectional tlv " + dnsutil.GetDsoTypeStr(primary.Type) + " is not supported")
+}
+
+// onResponse: rfc8490 5.3, response of our request
+func (c *DsoSession) onResponse(m *dnsutil.DnsMessage) (nextConnectPolicy int, err error) {
+	c.mutex.Lock()
+	pending, ok := c.pendings[m.Header.Id]
+	delete(c.pendings, m.Header.Id)
+	c.mutex.Unlock()
+	if !ok {
+		belogs.Error("DsoSession.onResponse(): response is not for any request, id:", m.Header.Id)
+		return transportutil.NEXT_CONNECT_POLICY_CLOSE_FORCIBLE, errors.New("response id " + strconv.Itoa(int(m.Header.Id)) + " is not found")
+	}
+
+	// response primary tlv is optional, and other tlvs are additional tlvs
+	rCode := m.GetRCode()
+	var responsePrimary *dnsutil.DsoTlv
+	for i := range m.DsoTlvs {
+		tlv := &m.DsoTlvs[i]
+		switch {
+		case i == 0 && tlv.Type == pending.primaryType:
+			responsePrimary = tlv
+		case tlv.Type == dnsutil.DSO_TYPE_RETRY_DELAY && rCode != uint16(dnsutil.DNS_RCODE_NOERROR):
+			// rfc8490 7.2.2: retry delay in error response
+			if retryDelay, err := tlv.GetRetryDelay(); err == nil {
+				c.setRetryDelay(retryDelay)
+			}
+		}
+	}
+
+	c.mutex.Lock()
+	if rCode == uint16(dnsutil.DNS_RCODE_NOERROR) {
+		c.state = dnsutil.DSO_SESSION_STATE_ESTABLISHED_SESSION
+		if pending.primaryType == dnsutil.DSO_TYPE_SUBSCRIBE {
+			c.subscriptions[m.Header.Id] = pending.question
+		}
+	} else if c.state == dnsutil.DSO_SESSION_STATE_ESTABLISHING_SESSION {
+		// rfc8490 5.1: DSOTYPENI or other error, session is not established
+		c.state = dnsutil.DSO_SESSION_STATE_CONNECTED_SESSIONLESS
+	}
+	c.refreshInactiveTime(pending.primaryType != dnsutil.DSO_TYPE_KEEPALIVE)
+	c.mutex.Unlock()
+
+	if rCode == uint16(dnsutil.DNS_RCODE_NOERROR) && responsePrimary != nil && responsePrimary.Type == dnsutil.DSO_TYPE_KEEPALIVE {
+		inactivityTimeout, keepaliveInterval, err := responsePrimary.GetKeepalive()
+		if err != nil {
+			return transportutil.NEXT_CONNECT_POLICY_CLOSE_FORCIBLE, err
+		}
+		c.setTimeouts(inactivityTimeout, keepaliveInterval)
+	}
+	// responseCh is buffered, and maybe nobody waits
+	pending.responseCh <- m
+	return transportutil.NEXT_CONNECT_POLICY_KEEP, nil
+}
+
+// SendRequest sends dso request and waits for response, cannot be called in DsoPushHandler
+func (c *DsoSession) SendRequest(primary dnsutil.DsoTlv, additionals ...dnsutil.DsoTlv) (*dnsutil.DnsMessage, error) {
+	pending, err := c.sendRequest(nil, primary, additionals...)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case m := <-pending.responseCh:
+		return m, nil
+	case <-c.closeCh:
+		return nil, errors.New("session is closed")
+	case <-time.After(DSO_RESPONSE_TIMEOUT_SECONDS * time.Second):
+		belogs.Error("DsoSession.SendRequest(): response is timeout, type:", dnsutil.GetDsoTypeStr(primary.Type))
+		return nil, errors.New("response is timeout")
+	}
+}
+
+func (c *DsoSession) sendRequest(question *dnsutil.DnsQuestion, primary dnsutil.DsoTlv,
+	additionals ...dnsutil.DsoTlv) (*dsoPendingRequest, error) {
+	c.mutex.Lock()
+	if c.state == dnsutil.DSO_SESSION_STATE_DISCONNECTED || c.state == dnsutil.DSO_SESSION_STATE_CONNECTING {
+		c.mutex.Unlock()
+		return nil, errors.New("session is not connected")
+	}
+	if len(c.pendings)+len(c.subscriptions) >= 0xffff {
+		c.mutex.Unlock()
+		return nil, errors.New("too many requests")
+	}
+	// message id is not 0, and not used by pending request or subscription
+	for {
+		c.nextId++
+		_, isPending := c.pendings[c.nextId]
+		_, isSubscription := c.subscriptions[c.nextId]
+		if c.nextId != 0 && !isPending && !isSubscription {
+			break
+		}
+	}
+	id := c.nextId
+	pending := &dsoPendingRequest{primaryType: primary.Type, question: question, responseCh: make(chan *dnsutil.DnsMessage, 1)}
+	c.pendings[id] = pending
+	if c.state == dnsutil.DSO_SESSION_STATE_CONNECTED_SESSIONLESS {
+		c.state = dnsutil.DSO_SESSION_STATE_ESTABLISHING_SESSION
+	}
+	c.mutex.Unlock()
+
+	if err := c.sendMessage(dnsutil.NewDsoMessage(id, append([]dnsutil.DsoTlv{primary}, additionals...)...)); err != nil {
+		c.mutex.Lock()
+		delete(c.pendings, id)
+		c.mutex.Unlock()
+		return nil, err
+	}
+	return pending, nil
+}
+
+// sendUnidirectional: rfc8490 5.4.3, only in established session
+func (c *DsoSession) sendUnidirectional(rCode uint8, primary dnsutil.DsoTlv) error {
+	if state := c.GetState(); state != dnsutil.DSO_SESSION_STATE_ESTABLISHED_SESSION {
+		return errors.New("session is not established: " + state)
+	}
+	m := dnsutil.NewDsoMessage(0, primary)
+	m.SetRCode(uint16(rCode))
+	return c.sendMessage(m)
+}
+
+// sendMessage packs message with encryption padding, rfc8490 7.3
+func (c *DsoSession) sendMessage(m *dnsutil.DnsMessage) error {
+	b, err := m.Pack()
+	if err != nil {
+		belogs.Error("DsoSession.sendMessage(): Pack fail:", m.String(), err)
+		return err
+	}
+	if c.config.PaddingBlockSize > 0 && m.Header.OpCode == dnsutil.DNS_OPCODE_DSO {
+		length := (c.config.PaddingBlockSize - (len(b)+dnsutil.DSO_LENGTH_MIN)%c.config.PaddingBlockSize) % c.config.PaddingBlockSize
+		m.DsoTlvs = append(m.DsoTlvs, dnsutil.NewDsoEncryptionPaddingTlv(length))
+		if b, err = m.Pack(); err != nil {
+			belogs.Error("DsoSession.sendMessage(): Pack with padding fail:", m.String(), err)
+			return err
+		}
+	}
+
+	c.mutex.Lock()
+	write := c.write
+	c.lastSendTime = time.Now()
+	c.mutex.Unlock()
+	if write == nil {
+		return errors.New("session is not connected")
+	}
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	belogs.Debug("DsoSession.sendMessage(): isServer:", c.isServer, "  len(b):", len(b), "  message:", m.String())
+	return write(b)
+}
+
+// SendKeepalive: client sends keepalive request with config timeouts, and uses timeouts from server
+func (c *DsoSession) SendKeepalive() error {
+	if c.isServer {
+		return errors.New("server cannot send keepalive request")
+	}
+	m, err := c.SendRequest(dnsutil.NewDsoKeepaliveTlv(c.config.InactivityTimeout, c.config.KeepaliveInterval))
+	if err != nil {
+		return err
+	}
+	if m.GetRCode() != uint16(dnsutil.DNS_RCODE_NOERROR) {
+		return errors.New("keepalive fail, rCode is " + strconv.Itoa(int(m.GetRCode())))
+	}
+	return nil
+}
+
+// SendKeepaliveUpdate: server changes timeouts of client, rfc8490 7.1.2
+func (c *DsoSession) SendKeepaliveUpdate(inactivityTimeout, keepaliveInterval uint32) error {
+	if !c.isServer {
+		return errors.New("client cannot send keepalive unidirectional message")
+	}
+	if err := c.sendUnidirectional(dnsutil.DNS_RCODE_NOERROR, dnsutil.NewDsoKeepaliveTlv(inactivityTimeout, keepaliveInterval)); err != nil {
+		return err
+	}
+	c.setTimeouts(inactivityTimeout, keepaliveInterval)
+	return nil
+}
+
+// SendRetryDelay: server asks client to close and not reconnect before retryDelay(ms), rfc8490 7.2.1.
+// Server closes connection forcibly when client does not close in DSO_CLOSE_WAIT_SECONDS
+func (c *DsoSession) SendRetryDelay(retryDelay uint32, rCode uint8) error {
+	if !c.isServer {
+		return errors.New("client cannot send retry delay")
+	}
+	if err := c.sendUnidirectional(rCode, dnsutil.NewDsoRetryDelayTlv(retryDelay)); err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	c.closingTime = time.Now()
+	c.mutex.Unlock()
+	return nil
+}
+
+// Subscribe: client subscribes question, rfc8765 6.2
+func (c *DsoSession) Subscribe(question *dnsutil.DnsQuestion) (subscribeId uint16, err error) {
+	if c.isServer {
+		return 0, errors.New("server cannot subscribe")
+	}
+	tlv, err := dnsutil.NewDsoSubscribeTlv(question)
+	if err != nil {
+		return 0, err
+	}
+	pending, err := c.sendRequest(question, tlv)
+	if err != nil {
+		return 0, err
+	}
+	select {
+	case m := <-pending.responseCh:
+		if m.GetRCode() != uint16(dnsutil.DNS_RCODE_NOERROR) {
+			return 0, dnsutil.NewDnsError("subscribe fail", m.Header.Id, dnsutil.DNS_OPCODE_DSO, uint8(m.GetRCode()),
+				transportutil.NEXT_CONNECT_POLICY_KEEP)
+		}
+		return m.Header.Id, nil
+	case <-c.closeCh:
+		return 0, errors.New("session is closed")
+	case <-time.After(DSO_RESPONSE_TIMEOUT_SECONDS * time.Second):
+		return 0, errors.New("response of subscribe is timeout")
+	}
+}
+
+// Unsubscribe: client cancels subscription, rfc8765 6.4
+func (c *DsoSession) Unsubscribe(subscribeId uint16) error {
+	c.mutex.Lock()
+	_, ok := c.subscriptions[subscribeId]
+	c.mutex.Unlock()
+	if c.isServer || !ok {
+		return errors.New("subscription is not found: " + strconv.Itoa(int(subscribeId)))
+	}
+	if err := c.sendUnidirectional(dnsutil.DNS_RCODE_NOERROR, dnsutil.NewDsoUnsubscribeTlv(subscribeId)); err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	delete(c.subscriptions, subscribeId)
+	c.refreshInactiveTime(true)
+	c.mutex.Unlock()
+	return nil
+}
+
+// Reconfirm: client asks server to reconfirm rr, rfc8765 6.5
+func (c *DsoSession) Reconfirm(rr *dnsutil.DnsRr) error {
+	if c.isServer {
+		return errors.New("server cannot reconfirm")
+	}
+	tlv, err := dnsutil.NewDsoReconfirmTlv(rr)
+	if err != nil {
+		return err
+	}
+	return c.sendUnidirectional(dnsutil.DNS_RCODE_NOERROR, tlv)
+}
+
+// Push: server pushes changes to client, rfc8765 6.3
+func (c *DsoSession) Push(rrs []*dnsutil.DnsRr) error {
+	if !c.isServer {
+		return errors.New("client cannot push")
+	}
+	tlv, err := dnsutil.NewDsoPushTlv(rrs)
+	if err != nil {
+		return err
+	}
+	return c.sendUnidirectional(dnsutil.DNS_RCODE_NOERROR, tlv)
+}
+
+func (c *DsoSession) setTimeouts(inactivityTimeout, keepaliveInterval uint32) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	belogs.Debug("DsoSession.setTimeouts(): isServer:", c.isServer, "  inactivityTimeout:", inactivityTimeout,
+		"  keepaliveInterval:", keepaliveInterval)
+	c.inactivityTimeout = inactivityTimeout
+	c.keepaliveInterval = keepaliveInterval
+}
+
+func (c *DsoSession) setRetryDelay(retryDelay uint32) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.retryTime = time.Now().Add(time.Duration(retryDelay) * time.Millisecond)
+}
+
+// isActive: there are subscriptions or requests except keepalive, rfc8490 6.2.
+// should be called in mutex
+func (c *DsoSession) isActive() bool {
+	if len(c.subscriptions) > 0 {
+		return true
+	}
+	for _, pending := range c.pendings {
+		if pending.primaryType != dnsutil.DSO_TYPE_KEEPALIVE {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshInactiveTime: session becomes inactive after last operation is done. should be called in mutex
+func (c *DsoSession) refreshInactiveTime(isOperationDone bool) {
+	if isOperationDone && !c.isActive() {
+		c.inactiveTime = time.Now()
+	}
+}
+
+// checkTimers: rfc8490 6.2 6.4 6.5.
+// Client closes inactive session, and sends keepalive when there is no traffic in keepalive interval;
+// server asks delinquent client to close by retry delay, and aborts client without traffic
+func (c *DsoSession) checkTimers(now time.Time) {
+	c.mutex.Lock()
+	if c.state != dnsutil.DSO_SESSION_STATE_ESTABLISHED_SESSION {
+		c.mutex.Unlock()
+		return
+	}
+	isActive := c.isActive()
+	inactivityTimeout := getDsoDuration(c.inactivityTimeout)
+	keepaliveInterval := getDsoDuration(c.keepaliveInterval)
+	sinceInactive := now.Sub(c.inactiveTime)
+	sinceSend := now.Sub(c.lastSendTime)
+	sinceReceive := now.Sub(c.lastReceiveTime)
+	closingTime := c.closingTime
+	c.mutex.Unlock()
+
+	if c.isServer {
+		switch {
+		case !closingTime.IsZero():
+			if now.Sub(closingTime) >= DSO_CLOSE_WAIT_SECONDS*time.Second {
+				belogs.Info("DsoSession.checkTimers(): client does not close after retry delay, will abort")
+				c.Close()
+			}
+		case keepaliveInterval >= 0 && sinceReceive >= getDelinquentDuration(keepaliveInterval):
+			belogs.Info("DsoSession.checkTimers(): no traffic from client, will abort, sinceReceive:", sinceReceive)
+			c.Close()
+		case !isActive && inactivityTimeout >= 0 && sinceInactive >= getDelinquentDuration(inactivityTimeout):
+			belogs.Info("DsoSession.checkTimers(): client is delinquent, will send retry delay, sinceInactive:", sinceInactive)
+			if err := c.SendRetryDelay(0, dnsutil.DNS_RCODE_NOERROR); err != nil {
+				c.Close()
+			}
+		}
+		return
+	}
+
+	switch {
+	case !isActive && inactivityTimeout >= 0 && sinceInactive >= inactivityTimeout:
+		belogs.Debug("DsoSession.checkTimers(): session is inactive, will close, sinceInactive:", sinceInactive)
+		c.Close()
+	case keepaliveInterval >= 0 && sinceSend >= keepaliveInterval:
+		belogs.Debug("DsoSession.checkTimers(): will send keepalive, sinceSend:", sinceSend)
+		if _, err := c.sendRequest(nil, dnsutil.NewDsoKeepaliveTlv(c.config.InactivityTimeout, c.config.KeepaliveInterval)); err != nil {
+			belogs.Error("DsoSession.checkTimers(): send keepalive fail:", err)
+		}
+	}
+}
+
+// getDsoDuration: infinite is -1
+func getDsoDuration(milliseconds uint32) time.Duration {
+	if milliseconds == DSO_INFINITE_MILLISECONDS {
+		return -1
+	}
+	return time.Duration(milliseconds) * time.Millisecond
+}
+
+// getDelinquentDuration: twice of timeout, or DSO_DELINQUENT_MIN_SECONDS
+func getDelinquentDuration(d time.Duration) time.Duration {
+	if d*2 < DSO_DELINQUENT_MIN_SECONDS*time.Second {
+		return DSO_DELINQUENT_MIN_SECONDS * time.Second
+	}
+	return d * 2
+}
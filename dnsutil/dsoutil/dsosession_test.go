@@ -0,0 +1,300 @@
+package dsoutil
+
+import (
+	"fmt"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cpusoft/goutil/dnsutil"
+	"github.com/cpusoft/goutil/jsonutil"
+	"github.com/cpusoft/goutil/transportutil"
+)
+
+type testPushHandler struct {
+	mutex        sync.Mutex
+	subscribes   []string
+	unsubscribes []uint16
+	reconfirms   []string
+	pushes       [][]*dnsutil.DnsRr
+}
+
+func (c *testPushHandler) OnSubscribe(session *DsoSession, subscribeId uint16, question *dnsutil.DnsQuestion) (rCode uint8) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if question.Name == "refused.example.com." {
+		return dnsutil.DNS_RCODE_REFUSED
+	}
+	c.subscribes = append(c.subscribes, question.Name)
+	return dnsutil.DNS_RCODE_NOERROR
+}
+func (c *testPushHandler) OnUnsubscribe(session *DsoSession, subscribeId uint16, question *dnsutil.DnsQuestion) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.unsubscribes = append(c.unsubscribes, subscribeId)
+}
+func (c *testPushHandler) OnReconfirm(session *DsoSession, rr *dnsutil.DnsRr) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.reconfirms = append(c.reconfirms, rr.String())
+}
+func (c *testPushHandler) OnPush(session *DsoSession, rrs []*dnsutil.DnsRr) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.pushes = append(c.pushes, rrs)
+}
+
+// newTestDsoSessions connects server and client in memory, policies are last policies of OnReceive
+func newTestDsoSessions(serverConfig, clientConfig *DsoSessionConfig, handler DsoPushHandler) (server, client *DsoSession,
+	policies map[bool]int, lengths *[]int) {
+	server = NewDsoSession(true, serverConfig, handler, nil, nil)
+	client = NewDsoSession(false, clientConfig, handler, nil, nil)
+	policies = make(map[bool]int)
+	lengths = &[]int{}
+	var mutex sync.Mutex
+	write := func(to *DsoSession) func(msg []byte) error {
+		return func(msg []byte) error {
+			policy, err := to.OnReceive(msg)
+			mutex.Lock()
+			policies[to.isServer] = policy
+			*lengths = append(*lengths, len(msg))
+			mutex.Unlock()
+			return err
+		}
+	}
+	server.setConn(write(client), func() { client.closed() })
+	client.setConn(write(server), func() { server.closed() })
+	return server, client, policies, lengths
+}
+
+func TestDsoSession(t *testing.T) {
+	handler := &testPushHandler{}
+	serverConfig := &DsoSessionConfig{InactivityTimeout: 20000, KeepaliveInterval: 30000}
+	server, client, _, _ := newTestDsoSessions(serverConfig, NewDefaultDsoSessionConfig(), handler)
+
+	// client uses timeouts from server
+	if err := client.SendKeepalive(); err != nil {
+		t.Fatal(err)
+	}
+	inactivityTimeout, keepaliveInterval := client.GetTimeouts()
+	if inactivityTimeout != 20000 || keepaliveInterval != 30000 {
+		t.Fatal("timeouts are wrong:", inactivityTimeout, keepaliveInterval)
+	}
+	if server.GetState() != dnsutil.DSO_SESSION_STATE_ESTABLISHED_SESSION ||
+		client.GetState() != dnsutil.DSO_SESSION_STATE_ESTABLISHED_SESSION {
+		t.Fatal("session should be established:", server.GetState(), client.GetState())
+	}
+
+	question := &dnsutil.DnsQuestion{Name: "_ipp._tcp.example.com.", Type: dnsutil.DNS_TYPE_INT_PTR, Class: dnsutil.DNS_CLASS_INT_IN}
+	subscribeId, err := client.Subscribe(question)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(server.GetSubscriptions()) != 1 || len(client.GetSubscriptions()) != 1 || len(handler.subscribes) != 1 {
+		t.Fatal("subscriptions are wrong")
+	}
+	_, err = client.Subscribe(&dnsutil.DnsQuestion{Name: "refused.example.com.", Type: dnsutil.DNS_TYPE_INT_A, Class: dnsutil.DNS_CLASS_INT_IN})
+	if err == nil || dnsutil.GetDnsErrorRCode(err) != dnsutil.DNS_RCODE_REFUSED || len(server.GetSubscriptions()) != 1 {
+		t.Fatal("subscribe should be refused:", err)
+	}
+
+	rrs := []*dnsutil.DnsRr{{Name: "_ipp._tcp.example.com.", Type: dnsutil.DNS_TYPE_INT_PTR, Class: dnsutil.DNS_CLASS_INT_IN,
+		Ttl: 3600, Data: &dnsutil.DnsRrDataHost{Host: "printer._ipp._tcp.example.com."}}}
+	if err = server.Push(rrs); err != nil {
+		t.Fatal(err)
+	}
+	if len(handler.pushes) != 1 || jsonutil.MarshalJson(handler.pushes[0]) != jsonutil.MarshalJson(rrs) {
+		t.Fatal("push is wrong:", jsonutil.MarshalJson(handler.pushes))
+	}
+
+	err = client.Reconfirm(&dnsutil.DnsRr{Name: "printer.example.com.", Type: dnsutil.DNS_TYPE_INT_A, Class: dnsutil.DNS_CLASS_INT_IN,
+		Data: &dnsutil.DnsRrDataA{Ip: netip.MustParseAddr("192.0.2.1")}})
+	if err != nil || len(handler.reconfirms) != 1 {
+		t.Fatal("reconfirm is wrong:", err)
+	}
+	fmt.Println(handler.reconfirms)
+
+	if err = client.Unsubscribe(subscribeId); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.GetSubscriptions()) != 0 || len(handler.unsubscribes) != 1 || handler.unsubscribes[0] != subscribeId {
+		t.Fatal("unsubscribe is wrong")
+	}
+
+	// wrong directions
+	if server.Subscribe(question); client.Push(rrs) == nil || server.SendKeepalive() == nil || client.SendRetryDelay(0, 0) == nil {
+		t.Fatal("wrong direction should fail")
+	}
+
+	client.Close()
+	if server.GetState() != dnsutil.DSO_SESSION_STATE_DISCONNECTED || client.GetState() != dnsutil.DSO_SESSION_STATE_DISCONNECTED {
+		t.Fatal("session should be closed")
+	}
+	if _, err = client.SendRequest(dnsutil.NewDsoKeepaliveTlv(0, 0)); err == nil {
+		t.Fatal("closed session should fail")
+	}
+}
+
+func TestDsoSessionNotImplemented(t *testing.T) {
+	server, client, policies, _ := newTestDsoSessions(nil, nil, nil)
+
+	// unknown primary tlv: DSOTYPENI, and session is not established
+	m, err := client.SendRequest(dnsutil.DsoTlv{Type: 0xf000, Data: []byte{1}})
+	if err != nil || m.GetRCode() != uint16(dnsutil.DNS_RCODE_DSOTYPENI) {
+		t.Fatal("should be DSOTYPENI:", err)
+	}
+	if client.GetState() != dnsutil.DSO_SESSION_STATE_CONNECTED_SESSIONLESS ||
+		server.GetState() != dnsutil.DSO_SESSION_STATE_CONNECTED_SESSIONLESS {
+		t.Fatal("session should not be established:", client.GetState())
+	}
+	// no push handler
+	if _, err = client.Subscribe(&dnsutil.DnsQuestion{Name: "a.com.", Type: 1, Class: 1}); err == nil ||
+		policies[true] != transportutil.NEXT_CONNECT_POLICY_CLOSE_FORCIBLE {
+		t.Fatal("subscribe without handler should fail")
+	}
+
+	// unidirectional message before session is established
+	b, _ := dnsutil.NewDsoMessage(0, dnsutil.NewDsoUnsubscribeTlv(1)).Pack()
+	if policy, err := server.OnReceive(b); err == nil || policy != transportutil.NEXT_CONNECT_POLICY_CLOSE_FORCIBLE {
+		t.Fatal("unidirectional message should fail")
+	}
+	// response of unknown request
+	b, _ = dnsutil.NewDnsResponse(dnsutil.NewDsoMessage(99), 0).Pack()
+	if policy, err := client.OnReceive(b); err == nil || policy != transportutil.NEXT_CONNECT_POLICY_CLOSE_FORCIBLE {
+		t.Fatal("unknown response should fail")
+	}
+	// query is not implemented
+	b, _ = dnsutil.NewDnsQuery(1, "a.com", dnsutil.DNS_TYPE_INT_A).Pack()
+	if policy, err := server.OnReceive(b); err != nil || policy != transportutil.NEXT_CONNECT_POLICY_KEEP {
+		t.Fatal("query should be NOTIMP:", err)
+	}
+}
+
+func TestDsoSessionTimers(t *testing.T) {
+	config := &DsoSessionConfig{InactivityTimeout: 10000, KeepaliveInterval: 20000}
+	server, client, policies, _ := newTestDsoSessions(config, config, &testPushHandler{})
+	if err := client.SendKeepalive(); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	// client is active when there is subscription
+	subscribeId, err := client.Subscribe(&dnsutil.DnsQuestion{Name: "a.com.", Type: 1, Class: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.checkTimers(now.Add(15 * time.Second))
+	if client.GetState() != dnsutil.DSO_SESSION_STATE_ESTABLISHED_SESSION {
+		t.Fatal("active session should not be closed")
+	}
+	// client sends keepalive when there is no traffic
+	client.checkTimers(now.Add(21 * time.Second))
+	if time.Since(client.lastSendTime) > time.Second || len(client.pendings) != 0 {
+		t.Fatal("keepalive should be sent")
+	}
+
+	// server sends retry delay to delinquent client
+	client.Unsubscribe(subscribeId)
+	server.checkTimers(time.Now().Add(19 * time.Second))
+	if !server.closingTime.IsZero() {
+		t.Fatal("server should wait twice of inactivity timeout")
+	}
+	server.checkTimers(time.Now().Add(21 * time.Second))
+	if server.closingTime.IsZero() || client.GetRetryTime().IsZero() ||
+		policies[false] != transportutil.NEXT_CONNECT_POLICY_CLOSE_GRACEFUL {
+		t.Fatal("retry delay should be sent")
+	}
+	server.checkTimers(server.closingTime.Add(DSO_CLOSE_WAIT_SECONDS * time.Second))
+	if server.GetState() != dnsutil.DSO_SESSION_STATE_DISCONNECTED {
+		t.Fatal("server should close")
+	}
+
+	// client closes inactive session
+	server, client, _, _ = newTestDsoSessions(config, config, nil)
+	client.SendKeepalive()
+	client.checkTimers(time.Now().Add(11 * time.Second))
+	if client.GetState() != dnsutil.DSO_SESSION_STATE_DISCONNECTED || server.GetState() != dnsutil.DSO_SESSION_STATE_DISCONNECTED {
+		t.Fatal("client should close inactive session")
+	}
+
+	// server aborts client without traffic, and keeps session when timeouts are infinite
+	infinite := &DsoSessionConfig{InactivityTimeout: DSO_INFINITE_MILLISECONDS, KeepaliveInterval: 20000}
+	server, client, _, _ = newTestDsoSessions(infinite, config, nil)
+	client.SendKeepalive()
+	server.checkTimers(time.Now().Add(39 * time.Second))
+	if server.GetState() != dnsutil.DSO_SESSION_STATE_ESTABLISHED_SESSION {
+		t.Fatal("server should wait twice of keepalive interval")
+	}
+	server.checkTimers(time.Now().Add(41 * time.Second))
+	if server.GetState() != dnsutil.DSO_SESSION_STATE_DISCONNECTED {
+		t.Fatal("server should abort client without traffic")
+	}
+}
+
+func TestDsoSessionPadding(t *testing.T) {
+	config := NewDefaultDsoSessionConfig()
+	config.PaddingBlockSize = DSO_PADDING_BLOCK_SIZE_CLIENT
+	_, client, _, lengths := newTestDsoSessions(config, config, nil)
+	if err := client.SendKeepalive(); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(*lengths)
+	if len(*lengths) != 2 {
+		t.Fatal("should be request and response:", *lengths)
+	}
+	for _, length := range *lengths {
+		if length%DSO_PADDING_BLOCK_SIZE_CLIENT != 0 {
+			t.Fatal("length should be padded:", length)
+		}
+	}
+}
+
+func TestDsoTcp(t *testing.T) {
+	handler := &testPushHandler{}
+	process := NewDsoTcpServerProcess(nil, handler)
+	ts := transportutil.NewTcpServer(process, make(chan transportutil.BusinessToConnMsg, 16),
+		DSO_TCP_LENGTH_DECLARATION, DSO_TCP_RECEIVE_LENGTH)
+	go ts.StartTcpServer("9953")
+	defer ts.SendMsgForCloseConnect(transportutil.BUSINESS_TO_CONN_MSG_TYPE_SERVER_CLOSE_FORCIBLE, "")
+
+	var client *DsoSession
+	var err error
+	for i := 0; i < 20; i++ {
+		if client, err = StartDsoTcpClient("127.0.0.1:9953", nil, handler); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = client.SendKeepalive(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Subscribe(&dnsutil.DnsQuestion{Name: "www.example.com.", Type: dnsutil.DNS_TYPE_INT_A,
+		Class: dnsutil.DNS_CLASS_INT_IN}); err != nil {
+		t.Fatal(err)
+	}
+	process.PushToSubscribers([]*dnsutil.DnsRr{
+		{Name: "www.example.com.", Type: dnsutil.DNS_TYPE_INT_A, Class: dnsutil.DNS_CLASS_INT_IN, Ttl: 60,
+			Data: &dnsutil.DnsRrDataA{Ip: netip.MustParseAddr("192.0.2.1")}},
+		{Name: "other.example.com.", Type: dnsutil.DNS_TYPE_INT_A, Class: dnsutil.DNS_CLASS_INT_IN, Ttl: 60,
+			Data: &dnsutil.DnsRrDataA{Ip: netip.MustParseAddr("192.0.2.2")}},
+	})
+	for i := 0; i < 20; i++ {
+		handler.mutex.Lock()
+		pushes := len(handler.pushes)
+		handler.mutex.Unlock()
+		if pushes > 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	if len(handler.pushes) != 1 || len(handler.pushes[0]) != 1 || handler.pushes[0][0].Name != "www.example.com." {
+		t.Fatal("push is wrong:", jsonutil.MarshalJson(handler.pushes))
+	}
+	client.Close()
+}
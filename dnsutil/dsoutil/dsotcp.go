@@ -0,0 +1,163 @@
+package dsoutil
+
+import (
+	"sync"
+
+	"github.com/cpusoft/goutil/belogs"
+	"github.com/cpusoft/goutil/dnsutil"
+	"github.com/cpusoft/goutil/transportutil"
+)
+
+const (
+	// tcp server/client do not declare length, and dso splits stream by length, rfc1035 4.2.2.
+	// one read is at most DSO_TCP_RECEIVE_LENGTH, longer message is joined by leftData
+	DSO_TCP_LENGTH_DECLARATION = "false"
+	DSO_TCP_RECEIVE_LENGTH     = 2048
+)
+
+// DsoTcpServerProcess is transportutil.TcpServerProcess, one DsoSession for one connection.
+// Use it as: transportutil.NewTcpServer(process, ch, DSO_TCP_LENGTH_DECLARATION, DSO_TCP_RECEIVE_LENGTH)
+type DsoTcpServerProcess struct {
+	config      *DsoSessionConfig
+	pushHandler DsoPushHandler
+
+	sessionsMutex sync.RWMutex
+	sessions      map[string]*DsoSession
+}
+
+func NewDsoTcpServerProcess(config *DsoSessionConfig, pushHandler DsoPushHandler) *DsoTcpServerProcess {
+	return &DsoTcpServerProcess{
+		config:      config,
+		pushHandler: pushHandler,
+		sessions:    make(map[string]*DsoSession),
+	}
+}
+
+func (c *DsoTcpServerProcess) OnConnectProcess(tcpConn *transportutil.TcpConn) {
+	session := NewDsoSession(true, c.config, c.pushHandler, getDsoTcpWrite(tcpConn), func() { tcpConn.Close() })
+	connKey := transportutil.GetTcpConnKey(tcpConn)
+	c.sessionsMutex.Lock()
+	c.sessions[connKey] = session
+	c.sessionsMutex.Unlock()
+	session.Start()
+	belogs.Debug("DsoTcpServerProcess.OnConnectProcess(): new session, connKey:", connKey)
+}
+
+func (c *DsoTcpServerProcess) OnReceiveAndSendProcess(tcpConn *transportutil.TcpConn, receiveData []byte) (nextConnectPolicy int, leftData []byte, err error) {
+	connKey := transportutil.GetTcpConnKey(tcpConn)
+	c.sessionsMutex.RLock()
+	session, ok := c.sessions[connKey]
+	c.sessionsMutex.RUnlock()
+	if !ok {
+		belogs.Error("DsoTcpServerProcess.OnReceiveAndSendProcess(): session is not found, connKey:", connKey)
+		return transportutil.NEXT_CONNECT_POLICY_CLOSE_FORCIBLE, nil, nil
+	}
+	return onDsoTcpReceive(session, receiveData)
+}
+
+func (c *DsoTcpServerProcess) OnCloseProcess(tcpConn *transportutil.TcpConn) {
+	connKey := transportutil.GetTcpConnKey(tcpConn)
+	c.sessionsMutex.Lock()
+	session, ok := c.sessions[connKey]
+	delete(c.sessions, connKey)
+	c.sessionsMutex.Unlock()
+	if ok {
+		session.closed()
+	}
+	belogs.Debug("DsoTcpServerProcess.OnCloseProcess(): session is closed, connKey:", connKey)
+}
+
+// GetSessions gets all sessions, connKey --> session
+func (c *DsoTcpServerProcess) GetSessions() map[string]*DsoSession {
+	c.sessionsMutex.RLock()
+	defer c.sessionsMutex.RUnlock()
+	sessions := make(map[string]*DsoSession, len(c.sessions))
+	for connKey, session := range c.sessions {
+		sessions[connKey] = session
+	}
+	return sessions
+}
+
+// PushToSubscribers pushes rrs to sessions which subscribe them, rfc8765 6.3.
+// Type or class ANY in subscription matches all
+func (c *DsoTcpServerProcess) PushToSubscribers(rrs []*dnsutil.DnsRr) {
+	for connKey, session := range c.GetSessions() {
+		subscriptions := session.GetSubscriptions()
+		pushRrs := make([]*dnsutil.DnsRr, 0, len(rrs))
+		for _, rr := range rrs {
+			for _, question := range subscriptions {
+				if dnsutil.EqualDomain(question.Name, rr.Name) &&
+					(question.Type == dnsutil.DNS_TYPE_INT_ANY || question.Type == rr.Type) &&
+					(question.Class == dnsutil.DNS_CLASS_INT_ANY || question.Class == rr.Class) {
+					pushRrs = append(pushRrs, rr)
+					break
+				}
+			}
+		}
+		if len(pushRrs) == 0 {
+			continue
+		}
+		if err := session.Push(pushRrs); err != nil {
+			belogs.Error("DsoTcpServerProcess.PushToSubscribers(): Push fail, connKey:", connKey, err)
+		}
+	}
+}
+
+// DsoTcpClientProcess is transportutil.TcpClientProcess for one DsoSession
+type DsoTcpClientProcess struct {
+	session *DsoSession
+}
+
+// StartDsoTcpClient connects to server, such as "127.0.0.1:853", and gets client session
+func StartDsoTcpClient(server string, config *DsoSessionConfig, pushHandler DsoPushHandler) (*DsoSession, error) {
+	session := NewDsoSession(false, config, pushHandler, nil, nil)
+	session.setState(dnsutil.DSO_SESSION_STATE_CONNECTING)
+	process := &DsoTcpClientProcess{session: session}
+	tc := transportutil.NewTcpClient(process, make(chan transportutil.BusinessToConnMsg, 16),
+		DSO_TCP_LENGTH_DECLARATION, DSO_TCP_RECEIVE_LENGTH)
+	if err := tc.StartTcpClient(server); err != nil {
+		belogs.Error("StartDsoTcpClient(): StartTcpClient fail, server:", server, err)
+		session.closed()
+		return nil, err
+	}
+	session.Start()
+	return session, nil
+}
+
+func (c *DsoTcpClientProcess) OnConnectProcess(tcpConn *transportutil.TcpConn) {
+	c.session.setConn(getDsoTcpWrite(tcpConn), func() { tcpConn.Close() })
+	belogs.Debug("DsoTcpClientProcess.OnConnectProcess(): connected:", transportutil.GetTcpConnKey(tcpConn))
+}
+
+func (c *DsoTcpClientProcess) OnCloseProcess(tcpConn *transportutil.TcpConn) {
+	c.session.closed()
+	belogs.Debug("DsoTcpClientProcess.OnCloseProcess(): session is closed")
+}
+
+func (c *DsoTcpClientProcess) OnReceiveProcess(tcpConn *transportutil.TcpConn, receiveData []byte) (nextRwPolicy int,
+	leftData []byte, connToBusinessMsg *transportutil.ConnToBusinessMsg, err error) {
+	nextConnectPolicy, leftData, err := onDsoTcpReceive(c.session, receiveData)
+	if err != nil || nextConnectPolicy != transportutil.NEXT_CONNECT_POLICY_KEEP {
+		return transportutil.NEXT_RW_POLICY_END_READ, nil, nil, err
+	}
+	return transportutil.NEXT_RW_POLICY_WAIT_READ, leftData, nil, nil
+}
+
+func onDsoTcpReceive(session *DsoSession, receiveData []byte) (nextConnectPolicy int, leftData []byte, err error) {
+	msgs, leftData := dnsutil.SplitTcpDnsMessages(receiveData)
+	for _, msg := range msgs {
+		nextConnectPolicy, err = session.OnReceive(msg)
+		if err != nil || nextConnectPolicy != transportutil.NEXT_CONNECT_POLICY_KEEP {
+			belogs.Debug("onDsoTcpReceive(): will close, nextConnectPolicy:", nextConnectPolicy, err)
+			return nextConnectPolicy, nil, err
+		}
+	}
+	return transportutil.NEXT_CONNECT_POLICY_KEEP, leftData, nil
+}
+
+func getDsoTcpWrite(tcpConn *transportutil.TcpConn) func(msg []byte) error {
+	return func(msg []byte) error {
+		_, err := tcpConn.Write(dnsutil.GetTcpDnsMessage(msg))
+		return err
+	}
+}
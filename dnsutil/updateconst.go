@@ -1,6 +1,6 @@
 package dnsutil
 
 const (
-	// dso header: 12bytes: Id(2) + Qr/OpCode/Z/RCode(2) + ZOCOUNT(2) + PRCOUNT(2) + UPCOUNT(2) + ADCOUNT(2)
+	// update header: 12bytes: Id(2) + Qr/OpCode/Z/RCode(2) + ZOCOUNT(2) + PRCOUNT(2) + UPCOUNT(2) + ADCOUNT(2)
 	UPDATE_LENGTH_MIN = 12
 )
@@ -0,0 +1,271 @@
+package iputil
+
+import (
+	"errors"
+	"math/bits"
+	"net/netip"
+)
+
+// PrefixTrie is a compressed binary trie (patricia trie) keyed by netip.Prefix,
+// IPv4 and IPv6 are in two separate trees. Prefixes are masked before used,
+// IPv4-mapped IPv6 prefixes are in IPv6 tree. It is not safe for concurrent writes.
+type PrefixTrie[V any] struct {
+	roots [2]*prefixTrieNode[V]
+	size  int
+}
+
+// PrefixTrieEntry is one prefix and its value in PrefixTrie
+type PrefixTrieEntry[V any] struct {
+	Prefix netip.Prefix `json:"prefix"`
+	Value  V            `json:"value"`
+}
+
+// prefixTrieNode: node without value is glue node which has two children
+type prefixTrieNode[V any] struct {
+	prefix   netip.Prefix
+	hasValue bool
+	value    V
+	children [2]*prefixTrieNode[V]
+}
+
+func NewPrefixTrie[V any]() *PrefixTrie[V] {
+	return &PrefixTrie[V]{}
+}
+
+// Len is the count of prefixes with value
+func (t *PrefixTrie[V]) Len() int {
+	return t.size
+}
+
+// Insert adds or replaces value of p, replaced is true when p already exists
+func (t *PrefixTrie[V]) Insert(p netip.Prefix, value V) (replaced bool, err error) {
+	p, root, err := t.getRoot(p)
+	if err != nil {
+		return false, err
+	}
+	*root, replaced = (*root).insert(p, value)
+	if !replaced {
+		t.size++
+	}
+	return replaced, nil
+}
+
+// Delete removes p, deleted is false when p does not exist
+func (t *PrefixTrie[V]) Delete(p netip.Prefix) (deleted bool) {
+	p, root, err := t.getRoot(p)
+	if err != nil {
+		return false
+	}
+	*root, deleted = (*root).delete(p)
+	if deleted {
+		t.size--
+	}
+	return deleted
+}
+
+// Get gets value of exact p
+func (t *PrefixTrie[V]) Get(p netip.Prefix) (value V, ok bool) {
+	p, root, err := t.getRoot(p)
+	if err != nil {
+		return value, false
+	}
+	for n := *root; n != nil && n.prefix.Bits() <= p.Bits(); {
+		if !n.contains(p) {
+			break
+		}
+		if n.prefix.Bits() == p.Bits() {
+			return n.value, n.hasValue
+		}
+		n = n.children[getAddrBit(p.Addr(), n.prefix.Bits())]
+	}
+	return value, false
+}
+
+// LongestMatch gets the longest prefix which contains p, p itself included
+func (t *PrefixTrie[V]) LongestMatch(p netip.Prefix) (entry PrefixTrieEntry[V], ok bool) {
+	covering := t.Covering(p)
+	if len(covering) == 0 {
+		return entry, false
+	}
+	return covering[len(covering)-1], true
+}
+
+// LongestMatchAddr gets the longest prefix which contains addr
+func (t *PrefixTrie[V]) LongestMatchAddr(addr netip.Addr) (entry PrefixTrieEntry[V], ok bool) {
+	return t.LongestMatch(netip.PrefixFrom(addr, addr.BitLen()))
+}
+
+// Covering gets all prefixes which contain p (p itself included), from the shortest to the longest
+func (t *PrefixTrie[V]) Covering(p netip.Prefix) []PrefixTrieEntry[V] {
+	entries := make([]PrefixTrieEntry[V], 0)
+	p, root, err := t.getRoot(p)
+	if err != nil {
+		return entries
+	}
+	for n := *root; n != nil && n.prefix.Bits() <= p.Bits() && n.contains(p); {
+		if n.hasValue {
+			entries = append(entries, PrefixTrieEntry[V]{Prefix: n.prefix, Value: n.value})
+		}
+		if n.prefix.Bits() == p.Bits() {
+			break
+		}
+		n = n.children[getAddrBit(p.Addr(), n.prefix.Bits())]
+	}
+	return entries
+}
+
+// Covered gets all prefixes in p (p itself included), in order of address and then length
+func (t *PrefixTrie[V]) Covered(p netip.Prefix) []PrefixTrieEntry[V] {
+	entries := make([]PrefixTrieEntry[V], 0)
+	p, root, err := t.getRoot(p)
+	if err != nil {
+		return entries
+	}
+	n := *root
+	for n != nil && n.prefix.Bits() < p.Bits() {
+		if !n.contains(p) {
+			return entries
+		}
+		n = n.children[getAddrBit(p.Addr(), n.prefix.Bits())]
+	}
+	if n != nil && p.Contains(n.prefix.Addr()) {
+		n.walk(func(entry PrefixTrieEntry[V]) bool {
+			entries = append(entries, entry)
+			return true
+		})
+	}
+	return entries
+}
+
+// Walk calls fn for all prefixes, IPv4 before IPv6, and stops when fn returns false
+func (t *PrefixTrie[V]) Walk(fn func(entry PrefixTrieEntry[V]) bool) {
+	for _, root := range t.roots {
+		if root != nil && !root.walk(fn) {
+			return
+		}
+	}
+}
+
+func (t *PrefixTrie[V]) getRoot(p netip.Prefix) (netip.Prefix, **prefixTrieNode[V], error) {
+	if !p.IsValid() {
+		return p, nil, errors.New("prefix is invalid")
+	}
+	p = p.Masked()
+	if p.Addr().Is4() {
+		return p, &t.roots[0], nil
+	}
+	return p, &t.roots[1], nil
+}
+
+// contains: prefix of n contains p, and p is not shorter
+func (n *prefixTrieNode[V]) contains(p netip.Prefix) bool {
+	return n.prefix.Bits() <= p.Bits() && n.prefix.Contains(p.Addr())
+}
+
+// insert returns new root of subtree of n
+func (n *prefixTrieNode[V]) insert(p netip.Prefix, value V) (*prefixTrieNode[V], bool) {
+	if n == nil {
+		return &prefixTrieNode[V]{prefix: p, hasValue: true, value: value}, false
+	}
+	common := getCommonBits(n.prefix, p)
+	switch {
+	case common == n.prefix.Bits() && common == p.Bits():
+		replaced := n.hasValue
+		n.hasValue, n.value = true, value
+		return n, replaced
+	case common == n.prefix.Bits():
+		// p is in n
+		b := getAddrBit(p.Addr(), common)
+		var replaced bool
+		n.children[b], replaced = n.children[b].insert(p, value)
+		return n, replaced
+	case common == p.Bits():
+		// n is in p
+		newNode := &prefixTrieNode[V]{prefix: p, hasValue: true, value: value}
+		newNode.children[getAddrBit(n.prefix.Addr(), common)] = n
+		return newNode, false
+	}
+	// glue node of the common bits
+	glue := &prefixTrieNode[V]{prefix: netip.PrefixFrom(p.Addr(), common).Masked()}
+	glue.children[getAddrBit(n.prefix.Addr(), common)] = n
+	glue.children[getAddrBit(p.Addr(), common)] = &prefixTrieNode[V]{prefix: p, hasValue: true, value: value}
+	return glue, false
+}
+
+// delete returns new root of subtree of n, glue node with only one child is removed
+func (n *prefixTrieNode[V]) delete(p netip.Prefix) (*prefixTrieNode[V], bool) {
+	if n == nil || !n.contains(p) {
+		return n, false
+	}
+	if n.prefix.Bits() == p.Bits() {
+		if !n.hasValue {
+			return n, false
+		}
+		var zero V
+		n.hasValue, n.value = false, zero
+		return n.compact(), true
+	}
+	b := getAddrBit(p.Addr(), n.prefix.Bits())
+	var deleted bool
+	if n.children[b], deleted = n.children[b].delete(p); !deleted {
+		return n, false
+	}
+	return n.compact(), true
+}
+
+// compact removes n when it has no value and less than two children
+func (n *prefixTrieNode[V]) compact() *prefixTrieNode[V] {
+	if n.hasValue {
+		return n
+	}
+	switch {
+	case n.children[0] == nil:
+		return n.children[1]
+	case n.children[1] == nil:
+		return n.children[0]
+	}
+	return n
+}
+
+// walk is in pre-order, so prefix is before prefixes in it
+func (n *prefixTrieNode[V]) walk(fn func(entry PrefixTrieEntry[V]) bool) bool {
+	if n.hasValue && !fn(PrefixTrieEntry[V]{Prefix: n.prefix, Value: n.value}) {
+		return false
+	}
+	for _, child := range n.children {
+		if child != nil && !child.walk(fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// getAddrBit gets the i-th bit of addr, 0 is the highest bit
+func getAddrBit(addr netip.Addr, i int) int {
+	if addr.Is4() {
+		a := addr.As4()
+		return int(a[i/8]>>(7-i%8)) & 1
+	}
+	a := addr.As16()
+	return int(a[i/8]>>(7-i%8)) & 1
+}
+
+// getCommonBits gets length of common prefix of p and q, which are in same family
+func getCommonBits(p, q netip.Prefix) int {
+	maxBits := min(p.Bits(), q.Bits())
+	a, b := p.Addr().As16(), q.Addr().As16()
+	offset := 0
+	if p.Addr().Is4() {
+		// As16 of IPv4 is IPv4-mapped IPv6, skip the first 96 bits
+		offset = 12
+	}
+	common := 0
+	for i := offset; i < 16 && common < maxBits; i++ {
+		if x := a[i] ^ b[i]; x != 0 {
+			common += bits.LeadingZeros8(x)
+			break
+		}
+		common += 8
+	}
+	return min(common, maxBits)
+}
@@ -0,0 +1,125 @@
+package iputil
+
+import (
+	"fmt"
+	"math/rand"
+	"net/netip"
+	"testing"
+
+	"github.com/cpusoft/goutil/jsonutil"
+)
+
+func getTriePrefixes(entries []PrefixTrieEntry[int]) []string {
+	ps := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		ps = append(ps, entry.Prefix.String())
+	}
+	return ps
+}
+
+func TestPrefixTrie(t *testing.T) {
+	trie := NewPrefixTrie[int]()
+	for i, s := range []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24", "10.128.0.0/9", "192.168.0.0/16",
+		"2001:db8::/32", "2001:db8:1::/48", "::/0", "10.1.2.3/8"} {
+		if _, err := trie.Insert(netip.MustParsePrefix(s), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 10.1.2.3/8 is masked to 10.0.0.0/8
+	if trie.Len() != 8 {
+		t.Fatal("len is wrong:", trie.Len())
+	}
+	if v, ok := trie.Get(netip.MustParsePrefix("10.0.0.0/8")); !ok || v != 8 {
+		t.Fatal("get is wrong:", v, ok)
+	}
+	if _, ok := trie.Get(netip.MustParsePrefix("10.1.0.0/15")); ok {
+		t.Fatal("10.1.0.0/15 should not exist")
+	}
+
+	entry, ok := trie.LongestMatchAddr(netip.MustParseAddr("10.1.2.3"))
+	if !ok || entry.Prefix.String() != "10.1.2.0/24" {
+		t.Fatal("longest match is wrong:", entry)
+	}
+	if entry, ok = trie.LongestMatch(netip.MustParsePrefix("10.200.0.0/16")); !ok || entry.Prefix.String() != "10.128.0.0/9" {
+		t.Fatal("longest match is wrong:", entry)
+	}
+	if entry, ok = trie.LongestMatchAddr(netip.MustParseAddr("2001:db9::1")); !ok || entry.Prefix.String() != "::/0" {
+		t.Fatal("longest match of ipv6 is wrong:", entry)
+	}
+	if _, ok = trie.LongestMatchAddr(netip.MustParseAddr("11.0.0.1")); ok {
+		t.Fatal("11.0.0.1 should not match")
+	}
+
+	covering := getTriePrefixes(trie.Covering(netip.MustParsePrefix("10.1.2.128/25")))
+	fmt.Println(covering)
+	if jsonutil.MarshalJson(covering) != `["10.0.0.0/8","10.1.0.0/16","10.1.2.0/24"]` {
+		t.Fatal("covering is wrong:", covering)
+	}
+	covered := getTriePrefixes(trie.Covered(netip.MustParsePrefix("10.0.0.0/8")))
+	fmt.Println(covered)
+	if jsonutil.MarshalJson(covered) != `["10.0.0.0/8","10.1.0.0/16","10.1.2.0/24","10.128.0.0/9"]` {
+		t.Fatal("covered is wrong:", covered)
+	}
+	if covered = getTriePrefixes(trie.Covered(netip.MustParsePrefix("10.1.0.0/15"))); jsonutil.MarshalJson(covered) != `["10.1.0.0/16","10.1.2.0/24"]` {
+		t.Fatal("covered of 10.1.0.0/15 is wrong:", covered)
+	}
+
+	// delete glue and leaf
+	if trie.Delete(netip.MustParsePrefix("10.1.0.0/15")) || !trie.Delete(netip.MustParsePrefix("10.1.0.0/16")) ||
+		!trie.Delete(netip.MustParsePrefix("10.1.2.0/24")) || trie.Len() != 6 {
+		t.Fatal("delete is wrong")
+	}
+	if covered = getTriePrefixes(trie.Covered(netip.MustParsePrefix("10.0.0.0/8"))); jsonutil.MarshalJson(covered) != `["10.0.0.0/8","10.128.0.0/9"]` {
+		t.Fatal("covered after delete is wrong:", covered)
+	}
+	if _, err := trie.Insert(netip.Prefix{}, 0); err == nil {
+		t.Fatal("invalid prefix should fail")
+	}
+}
+
+// TestPrefixTrieRandom compares with linear scan
+func TestPrefixTrieRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	randomPrefix := func() netip.Prefix {
+		// few high bits, so prefixes are often nested
+		a := [4]byte{byte(r.Intn(4)), byte(r.Intn(2)) << 7, 0, byte(r.Intn(256))}
+		return netip.PrefixFrom(netip.AddrFrom4(a), r.Intn(33)).Masked()
+	}
+	trie := NewPrefixTrie[int]()
+	all := make(map[netip.Prefix]int)
+	for i := 0; i < 2000; i++ {
+		p := randomPrefix()
+		if r.Intn(3) == 0 {
+			_, exist := all[p]
+			if trie.Delete(p) != exist {
+				t.Fatal("delete is wrong:", p)
+			}
+			delete(all, p)
+			continue
+		}
+		trie.Insert(p, i)
+		all[p] = i
+	}
+	if trie.Len() != len(all) {
+		t.Fatal("len is wrong:", trie.Len(), len(all))
+	}
+	for i := 0; i < 500; i++ {
+		q := randomPrefix()
+		covering, covered := 0, 0
+		for p := range all {
+			if p.Bits() <= q.Bits() && p.Contains(q.Addr()) {
+				covering++
+			}
+			if q.Bits() <= p.Bits() && q.Contains(p.Addr()) {
+				covered++
+			}
+		}
+		if len(trie.Covering(q)) != covering || len(trie.Covered(q)) != covered {
+			t.Fatal("query is wrong:", q, len(trie.Covering(q)), covering, len(trie.Covered(q)), covered)
+		}
+		expect, exist := all[q]
+		if v, ok := trie.Get(q); ok != exist || v != expect {
+			t.Fatal("get is wrong:", q)
+		}
+	}
+}
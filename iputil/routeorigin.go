@@ -0,0 +1,129 @@
+package iputil
+
+import (
+	"errors"
+	"net/netip"
+	"strconv"
+)
+
+// route origin validation states, RFC 6811
+const (
+	ROUTE_ORIGIN_NOT_FOUND = 0
+	ROUTE_ORIGIN_VALID     = 1
+	ROUTE_ORIGIN_INVALID   = 2
+)
+
+// RoaVrp is validated ROA payload used in route origin validation
+type RoaVrp struct {
+	Prefix    netip.Prefix `json:"prefix"`
+	MaxLength int          `json:"maxLength"`
+	Asn       uint32       `json:"asn"`
+}
+
+// RouteOriginResult: Covering are all VRPs whose prefix covers the route,
+// Matched are those which also match maxLength and origin asn
+type RouteOriginResult struct {
+	State    int      `json:"state"`
+	Covering []RoaVrp `json:"covering"`
+	Matched  []RoaVrp `json:"matched"`
+}
+
+// RouteOriginValidator validates routes by VRPs in PrefixTrie
+type RouteOriginValidator struct {
+	trie *PrefixTrie[[]RoaVrp]
+}
+
+func NewRouteOriginValidator(vrps []RoaVrp) (*RouteOriginValidator, error) {
+	v := &RouteOriginValidator{trie: NewPrefixTrie[[]RoaVrp]()}
+	for i := range vrps {
+		if err := v.Add(vrps[i]); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// Add adds vrp, maxLength 0 means same as length of prefix
+func (v *RouteOriginValidator) Add(vrp RoaVrp) error {
+	if !vrp.Prefix.IsValid() {
+		return errors.New("prefix of vrp is invalid")
+	}
+	vrp.Prefix = vrp.Prefix.Masked()
+	if vrp.MaxLength == 0 {
+		vrp.MaxLength = vrp.Prefix.Bits()
+	}
+	if vrp.MaxLength < vrp.Prefix.Bits() || vrp.MaxLength > vrp.Prefix.Addr().BitLen() {
+		return errors.New("maxLength of vrp is invalid: " + vrp.Prefix.String() + " " + strconv.Itoa(vrp.MaxLength))
+	}
+	vrps, _ := v.trie.Get(vrp.Prefix)
+	for i := range vrps {
+		if vrps[i] == vrp {
+			return nil
+		}
+	}
+	_, err := v.trie.Insert(vrp.Prefix, append(vrps, vrp))
+	return err
+}
+
+// Delete removes vrp, deleted is false when vrp does not exist
+func (v *RouteOriginValidator) Delete(vrp RoaVrp) (deleted bool) {
+	if !vrp.Prefix.IsValid() {
+		return false
+	}
+	vrp.Prefix = vrp.Prefix.Masked()
+	if vrp.MaxLength == 0 {
+		vrp.MaxLength = vrp.Prefix.Bits()
+	}
+	vrps, _ := v.trie.Get(vrp.Prefix)
+	for i := range vrps {
+		if vrps[i] == vrp {
+			vrps = append(vrps[:i:i], vrps[i+1:]...)
+			if len(vrps) == 0 {
+				v.trie.Delete(vrp.Prefix)
+			} else {
+				v.trie.Insert(vrp.Prefix, vrps)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Validate gets state of route and its origin asn.
+// Origin asn 0 is for route whose origin cannot be determined (such as AS_SET), it is never matched,
+// same as VRP of AS0 (RFC 6483 and RFC 7607)
+func (v *RouteOriginValidator) Validate(route netip.Prefix, originAsn uint32) (*RouteOriginResult, error) {
+	if !route.IsValid() {
+		return nil, errors.New("route is invalid")
+	}
+	route = route.Masked()
+	result := &RouteOriginResult{
+		State:    ROUTE_ORIGIN_NOT_FOUND,
+		Covering: make([]RoaVrp, 0),
+		Matched:  make([]RoaVrp, 0),
+	}
+	for _, entry := range v.trie.Covering(route) {
+		for _, vrp := range entry.Value {
+			result.Covering = append(result.Covering, vrp)
+			if route.Bits() <= vrp.MaxLength && vrp.Asn != 0 && vrp.Asn == originAsn {
+				result.Matched = append(result.Matched, vrp)
+			}
+		}
+	}
+	if len(result.Matched) > 0 {
+		result.State = ROUTE_ORIGIN_VALID
+	} else if len(result.Covering) > 0 {
+		result.State = ROUTE_ORIGIN_INVALID
+	}
+	return result, nil
+}
+
+func GetRouteOriginStateName(state int) string {
+	switch state {
+	case ROUTE_ORIGIN_VALID:
+		return "valid"
+	case ROUTE_ORIGIN_INVALID:
+		return "invalid"
+	}
+	return "notFound"
+}
@@ -0,0 +1,63 @@
+package iputil
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+
+	"github.com/cpusoft/goutil/jsonutil"
+)
+
+func TestRouteOriginValidator(t *testing.T) {
+	v, err := NewRouteOriginValidator([]RoaVrp{
+		{Prefix: netip.MustParsePrefix("10.0.0.0/8"), MaxLength: 16, Asn: 65001},
+		{Prefix: netip.MustParsePrefix("10.1.0.0/16"), MaxLength: 24, Asn: 65002},
+		{Prefix: netip.MustParsePrefix("192.168.0.0/16"), Asn: 0},
+		{Prefix: netip.MustParsePrefix("2001:db8::/32"), MaxLength: 48, Asn: 65003},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		route string
+		asn   uint32
+		state int
+	}{
+		{"10.0.0.0/8", 65001, ROUTE_ORIGIN_VALID},
+		{"10.2.0.0/16", 65001, ROUTE_ORIGIN_VALID},
+		// too long for maxLength
+		{"10.2.1.0/24", 65001, ROUTE_ORIGIN_INVALID},
+		{"10.1.1.0/24", 65002, ROUTE_ORIGIN_VALID},
+		{"10.1.1.0/24", 65001, ROUTE_ORIGIN_INVALID},
+		{"10.1.0.0/16", 65001, ROUTE_ORIGIN_VALID},
+		{"11.0.0.0/8", 65001, ROUTE_ORIGIN_NOT_FOUND},
+		// less specific than vrp is not covered
+		{"10.0.0.0/7", 65001, ROUTE_ORIGIN_NOT_FOUND},
+		// AS0 never matches
+		{"192.168.1.0/24", 0, ROUTE_ORIGIN_INVALID},
+		{"192.168.0.0/16", 0, ROUTE_ORIGIN_INVALID},
+		{"2001:db8:1::/48", 65003, ROUTE_ORIGIN_VALID},
+		{"2001:db8:1::/64", 65003, ROUTE_ORIGIN_INVALID},
+	} {
+		result, err := v.Validate(netip.MustParsePrefix(test.route), test.asn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Println(test.route, test.asn, GetRouteOriginStateName(result.State))
+		if result.State != test.state {
+			t.Fatal("state is wrong:", test.route, test.asn, jsonutil.MarshalJson(result))
+		}
+	}
+
+	vrp := RoaVrp{Prefix: netip.MustParsePrefix("10.1.0.0/16"), MaxLength: 24, Asn: 65002}
+	if !v.Delete(vrp) || v.Delete(vrp) {
+		t.Fatal("delete is wrong")
+	}
+	if result, _ := v.Validate(netip.MustParsePrefix("10.1.1.0/24"), 65002); result.State != ROUTE_ORIGIN_INVALID ||
+		len(result.Covering) != 1 {
+		t.Fatal("state after delete is wrong:", jsonutil.MarshalJson(result))
+	}
+	if err = v.Add(RoaVrp{Prefix: netip.MustParsePrefix("10.0.0.0/16"), MaxLength: 8, Asn: 1}); err == nil {
+		t.Fatal("maxLength less than length should fail")
+	}
+}
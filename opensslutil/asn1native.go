@@ -0,0 +1,184 @@
+package opensslutil
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cpusoft/goutil/asn1util/asn1node"
+	"github.com/cpusoft/goutil/belogs"
+)
+
+// one line of `openssl asn1parse`, all lines are in DER order, children follow their parent with Depth+1
+type Asn1ParseLine struct {
+	Offset       int    `json:"offset"`
+	Depth        int    `json:"depth"`
+	HeaderLength int    `json:"headerLength"`
+	Length       int    `json:"length"`
+	Class        int    `json:"class"`
+	Tag          int    `json:"tag"`
+	Constructed  bool   `json:"constructed"`
+	TagName      string `json:"tagName"`
+	// oid, string, time, decimal integer or hex of other primitive values
+	Value string `json:"value,omitempty"`
+}
+
+var asn1UniversalTagNames = map[int]string{
+	asn1node.TAG_END_OF_CONTENT:   "EOC",
+	asn1node.TAG_BOOLEAN:          "BOOLEAN",
+	asn1node.TAG_INTEGER:          "INTEGER",
+	asn1node.TAG_BIT_STRING:       "BIT STRING",
+	asn1node.TAG_OCTET_STRING:     "OCTET STRING",
+	asn1node.TAG_NULL:             "NULL",
+	asn1node.TAG_OID:              "OBJECT",
+	asn1node.TAG_REAL:             "REAL",
+	asn1node.TAG_ENUMERATED:       "ENUMERATED",
+	asn1node.TAG_UTF8_STRING:      "UTF8STRING",
+	asn1node.TAG_SEQUENCE:         "SEQUENCE",
+	asn1node.TAG_SET:              "SET",
+	asn1node.TAG_NUMBERIC_STRING:  "NUMERICSTRING",
+	asn1node.TAG_PRINTABLE_STRING: "PRINTABLESTRING",
+	asn1node.TAG_T61_STRING:       "T61STRING",
+	asn1node.TAG_VIDEOTEX_STRING:  "VIDEOTEXSTRING",
+	asn1node.TAG_IA5_STRING:       "IA5STRING",
+	asn1node.TAG_UTC_TIME:         "UTCTIME",
+	asn1node.TAG_GENERALIZED_TIME: "GENERALIZEDTIME",
+	asn1node.TAG_BMP_STRING:       "BMPSTRING",
+}
+
+// String is same format as `openssl asn1parse`, such as:
+// 4:d=1  hl=4 l= 475 cons: SEQUENCE
+func (a *Asn1ParseLine) String() string {
+	cons := "prim"
+	if a.Constructed {
+		cons = "cons"
+	}
+	s := fmt.Sprintf("%5d:d=%-2d hl=%d l=%4d %s: %-18s", a.Offset, a.Depth, a.HeaderLength, a.Length, cons, a.TagName)
+	if len(a.Value) > 0 {
+		value := a.Value
+		if a.Class == asn1node.CLASS_UNIVERSAL && a.Tag == asn1node.TAG_OID {
+			value = GetOidName(value)
+		}
+		s += ":" + value
+	}
+	return strings.TrimRight(s, " ")
+}
+
+// GetAsn1ParseLinesByFile is same as GetResultsByOpensslAns1, file may be DER or PEM
+func GetAsn1ParseLinesByFile(file string) ([]Asn1ParseLine, error) {
+	data, err := readDerFile(file)
+	if err != nil {
+		belogs.Error("GetAsn1ParseLinesByFile(): readDerFile fail, file:", file, err)
+		return nil, err
+	}
+	return GetAsn1ParseLinesByBytes(data)
+}
+
+// GetAsn1ParseLinesByBytes: data is DER, indefinite length is not supported
+func GetAsn1ParseLinesByBytes(data []byte) ([]Asn1ParseLine, error) {
+	if len(data) == 0 {
+		belogs.Error("GetAsn1ParseLinesByBytes(): data is empty")
+		return nil, errors.New("data is empty")
+	}
+	lines := make([]Asn1ParseLine, 0)
+	err := parseAsn1Lines(data, 0, 0, &lines)
+	if err != nil {
+		belogs.Error("GetAsn1ParseLinesByBytes(): parseAsn1Lines fail, len(data):", len(data), "  len(lines):", len(lines), err)
+		return nil, err
+	}
+	belogs.Debug("GetAsn1ParseLinesByBytes(): len(data):", len(data), "  len(lines):", len(lines))
+	return lines, nil
+}
+
+func parseAsn1Lines(data []byte, offset int, depth int, lines *[]Asn1ParseLine) error {
+	for len(data) > 0 {
+		var header asn1node.Header
+		rest, err := asn1node.DecodeHeader(data, &header)
+		if err != nil {
+			return errors.New("decode header fail at offset " + strconv.Itoa(offset) + ": " + err.Error())
+		}
+		if len(rest) > 0 && rest[0] == 0x80 {
+			return errors.New("indefinite length is not supported at offset " + strconv.Itoa(offset))
+		}
+		var length int
+		rest, err = asn1node.DecodeLength(rest, &length)
+		if err != nil {
+			return errors.New("decode length fail at offset " + strconv.Itoa(offset) + ": " + err.Error())
+		}
+		if length < 0 || length > len(rest) {
+			return errors.New("length is beyond data at offset " + strconv.Itoa(offset))
+		}
+		headerLength := len(data) - len(rest)
+		value := rest[:length]
+
+		*lines = append(*lines, Asn1ParseLine{
+			Offset:       offset,
+			Depth:        depth,
+			HeaderLength: headerLength,
+			Length:       length,
+			Class:        header.Class,
+			Tag:          header.Tag,
+			Constructed:  header.IsCompound,
+			TagName:      getAsn1TagName(header.Class, header.Tag),
+		})
+		if header.IsCompound {
+			if err = parseAsn1Lines(value, offset+headerLength, depth+1, lines); err != nil {
+				return err
+			}
+		} else {
+			(*lines)[len(*lines)-1].Value = getAsn1Value(header.Class, header.Tag, value)
+		}
+		data = rest[length:]
+		offset += headerLength + length
+	}
+	return nil
+}
+
+func getAsn1TagName(class int, tag int) string {
+	switch class {
+	case asn1node.CLASS_UNIVERSAL:
+		if name, ok := asn1UniversalTagNames[tag]; ok {
+			return name
+		}
+		return "<ASN1 " + strconv.Itoa(tag) + ">"
+	case asn1node.CLASS_APPLICATION:
+		return "appl [ " + strconv.Itoa(tag) + " ]"
+	case asn1node.CLASS_CONTEXT_SPECIFIC:
+		return "cont [ " + strconv.Itoa(tag) + " ]"
+	default:
+		return "priv [ " + strconv.Itoa(tag) + " ]"
+	}
+}
+
+// hex is used when value cannot be decoded
+func getAsn1Value(class int, tag int, value []byte) string {
+	hexValue := formatHexColon(value)
+	if class != asn1node.CLASS_UNIVERSAL {
+		return hexValue
+	}
+	n := asn1node.NewNode(class, tag)
+	n.SetBytes(value)
+	switch tag {
+	case asn1node.TAG_NULL:
+		return ""
+	case asn1node.TAG_BOOLEAN:
+		if b, err := n.GetBool(); err == nil {
+			return strconv.FormatBool(b)
+		}
+	case asn1node.TAG_INTEGER, asn1node.TAG_ENUMERATED:
+		if i, err := n.GetBigInt(); err == nil {
+			return i.String()
+		}
+	case asn1node.TAG_OID:
+		if oid, err := n.GetOid(); err == nil {
+			return oid
+		}
+	case asn1node.TAG_UTF8_STRING, asn1node.TAG_NUMBERIC_STRING, asn1node.TAG_PRINTABLE_STRING,
+		asn1node.TAG_T61_STRING, asn1node.TAG_IA5_STRING, asn1node.TAG_UTC_TIME, asn1node.TAG_GENERALIZED_TIME:
+		if s, err := n.GetString(); err == nil {
+			return s
+		}
+	}
+	return hexValue
+}
@@ -0,0 +1,91 @@
+package opensslutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cpusoft/goutil/asn1util/asn1node"
+)
+
+func TestGetAsn1ParseLinesByFile(t *testing.T) {
+	b := createNativeTestCert(t)
+	file := filepath.Join(t.TempDir(), "ca.cer")
+	os.WriteFile(file, b, 0644)
+	lines, err := GetAsn1ParseLinesByFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range lines {
+		fmt.Println(lines[i].String())
+	}
+	if lines[0].Offset != 0 || lines[0].Depth != 0 || !lines[0].Constructed || lines[0].TagName != "SEQUENCE" ||
+		lines[0].HeaderLength+lines[0].Length != len(b) {
+		t.Fatal("first line is wrong:", lines[0].String())
+	}
+	// tbsCertificate, [0] version, INTEGER 2
+	if lines[1].Offset != lines[0].HeaderLength || lines[1].Depth != 1 || lines[2].TagName != "cont [ 0 ]" ||
+		lines[3].Depth != 3 || lines[3].Value != "2" {
+		t.Fatal("version is wrong:", lines[1].String(), lines[2].String(), lines[3].String())
+	}
+	var hasSia bool
+	for _, line := range lines {
+		if line.Class == asn1node.CLASS_UNIVERSAL && line.Tag == asn1node.TAG_OID && line.Value == "1.3.6.1.5.5.7.1.11" {
+			hasSia = true
+			if line.String() != fmt.Sprintf("%5d:d=5  hl=2 l=   8 prim: OBJECT            :subjectInfoAccess", line.Offset) {
+				t.Fatal("string of line is wrong:", line.String())
+			}
+		}
+	}
+	if !hasSia {
+		t.Fatal("should have oid of sia")
+	}
+
+	errorDatas := map[string][]byte{
+		"empty":      {},
+		"length":     {0x30, 0x05, 0x02, 0x01, 0x01},
+		"indefinite": {0x30, 0x80, 0x02, 0x01, 0x01, 0x00, 0x00},
+		"child":      {0x30, 0x03, 0x02, 0x05, 0x01},
+	}
+	for name, data := range errorDatas {
+		if _, err = GetAsn1ParseLinesByBytes(data); err == nil {
+			t.Fatal(name, "should fail")
+		}
+	}
+}
+
+// oid value is decoded by asn1node, arcs of 0 are kept
+func TestGetAsn1ValueOid(t *testing.T) {
+	oids := map[string][]byte{
+		"2.5.4.0":               {0x55, 0x04, 0x00},
+		"1.2.840.113549.1.1.11": {0x2a, 0x86, 0x48, 0x86, 0xf7, 0x0d, 0x01, 0x01, 0x0b},
+		"2.999.3":               {0x88, 0x37, 0x03},
+		"0.9.2342.19200300.100": {0x09, 0x92, 0x26, 0x89, 0x93, 0xf2, 0x2c, 0x64},
+	}
+	for oid, b := range oids {
+		s := getAsn1Value(asn1node.CLASS_UNIVERSAL, asn1node.TAG_OID, b)
+		if s != oid {
+			t.Fatal("oid is wrong:", oid, s)
+		}
+	}
+	// invalid oid is shown as hex
+	if s := getAsn1Value(asn1node.CLASS_UNIVERSAL, asn1node.TAG_OID, []byte{0x2a, 0x86}); s != "2A:86" {
+		t.Fatal("invalid oid is wrong:", s)
+	}
+}
+
+// names are same as asn1node
+func TestGetOidName(t *testing.T) {
+	names := map[string]string{
+		"1.2.840.10045.4.3.2":        "ecdsaWithSHA256",
+		"1.2.840.113549.1.9.16.1.49": "rpkiASPA",
+		"1.3.6.1.5.5.7.1.7":          "ipAddrBlocks",
+		"1.2.3.4.5":                  "1.2.3.4.5",
+	}
+	for oid, name := range names {
+		if s := GetOidName(oid); s != name {
+			t.Fatal("name of oid is wrong:", oid, s)
+		}
+	}
+}
@@ -25,16 +25,13 @@ func setupCommonTest(t *testing.T) (
 ) {
 	t.Helper()
 	// 1. 创建临时目录
-	tempDir, err = os.MkdirTemp("", "opensslutil_test")
-	if err != nil {
-		return "", "", "", "", "", "", fmt.Errorf("create temp dir fail: %w", err)
-	}
+	tempDir = t.TempDir()
 
 	// 2. 生成真实自签名PEM证书（通过openssl命令）
 	validCertPEM = filepath.Join(tempDir, "valid.pem")
 	opensslCmd := getOpensslCmd()
 	genPemCmd := exec.Command(opensslCmd, "req", "-x509", "-newkey", "rsa:2048", "-nodes",
-		"-days", "1", "-out", validCertPEM, "-subj", "/CN=test.example.com")
+		"-days", "1", "-keyout", filepath.Join(tempDir, "valid.key"), "-out", validCertPEM, "-subj", "/CN=test.example.com")
 	if output, err := genPemCmd.CombinedOutput(); err != nil {
 		return "", "", "", "", "", "", fmt.Errorf("generate PEM cert fail: %v, output: %s", err, string(output))
 	}
@@ -80,16 +77,13 @@ func setupCommonBenchmark(b *testing.B) (
 	err error,
 ) {
 	b.Helper()
-	tempDir, err = os.MkdirTemp("", "opensslutil_test")
-	if err != nil {
-		return "", "", "", "", "", "", fmt.Errorf("create temp dir fail: %w", err)
-	}
+	tempDir = b.TempDir()
 
 	// 生成PEM证书
 	validCertPEM = filepath.Join(tempDir, "valid.pem")
 	opensslCmd := getOpensslCmd()
 	genPemCmd := exec.Command(opensslCmd, "req", "-x509", "-newkey", "rsa:2048", "-nodes",
-		"-days", "1", "-out", validCertPEM, "-subj", "/CN=test.example.com")
+		"-days", "1", "-keyout", filepath.Join(tempDir, "valid.key"), "-out", validCertPEM, "-subj", "/CN=test.example.com")
 	if output, err := genPemCmd.CombinedOutput(); err != nil {
 		return "", "", "", "", "", "", fmt.Errorf("generate PEM cert fail: %v, output: %s", err, string(output))
 	}
@@ -0,0 +1,384 @@
+package opensslutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/cpusoft/goutil/asn1util/asn1addressasn"
+	"github.com/cpusoft/goutil/asn1util/asn1node"
+	"github.com/cpusoft/goutil/belogs"
+	"github.com/cpusoft/goutil/fileutil"
+)
+
+// same as `openssl x509 -noout -text`, but typed and no need of openssl
+type X509Result struct {
+	Version            int       `json:"version"`
+	SerialNumber       string    `json:"serialNumber"`
+	SerialNumberHex    string    `json:"serialNumberHex"`
+	SignatureAlgorithm string    `json:"signatureAlgorithm"`
+	Issuer             string    `json:"issuer"`
+	Subject            string    `json:"subject"`
+	NotBefore          time.Time `json:"notBefore"`
+	NotAfter           time.Time `json:"notAfter"`
+	PublicKeyAlgorithm string    `json:"publicKeyAlgorithm"`
+	PublicKeyBits      int       `json:"publicKeyBits"`
+
+	// all extensions in order
+	Extensions []X509Extension `json:"extensions"`
+
+	BasicConstraints       *X509BasicConstraints   `json:"basicConstraints,omitempty"`
+	KeyUsages              []string                `json:"keyUsages,omitempty"`
+	ExtKeyUsages           []string                `json:"extKeyUsages,omitempty"`
+	SubjectKeyIdentifier   string                  `json:"subjectKeyIdentifier,omitempty"`
+	AuthorityKeyIdentifier string                  `json:"authorityKeyIdentifier,omitempty"`
+	CrlDistributionPoints  []string                `json:"crlDistributionPoints,omitempty"`
+	AuthorityInfoAccesses  []X509AccessDescription `json:"authorityInfoAccesses,omitempty"`
+	SubjectInfoAccesses    []X509AccessDescription `json:"subjectInfoAccesses,omitempty"`
+	CertificatePolicies    []X509CertificatePolicy `json:"certificatePolicies,omitempty"`
+	// rfc3779
+	IpAddrBlocks  []X509IpAddrBlock  `json:"ipAddrBlocks,omitempty"`
+	AsIdentifiers *X509AsIdentifiers `json:"asIdentifiers,omitempty"`
+}
+
+type X509Extension struct {
+	Oid      string `json:"oid"`
+	Name     string `json:"name"`
+	Critical bool   `json:"critical"`
+	Value    []byte `json:"value"`
+}
+
+type X509BasicConstraints struct {
+	IsCa       bool `json:"isCa"`
+	MaxPathLen int  `json:"maxPathLen"`
+}
+
+// sia/aia
+type X509AccessDescription struct {
+	Method    string `json:"method"`
+	MethodOid string `json:"methodOid"`
+	Location  string `json:"location"`
+}
+
+type X509CertificatePolicy struct {
+	Oid  string   `json:"oid"`
+	Cpss []string `json:"cpss,omitempty"`
+}
+
+// Afi: 1 is ipv4, 2 is ipv6. Inherit is true when ipAddressChoice is NULL
+type X509IpAddrBlock struct {
+	Afi               uint16                 `json:"afi"`
+	Inherit           bool                   `json:"inherit"`
+	IpAddressOrRanges []X509IpAddressOrRange `json:"ipAddressOrRanges,omitempty"`
+}
+
+// Prefix is empty when range is not one prefix
+type X509IpAddressOrRange struct {
+	Prefix string `json:"prefix,omitempty"`
+	Min    string `json:"min"`
+	Max    string `json:"max"`
+}
+
+type X509AsIdentifiers struct {
+	AsNum *X509AsIdentifierChoice `json:"asNum,omitempty"`
+	Rdi   *X509AsIdentifierChoice `json:"rdi,omitempty"`
+}
+
+// Inherit is true when asIdentifierChoice is NULL
+type X509AsIdentifierChoice struct {
+	Inherit      bool              `json:"inherit"`
+	AsIdOrRanges []X509AsIdOrRange `json:"asIdOrRanges,omitempty"`
+}
+
+// Min equals Max when it is one asn
+type X509AsIdOrRange struct {
+	Min uint32 `json:"min"`
+	Max uint32 `json:"max"`
+}
+
+var x509KeyUsageNames = []string{"digitalSignature", "contentCommitment", "keyEncipherment",
+	"dataEncipherment", "keyAgreement", "keyCertSign", "cRLSign", "encipherOnly", "decipherOnly"}
+
+var x509ExtKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "any",
+	x509.ExtKeyUsageServerAuth:      "serverAuth",
+	x509.ExtKeyUsageClientAuth:      "clientAuth",
+	x509.ExtKeyUsageCodeSigning:     "codeSigning",
+	x509.ExtKeyUsageEmailProtection: "emailProtection",
+	x509.ExtKeyUsageTimeStamping:    "timeStamping",
+	x509.ExtKeyUsageOCSPSigning:     "OCSPSigning",
+}
+
+// GetOidName gets name of oid registered in asn1node, or oid itself when it is unknown
+func GetOidName(oid string) string {
+	if name, ok := asn1node.GetOidName(oid); ok {
+		return name
+	}
+	return oid
+}
+
+// GetX509ResultByFile is same as GetResultsByOpensslX509, certFile may be DER or PEM
+func GetX509ResultByFile(certFile string) (*X509Result, error) {
+	data, err := readDerFile(certFile)
+	if err != nil {
+		belogs.Error("GetX509ResultByFile(): readDerFile fail, certFile:", certFile, err)
+		return nil, err
+	}
+	return GetX509ResultByBytes(data)
+}
+
+// GetX509ResultByBytes: data is DER
+func GetX509ResultByBytes(data []byte) (*X509Result, error) {
+	cert, err := x509.ParseCertificate(data)
+	if err != nil {
+		belogs.Error("GetX509ResultByBytes(): ParseCertificate fail:", len(data), err)
+		return nil, errors.New("fail to parse x509 certificate: " + err.Error())
+	}
+
+	x509Result := &X509Result{
+		Version:            cert.Version,
+		SerialNumber:       cert.SerialNumber.String(),
+		SerialNumberHex:    formatHexColon(cert.SerialNumber.Bytes()),
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		Issuer:             cert.Issuer.String(),
+		Subject:            cert.Subject.String(),
+		NotBefore:          cert.NotBefore,
+		NotAfter:           cert.NotAfter,
+		PublicKeyAlgorithm: cert.PublicKeyAlgorithm.String(),
+		PublicKeyBits:      getPublicKeyBits(cert.PublicKey),
+		Extensions:         make([]X509Extension, 0, len(cert.Extensions)),
+	}
+	if cert.BasicConstraintsValid {
+		x509Result.BasicConstraints = &X509BasicConstraints{IsCa: cert.IsCA, MaxPathLen: cert.MaxPathLen}
+	}
+	for i, name := range x509KeyUsageNames {
+		if cert.KeyUsage&(1<<uint(i)) != 0 {
+			x509Result.KeyUsages = append(x509Result.KeyUsages, name)
+		}
+	}
+	for _, extKeyUsage := range cert.ExtKeyUsage {
+		x509Result.ExtKeyUsages = append(x509Result.ExtKeyUsages, x509ExtKeyUsageNames[extKeyUsage])
+	}
+	for _, oid := range cert.UnknownExtKeyUsage {
+		x509Result.ExtKeyUsages = append(x509Result.ExtKeyUsages, GetOidName(oid.String()))
+	}
+	x509Result.SubjectKeyIdentifier = formatHexColon(cert.SubjectKeyId)
+	x509Result.AuthorityKeyIdentifier = formatHexColon(cert.AuthorityKeyId)
+	x509Result.CrlDistributionPoints = cert.CRLDistributionPoints
+
+	for _, extension := range cert.Extensions {
+		oid := extension.Id.String()
+		x509Result.Extensions = append(x509Result.Extensions, X509Extension{
+			Oid:      oid,
+			Name:     GetOidName(oid),
+			Critical: extension.Critical,
+			Value:    extension.Value,
+		})
+		switch oid {
+		case "1.3.6.1.5.5.7.1.1":
+			x509Result.AuthorityInfoAccesses, err = parseX509AccessDescriptions(extension.Value)
+		case "1.3.6.1.5.5.7.1.11":
+			x509Result.SubjectInfoAccesses, err = parseX509AccessDescriptions(extension.Value)
+		case "2.5.29.32":
+			x509Result.CertificatePolicies, err = parseX509CertificatePolicies(extension.Value)
+		case "1.3.6.1.5.5.7.1.7", "1.3.6.1.5.5.7.1.28":
+			x509Result.IpAddrBlocks, err = parseX509IpAddrBlocks(extension.Value)
+		case "1.3.6.1.5.5.7.1.8", "1.3.6.1.5.5.7.1.29":
+			x509Result.AsIdentifiers, err = parseX509AsIdentifiers(extension.Value)
+		}
+		if err != nil {
+			belogs.Error("GetX509ResultByBytes(): parse extension fail, oid:", oid, err)
+			return nil, errors.New("fail to parse extension " + GetOidName(oid) + ": " + err.Error())
+		}
+	}
+	belogs.Debug("GetX509ResultByBytes(): subject:", x509Result.Subject, "  len(Extensions):", len(x509Result.Extensions))
+	return x509Result, nil
+}
+
+// readDerFile reads file, and decodes it when it is PEM
+func readDerFile(fileName string) ([]byte, error) {
+	if err := validateCertFile(fileName); err != nil {
+		return nil, errors.New("invalid file: " + err.Error())
+	}
+	data, err := fileutil.ReadFileToBytes(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if p, _ := pem.Decode(data); p != nil {
+		return p.Bytes, nil
+	}
+	return data, nil
+}
+
+// AB:CD:EF, same as openssl
+func formatHexColon(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	s := strings.ToUpper(hex.EncodeToString(b))
+	var sb strings.Builder
+	for i := 0; i < len(s); i += 2 {
+		if i > 0 {
+			sb.WriteString(":")
+		}
+		sb.WriteString(s[i : i+2])
+	}
+	return sb.String()
+}
+
+func getPublicKeyBits(publicKey interface{}) int {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return key.N.BitLen()
+	case *ecdsa.PublicKey:
+		return key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return 256
+	}
+	return 0
+}
+
+// https://datatracker.ietf.org/doc/html/rfc5280#section-4.2.2.1
+func parseX509AccessDescriptions(value []byte) ([]X509AccessDescription, error) {
+	type accessDescription struct {
+		AccessMethod   asn1.ObjectIdentifier
+		AccessLocation asn1.RawValue
+	}
+	var ads []accessDescription
+	if err := unmarshalAll(value, &ads); err != nil {
+		return nil, err
+	}
+	accessDescriptions := make([]X509AccessDescription, 0, len(ads))
+	for _, ad := range ads {
+		accessDescription := X509AccessDescription{
+			Method:    GetOidName(ad.AccessMethod.String()),
+			MethodOid: ad.AccessMethod.String(),
+		}
+		// uniformResourceIdentifier [6] IA5String
+		if ad.AccessLocation.Class == asn1.ClassContextSpecific && ad.AccessLocation.Tag == 6 {
+			accessDescription.Location = string(ad.AccessLocation.Bytes)
+		} else {
+			accessDescription.Location = hex.EncodeToString(ad.AccessLocation.FullBytes)
+		}
+		accessDescriptions = append(accessDescriptions, accessDescription)
+	}
+	return accessDescriptions, nil
+}
+
+// https://datatracker.ietf.org/doc/html/rfc5280#section-4.2.1.4
+func parseX509CertificatePolicies(value []byte) ([]X509CertificatePolicy, error) {
+	type policyQualifierInfo struct {
+		PolicyQualifierId asn1.ObjectIdentifier
+		Qualifier         asn1.RawValue
+	}
+	type policyInformation struct {
+		PolicyIdentifier asn1.ObjectIdentifier
+		PolicyQualifiers []policyQualifierInfo `asn1:"optional"`
+	}
+	var pis []policyInformation
+	if err := unmarshalAll(value, &pis); err != nil {
+		return nil, err
+	}
+	policies := make([]X509CertificatePolicy, 0, len(pis))
+	for _, pi := range pis {
+		policy := X509CertificatePolicy{Oid: pi.PolicyIdentifier.String()}
+		for _, pq := range pi.PolicyQualifiers {
+			if pq.PolicyQualifierId.String() == "1.3.6.1.5.5.7.2.1" {
+				policy.Cpss = append(policy.Cpss, string(pq.Qualifier.Bytes))
+			}
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+// https://datatracker.ietf.org/doc/html/rfc3779#section-2.2.3
+// decoded by asn1addressasn, ranges are canonical: sorted and merged
+func parseX509IpAddrBlocks(value []byte) ([]X509IpAddrBlock, error) {
+	ips, err := asn1addressasn.DecodeIPAddressBlock(value)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if ipNet, ok := ip.(*asn1addressasn.IPNet); ok && ipNet.IPNet == nil {
+			return nil, errors.New("afi is not supported")
+		}
+	}
+	ipResourceSet, err := asn1addressasn.NewIPResourceSet(ips)
+	if err != nil {
+		return nil, err
+	}
+
+	ipv4Block := X509IpAddrBlock{Afi: 1, Inherit: ipResourceSet.InheritIpv4}
+	ipv6Block := X509IpAddrBlock{Afi: 2, Inherit: ipResourceSet.InheritIpv6}
+	for _, r := range ipResourceSet.Ranges {
+		x509IpAddressOrRange := X509IpAddressOrRange{Min: r.Min.String(), Max: r.Max.String()}
+		if prefixes := r.Prefixes(); len(prefixes) == 1 {
+			x509IpAddressOrRange.Prefix = prefixes[0].String()
+		}
+		if r.Min.Is4() {
+			ipv4Block.IpAddressOrRanges = append(ipv4Block.IpAddressOrRanges, x509IpAddressOrRange)
+		} else {
+			ipv6Block.IpAddressOrRanges = append(ipv6Block.IpAddressOrRanges, x509IpAddressOrRange)
+		}
+	}
+	ipAddrBlocks := make([]X509IpAddrBlock, 0, 2)
+	for _, ipAddrBlock := range []X509IpAddrBlock{ipv4Block, ipv6Block} {
+		if ipAddrBlock.Inherit || len(ipAddrBlock.IpAddressOrRanges) > 0 {
+			ipAddrBlocks = append(ipAddrBlocks, ipAddrBlock)
+		}
+	}
+	return ipAddrBlocks, nil
+}
+
+// https://datatracker.ietf.org/doc/html/rfc3779#section-3.2.3
+// decoded by asn1addressasn, asIdOrRanges are canonical: sorted and merged
+func parseX509AsIdentifiers(value []byte) (*X509AsIdentifiers, error) {
+	asNums, rdis, err := asn1addressasn.DecodeASN(value)
+	if err != nil {
+		return nil, err
+	}
+	x509AsIdentifiers := &X509AsIdentifiers{}
+	if len(asNums) > 0 {
+		if x509AsIdentifiers.AsNum, err = getX509AsIdentifierChoice(asNums); err != nil {
+			return nil, err
+		}
+	}
+	if len(rdis) > 0 {
+		if x509AsIdentifiers.Rdi, err = getX509AsIdentifierChoice(rdis); err != nil {
+			return nil, err
+		}
+	}
+	return x509AsIdentifiers, nil
+}
+
+func getX509AsIdentifierChoice(asns []asn1addressasn.ASNCertificateInformation) (*X509AsIdentifierChoice, error) {
+	asResourceSet, err := asn1addressasn.NewASResourceSet(asns)
+	if err != nil {
+		return nil, err
+	}
+	choice := &X509AsIdentifierChoice{Inherit: asResourceSet.Inherit}
+	for _, r := range asResourceSet.Ranges {
+		choice.AsIdOrRanges = append(choice.AsIdOrRanges, X509AsIdOrRange{Min: r.Min, Max: r.Max})
+	}
+	return choice, nil
+}
+
+// unmarshalAll fails when there is trailing data
+func unmarshalAll(b []byte, v interface{}) error {
+	rest, err := asn1.Unmarshal(b, v)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return errors.New("trailing data after asn1 value")
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package opensslutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cpusoft/goutil/asn1util/asn1addressasn"
+	"github.com/cpusoft/goutil/jsonutil"
+)
+
+func createNativeTestCert(t *testing.T) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ipv4, _ := net.ParseCIDR("192.0.2.0/24")
+	ipExtension, err := asn1addressasn.EncodeIPAddressBlock([]asn1addressasn.IPCertificateInformation{
+		&asn1addressasn.IPNet{IPNet: ipv4},
+		&asn1addressasn.IPAddressRange{Min: net.ParseIP("2001:db8::"), Max: net.ParseIP("2001:db8::ff")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	asnExtension, err := asn1addressasn.EncodeASN([]asn1addressasn.ASNCertificateInformation{
+		&asn1addressasn.ASN{ASN: 65001}, &asn1addressasn.ASNRange{Min: 65100, Max: 65200}},
+		[]asn1addressasn.ASNCertificateInformation{&asn1addressasn.ASNull{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	siaExtension, err := asn1addressasn.EncodeSIA([]*asn1addressasn.SIA{
+		{AccessMethod: asn1addressasn.CertRepository, GeneralName: []byte("rsync://example.com/repo/")},
+		{AccessMethod: asn1addressasn.SIAManifest, GeneralName: []byte("rsync://example.com/repo/ca.mft")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	aiaExtension, err := asn1addressasn.EncodeInfoAccess(true, "rsync://example.com/ta.cer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policyExtension, err := asn1addressasn.EncodePolicyInformation("https://example.com/cps")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(0x1234),
+		Subject:               pkix.Name{CommonName: "native test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            -1,
+		SubjectKeyId:          []byte{0x01, 0x02, 0xab},
+		CRLDistributionPoints: []string{"rsync://example.com/repo/ca.crl"},
+		ExtraExtensions:       []pkix.Extension{*ipExtension, *asnExtension, *siaExtension, *aiaExtension, *policyExtension},
+	}
+	b, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestGetX509ResultByFile(t *testing.T) {
+	b := createNativeTestCert(t)
+	tempDir := t.TempDir()
+	derFile := filepath.Join(tempDir, "ca.cer")
+	pemFile := filepath.Join(tempDir, "ca.pem")
+	os.WriteFile(derFile, b, 0644)
+	os.WriteFile(pemFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: b}), 0644)
+
+	for _, file := range []string{derFile, pemFile} {
+		x509Result, err := GetX509ResultByFile(file)
+		if err != nil {
+			t.Fatal(file, err)
+		}
+		fmt.Println(jsonutil.MarshalJson(x509Result))
+		if x509Result.Subject != "CN=native test ca" || x509Result.SerialNumber != "4660" || x509Result.SerialNumberHex != "12:34" ||
+			x509Result.PublicKeyBits != 256 || x509Result.SubjectKeyIdentifier != "01:02:AB" ||
+			!x509Result.BasicConstraints.IsCa || len(x509Result.KeyUsages) != 2 || len(x509Result.CrlDistributionPoints) != 1 {
+			t.Fatal("fields are wrong:", jsonutil.MarshalJson(x509Result))
+		}
+		if len(x509Result.SubjectInfoAccesses) != 2 || x509Result.SubjectInfoAccesses[1].Method != "rpkiManifest" ||
+			x509Result.SubjectInfoAccesses[1].Location != "rsync://example.com/repo/ca.mft" ||
+			len(x509Result.AuthorityInfoAccesses) != 1 || x509Result.AuthorityInfoAccesses[0].Method != "caIssuers" {
+			t.Fatal("sia/aia are wrong:", jsonutil.MarshalJson(x509Result))
+		}
+		if len(x509Result.CertificatePolicies) != 1 || x509Result.CertificatePolicies[0].Cpss[0] != "https://example.com/cps" {
+			t.Fatal("certificate policies are wrong:", jsonutil.MarshalJson(x509Result.CertificatePolicies))
+		}
+		ipAddrBlocks := x509Result.IpAddrBlocks
+		if len(ipAddrBlocks) != 2 || ipAddrBlocks[0].Afi != 1 || ipAddrBlocks[0].IpAddressOrRanges[0].Prefix != "192.0.2.0/24" ||
+			ipAddrBlocks[0].IpAddressOrRanges[0].Max != "192.0.2.255" || ipAddrBlocks[1].Afi != 2 ||
+			ipAddrBlocks[1].IpAddressOrRanges[0].Prefix != "2001:db8::/120" || ipAddrBlocks[1].IpAddressOrRanges[0].Max != "2001:db8::ff" {
+			t.Fatal("ip address blocks are wrong:", jsonutil.MarshalJson(ipAddrBlocks))
+		}
+		asIdentifiers := x509Result.AsIdentifiers
+		if asIdentifiers.AsNum.Inherit || len(asIdentifiers.AsNum.AsIdOrRanges) != 2 ||
+			asIdentifiers.AsNum.AsIdOrRanges[0] != (X509AsIdOrRange{Min: 65001, Max: 65001}) ||
+			asIdentifiers.AsNum.AsIdOrRanges[1] != (X509AsIdOrRange{Min: 65100, Max: 65200}) || !asIdentifiers.Rdi.Inherit {
+			t.Fatal("as identifiers are wrong:", jsonutil.MarshalJson(asIdentifiers))
+		}
+	}
+
+	invalidFile := filepath.Join(tempDir, "invalid.cer")
+	os.WriteFile(invalidFile, []byte("not a certificate"), 0644)
+	for _, file := range []string{invalidFile, filepath.Join(tempDir, "nonexist.cer"), ""} {
+		if _, err := GetX509ResultByFile(file); err == nil {
+			t.Fatal("should fail:", file)
+		}
+	}
+}
+
+func TestParseX509IpAddrBlocks(t *testing.T) {
+	// ipv4 inherit; ipv6 range by min/max bit strings: 2001::/16 - 2001:d00::/24
+	ipAddrBlocks, err := parseX509IpAddrBlocks([]byte{0x30, 0x1d,
+		0x30, 0x06, 0x04, 0x02, 0x00, 0x01, 0x05, 0x00,
+		0x30, 0x13, 0x04, 0x02, 0x00, 0x02, 0x30, 0x0d, 0x30, 0x0b,
+		0x03, 0x03, 0x00, 0x20, 0x01, 0x03, 0x04, 0x00, 0x20, 0x01, 0x0d})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(jsonutil.MarshalJson(ipAddrBlocks))
+	if !ipAddrBlocks[0].Inherit || ipAddrBlocks[1].IpAddressOrRanges[0].Prefix != "" || ipAddrBlocks[1].IpAddressOrRanges[0].Min != "2001::" ||
+		ipAddrBlocks[1].IpAddressOrRanges[0].Max != "2001:dff:ffff:ffff:ffff:ffff:ffff:ffff" {
+		t.Fatal("ip address blocks are wrong:", jsonutil.MarshalJson(ipAddrBlocks))
+	}
+}
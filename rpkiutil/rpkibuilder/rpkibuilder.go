@@ -0,0 +1,506 @@
+package rpkibuilder
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/big"
+	mathrand "math/rand/v2"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cpusoft/goutil/asn1util/asn1addressasn"
+	"github.com/cpusoft/goutil/asn1util/asn1cert"
+	"github.com/cpusoft/goutil/asn1util/asn1cms"
+	"github.com/cpusoft/goutil/base64util"
+	"github.com/cpusoft/goutil/belogs"
+	"github.com/cpusoft/goutil/talutil"
+	"github.com/cpusoft/goutil/urlutil"
+)
+
+const (
+	RPKI_BUILDER_DEFAULT_KEY_SIZE          = 2048
+	RPKI_BUILDER_DEFAULT_CERT_VALIDITY     = 365 * 24 * time.Hour
+	RPKI_BUILDER_DEFAULT_MANIFEST_VALIDITY = 24 * time.Hour
+)
+
+// RpkiBuilderConfig: zero values use defaults. Set Now, and NewKey by NewSeededKeyFunc, to get same bytes in every run
+type RpkiBuilderConfig struct {
+	// rsync uri is saved as LocalDir/host/path
+	LocalDir string `json:"localDir"`
+	// notBefore of certificates and thisUpdate of crls and manifests, default is now
+	Now              time.Time     `json:"now"`
+	CertValidity     time.Duration `json:"certValidity"`
+	ManifestValidity time.Duration `json:"manifestValidity"`
+	KeySize          int           `json:"keySize"`
+	// NewKey gets key of every certificate, default generates rsa key of KeySize
+	NewKey func() (*rsa.PrivateKey, error) `json:"-"`
+}
+
+// RpkiResources: rfc3779 resources of certificate
+type RpkiResources struct {
+	// such as 10.0.0.0/8, 2001:db8::/32, or range 10.0.0.1-10.0.0.9
+	Ips         []string `json:"ips"`
+	InheritIpv4 bool     `json:"inheritIpv4"`
+	InheritIpv6 bool     `json:"inheritIpv6"`
+	// such as 65000, or range 65000-65010
+	Asns       []string `json:"asns"`
+	InheritAsn bool     `json:"inheritAsn"`
+}
+
+// RpkiCa is TA or CA certificate with its publication point
+type RpkiCa struct {
+	// uri of .cer
+	Uri string `json:"uri"`
+	// caRepository, end with "/"
+	RepoUri   string            `json:"repoUri"`
+	CrlName   string            `json:"crlName"`
+	MftName   string            `json:"mftName"`
+	Cert      *x509.Certificate `json:"-"`
+	CertBytes []byte            `json:"-"`
+	Key       *rsa.PrivateKey   `json:"-"`
+	Parent    *RpkiCa           `json:"-"`
+
+	// published files except crl and manifest: name -> bytes
+	files          map[string][]byte
+	revoked        []x509.RevocationListEntry
+	crlNumber      int64
+	manifestNumber int64
+}
+
+// RpkiBuilder issues ta, ca, ee certificates, crls and signed objects, and writes them to LocalDir
+type RpkiBuilder struct {
+	config RpkiBuilderConfig
+	serial int64
+	// in order of creation, so parent is before child
+	cas []*RpkiCa
+}
+
+func NewRpkiBuilder(config RpkiBuilderConfig) *RpkiBuilder {
+	if config.Now.IsZero() {
+		config.Now = time.Now()
+	}
+	config.Now = config.Now.UTC().Truncate(time.Second)
+	if config.CertValidity <= 0 {
+		config.CertValidity = RPKI_BUILDER_DEFAULT_CERT_VALIDITY
+	}
+	if config.ManifestValidity <= 0 {
+		config.ManifestValidity = RPKI_BUILDER_DEFAULT_MANIFEST_VALIDITY
+	}
+	if config.KeySize <= 0 {
+		config.KeySize = RPKI_BUILDER_DEFAULT_KEY_SIZE
+	}
+	if config.NewKey == nil {
+		keySize := config.KeySize
+		config.NewKey = func() (*rsa.PrivateKey, error) {
+			return rsa.GenerateKey(rand.Reader, keySize)
+		}
+	}
+	return &RpkiBuilder{config: config}
+}
+
+// NewSeededKeyFunc gets rsa keys of keySize from seed, the same seed gets the same keys in the same order in every run.
+// rsa.GenerateKey is not used because it is random even with a seeded reader. It is only for test fixtures
+func NewSeededKeyFunc(seed [32]byte, keySize int) func() (*rsa.PrivateKey, error) {
+	r := mathrand.NewChaCha8(seed)
+	e := big.NewInt(65537)
+	one := big.NewInt(1)
+	return func() (*rsa.PrivateKey, error) {
+		if keySize < 1024 || keySize%2 != 0 {
+			return nil, errors.New("key size is invalid: " + strconv.Itoa(keySize))
+		}
+		for {
+			p, err := newSeededPrime(r, keySize/2)
+			if err != nil {
+				return nil, err
+			}
+			q, err := newSeededPrime(r, keySize/2)
+			if err != nil {
+				return nil, err
+			}
+			pMinus1 := new(big.Int).Sub(p, one)
+			qMinus1 := new(big.Int).Sub(q, one)
+			phi := new(big.Int).Mul(pMinus1, qMinus1)
+			d := new(big.Int).ModInverse(e, phi)
+			if p.Cmp(q) == 0 || d == nil {
+				continue
+			}
+			key := &rsa.PrivateKey{
+				PublicKey: rsa.PublicKey{N: new(big.Int).Mul(p, q), E: int(e.Int64())},
+				D:         d,
+				Primes:    []*big.Int{p, q},
+			}
+			if err = key.Validate(); err != nil {
+				return nil, err
+			}
+			key.Precompute()
+			return key, nil
+		}
+	}
+}
+
+// newSeededPrime reads prime of bits from r, top two bits are set so that product of two primes has 2*bits
+func newSeededPrime(r io.Reader, bits int) (*big.Int, error) {
+	b := make([]byte, (bits+7)/8)
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		// clear bits above bits, then set top two bits and the lowest bit
+		b[0] &= byte(0xFF >> (8*len(b) - bits))
+		p := new(big.Int).SetBytes(b)
+		p.SetBit(p, bits-1, 1)
+		p.SetBit(p, bits-2, 1)
+		p.SetBit(p, 0, 1)
+		if p.ProbablyPrime(20) {
+			return p, nil
+		}
+	}
+}
+
+// NewTa creates self-signed ta certificate, taUri is uri of .cer, repoUri is its publication point
+func (b *RpkiBuilder) NewTa(taUri, repoUri string, resources *RpkiResources) (*RpkiCa, error) {
+	if resources.InheritIpv4 || resources.InheritIpv6 || resources.InheritAsn {
+		return nil, errors.New("resources of ta cannot be inherit")
+	}
+	return b.newCa(nil, taUri, repoUri, resources)
+}
+
+// NewCa creates ca certificate issued by parent, name is file name in publication point of parent, such as ca.cer
+func (b *RpkiBuilder) NewCa(parent *RpkiCa, name, repoUri string, resources *RpkiResources) (*RpkiCa, error) {
+	if parent == nil {
+		return nil, errors.New("parent is nil")
+	}
+	ca, err := b.newCa(parent, parent.RepoUri+name, repoUri, resources)
+	if err != nil {
+		return nil, err
+	}
+	parent.files[name] = ca.CertBytes
+	return ca, nil
+}
+
+func (b *RpkiBuilder) newCa(parent *RpkiCa, uri, repoUri string, resources *RpkiResources) (*RpkiCa, error) {
+	if !strings.HasSuffix(repoUri, "/") {
+		repoUri += "/"
+	}
+	key, err := b.config.NewKey()
+	if err != nil {
+		belogs.Error("RpkiBuilder.newCa(): NewKey fail:", uri, err)
+		return nil, err
+	}
+	baseName := strings.TrimSuffix(filepath.Base(uri), filepath.Ext(uri))
+	ca := &RpkiCa{
+		Uri:     uri,
+		RepoUri: repoUri,
+		CrlName: baseName + ".crl",
+		MftName: baseName + ".mft",
+		Key:     key,
+		Parent:  parent,
+		files:   make(map[string][]byte),
+	}
+	sia, err := asn1addressasn.EncodeSIA([]*asn1addressasn.SIA{
+		{AccessMethod: asn1addressasn.CertRepository, GeneralName: []byte(repoUri)},
+		{AccessMethod: asn1addressasn.SIAManifest, GeneralName: []byte(repoUri + ca.MftName)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ca.Cert, ca.CertBytes, err = b.newCert(parent, key, true, resources, []pkix.Extension{*sia})
+	if err != nil {
+		belogs.Error("RpkiBuilder.newCa(): newCert fail:", uri, err)
+		return nil, err
+	}
+	b.cas = append(b.cas, ca)
+	belogs.Debug("RpkiBuilder.newCa(): uri:", uri, "  repoUri:", repoUri, "  serial:", ca.Cert.SerialNumber)
+	return ca, nil
+}
+
+// NewEeCert creates ee certificate of signed object, uri is its signedObject in sia
+func (b *RpkiBuilder) NewEeCert(parent *RpkiCa, uri string, resources *RpkiResources) (*x509.Certificate, []byte, *rsa.PrivateKey, error) {
+	key, err := b.config.NewKey()
+	if err != nil {
+		belogs.Error("RpkiBuilder.NewEeCert(): NewKey fail:", uri, err)
+		return nil, nil, nil, err
+	}
+	sia, err := asn1addressasn.EncodeSIA([]*asn1addressasn.SIA{
+		{AccessMethod: asn1addressasn.SignedObject, GeneralName: []byte(uri)},
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cert, certBytes, err := b.newCert(parent, key, false, resources, []pkix.Extension{*sia})
+	if err != nil {
+		belogs.Error("RpkiBuilder.NewEeCert(): newCert fail:", uri, err)
+		return nil, nil, nil, err
+	}
+	return cert, certBytes, key, nil
+}
+
+// newCert is profile of rfc6487, parent is nil for ta
+func (b *RpkiBuilder) newCert(parent *RpkiCa, key *rsa.PrivateKey, isCa bool, resources *RpkiResources,
+	extensions []pkix.Extension) (*x509.Certificate, []byte, error) {
+	b.serial++
+	ski := sha1.Sum(x509.MarshalPKCS1PublicKey(&key.PublicKey))
+	template := &x509.Certificate{
+		SerialNumber:       big.NewInt(b.serial),
+		Subject:            pkix.Name{CommonName: strings.ToUpper(hex.EncodeToString(ski[:]))},
+		NotBefore:          b.config.Now,
+		NotAfter:           b.config.Now.Add(b.config.CertValidity),
+		SubjectKeyId:       ski[:],
+		KeyUsage:           x509.KeyUsageDigitalSignature,
+		SignatureAlgorithm: x509.SHA256WithRSA,
+	}
+	if isCa {
+		template.BasicConstraintsValid = true
+		template.IsCA = true
+		template.MaxPathLen = -1
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	}
+	policy, err := asn1addressasn.EncodePolicyInformation("")
+	if err != nil {
+		return nil, nil, err
+	}
+	resourceExtensions, err := resources.toExtensions()
+	if err != nil {
+		return nil, nil, err
+	}
+	template.ExtraExtensions = append(append(extensions, *policy), resourceExtensions...)
+
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.Cert, parent.Key
+		template.CRLDistributionPoints = []string{parent.RepoUri + parent.CrlName}
+		aia, err := asn1addressasn.EncodeInfoAccess(true, parent.Uri)
+		if err != nil {
+			return nil, nil, err
+		}
+		template.ExtraExtensions = append(template.ExtraExtensions, *aia)
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, certBytes, nil
+}
+
+// AddFile publishes file in publication point of ca, such as .roa/.asa/.cer, or any other file
+func (b *RpkiBuilder) AddFile(ca *RpkiCa, name string, fileBytes []byte) {
+	ca.files[name] = fileBytes
+}
+
+// RemoveFile removes file from publication point of ca, so it is not in manifest
+func (b *RpkiBuilder) RemoveFile(ca *RpkiCa, name string) {
+	delete(ca.files, name)
+}
+
+// Revoke adds cert issued by ca to crl of ca
+func (b *RpkiBuilder) Revoke(ca *RpkiCa, cert *x509.Certificate) {
+	ca.revoked = append(ca.revoked, x509.RevocationListEntry{
+		SerialNumber:   cert.SerialNumber,
+		RevocationTime: b.config.Now,
+	})
+}
+
+// NewCrl creates next crl of ca, crlNumber is increased
+func (b *RpkiBuilder) NewCrl(ca *RpkiCa) ([]byte, error) {
+	ca.crlNumber++
+	crlBytes, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(ca.crlNumber),
+		ThisUpdate:                b.config.Now,
+		NextUpdate:                b.config.Now.Add(b.config.ManifestValidity),
+		RevokedCertificateEntries: ca.revoked,
+		SignatureAlgorithm:        x509.SHA256WithRSA,
+	}, ca.Cert, ca.Key)
+	if err != nil {
+		belogs.Error("RpkiBuilder.NewCrl(): CreateRevocationList fail:", ca.Uri, err)
+		return nil, err
+	}
+	return crlBytes, nil
+}
+
+// NewManifest creates next manifest of ca with files, manifestNumber is increased
+func (b *RpkiBuilder) NewManifest(ca *RpkiCa, files map[string][]byte) ([]byte, error) {
+	ca.manifestNumber++
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fileAndHashs := make([]asn1cert.FileAndHash, 0, len(files))
+	for _, name := range names {
+		hash := sha256.Sum256(files[name])
+		fileAndHashs = append(fileAndHashs, asn1cert.FileAndHash{File: name, Hash: hash[:]})
+	}
+	eContent, err := asn1cms.EncodeManifestContent(big.NewInt(ca.manifestNumber), b.config.Now,
+		b.config.Now.Add(b.config.ManifestValidity), fileAndHashs)
+	if err != nil {
+		belogs.Error("RpkiBuilder.NewManifest(): EncodeManifestContent fail:", ca.MftName, err)
+		return nil, err
+	}
+	mftBytes, _, err := b.NewSignedObject(ca, ca.MftName, asn1cms.ManifestOid, eContent,
+		&RpkiResources{InheritIpv4: true, InheritIpv6: true, InheritAsn: true})
+	return mftBytes, err
+}
+
+// WriteRepo writes ta certificate, and files, crl and manifest of every ca to LocalDir.
+// crl and manifest are created again in every call
+func (b *RpkiBuilder) WriteRepo() error {
+	for _, ca := range b.cas {
+		if ca.Parent == nil {
+			if err := b.writeFile(ca.Uri, ca.CertBytes); err != nil {
+				return err
+			}
+		}
+		crlBytes, err := b.NewCrl(ca)
+		if err != nil {
+			return err
+		}
+		files := make(map[string][]byte, len(ca.files)+1)
+		for name, fileBytes := range ca.files {
+			files[name] = fileBytes
+		}
+		files[ca.CrlName] = crlBytes
+		mftBytes, err := b.NewManifest(ca, files)
+		if err != nil {
+			return err
+		}
+		files[ca.MftName] = mftBytes
+		for name, fileBytes := range files {
+			if err = b.writeFile(ca.RepoUri+name, fileBytes); err != nil {
+				return err
+			}
+		}
+		belogs.Debug("RpkiBuilder.WriteRepo(): repoUri:", ca.RepoUri, "  len(files):", len(files))
+	}
+	return nil
+}
+
+// WriteTal writes tal of ta to talFile
+func (b *RpkiBuilder) WriteTal(ta *RpkiCa, talFile string) error {
+	talInfo := talutil.TalInfo{
+		Uris:   []string{ta.Uri},
+		PubKey: base64util.EncodeBase64(ta.Cert.RawSubjectPublicKeyInfo),
+	}
+	return talutil.WriteTalInfoToFile(&talInfo, talFile)
+}
+
+// GetLocalFile gets local file of uri in LocalDir
+func (b *RpkiBuilder) GetLocalFile(uri string) (string, error) {
+	return urlutil.JoinPrefixPathAndUrlFileName(b.config.LocalDir, uri)
+}
+
+func (b *RpkiBuilder) writeFile(uri string, fileBytes []byte) error {
+	file, err := b.GetLocalFile(uri)
+	if err != nil {
+		belogs.Error("RpkiBuilder.writeFile(): GetLocalFile fail:", uri, err)
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		belogs.Error("RpkiBuilder.writeFile(): MkdirAll fail:", file, err)
+		return err
+	}
+	return os.WriteFile(file, fileBytes, 0644)
+}
+
+// toExtensions gets ip and as extensions, extension is absent when there is no resource of it
+func (r *RpkiResources) toExtensions() ([]pkix.Extension, error) {
+	extensions := make([]pkix.Extension, 0, 2)
+	if r == nil {
+		return extensions, nil
+	}
+	ips := make([]asn1addressasn.IPCertificateInformation, 0, len(r.Ips)+2)
+	for _, ip := range r.Ips {
+		ipInformation, err := parseIp(ip)
+		if err != nil {
+			return nil, err
+		}
+		if (ipInformation.GetAfi() == 1 && r.InheritIpv4) || (ipInformation.GetAfi() == 2 && r.InheritIpv6) {
+			return nil, errors.New("ip cannot be with inherit of same family: " + ip)
+		}
+		ips = append(ips, ipInformation)
+	}
+	if r.InheritIpv4 {
+		ips = append(ips, &asn1addressasn.IPAddressNull{Family: 1})
+	}
+	if r.InheritIpv6 {
+		ips = append(ips, &asn1addressasn.IPAddressNull{Family: 2})
+	}
+	if len(ips) > 0 {
+		extension, err := asn1addressasn.EncodeIPAddressBlock(ips)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, *extension)
+	}
+
+	asns := make([]asn1addressasn.ASNCertificateInformation, 0, len(r.Asns))
+	if r.InheritAsn {
+		if len(r.Asns) > 0 {
+			return nil, errors.New("asns cannot be with inherit")
+		}
+		asns = append(asns, &asn1addressasn.ASNull{})
+	}
+	for _, asn := range r.Asns {
+		asnInformation, err := parseAsn(asn)
+		if err != nil {
+			return nil, err
+		}
+		asns = append(asns, asnInformation)
+	}
+	if len(asns) > 0 {
+		extension, err := asn1addressasn.EncodeASN(asns, nil)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, *extension)
+	}
+	return extensions, nil
+}
+
+// 10.0.0.0/8 or 10.0.0.1-10.0.0.9
+func parseIp(ip string) (asn1addressasn.IPCertificateInformation, error) {
+	if min, max, ok := strings.Cut(ip, "-"); ok {
+		minIp, maxIp := net.ParseIP(strings.TrimSpace(min)), net.ParseIP(strings.TrimSpace(max))
+		if minIp == nil || maxIp == nil || (minIp.To4() == nil) != (maxIp.To4() == nil) {
+			return nil, errors.New("ip range is invalid: " + ip)
+		}
+		return &asn1addressasn.IPAddressRange{Min: minIp, Max: maxIp}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(strings.TrimSpace(ip))
+	if err != nil {
+		return nil, errors.New("ip prefix is invalid: " + ip)
+	}
+	return &asn1addressasn.IPNet{IPNet: ipNet}, nil
+}
+
+// 65000 or 65000-65010
+func parseAsn(asn string) (asn1addressasn.ASNCertificateInformation, error) {
+	if min, max, ok := strings.Cut(asn, "-"); ok {
+		minAsn, err1 := strconv.ParseUint(strings.TrimSpace(min), 10, 32)
+		maxAsn, err2 := strconv.ParseUint(strings.TrimSpace(max), 10, 32)
+		if err1 != nil || err2 != nil || minAsn > maxAsn {
+			return nil, errors.New("asn range is invalid: " + asn)
+		}
+		return &asn1addressasn.ASNRange{Min: int(minAsn), Max: int(maxAsn)}, nil
+	}
+	a, err := strconv.ParseUint(strings.TrimSpace(asn), 10, 32)
+	if err != nil {
+		return nil, errors.New("asn is invalid: " + asn)
+	}
+	return &asn1addressasn.ASN{ASN: int(a)}, nil
+}
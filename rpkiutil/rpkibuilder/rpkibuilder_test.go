@@ -0,0 +1,178 @@
+package rpkibuilder
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cpusoft/goutil/asn1util/asn1cms"
+	"github.com/cpusoft/goutil/iputil"
+	"github.com/cpusoft/goutil/jsonutil"
+	"github.com/cpusoft/goutil/rpkiutil"
+)
+
+// testKeySeed makes keys, certificates and signed objects same in every run
+var testKeySeed = [32]byte{'r', 'p', 'k', 'i', 'b', 'u', 'i', 'l', 'd', 'e', 'r'}
+
+func newTestBuilder(t *testing.T) *RpkiBuilder {
+	return NewRpkiBuilder(RpkiBuilderConfig{
+		LocalDir: t.TempDir(),
+		Now:      time.Now().Add(-time.Hour),
+		NewKey:   NewSeededKeyFunc(testKeySeed, 1024),
+	})
+}
+
+func TestRpkiBuilder(t *testing.T) {
+	b := newTestBuilder(t)
+	ta, err := b.NewTa("rsync://example.com/ta.cer", "rsync://example.com/repo/ta/",
+		&RpkiResources{Ips: []string{"10.0.0.0/8", "2001:db8::/32"}, Asns: []string{"65000-65010"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := b.NewCa(ta, "ca.cer", "rsync://example.com/repo/ca",
+		&RpkiResources{Ips: []string{"10.1.0.0/16", "10.2.0.1-10.2.0.9"}, InheritIpv6: true, InheritAsn: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca.RepoUri != "rsync://example.com/repo/ca/" || ca.MftName != "ca.mft" || ca.Cert.Issuer.String() != ta.Cert.Subject.String() {
+		t.Fatal("ca is wrong:", jsonutil.MarshalJson(ca))
+	}
+	if _, _, err = b.NewRoa(ca, "good.roa", 65001, []RpkiRoaPrefix{{Prefix: "10.1.1.0/24", MaxLength: 24}, {Prefix: "2001:db8:1::/48"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	_, revokedEe, err := b.NewRoa(ca, "revoked.roa", 65002, []RpkiRoaPrefix{{Prefix: "10.1.2.0/24"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Revoke(ca, revokedEe)
+	if _, _, err = b.NewAspa(ca, "customer.asa", 65003, []uint32{65004, 65005}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.WriteRepo(); err != nil {
+		t.Fatal(err)
+	}
+	talFile := filepath.Join(b.config.LocalDir, "test.tal")
+	if err = b.WriteTal(ta, talFile); err != nil {
+		t.Fatal(err)
+	}
+
+	mftFile, _ := b.GetLocalFile(ca.RepoUri + ca.MftName)
+	mftBytes, err := os.ReadFile(mftFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mft, err := asn1cms.ParseManifest(mftBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(jsonutil.MarshalJson(mft))
+
+	result, err := rpkiutil.ValidateTalFile(talFile, &rpkiutil.RpkiValidatorConfig{LocalDir: b.config.LocalDir, StrictDer: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(jsonutil.MarshalJsonIndent(result))
+	if result.CaCount != 2 || result.MalformedCount != 0 || result.RoaCount != 1 || result.AspaCount != 1 || len(result.Vrps) != 2 ||
+		len(result.Warnings) != 1 || result.Warnings[0].Uri != ca.RepoUri+"revoked.roa" {
+		t.Fatal("validation result is wrong:", jsonutil.MarshalJson(result))
+	}
+	rov, err := result.GetRouteOriginValidator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := rov.Validate(netip.MustParsePrefix("10.1.1.0/24"), 65001); state.State != iputil.ROUTE_ORIGIN_VALID {
+		t.Fatal("route should be valid:", jsonutil.MarshalJson(state))
+	}
+	if state, _ := rov.Validate(netip.MustParsePrefix("10.1.1.0/24"), 65002); state.State != iputil.ROUTE_ORIGIN_INVALID {
+		t.Fatal("route should be invalid:", jsonutil.MarshalJson(state))
+	}
+
+	// crl and manifest are created again with next number
+	b.RemoveFile(ca, "customer.asa")
+	if err = b.WriteRepo(); err != nil {
+		t.Fatal(err)
+	}
+	if result, err = rpkiutil.ValidateTalFile(talFile, &rpkiutil.RpkiValidatorConfig{LocalDir: b.config.LocalDir}); err != nil {
+		t.Fatal(err)
+	}
+	if ca.manifestNumber != 2 || ca.crlNumber != 2 || result.AspaCount != 0 || result.RoaCount != 1 {
+		t.Fatal("second write is wrong:", jsonutil.MarshalJson(result))
+	}
+}
+
+func TestRpkiResources(t *testing.T) {
+	b := newTestBuilder(t)
+	if _, err := b.NewTa("rsync://example.com/ta.cer", "rsync://example.com/repo/ta/",
+		&RpkiResources{InheritAsn: true}); err == nil {
+		t.Fatal("ta with inherit should fail")
+	}
+	for _, resources := range []*RpkiResources{
+		{Ips: []string{"10.0.0.0/33"}},
+		{Ips: []string{"10.0.0.9-2001:db8::"}},
+		{Ips: []string{"10.0.0.0/8"}, InheritIpv4: true},
+		{Asns: []string{"65010-65000"}},
+		{Asns: []string{"AS65000"}},
+		{Asns: []string{"65000"}, InheritAsn: true},
+	} {
+		if _, err := resources.toExtensions(); err == nil {
+			t.Fatal("should fail:", jsonutil.MarshalJson(resources))
+		}
+	}
+	extensions, err := (&RpkiResources{Ips: []string{"2001:db8::/32"}, InheritIpv4: true, Asns: []string{"65000"}}).toExtensions()
+	if err != nil || len(extensions) != 2 {
+		t.Fatal("extensions are wrong:", err)
+	}
+
+	// same config gets same bytes
+	taBytes := make([][]byte, 0, 2)
+	for i := 0; i < 2; i++ {
+		b = newTestBuilder(t)
+		b.config.Now = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		ta, err := b.NewTa("rsync://example.com/ta.cer", "rsync://example.com/repo/ta/", &RpkiResources{Asns: []string{"65000"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		taBytes = append(taBytes, ta.CertBytes)
+	}
+	if !bytes.Equal(taBytes[0], taBytes[1]) {
+		t.Fatal("ta should be same")
+	}
+}
+
+func TestNewSeededKeyFunc(t *testing.T) {
+	newKey := NewSeededKeyFunc(testKeySeed, 1024)
+	key1, err := newKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key2, err := newKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(key1.N.BitLen(), key2.N.BitLen())
+	if key1.N.BitLen() != 1024 || key1.N.Cmp(key2.N) == 0 {
+		t.Fatal("keys are wrong")
+	}
+	// same seed gets same keys in every run
+	hash := sha256.Sum256(key1.N.Bytes())
+	if hex.EncodeToString(hash[:]) != "13153a06c2c78691c760aa135f9e2b5f8624c85f34d4368a11223e5ba332b729" {
+		t.Fatal("key of seed is changed:", hex.EncodeToString(hash[:]))
+	}
+	key, err := NewSeededKeyFunc(testKeySeed, 1024)()
+	if err != nil || !key.Equal(key1) {
+		t.Fatal("same seed should get same key:", err)
+	}
+	key, err = NewSeededKeyFunc([32]byte{1}, 1024)()
+	if err != nil || key.Equal(key1) {
+		t.Fatal("other seed should get other key:", err)
+	}
+	if _, err = NewSeededKeyFunc(testKeySeed, 512)(); err == nil {
+		t.Fatal("should fail for small key size")
+	}
+}
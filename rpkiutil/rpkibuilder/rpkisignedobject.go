@@ -0,0 +1,88 @@
+package rpkibuilder
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"net"
+	"strconv"
+
+	"github.com/cpusoft/goutil/asn1util/asn1cert"
+	"github.com/cpusoft/goutil/asn1util/asn1cms"
+	"github.com/cpusoft/goutil/belogs"
+)
+
+// RpkiRoaPrefix is prefix in roa, MaxLength is omitted when it is 0
+type RpkiRoaPrefix struct {
+	Prefix    string `json:"prefix"`
+	MaxLength int    `json:"maxLength"`
+}
+
+// NewSignedObject creates cms signed object of rfc6488 with new ee certificate, and publishes it as name in ca.
+// eeResources is resources of ee certificate
+func (b *RpkiBuilder) NewSignedObject(ca *RpkiCa, name string, eContentType asn1.ObjectIdentifier, eContent []byte,
+	eeResources *RpkiResources) ([]byte, *x509.Certificate, error) {
+	eeCert, eeBytes, eeKey, err := b.NewEeCert(ca, ca.RepoUri+name, eeResources)
+	if err != nil {
+		return nil, nil, err
+	}
+	signedObject, err := asn1cms.EncodeSignedObject(eContentType, eContent, eeBytes, eeKey, b.config.Now)
+	if err != nil {
+		belogs.Error("RpkiBuilder.NewSignedObject(): EncodeSignedObject fail:", name, err)
+		return nil, nil, err
+	}
+	// manifest is published by WriteRepo
+	if name != ca.MftName {
+		ca.files[name] = signedObject
+	}
+	return signedObject, eeCert, nil
+}
+
+// NewRoa creates roa of rfc9582, eeResources is nil means ee certificate has just prefixes of roa
+func (b *RpkiBuilder) NewRoa(ca *RpkiCa, name string, asn uint32, prefixes []RpkiRoaPrefix,
+	eeResources *RpkiResources) ([]byte, *x509.Certificate, error) {
+	roaIpAddresses := make([]asn1cms.RoaIpAddress, 0, len(prefixes))
+	ips := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		_, ipNet, err := net.ParseCIDR(prefix.Prefix)
+		if err != nil {
+			return nil, nil, errors.New("roa prefix is invalid: " + prefix.Prefix)
+		}
+		maxLength := prefix.MaxLength
+		if maxLength == 0 {
+			maxLength = -1
+		}
+		roaIpAddresses = append(roaIpAddresses, asn1cms.RoaIpAddress{
+			IpAddrBlock: asn1cert.IpAddrBlock{AddressPrefix: ipNet.String()},
+			MaxLength:   maxLength,
+		})
+		ips = append(ips, ipNet.String())
+	}
+	eContent, err := asn1cms.EncodeRoaContent(int64(asn), roaIpAddresses)
+	if err != nil {
+		belogs.Error("RpkiBuilder.NewRoa(): EncodeRoaContent fail:", name, err)
+		return nil, nil, err
+	}
+	if eeResources == nil {
+		eeResources = &RpkiResources{Ips: ips}
+	}
+	return b.NewSignedObject(ca, name, asn1cms.RoaOid, eContent, eeResources)
+}
+
+// NewAspa creates aspa of version 1, eeResources is nil means ee certificate has just customer asn
+func (b *RpkiBuilder) NewAspa(ca *RpkiCa, name string, customerAsn uint32, providerAsns []uint32,
+	eeResources *RpkiResources) ([]byte, *x509.Certificate, error) {
+	providerAsIds := make([]int64, 0, len(providerAsns))
+	for _, providerAsn := range providerAsns {
+		providerAsIds = append(providerAsIds, int64(providerAsn))
+	}
+	eContent, err := asn1cms.EncodeAspaContent(int64(customerAsn), providerAsIds)
+	if err != nil {
+		belogs.Error("RpkiBuilder.NewAspa(): EncodeAspaContent fail:", name, err)
+		return nil, nil, err
+	}
+	if eeResources == nil {
+		eeResources = &RpkiResources{Asns: []string{strconv.FormatUint(uint64(customerAsn), 10)}}
+	}
+	return b.NewSignedObject(ca, name, asn1cms.AspaOid, eContent, eeResources)
+}
@@ -0,0 +1,539 @@
+package rpkiutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"math"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cpusoft/goutil/asn1util/asn1addressasn"
+	"github.com/cpusoft/goutil/asn1util/asn1base"
+	"github.com/cpusoft/goutil/asn1util/asn1cms"
+	"github.com/cpusoft/goutil/belogs"
+	"github.com/cpusoft/goutil/iputil"
+	"github.com/cpusoft/goutil/jsonutil"
+	"github.com/cpusoft/goutil/talutil"
+	"github.com/cpusoft/goutil/urlutil"
+)
+
+const (
+	// max depth of CA certificates under TA
+	RPKI_VALIDATOR_MAX_DEPTH = 32
+)
+
+// RpkiValidatorConfig: files are in LocalDir/host/path of their rsync uris,
+// as saved by rsyncutil.RsyncNativeWithConfig or rrdputil
+type RpkiValidatorConfig struct {
+	LocalDir string `json:"localDir"`
+	// zero is time.Now()
+	ValidationTime time.Time `json:"validationTime"`
+	// 0 is RPKI_VALIDATOR_MAX_DEPTH
+	MaxDepth int `json:"maxDepth"`
+	// nil: TA certificate is read from LocalDir
+	TaCertFetchFunc talutil.TaCertFetchFunc `json:"-"`
+	// true: objects which are not strict DER are malformed and ignored
+	StrictDer bool `json:"strictDer"`
+}
+
+// Vrp is Validated ROA Payload
+type Vrp struct {
+	Prefix    string `json:"prefix"`
+	MaxLength int    `json:"maxLength"`
+	Asn       int64  `json:"asn"`
+	Ta        string `json:"ta"`
+	// uri of roa
+	Uri string `json:"uri"`
+}
+
+// Vap is Validated ASPA Payload
+type Vap struct {
+	CustomerAsn  int64   `json:"customerAsn"`
+	ProviderAsns []int64 `json:"providerAsns"`
+	Ta           string  `json:"ta"`
+	// uri of asa
+	Uri string `json:"uri"`
+}
+
+// ValidationWarning: the object of uri is ignored, or something is not as RFC
+type ValidationWarning struct {
+	Uri     string `json:"uri"`
+	Message string `json:"message"`
+}
+
+// ValidationResult is the result of one TAL
+type ValidationResult struct {
+	Ta        string    `json:"ta"`
+	TaUri     string    `json:"taUri"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+
+	CaCount       uint64 `json:"caCount"`
+	ManifestCount uint64 `json:"manifestCount"`
+	CrlCount      uint64 `json:"crlCount"`
+	RoaCount      uint64 `json:"roaCount"`
+	AspaCount     uint64 `json:"aspaCount"`
+	// objects which are not strict DER, only when StrictDer
+	MalformedCount uint64 `json:"malformedCount"`
+
+	Vrps     []Vrp               `json:"vrps"`
+	Vaps     []Vap               `json:"vaps"`
+	Warnings []ValidationWarning `json:"warnings"`
+}
+
+// GetRouteOriginValidator gets validator of RFC 6811 by Vrps
+func (r *ValidationResult) GetRouteOriginValidator() (*iputil.RouteOriginValidator, error) {
+	roaVrps := make([]iputil.RoaVrp, 0, len(r.Vrps))
+	for i := range r.Vrps {
+		prefix, err := netip.ParsePrefix(r.Vrps[i].Prefix)
+		if err != nil {
+			return nil, err
+		}
+		if r.Vrps[i].Asn < 0 || r.Vrps[i].Asn > math.MaxUint32 {
+			return nil, errors.New("asn of vrp is invalid: " + strconv.FormatInt(r.Vrps[i].Asn, 10))
+		}
+		roaVrps = append(roaVrps, iputil.RoaVrp{Prefix: prefix, MaxLength: r.Vrps[i].MaxLength, Asn: uint32(r.Vrps[i].Asn)})
+	}
+	return iputil.NewRouteOriginValidator(roaVrps)
+}
+
+// validatedCa is CA certificate which has been validated, with its effective resources (inherit is resolved)
+type validatedCa struct {
+	uri   string
+	cert  *asn1addressasn.RPKICertificate
+	ips   *asn1addressasn.IPResourceSet
+	asns  *asn1addressasn.ASResourceSet
+	depth int
+}
+
+type rpkiValidator struct {
+	config   *RpkiValidatorConfig
+	now      time.Time
+	maxDepth int
+	result   *ValidationResult
+	// ski of CA, to avoid loop
+	visited map[string]bool
+}
+
+// ValidateTalFile validates by tal file, ta is file name without ".tal"
+func ValidateTalFile(talFile string, config *RpkiValidatorConfig) (*ValidationResult, error) {
+	talInfo, err := talutil.ParseTalInfoFile(talFile)
+	if err != nil {
+		belogs.Error("ValidateTalFile(): ParseTalInfoFile fail:", talFile, err)
+		return nil, err
+	}
+	ta := strings.TrimSuffix(filepath.Base(talFile), ".tal")
+	return ValidateTal(ta, &talInfo, config)
+}
+
+// ValidateTal gets TA certificate of talInfo, then walks CA certificates by manifests.
+// error is returned only when TA certificate is invalid, others are in Warnings
+func ValidateTal(ta string, talInfo *talutil.TalInfo, config *RpkiValidatorConfig) (*ValidationResult, error) {
+	belogs.Debug("ValidateTal(): ta:", ta, "  talInfo:", jsonutil.MarshalJson(talInfo), "  config:", jsonutil.MarshalJson(config))
+	v := &rpkiValidator{
+		config:   config,
+		now:      config.ValidationTime,
+		maxDepth: config.MaxDepth,
+		result: &ValidationResult{
+			Ta:        ta,
+			StartTime: time.Now(),
+			Vrps:      make([]Vrp, 0),
+			Vaps:      make([]Vap, 0),
+			Warnings:  make([]ValidationWarning, 0),
+		},
+		visited: make(map[string]bool),
+	}
+	if v.now.IsZero() {
+		v.now = time.Now()
+	}
+	if v.maxDepth <= 0 {
+		v.maxDepth = RPKI_VALIDATOR_MAX_DEPTH
+	}
+
+	fetch := config.TaCertFetchFunc
+	if fetch == nil {
+		fetch = v.readLocalFile
+	}
+	_, certBytes, taUri, err := talutil.FetchTaCert(talInfo, fetch, v.now)
+	if err != nil {
+		belogs.Error("ValidateTal(): FetchTaCert fail:", ta, err)
+		return nil, err
+	}
+	taCert, err := asn1addressasn.DecodeCertificate(certBytes)
+	if err != nil {
+		belogs.Error("ValidateTal(): DecodeCertificate fail:", ta, taUri, err)
+		return nil, errors.New("TA certificate is invalid: " + err.Error())
+	}
+	taIps, taAsns, err := getResourceSets(taCert)
+	if err != nil {
+		return nil, errors.New("resources of TA certificate are invalid: " + err.Error())
+	}
+	if taIps.InheritIpv4 || taIps.InheritIpv6 || taAsns.Inherit {
+		return nil, errors.New("TA certificate should not use inherit")
+	}
+	v.result.TaUri = taUri
+	v.result.CaCount++
+	v.visited[hex.EncodeToString(taCert.Certificate.SubjectKeyId)] = true
+	v.walkCa(&validatedCa{
+		uri:  taUri,
+		cert: taCert,
+		ips:  taIps,
+		asns: taAsns,
+	})
+	v.result.EndTime = time.Now()
+	belogs.Info("ValidateTal(): ta:", ta, "  caCount:", v.result.CaCount, "  roaCount:", v.result.RoaCount,
+		"  len(vrps):", len(v.result.Vrps), "  len(warnings):", len(v.result.Warnings),
+		"  time(s):", v.result.EndTime.Sub(v.result.StartTime))
+	return v.result, nil
+}
+
+func (v *rpkiValidator) readLocalFile(uri string) ([]byte, error) {
+	file, err := urlutil.JoinPrefixPathAndUrlFileName(v.config.LocalDir, uri)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(file)
+}
+
+func (v *rpkiValidator) addWarning(uri string, message string) {
+	belogs.Debug("rpkiValidator.addWarning(): uri:", uri, "  message:", message)
+	v.result.Warnings = append(v.result.Warnings, ValidationWarning{Uri: uri, Message: message})
+}
+
+// walkCa checks manifest and crl of ca, then validates all files on manifest
+func (v *rpkiValidator) walkCa(ca *validatedCa) {
+	repoUri, mftUri := getCaSia(ca.cert)
+	if len(repoUri) == 0 || len(mftUri) == 0 {
+		v.addWarning(ca.uri, "SIA of CA certificate has no caRepository or rpkiManifest")
+		return
+	}
+	if !strings.HasSuffix(repoUri, "/") {
+		repoUri += "/"
+	}
+
+	// manifest
+	b, err := v.readLocalFile(mftUri)
+	if err != nil {
+		v.addWarning(mftUri, "manifest cannot be read: "+err.Error())
+		return
+	}
+	if !v.checkDer(mftUri, b) {
+		return
+	}
+	mft, err := asn1cms.ParseManifest(b)
+	if err != nil {
+		v.addWarning(mftUri, "manifest is invalid: "+err.Error())
+		return
+	}
+	if err = v.checkEeCert(ca, &mft.SignedObject, nil); err != nil {
+		v.addWarning(mftUri, "EE certificate of manifest is invalid: "+err.Error())
+		return
+	}
+	if v.now.Before(mft.ThisUpdate) || v.now.After(mft.NextUpdate) {
+		v.addWarning(mftUri, "manifest is not in thisUpdate and nextUpdate, thisUpdate:"+mft.ThisUpdate.String()+
+			", nextUpdate:"+mft.NextUpdate.String())
+		return
+	}
+	v.result.ManifestCount++
+
+	// crl, only one on manifest
+	hashs := make(map[string][]byte, len(mft.FileAndHashs))
+	crlName := ""
+	for _, fileAndHash := range mft.FileAndHashs {
+		hashs[fileAndHash.File] = fileAndHash.Hash
+		if strings.HasSuffix(fileAndHash.File, ".crl") {
+			if len(crlName) > 0 {
+				v.addWarning(mftUri, "manifest has more than one crl")
+				return
+			}
+			crlName = fileAndHash.File
+		}
+	}
+	if len(crlName) == 0 {
+		v.addWarning(mftUri, "manifest has no crl")
+		return
+	}
+	crlUri := repoUri + crlName
+	revokedSerials, err := v.checkCrl(ca, crlUri, hashs[crlName])
+	if err != nil {
+		v.addWarning(crlUri, "crl is invalid: "+err.Error())
+		return
+	}
+	v.result.CrlCount++
+	if err = checkRevoked(mft.EeCert, revokedSerials); err != nil {
+		v.addWarning(mftUri, "EE certificate of manifest is revoked")
+		return
+	}
+
+	for _, fileAndHash := range mft.FileAndHashs {
+		uri := repoUri + fileAndHash.File
+		if fileAndHash.File == crlName {
+			continue
+		}
+		b, err := v.readLocalFile(uri)
+		if err != nil {
+			v.addWarning(uri, "file on manifest cannot be read: "+err.Error())
+			continue
+		}
+		hash := sha256.Sum256(b)
+		if !bytes.Equal(hash[:], fileAndHash.Hash) {
+			v.addWarning(uri, "hash of file is different from manifest")
+			continue
+		}
+		if !v.checkDer(uri, b) {
+			continue
+		}
+		switch filepath.Ext(fileAndHash.File) {
+		case ".cer":
+			v.validateCer(ca, uri, b, revokedSerials)
+		case ".roa":
+			v.validateRoa(ca, uri, b, revokedSerials)
+		case ".asa":
+			v.validateAspa(ca, uri, b, revokedSerials)
+		default:
+			belogs.Debug("rpkiValidator.walkCa(): ignore file:", uri)
+		}
+	}
+}
+
+// checkCrl returns revoked serials
+func (v *rpkiValidator) checkCrl(ca *validatedCa, crlUri string, hash []byte) (map[string]bool, error) {
+	b, err := v.readLocalFile(crlUri)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(b)
+	if !bytes.Equal(h[:], hash) {
+		return nil, errors.New("hash of crl is different from manifest")
+	}
+	if !v.checkDer(crlUri, b) {
+		return nil, errors.New("crl is malformed")
+	}
+	crl, err := x509.ParseRevocationList(b)
+	if err != nil {
+		return nil, err
+	}
+	if err = crl.CheckSignatureFrom(ca.cert.Certificate); err != nil {
+		return nil, err
+	}
+	if v.now.After(crl.NextUpdate) {
+		return nil, errors.New("crl is expired, nextUpdate:" + crl.NextUpdate.String())
+	}
+	revokedSerials := make(map[string]bool, len(crl.RevokedCertificateEntries))
+	for _, entry := range crl.RevokedCertificateEntries {
+		revokedSerials[entry.SerialNumber.String()] = true
+	}
+	return revokedSerials, nil
+}
+
+// checkDer returns false when StrictDer and b is not DER, then all violations are in one warning
+func (v *rpkiValidator) checkDer(uri string, b []byte) bool {
+	if !v.config.StrictDer {
+		return true
+	}
+	violations := asn1base.ValidateDer(b)
+	if len(violations) == 0 {
+		return true
+	}
+	v.result.MalformedCount++
+	v.addWarning(uri, "object is malformed: "+violations.Error())
+	return false
+}
+
+// validateCer validates child CA certificate and walks it, non-CA (such as BGPsec router) certificate is ignored
+func (v *rpkiValidator) validateCer(ca *validatedCa, uri string, b []byte, revokedSerials map[string]bool) {
+	cert, err := asn1addressasn.DecodeCertificate(b)
+	if err != nil {
+		v.addWarning(uri, "certificate is invalid: "+err.Error())
+		return
+	}
+	if !cert.Certificate.IsCA {
+		belogs.Debug("rpkiValidator.validateCer(): not CA, ignore:", uri)
+		return
+	}
+	ips, asns, err := v.checkCert(ca, cert, revokedSerials)
+	if err != nil {
+		v.addWarning(uri, err.Error())
+		return
+	}
+	ski := hex.EncodeToString(cert.Certificate.SubjectKeyId)
+	if v.visited[ski] {
+		v.addWarning(uri, "CA certificate is already validated, maybe loop")
+		return
+	}
+	if ca.depth+1 > v.maxDepth {
+		v.addWarning(uri, "CA certificate is too deep: "+strconv.Itoa(ca.depth+1))
+		return
+	}
+	v.visited[ski] = true
+	v.result.CaCount++
+	v.walkCa(&validatedCa{
+		uri:   uri,
+		cert:  cert,
+		ips:   ips,
+		asns:  asns,
+		depth: ca.depth + 1,
+	})
+}
+
+// validateRoa: EE certificate and all prefixes should be in resources
+func (v *rpkiValidator) validateRoa(ca *validatedCa, uri string, b []byte, revokedSerials map[string]bool) {
+	roa, err := asn1cms.ParseRoa(b)
+	if err != nil {
+		v.addWarning(uri, "roa is invalid: "+err.Error())
+		return
+	}
+	ee := &validatedCa{}
+	if err = v.checkEeCert(ca, &roa.SignedObject, ee); err != nil {
+		v.addWarning(uri, "EE certificate of roa is invalid: "+err.Error())
+		return
+	}
+	if err = checkRevoked(roa.EeCert, revokedSerials); err != nil {
+		v.addWarning(uri, err.Error())
+		return
+	}
+	vrps := make([]Vrp, 0, len(roa.RoaIpAddresses))
+	for _, roaIpAddress := range roa.RoaIpAddresses {
+		prefix, err := netip.ParsePrefix(roaIpAddress.AddressPrefix)
+		if err != nil {
+			v.addWarning(uri, "prefix of roa is invalid: "+roaIpAddress.AddressPrefix)
+			return
+		}
+		prefix = prefix.Masked()
+		if !ee.ips.ContainsPrefix(prefix) {
+			v.addWarning(uri, "prefix of roa is not in EE certificate: "+roaIpAddress.AddressPrefix)
+			return
+		}
+		maxLength := roaIpAddress.MaxLength
+		if maxLength < 0 {
+			maxLength = prefix.Bits()
+		}
+		vrps = append(vrps, Vrp{
+			Prefix:    prefix.String(),
+			MaxLength: maxLength,
+			Asn:       roa.AsId,
+			Ta:        v.result.Ta,
+			Uri:       uri,
+		})
+	}
+	v.result.RoaCount++
+	v.result.Vrps = append(v.result.Vrps, vrps...)
+}
+
+// validateAspa: customer should be in resources of EE certificate
+func (v *rpkiValidator) validateAspa(ca *validatedCa, uri string, b []byte, revokedSerials map[string]bool) {
+	aspa, err := asn1cms.ParseAspa(b)
+	if err != nil {
+		v.addWarning(uri, "aspa is invalid: "+err.Error())
+		return
+	}
+	ee := &validatedCa{}
+	if err = v.checkEeCert(ca, &aspa.SignedObject, ee); err != nil {
+		v.addWarning(uri, "EE certificate of aspa is invalid: "+err.Error())
+		return
+	}
+	if err = checkRevoked(aspa.EeCert, revokedSerials); err != nil {
+		v.addWarning(uri, err.Error())
+		return
+	}
+	if aspa.CustomerAsId < 0 || aspa.CustomerAsId > math.MaxUint32 || !ee.asns.ContainsAsn(uint32(aspa.CustomerAsId)) {
+		v.addWarning(uri, "customer of aspa is not in EE certificate: "+strconv.FormatInt(aspa.CustomerAsId, 10))
+		return
+	}
+	v.result.AspaCount++
+	v.result.Vaps = append(v.result.Vaps, Vap{
+		CustomerAsn:  aspa.CustomerAsId,
+		ProviderAsns: aspa.ProviderAsIds,
+		Ta:           v.result.Ta,
+		Uri:          uri,
+	})
+}
+
+// checkEeCert checks EE certificate of signed object by ca, and saves effective resources to ee when ee is not nil
+func (v *rpkiValidator) checkEeCert(ca *validatedCa, signedObject *asn1cms.SignedObject, ee *validatedCa) error {
+	if signedObject.EeCert.Certificate.IsCA {
+		return errors.New("EE certificate should not be CA")
+	}
+	ips, asns, err := v.checkCert(ca, signedObject.EeCert, nil)
+	if err != nil {
+		return err
+	}
+	if ee != nil {
+		ee.cert = signedObject.EeCert
+		ee.ips = ips
+		ee.asns = asns
+	}
+	return nil
+}
+
+// checkCert checks signature, validity, revocation and resource containment by RFC 6487,
+// and gets effective resources of cert (inherit is resolved)
+func (v *rpkiValidator) checkCert(ca *validatedCa, cert *asn1addressasn.RPKICertificate,
+	revokedSerials map[string]bool) (*asn1addressasn.IPResourceSet, *asn1addressasn.ASResourceSet, error) {
+	if err := cert.Certificate.CheckSignatureFrom(ca.cert.Certificate); err != nil {
+		return nil, nil, errors.New("signature of certificate is invalid: " + err.Error())
+	}
+	if err := cert.ValidateTime(v.now); err != nil {
+		return nil, nil, err
+	}
+	if err := checkRevoked(cert, revokedSerials); err != nil {
+		return nil, nil, err
+	}
+	ips, asns, err := getResourceSets(cert)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ips.IsEmpty() && asns.IsEmpty() {
+		return nil, nil, errors.New("certificate has no resources")
+	}
+	if overClaimIps := ips.Subtract(ca.ips); len(overClaimIps.Ranges) > 0 {
+		return nil, nil, errors.New("ip addresses of certificate are not in issuer: " + overClaimIps.String())
+	}
+	if overClaimAsns := asns.Subtract(ca.asns); len(overClaimAsns.Ranges) > 0 {
+		return nil, nil, errors.New("as numbers of certificate are not in issuer: " + overClaimAsns.String())
+	}
+	return ips.ResolveInherit(ca.ips), asns.ResolveInherit(ca.asns), nil
+}
+
+func checkRevoked(cert *asn1addressasn.RPKICertificate, revokedSerials map[string]bool) error {
+	if revokedSerials[cert.Certificate.SerialNumber.String()] {
+		return errors.New("certificate is revoked, serial:" + cert.Certificate.SerialNumber.String())
+	}
+	return nil
+}
+
+// getCaSia gets caRepository and rpkiManifest, rsync uri is preferred
+func getCaSia(cert *asn1addressasn.RPKICertificate) (repoUri, mftUri string) {
+	for _, sia := range cert.SubjectInformationAccess {
+		uri := string(sia.GeneralName)
+		if !strings.HasPrefix(uri, "rsync://") {
+			continue
+		}
+		if sia.AccessMethod.Equal(asn1addressasn.CertRepository) && len(repoUri) == 0 {
+			repoUri = uri
+		} else if sia.AccessMethod.Equal(asn1addressasn.SIAManifest) && len(mftUri) == 0 {
+			mftUri = uri
+		}
+	}
+	return repoUri, mftUri
+}
+
+// getResourceSets gets ip and as resources of cert, inherit is not resolved
+func getResourceSets(cert *asn1addressasn.RPKICertificate) (*asn1addressasn.IPResourceSet, *asn1addressasn.ASResourceSet, error) {
+	ips, err := asn1addressasn.NewIPResourceSet(cert.IPAddresses)
+	if err != nil {
+		return nil, nil, err
+	}
+	asns, err := asn1addressasn.NewASResourceSet(cert.ASNums)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ips, asns, nil
+}
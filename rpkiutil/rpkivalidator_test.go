@@ -0,0 +1,130 @@
+package rpkiutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cpusoft/goutil/jsonutil"
+	"github.com/cpusoft/goutil/rpkiutil/rpkibuilder"
+)
+
+// newTestRepo builds ta, ca and their publication points, manifests are valid for 1 hour from now
+func newTestRepo(t *testing.T, localDir, talFile string) {
+	b := rpkibuilder.NewRpkiBuilder(rpkibuilder.RpkiBuilderConfig{
+		LocalDir:         localDir,
+		Now:              time.Now().Add(-time.Hour),
+		ManifestValidity: 2 * time.Hour,
+		KeySize:          1024,
+		NewKey:           rpkibuilder.NewSeededKeyFunc([32]byte{'r', 'p', 'k', 'i', 'u', 't', 'i', 'l'}, 1024),
+	})
+	// ta: 10.0.0.0/8, AS65000-65010
+	ta, err := b.NewTa("rsync://example.com/ta.cer", "rsync://example.com/repo/ta/",
+		&rpkibuilder.RpkiResources{Ips: []string{"10.0.0.0/8"}, Asns: []string{"65000-65010"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = b.WriteTal(ta, talFile); err != nil {
+		t.Fatal(err)
+	}
+	// ca: 10.1.0.0/16, inherit AS
+	ca, err := b.NewCa(ta, "ca.cer", "rsync://example.com/repo/ca/",
+		&rpkibuilder.RpkiResources{Ips: []string{"10.1.0.0/16"}, InheritAsn: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// too big: 11.0.0.0/8
+	if _, err = b.NewCa(ta, "big.cer", "rsync://example.com/repo/big/",
+		&rpkibuilder.RpkiResources{Ips: []string{"11.0.0.0/8"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	roas := []struct {
+		name        string
+		asn         uint32
+		prefix      rpkibuilder.RpkiRoaPrefix
+		eeResources *rpkibuilder.RpkiResources
+	}{
+		{"good.roa", 65001, rpkibuilder.RpkiRoaPrefix{Prefix: "10.1.1.0/24", MaxLength: 24}, nil},
+		{"nomax.roa", 65002, rpkibuilder.RpkiRoaPrefix{Prefix: "10.1.2.0/24"}, &rpkibuilder.RpkiResources{InheritIpv4: true}},
+		{"outofee.roa", 65001, rpkibuilder.RpkiRoaPrefix{Prefix: "10.1.4.0/24", MaxLength: 24},
+			&rpkibuilder.RpkiResources{Ips: []string{"10.1.3.0/24"}}},
+		{"outofca.roa", 65001, rpkibuilder.RpkiRoaPrefix{Prefix: "192.168.0.0/16", MaxLength: 24}, nil},
+		{"revoked.roa", 65001, rpkibuilder.RpkiRoaPrefix{Prefix: "10.1.5.0/24", MaxLength: 24}, nil},
+		{"hash.roa", 65001, rpkibuilder.RpkiRoaPrefix{Prefix: "10.1.6.0/24", MaxLength: 24}, nil},
+		{"missing.roa", 65001, rpkibuilder.RpkiRoaPrefix{Prefix: "10.1.7.0/24", MaxLength: 24}, nil},
+	}
+	for _, roa := range roas {
+		_, eeCert, err := b.NewRoa(ca, roa.name, roa.asn, []rpkibuilder.RpkiRoaPrefix{roa.prefix}, roa.eeResources)
+		if err != nil {
+			t.Fatal(roa.name, err)
+		}
+		if roa.name == "revoked.roa" {
+			b.Revoke(ca, eeCert)
+		}
+	}
+	if _, _, err = b.NewAspa(ca, "customer.asa", 65003, []uint32{65004, 65005}, nil); err != nil {
+		t.Fatal(err)
+	}
+	b.AddFile(ca, "unknown.other", []byte("other"))
+	if err = b.WriteRepo(); err != nil {
+		t.Fatal(err)
+	}
+
+	// hash.roa is different from manifest, and missing.roa is on manifest but not published
+	hashFile, _ := b.GetLocalFile(ca.RepoUri + "hash.roa")
+	if err = os.WriteFile(hashFile, []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	missingFile, _ := b.GetLocalFile(ca.RepoUri + "missing.roa")
+	if err = os.Remove(missingFile); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateTal(t *testing.T) {
+	localDir := t.TempDir()
+	talFile := filepath.Join(localDir, "test.tal")
+	newTestRepo(t, localDir, talFile)
+
+	result, err := ValidateTalFile(talFile, &RpkiValidatorConfig{LocalDir: localDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(jsonutil.MarshalJsonIndent(result))
+	if result.Ta != "test" || result.CaCount != 2 || result.RoaCount != 2 || result.AspaCount != 1 {
+		t.Fatal("counts are wrong:", jsonutil.MarshalJson(result))
+	}
+	vrps := make([]string, 0)
+	for _, vrp := range result.Vrps {
+		vrps = append(vrps, fmt.Sprintf("%s-%d-%d", vrp.Prefix, vrp.MaxLength, vrp.Asn))
+	}
+	if strings.Join(vrps, ",") != "10.1.1.0/24-24-65001,10.1.2.0/24-24-65002" &&
+		strings.Join(vrps, ",") != "10.1.2.0/24-24-65002,10.1.1.0/24-24-65001" {
+		t.Fatal("vrps are wrong:", vrps)
+	}
+	if len(result.Vaps) != 1 || result.Vaps[0].CustomerAsn != 65003 {
+		t.Fatal("vaps are wrong:", jsonutil.MarshalJson(result.Vaps))
+	}
+	warnings := make(map[string]bool)
+	for _, warning := range result.Warnings {
+		warnings[warning.Uri[strings.LastIndex(warning.Uri, "/")+1:]] = true
+	}
+	for _, name := range []string{"big.cer", "outofee.roa", "outofca.roa", "revoked.roa", "hash.roa", "missing.roa"} {
+		if !warnings[name] {
+			t.Fatal("should have warning:", name, jsonutil.MarshalJson(result.Warnings))
+		}
+	}
+
+	// manifest is stale
+	result, err = ValidateTalFile(talFile, &RpkiValidatorConfig{LocalDir: localDir, ValidationTime: time.Now().Add(2 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Vrps) != 0 || len(result.Warnings) == 0 {
+		t.Fatal("stale manifest should have no vrps:", jsonutil.MarshalJson(result))
+	}
+}
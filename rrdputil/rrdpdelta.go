@@ -281,13 +281,36 @@ func CheckRrdpDelta(deltaModel *DeltaModel, notificationModel *NotificationModel
 }
 
 func ConvertDeltasToRrdpFiles(deltaModels []DeltaModel, notifyUrl, destPath string) (rrdpFilesAll []*RrdpFile, err error) {
+	return convertDeltasToRrdpFilesWithVerifier(deltaModels, notifyUrl, destPath, nil)
+}
+
+// ConvertDeltasToRrdpFilesWithVerify works as ConvertDeltasToRrdpFiles, and also checks hash of every
+// publish and withdraw against the local files in destPath (RFC 8182 3.4.2),
+// deltaModels must be sorted from older to newer.
+// If some files conflict, err is *RrdpDeltaConflictError, caller should use snapshot instead.
+func ConvertDeltasToRrdpFilesWithVerify(deltaModels []DeltaModel, notifyUrl, destPath string) (rrdpFilesAll []*RrdpFile, err error) {
+	verifier := newRrdpDeltaVerifier()
+	rrdpFilesAll, err = convertDeltasToRrdpFilesWithVerifier(deltaModels, notifyUrl, destPath, verifier)
+	if err != nil {
+		return nil, err
+	}
+	if len(verifier.conflicts) > 0 {
+		belogs.Error("ConvertDeltasToRrdpFilesWithVerify(): has conflicts, notifyUrl:", notifyUrl,
+			"  len(conflicts):", len(verifier.conflicts), "  conflicts:", jsonutil.MarshalJson(verifier.conflicts))
+		return nil, &RrdpDeltaConflictError{NotifyUrl: notifyUrl, Conflicts: verifier.conflicts}
+	}
+	return rrdpFilesAll, nil
+}
+
+func convertDeltasToRrdpFilesWithVerifier(deltaModels []DeltaModel, notifyUrl, destPath string,
+	verifier *rrdpDeltaVerifier) (rrdpFilesAll []*RrdpFile, err error) {
 	belogs.Debug("ConvertDeltasToRrdpFiles(): input param, len(deltaModels):", len(deltaModels), "  notifyUrl:", notifyUrl, "  destPath:", destPath)
 	om := goorderedmap.New[string, *RrdpFile]()
 	// from latest to oldest
 	// will use latest serial delta, and ignore same url files in older serial delta
 	for i := range deltaModels {
 		// save publish files and remove withdraw files
-		err := convertDeltasToRrdpFiles(&deltaModels[i], om, destPath)
+		err := convertDeltasToRrdpFiles(&deltaModels[i], om, destPath, verifier)
 		if err != nil {
 			belogs.Error("ConvertDeltasToRrdpFiles(): convertDeltasToRrdpFiles fail, notifyUrl:", notifyUrl,
 				"   deltaModels[i].SessionId:", deltaModels[i].SessionId,
@@ -310,8 +333,9 @@ func ConvertDeltasToRrdpFiles(deltaModels []DeltaModel, notifyUrl, destPath stri
 }
 
 // repoPath --> conf.String("rrdp::reporrdp"): /root/rpki/data/reporrdp
+// verifier may be nil, then hashes will not be checked
 func convertDeltasToRrdpFiles(deltaModel *DeltaModel, om *goorderedmap.OrderedMap[string, *RrdpFile],
-	repoPath string) (err error) {
+	repoPath string, verifier *rrdpDeltaVerifier) (err error) {
 
 	// delta may have no publishes and no withdraws
 	if deltaModel == nil ||
@@ -335,7 +359,7 @@ func convertDeltasToRrdpFiles(deltaModel *DeltaModel, om *goorderedmap.OrderedMa
 				"    last:", jsonutil.MarshalJson(existRrdpFile))
 			om.Delete(uri)
 		}
-		rrdpFile, err := convertDeltaWithdrawToRrdpFile(deltaModel, &deltaModel.DeltaWithdraws[i], repoPath)
+		rrdpFile, err := convertDeltaWithdrawToRrdpFile(deltaModel, &deltaModel.DeltaWithdraws[i], repoPath, verifier)
 		if err != nil {
 			belogs.Error("convertDeltasToRrdpFiles(): convertDeltaWithdrawToRrdpFile fail,uri:", uri, err)
 			return err
@@ -358,7 +382,7 @@ func convertDeltasToRrdpFiles(deltaModel *DeltaModel, om *goorderedmap.OrderedMa
 				"    last:", jsonutil.MarshalJson(existRrdpFile))
 			om.Delete(uri)
 		}
-		rrdpFile, err := convertDeltaPublishToRrdpFile(deltaModel, &deltaModel.DeltaPublishs[i], repoPath)
+		rrdpFile, err := convertDeltaPublishToRrdpFile(deltaModel, &deltaModel.DeltaPublishs[i], repoPath, verifier)
 		if err != nil {
 			belogs.Error("convertDeltasToRrdpFiles(): convertDeltaPublishToRrdpFile fail,uri:", uri, err)
 			return err
@@ -374,7 +398,8 @@ func convertDeltasToRrdpFiles(deltaModel *DeltaModel, om *goorderedmap.OrderedMa
 	return nil
 }
 
-func convertDeltaWithdrawToRrdpFile(deltaModel *DeltaModel, deltaWithdraw *DeltaWithdraw, repoPath string) (*RrdpFile, error) {
+func convertDeltaWithdrawToRrdpFile(deltaModel *DeltaModel, deltaWithdraw *DeltaWithdraw, repoPath string,
+	verifier *rrdpDeltaVerifier) (*RrdpFile, error) {
 	belogs.Debug("convertDeltaWithdrawToRrdpFile(): deltaModel.DeltaUrl", deltaModel.DeltaUrl,
 		"    deltaWithdraw.Uri", deltaWithdraw.Uri, "   repoPath:", repoPath)
 	uri := deltaWithdraw.Uri
@@ -383,6 +408,9 @@ func convertDeltaWithdrawToRrdpFile(deltaModel *DeltaModel, deltaWithdraw *Delta
 		belogs.Error("convertDeltaWithdrawToRrdpFile(): JoinPrefixPathAndUrlFileName fail,uri:", uri, err)
 		return nil, err
 	}
+	if verifier != nil {
+		verifier.verifyWithdraw(deltaModel, deltaWithdraw, filePathName)
+	}
 	dir, file := osutil.Split(filePathName)
 	rrdpFile := &RrdpFile{
 		FilePath:      dir,
@@ -442,7 +470,8 @@ func convertDeltaWithdrawToRrdpFile(deltaModel *DeltaModel, deltaWithdraw *Delta
 	*/
 }
 
-func convertDeltaPublishToRrdpFile(deltaModel *DeltaModel, deltaPublish *DeltaPublish, repoPath string) (*RrdpFile, error) {
+func convertDeltaPublishToRrdpFile(deltaModel *DeltaModel, deltaPublish *DeltaPublish, repoPath string,
+	verifier *rrdpDeltaVerifier) (*RrdpFile, error) {
 	belogs.Debug("convertDeltaPublishToRrdpFile(): deltaModel.DeltaUrl", deltaModel.DeltaUrl,
 		"    deltaPublish.Uri", deltaPublish.Uri, "   repoPath:", repoPath)
 	uri := deltaPublish.Uri
@@ -451,6 +480,9 @@ func convertDeltaPublishToRrdpFile(deltaModel *DeltaModel, deltaPublish *DeltaPu
 		belogs.Error("convertDeltaPublishToRrdpFile(): JoinPrefixPathAndUrlFileName fail,uri:", uri, err)
 		return nil, err
 	}
+	if verifier != nil {
+		verifier.verifyPublish(deltaModel, deltaPublish, filePathName)
+	}
 	dir, file := osutil.Split(filePathName)
 	rrdpFile := &RrdpFile{
 		FilePath:     dir,
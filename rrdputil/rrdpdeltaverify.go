@@ -0,0 +1,138 @@
+package rrdputil
+
+import (
+	"os"
+	"strings"
+
+	"github.com/cpusoft/goutil/base64util"
+	"github.com/cpusoft/goutil/belogs"
+	"github.com/cpusoft/goutil/convert"
+	"github.com/cpusoft/goutil/hashutil"
+)
+
+const (
+	RRDP_DELTA_CONFLICT_TYPE_PUBLISH  = "publish"
+	RRDP_DELTA_CONFLICT_TYPE_WITHDRAW = "withdraw"
+)
+
+// RrdpDeltaConflict is one publish or withdraw whose hash does not match the local file
+type RrdpDeltaConflict struct {
+	Uri      string `json:"uri"`
+	Serial   uint64 `json:"serial"`
+	DeltaUrl string `json:"deltaUrl"`
+	// publish/withdraw
+	Type string `json:"type"`
+	// hash in delta, empty when publish a new file
+	ExpectHash string `json:"expectHash"`
+	// hash of local file, empty when local file does not exist
+	LocalHash string `json:"localHash"`
+	Reason    string `json:"reason"`
+}
+
+// RrdpDeltaConflictError is returned when deltas cannot be applied to local files
+type RrdpDeltaConflictError struct {
+	NotifyUrl string              `json:"notifyUrl"`
+	Conflicts []RrdpDeltaConflict `json:"conflicts"`
+}
+
+func (e *RrdpDeltaConflictError) Error() string {
+	var b strings.Builder
+	b.WriteString("deltas of " + e.NotifyUrl + " have " + convert.ToString(len(e.Conflicts)) + " conflicts with local files")
+	for i := range e.Conflicts {
+		if i >= 3 {
+			b.WriteString(", ...")
+			break
+		}
+		b.WriteString(", " + e.Conflicts[i].Uri + ": " + e.Conflicts[i].Reason)
+	}
+	return b.String()
+}
+
+// rrdpDeltaVerifier checks every publish/withdraw against the local file,
+// after a publish/withdraw, the file is seen as updated, so the next delta will check against it.
+type rrdpDeltaVerifier struct {
+	// map[filePathName]sha256 of file after applied deltas, "" means file is not exist
+	fileHashs map[string]string
+	conflicts []RrdpDeltaConflict
+}
+
+func newRrdpDeltaVerifier() *rrdpDeltaVerifier {
+	return &rrdpDeltaVerifier{
+		fileHashs: make(map[string]string),
+		conflicts: make([]RrdpDeltaConflict, 0),
+	}
+}
+
+func (c *rrdpDeltaVerifier) getHash(filePathName string) (string, error) {
+	if hash, ok := c.fileHashs[filePathName]; ok {
+		return hash, nil
+	}
+	hash, err := hashutil.Sha256File(filePathName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return hash, nil
+}
+
+// RFC 8182 3.4.2: publish without hash must be a new file, publish with hash must replace
+// the file with same hash
+func (c *rrdpDeltaVerifier) verifyPublish(deltaModel *DeltaModel, deltaPublish *DeltaPublish, filePathName string) {
+	localHash, err := c.getHash(filePathName)
+	conflict := RrdpDeltaConflict{
+		Uri:        deltaPublish.Uri,
+		Serial:     deltaModel.Serial,
+		DeltaUrl:   deltaModel.DeltaUrl,
+		Type:       RRDP_DELTA_CONFLICT_TYPE_PUBLISH,
+		ExpectHash: deltaPublish.Hash,
+		LocalHash:  localHash,
+	}
+	if err != nil {
+		conflict.Reason = "read local file fail: " + err.Error()
+	} else if len(deltaPublish.Hash) == 0 && len(localHash) > 0 {
+		conflict.Reason = "publish a new file, but local file exists"
+	} else if len(deltaPublish.Hash) > 0 && len(localHash) == 0 {
+		conflict.Reason = "replace a file, but local file does not exist"
+	} else if len(deltaPublish.Hash) > 0 && !strings.EqualFold(deltaPublish.Hash, localHash) {
+		conflict.Reason = "hash of local file is different from publish hash"
+	}
+	if len(conflict.Reason) > 0 {
+		belogs.Debug("rrdpDeltaVerifier.verifyPublish(): conflict:", conflict.Uri, conflict.Reason)
+		c.conflicts = append(c.conflicts, conflict)
+	}
+
+	bytes, err := base64util.DecodeBase64(deltaPublish.Base64)
+	if err != nil {
+		// decode error will be found when saving file, just mark it as unknown
+		c.fileHashs[filePathName] = "-"
+		return
+	}
+	c.fileHashs[filePathName] = hashutil.Sha256(bytes)
+}
+
+// RFC 8182 3.4.2: withdraw must remove the file with same hash
+func (c *rrdpDeltaVerifier) verifyWithdraw(deltaModel *DeltaModel, deltaWithdraw *DeltaWithdraw, filePathName string) {
+	localHash, err := c.getHash(filePathName)
+	conflict := RrdpDeltaConflict{
+		Uri:        deltaWithdraw.Uri,
+		Serial:     deltaModel.Serial,
+		DeltaUrl:   deltaModel.DeltaUrl,
+		Type:       RRDP_DELTA_CONFLICT_TYPE_WITHDRAW,
+		ExpectHash: deltaWithdraw.Hash,
+		LocalHash:  localHash,
+	}
+	if err != nil {
+		conflict.Reason = "read local file fail: " + err.Error()
+	} else if len(localHash) == 0 {
+		conflict.Reason = "withdraw a file, but local file does not exist"
+	} else if !strings.EqualFold(deltaWithdraw.Hash, localHash) {
+		conflict.Reason = "hash of local file is different from withdraw hash"
+	}
+	if len(conflict.Reason) > 0 {
+		belogs.Debug("rrdpDeltaVerifier.verifyWithdraw(): conflict:", conflict.Uri, conflict.Reason)
+		c.conflicts = append(c.conflicts, conflict)
+	}
+	c.fileHashs[filePathName] = ""
+}
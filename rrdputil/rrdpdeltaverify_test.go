@@ -0,0 +1,48 @@
+package rrdputil
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cpusoft/goutil/base64util"
+	"github.com/cpusoft/goutil/hashutil"
+)
+
+func TestConvertDeltasToRrdpFilesWithVerify(t *testing.T) {
+	repoPath := t.TempDir()
+	os.MkdirAll(filepath.Join(repoPath, "example.com/repo"), os.ModePerm)
+	os.WriteFile(filepath.Join(repoPath, "example.com/repo/a.cer"), []byte("a1"), 0644)
+	os.WriteFile(filepath.Join(repoPath, "example.com/repo/b.roa"), []byte("b1"), 0644)
+
+	deltaModels := []DeltaModel{
+		{Serial: 2, DeltaUrl: "https://example.com/2/delta.xml",
+			DeltaPublishs: []DeltaPublish{{Uri: "rsync://example.com/repo/a.cer", Hash: hashutil.Sha256([]byte("a1")),
+				Base64: base64util.EncodeBase64([]byte("a2"))}},
+			DeltaWithdraws: []DeltaWithdraw{{Uri: "rsync://example.com/repo/b.roa", Hash: hashutil.Sha256([]byte("b1"))}}},
+		// replace a2 by a3, the hash is the one published by serial 2
+		{Serial: 3, DeltaUrl: "https://example.com/3/delta.xml",
+			DeltaPublishs: []DeltaPublish{{Uri: "rsync://example.com/repo/a.cer", Hash: hashutil.Sha256([]byte("a2")),
+				Base64: base64util.EncodeBase64([]byte("a3"))}}},
+	}
+	rrdpFiles, err := ConvertDeltasToRrdpFilesWithVerify(deltaModels, "https://example.com/notification.xml", repoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(len(rrdpFiles))
+
+	// local file is changed
+	os.WriteFile(filepath.Join(repoPath, "example.com/repo/b.roa"), []byte("b-changed"), 0644)
+	// publish a new file, but it exists
+	deltaModels[1].DeltaPublishs = append(deltaModels[1].DeltaPublishs,
+		DeltaPublish{Uri: "rsync://example.com/repo/b.roa", Base64: base64util.EncodeBase64([]byte("b2"))})
+	_, err = ConvertDeltasToRrdpFilesWithVerify(deltaModels, "https://example.com/notification.xml", repoPath)
+	fmt.Println(err)
+	var conflictErr *RrdpDeltaConflictError
+	if !errors.As(err, &conflictErr) || len(conflictErr.Conflicts) != 1 ||
+		conflictErr.Conflicts[0].Type != RRDP_DELTA_CONFLICT_TYPE_WITHDRAW {
+		t.Fatal("should have one withdraw conflict")
+	}
+}
@@ -0,0 +1,439 @@
+package rrdputil
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cpusoft/goutil/base64util"
+	"github.com/cpusoft/goutil/belogs"
+	"github.com/cpusoft/goutil/convert"
+	"github.com/cpusoft/goutil/fileutil"
+	"github.com/cpusoft/goutil/hashutil"
+	"github.com/cpusoft/goutil/uuidutil"
+	"github.com/cpusoft/goutil/xmlutil"
+)
+
+const (
+	RRDP_XMLNS   = "http://www.ripe.net/rpki/rrdp"
+	RRDP_VERSION = "1"
+
+	// default count of deltas in notification.xml
+	RRDP_PUBLISHER_DEFAULT_MAX_DELTAS = 100
+)
+
+// RrdpPublisher is the publication server side of RRDP(RFC 8182).
+// It keeps current objects in memory, and generates notification.xml, snapshot.xml
+// and delta.xml when objects change.
+//
+//	notification: baseUrl/notification.xml
+//	snapshot:     baseUrl/<session_id>/<serial>/snapshot.xml
+//	delta:        baseUrl/<session_id>/<serial>/delta.xml
+type RrdpPublisher struct {
+	mutex sync.RWMutex
+
+	// https://rrdp.example.com/rrdp
+	baseUrl   string
+	maxDeltas int
+
+	sessionId string
+	serial    uint64
+	// map[uri]bytes
+	objects map[string][]byte
+
+	notificationXml []byte
+	snapshotXml     []byte
+	// from newer to older
+	deltas []*rrdpPublisherDelta
+}
+
+type rrdpPublisherDelta struct {
+	serial   uint64
+	deltaXml []byte
+	hash     string
+}
+
+// maxDeltas <=0 will use RRDP_PUBLISHER_DEFAULT_MAX_DELTAS
+func NewRrdpPublisher(baseUrl string, maxDeltas int) *RrdpPublisher {
+	if maxDeltas <= 0 {
+		maxDeltas = RRDP_PUBLISHER_DEFAULT_MAX_DELTAS
+	}
+	return &RrdpPublisher{
+		baseUrl:   strings.TrimSuffix(strings.TrimSpace(baseUrl), "/"),
+		maxDeltas: maxDeltas,
+		sessionId: uuidutil.GetUuid(),
+		objects:   make(map[string][]byte),
+	}
+}
+
+// LoadRrdpPublisher restores session_id, serial, objects and deltas from notification.xml, snapshot.xml
+// and delta.xml written by WriteToDir, so a restarted publisher keeps its session and serves deltas, RFC 8182 3.3.
+// Hashes of snapshot and deltas are checked against notification.xml.
+func LoadRrdpPublisher(baseUrl string, maxDeltas int, dir string) (*RrdpPublisher, error) {
+	start := time.Now()
+	b, err := os.ReadFile(filepath.Join(dir, "notification.xml"))
+	if err != nil {
+		belogs.Error("LoadRrdpPublisher(): ReadFile notification fail:", dir, err)
+		return nil, err
+	}
+	notificationModel := NotificationModel{}
+	if err = xmlutil.UnmarshalXml(string(b), &notificationModel); err != nil {
+		belogs.Error("LoadRrdpPublisher(): UnmarshalXml notification fail:", dir, err)
+		return nil, err
+	}
+	if err = CheckRrdpNotification(&notificationModel); err != nil {
+		belogs.Error("LoadRrdpPublisher(): CheckRrdpNotification fail:", dir, err)
+		return nil, err
+	}
+	// session_id is part of file path
+	if strings.ContainsAny(notificationModel.SessionId, `/\.`) {
+		belogs.Error("LoadRrdpPublisher(): session_id is illegal:", notificationModel.SessionId)
+		return nil, errors.New("session_id is illegal: " + notificationModel.SessionId)
+	}
+
+	c := NewRrdpPublisher(baseUrl, maxDeltas)
+	c.sessionId = notificationModel.SessionId
+	c.serial = notificationModel.Serial
+
+	snapshotXml, err := readRrdpPublisherFile(dir, c.getSnapshotPath(), notificationModel.Snapshot.Hash)
+	if err != nil {
+		belogs.Error("LoadRrdpPublisher(): readRrdpPublisherFile snapshot fail:", dir, c.getSnapshotPath(), err)
+		return nil, err
+	}
+	snapshotModel, err := parseXmlToSnapshotModel(snapshotXml)
+	if err != nil {
+		belogs.Error("LoadRrdpPublisher(): parseXmlToSnapshotModel fail:", dir, err)
+		return nil, err
+	}
+	if snapshotModel.SessionId != c.sessionId || snapshotModel.Serial != c.serial {
+		belogs.Error("LoadRrdpPublisher(): snapshot is not same as notification:", snapshotModel.SessionId, snapshotModel.Serial,
+			"  notification:", c.sessionId, c.serial)
+		return nil, errors.New("session_id or serial of snapshot is not same as notification")
+	}
+	for i := range snapshotModel.SnapshotPublishs {
+		object, err := base64util.DecodeBase64(base64util.TrimBase64(snapshotModel.SnapshotPublishs[i].Base64))
+		if err != nil {
+			belogs.Error("LoadRrdpPublisher(): DecodeBase64 fail:", snapshotModel.SnapshotPublishs[i].Uri, err)
+			return nil, err
+		}
+		c.objects[snapshotModel.SnapshotPublishs[i].Uri] = object
+	}
+	c.snapshotXml = snapshotXml
+
+	sort.Sort(NotificationDeltasSort(notificationModel.Deltas))
+	for _, d := range notificationModel.Deltas {
+		deltaXml, err := readRrdpPublisherFile(dir, c.getDeltaPath(d.Serial), d.Hash)
+		if err != nil {
+			belogs.Error("LoadRrdpPublisher(): readRrdpPublisherFile delta fail:", dir, c.getDeltaPath(d.Serial), err)
+			return nil, err
+		}
+		c.deltas = append(c.deltas, &rrdpPublisherDelta{
+			serial:   d.Serial,
+			deltaXml: deltaXml,
+			hash:     hashutil.Sha256(deltaXml),
+		})
+	}
+	c.pruneDeltas()
+	// baseUrl may be changed, so notification.xml is built again
+	c.notificationXml = c.buildNotificationXml()
+	belogs.Info("LoadRrdpPublisher(): sessionId:", c.sessionId, "  serial:", c.serial,
+		"  len(objects):", len(c.objects), "  len(deltas):", len(c.deltas), "  time(s):", time.Since(start))
+	return c, nil
+}
+
+// readRrdpPublisherFile reads file of path under dir, and checks its sha256 hash
+func readRrdpPublisherFile(dir, path, hash string) ([]byte, error) {
+	b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(path)))
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(hashutil.Sha256(b), strings.TrimSpace(hash)) {
+		return nil, errors.New("hash of " + path + " is not same as notification")
+	}
+	return b, nil
+}
+
+func (c *RrdpPublisher) SessionId() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.sessionId
+}
+
+func (c *RrdpPublisher) Serial() uint64 {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.serial
+}
+
+func (c *RrdpPublisher) NotificationUrl() string {
+	return c.baseUrl + "/notification.xml"
+}
+
+// Publish replaces all objects with newObjects(map[uri]bytes).
+// The first call generates serial 1 with only snapshot, then every call with changes
+// bumps serial and generates a delta against the previous objects.
+// changed is false when newObjects is the same as current objects.
+func (c *RrdpPublisher) Publish(newObjects map[string][]byte) (changed bool, err error) {
+	start := time.Now()
+	for uri := range newObjects {
+		if _, err := url.Parse(uri); err != nil || len(uri) == 0 {
+			belogs.Error("RrdpPublisher.Publish(): uri is illegal:", uri, err)
+			return false, errors.New("uri is illegal: " + uri)
+		}
+	}
+	objects := make(map[string][]byte, len(newObjects))
+	for uri, b := range newObjects {
+		objects[uri] = append([]byte(nil), b...)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.serial == 0 {
+		c.serial = 1
+	} else {
+		deltaXml := c.buildDeltaXml(c.serial+1, objects)
+		if deltaXml == nil {
+			belogs.Debug("RrdpPublisher.Publish(): no changes, serial:", c.serial)
+			return false, nil
+		}
+		c.serial++
+		c.deltas = append([]*rrdpPublisherDelta{{
+			serial:   c.serial,
+			deltaXml: deltaXml,
+			hash:     hashutil.Sha256(deltaXml),
+		}}, c.deltas...)
+	}
+	c.objects = objects
+	c.snapshotXml = c.buildSnapshotXml()
+	c.pruneDeltas()
+	c.notificationXml = c.buildNotificationXml()
+	belogs.Info("RrdpPublisher.Publish(): sessionId:", c.sessionId, "  serial:", c.serial,
+		"  len(objects):", len(c.objects), "  len(deltas):", len(c.deltas), "  time(s):", time.Since(start))
+	return true, nil
+}
+
+// PublishDir publishes all files in dir, uri of every file is uriPrefix + relative path,
+// such as uriPrefix is rsync://rpki.example.com/repo/
+func (c *RrdpPublisher) PublishDir(dir, uriPrefix string) (changed bool, err error) {
+	objects := make(map[string][]byte)
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		objects[uriPrefix+filepath.ToSlash(rel)] = b
+		return nil
+	})
+	if err != nil {
+		belogs.Error("RrdpPublisher.PublishDir(): WalkDir fail:", dir, err)
+		return false, err
+	}
+	return c.Publish(objects)
+}
+
+// WriteToDir writes notification.xml, current snapshot.xml and all deltas under dir,
+// dir can be served by any web server as baseUrl.
+func (c *RrdpPublisher) WriteToDir(dir string) error {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.serial == 0 {
+		return errors.New("nothing is published")
+	}
+	files := make(map[string][]byte, len(c.deltas)+2)
+	files[c.getSnapshotPath()] = c.snapshotXml
+	for _, d := range c.deltas {
+		files[c.getDeltaPath(d.serial)] = d.deltaXml
+	}
+	for path, b := range files {
+		err := saveRrdpBytesToFile(filepath.Join(dir, filepath.FromSlash(path)), b)
+		if err != nil {
+			belogs.Error("RrdpPublisher.WriteToDir(): saveRrdpBytesToFile fail:", dir, path, err)
+			return err
+		}
+	}
+	// notification.xml is the last one, so readers never see a notification pointing to missing files
+	tmpFile := filepath.Join(dir, "notification.xml.tmp")
+	err := fileutil.WriteBytesToFile(tmpFile, c.notificationXml)
+	if err != nil {
+		belogs.Error("RrdpPublisher.WriteToDir(): WriteBytesToFile notification fail:", tmpFile, err)
+		return err
+	}
+	return os.Rename(tmpFile, filepath.Join(dir, "notification.xml"))
+}
+
+// ServeHTTP serves notification.xml, snapshot.xml and delta.xml, the path of baseUrl is stripped
+func (c *RrdpPublisher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	path := r.URL.Path
+	if u, err := url.Parse(c.baseUrl); err == nil {
+		path = strings.TrimPrefix(path, strings.TrimSuffix(u.Path, "/"))
+	}
+	path = strings.TrimPrefix(path, "/")
+
+	c.mutex.RLock()
+	body := c.getFileByPath(path)
+	c.mutex.RUnlock()
+	if body == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	if path == "notification.xml" {
+		w.Header().Set("Cache-Control", "max-age=60")
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(body))
+}
+
+func (c *RrdpPublisher) getFileByPath(path string) []byte {
+	if c.serial == 0 {
+		return nil
+	}
+	if path == "notification.xml" {
+		return c.notificationXml
+	}
+	if path == c.getSnapshotPath() {
+		return c.snapshotXml
+	}
+	for _, d := range c.deltas {
+		if path == c.getDeltaPath(d.serial) {
+			return d.deltaXml
+		}
+	}
+	return nil
+}
+
+func (c *RrdpPublisher) getSnapshotPath() string {
+	return c.sessionId + "/" + convert.ToString(c.serial) + "/snapshot.xml"
+}
+
+func (c *RrdpPublisher) getDeltaPath(serial uint64) string {
+	return c.sessionId + "/" + convert.ToString(serial) + "/delta.xml"
+}
+
+// RFC 8182 3.3.2: keep at most maxDeltas, and total size of deltas should not be bigger than snapshot
+func (c *RrdpPublisher) pruneDeltas() {
+	if len(c.deltas) > c.maxDeltas {
+		c.deltas = c.deltas[:c.maxDeltas]
+	}
+	total := 0
+	for i, d := range c.deltas {
+		total += len(d.deltaXml)
+		if total > len(c.snapshotXml) {
+			belogs.Debug("RrdpPublisher.pruneDeltas(): deltas is bigger than snapshot, keep:", i)
+			c.deltas = c.deltas[:i]
+			break
+		}
+	}
+}
+
+func (c *RrdpPublisher) buildNotificationXml() []byte {
+	notificationModel := NotificationModel{
+		Xmlns:     RRDP_XMLNS,
+		Version:   RRDP_VERSION,
+		SessionId: c.sessionId,
+		Serial:    c.serial,
+		Snapshot: NotificationSnapshot{
+			Uri:  c.baseUrl + "/" + c.getSnapshotPath(),
+			Hash: hashutil.Sha256(c.snapshotXml),
+		},
+		Deltas: make([]NotificationDelta, 0, len(c.deltas)),
+	}
+	for _, d := range c.deltas {
+		notificationModel.Deltas = append(notificationModel.Deltas, NotificationDelta{
+			Serial: d.serial,
+			Uri:    c.baseUrl + "/" + c.getDeltaPath(d.serial),
+			Hash:   d.hash,
+		})
+	}
+	b, _ := xml.MarshalIndent(notificationModel, "", "  ")
+	return b
+}
+
+func (c *RrdpPublisher) buildSnapshotXml() []byte {
+	var buf bytes.Buffer
+	writeRrdpRootStart(&buf, "snapshot", c.sessionId, c.serial)
+	for _, uri := range sortedRrdpUris(c.objects) {
+		writeRrdpPublish(&buf, uri, "", c.objects[uri])
+	}
+	buf.WriteString("</snapshot>\n")
+	return buf.Bytes()
+}
+
+// returns nil when no changes
+func (c *RrdpPublisher) buildDeltaXml(serial uint64, objects map[string][]byte) []byte {
+	var buf bytes.Buffer
+	writeRrdpRootStart(&buf, "delta", c.sessionId, serial)
+	changes := 0
+	for _, uri := range sortedRrdpUris(objects) {
+		old, ok := c.objects[uri]
+		if !ok {
+			writeRrdpPublish(&buf, uri, "", objects[uri])
+			changes++
+		} else if !bytes.Equal(old, objects[uri]) {
+			writeRrdpPublish(&buf, uri, hashutil.Sha256(old), objects[uri])
+			changes++
+		}
+	}
+	for _, uri := range sortedRrdpUris(c.objects) {
+		if _, ok := objects[uri]; !ok {
+			buf.WriteString(`  <withdraw uri="`)
+			xml.EscapeText(&buf, []byte(uri))
+			buf.WriteString(`" hash="` + hashutil.Sha256(c.objects[uri]) + "\"/>\n")
+			changes++
+		}
+	}
+	if changes == 0 {
+		return nil
+	}
+	buf.WriteString("</delta>\n")
+	return buf.Bytes()
+}
+
+func writeRrdpRootStart(buf *bytes.Buffer, name, sessionId string, serial uint64) {
+	buf.WriteString(`<` + name + ` xmlns="` + RRDP_XMLNS + `" version="` + RRDP_VERSION +
+		`" session_id="` + sessionId + `" serial="` + convert.ToString(serial) + "\">\n")
+}
+
+func writeRrdpPublish(buf *bytes.Buffer, uri, hash string, b []byte) {
+	buf.WriteString(`  <publish uri="`)
+	xml.EscapeText(buf, []byte(uri))
+	buf.WriteString(`"`)
+	if len(hash) > 0 {
+		buf.WriteString(` hash="` + hash + `"`)
+	}
+	buf.WriteString(">")
+	buf.WriteString(base64util.EncodeBase64(b))
+	buf.WriteString("</publish>\n")
+}
+
+func sortedRrdpUris(objects map[string][]byte) []string {
+	uris := make([]string, 0, len(objects))
+	for uri := range objects {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	return uris
+}
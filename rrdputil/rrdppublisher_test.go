@@ -0,0 +1,168 @@
+package rrdputil
+
+import (
+	"bytes"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cpusoft/goutil/httpclient"
+	"github.com/cpusoft/goutil/xmlutil"
+)
+
+func TestRrdpPublisher(t *testing.T) {
+	var publisher *RrdpPublisher
+	ts := httptest.NewServer(nil)
+	defer ts.Close()
+	publisher = NewRrdpPublisher(ts.URL+"/rrdp", 10)
+	ts.Config.Handler = publisher
+
+	repoPath := t.TempDir()
+	syncer := NewRrdpSyncer(t.TempDir(), repoPath, httpclient.NewHttpClientConfig())
+
+	_, err := publisher.Publish(map[string][]byte{
+		"rsync://example.com/repo/a.cer": []byte("a1"),
+		"rsync://example.com/repo/b.roa": []byte("b1"),
+		"rsync://example.com/repo/d.mft": bytes.Repeat([]byte("d"), 1000),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	changeSet, err := syncer.Sync(publisher.NotificationUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(changeSet)
+	if changeSet.SyncMode != RRDP_SYNC_MODE_SNAPSHOT || len(changeSet.Added) != 3 {
+		t.Fatal("should use snapshot")
+	}
+
+	changed, err := publisher.Publish(map[string][]byte{
+		"rsync://example.com/repo/a.cer": []byte("a2"),
+		"rsync://example.com/repo/c.crl": []byte("c1"),
+		"rsync://example.com/repo/d.mft": bytes.Repeat([]byte("d"), 1000),
+	})
+	if err != nil || !changed || publisher.Serial() != 2 {
+		t.Fatal("publish should change", err)
+	}
+	changed, _ = publisher.Publish(map[string][]byte{
+		"rsync://example.com/repo/a.cer": []byte("a2"),
+		"rsync://example.com/repo/c.crl": []byte("c1"),
+		"rsync://example.com/repo/d.mft": bytes.Repeat([]byte("d"), 1000),
+	})
+	if changed {
+		t.Fatal("same objects should not change")
+	}
+	changeSet, err = syncer.Sync(publisher.NotificationUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(changeSet)
+	if changeSet.SyncMode != RRDP_SYNC_MODE_DELTA || len(changeSet.Added) != 1 ||
+		len(changeSet.Updated) != 1 || len(changeSet.Withdrawn) != 1 {
+		t.Fatal("should use delta")
+	}
+	b, _ := os.ReadFile(filepath.Join(repoPath, "example.com/repo/a.cer"))
+	if string(b) != "a2" {
+		t.Fatal("a.cer should be updated")
+	}
+
+	// local file is changed, delta conflicts, so will use snapshot
+	os.WriteFile(filepath.Join(repoPath, "example.com/repo/a.cer"), []byte("changed"), 0644)
+	publisher.Publish(map[string][]byte{
+		"rsync://example.com/repo/a.cer": []byte("a3"),
+		"rsync://example.com/repo/c.crl": []byte("c1"),
+		"rsync://example.com/repo/d.mft": bytes.Repeat([]byte("d"), 1000),
+	})
+	changeSet, err = syncer.Sync(publisher.NotificationUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(changeSet)
+	if changeSet.SyncMode != RRDP_SYNC_MODE_SNAPSHOT || len(changeSet.Updated) != 1 {
+		t.Fatal("conflict should use snapshot")
+	}
+
+	dir := t.TempDir()
+	if err = publisher.WriteToDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	b, _ = os.ReadFile(filepath.Join(dir, "notification.xml"))
+	notificationModel := NotificationModel{}
+	if err = xmlutil.UnmarshalXml(string(b), &notificationModel); err != nil || len(notificationModel.Deltas) != 2 {
+		t.Fatal("notification.xml is wrong", err)
+	}
+	fmt.Println(string(b))
+}
+
+func TestRrdpPublisherPruneDeltas(t *testing.T) {
+	publisher := NewRrdpPublisher("https://rrdp.example.com/rrdp", 2)
+	for i := 0; i < 5; i++ {
+		publisher.Publish(map[string][]byte{
+			"rsync://example.com/repo/a.cer": []byte{byte(i)},
+			"rsync://example.com/repo/b.cer": bytes.Repeat([]byte("b"), 1000),
+		})
+	}
+	fmt.Println(string(publisher.notificationXml))
+	if publisher.Serial() != 5 || len(publisher.deltas) != 2 || publisher.deltas[0].serial != 5 {
+		t.Fatal("prune fail")
+	}
+}
+
+func TestLoadRrdpPublisher(t *testing.T) {
+	ts := httptest.NewServer(nil)
+	defer ts.Close()
+	publisher := NewRrdpPublisher(ts.URL+"/rrdp", 10)
+	ts.Config.Handler = publisher
+	publisher.Publish(map[string][]byte{
+		"rsync://example.com/repo/a.cer": []byte("a1"),
+		"rsync://example.com/repo/d.mft": bytes.Repeat([]byte("d"), 1000),
+	})
+	publisher.Publish(map[string][]byte{
+		"rsync://example.com/repo/a.cer": []byte("a2"),
+		"rsync://example.com/repo/d.mft": bytes.Repeat([]byte("d"), 1000),
+	})
+	dir := t.TempDir()
+	if err := publisher.WriteToDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	syncer := NewRrdpSyncer(t.TempDir(), t.TempDir(), httpclient.NewHttpClientConfig())
+	if _, err := syncer.Sync(publisher.NotificationUrl()); err != nil {
+		t.Fatal(err)
+	}
+
+	// restart
+	loaded, err := LoadRrdpPublisher(ts.URL+"/rrdp", 10, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.Config.Handler = loaded
+	if loaded.SessionId() != publisher.SessionId() || loaded.Serial() != 2 || len(loaded.deltas) != 1 ||
+		!bytes.Equal(loaded.objects["rsync://example.com/repo/a.cer"], []byte("a2")) {
+		t.Fatal("loaded publisher is wrong")
+	}
+	changed, err := loaded.Publish(map[string][]byte{
+		"rsync://example.com/repo/a.cer": []byte("a3"),
+		"rsync://example.com/repo/d.mft": bytes.Repeat([]byte("d"), 1000),
+	})
+	if err != nil || !changed || loaded.Serial() != 3 || len(loaded.deltas) != 2 {
+		t.Fatal("publish after restart is wrong", err)
+	}
+	changeSet, err := syncer.Sync(loaded.NotificationUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(changeSet)
+	if changeSet.SyncMode != RRDP_SYNC_MODE_DELTA || len(changeSet.Updated) != 1 || len(changeSet.Added) != 0 {
+		t.Fatal("should use only delta after restart")
+	}
+
+	// snapshot is changed
+	snapshotFile := filepath.Join(dir, filepath.FromSlash(publisher.getSnapshotPath()))
+	os.WriteFile(snapshotFile, []byte("changed"), 0644)
+	if _, err = LoadRrdpPublisher(ts.URL+"/rrdp", 10, dir); err == nil {
+		t.Fatal("hash of snapshot should be checked")
+	}
+}
@@ -0,0 +1,313 @@
+package rrdputil
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cpusoft/goutil/base64util"
+	"github.com/cpusoft/goutil/belogs"
+	"github.com/cpusoft/goutil/convert"
+	"github.com/cpusoft/goutil/fileutil"
+	"github.com/cpusoft/goutil/httpclient"
+	"github.com/cpusoft/goutil/jsonutil"
+	"github.com/cpusoft/goutil/osutil"
+	"github.com/cpusoft/goutil/urlutil"
+	"github.com/orisano/gosax/xmlb"
+)
+
+// SnapshotPublishFunc is called once for every <publish> in snapshot.xml.
+// snapshotModel only has the attributes of <snapshot>, SnapshotPublishs is always empty,
+// snapshotPublish is only valid during the call.
+// if it returns error, parsing will stop and return this error
+type SnapshotPublishFunc func(snapshotModel *SnapshotModel, snapshotPublish *SnapshotPublish) error
+
+// ParseRrdpSnapshotStream reads snapshot.xml from reader, and calls fn for every publish,
+// so the whole snapshot never needs to be in memory.
+// The sha256 of all read bytes is saved in snapshotModel.Hash, if expectHash is not empty
+// (such as notificationModel.Snapshot.Hash), the two hashes must be equal.
+func ParseRrdpSnapshotStream(reader io.Reader, expectHash string,
+	fn SnapshotPublishFunc) (snapshotModel *SnapshotModel, err error) {
+	start := time.Now()
+	if reader == nil || fn == nil {
+		belogs.Error("ParseRrdpSnapshotStream(): reader or fn is nil")
+		return nil, errors.New("reader or fn is nil")
+	}
+
+	h := sha256.New()
+	counter := &countReader{reader: io.TeeReader(reader, h)}
+	buf := make([]byte, 0, 4096)
+	dec := xmlb.NewDecoder(counter, buf)
+
+	snapshotModel = &SnapshotModel{}
+	var currentPub *SnapshotPublish
+	var charBuf strings.Builder
+	inPublish := false
+	foundSnapshot := false
+	count := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			belogs.Error("ParseRrdpSnapshotStream(): Token fail, count:", count, err)
+			return nil, err
+		}
+
+		switch tok.Type() {
+		case xmlb.StartElement:
+			elem, _ := tok.StartElement()
+			switch elem.Name.Local {
+			case "snapshot":
+				foundSnapshot = true
+				for _, attr := range elem.Attr {
+					switch attr.Name.Local {
+					case "xmlns":
+						snapshotModel.Xmlns = attr.Value
+					case "version":
+						snapshotModel.Version = attr.Value
+					case "session_id":
+						snapshotModel.SessionId = attr.Value
+					case "serial":
+						serial, err := strconv.ParseUint(strings.TrimSpace(attr.Value), 10, 64)
+						if err != nil {
+							belogs.Error("ParseRrdpSnapshotStream(): serial is illegal:", attr.Value, err)
+							return nil, errors.New("serial of snapshot is illegal: " + attr.Value)
+						}
+						snapshotModel.Serial = serial
+					}
+				}
+			case "publish":
+				if !foundSnapshot {
+					belogs.Error("ParseRrdpSnapshotStream(): publish is before snapshot")
+					return nil, errors.New("body is not snapshot xml")
+				}
+				inPublish = true
+				currentPub = &SnapshotPublish{}
+				for _, attr := range elem.Attr {
+					if attr.Name.Local == "uri" {
+						currentPub.Uri = attr.Value
+					}
+				}
+				charBuf.Reset()
+			}
+
+		case xmlb.EndElement:
+			elem := tok.EndElement()
+			if elem.Name.Local == "publish" && currentPub != nil {
+				currentPub.Uri = strings.Replace(currentPub.Uri, "../", "/", -1) //fix Path traversal
+				currentPub.Base64 = base64util.TrimBase64(charBuf.String())
+				charBuf.Reset()
+				if err = fn(snapshotModel, currentPub); err != nil {
+					belogs.Error("ParseRrdpSnapshotStream(): fn fail, uri:", currentPub.Uri, err)
+					return nil, err
+				}
+				count++
+				currentPub = nil
+				inPublish = false
+			}
+
+		case xmlb.CharData:
+			if inPublish {
+				data, _ := tok.CharData()
+				charBuf.Write(data)
+			}
+		}
+	}
+	if !foundSnapshot {
+		belogs.Error("ParseRrdpSnapshotStream(): not found snapshot, len:", counter.count)
+		return nil, errors.New("body is not snapshot xml")
+	}
+	// make sure hash covers the whole body, even if decoder stops before the end
+	if _, err = io.Copy(io.Discard, counter); err != nil {
+		belogs.Error("ParseRrdpSnapshotStream(): read rest of body fail:", err)
+		return nil, err
+	}
+	snapshotModel.Hash = hex.EncodeToString(h.Sum(nil))
+	if len(expectHash) > 0 && !strings.EqualFold(expectHash, snapshotModel.Hash) {
+		belogs.Error("ParseRrdpSnapshotStream(): hash is not equal, expectHash:", expectHash,
+			"   snapshotModel.Hash:", snapshotModel.Hash)
+		return snapshotModel, errors.New("snapshot's hash is different from notification's snapshot's hash")
+	}
+	belogs.Debug("ParseRrdpSnapshotStream(): len:", counter.count, "  count:", count,
+		"  snapshotModel:", snapshotModel.String(), "  time(s):", time.Since(start))
+	return snapshotModel, nil
+}
+
+// GetRrdpSnapshotStreamWithConfig downloads snapshotUrl and parses the body while downloading,
+// see ParseRrdpSnapshotStream.
+func GetRrdpSnapshotStreamWithConfig(snapshotUrl string, expectHash string,
+	httpClientConfig *httpclient.HttpClientConfig, fn SnapshotPublishFunc) (snapshotModel *SnapshotModel, err error) {
+	start := time.Now()
+	if httpClientConfig == nil {
+		httpClientConfig = httpclient.NewHttpClientConfig()
+	}
+	snapshotUrl = strings.TrimSpace(snapshotUrl)
+	belogs.Debug("GetRrdpSnapshotStreamWithConfig(): snapshotUrl:", snapshotUrl, "  httpClientConfig:", jsonutil.MarshalJson(httpClientConfig))
+
+	body, err := openRrdpUrl(snapshotUrl, httpClientConfig)
+	if err != nil {
+		belogs.Error("GetRrdpSnapshotStreamWithConfig(): openRrdpUrl fail:", snapshotUrl, err)
+		return nil, err
+	}
+	defer body.Close()
+
+	snapshotModel, err = ParseRrdpSnapshotStream(body, expectHash, fn)
+	if err != nil {
+		belogs.Error("GetRrdpSnapshotStreamWithConfig(): ParseRrdpSnapshotStream fail:", snapshotUrl, err)
+		return snapshotModel, err
+	}
+	snapshotModel.SnapshotUrl = snapshotUrl
+	belogs.Debug("GetRrdpSnapshotStreamWithConfig(): snapshotUrl ok:", snapshotUrl, "  time(s):", time.Since(start))
+	return snapshotModel, nil
+}
+
+// SaveRrdpSnapshotStreamToRrdpFiles works as SaveRrdpSnapshotToRrdpFiles, but every publish
+// is written to disk once it is parsed.
+// files are written before the hash can be checked, so if err is not nil, repoPath may be
+// partly updated, caller should use a temporary repoPath when needed.
+//
+// repoPath --> conf.String("rrdp::reporrdp"): /root/rpki/data/reporrdp
+func SaveRrdpSnapshotStreamToRrdpFiles(reader io.Reader, snapshotUrl string, expectHash string,
+	repoPath string) (snapshotModel *SnapshotModel, rrdpFiles []RrdpFile, err error) {
+	start := time.Now()
+	belogs.Debug("SaveRrdpSnapshotStreamToRrdpFiles(): snapshotUrl:", snapshotUrl, "  repoPath:", repoPath)
+
+	duplicateFilePathNames := make(map[string]struct{})
+	rrdpFiles = make([]RrdpFile, 0)
+	fn := func(snapshotModel *SnapshotModel, snapshotPublish *SnapshotPublish) error {
+		rrdpFile, err := saveSnapshotPublishToFile(snapshotModel, snapshotPublish, snapshotUrl,
+			repoPath, duplicateFilePathNames)
+		if err != nil {
+			return err
+		}
+		if rrdpFile != nil {
+			rrdpFiles = append(rrdpFiles, *rrdpFile)
+		}
+		return nil
+	}
+	snapshotModel, err = ParseRrdpSnapshotStream(reader, expectHash, fn)
+	if err != nil {
+		belogs.Error("SaveRrdpSnapshotStreamToRrdpFiles(): ParseRrdpSnapshotStream fail:", snapshotUrl, err)
+		return snapshotModel, nil, err
+	}
+	snapshotModel.SnapshotUrl = snapshotUrl
+	belogs.Debug("SaveRrdpSnapshotStreamToRrdpFiles(): save len(rrdpFiles):", len(rrdpFiles), "  time(s):", time.Since(start))
+	return snapshotModel, rrdpFiles, nil
+}
+
+// if filePathName is duplicate, will return nil,nil
+func saveSnapshotPublishToFile(snapshotModel *SnapshotModel, snapshotPublish *SnapshotPublish,
+	snapshotUrl, repoPath string, duplicateFilePathNames map[string]struct{}) (*RrdpFile, error) {
+	uri := snapshotPublish.Uri
+	filePathName, err := getRrdpFilePathName(repoPath, uri)
+	if err != nil {
+		belogs.Error("saveSnapshotPublishToFile(): getRrdpFilePathName fail:", uri, "    snapshotUrl:", snapshotUrl, err)
+		return nil, err
+	}
+	if _, ok := duplicateFilePathNames[filePathName]; ok {
+		belogs.Error("saveSnapshotPublishToFile(): duplicate file in snapshot, filePathName:", filePathName,
+			"   snapshotUrl:", snapshotUrl)
+		return nil, nil
+	}
+	duplicateFilePathNames[filePathName] = struct{}{}
+
+	bytes, err := base64util.DecodeBase64(snapshotPublish.Base64)
+	if err != nil {
+		belogs.Error("saveSnapshotPublishToFile(): DecodeBase64 fail, uri:", uri, "    snapshotUrl:", snapshotUrl, err)
+		return nil, err
+	}
+	err = saveRrdpBytesToFile(filePathName, bytes)
+	if err != nil {
+		belogs.Error("saveSnapshotPublishToFile(): saveRrdpBytesToFile fail:", filePathName,
+			len(bytes), "    snapshotUrl:", snapshotUrl, err)
+		return nil, err
+	}
+	belogs.Debug("saveSnapshotPublishToFile(): save filePathName ", filePathName, "  ok")
+	dir, file := osutil.Split(filePathName)
+	return &RrdpFile{
+		FilePath:  dir,
+		FileName:  file,
+		FileUri:   uri,
+		SyncType:  "add",
+		SourceUrl: snapshotUrl,
+		Serial:    snapshotModel.Serial,
+	}, nil
+}
+
+// getRrdpFilePathName joins repoPath and uri, and checks length of path and file name
+func getRrdpFilePathName(repoPath, uri string) (string, error) {
+	filePathName, err := urlutil.JoinPrefixPathAndUrlFileName(repoPath, uri)
+	if err != nil {
+		return "", err
+	}
+	dir, file := osutil.Split(filePathName)
+	if !fileutil.CheckPathNameMaxLength(dir) {
+		return "", errors.New("path name is too long: " + dir)
+	}
+	if !fileutil.CheckFileNameMaxLength(file) {
+		return "", errors.New("file name is too long: " + file)
+	}
+	return filePathName, nil
+}
+
+// saveRrdpBytesToFile makes dir when not exists, then writes bytes to filePathName
+func saveRrdpBytesToFile(filePathName string, bytes []byte) error {
+	dir, _ := osutil.Split(filePathName)
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteBytesToFile(filePathName, bytes)
+}
+
+// openRrdpUrl returns the body of url, caller must close it
+func openRrdpUrl(urlStr string, httpClientConfig *httpclient.HttpClientConfig) (io.ReadCloser, error) {
+	timeout := time.Duration(httpClientConfig.TimeoutMins) * time.Minute
+	if httpClientConfig.TimeoutMillis > 0 {
+		timeout = time.Duration(httpClientConfig.TimeoutMillis) * time.Millisecond
+	}
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: !httpClientConfig.VerifyHttps},
+		},
+	}
+	req, err := http.NewRequest(http.MethodGet, urlStr, nil)
+	if err != nil {
+		belogs.Error("openRrdpUrl(): NewRequest fail:", urlStr, err)
+		return nil, err
+	}
+	req.Header.Set("User-Agent", httpclient.DefaultUserAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		belogs.Error("openRrdpUrl(): Do fail:", urlStr, err)
+		return nil, errors.New("http error of " + urlStr + " is " + err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		belogs.Error("openRrdpUrl(): statusCode is not StatusOK:", urlStr, "   statusCode:", resp.StatusCode)
+		return nil, errors.New("http status code of " + urlStr + " is " + convert.ToString(resp.StatusCode))
+	}
+	return resp.Body, nil
+}
+
+type countReader struct {
+	reader io.Reader
+	count  uint64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.count += uint64(n)
+	return n, err
+}
@@ -0,0 +1,90 @@
+package rrdputil
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cpusoft/goutil/base64util"
+	"github.com/cpusoft/goutil/hashutil"
+	"github.com/cpusoft/goutil/httpclient"
+)
+
+func getTestSnapshotXml() string {
+	return `<snapshot xmlns="http://www.ripe.net/rpki/rrdp" version="1" session_id="9df4b597-af9e-4dca-bdda-719cce2c4e28" serial="3">
+<publish uri="rsync://example.com/repo/a.cer">` + base64util.EncodeBase64([]byte("aaa")) + `</publish>
+<publish uri="rsync://example.com/repo/sub/b.roa">
+` + base64util.EncodeBase64([]byte("bbbb")) + `
+</publish>
+</snapshot>`
+}
+
+func TestParseRrdpSnapshotStream(t *testing.T) {
+	body := getTestSnapshotXml()
+	uris := make([]string, 0)
+	snapshotModel, err := ParseRrdpSnapshotStream(strings.NewReader(body), hashutil.Sha256([]byte(body)),
+		func(snapshotModel *SnapshotModel, snapshotPublish *SnapshotPublish) error {
+			uris = append(uris, snapshotPublish.Uri)
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(snapshotModel, uris)
+	if snapshotModel.Serial != 3 || len(uris) != 2 || len(snapshotModel.SnapshotPublishs) != 0 {
+		t.Fatal("parse fail")
+	}
+
+	_, err = ParseRrdpSnapshotStream(strings.NewReader(body), "00",
+		func(snapshotModel *SnapshotModel, snapshotPublish *SnapshotPublish) error { return nil })
+	fmt.Println("wrong hash:", err)
+	if err == nil {
+		t.Fatal("should fail when hash is different")
+	}
+
+	body = strings.Replace(body, `serial="3"`, `serial="3a"`, 1)
+	_, err = ParseRrdpSnapshotStream(strings.NewReader(body), "",
+		func(snapshotModel *SnapshotModel, snapshotPublish *SnapshotPublish) error { return nil })
+	fmt.Println("wrong serial:", err)
+	if err == nil {
+		t.Fatal("should fail when serial is illegal")
+	}
+}
+
+func TestSaveRrdpSnapshotStreamToRrdpFiles(t *testing.T) {
+	body := getTestSnapshotXml()
+	repoPath := t.TempDir()
+	snapshotModel, rrdpFiles, err := SaveRrdpSnapshotStreamToRrdpFiles(strings.NewReader(body),
+		"https://example.com/snapshot.xml", "", repoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(snapshotModel, len(rrdpFiles))
+	b, err := os.ReadFile(filepath.Join(repoPath, "example.com/repo/sub/b.roa"))
+	if err != nil || string(b) != "bbbb" {
+		t.Fatal("save fail", err)
+	}
+}
+
+func TestGetRrdpSnapshotStreamWithConfig(t *testing.T) {
+	body := getTestSnapshotXml()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer ts.Close()
+
+	count := 0
+	snapshotModel, err := GetRrdpSnapshotStreamWithConfig(ts.URL+"/snapshot.xml", hashutil.Sha256([]byte(body)),
+		httpclient.NewHttpClientConfig(), func(snapshotModel *SnapshotModel, snapshotPublish *SnapshotPublish) error {
+			count++
+			return nil
+		})
+	if err != nil || count != 2 {
+		t.Fatal(err, count)
+	}
+	fmt.Println(snapshotModel)
+}
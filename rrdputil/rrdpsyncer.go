@@ -0,0 +1,441 @@
+package rrdputil
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cpusoft/goutil/base64util"
+	"github.com/cpusoft/goutil/belogs"
+	"github.com/cpusoft/goutil/convert"
+	"github.com/cpusoft/goutil/fileutil"
+	"github.com/cpusoft/goutil/hashutil"
+	"github.com/cpusoft/goutil/httpclient"
+	"github.com/cpusoft/goutil/jsonutil"
+	"github.com/cpusoft/goutil/osutil"
+)
+
+const (
+	RRDP_SYNC_MODE_NONE     = "none"
+	RRDP_SYNC_MODE_SNAPSHOT = "snapshot"
+	RRDP_SYNC_MODE_DELTA    = "delta"
+)
+
+// RrdpSyncState is saved on disk for every notification url, after every successful sync
+type RrdpSyncState struct {
+	NotificationUrl string `json:"notificationUrl"`
+	SessionId       string `json:"sessionId"`
+	Serial          uint64 `json:"serial"`
+	// map[uri]sha256 of the published file
+	UriHashs map[string]string `json:"uriHashs"`
+	SyncTime time.Time         `json:"syncTime"`
+}
+
+// RrdpChangeSet is the result of RrdpSyncer.Sync
+type RrdpChangeSet struct {
+	NotificationUrl string `json:"notificationUrl"`
+	// none/snapshot/delta
+	SyncMode string `json:"syncMode"`
+	// why use snapshot, when SyncMode is snapshot
+	SnapshotReason string `json:"snapshotReason"`
+	SessionId      string `json:"sessionId"`
+	LastSerial     uint64 `json:"lastSerial"`
+	Serial         uint64 `json:"serial"`
+
+	Added     []*RrdpFile `json:"added"`
+	Updated   []*RrdpFile `json:"updated"`
+	Withdrawn []*RrdpFile `json:"withdrawn"`
+}
+
+func (c RrdpChangeSet) String() string {
+	m := make(map[string]interface{})
+	m["notificationUrl"] = c.NotificationUrl
+	m["syncMode"] = c.SyncMode
+	m["snapshotReason"] = c.SnapshotReason
+	m["sessionId"] = c.SessionId
+	m["lastSerial"] = c.LastSerial
+	m["serial"] = c.Serial
+	m["len(added)"] = len(c.Added)
+	m["len(updated)"] = len(c.Updated)
+	m["len(withdrawn)"] = len(c.Withdrawn)
+	return jsonutil.MarshalJson(m)
+}
+
+// RrdpSyncer keeps repoPath up to date with notification urls,
+// and decides delta or snapshot by RFC 8182.
+type RrdpSyncer struct {
+	// dir to save RrdpSyncState of every notification url
+	statePath string
+	// dir to save rrdp files: /root/rpki/data/reporrdp
+	repoPath         string
+	httpClientConfig *httpclient.HttpClientConfig
+
+	// map[notificationUrl]*sync.Mutex
+	locks sync.Map
+}
+
+func NewRrdpSyncer(statePath, repoPath string, httpClientConfig *httpclient.HttpClientConfig) *RrdpSyncer {
+	if httpClientConfig == nil {
+		httpClientConfig = httpclient.NewHttpClientConfig()
+	}
+	return &RrdpSyncer{
+		statePath:        statePath,
+		repoPath:         repoPath,
+		httpClientConfig: httpClientConfig,
+	}
+}
+
+// LoadState returns nil, nil when notificationUrl has never been synced
+func (c *RrdpSyncer) LoadState(notificationUrl string) (*RrdpSyncState, error) {
+	stateFile := c.getStateFile(notificationUrl)
+	exist, err := osutil.IsExists(stateFile)
+	if err != nil {
+		belogs.Error("RrdpSyncer.LoadState(): IsExists fail:", stateFile, err)
+		return nil, err
+	}
+	if !exist {
+		return nil, nil
+	}
+	b, err := fileutil.ReadFileToBytes(stateFile)
+	if err != nil {
+		belogs.Error("RrdpSyncer.LoadState(): ReadFileToBytes fail:", stateFile, err)
+		return nil, err
+	}
+	state := &RrdpSyncState{}
+	err = jsonutil.UnmarshalJsonBytes(b, state)
+	if err != nil {
+		belogs.Error("RrdpSyncer.LoadState(): UnmarshalJsonBytes fail:", stateFile, err)
+		return nil, err
+	}
+	if state.UriHashs == nil {
+		state.UriHashs = make(map[string]string)
+	}
+	return state, nil
+}
+
+// SaveState writes to a temporary file first, then renames it
+func (c *RrdpSyncer) SaveState(state *RrdpSyncState) error {
+	if state == nil || len(state.NotificationUrl) == 0 {
+		return errors.New("state or notificationUrl is empty")
+	}
+	err := os.MkdirAll(c.statePath, os.ModePerm)
+	if err != nil {
+		belogs.Error("RrdpSyncer.SaveState(): MkdirAll fail:", c.statePath, err)
+		return err
+	}
+	stateFile := c.getStateFile(state.NotificationUrl)
+	tmpFile := stateFile + ".tmp"
+	err = fileutil.WriteBytesToFile(tmpFile, jsonutil.MarshalJsonBytes(state))
+	if err != nil {
+		belogs.Error("RrdpSyncer.SaveState(): WriteBytesToFile fail:", tmpFile, err)
+		return err
+	}
+	return os.Rename(tmpFile, stateFile)
+}
+
+// ResetState removes saved state, so next Sync will use snapshot
+func (c *RrdpSyncer) ResetState(notificationUrl string) error {
+	err := os.Remove(c.getStateFile(notificationUrl))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (c *RrdpSyncer) getStateFile(notificationUrl string) string {
+	return filepath.Join(c.statePath, hashutil.Sha256([]byte(notificationUrl))+".json")
+}
+
+func (c *RrdpSyncer) lock(notificationUrl string) func() {
+	l, _ := c.locks.LoadOrStore(notificationUrl, &sync.Mutex{})
+	m := l.(*sync.Mutex)
+	m.Lock()
+	return m.Unlock
+}
+
+// Sync gets notification.xml, then applies deltas or snapshot to repoPath.
+// When deltas cannot be used, it will fall back to snapshot.
+func (c *RrdpSyncer) Sync(notificationUrl string) (changeSet *RrdpChangeSet, err error) {
+	start := time.Now()
+	notificationUrl = strings.TrimSpace(notificationUrl)
+	defer c.lock(notificationUrl)()
+	belogs.Debug("RrdpSyncer.Sync(): notificationUrl:", notificationUrl, "   repoPath:", c.repoPath)
+
+	state, err := c.LoadState(notificationUrl)
+	if err != nil {
+		belogs.Error("RrdpSyncer.Sync(): LoadState fail:", notificationUrl, err)
+		return nil, err
+	}
+	notificationModel, err := GetAndCheckRrdpNotificationWithConfig(notificationUrl, c.httpClientConfig)
+	if err != nil {
+		belogs.Error("RrdpSyncer.Sync(): GetAndCheckRrdpNotificationWithConfig fail:", notificationUrl, err)
+		return nil, err
+	}
+
+	reason := getRrdpSnapshotReason(state, &notificationModel)
+	if len(reason) == 0 {
+		if notificationModel.Serial == state.Serial {
+			belogs.Debug("RrdpSyncer.Sync(): serial is not changed, notificationUrl:", notificationUrl,
+				"   serial:", state.Serial)
+			return &RrdpChangeSet{
+				NotificationUrl: notificationUrl,
+				SyncMode:        RRDP_SYNC_MODE_NONE,
+				SessionId:       state.SessionId,
+				LastSerial:      state.Serial,
+				Serial:          state.Serial,
+			}, nil
+		}
+
+		changeSet, err = c.syncDeltas(state, &notificationModel)
+		if err == nil {
+			belogs.Info("RrdpSyncer.Sync(): syncDeltas ok, changeSet:", changeSet.String(), "  time(s):", time.Since(start))
+			return changeSet, nil
+		}
+		var conflictErr *RrdpDeltaConflictError
+		if errors.As(err, &conflictErr) {
+			reason = "deltas conflict with local files: " + err.Error()
+		} else {
+			reason = "apply deltas fail: " + err.Error()
+		}
+		belogs.Info("RrdpSyncer.Sync(): syncDeltas fail, will use snapshot, notificationUrl:", notificationUrl, err)
+	}
+
+	changeSet, err = c.syncSnapshot(state, &notificationModel)
+	if err != nil {
+		belogs.Error("RrdpSyncer.Sync(): syncSnapshot fail:", notificationUrl, "   reason:", reason, err)
+		return nil, err
+	}
+	changeSet.SnapshotReason = reason
+	belogs.Info("RrdpSyncer.Sync(): syncSnapshot ok, changeSet:", changeSet.String(), "  time(s):", time.Since(start))
+	return changeSet, nil
+}
+
+// getRrdpSnapshotReason returns empty when deltas can be used
+func getRrdpSnapshotReason(state *RrdpSyncState, notificationModel *NotificationModel) string {
+	if state == nil {
+		return "no local state"
+	}
+	if state.SessionId != notificationModel.SessionId {
+		return "session_id changed from " + state.SessionId + " to " + notificationModel.SessionId
+	}
+	if notificationModel.Serial < state.Serial {
+		return "serial decreased from " + convert.ToString(state.Serial) + " to " + convert.ToString(notificationModel.Serial)
+	}
+	for serial := state.Serial + 1; serial <= notificationModel.Serial; serial++ {
+		if _, ok := notificationModel.MapSerialDeltas[serial]; !ok {
+			return "delta of serial " + convert.ToString(serial) + " is missing"
+		}
+	}
+	return ""
+}
+
+func (c *RrdpSyncer) syncDeltas(state *RrdpSyncState, notificationModel *NotificationModel) (*RrdpChangeSet, error) {
+	deltaModels, err := GetRrdpDeltasWithConfig(notificationModel, state.Serial, c.httpClientConfig)
+	if err != nil {
+		belogs.Error("RrdpSyncer.syncDeltas(): GetRrdpDeltasWithConfig fail:", notificationModel.NotificationUrl, err)
+		return nil, err
+	}
+	// apply from older to newer
+	sort.Sort(DeltaModelsSort(deltaModels))
+	for i := range deltaModels {
+		expectSerial := state.Serial + uint64(i) + 1
+		if deltaModels[i].Serial != expectSerial {
+			return nil, errors.New("delta serial is " + convert.ToString(deltaModels[i].Serial) +
+				", but expect " + convert.ToString(expectSerial))
+		}
+		for j := range notificationModel.Deltas {
+			if notificationModel.Deltas[j].Serial == deltaModels[i].Serial &&
+				!strings.EqualFold(notificationModel.Deltas[j].Hash, deltaModels[i].Hash) {
+				return nil, errors.New("hash of delta " + deltaModels[i].DeltaUrl + " is different from notification")
+			}
+		}
+	}
+
+	rrdpFiles, err := ConvertDeltasToRrdpFilesWithVerify(deltaModels, notificationModel.NotificationUrl, c.repoPath)
+	if err != nil {
+		belogs.Error("RrdpSyncer.syncDeltas(): ConvertDeltasToRrdpFilesWithVerify fail:", notificationModel.NotificationUrl, err)
+		return nil, err
+	}
+
+	changeSet := &RrdpChangeSet{
+		NotificationUrl: notificationModel.NotificationUrl,
+		SyncMode:        RRDP_SYNC_MODE_DELTA,
+		SessionId:       notificationModel.SessionId,
+		LastSerial:      state.Serial,
+		Serial:          notificationModel.Serial,
+	}
+	// decode all before touching disk, so bad base64 will not leave repoPath half updated
+	publishBytes := make(map[string][]byte, len(rrdpFiles))
+	for _, rrdpFile := range rrdpFiles {
+		if rrdpFile.DeltaPublish == nil {
+			continue
+		}
+		b, err := base64util.DecodeBase64(rrdpFile.DeltaPublish.Base64)
+		if err != nil {
+			belogs.Error("RrdpSyncer.syncDeltas(): DecodeBase64 fail, uri:", rrdpFile.FileUri, err)
+			return nil, err
+		}
+		publishBytes[rrdpFile.FileUri] = b
+	}
+
+	uriHashs := make(map[string]string, len(state.UriHashs))
+	for k, v := range state.UriHashs {
+		uriHashs[k] = v
+	}
+	for _, rrdpFile := range rrdpFiles {
+		filePathName := filepath.Join(rrdpFile.FilePath, rrdpFile.FileName)
+		if rrdpFile.DeltaWithdraw != nil {
+			err = os.Remove(filePathName)
+			if err != nil && !os.IsNotExist(err) {
+				belogs.Error("RrdpSyncer.syncDeltas(): Remove fail:", filePathName, err)
+				return nil, err
+			}
+			delete(uriHashs, rrdpFile.FileUri)
+			changeSet.Withdrawn = append(changeSet.Withdrawn, rrdpFile)
+			continue
+		}
+		b := publishBytes[rrdpFile.FileUri]
+		err = saveRrdpBytesToFile(filePathName, b)
+		if err != nil {
+			belogs.Error("RrdpSyncer.syncDeltas(): saveRrdpBytesToFile fail:", filePathName, err)
+			return nil, err
+		}
+		if _, ok := uriHashs[rrdpFile.FileUri]; ok {
+			rrdpFile.SyncType = "update"
+			changeSet.Updated = append(changeSet.Updated, rrdpFile)
+		} else {
+			changeSet.Added = append(changeSet.Added, rrdpFile)
+		}
+		uriHashs[rrdpFile.FileUri] = hashutil.Sha256(b)
+	}
+
+	err = c.SaveState(&RrdpSyncState{
+		NotificationUrl: notificationModel.NotificationUrl,
+		SessionId:       notificationModel.SessionId,
+		Serial:          notificationModel.Serial,
+		UriHashs:        uriHashs,
+		SyncTime:        time.Now(),
+	})
+	if err != nil {
+		belogs.Error("RrdpSyncer.syncDeltas(): SaveState fail:", notificationModel.NotificationUrl, err)
+		return nil, err
+	}
+	return changeSet, nil
+}
+
+func (c *RrdpSyncer) syncSnapshot(state *RrdpSyncState, notificationModel *NotificationModel) (*RrdpChangeSet, error) {
+	changeSet := &RrdpChangeSet{
+		NotificationUrl: notificationModel.NotificationUrl,
+		SyncMode:        RRDP_SYNC_MODE_SNAPSHOT,
+		SessionId:       notificationModel.SessionId,
+		Serial:          notificationModel.Serial,
+	}
+	oldUriHashs := make(map[string]string)
+	if state != nil {
+		changeSet.LastSerial = state.Serial
+		oldUriHashs = state.UriHashs
+	}
+
+	uriHashs := make(map[string]string, len(oldUriHashs))
+	fn := func(snapshotModel *SnapshotModel, snapshotPublish *SnapshotPublish) error {
+		uri := snapshotPublish.Uri
+		if _, ok := uriHashs[uri]; ok {
+			belogs.Error("RrdpSyncer.syncSnapshot(): duplicate uri in snapshot:", uri)
+			return nil
+		}
+		b, err := base64util.DecodeBase64(snapshotPublish.Base64)
+		if err != nil {
+			belogs.Error("RrdpSyncer.syncSnapshot(): DecodeBase64 fail, uri:", uri, err)
+			return err
+		}
+		hash := hashutil.Sha256(b)
+		uriHashs[uri] = hash
+		filePathName, err := getRrdpFilePathName(c.repoPath, uri)
+		if err != nil {
+			belogs.Error("RrdpSyncer.syncSnapshot(): getRrdpFilePathName fail, uri:", uri, err)
+			return err
+		}
+		oldHash, ok := oldUriHashs[uri]
+		if ok && oldHash == hash {
+			// local file may be changed by a failed delta, so check it again
+			if diskHash, err := hashutil.Sha256File(filePathName); err == nil && diskHash == hash {
+				return nil
+			}
+		}
+		err = saveRrdpBytesToFile(filePathName, b)
+		if err != nil {
+			belogs.Error("RrdpSyncer.syncSnapshot(): saveRrdpBytesToFile fail:", filePathName, err)
+			return err
+		}
+		dir, file := osutil.Split(filePathName)
+		rrdpFile := &RrdpFile{
+			FilePath:  dir,
+			FileName:  file,
+			FileUri:   uri,
+			SyncType:  "add",
+			SourceUrl: notificationModel.Snapshot.Uri,
+			Serial:    snapshotModel.Serial,
+		}
+		if ok {
+			rrdpFile.SyncType = "update"
+			changeSet.Updated = append(changeSet.Updated, rrdpFile)
+		} else {
+			changeSet.Added = append(changeSet.Added, rrdpFile)
+		}
+		return nil
+	}
+	snapshotModel, err := GetRrdpSnapshotStreamWithConfig(notificationModel.Snapshot.Uri,
+		notificationModel.Snapshot.Hash, c.httpClientConfig, fn)
+	if err != nil {
+		belogs.Error("RrdpSyncer.syncSnapshot(): GetRrdpSnapshotStreamWithConfig fail:", notificationModel.Snapshot.Uri, err)
+		return nil, err
+	}
+	if snapshotModel.SessionId != notificationModel.SessionId || snapshotModel.Serial != notificationModel.Serial {
+		belogs.Error("RrdpSyncer.syncSnapshot(): snapshot is different from notification, snapshotModel:", snapshotModel.String(),
+			"   notificationModel.SessionId:", notificationModel.SessionId, "   notificationModel.Serial:", notificationModel.Serial)
+		return nil, errors.New("snapshot's session_id or serial is different from notification")
+	}
+
+	// files in old state but not in snapshot, should be withdrawn
+	for uri := range oldUriHashs {
+		if _, ok := uriHashs[uri]; ok {
+			continue
+		}
+		filePathName, err := getRrdpFilePathName(c.repoPath, uri)
+		if err != nil {
+			belogs.Error("RrdpSyncer.syncSnapshot(): getRrdpFilePathName of withdraw fail, uri:", uri, err)
+			continue
+		}
+		err = os.Remove(filePathName)
+		if err != nil && !os.IsNotExist(err) {
+			belogs.Error("RrdpSyncer.syncSnapshot(): Remove fail:", filePathName, err)
+			return nil, err
+		}
+		dir, file := osutil.Split(filePathName)
+		changeSet.Withdrawn = append(changeSet.Withdrawn, &RrdpFile{
+			FilePath:  dir,
+			FileName:  file,
+			FileUri:   uri,
+			SyncType:  "del",
+			SourceUrl: notificationModel.Snapshot.Uri,
+			Serial:    snapshotModel.Serial,
+		})
+	}
+
+	err = c.SaveState(&RrdpSyncState{
+		NotificationUrl: notificationModel.NotificationUrl,
+		SessionId:       notificationModel.SessionId,
+		Serial:          notificationModel.Serial,
+		UriHashs:        uriHashs,
+		SyncTime:        time.Now(),
+	})
+	if err != nil {
+		belogs.Error("RrdpSyncer.syncSnapshot(): SaveState fail:", notificationModel.NotificationUrl, err)
+		return nil, err
+	}
+	return changeSet, nil
+}
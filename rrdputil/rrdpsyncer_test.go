@@ -0,0 +1,140 @@
+package rrdputil
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cpusoft/goutil/base64util"
+	"github.com/cpusoft/goutil/convert"
+	"github.com/cpusoft/goutil/hashutil"
+	"github.com/cpusoft/goutil/httpclient"
+)
+
+// testRrdpServer serves notification.xml, snapshot.xml and <serial>.xml deltas
+type testRrdpServer struct {
+	sessionId string
+	serial    uint64
+	snapshot  string
+	deltas    map[uint64]string
+	server    *httptest.Server
+}
+
+func newTestRrdpServer() *testRrdpServer {
+	s := &testRrdpServer{sessionId: "9df4b597-af9e-4dca-bdda-719cce2c4e28", deltas: make(map[uint64]string)}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/notification.xml":
+			w.Write([]byte(s.notification()))
+		case r.URL.Path == "/snapshot.xml":
+			w.Write([]byte(s.snapshot))
+		default:
+			serial, _ := convert.String2Uint64(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), ".xml"))
+			if d, ok := s.deltas[serial]; ok {
+				w.Write([]byte(d))
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return s
+}
+
+func (s *testRrdpServer) notification() string {
+	var b strings.Builder
+	b.WriteString(`<notification xmlns="http://www.ripe.net/rpki/rrdp" version="1" session_id="` + s.sessionId +
+		`" serial="` + convert.ToString(s.serial) + `">`)
+	b.WriteString(`<snapshot uri="` + s.server.URL + `/snapshot.xml" hash="` + hashutil.Sha256([]byte(s.snapshot)) + `"/>`)
+	for serial, d := range s.deltas {
+		b.WriteString(`<delta serial="` + convert.ToString(serial) + `" uri="` + s.server.URL + `/` +
+			convert.ToString(serial) + `.xml" hash="` + hashutil.Sha256([]byte(d)) + `"/>`)
+	}
+	b.WriteString(`</notification>`)
+	return b.String()
+}
+
+func (s *testRrdpServer) setSnapshot(files map[string]string) {
+	var b strings.Builder
+	b.WriteString(`<snapshot xmlns="http://www.ripe.net/rpki/rrdp" version="1" session_id="` + s.sessionId +
+		`" serial="` + convert.ToString(s.serial) + `">`)
+	for uri, content := range files {
+		b.WriteString(`<publish uri="` + uri + `">` + base64util.EncodeBase64([]byte(content)) + `</publish>`)
+	}
+	b.WriteString(`</snapshot>`)
+	s.snapshot = b.String()
+}
+
+func TestRrdpSyncer(t *testing.T) {
+	s := newTestRrdpServer()
+	defer s.server.Close()
+	statePath := t.TempDir()
+	repoPath := t.TempDir()
+	syncer := NewRrdpSyncer(statePath, repoPath, httpclient.NewHttpClientConfig())
+	notificationUrl := s.server.URL + "/notification.xml"
+
+	// first, snapshot
+	s.serial = 1
+	s.setSnapshot(map[string]string{
+		"rsync://example.com/repo/a.cer": "a1",
+		"rsync://example.com/repo/b.roa": "b1",
+	})
+	changeSet, err := syncer.Sync(notificationUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(changeSet)
+	if changeSet.SyncMode != RRDP_SYNC_MODE_SNAPSHOT || len(changeSet.Added) != 2 {
+		t.Fatal("first sync should use snapshot")
+	}
+
+	// no change
+	changeSet, err = syncer.Sync(notificationUrl)
+	if err != nil || changeSet.SyncMode != RRDP_SYNC_MODE_NONE {
+		t.Fatal("should be none", err)
+	}
+
+	// delta: update a.cer, withdraw b.roa, add c.crl
+	s.serial = 2
+	s.deltas[2] = `<delta xmlns="http://www.ripe.net/rpki/rrdp" version="1" session_id="` + s.sessionId + `" serial="2">` +
+		`<publish uri="rsync://example.com/repo/a.cer" hash="` + hashutil.Sha256([]byte("a1")) + `">` + base64util.EncodeBase64([]byte("a2")) + `</publish>` +
+		`<withdraw uri="rsync://example.com/repo/b.roa" hash="` + hashutil.Sha256([]byte("b1")) + `"/>` +
+		`<publish uri="rsync://example.com/repo/c.crl">` + base64util.EncodeBase64([]byte("c2")) + `</publish>` +
+		`</delta>`
+	s.setSnapshot(map[string]string{
+		"rsync://example.com/repo/a.cer": "a2",
+		"rsync://example.com/repo/c.crl": "c2",
+	})
+	changeSet, err = syncer.Sync(notificationUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(changeSet)
+	if changeSet.SyncMode != RRDP_SYNC_MODE_DELTA || len(changeSet.Added) != 1 ||
+		len(changeSet.Updated) != 1 || len(changeSet.Withdrawn) != 1 {
+		t.Fatal("second sync should use delta")
+	}
+	b, _ := os.ReadFile(filepath.Join(repoPath, "example.com/repo/a.cer"))
+	if string(b) != "a2" {
+		t.Fatal("a.cer should be updated")
+	}
+
+	// session changed, will use snapshot
+	s.sessionId = "2c1d3c4b-0000-4000-8000-000000000000"
+	s.serial = 1
+	s.deltas = make(map[uint64]string)
+	s.setSnapshot(map[string]string{
+		"rsync://example.com/repo/a.cer": "a2",
+	})
+	changeSet, err = syncer.Sync(notificationUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(changeSet)
+	if changeSet.SyncMode != RRDP_SYNC_MODE_SNAPSHOT || len(changeSet.Withdrawn) != 1 || len(changeSet.Added) != 0 {
+		t.Fatal("session changed should use snapshot")
+	}
+}
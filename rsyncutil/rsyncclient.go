@@ -0,0 +1,725 @@
+package rsyncutil
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cpusoft/goutil/belogs"
+	"github.com/cpusoft/goutil/convert"
+	"github.com/cpusoft/goutil/jsonutil"
+	"github.com/cpusoft/goutil/osutil"
+	"github.com/cpusoft/goutil/urlutil"
+	"golang.org/x/crypto/md4"
+)
+
+// RsyncNative syncs rsyncUrl to destPath by pure go rsync client, no need rsync command.
+// it is the same as Rsync(), and uses globalRsyncClientConfig
+func RsyncNative(ctx context.Context, rsyncUrl, destPath string) (rsyncResults []RsyncResult, err error) {
+	belogs.Debug("RsyncNative():rsyncUrl:", rsyncUrl, " destPath:", destPath,
+		"   will use  globalRsyncClientConfig:", jsonutil.MarshalJson(globalRsyncClientConfig))
+	return RsyncNativeWithConfig(ctx, rsyncUrl, destPath, globalRsyncClientConfig)
+}
+
+// RsyncNativeWithConfig syncs rsyncUrl to destPath/host/path as "rsync -Lrt --del",
+// files with same size and modtime are skipped, and every received file is checked by whole-file checksum.
+// ConTimeout is for connecting, Timeout is for every read/write, ctx can cancel it.
+// when some files fail, the rsyncResults of other files are returned with err
+func RsyncNativeWithConfig(ctx context.Context, rsyncUrl, destPath string,
+	rsyncClientConfig *RsyncClientConfig) (rsyncResults []RsyncResult, err error) {
+	start := time.Now()
+	belogs.Debug("RsyncNativeWithConfig():rsyncUrl:", rsyncUrl, " destPath:", destPath, "  rsyncClientConfig:", jsonutil.MarshalJson(rsyncClientConfig))
+
+	session, err := newRsyncSession(rsyncUrl, true, rsyncClientConfig)
+	if err != nil {
+		belogs.Error("RsyncNativeWithConfig(): newRsyncSession fail, rsyncUrl:", rsyncUrl, err)
+		return nil, err
+	}
+	hostAndPath, err := urlutil.HostAndPath(session.rsyncUrl)
+	if err != nil {
+		belogs.Error("RsyncNativeWithConfig():HostAndPath: rsyncUrl:", rsyncUrl, " err:", err)
+		return nil, err
+	}
+	rsyncDestPath := osutil.JoinPathFile(destPath, hostAndPath)
+	if err = os.MkdirAll(rsyncDestPath, os.ModePerm); err != nil {
+		belogs.Error("RsyncNativeWithConfig():MkdirAll:", rsyncDestPath, " err:", err)
+		return nil, err
+	}
+
+	err = session.open(ctx)
+	if err != nil {
+		belogs.Error("RsyncNativeWithConfig(): open fail, rsyncUrl:", rsyncUrl, err)
+		return nil, err
+	}
+	defer session.close()
+
+	rsyncResults = make([]RsyncResult, 0)
+	plan := planNativeRsync(session.entries, rsyncDestPath)
+	for _, localDir := range plan.mkdirs {
+		os.RemoveAll(localDir)
+		if err = os.MkdirAll(localDir, os.ModePerm); err != nil {
+			belogs.Error("RsyncNativeWithConfig(): MkdirAll fail:", localDir, err)
+			return rsyncResults, err
+		}
+		rsyncResults = append(rsyncResults, newNativeRsyncResult(session.rsyncUrl, localDir, RSYNC_TYPE_MKDIR, true))
+	}
+	requests, requestTypes := plan.requests, plan.requestTypes
+	belogs.Debug("RsyncNativeWithConfig(): rsyncUrl:", rsyncUrl, "  len(entries):", len(session.entries), "  len(requests):", len(requests))
+
+	received := make(map[int32]bool, len(requests))
+	err = session.transfer(requests, func(ndx int32, entry *RsyncFileEntry, c *rsyncConn) error {
+		localFile := filepath.Join(rsyncDestPath, filepath.FromSlash(entry.Name))
+		if err := receiveRsyncFile(c, entry, localFile); err != nil {
+			return err
+		}
+		received[ndx] = true
+		rsyncResults = append(rsyncResults, newNativeRsyncResult(session.rsyncUrl, localFile, requestTypes[ndx], false))
+		return nil
+	})
+	if err != nil {
+		belogs.Error("RsyncNativeWithConfig(): transfer fail, rsyncUrl:", rsyncUrl, err)
+		return rsyncResults, session.wrapError(ctx, err)
+	}
+
+	failErr := session.getPeerError()
+	for _, ndx := range requests {
+		if !received[ndx] {
+			belogs.Error("RsyncNativeWithConfig(): file is not received:", session.entries[ndx].Name)
+			if failErr == nil {
+				failErr = errors.New("file " + session.entries[ndx].Name + " is not received")
+			}
+		}
+	}
+	if failErr != nil {
+		// as rsync, IO error encountered -- skipping file deletion
+		belogs.Error("RsyncNativeWithConfig(): some files fail, rsyncUrl:", rsyncUrl, failErr)
+		return rsyncResults, errors.New("rsync error of " + rsyncUrl + " is " + failErr.Error())
+	}
+
+	delResults, err := deleteNativeRsyncExtraFiles(session.rsyncUrl, rsyncDestPath, plan.remoteNames)
+	if err != nil {
+		belogs.Error("RsyncNativeWithConfig(): deleteNativeRsyncExtraFiles fail, rsyncDestPath:", rsyncDestPath, err)
+		return rsyncResults, err
+	}
+	rsyncResults = append(rsyncResults, delResults...)
+
+	err = AddCerToRsyncResults(rsyncDestPath, &rsyncResults)
+	if err != nil {
+		belogs.Error("RsyncNativeWithConfig():AddCerToRsyncResults fail, rsyncDestPath:", rsyncDestPath, err)
+		return rsyncResults, err
+	}
+	belogs.Info("RsyncNativeWithConfig(): rsyncUrl:", rsyncUrl, "  len(rsyncResults):", len(rsyncResults), "  time(s):", time.Since(start))
+	return rsyncResults, nil
+}
+
+// RsyncNativeFileWithConfig gets one file by pure go rsync client, such as TA certificate in TAL
+func RsyncNativeFileWithConfig(ctx context.Context, rsyncUrl string, rsyncClientConfig *RsyncClientConfig) ([]byte, error) {
+	belogs.Debug("RsyncNativeFileWithConfig():rsyncUrl:", rsyncUrl, "  rsyncClientConfig:", jsonutil.MarshalJson(rsyncClientConfig))
+	session, err := newRsyncSession(rsyncUrl, false, rsyncClientConfig)
+	if err != nil {
+		belogs.Error("RsyncNativeFileWithConfig(): newRsyncSession fail, rsyncUrl:", rsyncUrl, err)
+		return nil, err
+	}
+	if err = session.open(ctx); err != nil {
+		belogs.Error("RsyncNativeFileWithConfig(): open fail, rsyncUrl:", rsyncUrl, err)
+		return nil, err
+	}
+	defer session.close()
+	// the only entry is the file
+	if len(session.entries) != 1 || !session.entries[0].IsRegular() {
+		belogs.Error("RsyncNativeFileWithConfig(): not a file, rsyncUrl:", rsyncUrl, "  len(entries):", len(session.entries))
+		return nil, errors.New("rsync error of " + rsyncUrl + " is it is not a file")
+	}
+
+	var buf bytes.Buffer
+	received := false
+	err = session.transfer([]int32{0}, func(ndx int32, entry *RsyncFileEntry, c *rsyncConn) error {
+		received = true
+		return receiveRsyncFileData(c, entry, &buf)
+	})
+	if err != nil {
+		belogs.Error("RsyncNativeFileWithConfig(): transfer fail, rsyncUrl:", rsyncUrl, err)
+		return nil, session.wrapError(ctx, err)
+	}
+	if !received {
+		err = session.getPeerError()
+		if err == nil {
+			err = errors.New("file is not received")
+		}
+		belogs.Error("RsyncNativeFileWithConfig(): file is not received, rsyncUrl:", rsyncUrl, err)
+		return nil, errors.New("rsync error of " + rsyncUrl + " is " + err.Error())
+	}
+	belogs.Debug("RsyncNativeFileWithConfig(): rsyncUrl:", rsyncUrl, "  len(bytes):", buf.Len())
+	return buf.Bytes(), nil
+}
+
+// rsyncSession is one connection to rsync daemon, client is receiver and daemon is sender
+type rsyncSession struct {
+	rsyncUrl string
+	host     string
+	port     string
+	module   string
+	// module/path/, sent as arg
+	modulePath string
+
+	timeout    time.Duration
+	conTimeout time.Duration
+
+	c       *rsyncConn
+	stop    func() bool
+	entries []*RsyncFileEntry
+}
+
+// newRsyncSession syncs a dir when isDir, or only one file
+func newRsyncSession(rsyncUrl string, isDir bool, rsyncClientConfig *RsyncClientConfig) (*rsyncSession, error) {
+	u, err := url.Parse(rsyncUrl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "rsync" || len(u.Hostname()) == 0 {
+		return nil, errors.New("rsyncUrl should be rsync://host/module/path: " + rsyncUrl)
+	}
+	modulePath := strings.TrimPrefix(path.Clean("/"+u.Path), "/")
+	if len(modulePath) == 0 {
+		return nil, errors.New("module is empty in rsyncUrl: " + rsyncUrl)
+	}
+	s := &rsyncSession{
+		host:       u.Hostname(),
+		port:       u.Port(),
+		module:     strings.Split(modulePath, "/")[0],
+		modulePath: modulePath,
+	}
+	if len(s.port) == 0 {
+		s.port = RSYNC_DEFAULT_PORT
+	}
+	if isDir {
+		// sync the dir, so always ends with '/'
+		s.modulePath += "/"
+	} else if !strings.Contains(modulePath, "/") {
+		return nil, errors.New("file is empty in rsyncUrl: " + rsyncUrl)
+	}
+	s.rsyncUrl = "rsync://" + u.Host + "/" + s.modulePath
+	s.timeout, s.conTimeout = getRsyncClientTimeouts(rsyncClientConfig)
+	return s, nil
+}
+
+// Timeout/ConTimeout are seconds in string
+func getRsyncClientTimeouts(rsyncClientConfig *RsyncClientConfig) (timeout, conTimeout time.Duration) {
+	if rsyncClientConfig == nil {
+		rsyncClientConfig = globalRsyncClientConfig
+	}
+	defaultSec, _ := strconv.Atoi(RSYNC_TIMEOUT_SEC)
+	timeoutSec, err := strconv.Atoi(strings.TrimSpace(rsyncClientConfig.Timeout))
+	if err != nil || timeoutSec <= 0 {
+		timeoutSec = defaultSec
+	}
+	defaultSec, _ = strconv.Atoi(RSYNC_CONTIMEOUT_SEC)
+	conTimeoutSec, err := strconv.Atoi(strings.TrimSpace(rsyncClientConfig.ConTimeout))
+	if err != nil || conTimeoutSec <= 0 {
+		conTimeoutSec = defaultSec
+	}
+	return time.Duration(timeoutSec) * time.Second, time.Duration(conTimeoutSec) * time.Second
+}
+
+// open connects to daemon, and receives file list
+func (s *rsyncSession) open(ctx context.Context) (err error) {
+	dialer := net.Dialer{Timeout: s.conTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(s.host, s.port))
+	if err != nil {
+		belogs.Error("rsyncSession.open(): DialContext fail:", s.host, s.port, err)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return errors.New("rsync error of " + s.rsyncUrl + " is " + err.Error())
+	}
+	s.c = newRsyncConn(conn, s.timeout)
+	// when ctx is canceled, close conn to break reading/writing
+	s.stop = context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer func() {
+		if err != nil {
+			s.close()
+			err = s.wrapError(ctx, err)
+		}
+	}()
+
+	if err = s.handshake(); err != nil {
+		belogs.Error("rsyncSession.open(): handshake fail:", s.rsyncUrl, err)
+		return err
+	}
+	if err = s.setupProtocol(); err != nil {
+		belogs.Error("rsyncSession.open(): setupProtocol fail:", s.rsyncUrl, err)
+		return err
+	}
+	s.entries, err = recvRsyncFileList(s.c)
+	if err != nil {
+		belogs.Error("rsyncSession.open(): recvRsyncFileList fail:", s.rsyncUrl, err)
+		return err
+	}
+	return nil
+}
+
+func (s *rsyncSession) close() {
+	if s.stop != nil {
+		s.stop()
+	}
+	if s.c != nil {
+		s.c.conn.Close()
+	}
+}
+
+// wrapError uses ctx.Err() when canceled, and adds rsyncUrl
+func (s *rsyncSession) wrapError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if errMsg := s.getPeerError(); errMsg != nil {
+		return errors.New("rsync error of " + s.rsyncUrl + " is " + err.Error() + ", " + errMsg.Error())
+	}
+	return errors.New("rsync error of " + s.rsyncUrl + " is " + err.Error())
+}
+
+// getPeerError returns error msgs sent by daemon
+func (s *rsyncSession) getPeerError() error {
+	if s.c == nil {
+		return nil
+	}
+	if len(s.c.errMsgs) > 0 {
+		return errors.New(strings.Join(s.c.errMsgs, "; "))
+	}
+	if s.c.ioError != 0 {
+		return errors.New("io error " + convert.ToString(s.c.ioError) + " in daemon")
+	}
+	if s.c.exitCode != 0 {
+		return errors.New("daemon exits with code " + convert.ToString(s.c.exitCode))
+	}
+	return nil
+}
+
+// greeting, module and args, as start_inband_exchange of rsync
+func (s *rsyncSession) handshake() error {
+	line, err := s.c.readLine('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, RSYNC_GREETING_PREFIX) {
+		return errors.New("rsync greeting is invalid: " + line)
+	}
+	// "@RSYNCD: 31.0 sha512 sha256 sha1 md5 md4"
+	version := strings.Fields(strings.TrimPrefix(line, RSYNC_GREETING_PREFIX))
+	if len(version) == 0 {
+		return errors.New("rsync greeting is invalid: " + line)
+	}
+	remoteProtocol, err := strconv.Atoi(strings.Split(version[0], ".")[0])
+	if err != nil {
+		return errors.New("rsync greeting is invalid: " + line)
+	}
+	if remoteProtocol < RSYNC_PROTOCOL_VERSION_MIN {
+		return errors.New("protocol " + convert.ToString(remoteProtocol) + " of daemon is not supported")
+	}
+	s.c.protocol = remoteProtocol
+	if s.c.protocol > RSYNC_PROTOCOL_VERSION_MAX {
+		s.c.protocol = RSYNC_PROTOCOL_VERSION_MAX
+	}
+	belogs.Debug("rsyncSession.handshake(): greeting:", line, "  protocol:", s.c.protocol)
+
+	err = s.c.writeRaw([]byte(RSYNC_GREETING_PREFIX + convert.ToString(s.c.protocol) + ".0\n" + s.module + "\n"))
+	if err != nil {
+		return err
+	}
+	for {
+		line, err = s.c.readLine('\n')
+		if err != nil {
+			return err
+		}
+		if line == RSYNC_GREETING_OK {
+			break
+		}
+		if strings.HasPrefix(line, RSYNC_GREETING_ERROR) {
+			return errors.New(line)
+		}
+		if strings.HasPrefix(line, RSYNC_GREETING_AUTH) {
+			return errors.New("module " + s.module + " needs authentication, it is not supported")
+		}
+		if line == RSYNC_GREETING_EXIT {
+			return errors.New("daemon exits")
+		}
+		// motd
+		belogs.Debug("rsyncSession.handshake(): motd:", line)
+	}
+
+	// "-Lrt": copy links, recursive, times; "e." tells capabilities of client, see server_options of rsync
+	args := []string{"--server", "--sender"}
+	sep := []byte{'\n'}
+	if s.c.protocol >= 30 {
+		args = append(args, "-Lrte.fxC")
+		sep = []byte{0}
+	} else {
+		args = append(args, "-Lrt")
+	}
+	args = append(args, "--timeout="+convert.ToString(int(s.timeout/time.Second)), ".", s.modulePath)
+	var buf bytes.Buffer
+	for _, arg := range args {
+		buf.WriteString(arg)
+		buf.Write(sep)
+	}
+	buf.Write(sep)
+	belogs.Debug("rsyncSession.handshake(): args:", args)
+	return s.c.writeRaw(buf.Bytes())
+}
+
+// setup_protocol of rsync, then starts multiplex and sends empty filter list
+func (s *rsyncSession) setupProtocol() (err error) {
+	if s.c.protocol >= 30 {
+		s.c.compatFlags, err = s.c.readVarint()
+		if err != nil {
+			return err
+		}
+		if s.c.compatFlags&(RSYNC_CF_INC_RECURSE|RSYNC_CF_VARINT_FLIST_FLAGS) != 0 {
+			return errors.New("compat flags " + convert.ToString(s.c.compatFlags) + " of daemon are not supported")
+		}
+	}
+	s.c.seed, err = s.c.readInt()
+	if err != nil {
+		return err
+	}
+	belogs.Debug("rsyncSession.setupProtocol(): compatFlags:", s.c.compatFlags, "  seed:", s.c.seed)
+
+	s.c.multiplexIn = true
+	// generator sends msgs to sender since protocol 30
+	s.c.multiplexOut = s.c.protocol >= 30
+	// empty filter list
+	s.c.writeInt(0)
+	return s.c.flush()
+}
+
+// transfer requests files by ndx, fn reads every file. at last, reads stats and says goodbye.
+// requests may be empty, then only finishes the session
+func (s *rsyncSession) transfer(requests []int32, fn func(ndx int32, entry *RsyncFileEntry, c *rsyncConn) error) error {
+	// phase 0: transfer, phase 1: redo, phase 2 (since protocol 29): delay updates
+	maxPhase := 1
+	if s.c.protocol >= 29 {
+		maxPhase = 2
+	}
+
+	// generator writes in goroutine, because daemon sends files while reading requests
+	genErrCh := make(chan error, 1)
+	go func() {
+		for _, ndx := range requests {
+			s.c.writeNdx(ndx)
+			if s.c.protocol >= 29 {
+				s.c.writeShortInt(RSYNC_ITEM_TRANSFER)
+			}
+			// sum head: count, blength, s2length, remainder, all are zero, so whole file will be sent
+			for i := 0; i < 4; i++ {
+				s.c.writeInt(0)
+			}
+			if err := s.c.flush(); err != nil {
+				genErrCh <- err
+				return
+			}
+		}
+		for i := 0; i <= maxPhase; i++ {
+			s.c.writeNdx(RSYNC_NDX_DONE)
+		}
+		genErrCh <- s.c.flush()
+	}()
+
+	phase := 0
+	for {
+		ndx, err := s.c.readNdx()
+		if err != nil {
+			belogs.Error("rsyncSession.transfer(): readNdx fail:", err)
+			return err
+		}
+		if ndx == RSYNC_NDX_DONE {
+			phase++
+			if phase > maxPhase {
+				break
+			}
+			continue
+		}
+		if ndx == RSYNC_NDX_DEL_STATS {
+			for i := 0; i < 5; i++ {
+				if _, err = s.c.readVarint(); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if ndx < 0 || int(ndx) >= len(s.entries) {
+			return errors.New("file ndx " + convert.ToString(ndx) + " is invalid")
+		}
+		iflags := RSYNC_ITEM_TRANSFER
+		if s.c.protocol >= 29 {
+			if iflags, err = s.c.readShortInt(); err != nil {
+				return err
+			}
+			if iflags&RSYNC_ITEM_BASIS_TYPE_FOLLOWS != 0 {
+				if _, err = s.c.readByte(); err != nil {
+					return err
+				}
+			}
+			if iflags&RSYNC_ITEM_XNAME_FOLLOWS != 0 {
+				if _, err = s.c.readVstring(); err != nil {
+					return err
+				}
+			}
+		}
+		if iflags&RSYNC_ITEM_TRANSFER == 0 {
+			continue
+		}
+		if fn == nil {
+			return errors.New("file " + s.entries[ndx].Name + " is not requested")
+		}
+		if err = fn(ndx, s.entries[ndx], s.c); err != nil {
+			belogs.Error("rsyncSession.transfer(): receive file fail:", s.entries[ndx].Name, err)
+			return err
+		}
+	}
+	if err := <-genErrCh; err != nil {
+		belogs.Error("rsyncSession.transfer(): write requests fail:", err)
+		return err
+	}
+
+	// stats: total_read, total_written, total_size, [flist_buildtime, flist_xfertime]
+	statsCount := 3
+	if s.c.protocol >= 29 {
+		statsCount = 5
+	}
+	for i := 0; i < statsCount; i++ {
+		if _, err := s.c.readVarlong30(3); err != nil {
+			belogs.Error("rsyncSession.transfer(): read stats fail:", err)
+			return err
+		}
+	}
+	// final goodbye
+	s.c.writeNdx(RSYNC_NDX_DONE)
+	if err := s.c.flush(); err != nil {
+		return err
+	}
+	if s.c.protocol >= 31 {
+		// daemon says goodbye too, ignore error because daemon may exit
+		s.c.readNdx()
+	}
+	return nil
+}
+
+// receiveRsyncFile receives to a tmp file, then renames it to localFile
+func receiveRsyncFile(c *rsyncConn, entry *RsyncFileEntry, localFile string) (err error) {
+	dir, fileName := filepath.Split(localFile)
+	if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp(dir, "."+fileName+".")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		tmpFile.Close()
+		if err != nil {
+			os.Remove(tmpFile.Name())
+		}
+	}()
+
+	if err = receiveRsyncFileData(c, entry, tmpFile); err != nil {
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+	os.Chtimes(tmpFile.Name(), entry.ModTime, entry.ModTime)
+	if fi, err := os.Stat(localFile); err == nil && fi.IsDir() {
+		os.RemoveAll(localFile)
+	}
+	return os.Rename(tmpFile.Name(), localFile)
+}
+
+// receive_data of rsync without basis file: sum head, literal tokens, then whole-file checksum
+func receiveRsyncFileData(c *rsyncConn, entry *RsyncFileEntry, w io.Writer) (err error) {
+	for i := 0; i < 4; i++ {
+		if _, err = c.readInt(); err != nil {
+			return err
+		}
+	}
+	sum := newRsyncFileSum(c)
+	var size int64
+	buf := make([]byte, RSYNC_CHUNK_SIZE)
+	for {
+		token, err := c.readInt()
+		if err != nil {
+			return err
+		}
+		if token == 0 {
+			break
+		}
+		if token < 0 {
+			return errors.New("block match token of " + entry.Name + " is not expected")
+		}
+		for token > 0 {
+			n := int(token)
+			if n > len(buf) {
+				n = len(buf)
+			}
+			if err = c.readFull(buf[:n]); err != nil {
+				return err
+			}
+			sum.Write(buf[:n])
+			if _, err = w.Write(buf[:n]); err != nil {
+				return err
+			}
+			token -= int32(n)
+			size += int64(n)
+		}
+	}
+	remoteSum := make([]byte, RSYNC_SUM_LENGTH)
+	if err = c.readFull(remoteSum); err != nil {
+		return err
+	}
+	if !bytes.Equal(sum.Sum(nil), remoteSum) {
+		return errors.New("checksum of " + entry.Name + " is different from daemon")
+	}
+	if size != entry.Size {
+		return errors.New("size of " + entry.Name + " is " + convert.ToString(size) + ", but should be " + convert.ToString(entry.Size))
+	}
+	return nil
+}
+
+// whole-file checksum: md5 since protocol 30, or md4 with seed before data
+func newRsyncFileSum(c *rsyncConn) hash.Hash {
+	if c.protocol >= 30 {
+		return md5.New()
+	}
+	h := md4.New()
+	seed := make([]byte, 4)
+	binary.LittleEndian.PutUint32(seed, uint32(c.seed))
+	h.Write(seed)
+	return h
+}
+
+// nativeRsyncPlan is what to do for the file list, disk is not changed
+type nativeRsyncPlan struct {
+	// local dirs to create
+	mkdirs []string
+	// file ndx to transfer, and RSYNC_TYPE_ADD/RSYNC_TYPE_UPDATE
+	requests     []int32
+	requestTypes map[int32]string
+	remoteNames  map[string]struct{}
+}
+
+// planNativeRsync compares file list with local files, same size and modtime is seen as not changed
+func planNativeRsync(entries []*RsyncFileEntry, rsyncDestPath string) *nativeRsyncPlan {
+	plan := &nativeRsyncPlan{
+		mkdirs:       make([]string, 0),
+		requests:     make([]int32, 0),
+		requestTypes: make(map[int32]string),
+		remoteNames:  make(map[string]struct{}, len(entries)),
+	}
+	for i, entry := range entries {
+		plan.remoteNames[entry.Name] = struct{}{}
+		if entry.Name == "." {
+			continue
+		}
+		localFile := filepath.Join(rsyncDestPath, filepath.FromSlash(entry.Name))
+		fi, err := os.Stat(localFile)
+		if entry.IsDir() {
+			if err != nil || !fi.IsDir() {
+				plan.mkdirs = append(plan.mkdirs, localFile)
+			}
+			continue
+		}
+		if !entry.IsRegular() {
+			belogs.Debug("planNativeRsync(): skip not regular file:", entry.Name, entry.Mode)
+			continue
+		}
+		if err == nil && fi.Mode().IsRegular() && fi.Size() == entry.Size && fi.ModTime().Unix() == entry.ModTime.Unix() {
+			continue
+		}
+		plan.requests = append(plan.requests, int32(i))
+		if err == nil {
+			plan.requestTypes[int32(i)] = RSYNC_TYPE_UPDATE
+		} else {
+			plan.requestTypes[int32(i)] = RSYNC_TYPE_ADD
+		}
+	}
+	return plan
+}
+
+func newNativeRsyncResult(rsyncUrl, localFile, rsyncType string, isDir bool) RsyncResult {
+	rsyncResult := RsyncResult{}
+	rsyncResult.FilePath, rsyncResult.FileName = osutil.GetFilePathAndFileName(filepath.Clean(localFile))
+	if !isDir {
+		rsyncResult.FileType = strings.Replace(path.Ext(rsyncResult.FileName), ".", "", -1)
+	}
+	rsyncResult.RsyncType = rsyncType
+	rsyncResult.RsyncUrl = rsyncUrl
+	rsyncResult.IsDir = isDir
+	rsyncResult.SyncTime = time.Now()
+	return rsyncResult
+}
+
+// as --del, removes local files and dirs which are not in daemon
+func deleteNativeRsyncExtraFiles(rsyncUrl, rsyncDestPath string, remoteNames map[string]struct{}) ([]RsyncResult, error) {
+	delResults := make([]RsyncResult, 0)
+	extras, err := findNativeRsyncExtraFiles(rsyncDestPath, remoteNames)
+	if err != nil {
+		return nil, err
+	}
+	for _, extra := range extras {
+		fi, err := os.Stat(extra)
+		if err != nil {
+			continue
+		}
+		if err = os.RemoveAll(extra); err != nil {
+			belogs.Error("deleteNativeRsyncExtraFiles(): RemoveAll fail:", extra, err)
+			return delResults, err
+		}
+		belogs.Debug("deleteNativeRsyncExtraFiles(): deleted:", extra)
+		delResults = append(delResults, newNativeRsyncResult(rsyncUrl, extra, RSYNC_TYPE_DEL, fi.IsDir()))
+	}
+	return delResults, nil
+}
+
+// findNativeRsyncExtraFiles returns local files and top dirs which are not in daemon
+func findNativeRsyncExtraFiles(rsyncDestPath string, remoteNames map[string]struct{}) ([]string, error) {
+	extras := make([]string, 0)
+	err := filepath.WalkDir(rsyncDestPath, func(filePathName string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && filePathName == rsyncDestPath {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		rel, err := filepath.Rel(rsyncDestPath, filePathName)
+		if err != nil || rel == "." {
+			return err
+		}
+		if _, ok := remoteNames[filepath.ToSlash(rel)]; !ok {
+			extras = append(extras, filePathName)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(extras)
+	return extras, nil
+}
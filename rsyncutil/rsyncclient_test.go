@@ -0,0 +1,418 @@
+package rsyncutil
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/md4"
+)
+
+// testRsyncDaemon is a rsync daemon stand-in, it is the sender of one module
+type testRsyncDaemon struct {
+	listener net.Listener
+	protocol int
+	module   string
+	dir      string
+}
+
+func newTestRsyncDaemon(t *testing.T, protocol int, module, dir string) *testRsyncDaemon {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &testRsyncDaemon{listener: listener, protocol: protocol, module: module, dir: dir}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if err := d.serve(conn); err != nil {
+					fmt.Println("testRsyncDaemon.serve():", err)
+				}
+			}()
+		}
+	}()
+	return d
+}
+
+func (d *testRsyncDaemon) url(p string) string {
+	return "rsync://" + d.listener.Addr().String() + "/" + d.module + "/" + p
+}
+
+func (d *testRsyncDaemon) serve(conn net.Conn) error {
+	c := newRsyncConn(conn, 10*time.Second)
+	c.writeRaw([]byte(RSYNC_GREETING_PREFIX + strconv.Itoa(d.protocol) + ".0\n"))
+	line, err := c.readLine('\n')
+	if err != nil {
+		return err
+	}
+	clientProtocol, _ := strconv.Atoi(strings.Split(strings.TrimPrefix(line, RSYNC_GREETING_PREFIX), ".")[0])
+	c.protocol = min(d.protocol, clientProtocol)
+	module, err := c.readLine('\n')
+	if err != nil {
+		return err
+	}
+	if module != d.module {
+		return c.writeRaw([]byte("@ERROR: Unknown module '" + module + "'\n"))
+	}
+	c.writeRaw([]byte("motd of test daemon\n" + RSYNC_GREETING_OK + "\n"))
+
+	sep := byte('\n')
+	if c.protocol >= 30 {
+		sep = 0
+	}
+	args := make([]string, 0)
+	for {
+		arg, err := c.readLine(sep)
+		if err != nil {
+			return err
+		}
+		if len(arg) == 0 {
+			break
+		}
+		args = append(args, arg)
+	}
+	modulePath := args[len(args)-1]
+	root := filepath.Join(d.dir, filepath.FromSlash(strings.TrimPrefix(modulePath, d.module)))
+
+	if c.protocol >= 30 {
+		c.writeVarint(RSYNC_CF_SAFE_FLIST | RSYNC_CF_CHKSUM_SEED_FIX)
+	}
+	c.seed = int32(time.Now().Unix())
+	c.writeInt(c.seed)
+	if err = c.flush(); err != nil {
+		return err
+	}
+	c.multiplexOut = true
+	c.multiplexIn = c.protocol >= 30
+	// filter list
+	if _, err = c.readInt(); err != nil {
+		return err
+	}
+
+	entries, err := d.sendFileList(c, root)
+	if err != nil {
+		return err
+	}
+	c.writeMsg(RSYNC_MSG_INFO, []byte("file list is sent\n"))
+
+	maxPhase := 1
+	if c.protocol >= 29 {
+		maxPhase = 2
+	}
+	phase := 0
+	for {
+		ndx, err := c.readNdx()
+		if err != nil {
+			return err
+		}
+		if ndx == RSYNC_NDX_DONE {
+			phase++
+			if phase > maxPhase {
+				break
+			}
+			c.writeNdx(RSYNC_NDX_DONE)
+			c.flush()
+			continue
+		}
+		iflags := RSYNC_ITEM_TRANSFER
+		if c.protocol >= 29 {
+			if iflags, err = c.readShortInt(); err != nil {
+				return err
+			}
+		}
+		for i := 0; i < 4; i++ {
+			if _, err = c.readInt(); err != nil {
+				return err
+			}
+		}
+		filePathName := filepath.Join(root, filepath.FromSlash(entries[ndx].Name))
+		if len(entries) == 1 {
+			filePathName = root
+		}
+		b, err := os.ReadFile(filePathName)
+		if err != nil {
+			c.writeMsg(RSYNC_MSG_ERROR, []byte("send_files failed to open "+entries[ndx].Name+"\n"))
+			c.writeMsg(RSYNC_MSG_NO_SEND, binary.LittleEndian.AppendUint32(nil, uint32(ndx)))
+			continue
+		}
+		c.writeNdx(ndx)
+		if c.protocol >= 29 {
+			c.writeShortInt(iflags)
+		}
+		for i := 0; i < 4; i++ {
+			c.writeInt(0)
+		}
+		var sum hash.Hash
+		if c.protocol >= 30 {
+			sum = md5.New()
+		} else {
+			sum = md4.New()
+			sum.Write(binary.LittleEndian.AppendUint32(nil, uint32(c.seed)))
+		}
+		sum.Write(b)
+		for len(b) > 0 {
+			n := min(len(b), 1000)
+			c.writeInt(int32(n))
+			c.writeBytes(b[:n])
+			b = b[n:]
+		}
+		c.writeInt(0)
+		c.writeBytes(sum.Sum(nil))
+		if err = c.flush(); err != nil {
+			return err
+		}
+	}
+	c.writeNdx(RSYNC_NDX_DONE)
+	for i := 0; i < 5; i++ {
+		c.writeVarlong30(int64(i), 3)
+	}
+	c.flush()
+	if ndx, err := c.readNdx(); err != nil || ndx != RSYNC_NDX_DONE {
+		return errors.New("invalid goodbye")
+	}
+	if c.protocol >= 31 {
+		c.writeNdx(RSYNC_NDX_DONE)
+		c.flush()
+	}
+	return nil
+}
+
+// send_file_list, entries are sent in walk order, then sorted
+func (d *testRsyncDaemon) sendFileList(c *rsyncConn, root string) ([]*RsyncFileEntry, error) {
+	entries := make([]*RsyncFileEntry, 0)
+	err := filepath.WalkDir(root, func(p string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		fi, err := de.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		if rel == "." && !fi.IsDir() {
+			// only one file, the name is base name
+			rel = fi.Name()
+		}
+		entry := &RsyncFileEntry{Name: filepath.ToSlash(rel), ModTime: fi.ModTime(), Mode: RSYNC_S_IFREG | 0644}
+		if fi.IsDir() {
+			entry.Mode = RSYNC_S_IFDIR | 0755
+		} else {
+			entry.Size = fi.Size()
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// reverse, so client must sort it
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+
+	last := &RsyncFileEntry{}
+	for _, entry := range entries {
+		flags := 0
+		if entry.Name == "." {
+			flags |= RSYNC_XMIT_TOP_DIR
+		}
+		if entry.Mode == last.Mode {
+			flags |= RSYNC_XMIT_SAME_MODE
+		}
+		if entry.ModTime.Unix() == last.ModTime.Unix() {
+			flags |= RSYNC_XMIT_SAME_TIME
+		}
+		l1 := 0
+		for l1 < len(entry.Name) && l1 < len(last.Name) && l1 < 255 && entry.Name[l1] == last.Name[l1] {
+			l1++
+		}
+		if l1 > 0 {
+			flags |= RSYNC_XMIT_SAME_NAME
+		}
+		l2 := len(entry.Name) - l1
+		if l2 > 255 {
+			flags |= RSYNC_XMIT_LONG_NAME
+		}
+		if c.protocol >= 28 {
+			if flags == 0 && !entry.IsDir() {
+				flags |= RSYNC_XMIT_TOP_DIR
+			}
+			if flags&0xFF00 != 0 || flags == 0 {
+				flags |= RSYNC_XMIT_EXTENDED_FLAGS
+				c.writeShortInt(flags)
+			} else {
+				c.writeByte(byte(flags))
+			}
+		} else {
+			if flags&0xFF == 0 {
+				if entry.IsDir() {
+					flags |= RSYNC_XMIT_LONG_NAME
+				} else {
+					flags |= RSYNC_XMIT_TOP_DIR
+				}
+			}
+			c.writeByte(byte(flags))
+		}
+		if flags&RSYNC_XMIT_SAME_NAME != 0 {
+			c.writeByte(byte(l1))
+		}
+		if flags&RSYNC_XMIT_LONG_NAME != 0 {
+			c.writeVarint30(int32(l2))
+		} else {
+			c.writeByte(byte(l2))
+		}
+		c.writeBytes([]byte(entry.Name[l1:]))
+		c.writeVarlong30(entry.Size, 3)
+		if flags&RSYNC_XMIT_SAME_TIME == 0 {
+			if c.protocol >= 30 {
+				c.writeVarlong(entry.ModTime.Unix(), 4)
+			} else {
+				c.writeInt(int32(entry.ModTime.Unix()))
+			}
+		}
+		if flags&RSYNC_XMIT_SAME_MODE == 0 {
+			c.writeInt(int32(entry.Mode))
+		}
+		last = entry
+	}
+	c.writeByte(0)
+	if c.protocol < 30 {
+		c.writeInt(0)
+	}
+	if err = c.flush(); err != nil {
+		return nil, err
+	}
+	sortRsyncFileEntries(entries, c.protocol)
+	return entries, nil
+}
+
+func TestRsyncNativeWithConfig(t *testing.T) {
+	for _, protocol := range []int{27, 29, 30, 31} {
+		srcDir := createTempDir(t)
+		os.MkdirAll(filepath.Join(srcDir, "sub", "deep"), os.ModePerm)
+		createTestFile(t, srcDir, "a.cer", "a1")
+		createTestFile(t, srcDir, "b.roa", strings.Repeat("b", 100000))
+		createTestFile(t, srcDir, "sub/c.mft", "c1")
+		createTestFile(t, srcDir, "sub/deep/"+strings.Repeat("d", 200)+".crl", "d1")
+		daemon := newTestRsyncDaemon(t, protocol, "repo", srcDir)
+		destPath := createTempDir(t)
+		rsyncClientConfig := NewRsyncClientConfig("5", "5")
+
+		rsyncResults, err := RsyncNativeWithConfig(context.Background(), daemon.url(""), destPath, rsyncClientConfig)
+		fmt.Println(protocol, len(rsyncResults), err)
+		if err != nil {
+			t.Fatal(protocol, err)
+		}
+		// 4 add + 2 mkdir
+		if len(rsyncResults) != 6 {
+			t.Fatal(protocol, "len(rsyncResults) should be 6:", rsyncResults)
+		}
+		rsyncDestPath := filepath.Join(destPath, "127.0.0.1", "repo")
+		b, _ := os.ReadFile(filepath.Join(rsyncDestPath, "b.roa"))
+		if len(b) != 100000 {
+			t.Fatal(protocol, "b.roa is wrong")
+		}
+
+		// nothing changed, only the cer is added as JUST_SYNC
+		rsyncResults, err = RsyncNativeWithConfig(context.Background(), daemon.url(""), destPath, rsyncClientConfig)
+		if err != nil || len(rsyncResults) != 1 || rsyncResults[0].RsyncType != RSYNC_TYPE_JUST_SYNC {
+			t.Fatal(protocol, "should not change:", rsyncResults, err)
+		}
+
+		os.Remove(filepath.Join(srcDir, "a.cer"))
+		createTestFile(t, srcDir, "sub/c.mft", "c22")
+		createTestFile(t, srcDir, "e.cer", "e1")
+		createTestFile(t, rsyncDestPath, "local.roa", "local")
+		rsyncResults, err = RsyncNativeWithConfig(context.Background(), daemon.url(""), destPath, rsyncClientConfig)
+		if err != nil {
+			t.Fatal(protocol, err)
+		}
+		types := make(map[string]int)
+		for _, rsyncResult := range rsyncResults {
+			types[rsyncResult.RsyncType]++
+		}
+		fmt.Println(protocol, types)
+		if types[RSYNC_TYPE_ADD] != 1 || types[RSYNC_TYPE_UPDATE] != 1 || types[RSYNC_TYPE_DEL] != 2 {
+			t.Fatal(protocol, "add/update/del is wrong:", types)
+		}
+		if _, err = os.Stat(filepath.Join(rsyncDestPath, "a.cer")); !os.IsNotExist(err) {
+			t.Fatal(protocol, "a.cer should be deleted")
+		}
+
+		// one file
+		b, err = RsyncNativeFileWithConfig(context.Background(), daemon.url("b.roa"), rsyncClientConfig)
+		if err != nil || len(b) != 100000 {
+			t.Fatal(protocol, "get file fail:", len(b), err)
+		}
+		if _, err = RsyncNativeFileWithConfig(context.Background(), daemon.url("sub"), rsyncClientConfig); err == nil {
+			t.Fatal(protocol, "dir is not a file")
+		}
+
+		// sub dir
+		subDestPath := createTempDir(t)
+		rsyncResults, err = RsyncNativeWithConfig(context.Background(), daemon.url("sub/"), subDestPath, rsyncClientConfig)
+		if err != nil || len(rsyncResults) != 3 {
+			t.Fatal(protocol, "sync sub fail:", rsyncResults, err)
+		}
+	}
+}
+
+func TestRsyncNativeWithConfigFail(t *testing.T) {
+	srcDir := createTempDir(t)
+	daemon := newTestRsyncDaemon(t, 31, "repo", srcDir)
+	_, err := RsyncNativeWithConfig(context.Background(), "rsync://"+daemon.listener.Addr().String()+"/norepo/",
+		createTempDir(t), NewRsyncClientConfig("5", "5"))
+	fmt.Println(err)
+	if err == nil || !strings.Contains(err.Error(), "Unknown module") {
+		t.Fatal("should fail by unknown module")
+	}
+
+	// daemon does not say anything
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+	rsyncUrl := "rsync://" + listener.Addr().String() + "/repo/"
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err = RsyncNativeWithConfig(ctx, rsyncUrl, createTempDir(t), NewRsyncClientConfig("5", "5"))
+	fmt.Println(err)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("should be canceled by ctx")
+	}
+
+	start := time.Now()
+	_, err = RsyncNativeWithConfig(context.Background(), rsyncUrl, createTempDir(t), NewRsyncClientConfig("1", "1"))
+	fmt.Println(err, time.Since(start))
+	if err == nil || time.Since(start) > 3*time.Second {
+		t.Fatal("should timeout")
+	}
+}
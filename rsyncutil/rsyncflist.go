@@ -0,0 +1,313 @@
+package rsyncutil
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cpusoft/goutil/belogs"
+	"github.com/cpusoft/goutil/convert"
+)
+
+// RsyncFileEntry is one entry in rsync file list
+type RsyncFileEntry struct {
+	// relative to rsync url, use '/', the top dir is "."
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	// unix mode, includes file type
+	Mode uint32 `json:"mode"`
+}
+
+func (e *RsyncFileEntry) IsDir() bool {
+	return e.Mode&RSYNC_S_IFMT == RSYNC_S_IFDIR
+}
+
+func (e *RsyncFileEntry) IsRegular() bool {
+	return e.Mode&RSYNC_S_IFMT == RSYNC_S_IFREG
+}
+
+// recv_file_list of rsync, entries are sorted as sender does, so index of entry is the file ndx
+func recvRsyncFileList(c *rsyncConn) (entries []*RsyncFileEntry, err error) {
+	entries = make([]*RsyncFileEntry, 0)
+	last := &RsyncFileEntry{}
+	for {
+		b, err := c.readByte()
+		if err != nil {
+			belogs.Error("recvRsyncFileList(): readByte fail:", err)
+			return nil, err
+		}
+		flags := int(b)
+		if c.protocol >= 28 && flags&RSYNC_XMIT_EXTENDED_FLAGS != 0 {
+			b, err = c.readByte()
+			if err != nil {
+				belogs.Error("recvRsyncFileList(): readByte extended flags fail:", err)
+				return nil, err
+			}
+			flags |= int(b) << 8
+		}
+		if flags == 0 {
+			break
+		}
+		if c.protocol >= 30 && flags == RSYNC_XMIT_IO_ERROR_END_OF_ALL {
+			ioError, err := c.readVarint()
+			if err != nil {
+				belogs.Error("recvRsyncFileList(): readVarint ioError fail:", err)
+				return nil, err
+			}
+			c.ioError |= ioError
+			break
+		}
+
+		entry, err := recvRsyncFileEntry(c, flags, last)
+		if err != nil {
+			belogs.Error("recvRsyncFileList(): recvRsyncFileEntry fail:", err)
+			return nil, err
+		}
+		entries = append(entries, entry)
+		last = entry
+	}
+	if c.protocol < 30 {
+		ioError, err := c.readInt()
+		if err != nil {
+			belogs.Error("recvRsyncFileList(): readInt ioError fail:", err)
+			return nil, err
+		}
+		c.ioError |= ioError
+	}
+
+	sortRsyncFileEntries(entries, c.protocol)
+	belogs.Debug("recvRsyncFileList(): len(entries):", len(entries), "  ioError:", c.ioError)
+	return entries, nil
+}
+
+// recv_file_entry of rsync, only supports what client asks: -r -t -L, so no uid/gid/devices/links/hardlinks
+func recvRsyncFileEntry(c *rsyncConn, flags int, last *RsyncFileEntry) (*RsyncFileEntry, error) {
+	var l1, l2 int
+	if flags&RSYNC_XMIT_SAME_NAME != 0 {
+		b, err := c.readByte()
+		if err != nil {
+			return nil, err
+		}
+		l1 = int(b)
+	}
+	if flags&RSYNC_XMIT_LONG_NAME != 0 {
+		l, err := c.readVarint30()
+		if err != nil {
+			return nil, err
+		}
+		l2 = int(l)
+	} else {
+		b, err := c.readByte()
+		if err != nil {
+			return nil, err
+		}
+		l2 = int(b)
+	}
+	if l1 > len(last.Name) || l2 < 0 || l1+l2 > RSYNC_MAX_PATH_LEN {
+		return nil, errors.New("length of file name is invalid, l1:" + convert.ToString(l1) + ", l2:" + convert.ToString(l2))
+	}
+	name := make([]byte, l1+l2)
+	copy(name, last.Name[:l1])
+	if err := c.readFull(name[l1:]); err != nil {
+		return nil, err
+	}
+
+	entry := &RsyncFileEntry{Name: string(name)}
+	if err := checkRsyncFileName(entry.Name); err != nil {
+		return nil, err
+	}
+	size, err := c.readVarlong30(3)
+	if err != nil {
+		return nil, err
+	}
+	if size < 0 {
+		return nil, errors.New("size of " + entry.Name + " is invalid")
+	}
+	entry.Size = size
+
+	if flags&RSYNC_XMIT_SAME_TIME != 0 {
+		entry.ModTime = last.ModTime
+	} else {
+		var modTime int64
+		if c.protocol >= 30 {
+			modTime, err = c.readVarlong(4)
+		} else {
+			var t int32
+			t, err = c.readInt()
+			modTime = int64(t)
+		}
+		if err != nil {
+			return nil, err
+		}
+		entry.ModTime = time.Unix(modTime, 0)
+	}
+	if flags&RSYNC_XMIT_MOD_NSEC != 0 {
+		nsec, err := c.readVarint()
+		if err != nil {
+			return nil, err
+		}
+		entry.ModTime = time.Unix(entry.ModTime.Unix(), int64(nsec))
+	}
+
+	if flags&RSYNC_XMIT_SAME_MODE != 0 {
+		entry.Mode = last.Mode
+	} else {
+		mode, err := c.readInt()
+		if err != nil {
+			return nil, err
+		}
+		entry.Mode = uint32(mode)
+	}
+	return entry, nil
+}
+
+// file name from sender should be relative and not go up
+func checkRsyncFileName(name string) error {
+	if len(name) == 0 || strings.HasPrefix(name, "/") || strings.Contains(name, "\x00") {
+		return errors.New("file name '" + name + "' is invalid")
+	}
+	for _, one := range strings.Split(name, "/") {
+		if one == ".." {
+			return errors.New("file name '" + name + "' should not contain '..'")
+		}
+	}
+	return nil
+}
+
+// sort as f_name_cmp of rsync, since protocol 29, files are before dirs in one dir
+func sortRsyncFileEntries(entries []*RsyncFileEntry, protocol int) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return compareRsyncFileEntry(entries[i], entries[j], protocol) < 0
+	})
+}
+
+const (
+	rsyncFncStateDir = iota
+	rsyncFncStateSlash
+	rsyncFncStateBase
+	rsyncFncStateTrailing
+
+	rsyncFncTypeItem = 0
+	rsyncFncTypePath = 1
+)
+
+// rsyncFncIter walks "dirname/basename" of one entry as f_name_cmp
+type rsyncFncIter struct {
+	entry    *RsyncFileEntry
+	base     string
+	pathType int
+	state    int
+	typ      int
+	s        string
+	pos      int
+}
+
+func newRsyncFncIter(entry *RsyncFileEntry, dir, base string, pathType int, startAtBase bool) *rsyncFncIter {
+	it := &rsyncFncIter{entry: entry, base: base, pathType: pathType}
+	if startAtBase {
+		it.setBase()
+	} else {
+		it.typ = pathType
+		it.state = rsyncFncStateDir
+		it.s = dir
+	}
+	return it
+}
+
+func (it *rsyncFncIter) setBase() {
+	it.typ = rsyncFncTypeItem
+	if it.entry.IsDir() {
+		it.typ = it.pathType
+	}
+	it.s = it.base
+	it.pos = 0
+	if it.typ == rsyncFncTypePath && it.base == "." {
+		it.typ = rsyncFncTypeItem
+		it.state = rsyncFncStateTrailing
+		it.s = ""
+	} else {
+		it.state = rsyncFncStateBase
+	}
+}
+
+func (it *rsyncFncIter) ch() byte {
+	if it.pos < len(it.s) {
+		return it.s[it.pos]
+	}
+	return 0
+}
+
+// next is called at the end of current part
+func (it *rsyncFncIter) next() {
+	switch it.state {
+	case rsyncFncStateDir:
+		it.state = rsyncFncStateSlash
+		it.s = "/"
+		it.pos = 0
+	case rsyncFncStateSlash:
+		it.setBase()
+	case rsyncFncStateBase:
+		it.state = rsyncFncStateTrailing
+		if it.typ == rsyncFncTypePath {
+			it.s = "/"
+			it.pos = 0
+		} else {
+			it.typ = rsyncFncTypeItem
+		}
+	case rsyncFncStateTrailing:
+		it.typ = rsyncFncTypeItem
+	}
+}
+
+func compareRsyncFileEntry(e1, e2 *RsyncFileEntry, protocol int) int {
+	pathType := rsyncFncTypePath
+	if protocol < 29 {
+		pathType = rsyncFncTypeItem
+	}
+	dir1, base1 := splitRsyncFileName(e1.Name)
+	dir2, base2 := splitRsyncFileName(e2.Name)
+	it1 := newRsyncFncIter(e1, dir1, base1, pathType, len(dir1) == 0 || dir1 == dir2)
+	it2 := newRsyncFncIter(e2, dir2, base2, pathType, len(dir2) == 0 || dir1 == dir2)
+	typeResult := func(typ int) int {
+		if typ == rsyncFncTypePath {
+			return 1
+		}
+		return -1
+	}
+	if it1.typ != it2.typ {
+		return typeResult(it1.typ)
+	}
+	for {
+		if it1.ch() == 0 {
+			it1.next()
+			if it2.ch() != 0 && it1.typ != it2.typ {
+				return typeResult(it1.typ)
+			}
+		}
+		if it2.ch() == 0 {
+			it2.next()
+			if it1.ch() != 0 && it1.typ != it2.typ {
+				return typeResult(it1.typ)
+			}
+		}
+		ch1, ch2 := it1.ch(), it2.ch()
+		if ch1 != ch2 {
+			return int(ch1) - int(ch2)
+		}
+		if ch1 == 0 {
+			// both are at the end of name
+			return 0
+		}
+		it1.pos++
+		it2.pos++
+	}
+}
+
+// "a/b/c.cer" --> "a/b", "c.cer";  "c.cer" --> "", "c.cer"
+func splitRsyncFileName(name string) (dir, base string) {
+	dir, base = path.Split(name)
+	return strings.TrimSuffix(dir, "/"), base
+}
@@ -0,0 +1,211 @@
+package rsyncutil
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cpusoft/goutil/belogs"
+	"github.com/cpusoft/goutil/jsonutil"
+	"github.com/cpusoft/goutil/osutil"
+	"github.com/cpusoft/goutil/urlutil"
+)
+
+const (
+	RSYNC_LIST_STYLE_LIST    = "list"
+	RSYNC_LIST_STYLE_DRY_RUN = "dryrun"
+)
+
+// RsyncTreeNode is one file or dir in rsync listing
+type RsyncTreeNode struct {
+	// base name, the root is "."
+	Name string `json:"name"`
+	// relative to rsync url, use '/'
+	Path     string           `json:"path"`
+	Size     int64            `json:"size"`
+	ModTime  time.Time        `json:"modTime"`
+	Mode     uint32           `json:"mode"`
+	IsDir    bool             `json:"isDir"`
+	Children []*RsyncTreeNode `json:"children,omitempty"`
+}
+
+// RsyncListSummary is like RsyncRecord, counts what is in daemon and what will be transferred
+type RsyncListSummary struct {
+	RsyncUrl  string    `json:"rsyncUrl"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	// RSYNC_LIST_STYLE_***
+	Style string `json:"style"`
+
+	DirCount  uint64 `json:"dirCount"`
+	FileCount uint64 `json:"fileCount"`
+	TotalSize int64  `json:"totalSize"`
+	// cer/roa/mft/crl/..., no dot
+	FileTypeCounts map[string]uint64 `json:"fileTypeCounts"`
+
+	// only in dry run
+	AddCount     uint64 `json:"addCount"`
+	UpdateCount  uint64 `json:"updateCount"`
+	DelCount     uint64 `json:"delCount"`
+	MkdirCount   uint64 `json:"mkdirCount"`
+	TransferSize int64  `json:"transferSize"`
+	// what RsyncNativeWithConfig will return, but nothing is changed in disk
+	RsyncResults []RsyncResult `json:"rsyncResults"`
+}
+
+// RsyncListing is file list of rsync url
+type RsyncListing struct {
+	Root    *RsyncTreeNode   `json:"root"`
+	Entries []RsyncFileEntry `json:"entries"`
+	Summary RsyncListSummary `json:"summary"`
+}
+
+// Exceeds checks whether files or bytes to transfer are more than limits, limit <= 0 means no limit.
+// in list style, all files are seen as to transfer
+func (s *RsyncListSummary) Exceeds(maxFileCount uint64, maxSize int64) bool {
+	fileCount, size := s.FileCount, s.TotalSize
+	if s.Style == RSYNC_LIST_STYLE_DRY_RUN {
+		fileCount, size = s.AddCount+s.UpdateCount, s.TransferSize
+	}
+	return (maxFileCount > 0 && fileCount > maxFileCount) || (maxSize > 0 && size > maxSize)
+}
+
+// RsyncListWithConfig lists rsyncUrl as "rsync --list-only -r", no file is transferred
+func RsyncListWithConfig(ctx context.Context, rsyncUrl string, rsyncClientConfig *RsyncClientConfig) (*RsyncListing, error) {
+	belogs.Debug("RsyncListWithConfig():rsyncUrl:", rsyncUrl, "  rsyncClientConfig:", jsonutil.MarshalJson(rsyncClientConfig))
+	return rsyncList(ctx, rsyncUrl, "", rsyncClientConfig)
+}
+
+// RsyncDryRunWithConfig is as "rsync --dry-run" of RsyncNativeWithConfig, it compares file list with
+// files in destPath/host/path, and counts what will be added/updated/deleted, disk is not changed
+func RsyncDryRunWithConfig(ctx context.Context, rsyncUrl, destPath string, rsyncClientConfig *RsyncClientConfig) (*RsyncListing, error) {
+	belogs.Debug("RsyncDryRunWithConfig():rsyncUrl:", rsyncUrl, " destPath:", destPath, "  rsyncClientConfig:", jsonutil.MarshalJson(rsyncClientConfig))
+	return rsyncList(ctx, rsyncUrl, destPath, rsyncClientConfig)
+}
+
+// rsyncList gets file list and finishes session without any request, dry run when destPath is not empty
+func rsyncList(ctx context.Context, rsyncUrl, destPath string, rsyncClientConfig *RsyncClientConfig) (*RsyncListing, error) {
+	start := time.Now()
+	session, err := newRsyncSession(rsyncUrl, true, rsyncClientConfig)
+	if err != nil {
+		belogs.Error("rsyncList(): newRsyncSession fail, rsyncUrl:", rsyncUrl, err)
+		return nil, err
+	}
+	if err = session.open(ctx); err != nil {
+		belogs.Error("rsyncList(): open fail, rsyncUrl:", rsyncUrl, err)
+		return nil, err
+	}
+	defer session.close()
+	if err = session.transfer(nil, nil); err != nil {
+		belogs.Error("rsyncList(): transfer fail, rsyncUrl:", rsyncUrl, err)
+		return nil, session.wrapError(ctx, err)
+	}
+	if err = session.getPeerError(); err != nil {
+		// file list may be not complete
+		belogs.Error("rsyncList(): daemon has error, rsyncUrl:", rsyncUrl, err)
+		return nil, session.wrapError(ctx, err)
+	}
+
+	listing := newRsyncListing(session.rsyncUrl, session.entries)
+	listing.Summary.StartTime = start
+	if len(destPath) > 0 {
+		listing.Summary.Style = RSYNC_LIST_STYLE_DRY_RUN
+		if err = dryRunRsyncListing(listing, session, destPath); err != nil {
+			belogs.Error("rsyncList(): dryRunRsyncListing fail, rsyncUrl:", rsyncUrl, err)
+			return nil, err
+		}
+	}
+	listing.Summary.EndTime = time.Now()
+	belogs.Info("rsyncList(): rsyncUrl:", rsyncUrl, "  summary:", jsonutil.MarshalJson(listing.Summary), "  time(s):", time.Since(start))
+	return listing, nil
+}
+
+// newRsyncListing builds tree and summary, entries are sorted so parent is before children
+func newRsyncListing(rsyncUrl string, entries []*RsyncFileEntry) *RsyncListing {
+	listing := &RsyncListing{
+		Root:    &RsyncTreeNode{Name: ".", Path: ".", IsDir: true, Children: make([]*RsyncTreeNode, 0)},
+		Entries: make([]RsyncFileEntry, 0, len(entries)),
+		Summary: RsyncListSummary{
+			RsyncUrl:       rsyncUrl,
+			Style:          RSYNC_LIST_STYLE_LIST,
+			FileTypeCounts: make(map[string]uint64),
+			RsyncResults:   make([]RsyncResult, 0),
+		},
+	}
+	dirs := map[string]*RsyncTreeNode{".": listing.Root}
+	for _, entry := range entries {
+		listing.Entries = append(listing.Entries, *entry)
+		if entry.Name == "." {
+			listing.Root.ModTime, listing.Root.Mode = entry.ModTime, entry.Mode
+			continue
+		}
+		node := &RsyncTreeNode{
+			Name:    path.Base(entry.Name),
+			Path:    entry.Name,
+			Size:    entry.Size,
+			ModTime: entry.ModTime,
+			Mode:    entry.Mode,
+			IsDir:   entry.IsDir(),
+		}
+		if node.IsDir {
+			node.Children = make([]*RsyncTreeNode, 0)
+			dirs[entry.Name] = node
+			listing.Summary.DirCount++
+		} else {
+			listing.Summary.FileCount++
+			listing.Summary.TotalSize += entry.Size
+			listing.Summary.FileTypeCounts[strings.Replace(path.Ext(node.Name), ".", "", -1)]++
+		}
+		parent, ok := dirs[path.Dir(entry.Name)]
+		if !ok {
+			// should not happen, just put it in root
+			parent = listing.Root
+		}
+		parent.Children = append(parent.Children, node)
+	}
+	return listing
+}
+
+// dryRunRsyncListing counts as RsyncNativeWithConfig, and saves what will happen to RsyncResults
+func dryRunRsyncListing(listing *RsyncListing, session *rsyncSession, destPath string) error {
+	hostAndPath, err := urlutil.HostAndPath(session.rsyncUrl)
+	if err != nil {
+		belogs.Error("dryRunRsyncListing():HostAndPath: rsyncUrl:", session.rsyncUrl, " err:", err)
+		return err
+	}
+	rsyncDestPath := osutil.JoinPathFile(destPath, hostAndPath)
+	summary := &listing.Summary
+	plan := planNativeRsync(session.entries, rsyncDestPath)
+	for _, localDir := range plan.mkdirs {
+		summary.MkdirCount++
+		summary.RsyncResults = append(summary.RsyncResults, newNativeRsyncResult(session.rsyncUrl, localDir, RSYNC_TYPE_MKDIR, true))
+	}
+	for _, ndx := range plan.requests {
+		entry := session.entries[ndx]
+		if plan.requestTypes[ndx] == RSYNC_TYPE_ADD {
+			summary.AddCount++
+		} else {
+			summary.UpdateCount++
+		}
+		summary.TransferSize += entry.Size
+		localFile := filepath.Join(rsyncDestPath, filepath.FromSlash(entry.Name))
+		summary.RsyncResults = append(summary.RsyncResults, newNativeRsyncResult(session.rsyncUrl, localFile, plan.requestTypes[ndx], false))
+	}
+	extras, err := findNativeRsyncExtraFiles(rsyncDestPath, plan.remoteNames)
+	if err != nil {
+		belogs.Error("dryRunRsyncListing(): findNativeRsyncExtraFiles fail, rsyncDestPath:", rsyncDestPath, err)
+		return err
+	}
+	for _, extra := range extras {
+		fi, err := os.Stat(extra)
+		if err != nil {
+			continue
+		}
+		summary.DelCount++
+		summary.RsyncResults = append(summary.RsyncResults, newNativeRsyncResult(session.rsyncUrl, extra, RSYNC_TYPE_DEL, fi.IsDir()))
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package rsyncutil
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cpusoft/goutil/jsonutil"
+)
+
+func TestRsyncListWithConfig(t *testing.T) {
+	srcDir := createTempDir(t)
+	os.MkdirAll(filepath.Join(srcDir, "sub"), os.ModePerm)
+	createTestFile(t, srcDir, "a.cer", "a1")
+	createTestFile(t, srcDir, "b.roa", strings.Repeat("b", 1000))
+	createTestFile(t, srcDir, "sub/c.cer", "c1")
+	daemon := newTestRsyncDaemon(t, 31, "repo", srcDir)
+	rsyncClientConfig := NewRsyncClientConfig("5", "5")
+
+	listing, err := RsyncListWithConfig(context.Background(), daemon.url(""), rsyncClientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(jsonutil.MarshalJson(listing.Root))
+	fmt.Println(jsonutil.MarshalJson(listing.Summary))
+	if listing.Summary.FileCount != 3 || listing.Summary.DirCount != 1 || listing.Summary.TotalSize != 1004 ||
+		listing.Summary.FileTypeCounts["cer"] != 2 || len(listing.Root.Children) != 3 {
+		t.Fatal("listing is wrong")
+	}
+	if !listing.Summary.Exceeds(2, 0) || listing.Summary.Exceeds(0, 2000) {
+		t.Fatal("Exceeds is wrong")
+	}
+
+	// dry run, nothing is changed
+	destPath := createTempDir(t)
+	rsyncDestPath := filepath.Join(destPath, "127.0.0.1", "repo")
+	os.MkdirAll(rsyncDestPath, os.ModePerm)
+	createTestFile(t, rsyncDestPath, "a.cer", "a00")
+	createTestFile(t, rsyncDestPath, "old.crl", "old")
+	listing, err = RsyncDryRunWithConfig(context.Background(), daemon.url(""), destPath, rsyncClientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(jsonutil.MarshalJson(listing.Summary))
+	summary := listing.Summary
+	if summary.AddCount != 2 || summary.UpdateCount != 1 || summary.DelCount != 1 || summary.MkdirCount != 1 ||
+		summary.TransferSize != 1004 || len(summary.RsyncResults) != 5 {
+		t.Fatal("dry run is wrong")
+	}
+	if _, err = os.Stat(filepath.Join(rsyncDestPath, "old.crl")); err != nil {
+		t.Fatal("dry run should not change disk")
+	}
+
+	// after sync, nothing to transfer
+	if _, err = RsyncNativeWithConfig(context.Background(), daemon.url(""), destPath, rsyncClientConfig); err != nil {
+		t.Fatal(err)
+	}
+	listing, err = RsyncDryRunWithConfig(context.Background(), daemon.url(""), destPath, rsyncClientConfig)
+	if err != nil || len(listing.Summary.RsyncResults) != 0 || listing.Summary.Exceeds(1, 1) {
+		t.Fatal("should be nothing to transfer", err)
+	}
+}
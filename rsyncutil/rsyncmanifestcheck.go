@@ -0,0 +1,199 @@
+package rsyncutil
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cpusoft/goutil/asn1util/asn1cms"
+	"github.com/cpusoft/goutil/belogs"
+	"github.com/cpusoft/goutil/hashutil"
+	"github.com/cpusoft/goutil/osutil"
+)
+
+const (
+	MANIFEST_CHECK_FILE_OK       = "ok"
+	MANIFEST_CHECK_FILE_MISSING  = "missing"
+	MANIFEST_CHECK_FILE_EXTRA    = "extra"
+	MANIFEST_CHECK_FILE_MISMATCH = "mismatch"
+
+	MANIFEST_CHECK_TIME_OK      = "ok"
+	MANIFEST_CHECK_TIME_NOT_YET = "notYetValid"
+	MANIFEST_CHECK_TIME_STALE   = "stale"
+)
+
+// ManifestCheckFile is one file on manifest or in publication point, FilePath and FileName are same as RsyncFileHash
+type ManifestCheckFile struct {
+	FilePath string `json:"filePath" xorm:"filePath varchar(512)"`
+	FileName string `json:"fileName" xorm:"fileName varchar(128)"`
+	// cer/roa/mft/crl, no dot
+	FileType string `json:"fileType" xorm:"fileType  varchar(16)"`
+	// hex of sha256 on manifest, empty when extra
+	ManifestHash string `json:"manifestHash" xorm:"manifestHash varchar(512)"`
+	// hex of sha256 on disk, empty when missing
+	FileHash string `json:"fileHash" xorm:"fileHash varchar(512)"`
+	// MANIFEST_CHECK_FILE_***
+	Status string `json:"status" xorm:"status varchar(16)"`
+}
+
+// ManifestCheckReport is the result of CheckPublicationPointByManifest
+type ManifestCheckReport struct {
+	FilePath         string    `json:"filePath" xorm:"filePath varchar(512)"`
+	ManifestFileName string    `json:"manifestFileName" xorm:"manifestFileName varchar(128)"`
+	ManifestNumber   string    `json:"manifestNumber" xorm:"manifestNumber varchar(64)"`
+	ThisUpdate       time.Time `json:"thisUpdate" xorm:"thisUpdate datetime"`
+	NextUpdate       time.Time `json:"nextUpdate" xorm:"nextUpdate datetime"`
+	CheckTime        time.Time `json:"checkTime" xorm:"checkTime datetime"`
+	// MANIFEST_CHECK_TIME_***
+	TimeStatus string `json:"timeStatus" xorm:"timeStatus varchar(16)"`
+	// time is ok, and no missing/extra/mismatch file
+	IsConsistent bool `json:"isConsistent" xorm:"isConsistent tinyint"`
+
+	OkCount       uint64              `json:"okCount" xorm:"okCount int"`
+	MissingFiles  []ManifestCheckFile `json:"missingFiles" xorm:"missingFiles json"`
+	ExtraFiles    []ManifestCheckFile `json:"extraFiles" xorm:"extraFiles json"`
+	MismatchFiles []ManifestCheckFile `json:"mismatchFiles" xorm:"mismatchFiles json"`
+}
+
+// CheckPublicationPointByManifest compares files on manifest with files in dirPath (not recursive).
+// when mftFileName is empty, the only .mft in dirPath is used; when checkTime is zero, time.Now() is used.
+// error is returned only when manifest cannot be read or is invalid
+func CheckPublicationPointByManifest(dirPath, mftFileName string, checkTime time.Time) (*ManifestCheckReport, error) {
+	belogs.Debug("CheckPublicationPointByManifest(): dirPath:", dirPath, "  mftFileName:", mftFileName, "  checkTime:", checkTime)
+	if checkTime.IsZero() {
+		checkTime = time.Now()
+	}
+	dirEntries, err := os.ReadDir(dirPath)
+	if err != nil {
+		belogs.Error("CheckPublicationPointByManifest(): ReadDir fail, dirPath:", dirPath, err)
+		return nil, err
+	}
+	if len(mftFileName) == 0 {
+		for _, dirEntry := range dirEntries {
+			if dirEntry.Type().IsRegular() && strings.HasSuffix(dirEntry.Name(), ".mft") {
+				if len(mftFileName) > 0 {
+					belogs.Error("CheckPublicationPointByManifest(): more than one manifest, dirPath:", dirPath)
+					return nil, errors.New("there are more than one manifest in " + dirPath)
+				}
+				mftFileName = dirEntry.Name()
+			}
+		}
+		if len(mftFileName) == 0 {
+			belogs.Error("CheckPublicationPointByManifest(): no manifest, dirPath:", dirPath)
+			return nil, errors.New("there is no manifest in " + dirPath)
+		}
+	}
+
+	b, err := os.ReadFile(osutil.JoinPathFile(dirPath, mftFileName))
+	if err != nil {
+		belogs.Error("CheckPublicationPointByManifest(): ReadFile fail, dirPath:", dirPath, "  mftFileName:", mftFileName, err)
+		return nil, err
+	}
+	mft, err := asn1cms.ParseManifest(b)
+	if err != nil {
+		belogs.Error("CheckPublicationPointByManifest(): ParseManifest fail, dirPath:", dirPath, "  mftFileName:", mftFileName, err)
+		return nil, err
+	}
+	return checkPublicationPointByManifestModel(dirPath, dirEntries, mftFileName, mft, checkTime)
+}
+
+// CheckPublicationPointByManifestModel is as CheckPublicationPointByManifest, when manifest has been parsed
+func CheckPublicationPointByManifestModel(dirPath, mftFileName string, mft *asn1cms.ManifestModel,
+	checkTime time.Time) (*ManifestCheckReport, error) {
+	if checkTime.IsZero() {
+		checkTime = time.Now()
+	}
+	dirEntries, err := os.ReadDir(dirPath)
+	if err != nil {
+		belogs.Error("CheckPublicationPointByManifestModel(): ReadDir fail, dirPath:", dirPath, err)
+		return nil, err
+	}
+	return checkPublicationPointByManifestModel(dirPath, dirEntries, mftFileName, mft, checkTime)
+}
+
+// dirEntries is listing of dirPath, so dirPath is read only once
+func checkPublicationPointByManifestModel(dirPath string, dirEntries []os.DirEntry, mftFileName string,
+	mft *asn1cms.ManifestModel, checkTime time.Time) (*ManifestCheckReport, error) {
+	report := &ManifestCheckReport{
+		FilePath:         dirPath,
+		ManifestFileName: mftFileName,
+		ManifestNumber:   mft.ManifestNumber.String(),
+		ThisUpdate:       mft.ThisUpdate,
+		NextUpdate:       mft.NextUpdate,
+		CheckTime:        checkTime,
+		TimeStatus:       getManifestTimeStatus(mft.ThisUpdate, mft.NextUpdate, checkTime),
+		MissingFiles:     make([]ManifestCheckFile, 0),
+		ExtraFiles:       make([]ManifestCheckFile, 0),
+		MismatchFiles:    make([]ManifestCheckFile, 0),
+	}
+
+	// files on disk, except manifest itself
+	diskHashs := make(map[string]string, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.Type().IsRegular() || dirEntry.Name() == mftFileName {
+			continue
+		}
+		hash, err := hashutil.Sha256File(osutil.JoinPathFile(dirPath, dirEntry.Name()))
+		if err != nil {
+			belogs.Error("checkPublicationPointByManifestModel(): Sha256File fail, dirPath:", dirPath, "  fileName:", dirEntry.Name(), err)
+			return nil, err
+		}
+		diskHashs[dirEntry.Name()] = hash
+	}
+
+	for _, fileAndHash := range mft.FileAndHashs {
+		checkFile := newManifestCheckFile(dirPath, fileAndHash.File)
+		checkFile.ManifestHash = hex.EncodeToString(fileAndHash.Hash)
+		diskHash, ok := diskHashs[fileAndHash.File]
+		delete(diskHashs, fileAndHash.File)
+		if !ok {
+			checkFile.Status = MANIFEST_CHECK_FILE_MISSING
+			report.MissingFiles = append(report.MissingFiles, checkFile)
+			continue
+		}
+		checkFile.FileHash = diskHash
+		if diskHash != checkFile.ManifestHash {
+			checkFile.Status = MANIFEST_CHECK_FILE_MISMATCH
+			report.MismatchFiles = append(report.MismatchFiles, checkFile)
+			continue
+		}
+		report.OkCount++
+	}
+	for fileName, diskHash := range diskHashs {
+		checkFile := newManifestCheckFile(dirPath, fileName)
+		checkFile.FileHash = diskHash
+		checkFile.Status = MANIFEST_CHECK_FILE_EXTRA
+		report.ExtraFiles = append(report.ExtraFiles, checkFile)
+	}
+	sort.Slice(report.ExtraFiles, func(i, j int) bool {
+		return report.ExtraFiles[i].FileName < report.ExtraFiles[j].FileName
+	})
+
+	report.IsConsistent = report.TimeStatus == MANIFEST_CHECK_TIME_OK && len(report.MissingFiles) == 0 &&
+		len(report.ExtraFiles) == 0 && len(report.MismatchFiles) == 0
+	belogs.Info("checkPublicationPointByManifestModel(): dirPath:", dirPath, "  mftFileName:", mftFileName,
+		"  timeStatus:", report.TimeStatus, "  okCount:", report.OkCount, "  len(missingFiles):", len(report.MissingFiles),
+		"  len(extraFiles):", len(report.ExtraFiles), "  len(mismatchFiles):", len(report.MismatchFiles))
+	return report, nil
+}
+
+func newManifestCheckFile(dirPath, fileName string) ManifestCheckFile {
+	return ManifestCheckFile{
+		FilePath: dirPath,
+		FileName: fileName,
+		FileType: strings.Replace(osutil.Ext(fileName), ".", "", -1),
+	}
+}
+
+func getManifestTimeStatus(thisUpdate, nextUpdate, checkTime time.Time) string {
+	if checkTime.Before(thisUpdate) {
+		return MANIFEST_CHECK_TIME_NOT_YET
+	}
+	if checkTime.After(nextUpdate) {
+		return MANIFEST_CHECK_TIME_STALE
+	}
+	return MANIFEST_CHECK_TIME_OK
+}
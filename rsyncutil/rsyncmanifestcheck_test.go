@@ -0,0 +1,78 @@
+package rsyncutil
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cpusoft/goutil/asn1util/asn1cert"
+	"github.com/cpusoft/goutil/asn1util/asn1cms"
+	"github.com/cpusoft/goutil/jsonutil"
+)
+
+func writeTestManifestFile(t *testing.T, dirPath, fileName, content string) {
+	if err := os.WriteFile(filepath.Join(dirPath, fileName), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckPublicationPointByManifestModel(t *testing.T) {
+	dirPath := t.TempDir()
+	files := map[string]string{"a.roa": "roa", "b.cer": "cer", "c.crl": "crl"}
+	now := time.Now()
+	mft := &asn1cms.ManifestModel{
+		ManifestNumber: big.NewInt(3),
+		ThisUpdate:     now.Add(-time.Hour),
+		NextUpdate:     now.Add(time.Hour),
+		FileAndHashs:   make([]asn1cert.FileAndHash, 0),
+	}
+	for name, content := range files {
+		hash := sha256.Sum256([]byte(content))
+		mft.FileAndHashs = append(mft.FileAndHashs, asn1cert.FileAndHash{File: name, Hash: hash[:]})
+	}
+	writeTestManifestFile(t, dirPath, "a.roa", "roa")
+	writeTestManifestFile(t, dirPath, "b.cer", "changed")
+	writeTestManifestFile(t, dirPath, "d.asa", "extra")
+	writeTestManifestFile(t, dirPath, "test.mft", "mft")
+	if err := os.Mkdir(filepath.Join(dirPath, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := CheckPublicationPointByManifestModel(dirPath, "test.mft", mft, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(jsonutil.MarshalJson(report))
+	if report.IsConsistent || report.TimeStatus != MANIFEST_CHECK_TIME_OK || report.OkCount != 1 || report.ManifestNumber != "3" ||
+		len(report.MissingFiles) != 1 || report.MissingFiles[0].FileName != "c.crl" ||
+		len(report.MismatchFiles) != 1 || report.MismatchFiles[0].FileName != "b.cer" ||
+		len(report.ExtraFiles) != 1 || report.ExtraFiles[0].FileName != "d.asa" || report.ExtraFiles[0].FileType != "asa" {
+		t.Fatal("report is wrong:", jsonutil.MarshalJson(report))
+	}
+
+	writeTestManifestFile(t, dirPath, "b.cer", "cer")
+	writeTestManifestFile(t, dirPath, "c.crl", "crl")
+	if err = os.Remove(filepath.Join(dirPath, "d.asa")); err != nil {
+		t.Fatal(err)
+	}
+	report, err = CheckPublicationPointByManifestModel(dirPath, "test.mft", mft, now)
+	if err != nil || !report.IsConsistent || report.OkCount != 3 {
+		t.Fatal("report should be consistent:", jsonutil.MarshalJson(report), err)
+	}
+	report, err = CheckPublicationPointByManifestModel(dirPath, "test.mft", mft, now.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.IsConsistent || report.TimeStatus != MANIFEST_CHECK_TIME_STALE {
+		t.Fatal("report should be stale:", jsonutil.MarshalJson(report))
+	}
+
+	// manifest is not CMS
+	if _, err = CheckPublicationPointByManifest(dirPath, "", now); err == nil {
+		t.Fatal("CheckPublicationPointByManifest should fail")
+	}
+}
@@ -0,0 +1,583 @@
+package rsyncutil
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cpusoft/goutil/belogs"
+	"github.com/cpusoft/goutil/convert"
+)
+
+// rsync wire protocol, the same as rsync io.c/flist.c/compat.c
+const (
+	RSYNC_PROTOCOL_VERSION_MIN = 27
+	RSYNC_PROTOCOL_VERSION_MAX = 31
+	RSYNC_DEFAULT_PORT         = "873"
+
+	RSYNC_GREETING_PREFIX = "@RSYNCD: "
+	RSYNC_GREETING_OK     = "@RSYNCD: OK"
+	RSYNC_GREETING_EXIT   = "@RSYNCD: EXIT"
+	RSYNC_GREETING_AUTH   = "@RSYNCD: AUTHREQD"
+	RSYNC_GREETING_ERROR  = "@ERROR"
+
+	// multiplex tag = RSYNC_MPLEX_BASE + RSYNC_MSG_***
+	RSYNC_MPLEX_BASE          = 7
+	RSYNC_MSG_DATA            = 0
+	RSYNC_MSG_ERROR_XFER      = 1
+	RSYNC_MSG_INFO            = 2
+	RSYNC_MSG_ERROR           = 3
+	RSYNC_MSG_WARNING         = 4
+	RSYNC_MSG_ERROR_SOCKET    = 5
+	RSYNC_MSG_LOG             = 6
+	RSYNC_MSG_CLIENT          = 7
+	RSYNC_MSG_ERROR_UTF8      = 8
+	RSYNC_MSG_REDO            = 9
+	RSYNC_MSG_STATS           = 10
+	RSYNC_MSG_IO_ERROR        = 22
+	RSYNC_MSG_IO_TIMEOUT      = 33
+	RSYNC_MSG_NOOP            = 42
+	RSYNC_MSG_ERROR_EXIT      = 86
+	RSYNC_MSG_SUCCESS         = 100
+	RSYNC_MSG_DELETED         = 101
+	RSYNC_MSG_NO_SEND         = 102
+	RSYNC_MSG_MAX_DATA_LENGTH = 0xFFFFFF
+
+	RSYNC_NDX_DONE      = -1
+	RSYNC_NDX_FLIST_EOF = -2
+	RSYNC_NDX_DEL_STATS = -3
+
+	// compat flags of protocol 30
+	RSYNC_CF_INC_RECURSE        = 1 << 0
+	RSYNC_CF_SYMLINK_TIMES      = 1 << 1
+	RSYNC_CF_SYMLINK_ICONV      = 1 << 2
+	RSYNC_CF_SAFE_FLIST         = 1 << 3
+	RSYNC_CF_AVOID_XATTR_OPTIM  = 1 << 4
+	RSYNC_CF_CHKSUM_SEED_FIX    = 1 << 5
+	RSYNC_CF_VARINT_FLIST_FLAGS = 1 << 7
+
+	// flags of file entry in file list
+	RSYNC_XMIT_TOP_DIR             = 1 << 0
+	RSYNC_XMIT_SAME_MODE           = 1 << 1
+	RSYNC_XMIT_EXTENDED_FLAGS      = 1 << 2
+	RSYNC_XMIT_SAME_UID            = 1 << 3
+	RSYNC_XMIT_SAME_GID            = 1 << 4
+	RSYNC_XMIT_SAME_NAME           = 1 << 5
+	RSYNC_XMIT_LONG_NAME           = 1 << 6
+	RSYNC_XMIT_SAME_TIME           = 1 << 7
+	RSYNC_XMIT_NO_CONTENT_DIR      = 1 << 8
+	RSYNC_XMIT_HLINKED             = 1 << 9
+	RSYNC_XMIT_IO_ERROR_ENDLIST    = 1 << 12
+	RSYNC_XMIT_MOD_NSEC            = 1 << 13
+	RSYNC_XMIT_IO_ERROR_END_OF_ALL = RSYNC_XMIT_EXTENDED_FLAGS | RSYNC_XMIT_IO_ERROR_ENDLIST
+
+	// item flags sent with file index
+	RSYNC_ITEM_BASIS_TYPE_FOLLOWS = 1 << 11
+	RSYNC_ITEM_XNAME_FOLLOWS      = 1 << 12
+	RSYNC_ITEM_IS_NEW             = 1 << 13
+	RSYNC_ITEM_TRANSFER           = 1 << 15
+
+	// file mode
+	RSYNC_S_IFMT  = 0170000
+	RSYNC_S_IFDIR = 0040000
+	RSYNC_S_IFREG = 0100000
+	RSYNC_S_IFLNK = 0120000
+
+	RSYNC_CHUNK_SIZE   = 32 * 1024
+	RSYNC_MAX_PATH_LEN = 4096
+	RSYNC_SUM_LENGTH   = 16
+)
+
+// int_byte_extra of rsync io.c, the count of extra bytes of varint/varlong by first byte/4
+var rsyncIntByteExtra = [64]int{
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, // (00 - 3F)/4
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, // (40 - 7F)/4
+	1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, // (80 - BF)/4
+	2, 2, 2, 2, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 5, 6, // (C0 - FF)/4
+}
+
+// rsyncConn reads and writes rsync protocol data on one connection,
+// it is used by client, and by daemon in tests
+type rsyncConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+
+	protocol    int
+	compatFlags int32
+	seed        int32
+
+	// after multiplex is started, data is in MSG_DATA, and other msgs are read in readData
+	multiplexIn  bool
+	multiplexOut bool
+	dataLeft     int
+
+	writeMutex sync.Mutex
+	writeBuf   []byte
+
+	// state of write_ndx/read_ndx
+	readPrevPositive  int32
+	readPrevNegative  int32
+	writePrevPositive int32
+	writePrevNegative int32
+
+	// msgs from peer
+	errMsgs  []string
+	ioError  int32
+	exitCode int32
+	noSends  map[int32]bool
+}
+
+func newRsyncConn(conn net.Conn, timeout time.Duration) *rsyncConn {
+	return &rsyncConn{
+		conn:              conn,
+		reader:            bufio.NewReaderSize(conn, 64*1024),
+		timeout:           timeout,
+		readPrevPositive:  -1,
+		readPrevNegative:  1,
+		writePrevPositive: -1,
+		writePrevNegative: 1,
+		errMsgs:           make([]string, 0),
+		noSends:           make(map[int32]bool),
+	}
+}
+
+func (c *rsyncConn) setReadDeadline() {
+	if c.timeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+	}
+}
+
+func (c *rsyncConn) setWriteDeadline() {
+	if c.timeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	}
+}
+
+// readLine reads one line of greeting, ends with '\n' (or '\0' when args of protocol 30)
+func (c *rsyncConn) readLine(end byte) (string, error) {
+	c.setReadDeadline()
+	line := make([]byte, 0, 64)
+	for {
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if b == end {
+			break
+		}
+		line = append(line, b)
+		if len(line) > RSYNC_MAX_PATH_LEN {
+			return "", errors.New("line is too long")
+		}
+	}
+	return strings.TrimSuffix(string(line), "\r"), nil
+}
+
+// writeRaw writes bytes not in multiplex, it is used in greeting
+func (c *rsyncConn) writeRaw(b []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	c.setWriteDeadline()
+	_, err := c.conn.Write(b)
+	return err
+}
+
+// readFull reads data, when multiplexIn, msgs will be handled and only MSG_DATA is returned
+func (c *rsyncConn) readFull(b []byte) error {
+	for len(b) > 0 {
+		if !c.multiplexIn {
+			c.setReadDeadline()
+			_, err := io.ReadFull(c.reader, b)
+			return err
+		}
+		for c.dataLeft == 0 {
+			if err := c.readMsg(); err != nil {
+				return err
+			}
+		}
+		n := len(b)
+		if n > c.dataLeft {
+			n = c.dataLeft
+		}
+		c.setReadDeadline()
+		if _, err := io.ReadFull(c.reader, b[:n]); err != nil {
+			return err
+		}
+		c.dataLeft -= n
+		b = b[n:]
+	}
+	return nil
+}
+
+// readMsg reads one multiplex header, MSG_DATA will be read in readFull, other msgs are read here
+func (c *rsyncConn) readMsg() error {
+	header := make([]byte, 4)
+	c.setReadDeadline()
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return err
+	}
+	tag := int(header[3]) - RSYNC_MPLEX_BASE
+	length := int(binary.LittleEndian.Uint32(header) & RSYNC_MSG_MAX_DATA_LENGTH)
+	if tag < 0 {
+		return errors.New("unexpected multiplex tag " + convert.ToString(int(header[3])))
+	}
+	if tag == RSYNC_MSG_DATA {
+		c.dataLeft = length
+		return nil
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return err
+	}
+	switch tag {
+	case RSYNC_MSG_ERROR_XFER, RSYNC_MSG_ERROR, RSYNC_MSG_ERROR_SOCKET, RSYNC_MSG_ERROR_UTF8:
+		msg := strings.TrimSpace(string(payload))
+		belogs.Debug("rsyncConn.readMsg(): error msg:", msg)
+		c.errMsgs = append(c.errMsgs, msg)
+	case RSYNC_MSG_INFO, RSYNC_MSG_WARNING, RSYNC_MSG_LOG, RSYNC_MSG_CLIENT:
+		belogs.Debug("rsyncConn.readMsg(): msg:", tag, strings.TrimSpace(string(payload)))
+	case RSYNC_MSG_IO_ERROR:
+		if length == 4 {
+			c.ioError |= int32(binary.LittleEndian.Uint32(payload))
+		}
+	case RSYNC_MSG_ERROR_EXIT:
+		if length == 4 {
+			c.exitCode = int32(binary.LittleEndian.Uint32(payload))
+		}
+	case RSYNC_MSG_NO_SEND:
+		if length == 4 {
+			c.noSends[int32(binary.LittleEndian.Uint32(payload))] = true
+		}
+	default:
+		// MSG_NOOP, MSG_IO_TIMEOUT, MSG_SUCCESS, MSG_DELETED, MSG_REDO, MSG_STATS, ignore
+		belogs.Debug("rsyncConn.readMsg(): ignore msg:", tag, " length:", length)
+	}
+	return nil
+}
+
+func (c *rsyncConn) readByte() (byte, error) {
+	b := make([]byte, 1)
+	err := c.readFull(b)
+	return b[0], err
+}
+
+func (c *rsyncConn) readInt() (int32, error) {
+	b := make([]byte, 4)
+	if err := c.readFull(b); err != nil {
+		return 0, err
+	}
+	return int32(binary.LittleEndian.Uint32(b)), nil
+}
+
+func (c *rsyncConn) readShortInt() (int, error) {
+	b := make([]byte, 2)
+	if err := c.readFull(b); err != nil {
+		return 0, err
+	}
+	return int(binary.LittleEndian.Uint16(b)), nil
+}
+
+// read_longint: int, or -1 and int64
+func (c *rsyncConn) readLongInt() (int64, error) {
+	i, err := c.readInt()
+	if err != nil || i != -1 {
+		return int64(i), err
+	}
+	b := make([]byte, 8)
+	if err = c.readFull(b); err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(b)), nil
+}
+
+func (c *rsyncConn) readVarint() (int32, error) {
+	ch, err := c.readByte()
+	if err != nil {
+		return 0, err
+	}
+	b := make([]byte, 5)
+	extra := rsyncIntByteExtra[ch/4]
+	if extra > 0 {
+		if extra >= 5 {
+			return 0, errors.New("overflow in readVarint")
+		}
+		if err = c.readFull(b[:extra]); err != nil {
+			return 0, err
+		}
+		bit := byte(1) << (8 - extra)
+		b[extra] = ch & (bit - 1)
+	} else {
+		b[0] = ch
+	}
+	return int32(binary.LittleEndian.Uint32(b[:4])), nil
+}
+
+func (c *rsyncConn) readVarlong(minBytes int) (int64, error) {
+	b2 := make([]byte, minBytes)
+	if err := c.readFull(b2); err != nil {
+		return 0, err
+	}
+	b := make([]byte, 9)
+	copy(b, b2[1:])
+	ch := b2[0]
+	extra := rsyncIntByteExtra[ch/4]
+	if extra > 0 {
+		if minBytes+extra > 9 {
+			return 0, errors.New("overflow in readVarlong")
+		}
+		if err := c.readFull(b[minBytes-1 : minBytes-1+extra]); err != nil {
+			return 0, err
+		}
+		bit := byte(1) << (8 - extra)
+		b[minBytes+extra-1] = ch & (bit - 1)
+	} else {
+		b[minBytes-1] = ch
+	}
+	return int64(binary.LittleEndian.Uint64(b[:8])), nil
+}
+
+// read_varlong30: varlong when protocol >= 30, or longint
+func (c *rsyncConn) readVarlong30(minBytes int) (int64, error) {
+	if c.protocol >= 30 {
+		return c.readVarlong(minBytes)
+	}
+	return c.readLongInt()
+}
+
+// read_varint30: varint when protocol >= 30, or int
+func (c *rsyncConn) readVarint30() (int32, error) {
+	if c.protocol >= 30 {
+		return c.readVarint()
+	}
+	return c.readInt()
+}
+
+func (c *rsyncConn) readNdx() (int32, error) {
+	if c.protocol < 30 {
+		return c.readInt()
+	}
+	b := make([]byte, 4)
+	if err := c.readFull(b[:1]); err != nil {
+		return 0, err
+	}
+	prev := &c.readPrevPositive
+	if b[0] == 0xFF {
+		if err := c.readFull(b[:1]); err != nil {
+			return 0, err
+		}
+		prev = &c.readPrevNegative
+	} else if b[0] == 0 {
+		return RSYNC_NDX_DONE, nil
+	}
+	var num int32
+	if b[0] == 0xFE {
+		if err := c.readFull(b[:2]); err != nil {
+			return 0, err
+		}
+		if b[0]&0x80 != 0 {
+			b[3] = b[0] &^ 0x80
+			b[0] = b[1]
+			if err := c.readFull(b[1:3]); err != nil {
+				return 0, err
+			}
+			num = int32(binary.LittleEndian.Uint32(b))
+		} else {
+			num = int32(b[0])<<8 + int32(b[1]) + *prev
+		}
+	} else {
+		num = int32(b[0]) + *prev
+	}
+	*prev = num
+	if prev == &c.readPrevNegative {
+		num = -num
+	}
+	return num, nil
+}
+
+// readVstring reads string with varint30 length
+func (c *rsyncConn) readVstring() (string, error) {
+	l, err := c.readVarint30()
+	if err != nil {
+		return "", err
+	}
+	if l < 0 || l > RSYNC_MAX_PATH_LEN {
+		return "", errors.New("string length " + convert.ToString(l) + " is invalid")
+	}
+	b := make([]byte, l)
+	if err = c.readFull(b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// write data is saved in writeBuf, and sent when flush
+func (c *rsyncConn) writeBytes(b []byte) {
+	c.writeMutex.Lock()
+	c.writeBuf = append(c.writeBuf, b...)
+	c.writeMutex.Unlock()
+}
+
+func (c *rsyncConn) writeByte(b byte) {
+	c.writeBytes([]byte{b})
+}
+
+func (c *rsyncConn) writeInt(i int32) {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, uint32(i))
+	c.writeBytes(b)
+}
+
+func (c *rsyncConn) writeShortInt(i int) {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, uint16(i))
+	c.writeBytes(b)
+}
+
+func (c *rsyncConn) writeLongInt(i int64) {
+	if i >= 0 && i <= 0x7FFFFFFF {
+		c.writeInt(int32(i))
+		return
+	}
+	b := make([]byte, 12)
+	binary.LittleEndian.PutUint32(b, 0xFFFFFFFF)
+	binary.LittleEndian.PutUint64(b[4:], uint64(i))
+	c.writeBytes(b)
+}
+
+func (c *rsyncConn) writeVarint(x int32) {
+	b := make([]byte, 5)
+	binary.LittleEndian.PutUint32(b[1:], uint32(x))
+	cnt := 4
+	for cnt > 1 && b[cnt] == 0 {
+		cnt--
+	}
+	bit := byte(1) << (7 - cnt + 1)
+	if b[cnt] >= bit {
+		cnt++
+		b[0] = ^(bit - 1)
+	} else if cnt > 1 {
+		b[0] = b[cnt] | ^(bit*2 - 1)
+	} else {
+		b[0] = b[cnt]
+	}
+	c.writeBytes(b[:cnt])
+}
+
+func (c *rsyncConn) writeVarlong(x int64, minBytes int) {
+	b := make([]byte, 9)
+	binary.LittleEndian.PutUint64(b[1:], uint64(x))
+	cnt := 8
+	for cnt > minBytes && b[cnt] == 0 {
+		cnt--
+	}
+	bit := byte(1) << (7 - cnt + minBytes)
+	if b[cnt] >= bit {
+		cnt++
+		b[0] = ^(bit - 1)
+	} else if cnt > minBytes {
+		b[0] = b[cnt] | ^(bit*2 - 1)
+	} else {
+		b[0] = b[cnt]
+	}
+	c.writeBytes(b[:cnt])
+}
+
+func (c *rsyncConn) writeVarlong30(x int64, minBytes int) {
+	if c.protocol >= 30 {
+		c.writeVarlong(x, minBytes)
+		return
+	}
+	c.writeLongInt(x)
+}
+
+func (c *rsyncConn) writeVarint30(x int32) {
+	if c.protocol >= 30 {
+		c.writeVarint(x)
+		return
+	}
+	c.writeInt(x)
+}
+
+func (c *rsyncConn) writeNdx(ndx int32) {
+	if c.protocol < 30 {
+		c.writeInt(ndx)
+		return
+	}
+	b := make([]byte, 0, 6)
+	var diff int32
+	if ndx >= 0 {
+		diff = ndx - c.writePrevPositive
+		c.writePrevPositive = ndx
+	} else if ndx == RSYNC_NDX_DONE {
+		c.writeByte(0)
+		return
+	} else {
+		b = append(b, 0xFF)
+		ndx = -ndx
+		diff = ndx - c.writePrevNegative
+		c.writePrevNegative = ndx
+	}
+	if diff > 0 && diff < 0xFE {
+		b = append(b, byte(diff))
+	} else if diff < 0 || diff > 0x7FFF {
+		b = append(b, 0xFE, byte(ndx>>24)|0x80, byte(ndx), byte(ndx>>8), byte(ndx>>16))
+	} else {
+		b = append(b, 0xFE, byte(diff>>8), byte(diff))
+	}
+	c.writeBytes(b)
+}
+
+func (c *rsyncConn) writeVstring(s string) {
+	c.writeVarint30(int32(len(s)))
+	c.writeBytes([]byte(s))
+}
+
+// writeMsg sends a msg (not MSG_DATA) in multiplex, the buffered data will be sent before it
+func (c *rsyncConn) writeMsg(tag int, payload []byte) error {
+	if err := c.flush(); err != nil {
+		return err
+	}
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	c.setWriteDeadline()
+	_, err := c.conn.Write(append(rsyncMplexHeader(tag, len(payload)), payload...))
+	return err
+}
+
+// flush sends buffered data, in MSG_DATA when multiplexOut
+func (c *rsyncConn) flush() error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if len(c.writeBuf) == 0 {
+		return nil
+	}
+	c.setWriteDeadline()
+	if !c.multiplexOut {
+		_, err := c.conn.Write(c.writeBuf)
+		c.writeBuf = c.writeBuf[:0]
+		return err
+	}
+	for len(c.writeBuf) > 0 {
+		n := len(c.writeBuf)
+		if n > RSYNC_MSG_MAX_DATA_LENGTH {
+			n = RSYNC_MSG_MAX_DATA_LENGTH
+		}
+		if _, err := c.conn.Write(append(rsyncMplexHeader(RSYNC_MSG_DATA, n), c.writeBuf[:n]...)); err != nil {
+			return err
+		}
+		c.writeBuf = c.writeBuf[n:]
+	}
+	return nil
+}
+
+func rsyncMplexHeader(tag int, length int) []byte {
+	header := make([]byte, 4)
+	binary.LittleEndian.PutUint32(header, uint32(RSYNC_MPLEX_BASE+tag)<<24|uint32(length))
+	return header
+}
@@ -0,0 +1,77 @@
+package rsyncutil
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestRsyncConnCodec(t *testing.T) {
+	for _, protocol := range []int{29, 31} {
+		w := newRsyncConn(nil, 0)
+		w.protocol = protocol
+		varints := []int32{0, 1, 127, 128, 255, 16383, 16384, 1 << 24, -1}
+		varlongs := []int64{0, 1, 1 << 20, 1 << 31, 1 << 40, 1700000000}
+		ndxs := []int32{0, 1, 2, 300, 100000, 5, RSYNC_NDX_DONE, RSYNC_NDX_DEL_STATS, 3}
+		for _, v := range varints {
+			w.writeVarint(v)
+		}
+		for _, v := range varlongs {
+			w.writeVarlong(v, 3)
+			w.writeVarlong30(v, 3)
+		}
+		for _, v := range ndxs {
+			w.writeNdx(v)
+		}
+
+		r := newRsyncConn(nil, 0)
+		r.protocol = protocol
+		r.reader = bufio.NewReader(bytes.NewReader(w.writeBuf))
+		for _, v := range varints {
+			if got, err := r.readVarint(); err != nil || got != v {
+				t.Fatal(protocol, "varint", v, got, err)
+			}
+		}
+		for _, v := range varlongs {
+			if got, err := r.readVarlong(3); err != nil || got != v {
+				t.Fatal(protocol, "varlong", v, got, err)
+			}
+			if got, err := r.readVarlong30(3); err != nil || got != v {
+				t.Fatal(protocol, "varlong30", v, got, err)
+			}
+		}
+		for _, v := range ndxs {
+			if got, err := r.readNdx(); err != nil || got != v {
+				t.Fatal(protocol, "ndx", v, got, err)
+			}
+		}
+	}
+}
+
+func TestSortRsyncFileEntries(t *testing.T) {
+	names := []string{"z.roa", "b/x.cer", "b", "a.cer", ".", "b/c", "b/c/y.mft"}
+	dirs := map[string]bool{".": true, "b": true, "b/c": true}
+	for protocol, expect := range map[int]string{
+		29: ". a.cer z.roa b b/x.cer b/c b/c/y.mft",
+		28: ". a.cer b b/c b/c/y.mft b/x.cer z.roa",
+	} {
+		entries := make([]*RsyncFileEntry, 0)
+		for _, name := range names {
+			entry := &RsyncFileEntry{Name: name, Mode: RSYNC_S_IFREG}
+			if dirs[name] {
+				entry.Mode = RSYNC_S_IFDIR
+			}
+			entries = append(entries, entry)
+		}
+		sortRsyncFileEntries(entries, protocol)
+		sorted := make([]string, 0)
+		for _, entry := range entries {
+			sorted = append(sorted, entry.Name)
+		}
+		fmt.Println(protocol, sorted)
+		if fmt.Sprint(sorted) != "["+expect+"]" {
+			t.Fatal(protocol, "sort is wrong:", sorted)
+		}
+	}
+}
@@ -0,0 +1,519 @@
+package rtrutil
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cpusoft/goutil/belogs"
+	"github.com/cpusoft/goutil/convert"
+	"github.com/cpusoft/goutil/jsonutil"
+	"github.com/cpusoft/goutil/transportutil"
+)
+
+const (
+	RTR_CONN_TO_BUSINESS_MSG_TYPE_RESPONSE      = "rtrResponse"
+	RTR_CONN_TO_BUSINESS_MSG_TYPE_SERIAL_NOTIFY = "rtrSerialNotify"
+)
+
+// RtrClientConfig: Tls*FileName are only for StartTlsClient
+type RtrClientConfig struct {
+	// protocol version of queries, RTR_PROTOCOL_VERSION_0/1/2.
+	// it is downgraded when cache does not support it
+	ProtocolVersion uint8 `json:"protocolVersion"`
+	// send Serial Query when Serial Notify is received
+	SyncOnSerialNotify bool `json:"syncOnSerialNotify"`
+
+	TlsRootCrtFileName    string `json:"tlsRootCrtFileName"`
+	TlsPublicCrtFileName  string `json:"tlsPublicCrtFileName"`
+	TlsPrivateKeyFileName string `json:"tlsPrivateKeyFileName"`
+}
+
+// RtrClientState is session, serial and timers of client
+type RtrClientState struct {
+	ProtocolVersion uint8  `json:"protocolVersion"`
+	HasData         bool   `json:"hasData"`
+	SessionId       uint16 `json:"sessionId"`
+	SerialNumber    uint32 `json:"serialNumber"`
+	// last serial number of Serial Notify
+	NotifiedSerialNumber uint32 `json:"notifiedSerialNumber"`
+
+	RefreshInterval uint32    `json:"refreshInterval"`
+	RetryInterval   uint32    `json:"retryInterval"`
+	ExpireInterval  uint32    `json:"expireInterval"`
+	LastUpdateTime  time.Time `json:"lastUpdateTime"`
+	NextRefreshTime time.Time `json:"nextRefreshTime"`
+	ExpireTime      time.Time `json:"expireTime"`
+
+	VrpCount       uint64 `json:"vrpCount"`
+	RouterKeyCount uint64 `json:"routerKeyCount"`
+	AspaCount      uint64 `json:"aspaCount"`
+}
+
+// RtrClient is RPKI-to-Router client, implements transportutil.TcpClientProcess
+type RtrClient struct {
+	rtrClientConfig RtrClientConfig
+	server          string
+	isTls           bool
+	tcpClient       *transportutil.TcpClient
+
+	// only one query at the same time
+	queryMutex sync.Mutex
+
+	dataMutex sync.RWMutex
+	state     RtrClientState
+	records   map[string]*rtrRecord
+	// waiting for response of query
+	isWaiting    bool
+	isResetQuery bool
+	// pdus between Cache Response and End of Data
+	response *rtrClientResponse
+}
+
+type rtrClientResponse struct {
+	isReset   bool
+	sessionId uint16
+	rtrPdus   []RtrPdu
+}
+
+// result of one query, is ReceiveData of ConnToBusinessMsg
+type rtrClientResult struct {
+	isCacheReset bool
+	err          error
+}
+
+func NewRtrClient(rtrClientConfig RtrClientConfig) *RtrClient {
+	belogs.Debug("NewRtrClient(): rtrClientConfig:", jsonutil.MarshalJson(rtrClientConfig))
+	rc := &RtrClient{
+		rtrClientConfig: rtrClientConfig,
+		records:         make(map[string]*rtrRecord),
+	}
+	rc.state.ProtocolVersion = rtrClientConfig.ProtocolVersion
+	return rc
+}
+
+// StartTcpClient connects to cache, server: **.**.**.**:port
+func (rc *RtrClient) StartTcpClient(server string) error {
+	rc.server = server
+	rc.isTls = false
+	return rc.connect()
+}
+
+// StartTlsClient connects to cache by tls, server: **.**.**.**:port
+func (rc *RtrClient) StartTlsClient(server string) error {
+	rc.server = server
+	rc.isTls = true
+	return rc.connect()
+}
+
+func (rc *RtrClient) connect() (err error) {
+	businessToConnMsg := make(chan transportutil.BusinessToConnMsg, 16)
+	if rc.isTls {
+		rc.tcpClient, err = transportutil.NewTlsClient(rc.rtrClientConfig.TlsRootCrtFileName, rc.rtrClientConfig.TlsPublicCrtFileName,
+			rc.rtrClientConfig.TlsPrivateKeyFileName, rc, businessToConnMsg, "false", RTR_RECEIVE_ONE_PACKET_LENGTH)
+		if err != nil {
+			belogs.Error("RtrClient.connect(): NewTlsClient fail, server:", rc.server, err)
+			return err
+		}
+		err = rc.tcpClient.StartTlsClient(rc.server)
+	} else {
+		rc.tcpClient = transportutil.NewTcpClient(rc, businessToConnMsg, "false", RTR_RECEIVE_ONE_PACKET_LENGTH)
+		err = rc.tcpClient.StartTcpClient(rc.server)
+	}
+	if err != nil {
+		belogs.Error("RtrClient.connect(): start client fail, server:", rc.server, "  isTls:", rc.isTls, err)
+		return err
+	}
+	belogs.Info("RtrClient.connect(): server:", rc.server, "  isTls:", rc.isTls)
+	return nil
+}
+
+// Close closes connection to cache
+func (rc *RtrClient) Close() {
+	if rc.tcpClient != nil {
+		rc.tcpClient.SendAndReceiveMsg(&transportutil.BusinessToConnMsg{
+			BusinessToConnMsgType: transportutil.BUSINESS_TO_CONN_MSG_TYPE_CLIENT_CLOSE_CONNECT,
+		})
+	}
+}
+
+// Sync sends Serial Query when has data, or Reset Query
+func (rc *RtrClient) Sync() error {
+	rc.dataMutex.RLock()
+	hasData := rc.state.HasData
+	rc.dataMutex.RUnlock()
+	if hasData {
+		return rc.SerialQuery()
+	}
+	return rc.ResetQuery()
+}
+
+// ResetQuery gets all data from cache
+func (rc *RtrClient) ResetQuery() error {
+	rc.queryMutex.Lock()
+	defer rc.queryMutex.Unlock()
+	return rc.resetQuery()
+}
+
+// SerialQuery gets changes from cache, and sends Reset Query when cache responds Cache Reset
+func (rc *RtrClient) SerialQuery() error {
+	rc.queryMutex.Lock()
+	defer rc.queryMutex.Unlock()
+
+	rc.dataMutex.RLock()
+	state := rc.state
+	rc.dataMutex.RUnlock()
+	if !state.HasData {
+		belogs.Error("RtrClient.SerialQuery(): has no data, should send Reset Query first")
+		return errors.New("client has no data, should send Reset Query first")
+	}
+	serialQueryPdu := &SerialQueryPdu{ProtocolVersion: state.ProtocolVersion, SessionId: state.SessionId, SerialNumber: state.SerialNumber}
+	result, err := rc.query(serialQueryPdu)
+	if err != nil {
+		belogs.Error("RtrClient.SerialQuery(): query fail, serialQueryPdu:", jsonutil.MarshalJson(serialQueryPdu), err)
+		return err
+	}
+	if result.isCacheReset {
+		belogs.Info("RtrClient.SerialQuery(): receive Cache Reset, will send Reset Query, serialNumber:", state.SerialNumber)
+		return rc.resetQuery()
+	}
+	return result.err
+}
+
+// should be called with queryMutex
+func (rc *RtrClient) resetQuery() error {
+	rc.dataMutex.RLock()
+	protocolVersion := rc.state.ProtocolVersion
+	hasData := rc.state.HasData
+	rc.dataMutex.RUnlock()
+
+	result, err := rc.query(&ResetQueryPdu{ProtocolVersion: protocolVersion})
+	if err != nil {
+		belogs.Error("RtrClient.resetQuery(): query fail, protocolVersion:", protocolVersion, err)
+		return err
+	}
+	var rtrError *RtrError
+	if errors.As(result.err, &rtrError) && rtrError.ErrorCode == RTR_ERROR_CODE_UNSUPPORTED_PROTOCOL_VERSION && !hasData {
+		// version is downgraded in OnReceiveProcess, cache has closed connection
+		rc.dataMutex.RLock()
+		newProtocolVersion := rc.state.ProtocolVersion
+		rc.dataMutex.RUnlock()
+		if newProtocolVersion < protocolVersion {
+			belogs.Info("RtrClient.resetQuery(): downgrade protocolVersion from", protocolVersion, "to", newProtocolVersion)
+			// close previous connection, or it and its goroutines are leaked when cache does not close it
+			rc.Close()
+			if err = rc.connect(); err != nil {
+				return err
+			}
+			return rc.resetQuery()
+		}
+	}
+	if result.isCacheReset {
+		return errors.New("cache responds Cache Reset to Reset Query")
+	}
+	return result.err
+}
+
+// query sends pdu and waits for End of Data/Cache Reset/Error Report
+func (rc *RtrClient) query(rtrPdu RtrPdu) (*rtrClientResult, error) {
+	if rc.tcpClient == nil {
+		return nil, errors.New("client is not started")
+	}
+	rc.dataMutex.Lock()
+	rc.isWaiting = true
+	rc.isResetQuery = rtrPdu.GetPduType() == RTR_PDU_TYPE_RESET_QUERY
+	rc.response = nil
+	rc.dataMutex.Unlock()
+	defer func() {
+		rc.dataMutex.Lock()
+		rc.isWaiting = false
+		rc.dataMutex.Unlock()
+	}()
+
+	belogs.Debug("RtrClient.query(): rtrPdu:", jsonutil.MarshalJson(rtrPdu))
+	connToBusinessMsg, err := rc.tcpClient.SendAndReceiveMsg(&transportutil.BusinessToConnMsg{
+		BusinessToConnMsgType:           transportutil.BUSINESS_TO_CONN_MSG_TYPE_COMMON_SEND_DATA,
+		SendData:                        rtrPdu.Bytes(),
+		NeedClientWaitForServerResponse: true,
+	})
+	if err != nil {
+		belogs.Error("RtrClient.query(): SendAndReceiveMsg fail, rtrPdu:", jsonutil.MarshalJson(rtrPdu), err)
+		return nil, err
+	}
+	result, ok := connToBusinessMsg.ReceiveData.(*rtrClientResult)
+	if !ok {
+		return nil, errors.New("response of cache is invalid")
+	}
+	return result, nil
+}
+
+func (rc *RtrClient) OnConnectProcess(tcpConn *transportutil.TcpConn) {
+	belogs.Info("RtrClient.OnConnectProcess(): cache:", tcpConn.RemoteAddr().String())
+}
+
+func (rc *RtrClient) OnCloseProcess(tcpConn *transportutil.TcpConn) {
+	belogs.Info("RtrClient.OnCloseProcess(): cache:", tcpConn.RemoteAddr().String())
+}
+
+func (rc *RtrClient) OnReceiveProcess(tcpConn *transportutil.TcpConn, receiveData []byte) (nextRwPolicy int,
+	leftData []byte, connToBusinessMsg *transportutil.ConnToBusinessMsg, err error) {
+	belogs.Debug("RtrClient.OnReceiveProcess(): len(receiveData):", len(receiveData))
+	connToBusinessMsg = &transportutil.ConnToBusinessMsg{
+		IsActiveSendFromServer: true,
+		ConnToBusinessMsgType:  RTR_CONN_TO_BUSINESS_MSG_TYPE_SERIAL_NOTIFY,
+	}
+	packets, leftData, err := transportutil.RecombineReceiveData(receiveData, RTR_PDU_HEADER_LENGTH,
+		RTR_PDU_LENGTH_START, RTR_PDU_LENGTH_END)
+	if err != nil {
+		belogs.Error("RtrClient.OnReceiveProcess(): RecombineReceiveData fail:", err)
+		return transportutil.NEXT_RW_POLICY_END_READ, nil, connToBusinessMsg, err
+	}
+
+	syncOnSerialNotify := false
+	rc.dataMutex.Lock()
+	for e := packets.Front(); e != nil; e = e.Next() {
+		packet, _ := e.Value.([]byte)
+		result, serialNotify := rc.processPdu(tcpConn, packet)
+		syncOnSerialNotify = syncOnSerialNotify || serialNotify
+		if result != nil && rc.isWaiting {
+			rc.isWaiting = false
+			connToBusinessMsg = &transportutil.ConnToBusinessMsg{
+				IsActiveSendFromServer: false,
+				ConnToBusinessMsgType:  RTR_CONN_TO_BUSINESS_MSG_TYPE_RESPONSE,
+				ReceiveData:            result,
+			}
+		}
+	}
+	rc.dataMutex.Unlock()
+
+	if syncOnSerialNotify && rc.rtrClientConfig.SyncOnSerialNotify {
+		go func() {
+			if err := rc.Sync(); err != nil {
+				belogs.Error("RtrClient.OnReceiveProcess(): Sync after Serial Notify fail:", err)
+			}
+		}()
+	}
+	return transportutil.NEXT_RW_POLICY_WAIT_READ, leftData, connToBusinessMsg, nil
+}
+
+// processPdu should be called with dataMutex, result is not nil when response is end
+func (rc *RtrClient) processPdu(tcpConn *transportutil.TcpConn, packet []byte) (result *rtrClientResult, serialNotify bool) {
+	rtrPdu, err := ParseRtrPdu(packet)
+	if err != nil {
+		belogs.Error("RtrClient.processPdu(): ParseRtrPdu fail, packet:", convert.PrintBytesOneLine(packet), err)
+		rc.sendErrorReport(tcpConn, packet, err)
+		return &rtrClientResult{err: err}, false
+	}
+	belogs.Debug("RtrClient.processPdu(): rtrPdu:", jsonutil.MarshalJson(rtrPdu))
+
+	switch p := rtrPdu.(type) {
+	case *SerialNotifyPdu:
+		rc.state.NotifiedSerialNumber = p.SerialNumber
+		belogs.Info("RtrClient.processPdu(): receive Serial Notify, sessionId:", p.SessionId, "  serialNumber:", p.SerialNumber)
+		return nil, rc.state.HasData && p.SessionId == rc.state.SessionId && p.SerialNumber != rc.state.SerialNumber
+	case *ErrorReportPdu:
+		belogs.Error("RtrClient.processPdu(): receive Error Report:", jsonutil.MarshalJson(p))
+		if p.ErrorCode == RTR_ERROR_CODE_UNSUPPORTED_PROTOCOL_VERSION && p.ProtocolVersion < rc.state.ProtocolVersion {
+			rc.state.ProtocolVersion = p.ProtocolVersion
+		}
+		rc.response = nil
+		return &rtrClientResult{err: newRtrError(p.ErrorCode, p.ErrorText)}, false
+	case *CacheResetPdu:
+		rc.response = nil
+		return &rtrClientResult{isCacheReset: true}, false
+	}
+
+	if rtrPdu.GetProtocolVersion() != rc.state.ProtocolVersion {
+		err = newRtrError(RTR_ERROR_CODE_UNEXPECTED_PROTOCOL_VERSION, "protocol version is different from the negotiated")
+	} else if p, ok := rtrPdu.(*CacheResponsePdu); ok {
+		if rc.response != nil {
+			err = newRtrError(RTR_ERROR_CODE_CORRUPT_DATA, "Cache Response is received before End of Data")
+		} else if !rc.isResetQuery && p.SessionId != rc.state.SessionId {
+			err = newRtrError(RTR_ERROR_CODE_CORRUPT_DATA, "sessionId of Cache Response is different")
+		} else {
+			rc.response = &rtrClientResponse{isReset: rc.isResetQuery, sessionId: p.SessionId, rtrPdus: make([]RtrPdu, 0)}
+			return nil, false
+		}
+	} else if rc.response == nil {
+		err = newRtrError(RTR_ERROR_CODE_INVALID_REQUEST, "pdu type "+strconv.Itoa(int(rtrPdu.GetPduType()))+" is not in response")
+	} else if p, ok := rtrPdu.(*EndOfDataPdu); ok {
+		err = rc.applyResponse(p)
+		rc.response = nil
+		if err == nil {
+			return &rtrClientResult{}, false
+		}
+	} else {
+		rc.response.rtrPdus = append(rc.response.rtrPdus, rtrPdu)
+		return nil, false
+	}
+	rc.response = nil
+	rc.sendErrorReport(tcpConn, packet, err)
+	return &rtrClientResult{err: err}, false
+}
+
+// applyResponse applies pdus of response to records, should be called with dataMutex
+func (rc *RtrClient) applyResponse(endOfDataPdu *EndOfDataPdu) error {
+	if endOfDataPdu.SessionId != rc.response.sessionId {
+		return newRtrError(RTR_ERROR_CODE_CORRUPT_DATA, "sessionId of End of Data is different from Cache Response")
+	}
+	records := make(map[string]*rtrRecord)
+	if !rc.response.isReset {
+		for key, record := range rc.records {
+			records[key] = record
+		}
+	}
+	for _, rtrPdu := range rc.response.rtrPdus {
+		record, flags, err := getRtrRecordFromPdu(rtrPdu)
+		if err != nil {
+			return err
+		}
+		_, exists := records[record.key]
+		if flags&RTR_FLAG_ANNOUNCE == 0 {
+			if !exists {
+				return newRtrError(RTR_ERROR_CODE_WITHDRAWAL_OF_UNKNOWN_RECORD, "withdrawal of unknown record: "+record.key)
+			}
+			delete(records, record.key)
+			continue
+		}
+		// aspa of existing customer is replaced
+		if exists && record.aspa == nil {
+			return newRtrError(RTR_ERROR_CODE_DUPLICATE_ANNOUNCEMENT, "duplicate announcement: "+record.key)
+		}
+		records[record.key] = record
+	}
+
+	rc.records = records
+	rc.state.HasData = true
+	rc.state.SessionId = endOfDataPdu.SessionId
+	rc.state.SerialNumber = endOfDataPdu.SerialNumber
+	rc.state.LastUpdateTime = time.Now()
+	if endOfDataPdu.ProtocolVersion == RTR_PROTOCOL_VERSION_0 {
+		// version 0 has no intervals
+		rc.state.RefreshInterval = RTR_DEFAULT_REFRESH_INTERVAL
+		rc.state.RetryInterval = RTR_DEFAULT_RETRY_INTERVAL
+		rc.state.ExpireInterval = RTR_DEFAULT_EXPIRE_INTERVAL
+	} else {
+		rc.state.RefreshInterval = endOfDataPdu.RefreshInterval
+		rc.state.RetryInterval = endOfDataPdu.RetryInterval
+		rc.state.ExpireInterval = endOfDataPdu.ExpireInterval
+	}
+	rc.state.NextRefreshTime = rc.state.LastUpdateTime.Add(time.Duration(rc.state.RefreshInterval) * time.Second)
+	rc.state.ExpireTime = rc.state.LastUpdateTime.Add(time.Duration(rc.state.ExpireInterval) * time.Second)
+	rc.state.VrpCount, rc.state.RouterKeyCount, rc.state.AspaCount = 0, 0, 0
+	for _, record := range records {
+		switch {
+		case record.vrp != nil:
+			rc.state.VrpCount++
+		case record.routerKey != nil:
+			rc.state.RouterKeyCount++
+		default:
+			rc.state.AspaCount++
+		}
+	}
+	belogs.Info("RtrClient.applyResponse(): isReset:", rc.response.isReset, "  len(rtrPdus):", len(rc.response.rtrPdus),
+		"  state:", jsonutil.MarshalJson(rc.state))
+	return nil
+}
+
+// getRtrRecordFromPdu converts prefix/router key/aspa pdu to record, key is same as getRtrRecords
+func getRtrRecordFromPdu(rtrPdu RtrPdu) (record *rtrRecord, flags uint8, err error) {
+	rtrData := &RtrData{}
+	switch p := rtrPdu.(type) {
+	case *Ipv4PrefixPdu:
+		flags = p.Flags
+		rtrData.Vrps = []RtrVrp{{Prefix: p.Prefix + "/" + strconv.Itoa(int(p.PrefixLength)), MaxLength: p.MaxLength, Asn: p.Asn}}
+	case *Ipv6PrefixPdu:
+		flags = p.Flags
+		rtrData.Vrps = []RtrVrp{{Prefix: p.Prefix + "/" + strconv.Itoa(int(p.PrefixLength)), MaxLength: p.MaxLength, Asn: p.Asn}}
+	case *RouterKeyPdu:
+		flags = p.Flags
+		rtrData.RouterKeys = []RtrRouterKey{{SubjectKeyIdentifier: p.SubjectKeyIdentifier, Asn: p.Asn,
+			SubjectPublicKeyInfo: p.SubjectPublicKeyInfo}}
+	case *AspaPdu:
+		flags = p.Flags
+		if flags&RTR_FLAG_ANNOUNCE == 0 {
+			key := "aspa|" + strconv.FormatUint(uint64(p.CustomerAsn), 10)
+			return &rtrRecord{key: key, protocolVersion: RTR_PROTOCOL_VERSION_2,
+				aspa: &RtrAspa{CustomerAsn: p.CustomerAsn, ProviderAsns: make([]uint32, 0)}}, flags, nil
+		}
+		rtrData.Aspas = []RtrAspa{{CustomerAsn: p.CustomerAsn, ProviderAsns: p.ProviderAsns}}
+	default:
+		return nil, 0, newRtrError(RTR_ERROR_CODE_INVALID_REQUEST, "pdu type "+strconv.Itoa(int(rtrPdu.GetPduType()))+" is not in response")
+	}
+	records, err := getRtrRecords(rtrData)
+	if err != nil {
+		return nil, 0, newRtrError(RTR_ERROR_CODE_CORRUPT_DATA, err.Error())
+	}
+	// only one record
+	for _, record := range records {
+		return record, flags, nil
+	}
+	return nil, 0, newRtrError(RTR_ERROR_CODE_CORRUPT_DATA, "pdu has no record")
+}
+
+// should be called with dataMutex
+func (rc *RtrClient) sendErrorReport(tcpConn *transportutil.TcpConn, packet []byte, err error) {
+	errorReportPdu := &ErrorReportPdu{
+		ProtocolVersion: rc.state.ProtocolVersion,
+		ErrorCode:       RTR_ERROR_CODE_INTERNAL_ERROR,
+		ErroneousPdu:    packet,
+		ErrorText:       err.Error(),
+	}
+	var rtrError *RtrError
+	if errors.As(err, &rtrError) {
+		errorReportPdu.ErrorCode = rtrError.ErrorCode
+		errorReportPdu.ErrorText = rtrError.Message
+	}
+	if _, err := tcpConn.Write(errorReportPdu.Bytes()); err != nil {
+		belogs.Error("RtrClient.sendErrorReport(): Write fail, errorReportPdu:", jsonutil.MarshalJson(errorReportPdu), err)
+	}
+}
+
+// GetState gets session, serial and timers
+func (rc *RtrClient) GetState() RtrClientState {
+	rc.dataMutex.RLock()
+	defer rc.dataMutex.RUnlock()
+	return rc.state
+}
+
+// IsExpired: data is expired when not updated in expire interval
+func (rc *RtrClient) IsExpired() bool {
+	rc.dataMutex.RLock()
+	defer rc.dataMutex.RUnlock()
+	return !rc.state.HasData || time.Now().After(rc.state.ExpireTime)
+}
+
+// GetRtrData gets current vrps, router keys and aspas, sorted
+func (rc *RtrClient) GetRtrData() *RtrData {
+	rc.dataMutex.RLock()
+	keys := make([]string, 0, len(rc.records))
+	for key := range rc.records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	rtrData := &RtrData{
+		Vrps:       make([]RtrVrp, 0),
+		RouterKeys: make([]RtrRouterKey, 0),
+		Aspas:      make([]RtrAspa, 0),
+	}
+	for _, key := range keys {
+		record := rc.records[key]
+		switch {
+		case record.vrp != nil:
+			rtrData.Vrps = append(rtrData.Vrps, *record.vrp)
+		case record.routerKey != nil:
+			rtrData.RouterKeys = append(rtrData.RouterKeys, *record.routerKey)
+		default:
+			rtrData.Aspas = append(rtrData.Aspas, *record.aspa)
+		}
+	}
+	rc.dataMutex.RUnlock()
+	return rtrData
+}
+
+// GetVrps gets current vrps, sorted
+func (rc *RtrClient) GetVrps() []RtrVrp {
+	return rc.GetRtrData().Vrps
+}
@@ -0,0 +1,172 @@
+package rtrutil
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cpusoft/goutil/jsonutil"
+)
+
+func startTestRtrServer(t *testing.T, rtrServerConfig RtrServerConfig) (*RtrServer, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	listener.Close()
+	rs := NewRtrServer(rtrServerConfig)
+	go rs.StartTcpServer(port)
+	for i := 0; i < 20; i++ {
+		if conn, err := net.Dial("tcp", "127.0.0.1:"+port); err == nil {
+			conn.Close()
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return rs, "127.0.0.1:" + port
+}
+
+func TestRtrClient(t *testing.T) {
+	rs, server := startTestRtrServer(t, RtrServerConfig{SessionId: 9, RefreshInterval: 60, MaxDeltaCount: 1})
+	defer rs.Stop()
+
+	rc := NewRtrClient(RtrClientConfig{ProtocolVersion: RTR_PROTOCOL_VERSION_2, SyncOnSerialNotify: true})
+	if err := rc.StartTcpClient(server); err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	// no data
+	err := rc.Sync()
+	var rtrError *RtrError
+	if !errors.As(err, &rtrError) || rtrError.ErrorCode != RTR_ERROR_CODE_NO_DATA_AVAILABLE {
+		t.Fatal("should be no data available:", err)
+	}
+
+	rs.SetData(&RtrData{
+		Vrps: []RtrVrp{{Prefix: "192.0.2.0/24", MaxLength: 24, Asn: 65001},
+			{Prefix: "2001:db8::/32", MaxLength: 48, Asn: 65002}},
+		Aspas: []RtrAspa{{CustomerAsn: 65004, ProviderAsns: []uint32{3, 1}}},
+	})
+	if err = rc.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	state := rc.GetState()
+	fmt.Println(jsonutil.MarshalJson(state), jsonutil.MarshalJson(rc.GetRtrData()))
+	if !state.HasData || state.SessionId != 9 || state.SerialNumber != 0 || state.RefreshInterval != 60 ||
+		state.VrpCount != 2 || state.AspaCount != 1 || rc.IsExpired() {
+		t.Fatal("state is wrong:", jsonutil.MarshalJson(state))
+	}
+
+	// Serial Notify, then client sends Serial Query itself
+	rs.SetData(&RtrData{
+		Vrps:  []RtrVrp{{Prefix: "192.0.2.0/24", MaxLength: 24, Asn: 65001}},
+		Aspas: []RtrAspa{{CustomerAsn: 65004, ProviderAsns: []uint32{5}}},
+	})
+	for i := 0; i < 20 && rc.GetState().SerialNumber != 1; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	rtrData := rc.GetRtrData()
+	fmt.Println(jsonutil.MarshalJson(rc.GetState()), jsonutil.MarshalJson(rtrData))
+	if rc.GetState().SerialNumber != 1 || rc.GetState().NotifiedSerialNumber != 1 || len(rtrData.Vrps) != 1 ||
+		rtrData.Aspas[0].ProviderAsns[0] != 5 {
+		t.Fatal("serial update is wrong:", jsonutil.MarshalJson(rtrData))
+	}
+
+	// only one delta is kept, so serial 1 -> 3 is Cache Reset, and then Reset Query
+	rc.rtrClientConfig.SyncOnSerialNotify = false
+	rs.SetData(&RtrData{Vrps: []RtrVrp{{Prefix: "198.51.100.0/24", MaxLength: 24, Asn: 65005}}})
+	rs.SetData(&RtrData{Vrps: []RtrVrp{{Prefix: "198.51.100.0/24", MaxLength: 24, Asn: 65006}}})
+	if err = rc.SerialQuery(); err != nil {
+		t.Fatal(err)
+	}
+	rtrData = rc.GetRtrData()
+	if rc.GetState().SerialNumber != 3 || len(rtrData.Vrps) != 1 || rtrData.Vrps[0].Asn != 65006 || len(rtrData.Aspas) != 0 {
+		t.Fatal("reset after cache reset is wrong:", jsonutil.MarshalJson(rtrData))
+	}
+}
+
+func TestRtrClientApplyResponse(t *testing.T) {
+	rc := NewRtrClient(RtrClientConfig{ProtocolVersion: RTR_PROTOCOL_VERSION_1})
+	rc.response = &rtrClientResponse{isReset: true, sessionId: 1, rtrPdus: []RtrPdu{
+		&Ipv4PrefixPdu{ProtocolVersion: 1, Flags: RTR_FLAG_ANNOUNCE, PrefixLength: 24, MaxLength: 24, Prefix: "192.0.2.0", Asn: 1}}}
+	if err := rc.applyResponse(&EndOfDataPdu{ProtocolVersion: 1, SessionId: 1, SerialNumber: 5}); err != nil {
+		t.Fatal(err)
+	}
+
+	errorPdus := map[uint16]RtrPdu{
+		RTR_ERROR_CODE_DUPLICATE_ANNOUNCEMENT: &Ipv4PrefixPdu{ProtocolVersion: 1, Flags: RTR_FLAG_ANNOUNCE,
+			PrefixLength: 24, MaxLength: 24, Prefix: "192.0.2.0", Asn: 1},
+		RTR_ERROR_CODE_WITHDRAWAL_OF_UNKNOWN_RECORD: &Ipv4PrefixPdu{ProtocolVersion: 1, Flags: RTR_FLAG_WITHDRAW,
+			PrefixLength: 24, MaxLength: 24, Prefix: "192.0.2.0", Asn: 2},
+	}
+	for errorCode, rtrPdu := range errorPdus {
+		rc.response = &rtrClientResponse{sessionId: 1, rtrPdus: []RtrPdu{rtrPdu}}
+		err := rc.applyResponse(&EndOfDataPdu{ProtocolVersion: 1, SessionId: 1, SerialNumber: 6})
+		var rtrError *RtrError
+		if !errors.As(err, &rtrError) || rtrError.ErrorCode != errorCode {
+			t.Fatal("should fail with error code", errorCode, err)
+		}
+	}
+	if state := rc.GetState(); state.SerialNumber != 5 || state.VrpCount != 1 {
+		t.Fatal("failed response should not be applied:", jsonutil.MarshalJson(state))
+	}
+}
+
+// cache supports only version 1, and does not close connection after Error Report
+func TestRtrClientDowngrade(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	firstClosed := make(chan struct{})
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		buffer := make([]byte, 64)
+		conn.Read(buffer)
+		conn.Write((&ErrorReportPdu{ProtocolVersion: RTR_PROTOCOL_VERSION_1,
+			ErrorCode: RTR_ERROR_CODE_UNSUPPORTED_PROTOCOL_VERSION}).Bytes())
+		go func() {
+			// Read returns when client closes the first connection
+			conn.Read(buffer)
+			close(firstClosed)
+		}()
+
+		conn2, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn2.Close()
+		conn2.Read(buffer)
+		conn2.Write(append((&CacheResponsePdu{ProtocolVersion: RTR_PROTOCOL_VERSION_1, SessionId: 7}).Bytes(),
+			(&EndOfDataPdu{ProtocolVersion: RTR_PROTOCOL_VERSION_1, SessionId: 7, SerialNumber: 1,
+				RefreshInterval: 60, RetryInterval: 60, ExpireInterval: 600}).Bytes()...))
+		conn2.Read(buffer)
+	}()
+
+	rc := NewRtrClient(RtrClientConfig{ProtocolVersion: RTR_PROTOCOL_VERSION_2})
+	if err = rc.StartTcpClient(listener.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if err = rc.ResetQuery(); err != nil {
+		t.Fatal(err)
+	}
+	state := rc.GetState()
+	if state.ProtocolVersion != RTR_PROTOCOL_VERSION_1 || state.SessionId != 7 || state.SerialNumber != 1 {
+		t.Fatal("downgrade is wrong:", jsonutil.MarshalJson(state))
+	}
+	select {
+	case <-firstClosed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("first connection should be closed by client")
+	}
+}
@@ -0,0 +1,260 @@
+package rtrutil
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cpusoft/goutil/rpkiutil"
+)
+
+// RtrVrp: Prefix is such as 192.0.2.0/24
+type RtrVrp struct {
+	Prefix    string `json:"prefix"`
+	MaxLength uint8  `json:"maxLength"`
+	Asn       uint32 `json:"asn"`
+}
+
+// RtrRouterKey is BGPsec router key, from version 1
+type RtrRouterKey struct {
+	SubjectKeyIdentifier []byte `json:"subjectKeyIdentifier"`
+	Asn                  uint32 `json:"asn"`
+	SubjectPublicKeyInfo []byte `json:"subjectPublicKeyInfo"`
+}
+
+// RtrAspa is from version 2
+type RtrAspa struct {
+	CustomerAsn  uint32   `json:"customerAsn"`
+	ProviderAsns []uint32 `json:"providerAsns"`
+}
+
+// RtrData is all data of cache
+type RtrData struct {
+	Vrps       []RtrVrp       `json:"vrps"`
+	RouterKeys []RtrRouterKey `json:"routerKeys"`
+	Aspas      []RtrAspa      `json:"aspas"`
+}
+
+// NewRtrDataFromValidationResults gets vrps and aspas from results of rpkiutil
+func NewRtrDataFromValidationResults(validationResults ...*rpkiutil.ValidationResult) *RtrData {
+	rtrData := &RtrData{
+		Vrps:       make([]RtrVrp, 0),
+		RouterKeys: make([]RtrRouterKey, 0),
+		Aspas:      make([]RtrAspa, 0),
+	}
+	for _, validationResult := range validationResults {
+		for _, vrp := range validationResult.Vrps {
+			rtrData.Vrps = append(rtrData.Vrps, RtrVrp{
+				Prefix:    vrp.Prefix,
+				MaxLength: uint8(vrp.MaxLength),
+				Asn:       uint32(vrp.Asn),
+			})
+		}
+		for _, vap := range validationResult.Vaps {
+			rtrAspa := RtrAspa{
+				CustomerAsn:  uint32(vap.CustomerAsn),
+				ProviderAsns: make([]uint32, 0, len(vap.ProviderAsns)),
+			}
+			for _, providerAsn := range vap.ProviderAsns {
+				rtrAspa.ProviderAsns = append(rtrAspa.ProviderAsns, uint32(providerAsn))
+			}
+			rtrData.Aspas = append(rtrData.Aspas, rtrAspa)
+		}
+	}
+	return rtrData
+}
+
+// one vrp/router key/aspa of cache
+type rtrRecord struct {
+	key string
+	// compared when key is same, only aspa has value
+	value string
+	// lowest protocol version which has this record
+	protocolVersion uint8
+
+	vrp       *RtrVrp
+	prefix    netip.Prefix
+	routerKey *RtrRouterKey
+	aspa      *RtrAspa
+}
+
+// before is nil when announce new, after is nil when withdraw
+type rtrChange struct {
+	key    string
+	before *rtrRecord
+	after  *rtrRecord
+}
+
+// changes from serialNumber-1 to serialNumber
+type rtrDelta struct {
+	serialNumber uint32
+	changes      []rtrChange
+}
+
+func (r *rtrRecord) toPdu(protocolVersion, flags uint8) RtrPdu {
+	switch {
+	case r.vrp != nil && r.prefix.Addr().Is4():
+		return &Ipv4PrefixPdu{ProtocolVersion: protocolVersion, Flags: flags, PrefixLength: uint8(r.prefix.Bits()),
+			MaxLength: r.vrp.MaxLength, Prefix: r.prefix.Addr().String(), Asn: r.vrp.Asn}
+	case r.vrp != nil:
+		return &Ipv6PrefixPdu{ProtocolVersion: protocolVersion, Flags: flags, PrefixLength: uint8(r.prefix.Bits()),
+			MaxLength: r.vrp.MaxLength, Prefix: r.prefix.Addr().String(), Asn: r.vrp.Asn}
+	case r.routerKey != nil:
+		return &RouterKeyPdu{ProtocolVersion: protocolVersion, Flags: flags, SubjectKeyIdentifier: r.routerKey.SubjectKeyIdentifier,
+			Asn: r.routerKey.Asn, SubjectPublicKeyInfo: r.routerKey.SubjectPublicKeyInfo}
+	default:
+		aspaPdu := &AspaPdu{ProtocolVersion: protocolVersion, Flags: flags, CustomerAsn: r.aspa.CustomerAsn,
+			ProviderAsns: make([]uint32, 0)}
+		if flags == RTR_FLAG_ANNOUNCE {
+			aspaPdu.ProviderAsns = r.aspa.ProviderAsns
+		}
+		return aspaPdu
+	}
+}
+
+// getRtrRecords checks rtrData and converts to records, duplicated vrps and router keys are merged,
+// provider asns of same customer asn are merged
+func getRtrRecords(rtrData *RtrData) (map[string]*rtrRecord, error) {
+	records := make(map[string]*rtrRecord)
+	if rtrData == nil {
+		return records, nil
+	}
+	for i := range rtrData.Vrps {
+		vrp := rtrData.Vrps[i]
+		prefix, err := netip.ParsePrefix(vrp.Prefix)
+		if err != nil {
+			return nil, errors.New("prefix of vrp is invalid: " + vrp.Prefix)
+		}
+		prefix = prefix.Masked()
+		if int(vrp.MaxLength) < prefix.Bits() || int(vrp.MaxLength) > prefix.Addr().BitLen() {
+			return nil, errors.New("maxLength of vrp is invalid: " + vrp.Prefix + " " + strconv.Itoa(int(vrp.MaxLength)))
+		}
+		vrp.Prefix = prefix.String()
+		key := "vrp|" + vrp.Prefix + "|" + strconv.Itoa(int(vrp.MaxLength)) + "|" + strconv.FormatUint(uint64(vrp.Asn), 10)
+		records[key] = &rtrRecord{key: key, protocolVersion: RTR_PROTOCOL_VERSION_0, vrp: &vrp, prefix: prefix}
+	}
+	for i := range rtrData.RouterKeys {
+		routerKey := rtrData.RouterKeys[i]
+		if len(routerKey.SubjectKeyIdentifier) != RTR_PDU_SKI_LENGTH || len(routerKey.SubjectPublicKeyInfo) == 0 {
+			return nil, errors.New("subjectKeyIdentifier or subjectPublicKeyInfo of router key is invalid, asn: " +
+				strconv.FormatUint(uint64(routerKey.Asn), 10))
+		}
+		key := "routerKey|" + hex.EncodeToString(routerKey.SubjectKeyIdentifier) + "|" +
+			strconv.FormatUint(uint64(routerKey.Asn), 10) + "|" + hex.EncodeToString(routerKey.SubjectPublicKeyInfo)
+		records[key] = &rtrRecord{key: key, protocolVersion: RTR_PROTOCOL_VERSION_1, routerKey: &routerKey}
+	}
+	providerAsns := make(map[uint32]map[uint32]struct{})
+	for _, aspa := range rtrData.Aspas {
+		if len(aspa.ProviderAsns) == 0 {
+			return nil, errors.New("providerAsns of aspa is empty, customerAsn: " + strconv.FormatUint(uint64(aspa.CustomerAsn), 10))
+		}
+		if _, ok := providerAsns[aspa.CustomerAsn]; !ok {
+			providerAsns[aspa.CustomerAsn] = make(map[uint32]struct{})
+		}
+		for _, providerAsn := range aspa.ProviderAsns {
+			providerAsns[aspa.CustomerAsn][providerAsn] = struct{}{}
+		}
+	}
+	for customerAsn, providers := range providerAsns {
+		aspa := &RtrAspa{CustomerAsn: customerAsn, ProviderAsns: make([]uint32, 0, len(providers))}
+		for providerAsn := range providers {
+			aspa.ProviderAsns = append(aspa.ProviderAsns, providerAsn)
+		}
+		sort.Slice(aspa.ProviderAsns, func(i, j int) bool { return aspa.ProviderAsns[i] < aspa.ProviderAsns[j] })
+		values := make([]string, 0, len(aspa.ProviderAsns))
+		for _, providerAsn := range aspa.ProviderAsns {
+			values = append(values, strconv.FormatUint(uint64(providerAsn), 10))
+		}
+		key := "aspa|" + strconv.FormatUint(uint64(customerAsn), 10)
+		records[key] = &rtrRecord{key: key, value: strings.Join(values, ","),
+			protocolVersion: RTR_PROTOCOL_VERSION_2, aspa: aspa}
+	}
+	return records, nil
+}
+
+// diffRtrRecords gets changes from oldRecords to newRecords, sorted by sortRtrChanges
+func diffRtrRecords(oldRecords, newRecords map[string]*rtrRecord) []rtrChange {
+	changes := make([]rtrChange, 0)
+	for key, oldRecord := range oldRecords {
+		newRecord, ok := newRecords[key]
+		if !ok {
+			changes = append(changes, rtrChange{key: key, before: oldRecord})
+		} else if newRecord.value != oldRecord.value {
+			changes = append(changes, rtrChange{key: key, before: oldRecord, after: newRecord})
+		}
+	}
+	for key, newRecord := range newRecords {
+		if _, ok := oldRecords[key]; !ok {
+			changes = append(changes, rtrChange{key: key, after: newRecord})
+		}
+	}
+	sortRtrChanges(changes)
+	return changes
+}
+
+// composeRtrDeltas merges deltas in order into the changes from first to last
+func composeRtrDeltas(deltas []*rtrDelta) []rtrChange {
+	composed := make(map[string]*rtrChange)
+	for _, delta := range deltas {
+		for i := range delta.changes {
+			change := delta.changes[i]
+			if c, ok := composed[change.key]; ok {
+				c.after = change.after
+			} else {
+				composed[change.key] = &change
+			}
+		}
+	}
+	changes := make([]rtrChange, 0, len(composed))
+	for _, change := range composed {
+		if change.before == nil && change.after == nil {
+			continue
+		}
+		if change.before != nil && change.after != nil && change.before.value == change.after.value {
+			continue
+		}
+		changes = append(changes, *change)
+	}
+	sortRtrChanges(changes)
+	return changes
+}
+
+// withdrawals are before announcements, then prefixes, router keys, aspas, and then by key
+func sortRtrChanges(changes []rtrChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		iWithdraw := changes[i].after == nil
+		jWithdraw := changes[j].after == nil
+		if iWithdraw != jWithdraw {
+			return iWithdraw
+		}
+		if changes[i].record().protocolVersion != changes[j].record().protocolVersion {
+			return changes[i].record().protocolVersion < changes[j].record().protocolVersion
+		}
+		return changes[i].key < changes[j].key
+	})
+}
+
+func (c *rtrChange) record() *rtrRecord {
+	if c.after != nil {
+		return c.after
+	}
+	return c.before
+}
+
+// getChangesPdusBytes converts changes to pdus of protocolVersion, records which are not in protocolVersion are ignored
+func getChangesPdusBytes(changes []rtrChange, protocolVersion uint8) []byte {
+	b := make([]byte, 0)
+	for _, change := range changes {
+		if change.after == nil {
+			if change.before.protocolVersion <= protocolVersion {
+				b = append(b, change.before.toPdu(protocolVersion, RTR_FLAG_WITHDRAW).Bytes()...)
+			}
+		} else if change.after.protocolVersion <= protocolVersion {
+			b = append(b, change.after.toPdu(protocolVersion, RTR_FLAG_ANNOUNCE).Bytes()...)
+		}
+	}
+	return b
+}
@@ -0,0 +1,437 @@
+package rtrutil
+
+import (
+	"encoding/binary"
+	"net"
+	"strconv"
+
+	"github.com/cpusoft/goutil/iputil"
+)
+
+// https://datatracker.ietf.org/doc/html/rfc6810 (version 0)
+// https://datatracker.ietf.org/doc/html/rfc8210 (version 1)
+// https://datatracker.ietf.org/doc/html/draft-ietf-sidrops-8210bis (version 2)
+const (
+	RTR_PROTOCOL_VERSION_0   = 0
+	RTR_PROTOCOL_VERSION_1   = 1
+	RTR_PROTOCOL_VERSION_2   = 2
+	RTR_PROTOCOL_VERSION_MAX = RTR_PROTOCOL_VERSION_2
+
+	RTR_PDU_TYPE_SERIAL_NOTIFY  = 0
+	RTR_PDU_TYPE_SERIAL_QUERY   = 1
+	RTR_PDU_TYPE_RESET_QUERY    = 2
+	RTR_PDU_TYPE_CACHE_RESPONSE = 3
+	RTR_PDU_TYPE_IPV4_PREFIX    = 4
+	RTR_PDU_TYPE_IPV6_PREFIX    = 6
+	RTR_PDU_TYPE_END_OF_DATA    = 7
+	RTR_PDU_TYPE_CACHE_RESET    = 8
+	RTR_PDU_TYPE_ROUTER_KEY     = 9
+	RTR_PDU_TYPE_ERROR_REPORT   = 10
+	RTR_PDU_TYPE_ASPA           = 11
+
+	RTR_PDU_HEADER_LENGTH         = 8
+	RTR_PDU_LENGTH_START          = 4
+	RTR_PDU_LENGTH_END            = 8
+	RTR_PDU_SERIAL_NOTIFY_LENGTH  = 12
+	RTR_PDU_SERIAL_QUERY_LENGTH   = 12
+	RTR_PDU_RESET_QUERY_LENGTH    = 8
+	RTR_PDU_CACHE_RESPONSE_LENGTH = 8
+	RTR_PDU_IPV4_PREFIX_LENGTH    = 20
+	RTR_PDU_IPV6_PREFIX_LENGTH    = 32
+	RTR_PDU_END_OF_DATA_V0_LENGTH = 12
+	RTR_PDU_END_OF_DATA_LENGTH    = 24
+	RTR_PDU_CACHE_RESET_LENGTH    = 8
+	RTR_PDU_SKI_LENGTH            = 20
+	// larger pdu is treated as corrupt data
+	RTR_PDU_MAX_LENGTH = 65535
+
+	// flags of prefix/router key/aspa
+	RTR_FLAG_WITHDRAW = 0
+	RTR_FLAG_ANNOUNCE = 1
+
+	RTR_ERROR_CODE_CORRUPT_DATA                 = 0
+	RTR_ERROR_CODE_INTERNAL_ERROR               = 1
+	RTR_ERROR_CODE_NO_DATA_AVAILABLE            = 2
+	RTR_ERROR_CODE_INVALID_REQUEST              = 3
+	RTR_ERROR_CODE_UNSUPPORTED_PROTOCOL_VERSION = 4
+	RTR_ERROR_CODE_UNSUPPORTED_PDU_TYPE         = 5
+	RTR_ERROR_CODE_WITHDRAWAL_OF_UNKNOWN_RECORD = 6
+	RTR_ERROR_CODE_DUPLICATE_ANNOUNCEMENT       = 7
+	RTR_ERROR_CODE_UNEXPECTED_PROTOCOL_VERSION  = 8
+	RTR_ERROR_CODE_ASPA_PROVIDER_LIST_ERROR     = 9
+)
+
+// RtrError is error with the error code of Error Report PDU
+type RtrError struct {
+	ErrorCode uint16 `json:"errorCode"`
+	Message   string `json:"message"`
+}
+
+func (e *RtrError) Error() string {
+	return "rtr error code " + strconv.Itoa(int(e.ErrorCode)) + ": " + e.Message
+}
+
+func newRtrError(errorCode uint16, message string) *RtrError {
+	return &RtrError{ErrorCode: errorCode, Message: message}
+}
+
+// RtrPdu is one of *SerialNotifyPdu, *SerialQueryPdu, *ResetQueryPdu, *CacheResponsePdu,
+// *Ipv4PrefixPdu, *Ipv6PrefixPdu, *EndOfDataPdu, *CacheResetPdu, *RouterKeyPdu, *AspaPdu, *ErrorReportPdu
+type RtrPdu interface {
+	GetProtocolVersion() uint8
+	GetPduType() uint8
+	Bytes() []byte
+}
+
+type SerialNotifyPdu struct {
+	ProtocolVersion uint8  `json:"protocolVersion"`
+	SessionId       uint16 `json:"sessionId"`
+	SerialNumber    uint32 `json:"serialNumber"`
+}
+
+type SerialQueryPdu struct {
+	ProtocolVersion uint8  `json:"protocolVersion"`
+	SessionId       uint16 `json:"sessionId"`
+	SerialNumber    uint32 `json:"serialNumber"`
+}
+
+type ResetQueryPdu struct {
+	ProtocolVersion uint8 `json:"protocolVersion"`
+}
+
+type CacheResponsePdu struct {
+	ProtocolVersion uint8  `json:"protocolVersion"`
+	SessionId       uint16 `json:"sessionId"`
+}
+
+// Ipv4PrefixPdu: Prefix is address, such as 192.0.2.0
+type Ipv4PrefixPdu struct {
+	ProtocolVersion uint8  `json:"protocolVersion"`
+	Flags           uint8  `json:"flags"`
+	PrefixLength    uint8  `json:"prefixLength"`
+	MaxLength       uint8  `json:"maxLength"`
+	Prefix          string `json:"prefix"`
+	Asn             uint32 `json:"asn"`
+}
+
+// Ipv6PrefixPdu: Prefix is address, such as 2001:db8::
+type Ipv6PrefixPdu struct {
+	ProtocolVersion uint8  `json:"protocolVersion"`
+	Flags           uint8  `json:"flags"`
+	PrefixLength    uint8  `json:"prefixLength"`
+	MaxLength       uint8  `json:"maxLength"`
+	Prefix          string `json:"prefix"`
+	Asn             uint32 `json:"asn"`
+}
+
+// EndOfDataPdu: intervals are not in version 0
+type EndOfDataPdu struct {
+	ProtocolVersion uint8  `json:"protocolVersion"`
+	SessionId       uint16 `json:"sessionId"`
+	SerialNumber    uint32 `json:"serialNumber"`
+	RefreshInterval uint32 `json:"refreshInterval"`
+	RetryInterval   uint32 `json:"retryInterval"`
+	ExpireInterval  uint32 `json:"expireInterval"`
+}
+
+type CacheResetPdu struct {
+	ProtocolVersion uint8 `json:"protocolVersion"`
+}
+
+// RouterKeyPdu is from version 1
+type RouterKeyPdu struct {
+	ProtocolVersion      uint8  `json:"protocolVersion"`
+	Flags                uint8  `json:"flags"`
+	SubjectKeyIdentifier []byte `json:"subjectKeyIdentifier"`
+	Asn                  uint32 `json:"asn"`
+	SubjectPublicKeyInfo []byte `json:"subjectPublicKeyInfo"`
+}
+
+// AspaPdu is from version 2, ProviderAsns is empty when withdraw
+type AspaPdu struct {
+	ProtocolVersion uint8    `json:"protocolVersion"`
+	Flags           uint8    `json:"flags"`
+	CustomerAsn     uint32   `json:"customerAsn"`
+	ProviderAsns    []uint32 `json:"providerAsns"`
+}
+
+// ErrorReportPdu: ErroneousPdu is the pdu which caused the error, may be empty
+type ErrorReportPdu struct {
+	ProtocolVersion uint8  `json:"protocolVersion"`
+	ErrorCode       uint16 `json:"errorCode"`
+	ErroneousPdu    []byte `json:"erroneousPdu"`
+	ErrorText       string `json:"errorText"`
+}
+
+func (p *SerialNotifyPdu) GetProtocolVersion() uint8  { return p.ProtocolVersion }
+func (p *SerialQueryPdu) GetProtocolVersion() uint8   { return p.ProtocolVersion }
+func (p *ResetQueryPdu) GetProtocolVersion() uint8    { return p.ProtocolVersion }
+func (p *CacheResponsePdu) GetProtocolVersion() uint8 { return p.ProtocolVersion }
+func (p *Ipv4PrefixPdu) GetProtocolVersion() uint8    { return p.ProtocolVersion }
+func (p *Ipv6PrefixPdu) GetProtocolVersion() uint8    { return p.ProtocolVersion }
+func (p *EndOfDataPdu) GetProtocolVersion() uint8     { return p.ProtocolVersion }
+func (p *CacheResetPdu) GetProtocolVersion() uint8    { return p.ProtocolVersion }
+func (p *RouterKeyPdu) GetProtocolVersion() uint8     { return p.ProtocolVersion }
+func (p *AspaPdu) GetProtocolVersion() uint8          { return p.ProtocolVersion }
+func (p *ErrorReportPdu) GetProtocolVersion() uint8   { return p.ProtocolVersion }
+
+func (p *SerialNotifyPdu) GetPduType() uint8  { return RTR_PDU_TYPE_SERIAL_NOTIFY }
+func (p *SerialQueryPdu) GetPduType() uint8   { return RTR_PDU_TYPE_SERIAL_QUERY }
+func (p *ResetQueryPdu) GetPduType() uint8    { return RTR_PDU_TYPE_RESET_QUERY }
+func (p *CacheResponsePdu) GetPduType() uint8 { return RTR_PDU_TYPE_CACHE_RESPONSE }
+func (p *Ipv4PrefixPdu) GetPduType() uint8    { return RTR_PDU_TYPE_IPV4_PREFIX }
+func (p *Ipv6PrefixPdu) GetPduType() uint8    { return RTR_PDU_TYPE_IPV6_PREFIX }
+func (p *EndOfDataPdu) GetPduType() uint8     { return RTR_PDU_TYPE_END_OF_DATA }
+func (p *CacheResetPdu) GetPduType() uint8    { return RTR_PDU_TYPE_CACHE_RESET }
+func (p *RouterKeyPdu) GetPduType() uint8     { return RTR_PDU_TYPE_ROUTER_KEY }
+func (p *AspaPdu) GetPduType() uint8          { return RTR_PDU_TYPE_ASPA }
+func (p *ErrorReportPdu) GetPduType() uint8   { return RTR_PDU_TYPE_ERROR_REPORT }
+
+func (p *SerialNotifyPdu) Bytes() []byte {
+	b := newPduBytes(p.ProtocolVersion, RTR_PDU_TYPE_SERIAL_NOTIFY, p.SessionId, RTR_PDU_SERIAL_NOTIFY_LENGTH)
+	binary.BigEndian.PutUint32(b[8:], p.SerialNumber)
+	return b
+}
+
+func (p *SerialQueryPdu) Bytes() []byte {
+	b := newPduBytes(p.ProtocolVersion, RTR_PDU_TYPE_SERIAL_QUERY, p.SessionId, RTR_PDU_SERIAL_QUERY_LENGTH)
+	binary.BigEndian.PutUint32(b[8:], p.SerialNumber)
+	return b
+}
+
+func (p *ResetQueryPdu) Bytes() []byte {
+	return newPduBytes(p.ProtocolVersion, RTR_PDU_TYPE_RESET_QUERY, 0, RTR_PDU_RESET_QUERY_LENGTH)
+}
+
+func (p *CacheResponsePdu) Bytes() []byte {
+	return newPduBytes(p.ProtocolVersion, RTR_PDU_TYPE_CACHE_RESPONSE, p.SessionId, RTR_PDU_CACHE_RESPONSE_LENGTH)
+}
+
+func (p *Ipv4PrefixPdu) Bytes() []byte {
+	b := newPduBytes(p.ProtocolVersion, RTR_PDU_TYPE_IPV4_PREFIX, 0, RTR_PDU_IPV4_PREFIX_LENGTH)
+	putPrefix(b, p.Flags, p.PrefixLength, p.MaxLength, p.Prefix, p.Asn, 4)
+	return b
+}
+
+func (p *Ipv6PrefixPdu) Bytes() []byte {
+	b := newPduBytes(p.ProtocolVersion, RTR_PDU_TYPE_IPV6_PREFIX, 0, RTR_PDU_IPV6_PREFIX_LENGTH)
+	putPrefix(b, p.Flags, p.PrefixLength, p.MaxLength, p.Prefix, p.Asn, 16)
+	return b
+}
+
+func (p *EndOfDataPdu) Bytes() []byte {
+	if p.ProtocolVersion == RTR_PROTOCOL_VERSION_0 {
+		b := newPduBytes(p.ProtocolVersion, RTR_PDU_TYPE_END_OF_DATA, p.SessionId, RTR_PDU_END_OF_DATA_V0_LENGTH)
+		binary.BigEndian.PutUint32(b[8:], p.SerialNumber)
+		return b
+	}
+	b := newPduBytes(p.ProtocolVersion, RTR_PDU_TYPE_END_OF_DATA, p.SessionId, RTR_PDU_END_OF_DATA_LENGTH)
+	binary.BigEndian.PutUint32(b[8:], p.SerialNumber)
+	binary.BigEndian.PutUint32(b[12:], p.RefreshInterval)
+	binary.BigEndian.PutUint32(b[16:], p.RetryInterval)
+	binary.BigEndian.PutUint32(b[20:], p.ExpireInterval)
+	return b
+}
+
+func (p *CacheResetPdu) Bytes() []byte {
+	return newPduBytes(p.ProtocolVersion, RTR_PDU_TYPE_CACHE_RESET, 0, RTR_PDU_CACHE_RESET_LENGTH)
+}
+
+func (p *RouterKeyPdu) Bytes() []byte {
+	b := newPduBytes(p.ProtocolVersion, RTR_PDU_TYPE_ROUTER_KEY, uint16(p.Flags)<<8,
+		RTR_PDU_HEADER_LENGTH+RTR_PDU_SKI_LENGTH+4+len(p.SubjectPublicKeyInfo))
+	copy(b[8:8+RTR_PDU_SKI_LENGTH], p.SubjectKeyIdentifier)
+	binary.BigEndian.PutUint32(b[28:], p.Asn)
+	copy(b[32:], p.SubjectPublicKeyInfo)
+	return b
+}
+
+func (p *AspaPdu) Bytes() []byte {
+	b := newPduBytes(p.ProtocolVersion, RTR_PDU_TYPE_ASPA, uint16(p.Flags)<<8, RTR_PDU_HEADER_LENGTH+4+4*len(p.ProviderAsns))
+	binary.BigEndian.PutUint32(b[8:], p.CustomerAsn)
+	for i, providerAsn := range p.ProviderAsns {
+		binary.BigEndian.PutUint32(b[12+4*i:], providerAsn)
+	}
+	return b
+}
+
+func (p *ErrorReportPdu) Bytes() []byte {
+	b := newPduBytes(p.ProtocolVersion, RTR_PDU_TYPE_ERROR_REPORT, p.ErrorCode,
+		RTR_PDU_HEADER_LENGTH+4+len(p.ErroneousPdu)+4+len(p.ErrorText))
+	binary.BigEndian.PutUint32(b[8:], uint32(len(p.ErroneousPdu)))
+	copy(b[12:], p.ErroneousPdu)
+	binary.BigEndian.PutUint32(b[12+len(p.ErroneousPdu):], uint32(len(p.ErrorText)))
+	copy(b[16+len(p.ErroneousPdu):], p.ErrorText)
+	return b
+}
+
+// header: protocol version(1), pdu type(1), session id/error code/flags(2), length(4)
+func newPduBytes(protocolVersion, pduType uint8, headerField uint16, length int) []byte {
+	b := make([]byte, length)
+	b[0] = protocolVersion
+	b[1] = pduType
+	binary.BigEndian.PutUint16(b[2:], headerField)
+	binary.BigEndian.PutUint32(b[4:], uint32(length))
+	return b
+}
+
+func putPrefix(b []byte, flags, prefixLength, maxLength uint8, prefix string, asn uint32, ipLength int) {
+	b[8] = flags
+	b[9] = prefixLength
+	b[10] = maxLength
+	ip := iputil.IpToRtrFormatByte(prefix)
+	if len(ip) == 16 && ipLength == 4 {
+		ip = net.IP(ip).To4()
+	}
+	copy(b[12:12+ipLength], ip)
+	binary.BigEndian.PutUint32(b[12+ipLength:], asn)
+}
+
+// ParseRtrPdu parses one whole pdu, error is *RtrError
+func ParseRtrPdu(b []byte) (RtrPdu, error) {
+	if len(b) < RTR_PDU_HEADER_LENGTH {
+		return nil, newRtrError(RTR_ERROR_CODE_CORRUPT_DATA, "pdu is shorter than header")
+	}
+	protocolVersion := b[0]
+	pduType := b[1]
+	headerField := binary.BigEndian.Uint16(b[2:4])
+	length := int(binary.BigEndian.Uint32(b[4:8]))
+	if length != len(b) {
+		return nil, newRtrError(RTR_ERROR_CODE_CORRUPT_DATA, "length of pdu is "+strconv.Itoa(length)+
+			", but has "+strconv.Itoa(len(b))+" bytes")
+	}
+	if protocolVersion > RTR_PROTOCOL_VERSION_MAX {
+		return nil, newRtrError(RTR_ERROR_CODE_UNSUPPORTED_PROTOCOL_VERSION, "protocol version "+
+			strconv.Itoa(int(protocolVersion))+" is not supported")
+	}
+
+	checkLength := func(expected int) error {
+		if length != expected {
+			return newRtrError(RTR_ERROR_CODE_CORRUPT_DATA, "length of pdu type "+strconv.Itoa(int(pduType))+
+				" should be "+strconv.Itoa(expected)+", but is "+strconv.Itoa(length))
+		}
+		return nil
+	}
+	switch pduType {
+	case RTR_PDU_TYPE_SERIAL_NOTIFY:
+		if err := checkLength(RTR_PDU_SERIAL_NOTIFY_LENGTH); err != nil {
+			return nil, err
+		}
+		return &SerialNotifyPdu{ProtocolVersion: protocolVersion, SessionId: headerField,
+			SerialNumber: binary.BigEndian.Uint32(b[8:])}, nil
+	case RTR_PDU_TYPE_SERIAL_QUERY:
+		if err := checkLength(RTR_PDU_SERIAL_QUERY_LENGTH); err != nil {
+			return nil, err
+		}
+		return &SerialQueryPdu{ProtocolVersion: protocolVersion, SessionId: headerField,
+			SerialNumber: binary.BigEndian.Uint32(b[8:])}, nil
+	case RTR_PDU_TYPE_RESET_QUERY:
+		if err := checkLength(RTR_PDU_RESET_QUERY_LENGTH); err != nil {
+			return nil, err
+		}
+		return &ResetQueryPdu{ProtocolVersion: protocolVersion}, nil
+	case RTR_PDU_TYPE_CACHE_RESPONSE:
+		if err := checkLength(RTR_PDU_CACHE_RESPONSE_LENGTH); err != nil {
+			return nil, err
+		}
+		return &CacheResponsePdu{ProtocolVersion: protocolVersion, SessionId: headerField}, nil
+	case RTR_PDU_TYPE_IPV4_PREFIX:
+		if err := checkLength(RTR_PDU_IPV4_PREFIX_LENGTH); err != nil {
+			return nil, err
+		}
+		flags, prefixLength, maxLength, prefix, asn, err := parsePrefix(b, 4)
+		if err != nil {
+			return nil, err
+		}
+		return &Ipv4PrefixPdu{ProtocolVersion: protocolVersion, Flags: flags, PrefixLength: prefixLength,
+			MaxLength: maxLength, Prefix: prefix, Asn: asn}, nil
+	case RTR_PDU_TYPE_IPV6_PREFIX:
+		if err := checkLength(RTR_PDU_IPV6_PREFIX_LENGTH); err != nil {
+			return nil, err
+		}
+		flags, prefixLength, maxLength, prefix, asn, err := parsePrefix(b, 16)
+		if err != nil {
+			return nil, err
+		}
+		return &Ipv6PrefixPdu{ProtocolVersion: protocolVersion, Flags: flags, PrefixLength: prefixLength,
+			MaxLength: maxLength, Prefix: prefix, Asn: asn}, nil
+	case RTR_PDU_TYPE_END_OF_DATA:
+		if protocolVersion == RTR_PROTOCOL_VERSION_0 {
+			if err := checkLength(RTR_PDU_END_OF_DATA_V0_LENGTH); err != nil {
+				return nil, err
+			}
+			return &EndOfDataPdu{ProtocolVersion: protocolVersion, SessionId: headerField,
+				SerialNumber: binary.BigEndian.Uint32(b[8:])}, nil
+		}
+		if err := checkLength(RTR_PDU_END_OF_DATA_LENGTH); err != nil {
+			return nil, err
+		}
+		return &EndOfDataPdu{ProtocolVersion: protocolVersion, SessionId: headerField,
+			SerialNumber:    binary.BigEndian.Uint32(b[8:]),
+			RefreshInterval: binary.BigEndian.Uint32(b[12:]),
+			RetryInterval:   binary.BigEndian.Uint32(b[16:]),
+			ExpireInterval:  binary.BigEndian.Uint32(b[20:])}, nil
+	case RTR_PDU_TYPE_CACHE_RESET:
+		if err := checkLength(RTR_PDU_CACHE_RESET_LENGTH); err != nil {
+			return nil, err
+		}
+		return &CacheResetPdu{ProtocolVersion: protocolVersion}, nil
+	case RTR_PDU_TYPE_ROUTER_KEY:
+		if protocolVersion < RTR_PROTOCOL_VERSION_1 {
+			return nil, newRtrError(RTR_ERROR_CODE_UNSUPPORTED_PDU_TYPE, "router key pdu is not in protocol version 0")
+		}
+		if length <= RTR_PDU_HEADER_LENGTH+RTR_PDU_SKI_LENGTH+4 {
+			return nil, newRtrError(RTR_ERROR_CODE_CORRUPT_DATA, "router key pdu is too short")
+		}
+		return &RouterKeyPdu{ProtocolVersion: protocolVersion, Flags: b[2],
+			SubjectKeyIdentifier: append([]byte{}, b[8:28]...),
+			Asn:                  binary.BigEndian.Uint32(b[28:]),
+			SubjectPublicKeyInfo: append([]byte{}, b[32:]...)}, nil
+	case RTR_PDU_TYPE_ASPA:
+		if protocolVersion < RTR_PROTOCOL_VERSION_2 {
+			return nil, newRtrError(RTR_ERROR_CODE_UNSUPPORTED_PDU_TYPE, "aspa pdu is only in protocol version 2")
+		}
+		if length < RTR_PDU_HEADER_LENGTH+4 || (length-RTR_PDU_HEADER_LENGTH)%4 != 0 {
+			return nil, newRtrError(RTR_ERROR_CODE_CORRUPT_DATA, "length of aspa pdu is invalid: "+strconv.Itoa(length))
+		}
+		aspaPdu := &AspaPdu{ProtocolVersion: protocolVersion, Flags: b[2],
+			CustomerAsn:  binary.BigEndian.Uint32(b[8:]),
+			ProviderAsns: make([]uint32, 0, (length-12)/4)}
+		for i := 12; i < length; i += 4 {
+			aspaPdu.ProviderAsns = append(aspaPdu.ProviderAsns, binary.BigEndian.Uint32(b[i:]))
+		}
+		if aspaPdu.Flags&RTR_FLAG_ANNOUNCE == 0 && len(aspaPdu.ProviderAsns) > 0 {
+			return nil, newRtrError(RTR_ERROR_CODE_ASPA_PROVIDER_LIST_ERROR, "aspa withdrawal should have no provider")
+		}
+		return aspaPdu, nil
+	case RTR_PDU_TYPE_ERROR_REPORT:
+		if length < RTR_PDU_HEADER_LENGTH+8 {
+			return nil, newRtrError(RTR_ERROR_CODE_CORRUPT_DATA, "error report pdu is too short")
+		}
+		pduLength := int(binary.BigEndian.Uint32(b[8:]))
+		if pduLength > length-RTR_PDU_HEADER_LENGTH-8 {
+			return nil, newRtrError(RTR_ERROR_CODE_CORRUPT_DATA, "length of erroneous pdu is invalid")
+		}
+		textLength := int(binary.BigEndian.Uint32(b[12+pduLength:]))
+		if textLength != length-RTR_PDU_HEADER_LENGTH-8-pduLength {
+			return nil, newRtrError(RTR_ERROR_CODE_CORRUPT_DATA, "length of error text is invalid")
+		}
+		return &ErrorReportPdu{ProtocolVersion: protocolVersion, ErrorCode: headerField,
+			ErroneousPdu: append([]byte{}, b[12:12+pduLength]...),
+			ErrorText:    string(b[16+pduLength:])}, nil
+	}
+	return nil, newRtrError(RTR_ERROR_CODE_UNSUPPORTED_PDU_TYPE, "pdu type "+strconv.Itoa(int(pduType))+" is not supported")
+}
+
+func parsePrefix(b []byte, ipLength int) (flags, prefixLength, maxLength uint8, prefix string, asn uint32, err error) {
+	flags = b[8]
+	prefixLength = b[9]
+	maxLength = b[10]
+	if int(prefixLength) > ipLength*8 || maxLength < prefixLength || int(maxLength) > ipLength*8 {
+		return 0, 0, 0, "", 0, newRtrError(RTR_ERROR_CODE_CORRUPT_DATA, "prefix length "+strconv.Itoa(int(prefixLength))+
+			" or max length "+strconv.Itoa(int(maxLength))+" is invalid")
+	}
+	prefix = net.IP(append([]byte{}, b[12:12+ipLength]...)).String()
+	asn = binary.BigEndian.Uint32(b[12+ipLength:])
+	return flags, prefixLength, maxLength, prefix, asn, nil
+}
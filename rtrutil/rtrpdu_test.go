@@ -0,0 +1,68 @@
+package rtrutil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/cpusoft/goutil/jsonutil"
+)
+
+func TestParseRtrPdu(t *testing.T) {
+	ski := bytes.Repeat([]byte{0x01}, RTR_PDU_SKI_LENGTH)
+	rtrPdus := []RtrPdu{
+		&SerialNotifyPdu{ProtocolVersion: 1, SessionId: 5, SerialNumber: 10},
+		&SerialQueryPdu{ProtocolVersion: 1, SessionId: 5, SerialNumber: 10},
+		&ResetQueryPdu{ProtocolVersion: 2},
+		&CacheResponsePdu{ProtocolVersion: 0, SessionId: 5},
+		&Ipv4PrefixPdu{ProtocolVersion: 1, Flags: RTR_FLAG_ANNOUNCE, PrefixLength: 24, MaxLength: 32, Prefix: "192.0.2.0", Asn: 65001},
+		&Ipv6PrefixPdu{ProtocolVersion: 1, Flags: RTR_FLAG_WITHDRAW, PrefixLength: 32, MaxLength: 48, Prefix: "2001:db8::", Asn: 65002},
+		&EndOfDataPdu{ProtocolVersion: 0, SessionId: 5, SerialNumber: 10},
+		&EndOfDataPdu{ProtocolVersion: 1, SessionId: 5, SerialNumber: 10, RefreshInterval: 3600, RetryInterval: 600, ExpireInterval: 7200},
+		&CacheResetPdu{ProtocolVersion: 1},
+		&RouterKeyPdu{ProtocolVersion: 1, Flags: RTR_FLAG_ANNOUNCE, SubjectKeyIdentifier: ski, Asn: 65003, SubjectPublicKeyInfo: []byte{0x30, 0x00}},
+		&AspaPdu{ProtocolVersion: 2, Flags: RTR_FLAG_ANNOUNCE, CustomerAsn: 65004, ProviderAsns: []uint32{1, 2, 3}},
+		&AspaPdu{ProtocolVersion: 2, Flags: RTR_FLAG_WITHDRAW, CustomerAsn: 65004, ProviderAsns: []uint32{}},
+		&ErrorReportPdu{ProtocolVersion: 1, ErrorCode: RTR_ERROR_CODE_NO_DATA_AVAILABLE, ErroneousPdu: []byte{}, ErrorText: "no data"},
+	}
+	for _, rtrPdu := range rtrPdus {
+		b := rtrPdu.Bytes()
+		parsed, err := ParseRtrPdu(b)
+		if err != nil {
+			t.Fatal(jsonutil.MarshalJson(rtrPdu), err)
+		}
+		fmt.Println(parsed.GetPduType(), jsonutil.MarshalJson(parsed))
+		if !reflect.DeepEqual(rtrPdu, parsed) {
+			t.Fatal("should be same:", jsonutil.MarshalJson(rtrPdu), jsonutil.MarshalJson(parsed))
+		}
+	}
+
+	// end of data of version 1 length, but version 0
+	endOfDataInV0 := (&EndOfDataPdu{ProtocolVersion: 1}).Bytes()
+	endOfDataInV0[0] = RTR_PROTOCOL_VERSION_0
+	errorPdus := map[string]struct {
+		b         []byte
+		errorCode uint16
+	}{
+		"short":           {[]byte{1, 2, 0, 0}, RTR_ERROR_CODE_CORRUPT_DATA},
+		"length":          {[]byte{1, 2, 0, 0, 0, 0, 0, 12}, RTR_ERROR_CODE_CORRUPT_DATA},
+		"version":         {[]byte{3, 2, 0, 0, 0, 0, 0, 8}, RTR_ERROR_CODE_UNSUPPORTED_PROTOCOL_VERSION},
+		"type":            {[]byte{1, 5, 0, 0, 0, 0, 0, 8}, RTR_ERROR_CODE_UNSUPPORTED_PDU_TYPE},
+		"routerKeyInV0":   {(&RouterKeyPdu{SubjectKeyIdentifier: ski, SubjectPublicKeyInfo: []byte{0}}).Bytes(), RTR_ERROR_CODE_UNSUPPORTED_PDU_TYPE},
+		"aspaInV1":        {(&AspaPdu{ProtocolVersion: 1}).Bytes(), RTR_ERROR_CODE_UNSUPPORTED_PDU_TYPE},
+		"aspaWithdraw":    {(&AspaPdu{ProtocolVersion: 2, ProviderAsns: []uint32{1}}).Bytes(), RTR_ERROR_CODE_ASPA_PROVIDER_LIST_ERROR},
+		"maxLength":       {(&Ipv4PrefixPdu{PrefixLength: 24, MaxLength: 16, Prefix: "10.0.0.0"}).Bytes(), RTR_ERROR_CODE_CORRUPT_DATA},
+		"endOfDataInV0":   {endOfDataInV0, RTR_ERROR_CODE_CORRUPT_DATA},
+		"errorTextLength": {[]byte{1, 10, 0, 0, 0, 0, 0, 17, 0, 0, 0, 0, 0, 0, 0, 2, 'a'}, RTR_ERROR_CODE_CORRUPT_DATA},
+	}
+	for name, errorPdu := range errorPdus {
+		_, err := ParseRtrPdu(errorPdu.b)
+		var rtrError *RtrError
+		if !errors.As(err, &rtrError) || rtrError.ErrorCode != errorPdu.errorCode {
+			t.Fatal(name, "should fail with error code", errorPdu.errorCode, err)
+		}
+		fmt.Println(name, err)
+	}
+}
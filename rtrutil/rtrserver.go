@@ -0,0 +1,368 @@
+package rtrutil
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+
+	"github.com/cpusoft/goutil/belogs"
+	"github.com/cpusoft/goutil/convert"
+	"github.com/cpusoft/goutil/jsonutil"
+	"github.com/cpusoft/goutil/randutil"
+	"github.com/cpusoft/goutil/transportutil"
+)
+
+const (
+	RTR_DEFAULT_REFRESH_INTERVAL = 3600
+	RTR_DEFAULT_RETRY_INTERVAL   = 600
+	RTR_DEFAULT_EXPIRE_INTERVAL  = 7200
+
+	// how many serials can be answered incrementally
+	RTR_SERVER_DEFAULT_MAX_DELTA_COUNT = 64
+	// bytes of one read
+	RTR_RECEIVE_ONE_PACKET_LENGTH = 4096
+)
+
+// RtrServerConfig: zero intervals and MaxDeltaCount are defaults
+type RtrServerConfig struct {
+	// random when RandomSessionId is true
+	SessionId       uint16 `json:"sessionId"`
+	RandomSessionId bool   `json:"randomSessionId"`
+
+	RefreshInterval uint32 `json:"refreshInterval"`
+	RetryInterval   uint32 `json:"retryInterval"`
+	ExpireInterval  uint32 `json:"expireInterval"`
+	MaxDeltaCount   int    `json:"maxDeltaCount"`
+}
+
+// RtrServer is RPKI-to-Router cache, implements transportutil.TcpServerProcess
+type RtrServer struct {
+	rtrServerConfig RtrServerConfig
+
+	dataMutex    sync.RWMutex
+	sessionId    uint16
+	serialNumber uint32
+	// false until SetData is called
+	hasData bool
+	records map[string]*rtrRecord
+	// ordered by serialNumber
+	deltas []*rtrDelta
+
+	// negotiated protocol version of each connKey
+	connMutex    sync.RWMutex
+	connVersions map[string]uint8
+
+	tcpServer         *transportutil.TcpServer
+	businessToConnMsg chan transportutil.BusinessToConnMsg
+}
+
+func NewRtrServer(rtrServerConfig RtrServerConfig) *RtrServer {
+	if rtrServerConfig.RandomSessionId {
+		rtrServerConfig.SessionId = uint16(randutil.Intn(65536))
+	}
+	if rtrServerConfig.RefreshInterval == 0 {
+		rtrServerConfig.RefreshInterval = RTR_DEFAULT_REFRESH_INTERVAL
+	}
+	if rtrServerConfig.RetryInterval == 0 {
+		rtrServerConfig.RetryInterval = RTR_DEFAULT_RETRY_INTERVAL
+	}
+	if rtrServerConfig.ExpireInterval == 0 {
+		rtrServerConfig.ExpireInterval = RTR_DEFAULT_EXPIRE_INTERVAL
+	}
+	if rtrServerConfig.MaxDeltaCount <= 0 {
+		rtrServerConfig.MaxDeltaCount = RTR_SERVER_DEFAULT_MAX_DELTA_COUNT
+	}
+	belogs.Debug("NewRtrServer(): rtrServerConfig:", jsonutil.MarshalJson(rtrServerConfig))
+	return &RtrServer{
+		rtrServerConfig: rtrServerConfig,
+		sessionId:       rtrServerConfig.SessionId,
+		records:         make(map[string]*rtrRecord),
+		deltas:          make([]*rtrDelta, 0),
+		connVersions:    make(map[string]uint8),
+	}
+}
+
+// StartTcpServer will block, port: `323`
+func (rs *RtrServer) StartTcpServer(port string) error {
+	rs.connMutex.Lock()
+	rs.businessToConnMsg = make(chan transportutil.BusinessToConnMsg, 16)
+	rs.tcpServer = transportutil.NewTcpServer(rs, rs.businessToConnMsg, "false", RTR_RECEIVE_ONE_PACKET_LENGTH)
+	rs.connMutex.Unlock()
+	belogs.Info("RtrServer.StartTcpServer(): port:", port, "  sessionId:", rs.sessionId)
+	return rs.tcpServer.StartTcpServer(port)
+}
+
+// Stop closes listener and all connections
+func (rs *RtrServer) Stop() {
+	rs.connMutex.RLock()
+	tcpServer := rs.tcpServer
+	rs.connMutex.RUnlock()
+	if tcpServer != nil {
+		tcpServer.SendMsgForCloseConnect(transportutil.BUSINESS_TO_CONN_MSG_TYPE_SERVER_CLOSE_FORCIBLE, "")
+	}
+}
+
+func (rs *RtrServer) GetSessionId() uint16 {
+	return rs.sessionId
+}
+
+// GetSerialNumber: hasData is false before SetData
+func (rs *RtrServer) GetSerialNumber() (serialNumber uint32, hasData bool) {
+	rs.dataMutex.RLock()
+	defer rs.dataMutex.RUnlock()
+	return rs.serialNumber, rs.hasData
+}
+
+// SetData replaces all data of cache. When data is changed, serialNumber is increased,
+// and Serial Notify is sent to all routers
+func (rs *RtrServer) SetData(rtrData *RtrData) (serialNumber uint32, changed bool, err error) {
+	records, err := getRtrRecords(rtrData)
+	if err != nil {
+		belogs.Error("RtrServer.SetData(): getRtrRecords fail:", err)
+		return 0, false, err
+	}
+
+	rs.dataMutex.Lock()
+	if !rs.hasData {
+		rs.hasData = true
+		rs.records = records
+		serialNumber = rs.serialNumber
+		rs.dataMutex.Unlock()
+		belogs.Info("RtrServer.SetData(): first data, serialNumber:", serialNumber, "  len(records):", len(records))
+		rs.sendSerialNotify(serialNumber)
+		return serialNumber, true, nil
+	}
+	changes := diffRtrRecords(rs.records, records)
+	if len(changes) == 0 {
+		serialNumber = rs.serialNumber
+		rs.dataMutex.Unlock()
+		belogs.Debug("RtrServer.SetData(): no change, serialNumber:", serialNumber)
+		return serialNumber, false, nil
+	}
+	rs.serialNumber++
+	rs.records = records
+	rs.deltas = append(rs.deltas, &rtrDelta{serialNumber: rs.serialNumber, changes: changes})
+	if len(rs.deltas) > rs.rtrServerConfig.MaxDeltaCount {
+		rs.deltas = rs.deltas[len(rs.deltas)-rs.rtrServerConfig.MaxDeltaCount:]
+	}
+	serialNumber = rs.serialNumber
+	rs.dataMutex.Unlock()
+	belogs.Info("RtrServer.SetData(): new serialNumber:", serialNumber, "  len(records):", len(records), "  len(changes):", len(changes))
+
+	rs.sendSerialNotify(serialNumber)
+	return serialNumber, true, nil
+}
+
+// send to routers which have negotiated protocol version
+func (rs *RtrServer) sendSerialNotify(serialNumber uint32) {
+	rs.connMutex.RLock()
+	tcpServer := rs.tcpServer
+	connVersions := make(map[string]uint8, len(rs.connVersions))
+	for connKey, protocolVersion := range rs.connVersions {
+		connVersions[connKey] = protocolVersion
+	}
+	rs.connMutex.RUnlock()
+	if tcpServer == nil {
+		return
+	}
+	for connKey, protocolVersion := range connVersions {
+		serialNotifyPdu := &SerialNotifyPdu{ProtocolVersion: protocolVersion, SessionId: rs.sessionId, SerialNumber: serialNumber}
+		belogs.Debug("RtrServer.sendSerialNotify(): connKey:", connKey, "  serialNotifyPdu:", jsonutil.MarshalJson(serialNotifyPdu))
+		tcpServer.SendBusinessToConnMsg(&transportutil.BusinessToConnMsg{
+			BusinessToConnMsgType: transportutil.BUSINESS_TO_CONN_MSG_TYPE_COMMON_SEND_DATA,
+			SendData:              serialNotifyPdu.Bytes(),
+			ServerConnKey:         connKey,
+		})
+	}
+}
+
+func (rs *RtrServer) OnConnectProcess(tcpConn *transportutil.TcpConn) {
+	belogs.Info("RtrServer.OnConnectProcess(): router:", tcpConn.RemoteAddr().String())
+}
+
+func (rs *RtrServer) OnReceiveAndSendProcess(tcpConn *transportutil.TcpConn, receiveData []byte) (nextConnectPolicy int, leftData []byte, err error) {
+	connKey := transportutil.GetTcpConnKey(tcpConn)
+	belogs.Debug("RtrServer.OnReceiveAndSendProcess(): connKey:", connKey, "  len(receiveData):", len(receiveData))
+
+	var packets *list.List
+	sendData, nextConnectPolicy := rs.checkPduLength(connKey, receiveData)
+	if nextConnectPolicy == transportutil.NEXT_CONNECT_POLICY_KEEP {
+		packets, leftData, err = transportutil.RecombineReceiveData(receiveData, RTR_PDU_HEADER_LENGTH,
+			RTR_PDU_LENGTH_START, RTR_PDU_LENGTH_END)
+		if err != nil {
+			belogs.Error("RtrServer.OnReceiveAndSendProcess(): RecombineReceiveData fail, connKey:", connKey, err)
+			return transportutil.NEXT_CONNECT_POLICY_CLOSE_FORCIBLE, nil, err
+		}
+		for e := packets.Front(); e != nil; e = e.Next() {
+			packet, _ := e.Value.([]byte)
+			var data []byte
+			data, nextConnectPolicy = rs.ProcessPdu(connKey, packet)
+			sendData = append(sendData, data...)
+			if nextConnectPolicy != transportutil.NEXT_CONNECT_POLICY_KEEP {
+				break
+			}
+		}
+	}
+
+	if len(sendData) > 0 {
+		_, err = tcpConn.Write(sendData)
+		if err != nil {
+			belogs.Error("RtrServer.OnReceiveAndSendProcess(): Write fail, connKey:", connKey, err)
+			return transportutil.NEXT_CONNECT_POLICY_CLOSE_FORCIBLE, nil, err
+		}
+	}
+	return nextConnectPolicy, leftData, nil
+}
+
+func (rs *RtrServer) OnCloseProcess(tcpConn *transportutil.TcpConn) {
+	connKey := transportutil.GetTcpConnKey(tcpConn)
+	rs.connMutex.Lock()
+	delete(rs.connVersions, connKey)
+	rs.connMutex.Unlock()
+	belogs.Info("RtrServer.OnCloseProcess(): connKey:", connKey)
+}
+
+// checkPduLength checks length of the first pdu, to avoid waiting for a too large pdu
+func (rs *RtrServer) checkPduLength(connKey string, receiveData []byte) (sendData []byte, nextConnectPolicy int) {
+	if len(receiveData) < RTR_PDU_HEADER_LENGTH {
+		return nil, transportutil.NEXT_CONNECT_POLICY_KEEP
+	}
+	length := convert.Bytes2Uint64(receiveData[RTR_PDU_LENGTH_START:RTR_PDU_LENGTH_END])
+	if length >= RTR_PDU_HEADER_LENGTH && length <= RTR_PDU_MAX_LENGTH {
+		return nil, transportutil.NEXT_CONNECT_POLICY_KEEP
+	}
+	belogs.Error("RtrServer.checkPduLength(): length of pdu is invalid, connKey:", connKey, "  length:", length)
+	return rs.getErrorReportBytes(connKey, receiveData[:RTR_PDU_HEADER_LENGTH],
+		newRtrError(RTR_ERROR_CODE_CORRUPT_DATA, "length of pdu is invalid")), transportutil.NEXT_CONNECT_POLICY_CLOSE_GRACEFUL
+}
+
+// ProcessPdu processes one whole pdu from router, and returns the pdus to router
+func (rs *RtrServer) ProcessPdu(connKey string, packet []byte) (sendData []byte, nextConnectPolicy int) {
+	belogs.Debug("RtrServer.ProcessPdu(): connKey:", connKey, "  packet:", convert.PrintBytesOneLine(packet))
+	rtrPdu, err := ParseRtrPdu(packet)
+	if err != nil {
+		belogs.Error("RtrServer.ProcessPdu(): ParseRtrPdu fail, connKey:", connKey, "  packet:", convert.PrintBytesOneLine(packet), err)
+		var rtrError *RtrError
+		if errors.As(err, &rtrError) && len(packet) >= 2 && packet[1] == RTR_PDU_TYPE_ERROR_REPORT {
+			// never send error report for error report
+			return nil, transportutil.NEXT_CONNECT_POLICY_CLOSE_GRACEFUL
+		}
+		return rs.getErrorReportBytes(connKey, packet, err), transportutil.NEXT_CONNECT_POLICY_CLOSE_GRACEFUL
+	}
+
+	// version negotiation: the first query decides protocol version of this connection
+	rs.connMutex.Lock()
+	protocolVersion, ok := rs.connVersions[connKey]
+	if !ok {
+		protocolVersion = rtrPdu.GetProtocolVersion()
+		rs.connVersions[connKey] = protocolVersion
+		belogs.Info("RtrServer.ProcessPdu(): connKey:", connKey, "  negotiated protocolVersion:", protocolVersion)
+	}
+	rs.connMutex.Unlock()
+	if rtrPdu.GetProtocolVersion() != protocolVersion {
+		belogs.Error("RtrServer.ProcessPdu(): unexpected protocol version, connKey:", connKey,
+			"  protocolVersion:", rtrPdu.GetProtocolVersion(), "  negotiated:", protocolVersion)
+		return rs.getErrorReportBytes(connKey, packet, newRtrError(RTR_ERROR_CODE_UNEXPECTED_PROTOCOL_VERSION,
+			"protocol version is different from the negotiated")), transportutil.NEXT_CONNECT_POLICY_CLOSE_GRACEFUL
+	}
+
+	switch p := rtrPdu.(type) {
+	case *ResetQueryPdu:
+		return rs.getResetResponseBytes(connKey, packet, protocolVersion)
+	case *SerialQueryPdu:
+		return rs.getSerialResponseBytes(connKey, packet, p)
+	case *ErrorReportPdu:
+		belogs.Error("RtrServer.ProcessPdu(): receive error report, connKey:", connKey, "  errorReportPdu:", jsonutil.MarshalJson(p))
+		return nil, transportutil.NEXT_CONNECT_POLICY_CLOSE_GRACEFUL
+	}
+	belogs.Error("RtrServer.ProcessPdu(): pdu should not be sent by router, connKey:", connKey, "  pduType:", rtrPdu.GetPduType())
+	return rs.getErrorReportBytes(connKey, packet, newRtrError(RTR_ERROR_CODE_INVALID_REQUEST,
+		"pdu should not be sent by router")), transportutil.NEXT_CONNECT_POLICY_CLOSE_GRACEFUL
+}
+
+// Cache Response, all records, End of Data
+func (rs *RtrServer) getResetResponseBytes(connKey string, packet []byte, protocolVersion uint8) (sendData []byte, nextConnectPolicy int) {
+	rs.dataMutex.RLock()
+	defer rs.dataMutex.RUnlock()
+	if !rs.hasData {
+		return rs.getErrorReportBytes(connKey, packet, newRtrError(RTR_ERROR_CODE_NO_DATA_AVAILABLE,
+			"no data available")), transportutil.NEXT_CONNECT_POLICY_KEEP
+	}
+	changes := diffRtrRecords(nil, rs.records)
+	sendData = rs.getResponseBytes(changes, protocolVersion)
+	belogs.Info("RtrServer.getResetResponseBytes(): connKey:", connKey, "  serialNumber:", rs.serialNumber,
+		"  len(changes):", len(changes), "  len(sendData):", len(sendData))
+	return sendData, transportutil.NEXT_CONNECT_POLICY_KEEP
+}
+
+// Cache Response, changes from serialNumber of router, End of Data; or Cache Reset
+func (rs *RtrServer) getSerialResponseBytes(connKey string, packet []byte, serialQueryPdu *SerialQueryPdu) (sendData []byte, nextConnectPolicy int) {
+	rs.dataMutex.RLock()
+	defer rs.dataMutex.RUnlock()
+	protocolVersion := serialQueryPdu.ProtocolVersion
+	if !rs.hasData {
+		return rs.getErrorReportBytes(connKey, packet, newRtrError(RTR_ERROR_CODE_NO_DATA_AVAILABLE,
+			"no data available")), transportutil.NEXT_CONNECT_POLICY_KEEP
+	}
+	if serialQueryPdu.SessionId != rs.sessionId {
+		belogs.Info("RtrServer.getSerialResponseBytes(): sessionId is different, connKey:", connKey,
+			"  sessionId:", serialQueryPdu.SessionId, "  will send cache reset")
+		return (&CacheResetPdu{ProtocolVersion: protocolVersion}).Bytes(), transportutil.NEXT_CONNECT_POLICY_KEEP
+	}
+	if serialQueryPdu.SerialNumber == rs.serialNumber {
+		return rs.getResponseBytes(nil, protocolVersion), transportutil.NEXT_CONNECT_POLICY_KEEP
+	}
+	for i := range rs.deltas {
+		if rs.deltas[i].serialNumber == serialQueryPdu.SerialNumber+1 {
+			changes := composeRtrDeltas(rs.deltas[i:])
+			sendData = rs.getResponseBytes(changes, protocolVersion)
+			belogs.Info("RtrServer.getSerialResponseBytes(): connKey:", connKey, "  from serialNumber:", serialQueryPdu.SerialNumber,
+				"  to serialNumber:", rs.serialNumber, "  len(changes):", len(changes), "  len(sendData):", len(sendData))
+			return sendData, transportutil.NEXT_CONNECT_POLICY_KEEP
+		}
+	}
+	belogs.Info("RtrServer.getSerialResponseBytes(): serialNumber is too old, connKey:", connKey,
+		"  serialNumber:", serialQueryPdu.SerialNumber, "  will send cache reset")
+	return (&CacheResetPdu{ProtocolVersion: protocolVersion}).Bytes(), transportutil.NEXT_CONNECT_POLICY_KEEP
+}
+
+// should be called with dataMutex
+func (rs *RtrServer) getResponseBytes(changes []rtrChange, protocolVersion uint8) []byte {
+	sendData := (&CacheResponsePdu{ProtocolVersion: protocolVersion, SessionId: rs.sessionId}).Bytes()
+	sendData = append(sendData, getChangesPdusBytes(changes, protocolVersion)...)
+	endOfDataPdu := &EndOfDataPdu{
+		ProtocolVersion: protocolVersion,
+		SessionId:       rs.sessionId,
+		SerialNumber:    rs.serialNumber,
+		RefreshInterval: rs.rtrServerConfig.RefreshInterval,
+		RetryInterval:   rs.rtrServerConfig.RetryInterval,
+		ExpireInterval:  rs.rtrServerConfig.ExpireInterval,
+	}
+	return append(sendData, endOfDataPdu.Bytes()...)
+}
+
+// protocol version of error report is the negotiated, or the highest when router's is not supported
+func (rs *RtrServer) getErrorReportBytes(connKey string, packet []byte, err error) []byte {
+	errorReportPdu := &ErrorReportPdu{
+		ErrorCode:    RTR_ERROR_CODE_INTERNAL_ERROR,
+		ErroneousPdu: packet,
+		ErrorText:    err.Error(),
+	}
+	var rtrError *RtrError
+	if errors.As(err, &rtrError) {
+		errorReportPdu.ErrorCode = rtrError.ErrorCode
+		errorReportPdu.ErrorText = rtrError.Message
+	}
+	rs.connMutex.RLock()
+	protocolVersion, ok := rs.connVersions[connKey]
+	rs.connMutex.RUnlock()
+	if ok {
+		errorReportPdu.ProtocolVersion = protocolVersion
+	} else if len(packet) > 0 && packet[0] <= RTR_PROTOCOL_VERSION_MAX {
+		errorReportPdu.ProtocolVersion = packet[0]
+	} else {
+		errorReportPdu.ProtocolVersion = RTR_PROTOCOL_VERSION_MAX
+	}
+	belogs.Debug("RtrServer.getErrorReportBytes(): connKey:", connKey, "  errorReportPdu:", jsonutil.MarshalJson(errorReportPdu))
+	return errorReportPdu.Bytes()
+}
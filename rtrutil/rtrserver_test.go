@@ -0,0 +1,201 @@
+package rtrutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cpusoft/goutil/jsonutil"
+	"github.com/cpusoft/goutil/transportutil"
+)
+
+func splitRtrPdus(t *testing.T, b []byte) []RtrPdu {
+	rtrPdus := make([]RtrPdu, 0)
+	for len(b) > 0 {
+		length := int(binary.BigEndian.Uint32(b[4:8]))
+		rtrPdu, err := ParseRtrPdu(b[:length])
+		if err != nil {
+			t.Fatal(err)
+		}
+		rtrPdus = append(rtrPdus, rtrPdu)
+		b = b[length:]
+	}
+	return rtrPdus
+}
+
+func TestRtrServerProcessPdu(t *testing.T) {
+	rs := NewRtrServer(RtrServerConfig{SessionId: 7, MaxDeltaCount: 2})
+	ski := bytes.Repeat([]byte{0x02}, RTR_PDU_SKI_LENGTH)
+
+	// no data
+	sendData, policy := rs.ProcessPdu("c1", (&ResetQueryPdu{ProtocolVersion: 1}).Bytes())
+	rtrPdus := splitRtrPdus(t, sendData)
+	if errorReportPdu, ok := rtrPdus[0].(*ErrorReportPdu); !ok || errorReportPdu.ErrorCode != RTR_ERROR_CODE_NO_DATA_AVAILABLE ||
+		policy != transportutil.NEXT_CONNECT_POLICY_KEEP {
+		t.Fatal("should be no data available:", jsonutil.MarshalJson(rtrPdus))
+	}
+
+	rs.SetData(&RtrData{
+		Vrps: []RtrVrp{{Prefix: "192.0.2.0/24", MaxLength: 24, Asn: 65001},
+			{Prefix: "2001:db8::/32", MaxLength: 48, Asn: 65002}},
+		RouterKeys: []RtrRouterKey{{SubjectKeyIdentifier: ski, Asn: 65003, SubjectPublicKeyInfo: []byte{0x30, 0x00}}},
+		Aspas:      []RtrAspa{{CustomerAsn: 65004, ProviderAsns: []uint32{3, 1}}},
+	})
+	sendData, _ = rs.ProcessPdu("c1", (&ResetQueryPdu{ProtocolVersion: 1}).Bytes())
+	rtrPdus = splitRtrPdus(t, sendData)
+	fmt.Println(jsonutil.MarshalJson(rtrPdus))
+	// cache response, 2 prefixes, router key, end of data; no aspa in version 1
+	if len(rtrPdus) != 5 || rtrPdus[0].GetPduType() != RTR_PDU_TYPE_CACHE_RESPONSE ||
+		rtrPdus[4].(*EndOfDataPdu).SerialNumber != 0 || rtrPdus[4].(*EndOfDataPdu).RefreshInterval != RTR_DEFAULT_REFRESH_INTERVAL {
+		t.Fatal("reset response is wrong:", jsonutil.MarshalJson(rtrPdus))
+	}
+
+	// version 2 has aspa with sorted providers, version 0 has only prefixes
+	sendData, _ = rs.ProcessPdu("c2", (&ResetQueryPdu{ProtocolVersion: 2}).Bytes())
+	rtrPdus = splitRtrPdus(t, sendData)
+	if aspaPdu, ok := rtrPdus[len(rtrPdus)-2].(*AspaPdu); len(rtrPdus) != 6 || !ok || aspaPdu.ProviderAsns[0] != 1 {
+		t.Fatal("version 2 reset response is wrong:", jsonutil.MarshalJson(rtrPdus))
+	}
+	sendData, _ = rs.ProcessPdu("c0", (&ResetQueryPdu{ProtocolVersion: 0}).Bytes())
+	if rtrPdus = splitRtrPdus(t, sendData); len(rtrPdus) != 4 {
+		t.Fatal("version 0 reset response is wrong:", jsonutil.MarshalJson(rtrPdus))
+	}
+
+	// serial 0 -> 1 -> 2
+	rs.SetData(&RtrData{
+		Vrps: []RtrVrp{{Prefix: "192.0.2.0/24", MaxLength: 24, Asn: 65001},
+			{Prefix: "198.51.100.0/24", MaxLength: 24, Asn: 65005}},
+		Aspas: []RtrAspa{{CustomerAsn: 65004, ProviderAsns: []uint32{1}}},
+	})
+	serialNumber, changed, _ := rs.SetData(&RtrData{
+		Vrps:  []RtrVrp{{Prefix: "192.0.2.0/24", MaxLength: 24, Asn: 65001}},
+		Aspas: []RtrAspa{{CustomerAsn: 65004, ProviderAsns: []uint32{1}}},
+	})
+	if serialNumber != 2 || !changed {
+		t.Fatal("serialNumber should be 2:", serialNumber, changed)
+	}
+	if _, changed, _ = rs.SetData(&RtrData{
+		Vrps:  []RtrVrp{{Prefix: "192.0.2.1/24", MaxLength: 24, Asn: 65001}},
+		Aspas: []RtrAspa{{CustomerAsn: 65004, ProviderAsns: []uint32{1, 1}}},
+	}); changed {
+		t.Fatal("same data should not be changed")
+	}
+
+	// from 0: withdraw 2001:db8::/32 and router key, announce aspa; 198.51.100.0/24 is announced and withdrawn
+	sendData, _ = rs.ProcessPdu("c2", (&SerialQueryPdu{ProtocolVersion: 2, SessionId: 7, SerialNumber: 0}).Bytes())
+	rtrPdus = splitRtrPdus(t, sendData)
+	fmt.Println(jsonutil.MarshalJson(rtrPdus))
+	if len(rtrPdus) != 5 || rtrPdus[1].(*Ipv6PrefixPdu).Flags != RTR_FLAG_WITHDRAW ||
+		rtrPdus[2].(*RouterKeyPdu).Flags != RTR_FLAG_WITHDRAW || rtrPdus[3].(*AspaPdu).Flags != RTR_FLAG_ANNOUNCE ||
+		len(rtrPdus[3].(*AspaPdu).ProviderAsns) != 1 || rtrPdus[4].(*EndOfDataPdu).SerialNumber != 2 {
+		t.Fatal("serial response is wrong:", jsonutil.MarshalJson(rtrPdus))
+	}
+	// from 1
+	sendData, _ = rs.ProcessPdu("c2", (&SerialQueryPdu{ProtocolVersion: 2, SessionId: 7, SerialNumber: 1}).Bytes())
+	rtrPdus = splitRtrPdus(t, sendData)
+	if len(rtrPdus) != 3 || rtrPdus[1].(*Ipv4PrefixPdu).Prefix != "198.51.100.0" || rtrPdus[1].(*Ipv4PrefixPdu).Flags != RTR_FLAG_WITHDRAW {
+		t.Fatal("serial response from 1 is wrong:", jsonutil.MarshalJson(rtrPdus))
+	}
+	// from 2, no change
+	sendData, _ = rs.ProcessPdu("c2", (&SerialQueryPdu{ProtocolVersion: 2, SessionId: 7, SerialNumber: 2}).Bytes())
+	if rtrPdus = splitRtrPdus(t, sendData); len(rtrPdus) != 2 {
+		t.Fatal("serial response from 2 is wrong:", jsonutil.MarshalJson(rtrPdus))
+	}
+
+	// too old or other session
+	rs.SetData(&RtrData{})
+	for _, serialQueryPdu := range []*SerialQueryPdu{{ProtocolVersion: 2, SessionId: 7, SerialNumber: 0},
+		{ProtocolVersion: 2, SessionId: 8, SerialNumber: 3}} {
+		sendData, _ = rs.ProcessPdu("c2", serialQueryPdu.Bytes())
+		if rtrPdus = splitRtrPdus(t, sendData); len(rtrPdus) != 1 || rtrPdus[0].GetPduType() != RTR_PDU_TYPE_CACHE_RESET {
+			t.Fatal("should be cache reset:", jsonutil.MarshalJson(rtrPdus))
+		}
+	}
+
+	// version is different from the negotiated
+	sendData, policy = rs.ProcessPdu("c2", (&ResetQueryPdu{ProtocolVersion: 1}).Bytes())
+	rtrPdus = splitRtrPdus(t, sendData)
+	if errorReportPdu := rtrPdus[0].(*ErrorReportPdu); errorReportPdu.ErrorCode != RTR_ERROR_CODE_UNEXPECTED_PROTOCOL_VERSION ||
+		errorReportPdu.ProtocolVersion != 2 || policy != transportutil.NEXT_CONNECT_POLICY_CLOSE_GRACEFUL {
+		t.Fatal("should be unexpected protocol version:", jsonutil.MarshalJson(rtrPdus))
+	}
+	// unsupported version
+	sendData, _ = rs.ProcessPdu("c3", []byte{3, RTR_PDU_TYPE_RESET_QUERY, 0, 0, 0, 0, 0, 8})
+	rtrPdus = splitRtrPdus(t, sendData)
+	if errorReportPdu := rtrPdus[0].(*ErrorReportPdu); errorReportPdu.ErrorCode != RTR_ERROR_CODE_UNSUPPORTED_PROTOCOL_VERSION ||
+		errorReportPdu.ProtocolVersion != RTR_PROTOCOL_VERSION_MAX {
+		t.Fatal("should be unsupported protocol version:", jsonutil.MarshalJson(rtrPdus))
+	}
+	// pdu from cache
+	sendData, _ = rs.ProcessPdu("c4", (&CacheResetPdu{ProtocolVersion: 1}).Bytes())
+	if errorReportPdu := splitRtrPdus(t, sendData)[0].(*ErrorReportPdu); errorReportPdu.ErrorCode != RTR_ERROR_CODE_INVALID_REQUEST {
+		t.Fatal("should be invalid request:", jsonutil.MarshalJson(errorReportPdu))
+	}
+}
+
+func TestRtrServerTcp(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	listener.Close()
+
+	rs := NewRtrServer(RtrServerConfig{RandomSessionId: true})
+	rs.SetData(&RtrData{Vrps: []RtrVrp{{Prefix: "192.0.2.0/24", MaxLength: 24, Asn: 65001}}})
+	go rs.StartTcpServer(port)
+	defer rs.Stop()
+	var conn net.Conn
+	for i := 0; i < 20; i++ {
+		if conn, err = net.Dial("tcp", "127.0.0.1:"+port); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// query is sent in two parts
+	query := (&ResetQueryPdu{ProtocolVersion: 1}).Bytes()
+	conn.Write(query[:3])
+	time.Sleep(100 * time.Millisecond)
+	conn.Write(query[3:])
+	response := make([]byte, RTR_PDU_CACHE_RESPONSE_LENGTH+RTR_PDU_IPV4_PREFIX_LENGTH+RTR_PDU_END_OF_DATA_LENGTH)
+	if _, err = io.ReadFull(conn, response); err != nil {
+		t.Fatal(err)
+	}
+	rtrPdus := splitRtrPdus(t, response)
+	fmt.Println(jsonutil.MarshalJson(rtrPdus))
+	if rtrPdus[0].(*CacheResponsePdu).SessionId != rs.GetSessionId() {
+		t.Fatal("sessionId is wrong:", jsonutil.MarshalJson(rtrPdus))
+	}
+
+	rs.SetData(&RtrData{Vrps: []RtrVrp{{Prefix: "192.0.2.0/24", MaxLength: 24, Asn: 65002}}})
+	notify := make([]byte, RTR_PDU_SERIAL_NOTIFY_LENGTH)
+	if _, err = io.ReadFull(conn, notify); err != nil {
+		t.Fatal(err)
+	}
+	rtrPdus = splitRtrPdus(t, notify)
+	if serialNotifyPdu, ok := rtrPdus[0].(*SerialNotifyPdu); !ok || serialNotifyPdu.SerialNumber != 1 || serialNotifyPdu.ProtocolVersion != 1 {
+		t.Fatal("should be serial notify:", jsonutil.MarshalJson(rtrPdus))
+	}
+
+	// two queries in one write
+	serialQuery := (&SerialQueryPdu{ProtocolVersion: 1, SessionId: rs.GetSessionId(), SerialNumber: 0}).Bytes()
+	conn.Write(append(serialQuery, serialQuery...))
+	response = make([]byte, 2*(RTR_PDU_CACHE_RESPONSE_LENGTH+2*RTR_PDU_IPV4_PREFIX_LENGTH+RTR_PDU_END_OF_DATA_LENGTH))
+	if _, err = io.ReadFull(conn, response); err != nil {
+		t.Fatal(err)
+	}
+	if rtrPdus = splitRtrPdus(t, response); len(rtrPdus) != 8 || rtrPdus[1].(*Ipv4PrefixPdu).Flags != RTR_FLAG_WITHDRAW {
+		t.Fatal("serial response is wrong:", jsonutil.MarshalJson(rtrPdus))
+	}
+}
@@ -3,6 +3,7 @@ package talutil
 import (
 	"bufio"
 	"bytes"
+	"crypto"
 	"os"
 	"strings"
 
@@ -11,8 +12,17 @@ import (
 )
 
 type TalInfo struct {
+	// the first uri
 	SyncUrl string `json:"syncUrl"`
-	PubKey  string `json:"pubKey"`
+	// base64 of SubjectPublicKeyInfo, lines are joined
+	PubKey string `json:"pubKey"`
+
+	// RFC 8630: all rsync/https uris in order
+	Uris []string `json:"uris"`
+	// lines start with '#', without '#'
+	Comments []string `json:"comments"`
+	// decoded from PubKey, nil when PubKey is invalid
+	PublicKey crypto.PublicKey `json:"-"`
 }
 
 func GetAllTalFile(file string) ([]string, error) {
@@ -75,20 +85,9 @@ func parseTalInfo(file string) (TalInfo, error) {
 	buf := make([]byte, 1024*1024) // 初始1MB缓冲区
 	input.Buffer(buf, 2*1024*1024) // 最大2MB单行
 
-	i := 0
-	var buffer bytes.Buffer
+	lines := make([]string, 0)
 	for input.Scan() {
-		tmp := strings.TrimSpace(input.Text())
-		if len(tmp) == 0 {
-			continue
-		}
-		if i == 0 {
-			tmp = strings.Replace(tmp, "\r\n", "", -1)
-			talInfo.SyncUrl = tmp
-		} else {
-			buffer.WriteString(tmp)
-		}
-		i++
+		lines = append(lines, input.Text())
 	}
 
 	if err := input.Err(); err != nil {
@@ -96,7 +95,50 @@ func parseTalInfo(file string) (TalInfo, error) {
 		return talInfo, err
 	}
 
-	talInfo.PubKey = buffer.String()
+	talInfo = parseTalLines(lines)
 	belogs.Debug("ParseTalInfo(): talInfo:", talInfo)
 	return talInfo, nil
 }
+
+// parseTalLines: comments, then uris, then public key. the first line after comments is always seen as uri,
+// other uri lines must have "://". PublicKey is nil when it cannot be decoded
+func parseTalLines(lines []string) TalInfo {
+	talInfo := TalInfo{
+		Uris:     make([]string, 0),
+		Comments: make([]string, 0),
+	}
+	var buffer bytes.Buffer
+	inUris := true
+	for _, line := range lines {
+		tmp := strings.TrimSpace(line)
+		if len(tmp) == 0 {
+			// empty line ends uri section
+			if len(talInfo.Uris) > 0 {
+				inUris = false
+			}
+			continue
+		}
+		if strings.HasPrefix(tmp, "#") {
+			talInfo.Comments = append(talInfo.Comments, strings.TrimSpace(strings.TrimPrefix(tmp, "#")))
+			continue
+		}
+		if inUris && (len(talInfo.Uris) == 0 || strings.Contains(tmp, "://")) {
+			talInfo.Uris = append(talInfo.Uris, tmp)
+			continue
+		}
+		inUris = false
+		buffer.WriteString(tmp)
+	}
+	if len(talInfo.Uris) > 0 {
+		talInfo.SyncUrl = talInfo.Uris[0]
+	}
+	talInfo.PubKey = buffer.String()
+	if len(talInfo.PubKey) > 0 {
+		publicKey, _, err := DecodeTalPublicKey(talInfo.PubKey)
+		if err != nil {
+			belogs.Debug("parseTalLines(): DecodeTalPublicKey fail:", talInfo.PubKey, err)
+		}
+		talInfo.PublicKey = publicKey
+	}
+	return talInfo
+}
@@ -0,0 +1,222 @@
+package talutil
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cpusoft/goutil/base64util"
+	"github.com/cpusoft/goutil/belogs"
+	"github.com/cpusoft/goutil/httpclient"
+	"github.com/cpusoft/goutil/rsyncutil"
+)
+
+const (
+	// length of base64 line when writing tal
+	TAL_BASE64_LINE_LENGTH = 64
+)
+
+// TaCertFetchFunc gets TA certificate from one uri of tal
+type TaCertFetchFunc func(uri string) ([]byte, error)
+
+// ParseTalInfoFile parses tal file strictly by RFC 8630
+func ParseTalInfoFile(file string) (TalInfo, error) {
+	belogs.Debug("ParseTalInfoFile(): file:", file)
+	b, err := os.ReadFile(file)
+	if err != nil {
+		belogs.Error("ParseTalInfoFile(): ReadFile fail:", file, err)
+		return TalInfo{}, err
+	}
+	return ParseTalInfoBytes(b)
+}
+
+// ParseTalInfoBytes parses tal strictly by RFC 8630: optional comments, one or more rsync/https uris,
+// an empty line, then base64 of SubjectPublicKeyInfo which may be in several lines
+func ParseTalInfoBytes(talBytes []byte) (TalInfo, error) {
+	lines := strings.Split(strings.ReplaceAll(string(talBytes), "\r\n", "\n"), "\n")
+	talInfo := parseTalLines(lines)
+	if err := CheckTalInfo(&talInfo); err != nil {
+		belogs.Error("ParseTalInfoBytes(): CheckTalInfo fail:", err)
+		return talInfo, err
+	}
+	return talInfo, nil
+}
+
+// CheckTalInfo checks uris and public key
+func CheckTalInfo(talInfo *TalInfo) error {
+	if len(talInfo.Uris) == 0 {
+		return errors.New("tal has no uri")
+	}
+	for _, uri := range talInfo.Uris {
+		if !strings.HasPrefix(uri, "rsync://") && !strings.HasPrefix(uri, "https://") {
+			return errors.New("uri of tal should be rsync or https: " + uri)
+		}
+		if strings.ContainsAny(uri, " \t") {
+			return errors.New("uri of tal should not contain space: " + uri)
+		}
+	}
+	if len(talInfo.PubKey) == 0 {
+		return errors.New("tal has no public key")
+	}
+	publicKey, _, err := DecodeTalPublicKey(talInfo.PubKey)
+	if err != nil {
+		return err
+	}
+	talInfo.PublicKey = publicKey
+	return nil
+}
+
+// DecodeTalPublicKey decodes base64 SubjectPublicKeyInfo, PEM armor lines are ignored
+func DecodeTalPublicKey(pubKey string) (publicKey crypto.PublicKey, spki []byte, err error) {
+	pubKey = strings.TrimSpace(pubKey)
+	pubKey = strings.TrimPrefix(pubKey, "-----BEGIN PUBLIC KEY-----")
+	pubKey = strings.TrimSuffix(pubKey, "-----END PUBLIC KEY-----")
+	spki, err = base64util.DecodeBase64(base64util.TrimBase64(pubKey))
+	if err != nil {
+		return nil, nil, errors.New("public key of tal is not base64: " + err.Error())
+	}
+	publicKey, err = x509.ParsePKIXPublicKey(spki)
+	if err != nil {
+		return nil, nil, errors.New("public key of tal is not SubjectPublicKeyInfo: " + err.Error())
+	}
+	return publicKey, spki, nil
+}
+
+// getTalSpki gets SubjectPublicKeyInfo from PublicKey, or from PubKey
+func getTalSpki(talInfo *TalInfo) ([]byte, error) {
+	if talInfo.PublicKey != nil {
+		return x509.MarshalPKIXPublicKey(talInfo.PublicKey)
+	}
+	_, spki, err := DecodeTalPublicKey(talInfo.PubKey)
+	return spki, err
+}
+
+// FormatTalInfo writes tal in canonical format of RFC 8630:
+// "# comment" lines, uri lines, an empty line, then base64 in lines of 64 chars
+func FormatTalInfo(talInfo *TalInfo) ([]byte, error) {
+	uris := talInfo.Uris
+	if len(uris) == 0 && len(talInfo.SyncUrl) > 0 {
+		uris = []string{talInfo.SyncUrl}
+	}
+	if len(uris) == 0 {
+		return nil, errors.New("tal has no uri")
+	}
+	spki, err := getTalSpki(talInfo)
+	if err != nil {
+		belogs.Error("FormatTalInfo(): getTalSpki fail:", err)
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	for _, comment := range talInfo.Comments {
+		buffer.WriteString("# " + comment + "\n")
+	}
+	for _, uri := range uris {
+		buffer.WriteString(uri + "\n")
+	}
+	buffer.WriteString("\n")
+	pubKey := base64util.EncodeBase64(spki)
+	for len(pubKey) > TAL_BASE64_LINE_LENGTH {
+		buffer.WriteString(pubKey[:TAL_BASE64_LINE_LENGTH] + "\n")
+		pubKey = pubKey[TAL_BASE64_LINE_LENGTH:]
+	}
+	buffer.WriteString(pubKey + "\n")
+	return buffer.Bytes(), nil
+}
+
+// WriteTalInfoToFile saves tal as FormatTalInfo
+func WriteTalInfoToFile(talInfo *TalInfo, file string) error {
+	b, err := FormatTalInfo(talInfo)
+	if err != nil {
+		belogs.Error("WriteTalInfoToFile(): FormatTalInfo fail:", file, err)
+		return err
+	}
+	return os.WriteFile(file, b, 0644)
+}
+
+// ValidateTaCert checks the TA certificate by tal: public key must be the same as tal (RFC 8630 3),
+// it is self-signed CA, and it is in validity period at validationTime, zero is time.Now()
+func ValidateTaCert(talInfo *TalInfo, certBytes []byte, validationTime time.Time) (*x509.Certificate, error) {
+	spki, err := getTalSpki(talInfo)
+	if err != nil {
+		belogs.Error("ValidateTaCert(): getTalSpki fail:", err)
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		belogs.Error("ValidateTaCert(): ParseCertificate fail:", err)
+		return nil, errors.New("TA certificate is invalid: " + err.Error())
+	}
+	if !bytes.Equal(cert.RawSubjectPublicKeyInfo, spki) {
+		return nil, errors.New("public key of TA certificate is different from tal")
+	}
+	if !cert.BasicConstraintsValid || !cert.IsCA {
+		return nil, errors.New("TA certificate is not CA")
+	}
+	if err = cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+		return nil, errors.New("TA certificate is not self-signed: " + err.Error())
+	}
+	if validationTime.IsZero() {
+		validationTime = time.Now()
+	}
+	if validationTime.Before(cert.NotBefore) || validationTime.After(cert.NotAfter) {
+		return nil, errors.New("TA certificate is not in validity period, notBefore:" + cert.NotBefore.String() +
+			", notAfter:" + cert.NotAfter.String())
+	}
+	return cert, nil
+}
+
+// FetchTaCert tries uris of tal in order, returns the first TA certificate which is valid at validationTime
+func FetchTaCert(talInfo *TalInfo, fetch TaCertFetchFunc, validationTime time.Time) (cert *x509.Certificate, certBytes []byte, uri string, err error) {
+	uris := talInfo.Uris
+	if len(uris) == 0 && len(talInfo.SyncUrl) > 0 {
+		uris = []string{talInfo.SyncUrl}
+	}
+	errMsgs := make([]string, 0)
+	for _, uri = range uris {
+		certBytes, err = fetch(uri)
+		if err != nil {
+			belogs.Debug("FetchTaCert(): fetch fail:", uri, err)
+			errMsgs = append(errMsgs, uri+": "+err.Error())
+			continue
+		}
+		cert, err = ValidateTaCert(talInfo, certBytes, validationTime)
+		if err != nil {
+			belogs.Debug("FetchTaCert(): ValidateTaCert fail:", uri, err)
+			errMsgs = append(errMsgs, uri+": "+err.Error())
+			continue
+		}
+		belogs.Debug("FetchTaCert(): ok:", uri)
+		return cert, certBytes, uri, nil
+	}
+	if len(errMsgs) == 0 {
+		return nil, nil, "", errors.New("tal has no uri")
+	}
+	belogs.Error("FetchTaCert(): all uris fail:", errMsgs)
+	return nil, nil, "", errors.New("fetch TA certificate fail, " + strings.Join(errMsgs, "; "))
+}
+
+// NewTaCertFetchFunc gets https uri by httpclient, and rsync uri by rsyncutil native client
+func NewTaCertFetchFunc(httpClientConfig *httpclient.HttpClientConfig,
+	rsyncClientConfig *rsyncutil.RsyncClientConfig) TaCertFetchFunc {
+	return func(uri string) ([]byte, error) {
+		if strings.HasPrefix(uri, "rsync://") {
+			return rsyncutil.RsyncNativeFileWithConfig(context.Background(), uri, rsyncClientConfig)
+		}
+		resp, body, err := httpclient.GetWithConfig(uri, httpClientConfig)
+		if err != nil {
+			return nil, err
+		}
+		defer httpclient.CloseResponseBody(resp)
+		if resp.StatusCode != http.StatusOK {
+			return nil, errors.New("http status code is " + resp.Status)
+		}
+		return []byte(body), nil
+	}
+}
@@ -0,0 +1,123 @@
+package talutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cpusoft/goutil/base64util"
+)
+
+func newTestTaCert(t *testing.T) (*ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ta"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, certBytes
+}
+
+func TestTalInfoParseFormatValidate(t *testing.T) {
+	key, certBytes := newTestTaCert(t)
+	spki, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	pubKey := base64util.EncodeBase64(spki)
+	tal := "# test ta\r\n# second comment\r\n" +
+		"rsync://rpki.example.com/ta/ta.cer\r\n" +
+		"https://rpki.example.com/ta/ta.cer\r\n\r\n" +
+		pubKey[:40] + "\r\n" + pubKey[40:] + "\r\n"
+
+	talInfo, err := ParseTalInfoBytes([]byte(tal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(talInfo.Uris) != 2 || talInfo.SyncUrl != "rsync://rpki.example.com/ta/ta.cer" ||
+		len(talInfo.Comments) != 2 || talInfo.Comments[0] != "test ta" ||
+		talInfo.PubKey != pubKey || talInfo.PublicKey == nil {
+		t.Fatal("parse wrong:", talInfo)
+	}
+
+	// round trip
+	file := filepath.Join(t.TempDir(), "test.tal")
+	if err = WriteTalInfoToFile(&talInfo, file); err != nil {
+		t.Fatal(err)
+	}
+	talInfo2, err := ParseTalInfoFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(talInfo2.Uris, ",") != strings.Join(talInfo.Uris, ",") || talInfo2.PubKey != pubKey ||
+		strings.Join(talInfo2.Comments, ",") != strings.Join(talInfo.Comments, ",") {
+		t.Fatal("round trip wrong:", talInfo2)
+	}
+	b, _ := FormatTalInfo(&talInfo2)
+	for _, line := range strings.Split(string(b), "\n") {
+		if len(line) > TAL_BASE64_LINE_LENGTH {
+			t.Fatal("line is too long:", line)
+		}
+	}
+
+	cert, err := ValidateTaCert(&talInfo, certBytes, time.Time{})
+	if err != nil || cert.Subject.CommonName != "test-ta" {
+		t.Fatal("ValidateTaCert fail:", err)
+	}
+	if _, err = ValidateTaCert(&talInfo, certBytes, time.Now().Add(2*time.Hour)); err == nil {
+		t.Fatal("ValidateTaCert should fail after notAfter")
+	}
+	_, otherCertBytes := newTestTaCert(t)
+	if _, err = ValidateTaCert(&talInfo, otherCertBytes, time.Time{}); err == nil {
+		t.Fatal("ValidateTaCert should fail for other key")
+	}
+
+	// the first uri fails, the second is ok
+	tried := make([]string, 0)
+	_, _, uri, err := FetchTaCert(&talInfo, func(uri string) ([]byte, error) {
+		tried = append(tried, uri)
+		if strings.HasPrefix(uri, "rsync://") {
+			return nil, errors.New("connection refused")
+		}
+		return certBytes, nil
+	}, time.Time{})
+	if err != nil || uri != "https://rpki.example.com/ta/ta.cer" || len(tried) != 2 {
+		t.Fatal("FetchTaCert fail:", uri, tried, err)
+	}
+	if _, _, _, err = FetchTaCert(&talInfo, func(uri string) ([]byte, error) {
+		return otherCertBytes, nil
+	}, time.Time{}); err == nil {
+		t.Fatal("FetchTaCert should fail")
+	}
+}
+
+func TestParseTalInfoBytesFail(t *testing.T) {
+	tals := []string{
+		"",
+		"http://rpki.example.com/ta.cer\n\nMIIB",
+		"rsync://rpki.example.com/ta.cer\n\n",
+		"rsync://rpki.example.com/ta.cer\n\n!!!notbase64",
+		"rsync://rpki.example.com/ta.cer\n\nYWJjZA==",
+	}
+	for _, tal := range tals {
+		if _, err := ParseTalInfoBytes([]byte(tal)); err == nil {
+			t.Fatal("should fail:", tal)
+		}
+	}
+}
@@ -136,7 +136,7 @@ func (tc *TcpClient) StartTlsClient(server string) (err error) {
 	tlsConfigModel := TlsConfigModel{
 		TlsRootCrtFileName:    tc.tlsRootCrtFileName,
 		TlsPublicCrtFileName:  tc.tlsPublicCrtFileName,
-		TlsPrivateKeyFileName: tc.tlsPublicCrtFileName,
+		TlsPrivateKeyFileName: tc.tlsPrivateKeyFileName,
 		InsecureSkipVerify:    false,
 	}
 	config, err := GetClientTlsConfig(tlsConfigModel)
@@ -233,7 +233,9 @@ func (tc *TcpClient) onReceive() (err error) {
 
 		belogs.Debug("TcpClient.onReceive(): Read n :", n, "  length:", length, " from tcpConn: ", tc.tcpConn.RemoteAddr().String(),
 			"  time(s):", time.Since(start))
-		nextRwPolicy, leftData, connToBusinessMsg, err := tc.tcpClientProcess.OnReceiveProcess(tc.tcpConn, append(leftData, buffer[:n]...))
+		var nextRwPolicy int
+		var connToBusinessMsg *ConnToBusinessMsg
+		nextRwPolicy, leftData, connToBusinessMsg, err = tc.tcpClientProcess.OnReceiveProcess(tc.tcpConn, append(leftData, buffer[:n]...))
 		belogs.Debug("TcpClient.onReceive(): tcpClientProcess.OnReceiveProcess, tcpConn: ", tc.tcpConn.RemoteAddr().String(), " receive n: ", n,
 			"  len(leftData):", len(leftData), "  nextRwPolicy:", nextRwPolicy, "  connToBusinessMsg:", jsonutil.MarshalJson(connToBusinessMsg), "  time(s):", time.Since(start))
 		if err != nil {
@@ -250,7 +252,7 @@ func (tc *TcpClient) onReceive() (err error) {
 		belogs.Debug("TcpClient.onReceive(): will reset buffer and wait for Read from tcpConn: ", tc.tcpConn.RemoteAddr().String(),
 			"  time(s):", time.Since(start))
 		go func() {
-			if !connToBusinessMsg.IsActiveSendFromServer {
+			if connToBusinessMsg != nil && !connToBusinessMsg.IsActiveSendFromServer {
 				belogs.Debug("TcpClient.onReceive(): tcpClientProcess.OnReceiveProcess, will send to tc.businessToConnMsgCh:", tc.businessToConnMsgCh,
 					"   connToBusinessMsg:", jsonutil.MarshalJson(connToBusinessMsg))
 				tc.connToBusinessMsgCh <- *connToBusinessMsg
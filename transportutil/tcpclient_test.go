@@ -1,5 +1,147 @@
 package transportutil
 
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// leftDataClientProcess waits for 4 bytes, less bytes are returned as leftData without connToBusinessMsg
+type leftDataClientProcess struct {
+	receivedCh chan []byte
+}
+
+func (p *leftDataClientProcess) OnConnectProcess(tcpConn *TcpConn) {}
+
+func (p *leftDataClientProcess) OnCloseProcess(tcpConn *TcpConn) {}
+
+func (p *leftDataClientProcess) OnReceiveProcess(tcpConn *TcpConn, receiveData []byte) (nextRwPolicy int,
+	leftData []byte, connToBusinessMsg *ConnToBusinessMsg, err error) {
+	if len(receiveData) < 4 {
+		return NEXT_RW_POLICY_WAIT_READ, receiveData, nil, nil
+	}
+	p.receivedCh <- receiveData
+	return NEXT_RW_POLICY_WAIT_READ, nil, nil, nil
+}
+
+// write "ab" and "cd" in two packets to the first accepted conn
+func writeTwoPacketsToConn(listener net.Listener) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.Write([]byte("ab"))
+	time.Sleep(100 * time.Millisecond)
+	conn.Write([]byte("cd"))
+	time.Sleep(time.Second)
+}
+
+func waitForReceived(t *testing.T, receivedCh chan []byte) {
+	select {
+	case received := <-receivedCh:
+		if string(received) != "abcd" {
+			t.Fatal("leftData is lost:", string(received))
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("leftData is lost, 4 bytes are not received")
+	}
+}
+
+// leftData should be kept for the next Read, and nil connToBusinessMsg is ignored
+func TestTcpClientLeftData(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go writeTwoPacketsToConn(listener)
+
+	process := &leftDataClientProcess{receivedCh: make(chan []byte, 1)}
+	tc := NewTcpClient(process, make(chan BusinessToConnMsg), "false", 1024)
+	err = tc.StartTcpClient(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForReceived(t, process.receivedCh)
+}
+
+// tls client should load key pair from public crt and private key file
+func TestTlsClientPrivateKey(t *testing.T) {
+	crtFileName, keyFileName := writeTestTlsFiles(t)
+	cert, err := tls.LoadX509KeyPair(crtFileName, keyFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go writeTwoPacketsToConn(listener)
+
+	process := &leftDataClientProcess{receivedCh: make(chan []byte, 1)}
+	tc, err := NewTlsClient(crtFileName, crtFileName, keyFileName,
+		process, make(chan BusinessToConnMsg), "false", 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.StartTlsClient(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForReceived(t, process.receivedCh)
+}
+
+// self-signed cert for 127.0.0.1, is used as root, server and client cert
+func writeTestTlsFiles(t *testing.T) (crtFileName, keyFileName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	crtFileName = filepath.Join(dir, "client.crt")
+	keyFileName = filepath.Join(dir, "client.key")
+	err = os.WriteFile(crtFileName, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keyFileName, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crtFileName, keyFileName
+}
+
 /*
 func TestCreateTcpClient(t *testing.T) {
 	clientProcessFunc := new(ClientProcessFunc)
@@ -271,6 +271,7 @@ func (ts *TcpServer) receiveAndSend(tcpConn *TcpConn) {
 	var leftData []byte
 	var buffer []byte
 	var length uint16
+	var nextConnectPolicy int
 	// wait for new packet to read
 	for {
 		start := time.Now()
@@ -304,7 +305,7 @@ func (ts *TcpServer) receiveAndSend(tcpConn *TcpConn) {
 		belogs.Debug("TcpServer.receiveAndSend(): tcpConn: ", tcpConn.RemoteAddr().String(),
 			"   length:", length, " , Read n:", n,
 			"   time(s):", time.Since(start))
-		nextConnectPolicy, leftData, err := ts.tcpServerProcess.OnReceiveAndSendProcess(tcpConn, append(leftData, buffer[:n]...))
+		nextConnectPolicy, leftData, err = ts.tcpServerProcess.OnReceiveAndSendProcess(tcpConn, append(leftData, buffer[:n]...))
 		belogs.Debug("TcpServer.receiveAndSend(): after OnReceiveAndSendProcess,server tcpConn: ", tcpConn.RemoteAddr().String(), " receive n: ", n,
 			"  len(leftData):", len(leftData), "  time(s):", time.Since(start))
 		if err != nil {
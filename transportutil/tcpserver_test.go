@@ -1,10 +1,70 @@
 package transportutil
 
 import (
+	"net"
+	"testing"
+	"time"
+
 	_ "github.com/cpusoft/goutil/conf"
 	_ "github.com/cpusoft/goutil/logs"
 )
 
+// leftDataServerProcess waits for 4 bytes, less bytes are returned as leftData
+type leftDataServerProcess struct {
+	receivedCh chan []byte
+}
+
+func (p *leftDataServerProcess) OnConnectProcess(tcpConn *TcpConn) {}
+
+func (p *leftDataServerProcess) OnCloseProcess(tcpConn *TcpConn) {}
+
+func (p *leftDataServerProcess) OnReceiveAndSendProcess(tcpConn *TcpConn, receiveData []byte) (nextConnectPolicy int,
+	leftData []byte, err error) {
+	if len(receiveData) < 4 {
+		return NEXT_CONNECT_POLICY_KEEP, receiveData, nil
+	}
+	p.receivedCh <- receiveData
+	return NEXT_CONNECT_POLICY_KEEP, nil, nil
+}
+
+// leftData of OnReceiveAndSendProcess should be kept for the next Read
+func TestTcpServerLeftData(t *testing.T) {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	process := &leftDataServerProcess{receivedCh: make(chan []byte, 1)}
+	ts := NewTcpServer(process, make(chan BusinessToConnMsg), "false", 1024)
+	ts.state = SERVER_STATE_RUNNING
+	go func() {
+		conn, err := listener.AcceptTCP()
+		if err != nil {
+			return
+		}
+		tcpConn := NewFromTcpConn(conn)
+		ts.onConnect(tcpConn)
+		ts.receiveAndSend(tcpConn)
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("ab"))
+	time.Sleep(100 * time.Millisecond)
+	conn.Write([]byte("cd"))
+	select {
+	case received := <-process.receivedCh:
+		if string(received) != "abcd" {
+			t.Fatal("leftData is lost:", string(received))
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("leftData is lost, 4 bytes are not received")
+	}
+}
+
 /*
 func TestCreateTcpServer(t *testing.T) {
 
@@ -104,7 +104,7 @@ func RecombineReceiveData(receiveData []byte, minPacketLen, lengthFieldStart,
 			packets.PushBack(receiveData[:length])
 
 			// leftData continue to RecombineReceiveData
-			leftData = make([]byte, length)
+			leftData = make([]byte, len(receiveData)-length)
 			copy(leftData, receiveData[length:])
 			receiveData = leftData
 
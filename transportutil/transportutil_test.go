@@ -0,0 +1,31 @@
+package transportutil
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestRecombineReceiveData(t *testing.T) {
+	// length is in [2:4], the third packet is not complete
+	receiveData := []byte{0, 1, 0, 5, 'a',
+		0, 1, 0, 6, 'b', 'b',
+		0, 1, 0, 7, 'c'}
+	packets, leftData, err := RecombineReceiveData(receiveData, 4, 2, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(packets.Len(), leftData)
+	if packets.Len() != 2 || !bytes.Equal(packets.Back().Value.([]byte), []byte{0, 1, 0, 6, 'b', 'b'}) {
+		t.Fatal("packets are wrong:", packets.Len())
+	}
+	// leftData is all the rest, not length of the previous packet
+	if !bytes.Equal(leftData, []byte{0, 1, 0, 7, 'c'}) {
+		t.Fatal("leftData is wrong:", leftData)
+	}
+
+	packets, leftData, err = RecombineReceiveData(append(leftData, 'c', 'c'), 4, 2, 4)
+	if err != nil || packets.Len() != 1 || len(leftData) != 0 {
+		t.Fatal("packet of leftData is wrong:", packets.Len(), leftData, err)
+	}
+}
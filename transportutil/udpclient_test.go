@@ -7,6 +7,7 @@ import (
 	"github.com/cpusoft/goutil/belogs"
 	_ "github.com/cpusoft/goutil/conf"
 	"github.com/cpusoft/goutil/convert"
+	"github.com/cpusoft/goutil/dnsutil"
 )
 
 var dnsUdpClient *DnsUdpClient
@@ -62,13 +63,17 @@ func NewDnsClientProcess(businessToConnMsg chan BusinessToConnMsg) *DnsClientPro
 func (c *DnsClientProcess) OnReceiveProcess(udpConn *UdpConn, receiveData []byte) (connToBusinessMsg *ConnToBusinessMsg, err error) {
 	belogs.Debug("OnReceiveProcess(): client len(receiveData):", len(receiveData), "   receiveData:", convert.PrintBytesOneLine(receiveData))
 
-	receiveStr := string(receiveData)
-	belogs.Debug("OnReceiveProcess():receiveStr:", receiveStr)
+	response, err := dnsutil.UnpackDnsMessage(receiveData)
+	if err != nil {
+		belogs.Error("OnReceiveProcess(): UnpackDnsMessage fail:", err)
+		return nil, err
+	}
+	belogs.Debug("OnReceiveProcess(): response:", response.String())
 
 	// continue to receive next receiveData
 	bu := &ConnToBusinessMsg{
 		ConnToBusinessMsgType: "dns",
-		ReceiveData:           "recive from server:" + receiveStr,
+		ReceiveData:           response,
 	}
 	return bu, nil
 }
@@ -79,10 +84,15 @@ func TestDnsUdpClient(t *testing.T) {
 	if err != nil {
 		belogs.Error("TestDnsUdpClient(): StartDnsUdpClient fail:", err)
 	}
-	sendBytes := []byte("test udp")
+	sendBytes, err := dnsutil.NewDnsQuery(0x1234, "www.example.com", dnsutil.DNS_TYPE_INT_A).Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
 	businessToConnMsg := &BusinessToConnMsg{
 		BusinessToConnMsgType: BUSINESS_TO_CONN_MSG_TYPE_COMMON_SEND_AND_RECEIVE_DATA,
 		SendData:              sendBytes,
+
+		NeedClientWaitForServerResponse: true,
 	}
 	connToBusinessMsg, err := dnsUdpClient.udpClient.SendAndReceiveMsg(businessToConnMsg)
 	belogs.Debug("connToBusinessMsg:", connToBusinessMsg, err)
@@ -84,6 +84,11 @@ func (us *UdpServer) receiveAndSend() {
 					"  clientUdpAddr:", clientUdpAddr, err)
 				return
 			}
+			if us.state == SERVER_STATE_CLOSING || us.state == SERVER_STATE_CLOSED {
+				// udpConn is closed by waitBusinessToConnMsg
+				belogs.Debug("UdpServer.receiveAndSend(): server is closed, will return")
+				return
+			}
 			belogs.Error("UdpServer.receiveAndSend(): Read remote fail: ", err)
 			continue
 		}
@@ -3,12 +3,14 @@ package transportutil
 import (
 	"fmt"
 	"net"
+	"net/netip"
 	"testing"
 	"time"
 
 	"github.com/cpusoft/goutil/belogs"
 	_ "github.com/cpusoft/goutil/conf"
 	"github.com/cpusoft/goutil/convert"
+	"github.com/cpusoft/goutil/dnsutil"
 )
 
 var dnsUdpServer *DnsUdpServer
@@ -59,12 +61,31 @@ func NewServerProcess(businessToConnMsg chan BusinessToConnMsg) *ServerProcess {
 
 func (c *ServerProcess) OnReceiveAndSendProcess(udpConn *UdpConn, clientUdpAddr *net.UDPAddr, receiveData []byte) (err error) {
 	fmt.Println("OnReceiveAndSendProcess():", convert.PrintBytesOneLine(receiveData))
-	// 发送数据
-	sendStr := string(receiveData) + " from server"
+	// answer every A query with 127.0.0.1
+	var response *dnsutil.DnsMessage
+	query, err := dnsutil.UnpackDnsMessage(receiveData)
+	if err != nil {
+		belogs.Error("OnReceiveAndSendProcess(): UnpackDnsMessage fail:", err)
+		response = dnsutil.NewDnsMessage()
+		response.Header.Qr = dnsutil.DNS_QR_RESPONSE
+		response.Header.RCode = dnsutil.DNS_RCODE_FORMERR
+	} else {
+		response = dnsutil.NewDnsResponse(query, uint16(dnsutil.DNS_RCODE_NOERROR))
+		for _, q := range query.Questions {
+			if q.Type == dnsutil.DNS_TYPE_INT_A {
+				response.Answers = append(response.Answers, &dnsutil.DnsRr{Name: q.Name, Type: q.Type, Class: q.Class, Ttl: 60,
+					Data: &dnsutil.DnsRrDataA{Ip: netip.MustParseAddr("127.0.0.1")}})
+			}
+		}
+	}
+	sendBytes, err := response.Pack()
+	if err != nil {
+		belogs.Error("OnReceiveAndSendProcess(): Pack fail:", err)
+		return err
+	}
 
 	//len, err := udpConn.WriteToClient([]byte(sendStr))
 	serverConnKey := GetUdpAddrKey(clientUdpAddr)
-	sendBytes := []byte(sendStr)
 	businessToConnMsg := &BusinessToConnMsg{
 		BusinessToConnMsgType: BUSINESS_TO_CONN_MSG_TYPE_COMMON_SEND_DATA,
 		SendData:              sendBytes,
@@ -1,6 +1,18 @@
 package zonefileutil
 
-/*
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cpusoft/goutil/belogs"
+	"github.com/cpusoft/goutil/dnsutil"
+	"github.com/cpusoft/goutil/jsonutil"
+	"github.com/cpusoft/goutil/osutil"
+	"github.com/guregu/null/v6"
+)
+
 // type is golang keyword, so use Rr***
 type ResourceRecord struct {
 	// will have "." in the end  // lower
@@ -16,7 +28,7 @@ type ResourceRecord struct {
 	RrValues []string `json:"rrValues,omitempty"`
 }
 
-//
+// NewResourceRecord formats name, type and class
 func NewResourceRecord(rrDomain, rrName, rrType, rrClass string,
 	rrTtl null.Int, rrValues []string) (resourceRecord *ResourceRecord) {
 	resourceRecord = &ResourceRecord{
@@ -79,8 +91,8 @@ func (c *ResourceRecord) String() string {
 	return b.String()
 }
 
-//  https://datatracker.ietf.org/doc/rfc8765/
-//  RrName: ***, or @, or ""
+//	https://datatracker.ietf.org/doc/rfc8765/
+//	RrName: ***, or @, or ""
 //
 // if rrClass==ANY,                 remove all RRsets from a name in all classes: TTL = 0xFFFFFFFE, RDLEN = 0
 //
@@ -172,7 +184,7 @@ func UpdateResourceRecord(zoneFileModel *ZoneFileModel, oldResourceRecord, newRe
 
 	// set rrdomain
 	if len(newResourceRecord.RrDomain) == 0 {
-		newResourceRecord.RrDomain = newResourceRecord.RrName + "." + zoneFileModel.Origin
+		newResourceRecord.RrDomain = getRrDomain(newResourceRecord.RrName, zoneFileModel.Origin)
 	}
 	// set old rr's ttl as del specified ttl
 	oldResourceRecord.RrTtl = null.IntFrom(dnsutil.DSO_DEL_SPECIFIED_RESOURCE_RECORD_TTL)
@@ -214,7 +226,7 @@ func AddResourceRecord(zoneFileModel *ZoneFileModel, afterResourceRecord, newRes
 
 	// rrdomain
 	if len(newResourceRecord.RrDomain) == 0 {
-		newResourceRecord.RrDomain = newResourceRecord.RrName + "." + zoneFileModel.Origin
+		newResourceRecord.RrDomain = getRrDomain(newResourceRecord.RrName, zoneFileModel.Origin)
 	}
 	belogs.Debug("AddResourceRecord():  afterResourceRecord :", afterResourceRecord,
 		"   newResourceRecord :", jsonutil.MarshalJson(newResourceRecord))
@@ -384,7 +396,7 @@ func GetResourceRecordAnyKey(resourceRecord *ResourceRecord) string {
 	if resourceRecord == nil {
 		return ""
 	}
-	rrAnyKey := resourceRecord.RrDomain + "#" + dnsutil.DnsIntTypes[dnsutil.DNS_TYPE_ANY]
+	rrAnyKey := resourceRecord.RrDomain + "#" + dnsutil.DNS_TYPE_STR_ANY
 	belogs.Debug("GetResourceRecordAnyKey():rrAnyKey:", rrAnyKey)
 	return rrAnyKey
 }
@@ -406,4 +418,3 @@ func IsDelResourceRecord(rrTtl null.Int) bool {
 	}
 	return false
 }
-*/
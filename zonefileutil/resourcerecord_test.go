@@ -1,12 +1,22 @@
 package zonefileutil
 
-/*
+import (
+	"fmt"
+	"testing"
+)
+
 func TestDeepCopy(t *testing.T) {
 	v := []string{"101.228.10.127", "101.228.10.128", "101.228.10.129"}
 	r := ResourceRecord{RrName: "test", RrType: "A", RrValues: v}
 
 	nr := deepcopyResourceRecord(&r)
 	fmt.Println(nr)
-
+	if !EqualResourceRecord(&r, nr) {
+		t.Fatal("copy is not equal:", nr)
+	}
+	// values are copied, not shared
+	nr.RrValues[0] = "101.228.10.130"
+	if r.RrValues[0] != "101.228.10.127" {
+		t.Fatal("values are shared:", r.RrValues)
+	}
 }
-*/
@@ -0,0 +1,310 @@
+package zonefileutil
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/cpusoft/goutil/belogs"
+	"github.com/cpusoft/goutil/dnsutil"
+	"github.com/cpusoft/goutil/jsonutil"
+	"github.com/guregu/null/v6"
+)
+
+// getRrDomain: "@" is origin, relative name is joined with origin, absolute name ends with "."
+func getRrDomain(name, origin string) string {
+	if name == "@" {
+		return FormatRrDomain(origin)
+	}
+	if isAbsoluteDomain(name) {
+		return dnsutil.FormatDomainFqdn(name)
+	}
+	return dnsutil.FormatDomainFqdn(name + "." + origin)
+}
+
+// getRrName: origin is "@", others are relative to origin; ok is false when rrDomain is out of origin
+func getRrName(rrDomain, origin string) (rrName string, ok bool) {
+	if !dnsutil.IsSubDomain(rrDomain, origin) {
+		return "", false
+	}
+	rrDomain = dnsutil.FormatDomainFqdn(rrDomain)
+	origin = dnsutil.FormatDomainFqdn(origin)
+	if rrDomain == origin {
+		return "@", true
+	}
+	if origin == "." {
+		return strings.TrimSuffix(rrDomain, "."), true
+	}
+	return strings.TrimSuffix(rrDomain, "."+origin), true
+}
+
+// name ends with "." which is not escaped
+func isAbsoluteDomain(name string) bool {
+	if !strings.HasSuffix(name, ".") {
+		return false
+	}
+	backslashes := 0
+	for i := len(name) - 2; i >= 0 && name[i] == '\\'; i-- {
+		backslashes++
+	}
+	return backslashes%2 == 0
+}
+
+// ResourceRecordToDnsRr converts rr in zone file to rr in message, RrName and names in RrValues are relative to origin.
+// When RrTtl is null, use ttl
+func ResourceRecordToDnsRr(resourceRecord *ResourceRecord, origin string, ttl null.Int) (*dnsutil.DnsRr, error) {
+	if resourceRecord == nil {
+		return nil, errors.New("resourceRecord is nil")
+	}
+	rrType, err := getZoneFileType(FormatRrClassOrRrType(resourceRecord.RrType))
+	if err != nil {
+		return nil, err
+	}
+	rrClass := uint16(dnsutil.DNS_CLASS_INT_IN)
+	if len(resourceRecord.RrClass) > 0 {
+		if rrClass, err = getZoneFileClass(FormatRrClassOrRrType(resourceRecord.RrClass)); err != nil {
+			return nil, err
+		}
+	}
+	// RrName is written in zone file, so it is used first
+	rrDomain := resourceRecord.RrDomain
+	if len(resourceRecord.RrName) > 0 {
+		rrDomain = getRrDomain(resourceRecord.RrName, origin)
+	} else if len(rrDomain) == 0 {
+		rrDomain = getRrDomain("@", origin)
+	}
+	if !resourceRecord.RrTtl.IsZero() {
+		ttl = resourceRecord.RrTtl
+	}
+	data, err := getDnsRrData(rrType, resourceRecord.RrValues, origin)
+	if err != nil {
+		return nil, errors.New(resourceRecord.RrType + " of " + rrDomain + " is invalid: " + err.Error())
+	}
+	return &dnsutil.DnsRr{
+		Name:  dnsutil.FormatDomainFqdn(rrDomain),
+		Type:  rrType,
+		Class: rrClass,
+		Ttl:   uint32(ttl.ValueOrZero()),
+		Data:  data,
+	}, nil
+}
+
+// GetDnsRrs converts all rrs of zone in order, null RrTtl is Ttl of zone
+func GetDnsRrs(zoneFileModel *ZoneFileModel) ([]*dnsutil.DnsRr, error) {
+	if err := checkZoneFileModel(zoneFileModel); err != nil {
+		belogs.Error("GetDnsRrs(): checkZoneFileModel fail:", err)
+		return nil, err
+	}
+	zoneFileModel.resourceRecordMutex.RLock()
+	defer zoneFileModel.resourceRecordMutex.RUnlock()
+	dnsRrs := make([]*dnsutil.DnsRr, 0, len(zoneFileModel.ResourceRecords))
+	for _, resourceRecord := range zoneFileModel.ResourceRecords {
+		dnsRr, err := ResourceRecordToDnsRr(resourceRecord, zoneFileModel.Origin, zoneFileModel.Ttl)
+		if err != nil {
+			belogs.Error("GetDnsRrs(): ResourceRecordToDnsRr fail:", zoneFileModel.Origin, jsonutil.MarshalJson(resourceRecord), err)
+			return nil, err
+		}
+		dnsRrs = append(dnsRrs, dnsRr)
+	}
+	return dnsRrs, nil
+}
+
+// NewZoneFileModelByDnsRrs gets zone from rrs, such as rrs of zone transfer; all rrs should be in origin,
+// and ttl is written in every rr
+func NewZoneFileModelByDnsRrs(origin, zoneFileName string, dnsRrs []*dnsutil.DnsRr) (*ZoneFileModel, error) {
+	zoneFileModel := newZoneFileModel(zoneFileName)
+	zoneFileModel.Origin = dnsutil.FormatDomainFqdn(origin)
+	for _, dnsRr := range dnsRrs {
+		resourceRecord, err := DnsRrToResourceRecord(dnsRr, zoneFileModel.Origin)
+		if err != nil {
+			belogs.Error("NewZoneFileModelByDnsRrs(): DnsRrToResourceRecord fail:", origin, dnsRr, err)
+			return nil, err
+		}
+		zoneFileModel.ResourceRecords = append(zoneFileModel.ResourceRecords, resourceRecord)
+	}
+	return zoneFileModel, nil
+}
+
+// DnsRrToResourceRecord converts rr in message to rr in zone file, Name of dnsRr should be in origin
+func DnsRrToResourceRecord(dnsRr *dnsutil.DnsRr, origin string) (*ResourceRecord, error) {
+	if dnsRr == nil || dnsRr.Data == nil {
+		return nil, errors.New("dnsRr or its rdata is empty")
+	}
+	rrName, ok := getRrName(dnsRr.Name, origin)
+	if !ok {
+		return nil, errors.New(dnsRr.Name + " is out of origin " + origin)
+	}
+	var rrValues []string
+	switch data := dnsRr.Data.(type) {
+	case *dnsutil.DnsRrDataTxt:
+		rrValues = make([]string, 0, len(data.Txts))
+		for _, txt := range data.Txts {
+			rrValues = append(rrValues, quoteTxt(txt))
+		}
+		if len(rrValues) == 0 {
+			rrValues = append(rrValues, `""`)
+		}
+	case *dnsutil.DnsRrDataUnknown:
+		rrValues = []string{`\#`, strconv.Itoa(len(data.Data))}
+		if len(data.Data) > 0 {
+			rrValues = append(rrValues, hex.EncodeToString(data.Data))
+		}
+	default:
+		rrValues = strings.Fields(dnsRr.Data.String())
+	}
+	return NewResourceRecord(dnsRr.Name, rrName, dnsutil.GetDnsTypeStr(dnsRr.Type), dnsutil.GetDnsClassStr(dnsRr.Class),
+		null.IntFrom(int64(dnsRr.Ttl)), rrValues), nil
+}
+
+// getDnsRrData parses values of rdata, "\# length hex" is for any type, rfc3597 5
+func getDnsRrData(rrType uint16, values []string, origin string) (dnsutil.DnsRrData, error) {
+	if len(values) > 0 && values[0] == `\#` {
+		if len(values) < 2 {
+			return nil, errors.New(`length after \# is empty`)
+		}
+		length, err := strconv.Atoi(values[1])
+		if err != nil {
+			return nil, errors.New(`length after \# is invalid: ` + values[1])
+		}
+		data, err := hex.DecodeString(strings.Join(values[2:], ""))
+		if err != nil || len(data) != length {
+			return nil, errors.New(`hex after \# is invalid`)
+		}
+		return &dnsutil.DnsRrDataUnknown{Data: data}, nil
+	}
+	checkCount := func(count int) error {
+		if len(values) != count {
+			return errors.New("count of values should be " + strconv.Itoa(count) + ", but it is " + strconv.Itoa(len(values)))
+		}
+		return nil
+	}
+	switch rrType {
+	case dnsutil.DNS_TYPE_INT_A, dnsutil.DNS_TYPE_INT_AAAA:
+		if err := checkCount(1); err != nil {
+			return nil, err
+		}
+		ip, err := netip.ParseAddr(values[0])
+		if err != nil {
+			return nil, err
+		}
+		if rrType == dnsutil.DNS_TYPE_INT_A && ip.Is4() {
+			return &dnsutil.DnsRrDataA{Ip: ip}, nil
+		} else if rrType == dnsutil.DNS_TYPE_INT_AAAA && ip.Is6() && ip.Zone() == "" {
+			return &dnsutil.DnsRrDataAaaa{Ip: ip}, nil
+		}
+		return nil, errors.New("ip is invalid: " + values[0])
+	case dnsutil.DNS_TYPE_INT_NS, dnsutil.DNS_TYPE_INT_CNAME, dnsutil.DNS_TYPE_INT_PTR:
+		if err := checkCount(1); err != nil {
+			return nil, err
+		}
+		return &dnsutil.DnsRrDataHost{Host: getRrDomain(values[0], origin)}, nil
+	case dnsutil.DNS_TYPE_INT_MX:
+		if err := checkCount(2); err != nil {
+			return nil, err
+		}
+		preference, err := strconv.ParseUint(values[0], 10, 16)
+		if err != nil {
+			return nil, errors.New("preference is invalid: " + values[0])
+		}
+		return &dnsutil.DnsRrDataMx{Preference: uint16(preference), Exchange: getRrDomain(values[1], origin)}, nil
+	case dnsutil.DNS_TYPE_INT_SRV:
+		if err := checkCount(4); err != nil {
+			return nil, err
+		}
+		numbers, err := parseUints(values[:3], 16)
+		if err != nil {
+			return nil, err
+		}
+		return &dnsutil.DnsRrDataSrv{Priority: uint16(numbers[0]), Weight: uint16(numbers[1]), Port: uint16(numbers[2]),
+			Target: getRrDomain(values[3], origin)}, nil
+	case dnsutil.DNS_TYPE_INT_SOA:
+		if err := checkCount(7); err != nil {
+			return nil, err
+		}
+		numbers, err := parseUints(values[2:], 32)
+		if err != nil {
+			return nil, err
+		}
+		return &dnsutil.DnsRrDataSoa{MName: getRrDomain(values[0], origin), RName: getRrDomain(values[1], origin),
+			Serial: uint32(numbers[0]), Refresh: uint32(numbers[1]), Retry: uint32(numbers[2]),
+			Expire: uint32(numbers[3]), Minimum: uint32(numbers[4])}, nil
+	case dnsutil.DNS_TYPE_INT_TXT:
+		txts := make([]string, 0, len(values))
+		for _, value := range values {
+			txt := unquoteTxt(value)
+			if len(txt) > 255 {
+				return nil, errors.New("one string of TXT should be 255 octets or less")
+			}
+			txts = append(txts, txt)
+		}
+		return &dnsutil.DnsRrDataTxt{Txts: txts}, nil
+	}
+	return nil, errors.New(`type should use \# format`)
+}
+
+func parseUints(values []string, bitSize int) ([]uint64, error) {
+	numbers := make([]uint64, 0, len(values))
+	for _, value := range values {
+		n, err := strconv.ParseUint(value, 10, bitSize)
+		if err != nil {
+			return nil, errors.New("number is invalid: " + value)
+		}
+		numbers = append(numbers, n)
+	}
+	return numbers, nil
+}
+
+// unquoteTxt removes quotes, and "\X" is X, "\DDD" is one octet, rfc1035 5.1
+func unquoteTxt(s string) string {
+	if len(s) >= 2 && strings.HasPrefix(s, `"`) && strings.HasSuffix(s, `"`) {
+		s = s[1 : len(s)-1]
+	}
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 >= len(s) {
+			b = append(b, s[i])
+			continue
+		}
+		if i+3 < len(s) && isDigits(s[i+1:i+4]) {
+			if n, err := strconv.Atoi(s[i+1 : i+4]); err == nil && n <= 0xff {
+				b = append(b, byte(n))
+				i += 3
+				continue
+			}
+		}
+		i++
+		b = append(b, s[i])
+	}
+	return string(b)
+}
+
+// quoteTxt adds quotes, '"' and '\' are escaped, non-printable is "\DDD"
+func quoteTxt(s string) string {
+	var b strings.Builder
+	b.Grow(len(s) + 2)
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c > 0x7e:
+			b.WriteString("\\" + strconv.FormatUint(uint64(c)+1000, 10)[1:])
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func isDigits(s string) bool {
+	for i := range s {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
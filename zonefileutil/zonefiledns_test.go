@@ -0,0 +1,49 @@
+package zonefileutil
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewZoneFileModelByDnsRrs(t *testing.T) {
+	zf, err := LoadZoneFile("mydomain.com.zone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dnsRrs, err := GetDnsRrs(zf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zoneFileName := filepath.Join(t.TempDir(), "mydomain.com.zone")
+	newZf, err := NewZoneFileModelByDnsRrs(zf.Origin, zoneFileName, dnsRrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = SaveZoneFile(newZf, ""); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(newZf)
+
+	// same rrs after save and load
+	loadZf, err := LoadZoneFile(zoneFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loadRrs, err := GetDnsRrs(loadZf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loadRrs) != len(dnsRrs) {
+		t.Fatal("count of rrs is wrong:", len(loadRrs), len(dnsRrs))
+	}
+	for i := range dnsRrs {
+		if loadRrs[i].String() != dnsRrs[i].String() {
+			t.Fatal("rr is wrong:", loadRrs[i], dnsRrs[i])
+		}
+	}
+
+	if _, err = NewZoneFileModelByDnsRrs("example.com.", zoneFileName, dnsRrs); err == nil {
+		t.Fatal("rr out of origin should fail")
+	}
+}
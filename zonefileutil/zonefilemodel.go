@@ -1,9 +1,22 @@
 package zonefileutil
 
-/*
-type ZoneFileModel struct {
-	zonefile *zonefile.Zonefile `json:"-"`
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cpusoft/goutil/belogs"
+	"github.com/cpusoft/goutil/dnsutil"
+	"github.com/cpusoft/goutil/fileutil"
+	"github.com/cpusoft/goutil/jsonutil"
+	"github.com/cpusoft/goutil/osutil"
+	"github.com/guregu/null/v6"
+)
 
+type ZoneFileModel struct {
 	// will have "." in the end  // lower
 	Origin string `json:"origin"`
 	// null.NewInt(0, false) or null.NewInt(i64, true)
@@ -31,9 +44,8 @@ func (c *ZoneFileModel) String() string {
 	return b.String()
 }
 
-func newZoneFileModel(zonefile *zonefile.Zonefile, zoneFileName string) *ZoneFileModel {
+func newZoneFileModel(zoneFileName string) *ZoneFileModel {
 	c := &ZoneFileModel{}
-	c.zonefile = zonefile
 	c.ResourceRecords = make([]*ResourceRecord, 0)
 	c.ZoneFileName = zoneFileName
 	return c
@@ -43,7 +55,8 @@ func newZoneFileModel(zonefile *zonefile.Zonefile, zoneFileName string) *ZoneFil
 // not support $include ;
 // all ttl must be digital ;
 // zoneFileName must be absolute path filename;
-func LoadZoneFile(zoneFileName string) (originModel *dnsutil.OriginModel, err error) {
+// rr out of origin is ignored ;
+func LoadZoneFile(zoneFileName string) (zoneFileModel *ZoneFileModel, err error) {
 	// Load zonefile
 	data, err := os.ReadFile(zoneFileName)
 	if err != nil {
@@ -52,69 +65,76 @@ func LoadZoneFile(zoneFileName string) (originModel *dnsutil.OriginModel, err er
 	}
 	belogs.Debug("LoadZoneFile():len(data):", zoneFileName, len(data))
 
-	zf, perr := zonefile.Load(data)
-	if perr != nil {
-		belogs.Error("LoadZoneFile():Load fail:", zoneFileName, perr)
-		return nil, errors.New(perr.Error())
+	entries, err := parseZoneFile(data)
+	if err != nil {
+		belogs.Error("LoadZoneFile():parseZoneFile fail:", zoneFileName, err)
+		return nil, err
 	}
-	belogs.Debug("LoadZoneFile():len(zf.Entries):", zoneFileName, len(zf.Entries()))
-	var lastRrName string
-	originModel = dnsutil.NewOriginModel()
-	for i, e := range zf.Entries() {
-		belogs.Debug("LoadZoneFile(): i :", i, "  e:", e)
-		if len(e.Command()) > 0 {
-			belogs.Debug("LoadZoneFile(): Command:", string(e.Command()), e.Values())
-			if string(e.Command()) == "$ORIGIN" && len(e.Values()) > 0 {
-				originModel.Origin = dnsutil.FormatOrigin(string(e.Values()[0]))
-			} else if string(e.Command()) == "$TTL" && len(e.Values()) > 0 {
-				ttlStr := string(e.Values()[0])
+	belogs.Debug("LoadZoneFile():len(entries):", zoneFileName, len(entries))
+	var lastRrDomain string
+	zoneFileModel = newZoneFileModel(zoneFileName)
+	for i, e := range entries {
+		belogs.Debug("LoadZoneFile(): i :", i, "  e.line:", e.line)
+		if len(e.command) > 0 {
+			belogs.Debug("LoadZoneFile(): command:", e.command, e.values)
+			if e.command == "$ORIGIN" {
+				zoneFileModel.Origin = FormatRrDomain(e.values[0])
+			} else if e.command == "$TTL" {
+				ttlStr := e.values[0]
 				belogs.Debug("LoadZoneFile(): ttlStr:", ttlStr)
-				ttl, _ := strconv.Atoi(ttlStr)
-				originModel.Ttl = null.IntFrom(int64(ttl))
-			}
-		} else {
-			// check Domain,if is empty, get last rrName
-			rrName := FormatRrName(string(e.Domain()))
-			if len(rrName) == 0 && len(lastRrName) > 0 {
-				rrName = lastRrName
-			} else if len(rrName) > 0 {
-				lastRrName = rrName
-			}
-			belogs.Debug("LoadZoneFile(): rrName:", rrName)
-
-			// get ttl
-			rrTtl := null.NewInt(0, false)
-			if e.TTL() != nil {
-				ttl := int64(*e.TTL())
-				if ttl > dnsutil.DSO_ADD_RECOURCE_RECORD_MAX_TTL {
-					belogs.Error("LoadZoneFile(): ttl is bigger than DSO_ADD_RECOURCE_RECORD_MAX_TTL:", ttl, dnsutil.DSO_ADD_RECOURCE_RECORD_MAX_TTL)
-					return nil, errors.New("ttl is bigger than DSO_ADD_RECOURCE_RECORD_MAX_TTL")
+				ttl, err := strconv.ParseUint(ttlStr, 10, 32)
+				if err != nil || ttl > dnsutil.DSO_ADD_RECOURCE_RECORD_MAX_TTL {
+					belogs.Error("LoadZoneFile(): $TTL is invalid:", zoneFileName, ttlStr, err)
+					return nil, errors.New("$TTL is invalid: " + ttlStr)
 				}
-				rrTtl = null.IntFrom(ttl)
+				zoneFileModel.Ttl = null.IntFrom(int64(ttl))
 			}
-			belogs.Debug("LoadZoneFile(): rrTtl:", rrTtl)
+			continue
+		}
+		// relative domain needs origin
+		if len(zoneFileModel.Origin) == 0 {
+			belogs.Error("LoadZoneFile():Origin must be before rr, fail:", zoneFileName, e.line)
+			return nil, errors.New("Origin must be before rr")
+		}
 
-			var rrData string
-			for j := range e.Values() {
-				rrData += (string(e.Values()[j]) + " ")
-			}
-			belogs.Debug("LoadZoneFile(): rrData:", rrData)
+		// check Domain,if is empty, get last rrDomain
+		rrDomain := lastRrDomain
+		if len(e.domain) > 0 {
+			rrDomain = getRrDomain(e.domain, zoneFileModel.Origin)
+			lastRrDomain = rrDomain
+		} else if len(rrDomain) == 0 {
+			belogs.Error("LoadZoneFile():first rr has no domain, fail:", zoneFileName, e.line)
+			return nil, errors.New("first rr has no domain")
+		}
+		rrName, ok := getRrName(rrDomain, zoneFileModel.Origin)
+		if !ok {
+			belogs.Info("LoadZoneFile(): rr is out of origin, ignore:", zoneFileName, e.line, rrDomain, zoneFileModel.Origin)
+			continue
+		}
+		belogs.Debug("LoadZoneFile(): rrDomain:", rrDomain, "  rrName:", rrName)
 
-			rrModel := dnsutil.NewRrModel(originModel.Origin, rrName,
-				string(e.Type()), string(e.Class()), rrTtl, rrData)
-			belogs.Debug("LoadZoneFile(): rrModel:", jsonutil.MarshalJson(rrModel))
-			originModel.RrModels = append(originModel.RrModels, rrModel)
+		// get ttl
+		if e.ttl.ValueOrZero() > dnsutil.DSO_ADD_RECOURCE_RECORD_MAX_TTL {
+			belogs.Error("LoadZoneFile(): ttl is bigger than DSO_ADD_RECOURCE_RECORD_MAX_TTL:", e.ttl, dnsutil.DSO_ADD_RECOURCE_RECORD_MAX_TTL)
+			return nil, errors.New("ttl is bigger than DSO_ADD_RECOURCE_RECORD_MAX_TTL")
+		}
+		rrClass := e.class
+		if len(rrClass) == 0 {
+			rrClass = dnsutil.DNS_CLASS_STR_IN
 		}
+		resourceRecord := NewResourceRecord(rrDomain, rrName, e.rrType, rrClass, e.ttl, e.values)
+		belogs.Debug("LoadZoneFile(): resourceRecord:", jsonutil.MarshalJson(resourceRecord))
+		zoneFileModel.ResourceRecords = append(zoneFileModel.ResourceRecords, resourceRecord)
 	}
 
 	// check
-	if len(originModel.Origin) == 0 {
+	if len(zoneFileModel.Origin) == 0 {
 		belogs.Error("LoadZoneFile():Origin must be exist, fail:", zoneFileName)
 		return nil, errors.New("Origin must be exist")
 	}
 
-	belogs.Debug("LoadZoneFile(): originModel:", jsonutil.MarshalJson(originModel))
-	return originModel, nil
+	belogs.Debug("LoadZoneFile(): zoneFileModel:", jsonutil.MarshalJson(zoneFileModel))
+	return zoneFileModel, nil
 }
 
 // if file is empty, then save to zoneFileName in LoadZoneFile()
@@ -144,4 +164,3 @@ func checkZoneFileModel(zoneFileModel *ZoneFileModel) error {
 	}
 	return nil
 }
-*/
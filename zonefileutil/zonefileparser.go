@@ -0,0 +1,205 @@
+package zonefileutil
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/cpusoft/goutil/dnsutil"
+	"github.com/guregu/null/v6"
+)
+
+// zoneFileEntry is one command or one rr in master file, rfc1035 5.1
+type zoneFileEntry struct {
+	// "$ORIGIN" or "$TTL", empty for rr
+	command string
+	// empty when owner is blank, then it is the last owner
+	domain string
+	ttl    null.Int
+	class  string
+	rrType string
+	// quotes and escapes are kept, such as "v=spf1 \; all"
+	values []string
+	line   int
+}
+
+// parseZoneFile splits data to entries: ";" is comment, "(" and ")" join lines, blank in the beginning is last owner
+func parseZoneFile(data []byte) (entries []*zoneFileEntry, err error) {
+	entries = make([]*zoneFileEntry, 0)
+	var tokens []string
+	var token strings.Builder
+	inToken, startsWithBlank, parentheses := false, false, 0
+	line, entryLine, atLineStart := 1, 1, true
+
+	endToken := func() {
+		if inToken {
+			tokens = append(tokens, token.String())
+			token.Reset()
+			inToken = false
+		}
+	}
+	endEntry := func() error {
+		endToken()
+		if len(tokens) > 0 {
+			entry, err := newZoneFileEntry(tokens, startsWithBlank, entryLine)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		tokens = nil
+		return nil
+	}
+
+	for i := 0; i < len(data); i++ {
+		ch := data[i]
+		if atLineStart && parentheses == 0 && len(tokens) == 0 {
+			startsWithBlank = ch == ' ' || ch == '\t'
+			entryLine = line
+		}
+		atLineStart = false
+		switch {
+		case ch == '\n':
+			line++
+			atLineStart = true
+			if parentheses > 0 {
+				endToken()
+				continue
+			}
+			if err = endEntry(); err != nil {
+				return nil, err
+			}
+		case ch == ';':
+			for i+1 < len(data) && data[i+1] != '\n' {
+				i++
+			}
+		case ch == ' ' || ch == '\t' || ch == '\r':
+			endToken()
+		case ch == '(':
+			endToken()
+			parentheses++
+		case ch == ')':
+			endToken()
+			if parentheses == 0 {
+				return nil, errors.New("line " + strconv.Itoa(line) + ": ')' is not matched")
+			}
+			parentheses--
+		case ch == '"':
+			endToken()
+			token.WriteByte(ch)
+			for i++; ; i++ {
+				if i >= len(data) {
+					return nil, errors.New("line " + strconv.Itoa(line) + ": '\"' is not closed")
+				}
+				token.WriteByte(data[i])
+				if data[i] == '\n' {
+					line++
+				} else if data[i] == '\\' && i+1 < len(data) {
+					i++
+					token.WriteByte(data[i])
+				} else if data[i] == '"' {
+					break
+				}
+			}
+			inToken = true
+			endToken()
+		case ch == '\\' && i+1 < len(data):
+			token.WriteByte(ch)
+			i++
+			token.WriteByte(data[i])
+			inToken = true
+		default:
+			token.WriteByte(ch)
+			inToken = true
+		}
+	}
+	if parentheses > 0 {
+		return nil, errors.New("line " + strconv.Itoa(line) + ": '(' is not closed")
+	}
+	if err = endEntry(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// newZoneFileEntry: <domain> [<ttl>] [<class>] <type> <rdata>, ttl and class can be in any order
+func newZoneFileEntry(tokens []string, startsWithBlank bool, line int) (*zoneFileEntry, error) {
+	lineStr := "line " + strconv.Itoa(line) + ": "
+	entry := &zoneFileEntry{line: line}
+	if strings.HasPrefix(tokens[0], "$") && !startsWithBlank {
+		entry.command = strings.ToUpper(tokens[0])
+		entry.values = tokens[1:]
+		switch entry.command {
+		case "$ORIGIN", "$TTL":
+			if len(entry.values) != 1 {
+				return nil, errors.New(lineStr + entry.command + " should have one value")
+			}
+		default:
+			return nil, errors.New(lineStr + entry.command + " is not supported")
+		}
+		return entry, nil
+	}
+
+	if !startsWithBlank {
+		entry.domain = tokens[0]
+		tokens = tokens[1:]
+	}
+	for j := 0; j < 2 && len(tokens) > 0; j++ {
+		if isZoneFileTtl(tokens[0]) && !entry.ttl.Valid {
+			ttl, err := strconv.ParseUint(tokens[0], 10, 32)
+			if err != nil {
+				return nil, errors.New(lineStr + "ttl is invalid: " + tokens[0])
+			}
+			entry.ttl = null.IntFrom(int64(ttl))
+		} else if isZoneFileClass(tokens[0]) && len(entry.class) == 0 {
+			entry.class = strings.ToUpper(tokens[0])
+		} else {
+			break
+		}
+		tokens = tokens[1:]
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New(lineStr + "type is empty")
+	}
+	entry.rrType = strings.ToUpper(tokens[0])
+	if _, err := getZoneFileType(entry.rrType); err != nil {
+		return nil, errors.New(lineStr + err.Error())
+	}
+	entry.values = tokens[1:]
+	if len(entry.values) == 0 {
+		return nil, errors.New(lineStr + "rdata of " + entry.rrType + " is empty")
+	}
+	return entry, nil
+}
+
+// all ttl must be digital
+func isZoneFileTtl(s string) bool {
+	return len(s) > 0 && isDigits(s)
+}
+
+func isZoneFileClass(s string) bool {
+	_, err := getZoneFileClass(strings.ToUpper(s))
+	return err == nil
+}
+
+// getZoneFileType: "A", or "TYPE123" as rfc3597 5
+func getZoneFileType(rrType string) (uint16, error) {
+	if t, ok := dnsutil.DnsStrTypes[rrType]; ok {
+		return t, nil
+	}
+	if t, err := strconv.ParseUint(strings.TrimPrefix(rrType, "TYPE"), 10, 16); err == nil && strings.HasPrefix(rrType, "TYPE") {
+		return uint16(t), nil
+	}
+	return 0, errors.New("type is unknown: " + rrType)
+}
+
+// getZoneFileClass: "IN", or "CLASS123" as rfc3597 5
+func getZoneFileClass(rrClass string) (uint16, error) {
+	if c, ok := dnsutil.DnsStrClasses[rrClass]; ok {
+		return c, nil
+	}
+	if c, err := strconv.ParseUint(strings.TrimPrefix(rrClass, "CLASS"), 10, 16); err == nil && strings.HasPrefix(rrClass, "CLASS") {
+		return uint16(c), nil
+	}
+	return 0, errors.New("class is unknown: " + rrClass)
+}
@@ -0,0 +1,58 @@
+package zonefileutil
+
+import (
+	"testing"
+
+	"github.com/cpusoft/goutil/jsonutil"
+)
+
+func TestParseZoneFile(t *testing.T) {
+	data := `$origin Example.com.
+$TTL 300
+@ IN 3600 SOA ns hostmaster ( 1 2 3
+	4 5 ) ; comment
+  NS ns.example.net.
+txt TXT "a ; b" "c \"d\"" e\;f
+rfc3597 CLASS1 TYPE65534 \# 3 abcdef
+`
+	entries, err := parseZoneFile([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 6 {
+		t.Fatal("count of entries is wrong:", len(entries))
+	}
+	soa := entries[2]
+	if soa.domain != "@" || soa.ttl.ValueOrZero() != 3600 || soa.class != "IN" || soa.rrType != "SOA" ||
+		jsonutil.MarshalJson(soa.values) != `["ns","hostmaster","1","2","3","4","5"]` {
+		t.Fatal("SOA is wrong:", soa)
+	}
+	if ns := entries[3]; ns.domain != "" || ns.rrType != "NS" || ns.line != 5 {
+		t.Fatal("NS is wrong:", ns)
+	}
+	txt := entries[4]
+	if jsonutil.MarshalJson(txt.values) != jsonutil.MarshalJson([]string{`"a ; b"`, `"c \"d\""`, `e\;f`}) {
+		t.Fatal("TXT is wrong:", jsonutil.MarshalJson(txt.values))
+	}
+	dnsRr, err := ResourceRecordToDnsRr(NewResourceRecord("", "txt", txt.rrType, "", txt.ttl, txt.values), "example.com.", txt.ttl)
+	if err != nil || dnsRr.Name != "txt.example.com." || dnsRr.Data.String() != `"a ; b" "c \"d\"" "e;f"` {
+		t.Fatal("TXT is wrong:", dnsRr, err)
+	}
+	unknown := NewResourceRecord("", "rfc3597", entries[5].rrType, entries[5].class, entries[5].ttl, entries[5].values)
+	if dnsRr, err = ResourceRecordToDnsRr(unknown, "example.com.", entries[5].ttl); err != nil || dnsRr.Type != 65534 {
+		t.Fatal("unknown type is wrong:", dnsRr, err)
+	}
+
+	for _, data := range []string{
+		"$INCLUDE other.zone\n",
+		"@ SOA ( 1 2\n",
+		"@ A 1.1.1.1 )\n",
+		"@ TXT \"abc\n",
+		"@ 300 IN\n",
+		"@ UNKNOWN abc\n",
+	} {
+		if _, err := parseZoneFile([]byte(data)); err == nil {
+			t.Fatal("should fail:", data)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package zonefileutil
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/cpusoft/goutil/belogs"
+	"github.com/cpusoft/goutil/dnsutil"
+	"github.com/cpusoft/goutil/jsonutil"
+	"github.com/cpusoft/goutil/transportutil"
+	"github.com/guregu/null/v6"
+)
+
+// zoneRr is rr in zone file and its dns format
+type zoneRr struct {
+	resourceRecord *ResourceRecord
+	dnsRr          *dnsutil.DnsRr
+}
+
+// ApplyDnsUpdate checks prerequisites and applies updates of UPDATE request to zone, rfc2136 3.
+// All updates are applied or none, and serial of SOA is increased when zone is changed.
+// delResourceRecords/addResourceRecords are changes of zone, SOA is the first when it is changed.
+// err is from dnsutil.NewDnsError, get rCode by dnsutil.GetDnsErrorRCode(err)
+func ApplyDnsUpdate(zoneFileModel *ZoneFileModel, request *dnsutil.DnsMessage) (delResourceRecords,
+	addResourceRecords []*ResourceRecord, err error) {
+	if request == nil {
+		belogs.Error("ApplyDnsUpdate(): request is nil")
+		return nil, nil, newDnsUpdateError(0, dnsutil.DNS_RCODE_FORMERR, "request is nil")
+	}
+	dnsUpdate, err := dnsutil.ParseDnsUpdate(request)
+	if err != nil {
+		belogs.Error("ApplyDnsUpdate(): ParseDnsUpdate fail:", request.Header.Id, err)
+		return nil, nil, newDnsUpdateError(request.Header.Id, dnsutil.DNS_RCODE_FORMERR, err.Error())
+	}
+	if err := checkZoneFileModel(zoneFileModel); err != nil {
+		belogs.Error("ApplyDnsUpdate(): checkZoneFileModel fail:", dnsUpdate.Id, err)
+		return nil, nil, newDnsUpdateError(dnsUpdate.Id, dnsutil.DNS_RCODE_SERVFAIL, err.Error())
+	}
+	// zone section, rfc2136 3.1.2
+	if !dnsutil.EqualDomain(dnsUpdate.Zone.Name, zoneFileModel.Origin) || dnsUpdate.Zone.Class != dnsutil.DNS_CLASS_INT_IN {
+		belogs.Error("ApplyDnsUpdate(): zone is not origin:", dnsUpdate.Id, dnsUpdate.Zone.String(), zoneFileModel.Origin)
+		return nil, nil, newDnsUpdateError(dnsUpdate.Id, dnsutil.DNS_RCODE_NOTAUTH, "zone is not "+zoneFileModel.Origin)
+	}
+	belogs.Debug("ApplyDnsUpdate(): dnsUpdate:", jsonutil.MarshalJson(dnsUpdate))
+
+	zoneFileModel.resourceRecordMutex.Lock()
+	defer zoneFileModel.resourceRecordMutex.Unlock()
+	zoneRrs := make([]*zoneRr, 0, len(zoneFileModel.ResourceRecords))
+	var soa *zoneRr
+	for _, resourceRecord := range zoneFileModel.ResourceRecords {
+		dnsRr, err := ResourceRecordToDnsRr(resourceRecord, zoneFileModel.Origin, zoneFileModel.Ttl)
+		if err != nil {
+			belogs.Error("ApplyDnsUpdate(): ResourceRecordToDnsRr fail:", dnsUpdate.Id, jsonutil.MarshalJson(resourceRecord), err)
+			return nil, nil, newDnsUpdateError(dnsUpdate.Id, dnsutil.DNS_RCODE_SERVFAIL, err.Error())
+		}
+		zr := &zoneRr{resourceRecord: resourceRecord, dnsRr: dnsRr}
+		if _, ok := dnsRr.Data.(*dnsutil.DnsRrDataSoa); ok && soa == nil {
+			soa = zr
+		}
+		zoneRrs = append(zoneRrs, zr)
+	}
+	if soa == nil {
+		belogs.Error("ApplyDnsUpdate(): zone has no SOA:", dnsUpdate.Id, zoneFileModel.Origin)
+		return nil, nil, newDnsUpdateError(dnsUpdate.Id, dnsutil.DNS_RCODE_SERVFAIL, "zone has no SOA")
+	}
+
+	if err = checkDnsUpdatePrerequisites(zoneRrs, dnsUpdate); err != nil {
+		belogs.Info("ApplyDnsUpdate(): checkDnsUpdatePrerequisites fail:", dnsUpdate.Id, err)
+		return nil, nil, err
+	}
+	if err = prescanDnsUpdates(dnsUpdate); err != nil {
+		belogs.Error("ApplyDnsUpdate(): prescanDnsUpdates fail:", dnsUpdate.Id, err)
+		return nil, nil, err
+	}
+	newZoneRrs, err := applyDnsUpdates(zoneRrs, dnsUpdate)
+	if err != nil {
+		belogs.Error("ApplyDnsUpdate(): applyDnsUpdates fail:", dnsUpdate.Id, err)
+		return nil, nil, err
+	}
+	delZoneRrs, addZoneRrs := getZoneRrsDiff(zoneRrs, newZoneRrs)
+	if len(delZoneRrs) == 0 && len(addZoneRrs) == 0 {
+		belogs.Debug("ApplyDnsUpdate(): zone is not changed:", dnsUpdate.Id)
+		return make([]*ResourceRecord, 0), make([]*ResourceRecord, 0), nil
+	}
+
+	// increase serial when SOA is not updated, rfc2136 3.6
+	for i := range newZoneRrs {
+		if newZoneRrs[i] == soa {
+			if newZoneRrs[i], err = increaseSoaSerial(soa, zoneFileModel.Origin); err != nil {
+				belogs.Error("ApplyDnsUpdate(): increaseSoaSerial fail:", dnsUpdate.Id, err)
+				return nil, nil, newDnsUpdateError(dnsUpdate.Id, dnsutil.DNS_RCODE_SERVFAIL, err.Error())
+			}
+			delZoneRrs, addZoneRrs = getZoneRrsDiff(zoneRrs, newZoneRrs)
+			break
+		}
+	}
+
+	resourceRecords := make([]*ResourceRecord, 0, len(newZoneRrs))
+	for _, zr := range newZoneRrs {
+		resourceRecords = append(resourceRecords, zr.resourceRecord)
+	}
+	zoneFileModel.ResourceRecords = resourceRecords
+	delResourceRecords = getResourceRecordsWithTtl(delZoneRrs)
+	addResourceRecords = getResourceRecordsWithTtl(addZoneRrs)
+	belogs.Info("ApplyDnsUpdate(): zone is updated:", dnsUpdate.Id, zoneFileModel.Origin,
+		"  delResourceRecords:", jsonutil.MarshalJson(delResourceRecords),
+		"  addResourceRecords:", jsonutil.MarshalJson(addResourceRecords))
+	return delResourceRecords, addResourceRecords, nil
+}
+
+// checkDnsUpdatePrerequisites: rfc2136 3.2
+func checkDnsUpdatePrerequisites(zoneRrs []*zoneRr, dnsUpdate *dnsutil.DnsUpdate) error {
+	values := make([]*dnsutil.DnsRr, 0)
+	for _, rr := range dnsUpdate.Prerequisites {
+		if rr.Ttl != 0 {
+			return newDnsUpdateError(dnsUpdate.Id, dnsutil.DNS_RCODE_FORMERR, "ttl of prerequisite should be 0: "+rr.String())
+		}
+		if !dnsutil.IsSubDomain(rr.Name, dnsUpdate.Zone.Name) {
+			return newDnsUpdateError(dnsUpdate.Id, dnsutil.DNS_RCODE_NOTZONE, rr.Name+" is not in zone")
+		}
+		switch rr.Class {
+		case dnsutil.DNS_CLASS_INT_ANY:
+			if rr.Data != nil {
+				return newDnsUpdateError(dnsUpdate.Id, dnsutil.DNS_RCODE_FORMERR, "prerequisite should have no rdata: "+rr.String())
+			}
+			if rr.Type == dnsutil.DNS_TYPE_INT_ANY {
+				if !isNameInUse(zoneRrs, rr.Name) {
+					return newDnsUpdateError(dnsUpdate.Id, dnsutil.DNS_RCODE_NXDOMAIN, rr.Name+" is not in use")
+				}
+			} else if len(getRrset(zoneRrs, rr.Name, rr.Type)) == 0 {
+				return newDnsUpdateError(dnsUpdate.Id, dnsutil.DNS_RCODE_NXRRSET, rr.Name+" "+
+					dnsutil.GetDnsTypeStr(rr.Type)+" does not exist")
+			}
+		case dnsutil.DNS_CLASS_INT_NONE:
+			if rr.Data != nil {
+				return newDnsUpdateError(dnsUpdate.Id, dnsutil.DNS_RCODE_FORMERR, "prerequisite should have no rdata: "+rr.String())
+			}
+			if rr.Type == dnsutil.DNS_TYPE_INT_ANY {
+				if isNameInUse(zoneRrs, rr.Name) {
+					return newDnsUpdateError(dnsUpdate.Id, dnsutil.DNS_RCODE_YXDOMAIN, rr.Name+" is in use")
+				}
+			} else if len(getRrset(zoneRrs, rr.Name, rr.Type)) > 0 {
+				return newDnsUpdateError(dnsUpdate.Id, dnsutil.DNS_RCODE_YXRRSET, rr.Name+" "+
+					dnsutil.GetDnsTypeStr(rr.Type)+" exists")
+			}
+		case dnsUpdate.Zone.Class:
+			if rr.Data == nil {
+				return newDnsUpdateError(dnsUpdate.Id, dnsutil.DNS_RCODE_FORMERR, "prerequisite should have rdata: "+rr.String())
+			}
+			values = append(values, rr)
+		default:
+			return newDnsUpdateError(dnsUpdate.Id, dnsutil.DNS_RCODE_FORMERR, "class of prerequisite is invalid: "+rr.String())
+		}
+	}
+
+	// value dependent: rrset in zone should be same as rrset in prerequisites, rfc2136 3.2.5
+	checked := make(map[string]bool)
+	for _, rr := range values {
+		key := dnsutil.FormatDomainFqdn(rr.Name) + "#" + dnsutil.GetDnsTypeStr(rr.Type)
+		if checked[key] {
+			continue
+		}
+		checked[key] = true
+		expected := make([]*dnsutil.DnsRr, 0)
+		for _, v := range values {
+			if dnsutil.EqualDomain(v.Name, rr.Name) && v.Type == rr.Type {
+				expected = append(expected, v)
+			}
+		}
+		rrset := getRrset(zoneRrs, rr.Name, rr.Type)
+		for _, zr := range rrset {
+			if !containsDnsRrData(expected, zr.dnsRr.Data) {
+				return newDnsUpdateError(dnsUpdate.Id, dnsutil.DNS_RCODE_NXRRSET, key+" is not same")
+			}
+		}
+		for _, v := range expected {
+			if !containsZoneRrData(rrset, v.Data) {
+				return newDnsUpdateError(dnsUpdate.Id, dnsutil.DNS_RCODE_NXRRSET, key+" is not same")
+			}
+		}
+	}
+	return nil
+}
+
+// prescanDnsUpdates: rfc2136 3.4.1
+func prescanDnsUpdates(dnsUpdate *dnsutil.DnsUpdate) error {
+	for _, rr := range dnsUpdate.Updates {
+		if !dnsutil.IsSubDomain(rr.Name, dnsUpdate.Zone.Name) {
+			return newDnsUpdateError(dnsUpdate.Id, dnsutil.DNS_RCODE_NOTZONE, rr.Name+" is not in zone")
+		}
+		valid := false
+		switch rr.Class {
+		case dnsUpdate.Zone.Class:
+			valid = rr.Data != nil && rr.Type != dnsutil.DNS_TYPE_INT_ANY && !isMetaType(rr.Type)
+		case dnsutil.DNS_CLASS_INT_ANY:
+			valid = rr.Ttl == 0 && rr.Data == nil && !isMetaType(rr.Type)
+		case dnsutil.DNS_CLASS_INT_NONE:
+			valid = rr.Ttl == 0 && rr.Data != nil && rr.Type != dnsutil.DNS_TYPE_INT_ANY && !isMetaType(rr.Type)
+		}
+		if !valid {
+			return newDnsUpdateError(dnsUpdate.Id, dnsutil.DNS_RCODE_FORMERR, "update is invalid: "+rr.String())
+		}
+	}
+	return nil
+}
+
+// applyDnsUpdates gets new rrs of zone, zoneRrs is not changed, rfc2136 3.4.2
+func applyDnsUpdates(zoneRrs []*zoneRr, dnsUpdate *dnsutil.DnsUpdate) (newZoneRrs []*zoneRr, err error) {
+	origin := dnsUpdate.Zone.Name
+	newZoneRrs = append(make([]*zoneRr, 0, len(zoneRrs)+len(dnsUpdate.Updates)), zoneRrs...)
+	del := func(match func(zr *zoneRr) bool) {
+		kept := make([]*zoneRr, 0, len(newZoneRrs))
+		for _, zr := range newZoneRrs {
+			if !match(zr) {
+				kept = append(kept, zr)
+			}
+		}
+		newZoneRrs = kept
+	}
+	replace := func(oldZr, newZr *zoneRr) {
+		for i := range newZoneRrs {
+			if newZoneRrs[i] == oldZr {
+				newZoneRrs[i] = newZr
+			}
+		}
+	}
+
+	for _, rr := range dnsUpdate.Updates {
+		isApex := dnsutil.EqualDomain(rr.Name, origin)
+		switch rr.Class {
+		case dnsutil.DNS_CLASS_INT_ANY:
+			del(func(zr *zoneRr) bool {
+				if !dnsutil.EqualDomain(zr.dnsRr.Name, rr.Name) {
+					return false
+				}
+				// SOA and NS in apex are not deleted, rfc2136 3.4.2.3
+				if isApex && (zr.dnsRr.Type == dnsutil.DNS_TYPE_INT_SOA || zr.dnsRr.Type == dnsutil.DNS_TYPE_INT_NS) {
+					return false
+				}
+				return rr.Type == dnsutil.DNS_TYPE_INT_ANY || zr.dnsRr.Type == rr.Type
+			})
+		case dnsutil.DNS_CLASS_INT_NONE:
+			// SOA and the last NS in apex are not deleted, rfc2136 3.4.2.4
+			if rr.Type == dnsutil.DNS_TYPE_INT_SOA {
+				continue
+			}
+			rrset := getRrset(newZoneRrs, rr.Name, rr.Type)
+			if isApex && rr.Type == dnsutil.DNS_TYPE_INT_NS && len(rrset) == 1 {
+				continue
+			}
+			del(func(zr *zoneRr) bool {
+				return dnsutil.EqualDomain(zr.dnsRr.Name, rr.Name) && zr.dnsRr.Type == rr.Type &&
+					zr.dnsRr.Class == dnsUpdate.Zone.Class && dnsutil.EqualDnsRrData(zr.dnsRr.Data, rr.Data)
+			})
+		default:
+			resourceRecord, err := DnsRrToResourceRecord(rr, origin)
+			if err != nil {
+				return nil, newDnsUpdateError(dnsUpdate.Id, dnsutil.DNS_RCODE_FORMERR, err.Error())
+			}
+			zr := &zoneRr{resourceRecord: resourceRecord, dnsRr: rr}
+			rrset := getRrset(newZoneRrs, rr.Name, rr.Type)
+
+			// SOA is replaced when serial is greater, rfc2136 3.4.2.2
+			if rr.Type == dnsutil.DNS_TYPE_INT_SOA {
+				if !isApex || len(rrset) == 0 {
+					continue
+				}
+				oldSoa, okOld := rrset[0].dnsRr.Data.(*dnsutil.DnsRrDataSoa)
+				newSoa, okNew := rr.Data.(*dnsutil.DnsRrDataSoa)
+				if okOld && okNew && isSerialGreater(newSoa.Serial, oldSoa.Serial) {
+					replace(rrset[0], zr)
+				}
+				continue
+			}
+			// CNAME can not be with other data, rfc2136 3.4.2.2
+			hasCname, hasOther := false, false
+			for _, other := range newZoneRrs {
+				if dnsutil.EqualDomain(other.dnsRr.Name, rr.Name) {
+					if other.dnsRr.Type == dnsutil.DNS_TYPE_INT_CNAME {
+						hasCname = true
+					} else {
+						hasOther = true
+					}
+				}
+			}
+			if (rr.Type == dnsutil.DNS_TYPE_INT_CNAME && hasOther) || (rr.Type != dnsutil.DNS_TYPE_INT_CNAME && hasCname) {
+				belogs.Debug("applyDnsUpdates(): CNAME and other data, ignore:", rr.String())
+				continue
+			}
+			if rr.Type == dnsutil.DNS_TYPE_INT_CNAME && len(rrset) > 0 {
+				if !dnsutil.EqualDnsRrData(rrset[0].dnsRr.Data, rr.Data) || rrset[0].dnsRr.Ttl != rr.Ttl {
+					replace(rrset[0], zr)
+				}
+				continue
+			}
+			// same rr only updates ttl
+			found := false
+			for _, old := range rrset {
+				if dnsutil.EqualDnsRrData(old.dnsRr.Data, rr.Data) {
+					found = true
+					if old.dnsRr.Ttl != rr.Ttl {
+						replace(old, zr)
+					}
+					break
+				}
+			}
+			if !found {
+				newZoneRrs = append(newZoneRrs, zr)
+			}
+		}
+	}
+	return newZoneRrs, nil
+}
+
+// increaseSoaSerial gets new SOA, serial is added 1 in serial number arithmetic, rfc1982
+func increaseSoaSerial(soa *zoneRr, origin string) (*zoneRr, error) {
+	soaData, ok := soa.dnsRr.Data.(*dnsutil.DnsRrDataSoa)
+	if !ok {
+		return nil, errors.New("rdata of SOA is invalid")
+	}
+	newSoaData := *soaData
+	newSoaData.Serial++
+	newDnsRr := *soa.dnsRr
+	newDnsRr.Data = &newSoaData
+
+	resourceRecord := deepcopyResourceRecord(soa.resourceRecord)
+	if len(resourceRecord.RrValues) == 7 && resourceRecord.RrValues[0] != `\#` {
+		resourceRecord.RrValues[2] = strconv.FormatUint(uint64(newSoaData.Serial), 10)
+	} else {
+		resourceRecord.RrValues = strings.Fields(newSoaData.String())
+	}
+	belogs.Debug("increaseSoaSerial(): origin:", origin, "  serial:", soaData.Serial, "-->", newSoaData.Serial)
+	return &zoneRr{resourceRecord: resourceRecord, dnsRr: &newDnsRr}, nil
+}
+
+// isSerialGreater: s1 > s2 in serial number arithmetic, rfc1982 3.2
+func isSerialGreater(s1, s2 uint32) bool {
+	return int32(s1-s2) > 0
+}
+
+// getZoneRrsDiff: rr is same when it is the same pointer, SOA is the first
+func getZoneRrsDiff(oldZoneRrs, newZoneRrs []*zoneRr) (delZoneRrs, addZoneRrs []*zoneRr) {
+	diff := func(from, to []*zoneRr) []*zoneRr {
+		in := make(map[*zoneRr]bool, len(to))
+		for _, zr := range to {
+			in[zr] = true
+		}
+		result := make([]*zoneRr, 0)
+		for _, zr := range from {
+			if in[zr] {
+				continue
+			}
+			if zr.dnsRr.Type == dnsutil.DNS_TYPE_INT_SOA {
+				result = append([]*zoneRr{zr}, result...)
+			} else {
+				result = append(result, zr)
+			}
+		}
+		return result
+	}
+	return diff(oldZoneRrs, newZoneRrs), diff(newZoneRrs, oldZoneRrs)
+}
+
+// getResourceRecordsWithTtl: null ttl is set as ttl in dnsRr
+func getResourceRecordsWithTtl(zoneRrs []*zoneRr) []*ResourceRecord {
+	resourceRecords := make([]*ResourceRecord, 0, len(zoneRrs))
+	for _, zr := range zoneRrs {
+		resourceRecord := deepcopyResourceRecord(zr.resourceRecord)
+		if resourceRecord.RrTtl.IsZero() {
+			resourceRecord.RrTtl = null.IntFrom(int64(zr.dnsRr.Ttl))
+		}
+		resourceRecords = append(resourceRecords, resourceRecord)
+	}
+	return resourceRecords
+}
+
+// getRrset gets rrs of name and type in class IN
+func getRrset(zoneRrs []*zoneRr, name string, rrType uint16) []*zoneRr {
+	rrset := make([]*zoneRr, 0)
+	for _, zr := range zoneRrs {
+		if zr.dnsRr.Type == rrType && zr.dnsRr.Class == dnsutil.DNS_CLASS_INT_IN && dnsutil.EqualDomain(zr.dnsRr.Name, name) {
+			rrset = append(rrset, zr)
+		}
+	}
+	return rrset
+}
+
+func isNameInUse(zoneRrs []*zoneRr, name string) bool {
+	for _, zr := range zoneRrs {
+		if dnsutil.EqualDomain(zr.dnsRr.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsDnsRrData(rrs []*dnsutil.DnsRr, data dnsutil.DnsRrData) bool {
+	for _, rr := range rrs {
+		if dnsutil.EqualDnsRrData(rr.Data, data) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsZoneRrData(zoneRrs []*zoneRr, data dnsutil.DnsRrData) bool {
+	for _, zr := range zoneRrs {
+		if dnsutil.EqualDnsRrData(zr.dnsRr.Data, data) {
+			return true
+		}
+	}
+	return false
+}
+
+// IXFR, AXFR, MAILA, MAILB and OPT are not in zone
+func isMetaType(rrType uint16) bool {
+	return rrType == dnsutil.DNS_TYPE_INT_IXFR || rrType == dnsutil.DNS_TYPE_INT_AXFR || rrType == dnsutil.DNS_TYPE_INT_MAILA ||
+		rrType == dnsutil.DNS_TYPE_INT_MAILB || rrType == dnsutil.DNS_TYPE_INT_OPT
+}
+
+func newDnsUpdateError(id uint16, rCode uint8, msg string) error {
+	return dnsutil.NewDnsError(msg, id, dnsutil.DNS_OPCODE_UPDATE, rCode, transportutil.NEXT_CONNECT_POLICY_KEEP)
+}
@@ -0,0 +1,161 @@
+package zonefileutil
+
+import (
+	"fmt"
+	"net/netip"
+	"path/filepath"
+	"testing"
+
+	"github.com/cpusoft/goutil/dnsutil"
+	"github.com/cpusoft/goutil/jsonutil"
+)
+
+func getTestSoaSerial(t *testing.T, zf *ZoneFileModel) uint32 {
+	rrs, err := QueryResourceRecords(zf, &ResourceRecord{RrName: "@", RrType: "SOA"})
+	if err != nil || len(rrs) != 1 {
+		t.Fatal("SOA is not found:", err)
+	}
+	dnsRr, err := ResourceRecordToDnsRr(rrs[0], zf.Origin, zf.Ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dnsRr.Data.(*dnsutil.DnsRrDataSoa).Serial
+}
+
+func TestApplyDnsUpdate(t *testing.T) {
+	zf, err := LoadZoneFile("mydomain.com.zone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial := getTestSoaSerial(t, zf)
+	a := &dnsutil.DnsRr{Name: "www2.mydomain.com.", Type: dnsutil.DNS_TYPE_INT_A, Ttl: 600,
+		Data: &dnsutil.DnsRrDataA{Ip: netip.MustParseAddr("192.0.2.2")}}
+
+	// add when name is not in use
+	u := dnsutil.NewDnsUpdate(1, "mydomain.com.")
+	u.AddPrereqNameNotInUse("www2.mydomain.com.")
+	u.AddUpdateAddRr(a)
+	delRrs, addRrs, err := ApplyDnsUpdate(zf, u.GetDnsMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(jsonutil.MarshalJson(delRrs), jsonutil.MarshalJson(addRrs))
+	if len(delRrs) != 1 || delRrs[0].RrType != "SOA" || len(addRrs) != 2 || addRrs[0].RrType != "SOA" ||
+		addRrs[1].RrName != "www2" || addRrs[1].RrTtl.ValueOrZero() != 600 {
+		t.Fatal("changes are wrong")
+	}
+	if getTestSoaSerial(t, zf) != serial+1 {
+		t.Fatal("serial should be increased")
+	}
+
+	// failed update does not change zone
+	before := zf.String()
+	for _, c := range []struct {
+		rCode uint8
+		build func(u *dnsutil.DnsUpdate)
+	}{
+		{dnsutil.DNS_RCODE_YXDOMAIN, func(u *dnsutil.DnsUpdate) { u.AddPrereqNameNotInUse("www2.mydomain.com.") }},
+		{dnsutil.DNS_RCODE_NXDOMAIN, func(u *dnsutil.DnsUpdate) { u.AddPrereqNameInUse("none.mydomain.com.") }},
+		{dnsutil.DNS_RCODE_NXRRSET, func(u *dnsutil.DnsUpdate) { u.AddPrereqRrsetExists("www.mydomain.com.", dnsutil.DNS_TYPE_INT_MX) }},
+		{dnsutil.DNS_RCODE_YXRRSET, func(u *dnsutil.DnsUpdate) { u.AddPrereqRrsetNotExists("www.mydomain.com.", dnsutil.DNS_TYPE_INT_A) }},
+		// value dependent rrset is only a part of MX
+		{dnsutil.DNS_RCODE_NXRRSET, func(u *dnsutil.DnsUpdate) {
+			u.AddPrereqRrsetExistsValue(&dnsutil.DnsRr{Name: "mydomain.com.", Type: dnsutil.DNS_TYPE_INT_MX,
+				Data: &dnsutil.DnsRrDataMx{Preference: 0, Exchange: "mail1.mydomain.com."}})
+		}},
+		{dnsutil.DNS_RCODE_NOTZONE, func(u *dnsutil.DnsUpdate) { u.AddUpdateDelName("www.example.com.") }},
+		// valid update and invalid update are in one message
+		{dnsutil.DNS_RCODE_FORMERR, func(u *dnsutil.DnsUpdate) {
+			u.AddUpdateDelName("www.mydomain.com.")
+			u.AddUpdateAddRr(&dnsutil.DnsRr{Name: "www.mydomain.com.", Type: dnsutil.DNS_TYPE_INT_ANY, Data: a.Data})
+		}},
+	} {
+		u := dnsutil.NewDnsUpdate(2, "mydomain.com.")
+		u.AddUpdateAddRr(a)
+		c.build(u)
+		_, _, err := ApplyDnsUpdate(zf, u.GetDnsMessage())
+		if err == nil || dnsutil.GetDnsErrorRCode(err) != c.rCode {
+			t.Fatal("rCode should be", dnsutil.DnsRCodes[c.rCode], err)
+		}
+		if zf.String() != before {
+			t.Fatal("zone should not be changed:", err)
+		}
+	}
+	u = dnsutil.NewDnsUpdate(3, "example.com.")
+	if _, _, err = ApplyDnsUpdate(zf, u.GetDnsMessage()); dnsutil.GetDnsErrorRCode(err) != dnsutil.DNS_RCODE_NOTAUTH {
+		t.Fatal("other zone should be NOTAUTH:", err)
+	}
+
+	// value dependent rrset is same, then replace www
+	u = dnsutil.NewDnsUpdate(4, "mydomain.com.")
+	for _, mx := range []*dnsutil.DnsRrDataMx{{Preference: 10, Exchange: "MAIL2.mydomain.com."}, {Preference: 0, Exchange: "mail1.mydomain.com."}} {
+		u.AddPrereqRrsetExistsValue(&dnsutil.DnsRr{Name: "mydomain.com.", Type: dnsutil.DNS_TYPE_INT_MX, Data: mx})
+	}
+	u.AddUpdateDelRrset("www.mydomain.com.", dnsutil.DNS_TYPE_INT_A)
+	u.AddUpdateAddRr(&dnsutil.DnsRr{Name: "www.mydomain.com.", Type: dnsutil.DNS_TYPE_INT_TXT, Ttl: 60,
+		Data: &dnsutil.DnsRrDataTxt{Txts: []string{`say "hi"; \ ok`, "\x01"}}})
+	if _, _, err = ApplyDnsUpdate(zf, u.GetDnsMessage()); err != nil {
+		t.Fatal(err)
+	}
+	rrs, _ := QueryResourceRecords(zf, &ResourceRecord{RrName: "www"})
+	if len(rrs) != 1 || rrs[0].RrType != "TXT" || getTestSoaSerial(t, zf) != serial+2 {
+		t.Fatal("www is wrong:", jsonutil.MarshalJson(rrs))
+	}
+
+	// CNAME and other data, SOA and NS in apex, the last NS, all are ignored
+	u = dnsutil.NewDnsUpdate(5, "mydomain.com.")
+	u.AddUpdateAddRr(&dnsutil.DnsRr{Name: "mail1.mydomain.com.", Type: dnsutil.DNS_TYPE_INT_A, Ttl: 60, Data: a.Data})
+	u.AddUpdateAddRr(&dnsutil.DnsRr{Name: "mail.mydomain.com.", Type: dnsutil.DNS_TYPE_INT_CNAME, Ttl: 60,
+		Data: &dnsutil.DnsRrDataHost{Host: "www.mydomain.com."}})
+	u.AddUpdateDelRrset("mydomain.com.", dnsutil.DNS_TYPE_INT_NS)
+	u.AddUpdateDelRr(&dnsutil.DnsRr{Name: "mydomain.com.", Type: dnsutil.DNS_TYPE_INT_SOA, Data: &dnsutil.DnsRrDataSoa{}})
+	delRrs, addRrs, err = ApplyDnsUpdate(zf, u.GetDnsMessage())
+	if err != nil || len(delRrs) != 0 || len(addRrs) != 0 || getTestSoaSerial(t, zf) != serial+2 {
+		t.Fatal("update should be ignored:", jsonutil.MarshalJson(addRrs), err)
+	}
+	u = dnsutil.NewDnsUpdate(6, "mydomain.com.")
+	u.AddUpdateDelName("mydomain.com.")
+	for _, ns := range []string{"ns1.nameserver.net.", "ns2.nameserver.net."} {
+		u.AddUpdateDelRr(&dnsutil.DnsRr{Name: "mydomain.com.", Type: dnsutil.DNS_TYPE_INT_NS, Data: &dnsutil.DnsRrDataHost{Host: ns}})
+	}
+	if _, _, err = ApplyDnsUpdate(zf, u.GetDnsMessage()); err != nil {
+		t.Fatal(err)
+	}
+	rrs, _ = QueryResourceRecords(zf, &ResourceRecord{RrName: "@"})
+	if len(rrs) != 2 || rrs[0].RrType != "SOA" || rrs[1].RrType != "NS" || rrs[1].RrValues[0] != "NS2.NAMESERVER.NET." {
+		t.Fatal("apex is wrong:", jsonutil.MarshalJson(rrs))
+	}
+
+	// SOA with greater serial replaces old one
+	u = dnsutil.NewDnsUpdate(7, "mydomain.com.")
+	u.AddUpdateAddRr(&dnsutil.DnsRr{Name: "mydomain.com.", Type: dnsutil.DNS_TYPE_INT_SOA, Ttl: 3600,
+		Data: &dnsutil.DnsRrDataSoa{MName: "ns2.nameserver.net.", RName: "hostmaster.mydomain.com.", Serial: serial + 100,
+			Refresh: 3600, Retry: 600, Expire: 604800, Minimum: 300}})
+	if _, _, err = ApplyDnsUpdate(zf, u.GetDnsMessage()); err != nil {
+		t.Fatal(err)
+	}
+	if getTestSoaSerial(t, zf) != serial+100 {
+		t.Fatal("SOA should be replaced")
+	}
+
+	// save and load again
+	file := filepath.Join(t.TempDir(), "mydomain.com.zone")
+	if err = SaveZoneFile(zf, file); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(zf.String())
+	loaded, err := LoadZoneFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.ResourceRecords) != len(zf.ResourceRecords) {
+		t.Fatal("count of rrs is wrong:", len(loaded.ResourceRecords), len(zf.ResourceRecords))
+	}
+	for i := range zf.ResourceRecords {
+		left, err1 := ResourceRecordToDnsRr(zf.ResourceRecords[i], zf.Origin, zf.Ttl)
+		right, err2 := ResourceRecordToDnsRr(loaded.ResourceRecords[i], loaded.Origin, loaded.Ttl)
+		if err1 != nil || err2 != nil || left.String() != right.String() || !dnsutil.EqualDnsRrData(left.Data, right.Data) {
+			t.Fatal("rr is wrong after load:", left, right, err1, err2)
+		}
+	}
+}
@@ -1,10 +1,27 @@
 package zonefileutil
 
-/*
+import (
+	"fmt"
+	"testing"
+
+	"github.com/guregu/null/v6"
+)
+
 func TestLoadZoneFile(t *testing.T) {
 	file := `mydomain.com.zone`
 	zf, err := LoadZoneFile(file)
-	fmt.Println("now:\n"+zf.String(), err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("now:\n" + zf.String())
+	// treefrog.ca. and treemonkey.ca. are out of origin
+	if zf.Origin != "mydomain.com." || zf.Ttl.ValueOrZero() != 3600 || len(zf.ResourceRecords) != 15 {
+		t.Fatal("zone file is wrong:", zf.Origin, zf.Ttl, len(zf.ResourceRecords))
+	}
+	test := zf.ResourceRecords[10]
+	if test.RrDomain != "test.mydomain.com." || test.RrName != "test" || test.RrTtl.ValueOrZero() != 300 {
+		t.Fatal("test rr is wrong:", test)
+	}
 
 	//DelResourceRecord(zf, rr)
 	//fmt.Println("del\n"+zf.String(), err)
@@ -12,16 +29,27 @@ func TestLoadZoneFile(t *testing.T) {
 	afterV := []string{"101.228.10.127"}
 	afterR := ResourceRecord{RrName: "test", RrType: "A", RrValues: afterV}
 	newV := []string{"101.228.10.128"}
-	newR := ResourceRecord{RrName: "", RrType: "A", RrTtl: null.IntFrom(600), RrValues: newV}
-	AddResourceRecord(zf, &afterR, &newR)
-	fmt.Println("add\n"+zf.String(), err)
+	newR := ResourceRecord{RrDomain: "test.mydomain.com.", RrName: "", RrType: "A", RrTtl: null.IntFrom(600), RrValues: newV}
+	err = AddResourceRecord(zf, &afterR, &newR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("add\n" + zf.String())
+	if len(zf.ResourceRecords) != 16 || zf.ResourceRecords[11] != &newR ||
+		newR.RrName != "test" {
+		t.Fatal("add is wrong:", len(zf.ResourceRecords), newR)
+	}
 
 	oldV := []string{"101.228.10.127"}
 	oldR := ResourceRecord{RrName: "test", RrType: "A", RrValues: oldV}
 	newV1 := []string{"101.228.10.129"}
-	newR1 := ResourceRecord{RrName: "test", RrType: "A", RrTtl: null.IntFrom(500), RrValues: newV1}
-	UpdateResourceRecord(zf, &oldR, &newR1)
-	fmt.Println("update\n", zf.String(), err)
-
+	newR1 := ResourceRecord{RrDomain: "test.mydomain.com.", RrName: "test", RrType: "A", RrTtl: null.IntFrom(500), RrValues: newV1}
+	err = UpdateResourceRecord(zf, &oldR, &newR1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("update\n", zf.String())
+	if len(zf.ResourceRecords) != 16 || zf.ResourceRecords[10] != &newR1 {
+		t.Fatal("update is wrong:", zf.ResourceRecords[10])
+	}
 }
-*/